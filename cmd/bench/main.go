@@ -0,0 +1,380 @@
+// Package main benchmarks the proxy end to end against an in-process mock Alist.
+//
+//	bench [-size 64] [-n 5] [-entries 500] [-ciphers aesctr,chacha20,rc4md5] [-buffers 64,512,2048] [-v]
+//
+// For every cipher/buffer combination a fresh proxy is built with server.New and
+// pointed at the mock upstream, then download-decrypt (/d), upload-encrypt
+// (WebDAV PUT), PROPFIND rewrite and fs/list rewrite are timed. The results are
+// printed as one table so buffer and cipher choices can be compared directly.
+//
+// The tool runs inside a temporary working directory, so the config file and
+// BoltDB it creates never touch the real installation.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/encryption"
+	"github.com/alist-encrypt-go/internal/server"
+)
+
+const benchPassword = "bench-password"
+
+const (
+	benchFilePath = "/bench/file.bin"
+	benchNamesDir = "/names"
+	// benchFirstName is the decoded name of the first mock listing entry.
+	benchFirstName = "episode-0000.mkv"
+)
+
+type benchOptions struct {
+	sizeMB     int
+	iterations int
+	entries    int
+	ciphers    []string
+	buffersKB  []int
+}
+
+type benchResult struct {
+	cipher   string
+	bufferKB int
+	op       string
+	samples  []time.Duration
+	bytes    int64
+	err      error
+}
+
+func main() {
+	opts := benchOptions{}
+	ciphers := flag.String("ciphers", "aesctr,chacha20,rc4md5", "comma-separated encTypes to compare")
+	buffers := flag.String("buffers", "64,512,2048", "comma-separated streamBufferKb values to compare")
+	flag.IntVar(&opts.sizeMB, "size", 64, "payload size in MB for download/upload")
+	flag.IntVar(&opts.iterations, "n", 5, "iterations per operation")
+	flag.IntVar(&opts.entries, "entries", 500, "directory entries for PROPFIND/fs/list")
+	verbose := flag.Bool("v", false, "keep proxy logs at info level")
+	flag.Parse()
+
+	opts.ciphers = splitList(*ciphers)
+	for _, raw := range splitList(*buffers) {
+		kb, err := strconv.Atoi(raw)
+		if err != nil || kb <= 0 {
+			fmt.Fprintf(os.Stderr, "Error: invalid buffer size %q\n", raw)
+			os.Exit(2)
+		}
+		opts.buffersKB = append(opts.buffersKB, kb)
+	}
+	if opts.sizeMB <= 0 || opts.iterations <= 0 || opts.entries <= 0 || len(opts.ciphers) == 0 || len(opts.buffersKB) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Request tracing prints straight to stdout; keep the table readable by
+	// sending everything except the final report to /dev/null.
+	report := os.Stdout
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+	if *verbose {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+		report = os.Stderr
+	} else if devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+		os.Stdout = devNull
+	}
+
+	workDir, err := os.MkdirTemp("", "alist-encrypt-bench-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(workDir)
+	if err := os.Chdir(workDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	var results []benchResult
+	for _, encType := range opts.ciphers {
+		for _, bufKB := range opts.buffersKB {
+			fmt.Fprintf(os.Stderr, "running cipher=%s buffer=%dKB ...\n", encType, bufKB)
+			results = append(results, runCombination(opts, workDir, encType, bufKB)...)
+		}
+	}
+	printTable(report, results)
+}
+
+// runCombination builds one proxy instance for encType/bufKB and times every
+// operation against it.
+func runCombination(opts benchOptions, workDir, encType string, bufKB int) []benchResult {
+	fail := func(err error) []benchResult {
+		return []benchResult{{cipher: encType, bufferKB: bufKB, op: "setup", err: err}}
+	}
+
+	size := int64(opts.sizeMB) * 1024 * 1024
+	upstream, err := newMockAlist(encType, size, opts.entries)
+	if err != nil {
+		return fail(err)
+	}
+	mock := httptest.NewServer(upstream)
+	defer mock.Close()
+	upstream.baseURL = mock.URL
+
+	mockURL, _ := url.Parse(mock.URL)
+	port, _ := strconv.Atoi(mockURL.Port())
+
+	cfg := config.Get()
+	cfg.DataDir = filepath.Join(workDir, "data-"+encType+"-"+strconv.Itoa(bufKB))
+	cfg.AlistServer.ServerHost = mockURL.Hostname()
+	cfg.AlistServer.ServerPort = port
+	cfg.AlistServer.HTTPS = false
+	cfg.AlistServer.StreamBufferKb = bufKB
+	cfg.AlistServer.EnableListCache = false
+	cfg.AlistServer.PasswdList = []config.PasswdInfo{
+		{Password: benchPassword, EncType: encType, Enable: true, EncPath: []string{"/bench/*"}},
+		{Password: benchPassword, EncType: encType, Enable: true, EncName: true, EncPath: []string{benchNamesDir + "/*"}},
+	}
+
+	srv, err := server.New(cfg)
+	if err != nil {
+		return fail(err)
+	}
+	proxy := httptest.NewServer(srv.Handler())
+	defer func() {
+		proxy.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}()
+
+	client := &http.Client{}
+	payload := make([]byte, size)
+	rand.New(rand.NewSource(1)).Read(payload)
+
+	ops := []struct {
+		name  string
+		bytes int64
+		run   func() error
+	}{
+		{"download-decrypt", size, func() error {
+			return expectBody(client, http.MethodGet, proxy.URL+"/d"+benchFilePath, nil, nil, size, "")
+		}},
+		{"upload-encrypt", size, func() error {
+			headers := http.Header{"Content-Type": {"application/octet-stream"}}
+			return expectBody(client, http.MethodPut, proxy.URL+"/dav/bench/upload.bin", bytes.NewReader(payload), headers, -1, "")
+		}},
+		{"propfind-rewrite", 0, func() error {
+			headers := http.Header{"Depth": {"1"}, "Content-Type": {"application/xml"}}
+			return expectBody(client, "PROPFIND", proxy.URL+"/dav"+benchNamesDir+"/", nil, headers, -1, benchFirstName)
+		}},
+		{"fslist-rewrite", 0, func() error {
+			body := strings.NewReader(`{"path":"` + benchNamesDir + `","page":1,"per_page":0,"refresh":false}`)
+			headers := http.Header{"Content-Type": {"application/json"}}
+			return expectBody(client, http.MethodPost, proxy.URL+"/api/fs/list", body, headers, -1, benchFirstName)
+		}},
+	}
+
+	var results []benchResult
+	for _, op := range ops {
+		res := benchResult{cipher: encType, bufferKB: bufKB, op: op.name, bytes: op.bytes}
+		for i := 0; i < opts.iterations; i++ {
+			start := time.Now()
+			if err := op.run(); err != nil {
+				res.err = err
+				break
+			}
+			res.samples = append(res.samples, time.Since(start))
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// expectBody performs one request and drains the response. want < 0 skips
+// the length check; a non-empty contains must appear in the body, which
+// proves listings were actually decoded rather than passed through.
+func expectBody(client *http.Client, method, target string, body io.Reader, headers http.Header, want int64, contains string) error {
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return err
+	}
+	for k, v := range headers {
+		req.Header[k] = v
+	}
+	if r, ok := body.(*bytes.Reader); ok {
+		req.ContentLength = r.Size()
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	var n int64
+	var found bool
+	if contains != "" {
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		n, found = int64(len(data)), bytes.Contains(data, []byte(contains))
+	} else if n, err = io.Copy(io.Discard, resp.Body); err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s %s: status %d", method, target, resp.StatusCode)
+	}
+	if want >= 0 && n != want {
+		return fmt.Errorf("%s %s: got %d bytes, want %d", method, target, n, want)
+	}
+	if contains != "" && !found {
+		return fmt.Errorf("%s %s: response does not contain %q", method, target, contains)
+	}
+	return nil
+}
+
+func printTable(w io.Writer, results []benchResult) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "cipher\tbuffer\toperation\tavg\tp50\tmax\tthroughput\t")
+	for _, res := range results {
+		if res.err != nil {
+			fmt.Fprintf(tw, "%s\t%dKB\t%s\terror: %v\t\t\t\t\n", res.cipher, res.bufferKB, res.op, res.err)
+			continue
+		}
+		avg, p50, max := summarize(res.samples)
+		throughput := "-"
+		if res.bytes > 0 && avg > 0 {
+			throughput = fmt.Sprintf("%.1f MB/s", float64(res.bytes)/avg.Seconds()/(1024*1024))
+		}
+		fmt.Fprintf(tw, "%s\t%dKB\t%s\t%s\t%s\t%s\t%s\t\n", res.cipher, res.bufferKB, res.op,
+			roundDuration(avg), roundDuration(p50), roundDuration(max), throughput)
+	}
+	tw.Flush()
+}
+
+func summarize(samples []time.Duration) (avg, p50, max time.Duration) {
+	if len(samples) == 0 {
+		return 0, 0, 0
+	}
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	return total / time.Duration(len(sorted)), sorted[len(sorted)/2], sorted[len(sorted)-1]
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	if d > time.Second {
+		return d.Round(time.Millisecond)
+	}
+	return d.Round(10 * time.Microsecond)
+}
+
+func splitList(raw string) []string {
+	var out []string
+	for _, part := range strings.Split(raw, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, part)
+		}
+	}
+	return out
+}
+
+// mockAlist answers just enough of the Alist API and WebDAV surface for the
+// proxy code paths being measured.
+type mockAlist struct {
+	baseURL    string
+	size       int64
+	ciphertext []byte
+	names      []string
+}
+
+func newMockAlist(encType string, size int64, entries int) (*mockAlist, error) {
+	plain := make([]byte, size)
+	rand.New(rand.NewSource(2)).Read(plain)
+	// Look like an MP4 so the proxy's decrypt sniffing accepts random payload.
+	copy(plain, "\x00\x00\x00\x18ftypisom")
+	flow, err := encryption.NewFlowEnc(benchPassword, encType, size)
+	if err != nil {
+		return nil, err
+	}
+	flow.Encrypt(plain)
+
+	converter := encryption.NewFileNameConverter(benchPassword, encType, "")
+	names := make([]string, entries)
+	for i := range names {
+		names[i] = converter.ToRealName(fmt.Sprintf("episode-%04d.mkv", i))
+	}
+	return &mockAlist{size: size, ciphertext: plain, names: names}, nil
+}
+
+func (m *mockAlist) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPut:
+		_, _ = io.Copy(io.Discard, r.Body)
+		if strings.HasPrefix(r.URL.Path, "/dav/") {
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+		m.writeJSON(w, nil)
+	case r.Method == "PROPFIND":
+		m.writePropfind(w, r)
+	case strings.HasPrefix(r.URL.Path, "/d/") || strings.HasPrefix(r.URL.Path, "/dav/"):
+		http.ServeContent(w, r, path.Base(r.URL.Path), time.Time{}, bytes.NewReader(m.ciphertext))
+	case r.URL.Path == "/api/fs/get":
+		m.writeJSON(w, map[string]interface{}{
+			"name":     path.Base(benchFilePath),
+			"size":     m.size,
+			"is_dir":   false,
+			"raw_url":  m.baseURL + "/d" + benchFilePath,
+			"provider": "Local",
+		})
+	case r.URL.Path == "/api/fs/list":
+		content := make([]map[string]interface{}, len(m.names))
+		for i, name := range m.names {
+			content[i] = map[string]interface{}{"name": name, "size": m.size, "is_dir": false}
+		}
+		m.writeJSON(w, map[string]interface{}{"content": content, "total": len(content), "provider": "Local"})
+	default:
+		m.writeJSON(w, nil)
+	}
+}
+
+func (m *mockAlist) writeJSON(w http.ResponseWriter, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "message": "success", "data": data})
+}
+
+func (m *mockAlist) writePropfind(w http.ResponseWriter, r *http.Request) {
+	dir := strings.TrimSuffix(r.URL.Path, "/") + "/"
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?><D:multistatus xmlns:D="DAV:">`)
+	fmt.Fprintf(&b, `<D:response><D:href>%s</D:href><D:propstat><D:prop><D:resourcetype><D:collection/></D:resourcetype></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>`, dir)
+	if r.Header.Get("Depth") != "0" {
+		for _, name := range m.names {
+			fmt.Fprintf(&b, `<D:response><D:href>%s%s</D:href><D:propstat><D:prop><D:displayname>%s</D:displayname><D:getcontentlength>%d</D:getcontentlength><D:resourcetype/></D:prop><D:status>HTTP/1.1 200 OK</D:status></D:propstat></D:response>`,
+				dir, url.PathEscape(name), name, m.size)
+		}
+	}
+	b.WriteString(`</D:multistatus>`)
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	_, _ = io.WriteString(w, b.String())
+}
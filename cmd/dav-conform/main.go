@@ -0,0 +1,139 @@
+// Package main replays the recorded WebDAV client sessions of
+// internal/davconform against the proxy and reports which steps break.
+//
+//	dav-conform [-client rclone,kodi] [-v]
+//
+// Every client runs against a fresh proxy built with server.New and backed
+// by an in-memory fake Alist, so a failure points at the proxy, not at
+// leftovers from another client. It exits 1 when any step fails.
+//
+// The tool runs inside a temporary working directory, so the config file and
+// BoltDB it creates never touch the real installation.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/alist-encrypt-go/internal/davconform"
+)
+
+func main() {
+	clients := flag.String("client", "", "comma-separated clients to run (default: all)")
+	verbose := flag.Bool("v", false, "keep proxy logs at info level and list passing steps")
+	flag.Parse()
+
+	scenarios, err := davconform.Scenarios()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *clients != "" {
+		scenarios, err = selectClients(scenarios, *clients)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+	}
+
+	// Request tracing prints straight to stdout; keep the report readable by
+	// sending everything else to /dev/null.
+	report := os.Stdout
+	zerolog.SetGlobalLevel(zerolog.ErrorLevel)
+	if *verbose {
+		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+		report = os.Stderr
+	} else if devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err == nil {
+		os.Stdout = devNull
+	}
+
+	workDir, err := os.MkdirTemp("", "alist-encrypt-dav-conform-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	defer os.RemoveAll(workDir)
+	if err := os.Chdir(workDir); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	failed := 0
+	var results []davconform.Result
+	for _, s := range scenarios {
+		env, err := davconform.Start(filepath.Join(workDir, "data-"+s.Client))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s: %v\n", s.Client, err)
+			os.Exit(1)
+		}
+		result := davconform.Run(client, env.URL, s)
+		env.Close()
+		if !result.Passed() {
+			failed++
+		}
+		results = append(results, result)
+	}
+	printReport(report, results, *verbose)
+	fmt.Fprintf(report, "%d of %d clients passed\n", len(results)-failed, len(results))
+
+	// Deferred cleanup does not run on os.Exit.
+	if failed > 0 {
+		os.RemoveAll(workDir)
+		os.Exit(1)
+	}
+}
+
+func selectClients(scenarios []davconform.Scenario, list string) ([]davconform.Scenario, error) {
+	byName := make(map[string]davconform.Scenario, len(scenarios))
+	var names []string
+	for _, s := range scenarios {
+		byName[s.Client] = s
+		names = append(names, s.Client)
+	}
+	var out []davconform.Scenario
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		s, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown client %q (have %s)", name, strings.Join(names, ", "))
+		}
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// printReport lists failing steps with their reasons, and passing steps too
+// when verbose.
+func printReport(w io.Writer, results []davconform.Result, verbose bool) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "client\tstep\trequest\tstatus\tresult")
+	for _, res := range results {
+		passed := 0
+		for _, step := range res.Steps {
+			request := step.Method + " " + step.Path
+			if len(step.Failures) == 0 {
+				passed++
+				if verbose {
+					fmt.Fprintf(tw, "%s\t%s\t%s\t%d\tok\n", res.Client, step.Name, request, step.Status)
+				}
+				continue
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\tFAIL: %s\n", res.Client, step.Name, request, step.Status, strings.Join(step.Failures, "; "))
+		}
+		fmt.Fprintf(tw, "%s\t%d/%d steps passed\t\t\t\n", res.Client, passed, len(res.Steps))
+	}
+	tw.Flush()
+}
@@ -97,7 +97,7 @@ type flags struct {
 	input        string
 	output       string
 	stdout       bool   // enc only: write encrypted bytes to stdout without creating a file
-	encType      string // "auto" for dec; "aesctr"/"chacha20"/"rc4md5" for enc
+	encType      string // "auto" for dec; "aesctr"/"chacha20"/"rc4md5"/"aesgcm"/"xchacha20poly1305" for enc
 	encName      bool   // enc only: encrypt filenames
 	suffix       string // enc only: suffix to append
 	workers      int    // parallel workers for batch mode
@@ -602,7 +602,7 @@ func detectEncType(filePath, password string) encryption.EncType {
 // detectEncTypeVerbose returns the encryption type and a human-readable
 // detection method string.  Detection cascade:
 //
-//  1. V2 magic bytes (AECTR2/CHC202/RC4MD2) — 100% reliable
+//  1. V2/V3 magic bytes (AECTR2/CHC202/RC4MD2/AESGCM/XCHAPO) — 100% reliable
 //  2. Filename CRC6 — ~98.4% reliable per algorithm (1/64 false positive)
 //  3. Content file signature — decrypt first 256 bytes, check magic bytes
 //  4. Default to aesctr — uncertain, caller should warn
@@ -624,6 +624,10 @@ func detectEncTypeVerbose(filePath, password string) (encryption.EncType, string
 			return encryption.EncTypeChaCha20, "v2-magic"
 		case "RC4MD2":
 			return encryption.EncTypeRC4MD5, "v2-magic"
+		case "AESGCM":
+			return encryption.EncTypeAESGCM, "v3-magic"
+		case "XCHAPO":
+			return encryption.EncTypeXChaCha20Poly1305, "v3-magic"
 		}
 	}
 
@@ -973,8 +977,8 @@ func parseFlags(command string) *flags {
 	fs.StringVar(&f.output, "o", "", "output path (default: alongside source)")
 	fs.StringVar(&f.output, "output", "", "output path")
 	fs.BoolVar(&f.stdout, "stdout", false, "enc: write encrypted bytes to stdout (single file only)")
-	fs.StringVar(&f.encType, "t", "auto", "algorithm: aesctr (enc default) | chacha20 | rc4md5 | auto (dec default)")
-	fs.StringVar(&f.encType, "type", "auto", "algorithm: aesctr | chacha20 | rc4md5 | auto")
+	fs.StringVar(&f.encType, "t", "auto", "algorithm: aesctr (enc default) | chacha20 | rc4md5 | aesgcm | xchacha20poly1305 | auto (dec default)")
+	fs.StringVar(&f.encType, "type", "auto", "algorithm: aesctr | chacha20 | rc4md5 | aesgcm | xchacha20poly1305 | auto")
 	fs.BoolVar(&f.encName, "n", false, "enc: encrypt filenames")
 	fs.BoolVar(&f.encName, "enc-name", false, "enc: encrypt filenames")
 	fs.StringVar(&f.suffix, "s", ".bin", `enc: suffix (default .bin, "" = none)`)
@@ -1046,7 +1050,7 @@ Encrypt flags:
   -i, --input <path>     Input file or directory (required)
   -o, --output <path>    Output path (default: alongside source)
       --stdout           Stream V2 ciphertext to stdout (single file only)
-  -t, --type <algo>      aesctr (default) | chacha20 | rc4md5
+  -t, --type <algo>      aesctr (default) | chacha20 | rc4md5 | aesgcm | xchacha20poly1305
   -n, --enc-name         Encrypt filenames (matches proxy's ConvertRealNameWithSuffix)
   -s, --suffix <str>     Encrypted suffix (default: .bin, "" = none)
   -w, --workers <n>      Parallel workers for batch (default: NumCPU)
@@ -1058,7 +1062,7 @@ Decrypt flags:
                          Read password from file (recommended for scripts)
   -i, --input <path>     Input file or directory (required)
   -o, --output <path>    Output path (default: alongside source)
-  -t, --type <algo>      auto (default) | aesctr | chacha20 | rc4md5
+  -t, --type <algo>      auto (default) | aesctr | chacha20 | rc4md5 | aesgcm | xchacha20poly1305
   -w, --workers <n>      Parallel workers for batch (default: NumCPU)
   -v, --verbose          Show progress per file
       --log <path>       Write detailed error log (detection, warnings, errors)
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestHistogramQuantiles(t *testing.T) {
+	var h histogram
+	for i := 1; i <= 100; i++ {
+		h.add(time.Duration(i) * time.Millisecond)
+	}
+	for _, tc := range []struct {
+		q    float64
+		want time.Duration
+	}{{0.5, 50 * time.Millisecond}, {0.9, 90 * time.Millisecond}, {0.99, 99 * time.Millisecond}} {
+		got := h.quantile(tc.q)
+		// Buckets are a quarter octave wide.
+		if got < tc.want || float64(got) > float64(tc.want)*1.2 {
+			t.Errorf("quantile(%v) = %s, want %s within one bucket", tc.q, got, tc.want)
+		}
+	}
+	if h.quantile(1) != 100*time.Millisecond || h.max != 100*time.Millisecond {
+		t.Fatalf("quantile(1) = %s, max = %s", h.quantile(1), h.max)
+	}
+	if h.mean() != 50500*time.Microsecond {
+		t.Fatalf("mean = %s", h.mean())
+	}
+}
+
+func TestClassify(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{failf(failNoRange, "x"), failNoRange},
+		{fmt.Errorf("get: %w", context.DeadlineExceeded), failTimeout},
+		{fmt.Errorf("dial: %w", syscall.ECONNREFUSED), failRefused},
+		{fmt.Errorf("read: %w", syscall.ECONNRESET), failReset},
+		{io.ErrUnexpectedEOF, failReset},
+		{errors.New("tls: bad certificate"), failNetwork},
+	} {
+		if got := classify(tc.err); got != tc.want {
+			t.Errorf("classify(%v) = %q, want %q", tc.err, got, tc.want)
+		}
+	}
+}
+
+func TestParseMix(t *testing.T) {
+	mix, err := parseMix("list=4, scrub=10,upload=0")
+	if err != nil || mix[opList] != 4 || mix[opScrub] != 10 || mix[opUpload] != 0 {
+		t.Fatalf("mix=%v err=%v", mix, err)
+	}
+	for _, bad := range []string{"", "list=0", "list", "list=-1", "seek=3"} {
+		if _, err := parseMix(bad); err == nil {
+			t.Errorf("parseMix(%q) accepted", bad)
+		}
+	}
+}
+
+func TestJudgeSoak(t *testing.T) {
+	start := time.Now()
+	var during []healthSample
+	for i := 0; i < 20; i++ {
+		during = append(during, healthSample{at: start.Add(time.Duration(i) * time.Minute), goroutines: 100 + 2*i, fds: 30})
+	}
+	v := judgeSoak(healthSample{goroutines: 40, fds: 12}, healthSample{goroutines: 45, fds: 40}, during, 50, 20)
+	if v.goroutineLeak || !v.fdLeak || v.fdGrowth != 28 {
+		t.Fatalf("verdict %+v", v)
+	}
+	if v.goroutineSlope < 119 || v.goroutineSlope > 121 {
+		t.Fatalf("goroutine slope = %.1f/h, want 120", v.goroutineSlope)
+	}
+	// Servers that do not report fds are not judged on them.
+	if v := judgeSoak(healthSample{}, healthSample{fds: 0}, nil, 50, 20); v.fdLeak {
+		t.Fatal("fd leak without fd readings")
+	}
+}
+
+// fakeProxy answers the requests loadgen makes.
+type fakeProxy struct {
+	mu       sync.Mutex
+	uploads  map[string]bool
+	ranges   int
+	fileSize int64
+}
+
+func (f *fakeProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	reply := func(data interface{}) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "message": "success", "data": data})
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.URL.Path {
+	case "/health":
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"num_goroutine": 10, "num_fd": 8, "mem_alloc_mb": 20})
+	case "/api/fs/list":
+		var req struct{ Path string }
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		items := []map[string]interface{}{{"name": "small.txt", "size": 10}}
+		if req.Path == "/movies" {
+			items = append(items, map[string]interface{}{"name": "season 1", "is_dir": true})
+		} else {
+			items = append(items, map[string]interface{}{"name": "ep 1.mkv", "size": f.fileSize, "sign": "s1"})
+		}
+		reply(map[string]interface{}{"content": items})
+	case "/api/fs/put":
+		n, _ := io.Copy(io.Discard, r.Body)
+		if n != r.ContentLength {
+			http.Error(w, "short upload", http.StatusBadRequest)
+			return
+		}
+		f.uploads[r.Header.Get("File-Path")] = true
+		reply(nil)
+	case "/api/fs/remove":
+		var req struct {
+			Dir   string
+			Names []string
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		for _, name := range req.Names {
+			delete(f.uploads, "%2Fup%2F"+name)
+		}
+		reply(nil)
+	case "/d/movies/season%201/ep%201.mkv", "/d/movies/season 1/ep 1.mkv":
+		if r.URL.Query().Get("sign") != "s1" {
+			http.Error(w, "bad sign", http.StatusForbidden)
+			return
+		}
+		f.ranges++
+		http.ServeContent(w, r, "ep.mkv", time.Time{}, bytes.NewReader(make([]byte, f.fileSize)))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestRunAgainstFakeProxy(t *testing.T) {
+	fake := &fakeProxy{uploads: make(map[string]bool), fileSize: 1 << 20}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+
+	opts := options{
+		target:         srv.URL,
+		dir:            "/movies",
+		uploadDir:      "/up",
+		via:            "d",
+		mix:            map[string]int{opList: 1, opScrub: 3, opUpload: 1},
+		workers:        4,
+		duration:       300 * time.Millisecond,
+		timeout:        5 * time.Second,
+		rangeKB:        64,
+		uploadMB:       1,
+		depth:          2,
+		maxFail:        0,
+		soak:           true,
+		sample:         50 * time.Millisecond,
+		leakGoroutines: 5,
+		leakFDs:        5,
+	}
+	if code := run(context.Background(), opts); code != 0 {
+		t.Fatalf("exit code %d", code)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.ranges == 0 {
+		t.Fatal("no scrub reads reached the proxy")
+	}
+	for name := range fake.uploads {
+		if strings.Contains(name, "loadgen-") {
+			t.Fatalf("upload %s was not cleaned up", name)
+		}
+	}
+}
@@ -0,0 +1,294 @@
+// Package main puts a running proxy under a realistic client mix and
+// reports latency and failures, to check an installation's sizing before
+// relying on it.
+//
+//	loadgen -target http://nas:5344 -token <alist token> -dir /movies
+//	        [-mix list=4,scrub=10,upload=1] [-c 16] [-d 1m]
+//	        [-upload-dir /loadgen] [-soak -d 6h]
+//
+// Three operations run side by side on -c workers, picked by the -mix
+// weights:
+//   - list: fs/list of a directory found under -dir, as a file browser or
+//     media library scan does; a high weight makes a listing storm.
+//   - scrub: a Range read at a random offset of a file under -dir through
+//     /d (or WebDAV with -via dav), the way a player seeks through a video.
+//   - upload: a /api/fs/put of -upload-mb random bytes into -upload-dir.
+//     The files are removed again when the run ends.
+//
+// The report has p50/p90/p99 latencies and a histogram per operation, and
+// failures grouped by class (timeouts, refused or reset connections, HTTP
+// 4xx/5xx, Alist error codes, ignored ranges, short reads).
+//
+// -soak reads /health before the load starts, every -sample while it runs
+// and again -settle after it stops. Goroutines or file descriptors that stay
+// above the idle count by more than -leak-goroutines/-leak-fds are reported
+// as a leak. Soak runs are meant to last hours.
+//
+// The exit code is 1 when the failure rate exceeds -max-fail or a leak was
+// found.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+type options struct {
+	target     string
+	token      string
+	user, pass string
+	dir        string
+	uploadDir  string
+	via        string
+	mix        map[string]int
+	workers    int
+	duration   time.Duration
+	timeout    time.Duration
+	rangeKB    int
+	uploadMB   int
+	depth      int
+	maxFail    float64
+
+	soak           bool
+	sample         time.Duration
+	settle         time.Duration
+	leakGoroutines int
+	leakFDs        int
+}
+
+func main() {
+	opts := options{}
+	mix := flag.String("mix", "list=4,scrub=10,upload=1", "operation weights (list, scrub, upload)")
+	flag.StringVar(&opts.target, "target", "http://127.0.0.1:5344", "proxy base URL")
+	flag.StringVar(&opts.token, "token", os.Getenv("ALIST_TOKEN"), "Alist token for the fs API and /d (default $ALIST_TOKEN)")
+	flag.StringVar(&opts.user, "user", "", "WebDAV user for -via dav")
+	flag.StringVar(&opts.pass, "pass", os.Getenv("LOADGEN_PASS"), "WebDAV password for -via dav (default $LOADGEN_PASS)")
+	flag.StringVar(&opts.dir, "dir", "/", "directory to list and scrub files from")
+	flag.StringVar(&opts.uploadDir, "upload-dir", "/loadgen", "existing directory uploads go to")
+	flag.StringVar(&opts.via, "via", "d", "scrub through d (/d download links) or dav (WebDAV)")
+	flag.IntVar(&opts.workers, "c", 16, "concurrent workers")
+	flag.DurationVar(&opts.duration, "d", time.Minute, "how long to run")
+	flag.DurationVar(&opts.timeout, "timeout", 30*time.Second, "per-request timeout")
+	flag.IntVar(&opts.rangeKB, "range-kb", 2048, "bytes per scrub read, in KB")
+	flag.IntVar(&opts.uploadMB, "upload-mb", 8, "upload size in MB")
+	flag.IntVar(&opts.depth, "depth", 2, "directory levels below -dir to look for files")
+	flag.Float64Var(&opts.maxFail, "max-fail", 0.01, "failure rate above which the exit code is 1")
+	flag.BoolVar(&opts.soak, "soak", false, "watch goroutines and fds on /health for leaks")
+	flag.DurationVar(&opts.sample, "sample", time.Minute, "soak: /health sampling interval")
+	flag.DurationVar(&opts.settle, "settle", 30*time.Second, "soak: idle time after the load before the final reading")
+	flag.IntVar(&opts.leakGoroutines, "leak-goroutines", 50, "soak: goroutines allowed above the idle count")
+	flag.IntVar(&opts.leakFDs, "leak-fds", 20, "soak: open fds allowed above the idle count")
+	flag.Parse()
+
+	var err error
+	if opts.mix, err = parseMix(*mix); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	if opts.workers <= 0 || opts.duration <= 0 || opts.rangeKB <= 0 || opts.uploadMB <= 0 || (opts.via != "d" && opts.via != "dav") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	opts.target = strings.TrimSuffix(opts.target, "/")
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	os.Exit(run(ctx, opts))
+}
+
+// parseMix parses "list=4,scrub=10,upload=1".
+func parseMix(raw string) (map[string]int, error) {
+	mix := make(map[string]int)
+	total := 0
+	for _, part := range strings.Split(raw, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, weight, ok := strings.Cut(part, "=")
+		n, err := strconv.Atoi(weight)
+		if !ok || err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid mix entry %q, want name=weight", part)
+		}
+		switch name {
+		case opList, opScrub, opUpload:
+		default:
+			return nil, fmt.Errorf("unknown operation %q in mix", name)
+		}
+		mix[name] = n
+		total += n
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("mix %q has no weight", raw)
+	}
+	return mix, nil
+}
+
+func run(ctx context.Context, opts options) int {
+	g := &generator{
+		opts:   opts,
+		client: &http.Client{Timeout: opts.timeout},
+		rec:    newRecorder(),
+	}
+	fmt.Fprintf(os.Stderr, "discovering files under %s ...\n", opts.dir)
+	if err := g.discover(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "found %d directories, %d files to scrub\n", len(g.dirs), len(g.files))
+	if opts.mix[opScrub] > 0 && len(g.files) == 0 {
+		fmt.Fprintf(os.Stderr, "Error: no files of at least %d KB under %s to scrub\n", opts.rangeKB, opts.dir)
+		return 1
+	}
+
+	var baseline healthSample
+	if opts.soak {
+		var err error
+		if baseline, err = g.health(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: soak needs /health: %v\n", err)
+			return 1
+		}
+	}
+
+	start := time.Now()
+	loadCtx, cancel := context.WithTimeout(ctx, opts.duration)
+	defer cancel()
+
+	var during []healthSample
+	var samplerDone sync.WaitGroup
+	if opts.soak {
+		samplerDone.Add(1)
+		go func() {
+			defer samplerDone.Done()
+			during = g.sampleHealth(loadCtx)
+		}()
+	}
+
+	var wg sync.WaitGroup
+	var seq atomic.Int64
+	for i := 0; i < opts.workers; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			g.work(loadCtx, ctx, rand.New(rand.NewSource(seed)), &seq)
+		}(time.Now().UnixNano() + int64(i))
+	}
+	progressDone := make(chan struct{})
+	go g.progress(loadCtx, start, progressDone)
+
+	wg.Wait()
+	cancel()
+	<-progressDone
+	samplerDone.Wait()
+	elapsed := time.Since(start)
+
+	g.cleanup(context.WithoutCancel(ctx))
+
+	fmt.Fprintf(os.Stdout, "\n%d workers for %s against %s\n\n", opts.workers, elapsed.Round(time.Second), opts.target)
+	g.rec.printReport(os.Stdout, elapsed)
+
+	code := 0
+	ok, failed := g.rec.totals()
+	if ok+failed > 0 && float64(failed)/float64(ok+failed) > opts.maxFail {
+		fmt.Fprintf(os.Stdout, "\nfailure rate %.2f%% is above %.2f%%\n", 100*float64(failed)/float64(ok+failed), 100*opts.maxFail)
+		code = 1
+	}
+
+	if opts.soak {
+		fmt.Fprintf(os.Stderr, "load stopped, settling for %s ...\n", opts.settle)
+		select {
+		case <-time.After(opts.settle):
+		case <-ctx.Done():
+		}
+		final, err := g.health(context.WithoutCancel(ctx))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: final /health reading: %v\n", err)
+			return 1
+		}
+		v := judgeSoak(baseline, final, during, opts.leakGoroutines, opts.leakFDs)
+		v.print(os.Stdout)
+		if v.goroutineLeak || v.fdLeak {
+			code = 1
+		}
+	}
+	return code
+}
+
+// work runs operations picked by the mix weights until loadCtx ends.
+// Requests run under ctx, so the ones in flight at the end of the run
+// complete and are counted; only an interrupt cuts them short.
+func (g *generator) work(loadCtx, ctx context.Context, rng *rand.Rand, seq *atomic.Int64) {
+	total := 0
+	for _, w := range g.opts.mix {
+		total += w
+	}
+	for loadCtx.Err() == nil {
+		pick := rng.Intn(total)
+		op := opList
+		for _, name := range []string{opList, opScrub, opUpload} {
+			if pick < g.opts.mix[name] {
+				op = name
+				break
+			}
+			pick -= g.opts.mix[name]
+		}
+		start := time.Now()
+		n, err := g.runOp(ctx, op, rng, seq)
+		if ctx.Err() != nil {
+			// Interrupted, not failed by the server.
+			return
+		}
+		g.rec.record(op, time.Since(start), n, err)
+	}
+}
+
+// progress prints a status line every ten seconds.
+func (g *generator) progress(ctx context.Context, start time.Time, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			ok, failed := g.rec.totals()
+			elapsed := time.Since(start)
+			fmt.Fprintf(os.Stderr, "%s  ok=%d failed=%d  %.1f ops/s\n", elapsed.Round(time.Second), ok, failed, float64(ok+failed)/elapsed.Seconds())
+		}
+	}
+}
+
+// sampleHealth reads /health every -sample until ctx ends.
+func (g *generator) sampleHealth(ctx context.Context) []healthSample {
+	var samples []healthSample
+	ticker := time.NewTicker(g.opts.sample)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return samples
+		case <-ticker.C:
+			s, err := g.health(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					fmt.Fprintf(os.Stderr, "health sample failed: %v\n", err)
+				}
+				continue
+			}
+			samples = append(samples, s)
+			fmt.Fprintf(os.Stderr, "health: goroutines=%d fds=%d heap=%dMB\n", s.goroutines, s.fds, s.memMB)
+		}
+	}
+}
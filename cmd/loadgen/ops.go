@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/pathutil"
+)
+
+// Operation names, also the keys of -mix.
+const (
+	opList   = "list"
+	opScrub  = "scrub"
+	opUpload = "upload"
+)
+
+// maxDiscoverDirs bounds the listing done before the run starts.
+const maxDiscoverDirs = 200
+
+type remoteFile struct {
+	path string
+	size int64
+	sign string
+}
+
+type generator struct {
+	opts   options
+	client *http.Client
+	rec    *recorder
+
+	dirs  []string
+	files []remoteFile
+
+	payloadOnce sync.Once
+	payload     []byte
+
+	uploadedMu sync.Mutex
+	uploaded   []string
+}
+
+type listItem struct {
+	Name  string `json:"name"`
+	Size  int64  `json:"size"`
+	IsDir bool   `json:"is_dir"`
+	Sign  string `json:"sign"`
+}
+
+// discover lists -dir and the directories below it, down to -depth, for
+// the list and scrub operations to pick from.
+func (g *generator) discover(ctx context.Context) error {
+	root := pathutil.CleanInput(g.opts.dir)
+	level := []string{root}
+	minSize := int64(g.opts.rangeKB) * 1024
+	for depth := 0; depth <= g.opts.depth && len(level) > 0; depth++ {
+		var next []string
+		for _, dir := range level {
+			if len(g.dirs) >= maxDiscoverDirs {
+				return nil
+			}
+			items, err := g.list(ctx, dir)
+			if err != nil {
+				if dir == root {
+					return fmt.Errorf("list %s: %w", dir, err)
+				}
+				continue
+			}
+			g.dirs = append(g.dirs, dir)
+			for _, item := range items {
+				p := pathutil.Join(dir, item.Name)
+				switch {
+				case item.IsDir:
+					next = append(next, p)
+				case item.Size >= minSize:
+					g.files = append(g.files, remoteFile{path: p, size: item.Size, sign: item.Sign})
+				}
+			}
+		}
+		level = next
+	}
+	return nil
+}
+
+func (g *generator) runOp(ctx context.Context, op string, rng *rand.Rand, seq *atomic.Int64) (int64, error) {
+	switch op {
+	case opList:
+		_, err := g.list(ctx, g.dirs[rng.Intn(len(g.dirs))])
+		return 0, err
+	case opScrub:
+		return g.scrub(ctx, g.files[rng.Intn(len(g.files))], rng)
+	case opUpload:
+		return g.upload(ctx, seq.Add(1))
+	}
+	return 0, fmt.Errorf("unknown operation %q", op)
+}
+
+// list runs one fs/list and returns the entries.
+func (g *generator) list(ctx context.Context, dir string) ([]listItem, error) {
+	var data struct {
+		Content []listItem `json:"content"`
+	}
+	body := map[string]interface{}{"path": dir, "password": "", "page": 1, "per_page": 0, "refresh": false}
+	if err := g.fsCall(ctx, "/api/fs/list", body, &data); err != nil {
+		return nil, err
+	}
+	return data.Content, nil
+}
+
+// scrub reads -range-kb bytes at a random offset of f, like a player
+// seeking.
+func (g *generator) scrub(ctx context.Context, f remoteFile, rng *rand.Rand) (int64, error) {
+	length := int64(g.opts.rangeKB) * 1024
+	offset := rng.Int63n(f.size - length + 1)
+	escaped := (&url.URL{Path: f.path}).EscapedPath()
+
+	target := g.opts.target + "/d" + escaped
+	if g.opts.via == "dav" {
+		target = g.opts.target + "/dav" + escaped
+	} else if f.sign != "" {
+		target += "?sign=" + url.QueryEscape(f.sign)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	if g.opts.via == "dav" {
+		req.SetBasicAuth(g.opts.user, g.opts.pass)
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if class := statusClass(resp.StatusCode); class != "" {
+		return 0, failf(class, "GET %s: status %d", f.path, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, failf(failNoRange, "GET %s: status %d for a range request", f.path, resp.StatusCode)
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	if err != nil {
+		return n, err
+	}
+	if n != length {
+		return n, failf(failShortRead, "GET %s: got %d of %d bytes at %d", f.path, n, length, offset)
+	}
+	return n, nil
+}
+
+// upload puts -upload-mb random bytes into -upload-dir.
+func (g *generator) upload(ctx context.Context, n int64) (int64, error) {
+	g.payloadOnce.Do(func() {
+		g.payload = make([]byte, g.opts.uploadMB*1024*1024)
+		rand.New(rand.NewSource(1)).Read(g.payload)
+	})
+	name := fmt.Sprintf("loadgen-%d-%d.bin", os.Getpid(), n)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, g.opts.target+"/api/fs/put", bytes.NewReader(g.payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", g.opts.token)
+	req.Header.Set("File-Path", pathutil.EscapeFilePath(pathutil.Join(g.opts.uploadDir, name)))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Length", strconv.Itoa(len(g.payload)))
+	req.Header.Set("As-Task", "false")
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if err := decodeFsResponse(resp, "/api/fs/put", nil); err != nil {
+		return 0, err
+	}
+	g.uploadedMu.Lock()
+	g.uploaded = append(g.uploaded, name)
+	g.uploadedMu.Unlock()
+	return int64(len(g.payload)), nil
+}
+
+// cleanup removes the uploaded files.
+func (g *generator) cleanup(ctx context.Context) {
+	g.uploadedMu.Lock()
+	names := g.uploaded
+	g.uploaded = nil
+	g.uploadedMu.Unlock()
+	dir := pathutil.CleanInput(g.opts.uploadDir)
+	for len(names) > 0 {
+		batch := names[:min(len(names), 100)]
+		names = names[len(batch):]
+		body := map[string]interface{}{"dir": dir, "names": batch}
+		if err := g.fsCall(ctx, "/api/fs/remove", body, nil); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: removing uploads from %s: %v\n", dir, err)
+		}
+	}
+}
+
+// fsCall posts body to an Alist fs endpoint and decodes the data field
+// into out.
+func (g *generator) fsCall(ctx context.Context, endpoint string, body, out interface{}) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.opts.target+endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", g.opts.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return decodeFsResponse(resp, endpoint, out)
+}
+
+// decodeFsResponse checks the HTTP status and Alist's code and decodes the
+// data field into out.
+func decodeFsResponse(resp *http.Response, endpoint string, out interface{}) error {
+	if class := statusClass(resp.StatusCode); class != "" {
+		return failf(class, "%s: status %d", endpoint, resp.StatusCode)
+	}
+	var envelope struct {
+		Code    int             `json:"code"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&envelope); err != nil {
+		return failf(failAlist, "%s: decode response: %v", endpoint, err)
+	}
+	if envelope.Code != 200 {
+		return failf(failAlist, "%s: code %d: %s", endpoint, envelope.Code, envelope.Message)
+	}
+	if out != nil && len(envelope.Data) > 0 {
+		if err := json.Unmarshal(envelope.Data, out); err != nil {
+			return failf(failAlist, "%s: decode data: %v", endpoint, err)
+		}
+	}
+	return nil
+}
+
+// health reads the proxy's /health.
+func (g *generator) health(ctx context.Context) (healthSample, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, g.opts.target+"/health", nil)
+	if err != nil {
+		return healthSample{}, err
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return healthSample{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return healthSample{}, fmt.Errorf("/health: status %d", resp.StatusCode)
+	}
+	var h struct {
+		NumGoroutine int    `json:"num_goroutine"`
+		NumFD        int    `json:"num_fd"`
+		MemAlloc     uint64 `json:"mem_alloc_mb"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&h); err != nil {
+		return healthSample{}, err
+	}
+	return healthSample{at: time.Now(), goroutines: h.NumGoroutine, fds: h.NumFD, memMB: h.MemAlloc}, nil
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// Failure classes, so a report says why requests failed rather than only
+// how many did.
+const (
+	failTimeout   = "timeout"
+	failRefused   = "refused"
+	failReset     = "reset"
+	failNetwork   = "network"
+	failHTTP4xx   = "http_4xx"
+	failHTTP5xx   = "http_5xx"
+	failAlist     = "alist_error"
+	failNoRange   = "range_ignored"
+	failShortRead = "short_read"
+)
+
+// opError is a failed operation with its class.
+type opError struct {
+	class string
+	err   error
+}
+
+func (e *opError) Error() string { return e.class + ": " + e.err.Error() }
+
+func (e *opError) Unwrap() error { return e.err }
+
+func failf(class, format string, args ...interface{}) error {
+	return &opError{class: class, err: fmt.Errorf(format, args...)}
+}
+
+// classify returns the failure class of err.
+func classify(err error) string {
+	var opErr *opError
+	if errors.As(err, &opErr) {
+		return opErr.class
+	}
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return failTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return failRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE), errors.Is(err, io.ErrUnexpectedEOF):
+		return failReset
+	}
+	return failNetwork
+}
+
+// statusClass returns the failure class of an HTTP status, or "" for a
+// success.
+func statusClass(status int) string {
+	switch {
+	case status >= 500:
+		return failHTTP5xx
+	case status >= 400:
+		return failHTTP4xx
+	}
+	return ""
+}
+
+// histogram records latencies in quarter-octave buckets: each bucket is
+// about 19% wider than the one before, so percentiles stay within that
+// error from a microsecond to hours without keeping every sample.
+type histogram struct {
+	counts []int64
+	total  int64
+	sum    time.Duration
+	max    time.Duration
+}
+
+const bucketsPerOctave = 4
+
+func bucketOf(d time.Duration) int {
+	us := float64(d) / float64(time.Microsecond)
+	if us < 1 {
+		return 0
+	}
+	return int(math.Log2(us)*bucketsPerOctave) + 1
+}
+
+// bucketUpper returns the largest latency bucket i holds.
+func bucketUpper(i int) time.Duration {
+	if i == 0 {
+		return time.Microsecond
+	}
+	return time.Duration(math.Exp2(float64(i)/bucketsPerOctave) * float64(time.Microsecond))
+}
+
+func (h *histogram) add(d time.Duration) {
+	i := bucketOf(d)
+	for len(h.counts) <= i {
+		h.counts = append(h.counts, 0)
+	}
+	h.counts[i]++
+	h.total++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+}
+
+// quantile returns the upper bound of the bucket holding quantile q.
+func (h *histogram) quantile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := int64(math.Ceil(q * float64(h.total)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank {
+			if upper := bucketUpper(i); upper < h.max {
+				return upper
+			}
+			return h.max
+		}
+	}
+	return h.max
+}
+
+func (h *histogram) mean() time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	return h.sum / time.Duration(h.total)
+}
+
+// opStats collects the outcome of one operation kind.
+type opStats struct {
+	latency  histogram
+	bytes    int64
+	failures map[string]int64
+	lastErr  map[string]string
+}
+
+// recorder is shared by the workers.
+type recorder struct {
+	mu  sync.Mutex
+	ops map[string]*opStats
+}
+
+func newRecorder() *recorder {
+	return &recorder{ops: make(map[string]*opStats)}
+}
+
+func (r *recorder) record(op string, d time.Duration, n int64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.ops[op]
+	if s == nil {
+		s = &opStats{failures: make(map[string]int64), lastErr: make(map[string]string)}
+		r.ops[op] = s
+	}
+	if err != nil {
+		class := classify(err)
+		s.failures[class]++
+		s.lastErr[class] = err.Error()
+		return
+	}
+	s.latency.add(d)
+	s.bytes += n
+}
+
+// totals returns the successful and failed operation counts so far.
+func (r *recorder) totals() (ok, failed int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, s := range r.ops {
+		ok += s.latency.total
+		for _, n := range s.failures {
+			failed += n
+		}
+	}
+	return ok, failed
+}
+
+func (r *recorder) sortedOps() []string {
+	names := make([]string, 0, len(r.ops))
+	for name := range r.ops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// printReport writes the latency table, a histogram per operation and the
+// failures by class.
+func (r *recorder) printReport(w io.Writer, elapsed time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "operation\tok\tfailed\trate\tmean\tp50\tp90\tp99\tmax\tthroughput\t")
+	for _, name := range r.sortedOps() {
+		s := r.ops[name]
+		var failed int64
+		for _, n := range s.failures {
+			failed += n
+		}
+		h := &s.latency
+		throughput := "-"
+		if s.bytes > 0 && elapsed > 0 {
+			throughput = fmt.Sprintf("%.1f MB/s", float64(s.bytes)/elapsed.Seconds()/(1024*1024))
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.1f/s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", name, h.total, failed,
+			float64(h.total+failed)/elapsed.Seconds(), roundDuration(h.mean()), roundDuration(h.quantile(0.5)),
+			roundDuration(h.quantile(0.9)), roundDuration(h.quantile(0.99)), roundDuration(h.max), throughput)
+	}
+	tw.Flush()
+
+	for _, name := range r.sortedOps() {
+		if h := &r.ops[name].latency; h.total > 0 {
+			fmt.Fprintf(w, "\n%s latency\n", name)
+			printHistogram(w, h)
+		}
+	}
+
+	var lines []string
+	for _, name := range r.sortedOps() {
+		s := r.ops[name]
+		for class, n := range s.failures {
+			lines = append(lines, fmt.Sprintf("  %-8s %-14s %6d  last: %s", name, class, n, s.lastErr[class]))
+		}
+	}
+	if len(lines) > 0 {
+		sort.Strings(lines)
+		fmt.Fprintf(w, "\nfailures\n%s\n", strings.Join(lines, "\n"))
+	}
+}
+
+// printHistogram draws one bar per octave between the fastest and slowest
+// sample.
+func printHistogram(w io.Writer, h *histogram) {
+	const width = 40
+	var octaves []int64
+	first := -1
+	for i, n := range h.counts {
+		o := i / bucketsPerOctave
+		for len(octaves) <= o {
+			octaves = append(octaves, 0)
+		}
+		octaves[o] += n
+		if n > 0 && first < 0 {
+			first = o
+		}
+	}
+	var peak int64
+	for _, n := range octaves {
+		peak = max(peak, n)
+	}
+	for o := first; o >= 0 && o < len(octaves); o++ {
+		bar := int(octaves[o] * width / peak)
+		if octaves[o] > 0 && bar == 0 {
+			bar = 1
+		}
+		fmt.Fprintf(w, "  <= %9s %7d %s\n", roundDuration(bucketUpper((o+1)*bucketsPerOctave-1)), octaves[o], strings.Repeat("#", bar))
+	}
+}
+
+func roundDuration(d time.Duration) time.Duration {
+	switch {
+	case d > time.Second:
+		return d.Round(time.Millisecond)
+	case d > time.Millisecond:
+		return d.Round(10 * time.Microsecond)
+	}
+	return d.Round(time.Microsecond)
+}
+
+// healthSample is one /health reading taken by the soak sampler.
+type healthSample struct {
+	at         time.Time
+	goroutines int
+	fds        int
+	memMB      uint64
+}
+
+// soakVerdict compares the idle readings before and after the run, and
+// the trend while the load ran.
+type soakVerdict struct {
+	baseline, final           healthSample
+	goroutineSlope, fdSlope   float64 // per hour, under load
+	goroutineLeak, fdLeak     bool
+	goroutineGrowth, fdGrowth int
+}
+
+// judgeSoak flags a leak when the idle reading after the run exceeds the
+// one before it by more than the allowed slack. Under load the counts go up
+// and down with the requests in flight; only what stays behind once the
+// load is gone counts.
+func judgeSoak(baseline, final healthSample, during []healthSample, slackGoroutines, slackFDs int) soakVerdict {
+	v := soakVerdict{baseline: baseline, final: final}
+	v.goroutineGrowth = final.goroutines - baseline.goroutines
+	v.fdGrowth = final.fds - baseline.fds
+	v.goroutineLeak = v.goroutineGrowth > slackGoroutines
+	v.fdLeak = baseline.fds > 0 && v.fdGrowth > slackFDs
+	v.goroutineSlope = slopePerHour(during, func(s healthSample) float64 { return float64(s.goroutines) })
+	v.fdSlope = slopePerHour(during, func(s healthSample) float64 { return float64(s.fds) })
+	return v
+}
+
+// slopePerHour fits a least-squares line through the samples, skipping the
+// first tenth as warm-up.
+func slopePerHour(samples []healthSample, value func(healthSample) float64) float64 {
+	samples = samples[len(samples)/10:]
+	if len(samples) < 2 {
+		return 0
+	}
+	t0 := samples[0].at
+	var sx, sy, sxx, sxy float64
+	for _, s := range samples {
+		x := s.at.Sub(t0).Hours()
+		y := value(s)
+		sx += x
+		sy += y
+		sxx += x * x
+		sxy += x * y
+	}
+	n := float64(len(samples))
+	den := n*sxx - sx*sx
+	if den == 0 {
+		return 0
+	}
+	return (n*sxy - sx*sy) / den
+}
+
+func (v soakVerdict) print(w io.Writer) {
+	fmt.Fprintf(w, "\nsoak\n")
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "\tidle before\tidle after\tgrowth\ttrend under load\t")
+	fmt.Fprintf(tw, "goroutines\t%d\t%d\t%+d\t%+.1f/h\t\n", v.baseline.goroutines, v.final.goroutines, v.goroutineGrowth, v.goroutineSlope)
+	if v.baseline.fds > 0 {
+		fmt.Fprintf(tw, "open fds\t%d\t%d\t%+d\t%+.1f/h\t\n", v.baseline.fds, v.final.fds, v.fdGrowth, v.fdSlope)
+	} else {
+		fmt.Fprintln(tw, "open fds\t-\t-\t-\t-\t")
+	}
+	fmt.Fprintf(tw, "heap MB\t%d\t%d\t%+d\t\t\n", v.baseline.memMB, v.final.memMB, int64(v.final.memMB)-int64(v.baseline.memMB))
+	tw.Flush()
+	if v.goroutineLeak {
+		fmt.Fprintln(w, "LEAK: goroutines did not return to the idle count")
+	}
+	if v.fdLeak {
+		fmt.Fprintln(w, "LEAK: file descriptors did not return to the idle count")
+	}
+	if v.baseline.fds == 0 {
+		fmt.Fprintln(w, "note: the server does not report open fds (not Linux, or an older version)")
+	}
+}
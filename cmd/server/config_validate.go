@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/proxy"
+)
+
+const alistProbeTimeout = 10 * time.Second
+
+// runConfig implements `server config <command>`.
+func runConfig(args []string) int {
+	if len(args) == 0 || args[0] != "validate" {
+		fmt.Fprintln(os.Stderr, "usage: server config validate [-base dir] [-offline]")
+		return 2
+	}
+	return runConfigValidate(args[1:])
+}
+
+// runConfigValidate implements `server config validate`: it loads the config
+// as the server would, without writing it, and prints every problem found,
+// including an unreachable Alist backend. It exits 1 when there are errors.
+func runConfigValidate(args []string) int {
+	flags := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	base := flags.String("base", "", "folder holding conf/config.json (default: working directory)")
+	offline := flags.Bool("offline", false, "skip probing the Alist backend")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	// Migration notices are noise here; problems are reported below.
+	zerolog.SetGlobalLevel(zerolog.WarnLevel)
+
+	path := config.FilePath(*base)
+	cfg, issues := config.LoadForValidation(path)
+	issues = append(issues, cfg.Validate()...)
+	if !*offline {
+		if issue, ok := probeAlist(cfg); !ok {
+			issues = append(issues, issue)
+		}
+	}
+
+	fmt.Printf("config: %s\n", path)
+	fmt.Printf("alist:  %s\n", cfg.GetAlistURL())
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+	errorsFound := 0
+	for _, issue := range issues {
+		if issue.Severity == config.IssueError {
+			errorsFound++
+		}
+	}
+	fmt.Printf("%d error(s), %d warning(s)\n", errorsFound, len(issues)-errorsFound)
+	if errorsFound > 0 {
+		return 1
+	}
+	return 0
+}
+
+// probeAlist checks that the configured backend answers like Alist.
+func probeAlist(cfg *config.Config) (config.Issue, bool) {
+	target := strings.TrimRight(cfg.GetAlistURL(), "/") + "/api/public/settings"
+	fail := func(format string, args ...interface{}) (config.Issue, bool) {
+		return config.Issue{Severity: config.IssueError, Field: "alistServer", Message: fmt.Sprintf(format, args...)}, false
+	}
+
+	resp, err := proxy.NewHTTPClient(cfg, alistProbeTimeout).Get(target)
+	if err != nil {
+		return fail("cannot reach Alist at %s: %v; check serverHost/serverPort/https (or ALIST_HOST/ALIST_PORT)", cfg.GetAlistURL(), err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		return fail("GET %s answered HTTP %d; is this the Alist address?", target, resp.StatusCode)
+	}
+	var reply struct {
+		Code int `json:"code"`
+	}
+	if err := json.Unmarshal(body, &reply); err != nil || reply.Code != http.StatusOK {
+		return fail("GET %s did not return an Alist API response; is this the Alist address?", target)
+	}
+	return config.Issue{}, true
+}
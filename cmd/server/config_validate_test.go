@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+func probeConfig(t *testing.T, rawURL string) *config.Config {
+	t.Helper()
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, _ := strconv.Atoi(u.Port())
+	cfg := config.DefaultConfig()
+	cfg.AlistServer.ServerHost = u.Hostname()
+	cfg.AlistServer.ServerPort = port
+	return cfg
+}
+
+func TestProbeAlist(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/public/settings", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"code":200,"message":"success","data":{}}`))
+	})
+	alist := httptest.NewServer(mux)
+	defer alist.Close()
+	if issue, ok := probeAlist(probeConfig(t, alist.URL)); !ok {
+		t.Fatalf("alist probe failed: %v", issue)
+	}
+
+	other := httptest.NewServer(http.NotFoundHandler())
+	defer other.Close()
+	if issue, ok := probeAlist(probeConfig(t, other.URL)); ok || !strings.Contains(issue.Message, "HTTP 404") {
+		t.Fatalf("non-alist backend: ok=%v %v", ok, issue)
+	}
+
+	other.Close()
+	if issue, ok := probeAlist(probeConfig(t, other.URL)); ok || !strings.Contains(issue.Message, "cannot reach") {
+		t.Fatalf("closed backend: ok=%v %v", ok, issue)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/alist-encrypt-go/internal/encryption"
+)
+
+// keyFlags select the folder password and encType, as in passwdList.
+type keyFlags struct {
+	password     string
+	passwordFile string
+	encType      string
+}
+
+func (f *keyFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.password, "password", "", "folder password from passwdList")
+	fs.StringVar(&f.passwordFile, "password-file", "", "read the password from this file (one trailing newline is ignored)")
+	fs.StringVar(&f.encType, "type", string(encryption.EncTypeAESCTR), "encType of the folder: aesctr, rc4md5, chacha20, aesgcm or xchacha20poly1305")
+}
+
+// resolve validates the flags and loads the password file.
+func (f *keyFlags) resolve() error {
+	switch encryption.NormalizeEncType(f.encType) {
+	case encryption.EncTypeAESCTR, encryption.EncTypeRC4MD5, encryption.EncTypeChaCha20,
+		encryption.EncTypeAESGCM, encryption.EncTypeXChaCha20Poly1305:
+	default:
+		return fmt.Errorf("unknown -type %q", f.encType)
+	}
+	if f.password != "" && f.passwordFile != "" {
+		return fmt.Errorf("-password and -password-file are mutually exclusive")
+	}
+	if f.passwordFile == "" {
+		if f.password == "" {
+			return fmt.Errorf("-password or -password-file is required")
+		}
+		return nil
+	}
+	data, err := os.ReadFile(f.passwordFile)
+	if err != nil {
+		return fmt.Errorf("read password file: %w", err)
+	}
+	data = bytes.TrimSuffix(data, []byte("\n"))
+	data = bytes.TrimSuffix(data, []byte("\r"))
+	if len(data) == 0 {
+		return fmt.Errorf("password file is empty")
+	}
+	f.password = string(data)
+	return nil
+}
+
+// fileCryptFlags are the options shared by encrypt-file and decrypt-file.
+type fileCryptFlags struct {
+	keyFlags
+	in  string
+	out string
+}
+
+func (f *fileCryptFlags) register(fs *flag.FlagSet) {
+	f.keyFlags.register(fs)
+	fs.StringVar(&f.in, "in", "", "input file")
+	fs.StringVar(&f.out, "out", "", "output file, - for stdout")
+}
+
+func (f *fileCryptFlags) resolve() error {
+	if f.in == "" {
+		return fmt.Errorf("-in is required")
+	}
+	if f.out == "" {
+		return fmt.Errorf("-out is required")
+	}
+	return f.keyFlags.resolve()
+}
+
+// runEncryptFile implements `server encrypt-file`: it encrypts a local file
+// the way the proxy encrypts uploads, so the result can be put on the drive
+// directly.
+func runEncryptFile(args []string) int {
+	fs := flag.NewFlagSet("encrypt-file", flag.ContinueOnError)
+	var f fileCryptFlags
+	f.register(fs)
+	format := fs.Int("format", encryption.ContentVersionV2, "1 writes the headerless FlowEnc layout older readers expect, 2 the current layout with a header (aesgcm/xchacha20poly1305 always use their own format)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if err := f.resolve(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *format != encryption.ContentVersionV1 && *format != encryption.ContentVersionV2 {
+		fmt.Fprintf(os.Stderr, "-format must be 1 or 2\n")
+		return 2
+	}
+
+	in, err := os.Open(f.in)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open input: %v\n", err)
+		return 1
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "stat input: %v\n", err)
+		return 1
+	}
+
+	enc, err := encryption.NewContentEncryptor(f.password, f.encType, info.Size(), *format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "create cipher: %v\n", err)
+		return 1
+	}
+	reader, err := enc.EncryptReader(in, 0)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "create cipher: %v\n", err)
+		return 1
+	}
+	if err := writeFileCryptOutput(f.out, reader); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// runDecryptFile implements `server decrypt-file`: it decrypts a file
+// downloaded straight from the drive. Files with a content header are
+// detected automatically; headerless FlowEnc files derive their keystream
+// from the full ciphertext size, which is the input's size unless -size says
+// otherwise, and a piece cut out of such a file needs -offset as well.
+func runDecryptFile(args []string) int {
+	fs := flag.NewFlagSet("decrypt-file", flag.ContinueOnError)
+	var f fileCryptFlags
+	f.register(fs)
+	size := fs.Int64("size", 0, "size of the complete encrypted file on the drive, for a truncated or partial headerless file")
+	offset := fs.Int64("offset", 0, "position of the input's first byte within the complete headerless file")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if err := f.resolve(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *size < 0 || *offset < 0 {
+		fmt.Fprintf(os.Stderr, "-size and -offset cannot be negative\n")
+		return 2
+	}
+	if *offset > 0 && *size == 0 {
+		fmt.Fprintf(os.Stderr, "-offset needs -size\n")
+		return 2
+	}
+
+	in, err := os.Open(f.in)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open input: %v\n", err)
+		return 1
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "stat input: %v\n", err)
+		return 1
+	}
+
+	encType := encryption.NormalizeEncType(f.encType)
+	var reader io.Reader
+	if *offset > 0 {
+		// A piece from the middle cannot carry a header; it is headerless
+		// FlowEnc by definition.
+		if encryption.IsAEADEncType(string(encType)) {
+			fmt.Fprintf(os.Stderr, "-offset is not supported for %s\n", encType)
+			return 2
+		}
+		cipherImpl, err := encryption.NewCipher(encType, f.password, *size)
+		if err == nil {
+			err = cipherImpl.SetPosition(*offset)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "create cipher: %v\n", err)
+			return 1
+		}
+		reader = cipherImpl.DecryptReader(in)
+	} else {
+		fileSize := info.Size()
+		if *size > 0 {
+			fileSize = *size
+		}
+		reader, _, err = encryption.AutoDecryptReader(f.password, encType, in, fileSize)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "create cipher: %v\n", err)
+			return 1
+		}
+	}
+	if err := writeFileCryptOutput(f.out, reader); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// writeFileCryptOutput copies r to path (or stdout for "-"). A file is
+// written next to its destination first so a failed run never leaves a
+// half-written result under the final name.
+func writeFileCryptOutput(path string, r io.Reader) error {
+	if path == "-" {
+		if _, err := io.Copy(os.Stdout, r); err != nil {
+			return fmt.Errorf("write output: %w", err)
+		}
+		return nil
+	}
+	tmp := path + ".part"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("create output: %w", err)
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		if errors.Is(err, encryption.ErrContentAuthFailed) {
+			return fmt.Errorf("decrypt: %w (wrong password or type?)", err)
+		}
+		return fmt.Errorf("write output: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write output: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("write output: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/certgen"
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+// runGencert implements `server gencert`: it writes a self-signed certificate
+// into the conf dir and points scheme.cert_file / scheme.key_file at it.
+func runGencert(args []string) int {
+	fs := flag.NewFlagSet("gencert", flag.ContinueOnError)
+	hosts := fs.String("hosts", "", "comma separated DNS names / IPs for the certificate SAN (default localhost,127.0.0.1,::1)")
+	days := fs.Int("days", int(certgen.DefaultValidity/(24*time.Hour)), "certificate validity in days")
+	httpsPort := fs.Int("https-port", 0, "enable HTTPS on this port if it is not already enabled (0 keeps the current setting)")
+	force := fs.Bool("force", false, "overwrite an existing certificate configured in scheme")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	cfg := config.LoadFresh()
+	if cfg.Scheme != nil && certgen.FilesExist(cfg.Scheme.CertFile, cfg.Scheme.KeyFile) && !*force {
+		fmt.Fprintf(os.Stderr, "certificate already configured (%s); use -force to replace it\n", cfg.Scheme.CertFile)
+		return 1
+	}
+
+	hostList := certgen.NormalizeHosts([]string{*hosts})
+	certPath, keyPath, err := certgen.WriteFiles(cfg.ConfDir(), certgen.Options{
+		Hosts:    hostList,
+		Validity: time.Duration(*days) * 24 * time.Hour,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "generate certificate: %v\n", err)
+		return 1
+	}
+
+	scheme := *cfg.Scheme
+	scheme.CertFile = certPath
+	scheme.KeyFile = keyPath
+	if len(hostList) > 0 {
+		scheme.SelfSignedHosts = hostList
+	}
+	if *httpsPort > 0 {
+		scheme.HTTPSPort = *httpsPort
+	}
+	if _, err := cfg.UpdateScheme(scheme); err != nil {
+		fmt.Fprintf(os.Stderr, "save config: %v\n", err)
+		return 1
+	}
+
+	fmt.Printf("certificate: %s\nkey:         %s\n", certPath, keyPath)
+	if scheme.HTTPSPort <= 0 {
+		fmt.Println("HTTPS is still disabled; set scheme.https_port or rerun with -https-port")
+	}
+	return 0
+}
+
+// ensureSelfSignedCert generates a certificate on first start when
+// scheme.auto_self_signed is set and HTTPS has no usable certificate yet.
+func ensureSelfSignedCert(cfg *config.Config) {
+	scheme := cfg.Scheme
+	if scheme == nil || !scheme.AutoSelfSigned || !scheme.WantsTLS() {
+		return
+	}
+	if certgen.FilesExist(scheme.CertFile, scheme.KeyFile) {
+		return
+	}
+
+	certPath, keyPath, err := certgen.WriteFiles(cfg.ConfDir(), certgen.Options{Hosts: scheme.SelfSignedHosts})
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to generate self-signed certificate")
+		return
+	}
+	updated := *scheme
+	updated.CertFile = certPath
+	updated.KeyFile = keyPath
+	if _, err := cfg.UpdateScheme(updated); err != nil {
+		log.Warn().Err(err).Msg("Failed to persist self-signed certificate paths")
+	}
+	log.Info().Str("cert", certPath).Msg("Generated self-signed certificate")
+}
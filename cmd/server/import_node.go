@@ -0,0 +1,75 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/dao"
+	"github.com/alist-encrypt-go/internal/nodeimport"
+	"github.com/alist-encrypt-go/internal/storage"
+)
+
+// runImportNode implements `server import-node`: it moves passwdList, the
+// WebDAV servers, the port and the login accounts of a Node.js alist-encrypt
+// installation into this one. Run it while the server is stopped.
+func runImportNode(args []string) int {
+	flags := flag.NewFlagSet("import-node", flag.ContinueOnError)
+	from := flags.String("from", "", "Node.js alist-encrypt folder (or its conf folder)")
+	base := flags.String("base", "", "folder holding this server's conf/ and data/ (default: working directory)")
+	dryRun := flags.Bool("dry-run", false, "only print what would be imported")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *from == "" {
+		fmt.Fprintln(os.Stderr, "-from is required")
+		return 2
+	}
+
+	src, err := nodeimport.Read(*from)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	cfg := config.LoadFromBaseDir(*base)
+	store, err := storage.NewStore(cfg.DataDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "open store: %v\n", err)
+		return 1
+	}
+	defer store.Close()
+	if reason := store.DegradedReason(); reason != "" {
+		fmt.Fprintf(os.Stderr, "store unavailable (%s); stop the server before importing\n", reason)
+		return 1
+	}
+
+	report, err := nodeimport.Apply(src, cfg, dao.NewUserDAO(store), *dryRun)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	verb := "imported"
+	if *dryRun {
+		verb = "would import"
+	}
+	fmt.Printf("%s %d passwdList entries\n", verb, report.PasswdEntries)
+	printImportList(verb+" WebDAV servers (new)", report.WebDAVAdded)
+	printImportList(verb+" WebDAV servers (replacing same id)", report.WebDAVUpdated)
+	if report.Port > 0 {
+		fmt.Printf("%s port %d (takes effect after restart)\n", verb, report.Port)
+	}
+	printImportList(verb+" accounts (new)", report.UsersCreated)
+	printImportList(verb+" accounts (password replaced)", report.UsersUpdated)
+	for _, w := range report.Warnings {
+		fmt.Fprintln(os.Stderr, "warning:", w)
+	}
+	return 0
+}
+
+func printImportList(label string, items []string) {
+	if len(items) > 0 {
+		fmt.Printf("%s: %s\n", label, strings.Join(items, ", "))
+	}
+}
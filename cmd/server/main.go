@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -18,72 +20,127 @@ import (
 )
 
 func main() {
-	// Server restart loop - allows graceful restart when H2C changes
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "gencert":
+			os.Exit(runGencert(os.Args[2:]))
+		case "encrypt-file":
+			os.Exit(runEncryptFile(os.Args[2:]))
+		case "decrypt-file":
+			os.Exit(runDecryptFile(os.Args[2:]))
+		case "names":
+			os.Exit(runNames(os.Args[2:]))
+		case "import-node":
+			os.Exit(runImportNode(os.Args[2:]))
+		case "config":
+			os.Exit(runConfig(os.Args[2:]))
+		}
+	}
+
+	// Server restart loop. A reload or restart starts the next server on the
+	// retained listening sockets first and only then drains the previous
+	// one, so new connections are served throughout.
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	var prev *server.Server
+	var prevTimeout time.Duration
+	var draining sync.WaitGroup
 	for {
 		// Load fresh configuration each loop so API-triggered restarts pick up persisted changes.
 		cfg := config.LoadFresh()
 
 		// Setup logging based on config
 		setupLogging(cfg)
+		ensureSelfSignedCert(cfg)
 
-		trace.ServerLog("server", fmt.Sprintf("Encrypt proxy server starting on port %s", cfg.GetHTTPAddr()))
-		trace.ServerLog("config", fmt.Sprintf("Alist URL: %s, H2C: %t, HTTPS: %t", cfg.GetAlistURL(), cfg.Scheme.EnableH2C, cfg.IsHTTPSEnabled()))
+		var addrs []string
+		https := false
+		for _, l := range cfg.Listeners() {
+			addrs = append(addrs, l.Addr())
+			https = https || l.TLS
+		}
+		trace.ServerLog("server", fmt.Sprintf("Encrypt proxy server starting on %s", strings.Join(addrs, ", ")))
+		trace.ServerLog("config", fmt.Sprintf("Alist URL: %s, H2C: %t, HTTPS: %t", cfg.GetAlistURL(), cfg.Scheme.EnableH2C, https))
 
 		// Create and start server
 		srv, err := server.New(cfg)
 		if err != nil {
 			log.Fatal().Err(err).Msg("Failed to create server")
 		}
+		stopped := make(chan error, 1)
+		go func() { stopped <- srv.Start() }()
+
+		// The new server is up; let the previous one finish its requests.
+		if prev != nil {
+			draining.Add(1)
+			go func(old *server.Server, timeout time.Duration) {
+				defer draining.Done()
+				shutdownServer(old, timeout)
+			}(prev, prevTimeout)
+			prev = nil
+		}
 
-		// Channel to signal restart
+		// Export restart channel for API to trigger restart
 		restartChan := make(chan struct{})
-		shutdownChan := make(chan struct{})
-		doneChan := make(chan struct{})
-
-		// Graceful shutdown handler
-		go func() {
-			sigChan := make(chan os.Signal, 1)
-			signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-			defer signal.Stop(sigChan)
+		restart.SetChan(restartChan)
 
+		// SIGHUP reloads once the config file passes validation.
+		reload := false
+	wait:
+		for {
 			select {
-			case <-sigChan:
+			case sig := <-sigChan:
+				if sig == syscall.SIGHUP {
+					if !reloadConfigValid() {
+						continue
+					}
+					log.Info().Msg("Received SIGHUP, reloading")
+					reload = true
+					break wait
+				}
 				log.Info().Msg("Received shutdown signal")
-				close(shutdownChan)
+				break wait
 			case <-restartChan:
 				log.Info().Msg("Restart requested")
+				reload = true
+				break wait
+			case err := <-stopped:
+				if err != nil {
+					log.Error().Err(err).Msg("Server stopped")
+				}
+				stopped = nil
 			}
-
-			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-			defer cancel()
-
-			if err := srv.Shutdown(ctx); err != nil {
-				log.Error().Err(err).Msg("Error during shutdown")
-			}
-			close(doneChan)
-		}()
-
-		// Export restart channel for API to trigger restart
-		restart.SetChan(restartChan)
-
-		// Start server (blocks until shutdown)
-		if err := srv.Start(); err != nil {
-			log.Error().Err(err).Msg("Server stopped")
 		}
 
-		// Wait for shutdown to complete
-		<-doneChan
-
-		// Check if we should exit or restart
-		select {
-		case <-shutdownChan:
+		if !reload {
+			shutdownServer(srv, 5*time.Second)
+			draining.Wait()
+			server.ReleaseListeners()
 			log.Info().Msg("Server shutdown complete")
 			return
-		default:
-			// Restart - reload config and continue loop
-			log.Info().Msg("Restarting server...")
-			time.Sleep(500 * time.Millisecond) // Brief pause before restart
 		}
+		if restart.ExecRequested() {
+			// exec replaces the process, so everything must finish first.
+			shutdownServer(srv, cfg.DrainTimeout())
+			draining.Wait()
+			log.Info().Msg("Restarting into updated binary...")
+			if err := reexec(); err != nil {
+				log.Error().Err(err).Msg("Failed to exec updated binary, restarting in-process")
+			}
+		} else {
+			prev, prevTimeout = srv, cfg.DrainTimeout()
+		}
+		// Restart - reload config and continue loop
+		log.Info().Msg("Restarting server...")
+	}
+}
+
+// shutdownServer drains srv for at most timeout.
+func shutdownServer(srv *server.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Error().Err(err).Msg("Error during shutdown")
 	}
 }
 
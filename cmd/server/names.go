@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/alist-encrypt-go/internal/encryption"
+)
+
+// nameMapping is one line of `server names` output.
+type nameMapping struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	OK   bool   `json:"ok"`
+}
+
+// runNames implements `server names`: it prints encrypted <-> plain name
+// pairs for names given as arguments, read from stdin (one per line, e.g. a
+// pasted directory listing) or taken from a local folder with -dir.
+func runNames(args []string) int {
+	flags := flag.NewFlagSet("names", flag.ContinueOnError)
+	var key keyFlags
+	key.register(flags)
+	suffix := flags.String("suffix", "", "stored suffix of encrypted names: the folder's encSuffix, or .bin for extPolicy hide")
+	encode := flags.Bool("encode", false, "map plain names to encrypted ones instead of decoding")
+	dir := flags.String("dir", "", "take the names from this local folder")
+	recursive := flags.Bool("r", false, "with -dir, walk subfolders too")
+	asJSON := flags.Bool("json", false, "print a JSON array instead of tab separated lines")
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if err := key.resolve(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if *recursive && *dir == "" {
+		fmt.Fprintln(os.Stderr, "-r needs -dir")
+		return 2
+	}
+
+	var names []string
+	var err error
+	switch {
+	case *dir != "":
+		names, err = listLocalNames(*dir, *recursive)
+	case flags.NArg() > 0:
+		names = flags.Args()
+	default:
+		names, err = readNameLines(os.Stdin)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	mapped := make([]nameMapping, 0, len(names))
+	failed := 0
+	for _, name := range names {
+		m := mapName(key.password, key.encType, *suffix, name, *encode)
+		if !m.OK {
+			failed++
+		}
+		mapped = append(mapped, m)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(mapped); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	} else {
+		out := bufio.NewWriter(os.Stdout)
+		for _, m := range mapped {
+			fmt.Fprintf(out, "%s\t%s\n", m.From, m.To)
+		}
+		if err := out.Flush(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if failed > 0 {
+		fmt.Fprintf(os.Stderr, "%d of %d names could not be decoded with this password and type\n", failed, len(mapped))
+	}
+	return 0
+}
+
+// mapName converts the last element of name and keeps any leading folders.
+// A name that does not decode maps to "" with OK false.
+func mapName(password, encType, suffix, name string, encode bool) nameMapping {
+	dir, base := path.Split(filepath.ToSlash(name))
+	if encode {
+		return nameMapping{From: name, To: dir + encryption.ConvertRealNameWithSuffix(password, encType, base, suffix), OK: true}
+	}
+	shown := encryption.ConvertShowNameWithSuffixOptions(password, encType, base, suffix, false)
+	if strings.HasPrefix(shown, encryption.OrigPrefix) {
+		return nameMapping{From: name}
+	}
+	return nameMapping{From: name, To: dir + shown, OK: true}
+}
+
+// readNameLines reads one name per line, skipping blank lines. Trailing
+// slashes (folders in a listing) are dropped.
+func readNameLines(r io.Reader) ([]string, error) {
+	var names []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(strings.TrimSpace(scanner.Text()), "/")
+		if line != "" {
+			names = append(names, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read names: %w", err)
+	}
+	return names, nil
+}
+
+// listLocalNames returns the files under root, relative to it. Folders are
+// only descended into; their own names are not mapped.
+func listLocalNames(root string, recursive bool) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if p != root && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		names = append(names, filepath.ToSlash(rel))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list %s: %w", root, err)
+	}
+	return names, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/encryption"
+)
+
+func TestMapNameRoundTrip(t *testing.T) {
+	enc := mapName("pw", "aesctr", ".bin", "movies/Oceans (2009).mkv", true)
+	if !enc.OK || !strings.HasPrefix(enc.To, "movies/") || !strings.HasSuffix(enc.To, ".bin") {
+		t.Fatalf("encode: %+v", enc)
+	}
+	dec := mapName("pw", "aesctr", ".bin", enc.To, false)
+	if !dec.OK || dec.To != "movies/Oceans (2009).mkv" {
+		t.Fatalf("decode: %+v", dec)
+	}
+	if wrong := mapName("other", "aesctr", ".bin", enc.To, false); wrong.OK || wrong.To != "" {
+		t.Fatalf("wrong password decoded: %+v", wrong)
+	}
+}
+
+func TestNamesInputs(t *testing.T) {
+	root := t.TempDir()
+	stored := encryption.ConvertRealNameWithSuffix("pw", "aesctr", "a.txt", "")
+	os.MkdirAll(filepath.Join(root, "sub"), 0o755)
+	os.WriteFile(filepath.Join(root, stored), nil, 0o644)
+	os.WriteFile(filepath.Join(root, "sub", stored), nil, 0o644)
+
+	top, err := listLocalNames(root, false)
+	if err != nil || len(top) != 1 || top[0] != stored {
+		t.Fatalf("top level: %v %v", top, err)
+	}
+	all, _ := listLocalNames(root, true)
+	if len(all) != 2 || all[1] != "sub/"+stored {
+		t.Fatalf("recursive: %v", all)
+	}
+
+	lines, err := readNameLines(strings.NewReader("\n" + stored + "\nfolder/\n  \n"))
+	if err != nil || len(lines) != 2 || lines[0] != stored || lines[1] != "folder" {
+		t.Fatalf("lines: %q %v", lines, err)
+	}
+}
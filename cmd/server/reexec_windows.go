@@ -0,0 +1,24 @@
+//go:build windows
+
+package main
+
+import (
+	"os"
+)
+
+// reexec starts the (updated) executable as a new process and exits, since
+// Windows has no exec(2).
+func reexec() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if _, err := os.StartProcess(exe, os.Args, &os.ProcAttr{
+		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr},
+		Env:   os.Environ(),
+	}); err != nil {
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
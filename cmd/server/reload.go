@@ -0,0 +1,19 @@
+package main
+
+import (
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+// reloadConfigValid checks conf/config.json before a SIGHUP reload, the way
+// `config validate -offline` does. With errors the reload is refused and
+// the running server keeps its config, instead of restarting into defaults
+// or a half-applied file.
+func reloadConfigValid() bool {
+	errs := config.FileErrors(config.FilePath(""))
+	for _, issue := range errs {
+		log.Error().Str("issue", issue.String()).Msg("Config reload refused")
+	}
+	return len(errs) == 0
+}
@@ -29,6 +29,21 @@ export const updateUsernameReq = (subForm) => {
     method: 'post'
   })
 }
+// 获取界面偏好
+export const getPreferencesReq = () => {
+  return axiosReq({
+    url: '/enc-api/preferences',
+    method: 'get'
+  })
+}
+// 保存界面偏好（整体替换）
+export const savePreferencesReq = (prefs) => {
+  return axiosReq({
+    url: '/enc-api/preferences',
+    data: prefs,
+    method: 'post'
+  })
+}
 // 获取alist的配置信息
 export const getAlistConfigReq = (subForm) => {
   return axiosReq({
@@ -127,11 +142,21 @@ export const loginReq = (subForm) => {
   })
 }
 
-//退出登录
-export const loginOutReq = () => {
+// 构建信息（含是否启用 OIDC 登录）
+export const getBuildInfoReq = () => {
   return axiosReq({
-    url: '/mock/basis-func/user/loginValid',
-    method: 'post'
+    url: '/enc-api/getBuildInfo',
+    method: 'get'
+  })
+}
+
+//退出登录，all 为 true 时退出所有设备
+export const loginOutReq = (all = false) => {
+  return axiosReq({
+    url: '/enc-api/logout',
+    data: { all },
+    method: 'post',
+    isNotTipErrorMsg: true
   })
 }
 
@@ -143,15 +168,24 @@ export const getSchemeConfigReq = () => {
   })
 }
 
-// 保存服务器scheme配置
-export const saveSchemeConfigReq = (subForm) => {
+// 保存服务器scheme配置；restart 为 false 时只保存，稍后调用 restartServerReq 生效
+export const saveSchemeConfigReq = (subForm, restart = true) => {
   return axiosReq({
     url: '/enc-api/saveSchemeConfig',
+    params: restart ? undefined : { restart: false },
     data: subForm,
     method: 'post'
   })
 }
 
+// 重启服务（先校验配置文件，有错误时不重启）
+export const restartServerReq = () => {
+  return axiosReq({
+    url: '/enc-api/restart',
+    method: 'post'
+  })
+}
+
 export const getProxyDomainDictionaryReq = () => {
   return axiosReq({
     url: '/enc-api/getProxyDomainDictionary',
@@ -43,7 +43,8 @@ export default {
     Home: '',
     Github: '',
     Docs: '',
-    'login out': ''
+    'login out': '',
+    'login out all': ''
   },
 
   //page
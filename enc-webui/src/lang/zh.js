@@ -62,7 +62,8 @@ export default {
     Home: '首页',
     Github: '项目git地址',
     Docs: '官方文档',
-    'login out': '退出登录'
+    'login out': '退出登录',
+    'login out all': '退出所有设备'
   },
   //page
   dashboard: {
@@ -5,11 +5,13 @@ import (
 	"crypto/rand"
 	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strconv"
 	"strings"
@@ -23,6 +25,8 @@ import (
 	"github.com/alist-encrypt-go/internal/proxy"
 	"github.com/alist-encrypt-go/internal/proxydict"
 	"github.com/alist-encrypt-go/internal/storage/mysqlstore"
+	"github.com/alist-encrypt-go/internal/update"
+	"github.com/rs/zerolog/log"
 )
 
 type FileStatsProvider interface {
@@ -50,6 +54,9 @@ type Service struct {
 	webdavStats StatsProvider
 	rangeStats  RangeCompatStatsProvider
 	startTime   time.Time
+	updates     *update.Checker
+	prefsDAO    *dao.PreferencesDAO
+	tokenDAO    *dao.TokenDAO
 }
 
 type Deps struct {
@@ -89,27 +96,171 @@ func New(deps Deps) *Service {
 	}
 }
 
+// SetUpdateChecker attaches the release checker surfaced in UserInfo.
+func (s *Service) SetUpdateChecker(c *update.Checker) {
+	s.updates = c
+}
+
+// SetPreferencesDAO enables per-user UI preferences.
+func (s *Service) SetPreferencesDAO(d *dao.PreferencesDAO) {
+	s.prefsDAO = d
+}
+
+// SetTokenDAO enables logout and revoking tokens on credential changes.
+func (s *Service) SetTokenDAO(d *dao.TokenDAO) {
+	s.tokenDAO = d
+}
+
+// Logout revokes token, or with all every token of its user.
+func (s *Service) Logout(token string, all bool) error {
+	claims, err := s.jwtAuth.ValidateToken(token)
+	if err != nil {
+		return err
+	}
+	if s.tokenDAO == nil {
+		return fmt.Errorf("token store not initialized")
+	}
+	// Tokens issued before IDs were added can only go with the rest.
+	if all || claims.ID == "" || claims.ExpiresAt == nil {
+		return s.tokenDAO.RevokeUser(claims.Username)
+	}
+	return s.tokenDAO.Revoke(claims.ID, claims.ExpiresAt.Time)
+}
+
+// ClientInfo describes where a login came from, shown in the session list.
+type ClientInfo struct {
+	RemoteIP  string
+	UserAgent string
+}
+
+// issueToken creates a management token for username and records its
+// session, without which the token is not accepted.
+func (s *Service) issueToken(username string, client ClientInfo) (string, error) {
+	if s.tokenDAO == nil {
+		return s.jwtAuth.GenerateToken(username)
+	}
+	token, err := s.jwtAuth.GenerateTokenAt(username, s.tokenDAO.IssueTime(username))
+	if err != nil {
+		return "", err
+	}
+	claims, err := s.jwtAuth.ValidateToken(token)
+	if err != nil {
+		return "", err
+	}
+	sess := dao.Session{
+		ID:        claims.ID,
+		Username:  username,
+		IssuedAt:  claims.IssuedAtTime(),
+		ExpiresAt: claims.ExpiresAt.Time,
+		RemoteIP:  client.RemoteIP,
+		UserAgent: client.UserAgent,
+	}
+	if err := s.tokenDAO.RecordSession(sess); err != nil {
+		return "", fmt.Errorf("record session: %w", err)
+	}
+	return token, nil
+}
+
+// Sessions returns the sessions of the user token belongs to, marking the
+// one token itself is.
+func (s *Service) Sessions(token string) ([]map[string]interface{}, error) {
+	claims, err := s.jwtAuth.ValidateToken(token)
+	if err != nil {
+		return nil, err
+	}
+	if s.tokenDAO == nil {
+		return nil, fmt.Errorf("token store not initialized")
+	}
+	sessions := s.tokenDAO.Sessions(claims.Username)
+	out := make([]map[string]interface{}, 0, len(sessions))
+	for _, sess := range sessions {
+		out = append(out, map[string]interface{}{
+			"id":         sess.ID,
+			"issued_at":  sess.IssuedAt,
+			"expires_at": sess.ExpiresAt,
+			"remote_ip":  sess.RemoteIP,
+			"user_agent": sess.UserAgent,
+			"current":    sess.ID == claims.ID,
+		})
+	}
+	return out, nil
+}
+
+// EndSession logs out the session id of the user token belongs to.
+func (s *Service) EndSession(token, id string) error {
+	claims, err := s.jwtAuth.ValidateToken(token)
+	if err != nil {
+		return err
+	}
+	if s.tokenDAO == nil {
+		return fmt.Errorf("token store not initialized")
+	}
+	sess, ok := s.tokenDAO.Session(id)
+	if !ok || sess.Username != claims.Username {
+		return fmt.Errorf("session not found")
+	}
+	return s.tokenDAO.Revoke(sess.ID, sess.ExpiresAt)
+}
+
+// revokeUserTokens logs out every session of username after its credentials
+// changed.
+func (s *Service) revokeUserTokens(username string) {
+	if s.tokenDAO == nil {
+		return
+	}
+	if err := s.tokenDAO.RevokeUser(username); err != nil {
+		log.Warn().Err(err).Str("username", username).Msg("Failed to revoke login tokens")
+	}
+}
+
+// GetPreferences returns the stored UI preferences of username.
+func (s *Service) GetPreferences(username string) (json.RawMessage, error) {
+	if s.prefsDAO == nil {
+		return nil, fmt.Errorf("preferences dao not initialized")
+	}
+	if username == "" {
+		return nil, fmt.Errorf("no login user")
+	}
+	return s.prefsDAO.Get(username)
+}
+
+// SavePreferences replaces the UI preferences of username.
+func (s *Service) SavePreferences(username string, prefs []byte) error {
+	if s.prefsDAO == nil {
+		return fmt.Errorf("preferences dao not initialized")
+	}
+	if username == "" {
+		return fmt.Errorf("no login user")
+	}
+	return s.prefsDAO.Set(username, prefs)
+}
+
 func (s *Service) BuildInfo() map[string]interface{} {
-	return map[string]interface{}{
+	info := map[string]interface{}{
 		"version":          config.Version,
 		"embedded_web_ui":  buildinfo.EmbeddedWebUI(),
 		"management_mode":  buildinfo.ManagementMode(),
 		"default_head_img": DefaultHeadImageURL(),
+		"oidc_login":       s.cfg.OIDCEnabled(),
 	}
+	if s.cfg.OIDCEnabled() {
+		info["oidc_button"] = s.cfg.OIDC.ButtonText
+	}
+	return info
 }
 
 func DefaultHeadImageURL() string {
 	return "/public/logo.png"
 }
 
-func (s *Service) Login(username, password string) (map[string]interface{}, string, error) {
+func (s *Service) Login(username, password string, client ClientInfo) (map[string]interface{}, string, error) {
 	if s.userDAO == nil {
 		return nil, "", fmt.Errorf("user dao not initialized")
 	}
 	if err := s.userDAO.Validate(username, password); err != nil {
 		return nil, "", err
 	}
-	token, err := s.jwtAuth.GenerateToken(username)
+	token, err := s.issueToken(username, client)
 	if err != nil {
 		return nil, "", err
 	}
@@ -119,36 +270,137 @@ func (s *Service) Login(username, password string) (map[string]interface{}, stri
 	}, token, nil
 }
 
-func (s *Service) UserInfo() (map[string]interface{}, error) {
-	username := "admin"
+// LoginExternal signs in a user authenticated by an identity provider,
+// creating or updating their account with role, and returns a management
+// token.
+func (s *Service) LoginExternal(username, provider, role string, client ClientInfo) (map[string]interface{}, string, error) {
+	if s.userDAO == nil {
+		return nil, "", fmt.Errorf("user dao not initialized")
+	}
+	if err := s.userDAO.UpsertExternal(username, provider, role); err != nil {
+		return nil, "", err
+	}
+	token, err := s.issueToken(username, client)
+	if err != nil {
+		return nil, "", err
+	}
+	return map[string]interface{}{
+		"username":   username,
+		"headImgUrl": DefaultHeadImageURL(),
+	}, token, nil
+}
+
+func (s *Service) UserInfo(username string) (map[string]interface{}, error) {
+	role := dao.RoleAdmin
 	if s.userDAO != nil {
-		if user, err := s.userDAO.GetFirstUser(); err == nil && user != nil {
+		if username != "" {
+			if r := s.userDAO.Role(username); r != "" {
+				role = r
+			}
+		} else if user, err := s.userDAO.GetFirstUser(); err == nil && user != nil {
 			username = user.Username
 		}
 	}
-	return map[string]interface{}{
+	if username == "" {
+		username = "admin"
+	}
+	info := map[string]interface{}{
 		"codes": []int{16, 9, 10, 11, 12, 13, 15},
 		"userInfo": map[string]interface{}{
 			"username":   username,
 			"headImgUrl": DefaultHeadImageURL(),
 		},
 		"menuList": []interface{}{},
-		"roles":    []string{"admin"},
+		"roles":    []string{role},
 		"version":  config.Version,
-	}, nil
+	}
+	if s.updates != nil {
+		info["update"] = s.updates.Status()
+	}
+	return info, nil
+}
+
+// UserRole returns the role of a logged-in user, or "" if it is unknown.
+func (s *Service) UserRole(username string) string {
+	if s.userDAO == nil {
+		return ""
+	}
+	return s.userDAO.Role(username)
 }
 
-func (s *Service) UpdatePassword(username, password, newPassword string) error {
+func (s *Service) UpdatePassword(caller, username, password, newPassword string) error {
 	if s.userDAO == nil {
 		return fmt.Errorf("user dao not initialized")
 	}
 	if len(newPassword) < 7 {
 		return fmt.Errorf("password too short, at less 8 digits")
 	}
-	if err := s.userDAO.Validate(username, password); err != nil {
+	// An expired account's legacy hash is no longer trusted, so another
+	// administrator resets it without the old password.
+	err := s.userDAO.Validate(username, password)
+	if errors.Is(err, dao.ErrPasswordExpired) && caller != username && s.userDAO.Role(caller) == dao.RoleAdmin {
+		err = nil
+	}
+	if err != nil {
 		return fmt.Errorf("password error")
 	}
-	return s.userDAO.UpdatePassword(username, newPassword)
+	if err := s.userDAO.UpdatePassword(username, newPassword); err != nil {
+		return err
+	}
+	s.revokeUserTokens(username)
+	return nil
+}
+
+// ExpireLegacyPasswords expires every account still on a legacy SHA256 hash
+// except the caller's, and reports how many legacy hashes remain.
+func (s *Service) ExpireLegacyPasswords(caller string) (map[string]interface{}, error) {
+	if s.userDAO == nil {
+		return nil, fmt.Errorf("user dao not initialized")
+	}
+	expired, err := s.userDAO.ExpireLegacyHashes(caller)
+	if err != nil {
+		return nil, err
+	}
+	for _, username := range expired {
+		s.revokeUserTokens(username)
+	}
+	remaining, err := s.userDAO.CountLegacyHashes()
+	if err != nil {
+		return nil, err
+	}
+	if expired == nil {
+		expired = []string{}
+	}
+	return map[string]interface{}{
+		"expired":          expired,
+		"legacy_remaining": remaining,
+	}, nil
+}
+
+// CreateUser adds a management account with the given role.
+func (s *Service) CreateUser(username, password, role string) error {
+	if s.userDAO == nil {
+		return fmt.Errorf("user dao not initialized")
+	}
+	if len(username) < 3 {
+		return fmt.Errorf("username too short, at least 3 characters")
+	}
+	if len(password) < 7 {
+		return fmt.Errorf("password too short, at less 8 digits")
+	}
+	return s.userDAO.CreateWithRole(username, password, role)
+}
+
+// SetUserRole changes another account's role. Administrators cannot demote
+// themselves, so at least the caller keeps full access.
+func (s *Service) SetUserRole(caller, username, role string) error {
+	if s.userDAO == nil {
+		return fmt.Errorf("user dao not initialized")
+	}
+	if caller == username {
+		return fmt.Errorf("cannot change your own role")
+	}
+	return s.userDAO.SetRole(username, role)
 }
 
 func (s *Service) UpdateUsername(username, password, newUsername string) error {
@@ -164,6 +416,12 @@ func (s *Service) UpdateUsername(username, password, newUsername string) error {
 		}
 		return err
 	}
+	s.revokeUserTokens(username)
+	if s.prefsDAO != nil {
+		if err := s.prefsDAO.Rename(username, newUsername); err != nil {
+			log.Warn().Err(err).Str("username", newUsername).Msg("Failed to move UI preferences after rename")
+		}
+	}
 	return nil
 }
 
@@ -171,11 +429,16 @@ func (s *Service) GetAlistConfig() interface{} {
 	return s.cfg.AlistServer
 }
 
-func (s *Service) SaveAlistConfig(raw map[string]interface{}) error {
+// SaveAlistConfig saves the Alist server config. Operators only change the
+// passwd list and cache settings; the rest is kept.
+func (s *Service) SaveAlistConfig(raw map[string]interface{}, role string) error {
 	if _, hasLegacy := raw["rangeCompatTtlMinutes"]; hasLegacy {
 		return fmt.Errorf("rangeCompatTtlMinutes is deprecated, use rangeReprobeMinutes")
 	}
 	server := config.ParseAlistServerFromMap(raw)
+	if role == dao.RoleOperator {
+		return s.cfg.UpdateAlistServerAsOperator(server)
+	}
 	return s.cfg.UpdateAlistServer(server)
 }
 
@@ -252,8 +515,13 @@ func (s *Service) SaveWebdavConfig(raw map[string]interface{}) error {
 	return s.cfg.AddWebDAVServer(server)
 }
 
-func (s *Service) UpdateWebdavConfig(raw map[string]interface{}) error {
+// UpdateWebdavConfig updates a WebDAV server config. Operators only change
+// its passwd list.
+func (s *Service) UpdateWebdavConfig(raw map[string]interface{}, role string) error {
 	server := config.ParseWebDAVServerFromMap(raw)
+	if role == dao.RoleOperator {
+		return s.cfg.UpdateWebDAVPasswdList(server.ID, server.PasswdList)
+	}
 	return s.cfg.UpdateWebDAVServer(server)
 }
 
@@ -278,6 +546,72 @@ func (s *Service) DecodeFolderName(password, encType, folderNameEnc string) (map
 	}, nil
 }
 
+// IdentifyRequest asks which name dialects stored names decode with: under
+// the passwd rule of Dir, or with Password/EncType/EncSuffix when given.
+type IdentifyRequest struct {
+	Dir       string   `json:"dir"`
+	Names     []string `json:"names"`
+	Password  string   `json:"password"`
+	EncType   string   `json:"encType"`
+	EncSuffix string   `json:"encSuffix"`
+}
+
+// IdentifiedName is the dialects one stored name decodes with.
+type IdentifiedName struct {
+	Name    string                    `json:"name"`
+	Matches []encryption.DialectMatch `json:"matches"`
+}
+
+// IdentifyNames reports the name dialects the given names decode with and
+// suggests the one matching most of them (the earliest listed on a tie).
+func (s *Service) IdentifyNames(req IdentifyRequest) (map[string]interface{}, error) {
+	if len(req.Names) == 0 {
+		return nil, fmt.Errorf("names is empty")
+	}
+	rule := ""
+	current := ""
+	if req.Password == "" {
+		info, ok := s.passwdDAO.FindByDir(req.Dir)
+		if !ok || info == nil {
+			return nil, fmt.Errorf("no passwd rule covers %q; pass password and encType", req.Dir)
+		}
+		req.Password, req.EncType, req.EncSuffix = info.Password, info.EncType, info.NameSuffix()
+		rule, current = info.Describe, info.NameDialect
+		if current == "" {
+			current = "default"
+		}
+	}
+	if req.EncType == "" {
+		req.EncType = "aesctr"
+	}
+
+	counts := make(map[string]int, len(encryption.NameDialects))
+	for _, dialect := range encryption.NameDialects {
+		counts[dialect] = 0
+	}
+	names := make([]IdentifiedName, 0, len(req.Names))
+	for _, name := range req.Names {
+		matches := encryption.DetectNameDialects(req.Password, req.EncType, path.Base(name), req.EncSuffix)
+		for _, m := range matches {
+			counts[m.Dialect]++
+		}
+		names = append(names, IdentifiedName{Name: name, Matches: matches})
+	}
+	suggested := ""
+	for _, dialect := range encryption.NameDialects {
+		if counts[dialect] > 0 && (suggested == "" || counts[dialect] > counts[suggested]) {
+			suggested = dialect
+		}
+	}
+	return map[string]interface{}{
+		"rule":      rule,
+		"current":   current,
+		"names":     names,
+		"counts":    counts,
+		"suggested": suggested,
+	}, nil
+}
+
 func (s *Service) GetSchemeConfig() interface{} {
 	return s.cfg.Scheme
 }
@@ -354,6 +688,14 @@ func (s *Service) SaveProxyRoutingConfig(proxyCfg config.ProxyConfig) error {
 	return s.cfg.UpdateProxy(proxyCfg)
 }
 
+func (s *Service) GetRequestRules() []config.RequestRule {
+	return s.cfg.GetRequestRules()
+}
+
+func (s *Service) SaveRequestRules(rules []config.RequestRule) error {
+	return s.cfg.UpdateRequestRules(rules)
+}
+
 func (s *Service) GetStats() map[string]interface{} {
 	proxyStats := map[string]interface{}{}
 	webdavStats := map[string]interface{}{}
@@ -1,7 +1,11 @@
 package auth
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -12,12 +16,27 @@ var (
 	ErrExpiredToken = errors.New("token expired")
 )
 
-// Claims represents JWT claims
+// Claims represents JWT claims. iat has whole-second precision, so the
+// issue time is also carried in nanoseconds: revoking a user's tokens must
+// catch the ones issued earlier in the same second.
 type Claims struct {
-	Username string `json:"username"`
+	Username     string `json:"username"`
+	IssuedAtNano int64  `json:"iat_ns,omitempty"`
 	jwt.RegisteredClaims
 }
 
+// IssuedAtTime returns when the token was issued, to the nanosecond when
+// the token says so, or the zero time for tokens without iat.
+func (c *Claims) IssuedAtTime() time.Time {
+	if c.IssuedAtNano != 0 {
+		return time.Unix(0, c.IssuedAtNano)
+	}
+	if c.IssuedAt != nil {
+		return c.IssuedAt.Time
+	}
+	return time.Time{}
+}
+
 // JWTAuth handles JWT authentication
 type JWTAuth struct {
 	secret     []byte
@@ -32,13 +51,27 @@ func NewJWTAuth(secret string, expiration time.Duration) *JWTAuth {
 	}
 }
 
-// GenerateToken creates a new JWT token
+// GenerateToken creates a new JWT token. Each token carries a random ID so
+// it can be revoked on its own (logout).
 func (j *JWTAuth) GenerateToken(username string) (string, error) {
+	return j.GenerateTokenAt(username, time.Now())
+}
+
+// GenerateTokenAt creates a new JWT token issued at issuedAt, which callers
+// move past a revocation cutoff so a fresh login is not revoked with the
+// tokens it replaces.
+func (j *JWTAuth) GenerateTokenAt(username string, issuedAt time.Time) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
 	claims := Claims{
-		Username: username,
+		Username:     username,
+		IssuedAtNano: issuedAt.UnixNano(),
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(j.expiration)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
+			ID:        hex.EncodeToString(id),
+			ExpiresAt: jwt.NewNumericDate(issuedAt.Add(j.expiration)),
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
 			Issuer:    "alist-encrypt",
 		},
 	}
@@ -69,3 +102,20 @@ func (j *JWTAuth) ValidateToken(tokenString string) (*Claims, error) {
 
 	return nil, ErrInvalidToken
 }
+
+// TokenFromRequest returns the management token of r: the Authorizetoken
+// header the web UI sends, or an Authorization header with or without the
+// Bearer scheme. Query parameters are not accepted since URLs leak into
+// logs, browser history and referrer headers.
+func TokenFromRequest(r *http.Request) string {
+	if token := strings.TrimSpace(r.Header.Get("Authorizetoken")); token != "" {
+		return token
+	}
+	if authz := strings.TrimSpace(r.Header.Get("Authorization")); authz != "" {
+		if len(authz) >= 7 && strings.EqualFold(authz[:7], "Bearer ") {
+			return strings.TrimSpace(authz[7:])
+		}
+		return authz
+	}
+	return ""
+}
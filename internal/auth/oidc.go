@@ -0,0 +1,373 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	oidcMetadataTTL   = time.Hour
+	oidcKeysMinReload = time.Minute
+	oidcMaxBody       = 1 << 20
+)
+
+var (
+	ErrOIDCNonce      = errors.New("id token nonce does not match")
+	ErrOIDCUnknownKey = errors.New("id token signed with an unknown key")
+)
+
+// oidcMetadata is the part of the discovery document a relying party needs.
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// OIDCProvider is a relying party for one OpenID Connect provider using the
+// authorization code flow with PKCE. The discovery document and signing keys
+// are fetched on first use and cached; an ID token signed with an unknown
+// key reloads the keys, so provider key rotation needs no restart.
+type OIDCProvider struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+
+	client *http.Client
+	now    func() time.Time
+
+	mu     sync.Mutex
+	meta   *oidcMetadata
+	metaAt time.Time
+	keys   map[string]crypto.PublicKey
+	keysAt time.Time
+}
+
+// NewOIDCProvider creates a relying party for issuer. client makes the
+// requests to the provider.
+func NewOIDCProvider(issuer, clientID, clientSecret string, client *http.Client) *OIDCProvider {
+	if client == nil {
+		client = &http.Client{Timeout: 15 * time.Second}
+	}
+	return &OIDCProvider{
+		Issuer:       strings.TrimRight(issuer, "/"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		client:       client,
+		now:          time.Now,
+	}
+}
+
+// NewOIDCSecret returns a random URL-safe value for state, nonce and PKCE
+// verifiers.
+func NewOIDCSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// AuthURL returns the provider URL that starts a login. The PKCE challenge is
+// derived from verifier, which Exchange needs again.
+func (p *OIDCProvider) AuthURL(ctx context.Context, redirectURL, state, nonce, verifier string, scopes []string) (string, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	challenge := sha256.Sum256([]byte(verifier))
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {redirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(meta.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return meta.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange redeems an authorization code and returns the raw ID token.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, redirectURL, verifier string) (string, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURL},
+		"client_id":     {p.ClientID},
+		"code_verifier": {verifier},
+	}
+	if p.ClientSecret != "" {
+		form.Set("client_secret", p.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	var body struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := p.getJSON(req, &body)
+	if err != nil && body.Error == "" {
+		return "", fmt.Errorf("token request: %w", err)
+	}
+	if body.Error != "" {
+		return "", fmt.Errorf("token request: %s %s", body.Error, body.ErrorDescription)
+	}
+	if body.IDToken == "" {
+		return "", fmt.Errorf("token response (HTTP %d) has no id_token", status)
+	}
+	return body.IDToken, nil
+}
+
+// Verify checks an ID token's signature, issuer, audience, expiry and nonce
+// and returns its claims.
+func (p *OIDCProvider) Verify(ctx context.Context, rawIDToken, nonce string) (jwt.MapClaims, error) {
+	meta, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(rawIDToken, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, meta, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(meta.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(time.Minute),
+		jwt.WithTimeFunc(p.now),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if got, _ := claims["nonce"].(string); nonce != "" && got != nonce {
+		return nil, ErrOIDCNonce
+	}
+	return claims, nil
+}
+
+func (p *OIDCProvider) metadata(ctx context.Context) (*oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil && p.now().Sub(p.metaAt) < oidcMetadataTTL {
+		return p.meta, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.Issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return nil, err
+	}
+	var meta oidcMetadata
+	if _, err := p.getJSON(req, &meta); err != nil {
+		if p.meta != nil {
+			// Keep using the previous document while the provider is down.
+			return p.meta, nil
+		}
+		return nil, fmt.Errorf("discovery: %w", err)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, errors.New("discovery: document lacks authorization, token or jwks endpoint")
+	}
+	if strings.TrimRight(meta.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("discovery: issuer %q does not match %q", meta.Issuer, p.Issuer)
+	}
+	p.meta, p.metaAt = &meta, p.now()
+	return p.meta, nil
+}
+
+// key returns the signing key kid, reloading the key set when it is unknown.
+// An empty kid matches the only key of a single-key set.
+func (p *OIDCProvider) key(ctx context.Context, meta *oidcMetadata, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if k := pickKey(p.keys, kid); k != nil {
+		return k, nil
+	}
+	if p.keys != nil && p.now().Sub(p.keysAt) < oidcKeysMinReload {
+		return nil, ErrOIDCUnknownKey
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, meta.JWKSURI, nil)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if _, err := p.getJSON(req, &set); err != nil {
+		return nil, fmt.Errorf("jwks: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		if k, err := jwk.publicKey(); err == nil {
+			keys[jwk.Kid] = k
+		}
+	}
+	p.keys, p.keysAt = keys, p.now()
+	if k := pickKey(keys, kid); k != nil {
+		return k, nil
+	}
+	return nil, ErrOIDCUnknownKey
+}
+
+func pickKey(keys map[string]crypto.PublicKey, kid string) crypto.PublicKey {
+	if k, ok := keys[kid]; ok {
+		return k
+	}
+	if kid == "" && len(keys) == 1 {
+		for _, k := range keys {
+			return k
+		}
+	}
+	return nil
+}
+
+// getJSON runs req and decodes a JSON body into v. Non-2xx answers are
+// decoded too (token errors come as JSON) and reported as an error.
+func (p *OIDCProvider) getJSON(req *http.Request, v interface{}) (int, error) {
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, oidcMaxBody))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	decodeErr := json.Unmarshal(data, v)
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode, fmt.Errorf("%s answered HTTP %d", req.URL.Redacted(), resp.StatusCode)
+	}
+	return resp.StatusCode, decodeErr
+}
+
+// jsonWebKey is an RSA or EC public key from a JWKS document.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jsonWebKey) publicKey() (crypto.PublicKey, error) {
+	num := func(s string) (*big.Int, error) {
+		b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+		if err != nil || len(b) == 0 {
+			return nil, fmt.Errorf("bad key parameter")
+		}
+		return new(big.Int).SetBytes(b), nil
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := num(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := num(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, fmt.Errorf("bad RSA exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := num(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := num(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// ClaimString returns a string claim. name may be a dotted path into nested
+// objects.
+func ClaimString(claims jwt.MapClaims, name string) string {
+	s, _ := claimValue(claims, name).(string)
+	return s
+}
+
+// ClaimStrings returns a list claim such as groups; a single string counts
+// as a list of one. name may be a dotted path, e.g. realm_access.roles for
+// Keycloak realm roles.
+func ClaimStrings(claims jwt.MapClaims, name string) []string {
+	switch v := claimValue(claims, name).(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func claimValue(claims jwt.MapClaims, name string) interface{} {
+	if v, ok := claims[name]; ok {
+		return v
+	}
+	var cur interface{} = map[string]interface{}(claims)
+	for _, part := range strings.Split(name, ".") {
+		obj, ok := cur.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		cur = obj[part]
+	}
+	return cur
+}
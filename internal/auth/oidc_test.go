@@ -0,0 +1,188 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// fakeOIDC is a minimal provider: discovery, a JWKS with the current key and
+// a token endpoint that answers one expected code with id token claims.
+type fakeOIDC struct {
+	srv      *httptest.Server
+	key      *rsa.PrivateKey
+	kid      string
+	jwksHits int
+	code     string
+	verifier string
+	claims   jwt.MapClaims
+}
+
+func newFakeOIDC(t *testing.T) *fakeOIDC {
+	t.Helper()
+	f := &fakeOIDC{kid: "k1"}
+	f.rotate(t, "k1")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 f.srv.URL,
+			"authorization_endpoint": f.srv.URL + "/authorize",
+			"token_endpoint":         f.srv.URL + "/token",
+			"jwks_uri":               f.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		f.jwksHits++
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": f.kid, "use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(f.key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(f.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("code") != f.code || r.Form.Get("code_verifier") != f.verifier {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": f.sign(t, f.claims)})
+	})
+	f.srv = httptest.NewServer(mux)
+	t.Cleanup(f.srv.Close)
+	return f
+}
+
+func (f *fakeOIDC) rotate(t *testing.T, kid string) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.key, f.kid = key, kid
+}
+
+func (f *fakeOIDC) sign(t *testing.T, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = f.kid
+	raw, err := token.SignedString(f.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+func (f *fakeOIDC) idClaims(nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":                f.srv.URL,
+		"aud":                "encrypt",
+		"sub":                "u-1",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"nonce":              nonce,
+		"preferred_username": "alice",
+		"groups":             []string{"media", "homelab-admins"},
+		"realm_access":       map[string]interface{}{"roles": []string{"enc-operator"}},
+	}
+}
+
+func TestOIDCProviderCodeFlow(t *testing.T) {
+	f := newFakeOIDC(t)
+	p := NewOIDCProvider(f.srv.URL+"/", "encrypt", "secret", f.srv.Client())
+	ctx := context.Background()
+
+	authURL, err := p.AuthURL(ctx, "https://enc.example/enc-api/oidc/callback", "st", "n1", "verifier-1", []string{"openid", "groups"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(authURL)
+	sum := sha256.Sum256([]byte("verifier-1"))
+	if q := u.Query(); u.Path != "/authorize" || q.Get("state") != "st" || q.Get("nonce") != "n1" ||
+		q.Get("code_challenge") != base64.RawURLEncoding.EncodeToString(sum[:]) || q.Get("scope") != "openid groups" {
+		t.Fatalf("auth url %s", authURL)
+	}
+
+	f.code, f.verifier, f.claims = "c1", "verifier-1", f.idClaims("n1")
+	raw, err := p.Exchange(ctx, "c1", "https://enc.example/enc-api/oidc/callback", "verifier-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := p.Verify(ctx, raw, "n1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ClaimString(claims, "preferred_username") != "alice" {
+		t.Fatalf("claims %v", claims)
+	}
+	if got := ClaimStrings(claims, "groups"); len(got) != 2 || got[1] != "homelab-admins" {
+		t.Fatalf("groups %v", got)
+	}
+	if got := ClaimStrings(claims, "realm_access.roles"); len(got) != 1 || got[0] != "enc-operator" {
+		t.Fatalf("nested roles %v", got)
+	}
+
+	if _, err := p.Exchange(ctx, "wrong", "https://enc.example/enc-api/oidc/callback", "verifier-1"); err == nil {
+		t.Fatal("bad code accepted")
+	}
+	if _, err := p.Verify(ctx, raw, "n2"); !errors.Is(err, ErrOIDCNonce) {
+		t.Fatalf("nonce mismatch: %v", err)
+	}
+}
+
+func TestOIDCProviderRejectsForeignTokens(t *testing.T) {
+	f := newFakeOIDC(t)
+	p := NewOIDCProvider(f.srv.URL, "encrypt", "", f.srv.Client())
+	ctx := context.Background()
+
+	claims := f.idClaims("n")
+	claims["aud"] = "another-app"
+	if _, err := p.Verify(ctx, f.sign(t, claims), "n"); err == nil {
+		t.Fatal("token for another client accepted")
+	}
+	claims = f.idClaims("n")
+	claims["iss"] = "https://evil.example"
+	if _, err := p.Verify(ctx, f.sign(t, claims), "n"); err == nil {
+		t.Fatal("token from another issuer accepted")
+	}
+	claims = f.idClaims("n")
+	claims["exp"] = time.Now().Add(-time.Hour).Unix()
+	if _, err := p.Verify(ctx, f.sign(t, claims), "n"); err == nil {
+		t.Fatal("expired token accepted")
+	}
+	hmacToken, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, f.idClaims("n")).SignedString([]byte("secret"))
+	if _, err := p.Verify(ctx, hmacToken, "n"); err == nil {
+		t.Fatal("HMAC token accepted")
+	}
+}
+
+func TestOIDCProviderReloadsRotatedKeys(t *testing.T) {
+	f := newFakeOIDC(t)
+	p := NewOIDCProvider(f.srv.URL, "encrypt", "", f.srv.Client())
+	now := time.Now()
+	p.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	if _, err := p.Verify(ctx, f.sign(t, f.idClaims("n")), "n"); err != nil {
+		t.Fatal(err)
+	}
+	f.rotate(t, "k2")
+	// Within a minute of the last load an unknown key is refused without
+	// asking the provider again.
+	if _, err := p.Verify(ctx, f.sign(t, f.idClaims("n")), "n"); err == nil || f.jwksHits != 1 {
+		t.Fatalf("err=%v jwks hits=%d", err, f.jwksHits)
+	}
+	now = now.Add(2 * time.Minute)
+	if _, err := p.Verify(ctx, f.sign(t, f.idClaims("n")), "n"); err != nil || f.jwksHits != 2 {
+		t.Fatalf("after rotation: err=%v jwks hits=%d", err, f.jwksHits)
+	}
+}
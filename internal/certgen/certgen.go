@@ -0,0 +1,151 @@
+// Package certgen creates self-signed TLS certificates for LAN HTTPS setups.
+package certgen
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// CertFileName and KeyFileName are the file names written into the conf dir.
+	CertFileName = "selfsigned.crt"
+	KeyFileName  = "selfsigned.key"
+
+	// DefaultValidity stays under the 825-day limit enforced by Apple clients.
+	DefaultValidity = 825 * 24 * time.Hour
+)
+
+// DefaultHosts are used when no SANs are supplied.
+var DefaultHosts = []string{"localhost", "127.0.0.1", "::1"}
+
+// Options controls certificate generation.
+type Options struct {
+	Hosts    []string // DNS names and/or IP addresses for the SAN extension
+	Validity time.Duration
+	Now      time.Time // zero means time.Now()
+}
+
+// Generate returns PEM encoded certificate and private key (ECDSA P-256).
+func Generate(opts Options) (certPEM, keyPEM []byte, err error) {
+	hosts := NormalizeHosts(opts.Hosts)
+	if len(hosts) == 0 {
+		hosts = DefaultHosts
+	}
+	validity := opts.Validity
+	if validity <= 0 {
+		validity = DefaultValidity
+	}
+	now := opts.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate key: %w", err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate serial: %w", err)
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"alist-encrypt-go"},
+			CommonName:   hosts[0],
+		},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(validity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("create certificate: %w", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshal key: %w", err)
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// WriteFiles generates a certificate and writes it into dir, returning the
+// absolute cert and key paths. The key is written with 0600 permissions.
+func WriteFiles(dir string, opts Options) (certPath, keyPath string, err error) {
+	certPEM, keyPEM, err := Generate(opts)
+	if err != nil {
+		return "", "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", err
+	}
+	if abs, absErr := filepath.Abs(dir); absErr == nil {
+		dir = abs
+	}
+	certPath = filepath.Join(dir, CertFileName)
+	keyPath = filepath.Join(dir, KeyFileName)
+	if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		return "", "", fmt.Errorf("write key: %w", err)
+	}
+	if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+		return "", "", fmt.Errorf("write certificate: %w", err)
+	}
+	return certPath, keyPath, nil
+}
+
+// NormalizeHosts splits comma separated entries, trims blanks and drops duplicates.
+func NormalizeHosts(hosts []string) []string {
+	seen := make(map[string]struct{}, len(hosts))
+	out := make([]string, 0, len(hosts))
+	for _, entry := range hosts {
+		for _, host := range strings.Split(entry, ",") {
+			host = strings.TrimSpace(host)
+			if host == "" {
+				continue
+			}
+			if _, ok := seen[host]; ok {
+				continue
+			}
+			seen[host] = struct{}{}
+			out = append(out, host)
+		}
+	}
+	return out
+}
+
+// FilesExist reports whether both files are present on disk.
+func FilesExist(certPath, keyPath string) bool {
+	if certPath == "" || keyPath == "" {
+		return false
+	}
+	if _, err := os.Stat(certPath); err != nil {
+		return false
+	}
+	_, err := os.Stat(keyPath)
+	return err == nil
+}
@@ -0,0 +1,54 @@
+package certgen
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"runtime"
+	"testing"
+)
+
+func TestGenerateIncludesSANs(t *testing.T) {
+	certPEM, keyPEM, err := Generate(Options{Hosts: []string{"nas.lan, 192.168.1.10", "nas.lan"}})
+	if err != nil {
+		t.Fatalf("generate: %v", err)
+	}
+	if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		t.Fatalf("key pair: %v", err)
+	}
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if len(cert.DNSNames) != 1 || cert.DNSNames[0] != "nas.lan" {
+		t.Fatalf("DNSNames=%v", cert.DNSNames)
+	}
+	if len(cert.IPAddresses) != 1 || cert.IPAddresses[0].String() != "192.168.1.10" {
+		t.Fatalf("IPAddresses=%v", cert.IPAddresses)
+	}
+	if err := cert.VerifyHostname("192.168.1.10"); err != nil {
+		t.Fatalf("verify hostname: %v", err)
+	}
+}
+
+func TestWriteFilesUsesPrivateKeyPermissions(t *testing.T) {
+	certPath, keyPath, err := WriteFiles(t.TempDir(), Options{})
+	if err != nil {
+		t.Fatalf("write files: %v", err)
+	}
+	if !FilesExist(certPath, keyPath) {
+		t.Fatal("expected files to exist")
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+	info, err := os.Stat(keyPath)
+	if err != nil {
+		t.Fatalf("stat key: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm&0077 != 0 {
+		t.Fatalf("key perm=%o, want owner-only", perm)
+	}
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AccessWindow is one entry of PasswdInfo.AccessWindows: a daily time range
+// on some weekdays, in the server's local time. A range whose end is not
+// after its start runs past midnight and belongs to the day it starts on.
+type AccessWindow struct {
+	Days  [7]bool // indexed by time.Weekday
+	Start int     // minutes after midnight
+	End   int     // minutes after midnight, 1440 for "24:00"
+}
+
+var weekdayNames = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseAccessWindow parses "[days ]HH:MM-HH:MM", where days is a comma
+// separated list of weekdays or weekday ranges such as "mon-fri" or
+// "sat,sun"; without days the window applies every day.
+func ParseAccessWindow(s string) (AccessWindow, error) {
+	var w AccessWindow
+	fields := strings.Fields(strings.ToLower(s))
+	var days, span string
+	switch len(fields) {
+	case 1:
+		span = fields[0]
+		for i := range w.Days {
+			w.Days[i] = true
+		}
+	case 2:
+		days, span = fields[0], fields[1]
+	default:
+		return w, fmt.Errorf("want \"[days ]HH:MM-HH:MM\"")
+	}
+	for _, part := range strings.Split(days, ",") {
+		if part == "" {
+			continue
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		first, ok := weekdayNames[from]
+		last, lastOK := first, true
+		if isRange {
+			last, lastOK = weekdayNames[to]
+		}
+		if !ok || !lastOK {
+			return w, fmt.Errorf("unknown weekday %q (use sun, mon, ... sat)", part)
+		}
+		for d := first; ; d = (d + 1) % 7 {
+			w.Days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	start, end, ok := strings.Cut(span, "-")
+	if !ok {
+		return w, fmt.Errorf("time range %q has no \"-\"", span)
+	}
+	var err error
+	if w.Start, err = parseClock(start); err != nil {
+		return w, err
+	}
+	if w.End, err = parseClock(end); err != nil {
+		return w, err
+	}
+	if w.Start == 1440 {
+		return w, fmt.Errorf("window cannot start at 24:00")
+	}
+	return w, nil
+}
+
+func parseClock(s string) (int, error) {
+	hh, mm, ok := strings.Cut(s, ":")
+	h, herr := strconv.Atoi(hh)
+	m, merr := strconv.Atoi(mm)
+	if !ok || herr != nil || merr != nil || h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("%q is not a time of day (HH:MM)", s)
+	}
+	return h*60 + m, nil
+}
+
+// Contains reports whether t falls inside the window.
+func (w AccessWindow) Contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if w.End > w.Start {
+		return w.Days[day] && minute >= w.Start && minute < w.End
+	}
+	// Overnight: the evening part on a listed day, the early part on the
+	// day after one.
+	return (w.Days[day] && minute >= w.Start) || (w.Days[(day+6)%7] && minute < w.End)
+}
+
+// AccessAllowed reports whether files under the rule may be read at t: the
+// rule has no access windows, or t falls inside one of them. Windows that
+// do not parse never match, so a typo closes the folder rather than
+// opening it; Validate reports them.
+func (p PasswdInfo) AccessAllowed(t time.Time) bool {
+	if len(p.AccessWindows) == 0 {
+		return true
+	}
+	t = t.In(time.Local)
+	for _, s := range p.AccessWindows {
+		if w, err := ParseAccessWindow(s); err == nil && w.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccessWindowContains(t *testing.T) {
+	// 2026-10-16 is a Friday.
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, time.October, day, hour, minute, 0, 0, time.Local)
+	}
+	cases := []struct {
+		window string
+		t      time.Time
+		want   bool
+	}{
+		{"18:00-21:00", at(16, 18, 0), true},
+		{"18:00-21:00", at(16, 21, 0), false},
+		{"18:00-21:00", at(16, 9, 30), false},
+		{"mon-fri 08:00-17:00", at(16, 12, 0), true},
+		{"mon-fri 08:00-17:00", at(17, 12, 0), false},
+		{"sat,sun 10:00-24:00", at(18, 23, 59), true},
+		{"fri-mon 10:00-12:00", at(19, 11, 0), true},
+		{"fri-mon 10:00-12:00", at(20, 11, 0), false},
+		// Overnight windows belong to the day they start on.
+		{"fri 22:00-02:00", at(16, 23, 0), true},
+		{"fri 22:00-02:00", at(17, 1, 30), true},
+		{"fri 22:00-02:00", at(16, 1, 30), false},
+	}
+	for _, tc := range cases {
+		w, err := ParseAccessWindow(tc.window)
+		if err != nil {
+			t.Fatalf("%q: %v", tc.window, err)
+		}
+		if got := w.Contains(tc.t); got != tc.want {
+			t.Errorf("%q at %s: got %v, want %v", tc.window, tc.t.Format("Mon 15:04"), got, tc.want)
+		}
+	}
+}
+
+func TestParseAccessWindowRejectsMalformed(t *testing.T) {
+	for _, s := range []string{"", "18:00", "25:00-26:00", "18:60-19:00", "funday 10:00-11:00", "24:00-01:00", "mon fri 10:00-11:00"} {
+		if _, err := ParseAccessWindow(s); err == nil {
+			t.Errorf("%q parsed", s)
+		}
+	}
+}
+
+func TestAccessAllowedFailsClosedOnTypos(t *testing.T) {
+	now := time.Now()
+	if !(PasswdInfo{}).AccessAllowed(now) {
+		t.Fatal("rule without windows is closed")
+	}
+	if (PasswdInfo{AccessWindows: []string{"18:00-2100"}}).AccessAllowed(now) {
+		t.Fatal("malformed window opened the rule")
+	}
+	if !(PasswdInfo{AccessWindows: []string{"18:00-2100", "00:00-24:00"}}).AccessAllowed(now) {
+		t.Fatal("valid window next to a malformed one was ignored")
+	}
+}
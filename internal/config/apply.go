@@ -0,0 +1,52 @@
+package config
+
+import "slices"
+
+// RestartReasons lists the scheme settings that differ between old and next
+// and are only read when the listeners are created: addresses, ports and
+// scheme.listeners, TLS files, the unix socket, h2c and the SFTP frontend.
+// Everything else (passwdList, the upstream Alist host, cache sizes, proxy
+// routing, ...) is applied in place and never needs the restart loop.
+func RestartReasons(old *SchemeConfig, next SchemeConfig) []string {
+	var prev SchemeConfig
+	if old != nil {
+		prev = *old
+	}
+	var reasons []string
+	add := func(changed bool, name string) {
+		if changed {
+			reasons = append(reasons, name)
+		}
+	}
+	add(prev.Address != next.Address, "address")
+	add(prev.HTTPPort != next.HTTPPort, "http_port")
+	add(prev.HTTPSPort != next.HTTPSPort, "https_port")
+	add(prev.CertFile != next.CertFile || prev.KeyFile != next.KeyFile, "tls")
+	add(prev.ClientCAFile != next.ClientCAFile, "client_ca_file")
+	add(!slices.EqualFunc(prev.Listeners, next.Listeners, func(a, b ListenerConfig) bool {
+		return a.Address == b.Address && a.Port == b.Port && a.TLS == b.TLS && slices.Equal(a.Paths, b.Paths)
+	}), "listeners")
+	add(prev.UnixFile != next.UnixFile || prev.UnixFilePerm != next.UnixFilePerm, "unix_file")
+	add(prev.EnableH2C != next.EnableH2C, "enable_h2c")
+	add(prev.SFTPPort != next.SFTPPort || prev.SFTPHostKey != next.SFTPHostKey, "sftp")
+	return reasons
+}
+
+// OnApply registers fn to run after a configuration change was applied in
+// place, so components that derive state from the config when they are
+// built (cache sizes, budgets) can rebuild it and swap it in. Hooks run on
+// the goroutine that made the change.
+func (c *Config) OnApply(fn func(*Config)) {
+	c.hooksMu.Lock()
+	c.applyHooks = append(c.applyHooks, fn)
+	c.hooksMu.Unlock()
+}
+
+func (c *Config) notifyApplied() {
+	c.hooksMu.Lock()
+	hooks := append([]func(*Config){}, c.applyHooks...)
+	c.hooksMu.Unlock()
+	for _, fn := range hooks {
+		fn(c)
+	}
+}
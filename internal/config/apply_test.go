@@ -0,0 +1,59 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestUpdatesApplyInPlaceUnlessListenersChange(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "conf", "config.json")
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := loadConfigAt(configPath)
+	applied := 0
+	cfg.OnApply(func(*Config) { applied++ })
+
+	server := cfg.AlistServer
+	server.ServerHost = "alist.lan"
+	server.StaticCacheMb = 128
+	if err := cfg.UpdateAlistServer(server); err != nil {
+		t.Fatal(err)
+	}
+	if applied != 1 || cfg.GetAlistURL() != "http://alist.lan:5244" {
+		t.Fatalf("applied=%d url=%s", applied, cfg.GetAlistURL())
+	}
+
+	old := cfg.Scheme
+	scheme := *cfg.Scheme
+	scheme.ForceHTTPS = !scheme.ForceHTTPS
+	scheme.SelfSignedHosts = []string{"nas.lan"}
+	if restart, err := cfg.UpdateScheme(scheme); err != nil || restart {
+		t.Fatalf("force_https/self_signed_hosts should apply in place: restart=%v err=%v", restart, err)
+	}
+	if applied != 2 || cfg.Scheme == old {
+		t.Fatal("scheme change should swap in a new snapshot and run the hooks")
+	}
+
+	scheme.HTTPPort++
+	scheme.CertFile = "/tmp/cert.pem"
+	if restart, _ := cfg.UpdateScheme(scheme); !restart || applied != 2 {
+		t.Fatalf("port change must restart instead of applying: restart=%v applied=%d", restart, applied)
+	}
+}
+
+func TestRestartReasons(t *testing.T) {
+	base := SchemeConfig{HTTPPort: 5344, EnableH2C: true}
+	next := base
+	next.EnableH2C = false
+	next.SFTPPort = 2022
+	next.KeyFile = "k.pem"
+	if got := RestartReasons(&base, next); !reflect.DeepEqual(got, []string{"tls", "enable_h2c", "sftp"}) {
+		t.Fatalf("reasons=%v", got)
+	}
+	if got := RestartReasons(nil, SchemeConfig{}); got != nil {
+		t.Fatalf("nil to zero scheme: %v", got)
+	}
+}
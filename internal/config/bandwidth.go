@@ -0,0 +1,62 @@
+package config
+
+import "fmt"
+
+// BandwidthConfig caps how fast response bodies are sent, so one client
+// pulling a 4K remux cannot fill the uplink for everyone else. Rates are in
+// KiB per second; 0 leaves that cap off.
+type BandwidthConfig struct {
+	Enable bool `json:"enable"`
+	// PerStreamKBps caps each download on its own.
+	PerStreamKBps int `json:"per_stream_kbps"`
+	// PerClientKBps caps all downloads of one client address together.
+	PerClientKBps int `json:"per_client_kbps"`
+	// Exempt lists client addresses or CIDRs that are never throttled,
+	// e.g. the LAN, whose traffic does not cross the uplink.
+	Exempt []string `json:"exempt,omitempty"`
+}
+
+// maxBandwidthKBps bounds the caps (10 GiB/s) so the byte rates cannot
+// overflow.
+const maxBandwidthKBps = 10 << 20
+
+// GetBandwidth returns a copy of bandwidth, or nil when it is not enabled.
+func (c *Config) GetBandwidth() *BandwidthConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.Bandwidth == nil || !c.Bandwidth.Enable {
+		return nil
+	}
+	bw := *c.Bandwidth
+	bw.Exempt = append([]string(nil), bw.Exempt...)
+	return &bw
+}
+
+func (c *Config) normalizeBandwidthConfig() {
+	if c == nil || c.Bandwidth == nil {
+		return
+	}
+	bw := c.Bandwidth
+	bw.PerStreamKBps = clampIntValue(bw.PerStreamKBps, 0, maxBandwidthKBps)
+	bw.PerClientKBps = clampIntValue(bw.PerClientKBps, 0, maxBandwidthKBps)
+}
+
+func validateBandwidth(bw *BandwidthConfig) []Issue {
+	if !bw.Enable {
+		return nil
+	}
+	var issues []Issue
+	add := func(severity, field, format string, args ...interface{}) {
+		issues = append(issues, Issue{severity, field, fmt.Sprintf(format, args...)})
+	}
+	if bw.PerStreamKBps == 0 && bw.PerClientKBps == 0 {
+		add(IssueWarning, "bandwidth", "enabled without per_stream_kbps or per_client_kbps; nothing is throttled")
+	}
+	if bw.PerClientKBps > 0 && bw.PerStreamKBps > bw.PerClientKBps {
+		add(IssueWarning, "bandwidth.per_stream_kbps", "%d is above per_client_kbps %d, which caps every stream anyway", bw.PerStreamKBps, bw.PerClientKBps)
+	}
+	if _, err := ParseIPNets(bw.Exempt); err != nil {
+		add(IssueError, "bandwidth.exempt", "%v; the list is ignored", err)
+	}
+	return issues
+}
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateBandwidth(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Bandwidth = &BandwidthConfig{Enable: true, PerStreamKBps: 8192, PerClientKBps: -5, Exempt: []string{"lan"}}
+	cfg.normalizeBandwidthConfig()
+	if bw := cfg.GetBandwidth(); bw.PerClientKBps != 0 || bw.PerStreamKBps != 8192 {
+		t.Fatalf("bandwidth = %+v", bw)
+	}
+	cfg.Bandwidth.PerClientKBps = 4096
+	var found []string
+	for _, issue := range cfg.Validate() {
+		if strings.HasPrefix(issue.Field, "bandwidth") {
+			found = append(found, issue.Severity+" "+issue.Field)
+		}
+	}
+	want := []string{"warning bandwidth.per_stream_kbps", "error bandwidth.exempt"}
+	if strings.Join(found, ",") != strings.Join(want, ",") {
+		t.Fatalf("issues = %v, want %v", found, want)
+	}
+}
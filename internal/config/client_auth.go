@@ -0,0 +1,37 @@
+package config
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/alist-encrypt-go/internal/pathutil"
+)
+
+// LoadClientCAs reads the PEM certificates of scheme.client_ca_file into a
+// pool for verifying client certificates.
+func LoadClientCAs(file string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s contains no PEM certificates", file)
+	}
+	return pool, nil
+}
+
+// ClientCertRequired reports whether a request for urlPath on the HTTPS
+// listener must carry a verified client certificate.
+func (s *SchemeConfig) ClientCertRequired(urlPath string) bool {
+	if s == nil || s.ClientCAFile == "" {
+		return false
+	}
+	for _, prefix := range s.ClientAuthExempt {
+		if pathutil.Within(urlPath, prefix) {
+			return false
+		}
+	}
+	return true
+}
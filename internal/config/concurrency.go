@@ -0,0 +1,124 @@
+package config
+
+import (
+	"path"
+	"strings"
+)
+
+// Worker pool names shared by config.Concurrency and internal/workers.
+const (
+	PoolNameDecrypt     = "name_decrypt"
+	PoolPrefetch        = "prefetch"
+	PoolSizeResolve     = "size_resolve"
+	PoolJobs            = "jobs"
+	PoolDownloadStreams = "download_streams"
+	PoolImageResize     = "image_resize"
+)
+
+const maxWorkerLimit = 256
+
+// ConcurrencyConfig sizes the process-wide worker pools. Zero keeps the
+// legacy alistServer setting (parallelDecryptConcurrency, maxActiveStreams)
+// or the built-in default.
+type ConcurrencyConfig struct {
+	NameDecryptWorkers int `json:"name_decrypt_workers"`
+	PrefetchWorkers    int `json:"prefetch_workers"`
+	SizeResolveWorkers int `json:"size_resolve_workers"`
+	JobWorkers         int `json:"job_workers"`
+	DownloadStreams    int `json:"download_streams"`
+	ImageResizeWorkers int `json:"image_resize_workers"`
+	// StorageBudgets limit individual Alist storages, keyed by their
+	// top-level path, on top of the pools above.
+	StorageBudgets []StorageBudget `json:"storage_budgets,omitempty"`
+}
+
+// StorageBudget caps the load sent to one Alist storage (a top-level path
+// such as "/aliyun"); zero leaves a limit off.
+type StorageBudget struct {
+	Path           string `json:"path"`
+	MaxStreams     int    `json:"max_streams"`
+	RequestsPerMin int    `json:"requests_per_min"`
+}
+
+// WorkerLimit returns the configured size of the named pool.
+func (c *Config) WorkerLimit(pool string) int {
+	var cc ConcurrencyConfig
+	var s AlistServer
+	if c != nil {
+		if c.Concurrency != nil {
+			cc = *c.Concurrency
+		}
+		s = c.AlistServer
+	}
+	pick := func(v, legacy, def int) int {
+		if v <= 0 {
+			v = legacy
+		}
+		if v <= 0 {
+			v = def
+		}
+		return clampIntValue(v, 1, maxWorkerLimit)
+	}
+	switch pool {
+	case PoolNameDecrypt:
+		return pick(cc.NameDecryptWorkers, s.ParallelDecryptConcurrency, 4)
+	case PoolPrefetch:
+		return pick(cc.PrefetchWorkers, 0, 10)
+	case PoolSizeResolve:
+		return pick(cc.SizeResolveWorkers, 0, 20)
+	case PoolJobs:
+		return pick(cc.JobWorkers, 0, 2)
+	case PoolDownloadStreams:
+		return pick(cc.DownloadStreams, s.MaxActiveStreams, 32)
+	case PoolImageResize:
+		return pick(cc.ImageResizeWorkers, 0, 2)
+	}
+	return 1
+}
+
+func (c *Config) normalizeConcurrencyConfig() {
+	if c == nil {
+		return
+	}
+	if c.Concurrency == nil {
+		c.Concurrency = &ConcurrencyConfig{}
+	}
+	cc := c.Concurrency
+	cc.NameDecryptWorkers = clampIntValue(cc.NameDecryptWorkers, 0, maxWorkerLimit)
+	cc.PrefetchWorkers = clampIntValue(cc.PrefetchWorkers, 0, maxWorkerLimit)
+	cc.SizeResolveWorkers = clampIntValue(cc.SizeResolveWorkers, 0, maxWorkerLimit)
+	cc.JobWorkers = clampIntValue(cc.JobWorkers, 0, maxWorkerLimit)
+	cc.DownloadStreams = clampIntValue(cc.DownloadStreams, 0, maxWorkerLimit)
+	cc.ImageResizeWorkers = clampIntValue(cc.ImageResizeWorkers, 0, maxWorkerLimit)
+	cc.StorageBudgets = normalizeStorageBudgets(cc.StorageBudgets)
+}
+
+// normalizeStorageBudgets reduces each path to its top-level segment and
+// drops entries without a path or any limit; a later entry for the same
+// storage wins.
+func normalizeStorageBudgets(in []StorageBudget) []StorageBudget {
+	out := make([]StorageBudget, 0, len(in))
+	index := make(map[string]int)
+	for _, b := range in {
+		p := strings.TrimPrefix(path.Clean("/"+strings.TrimSpace(b.Path)), "/")
+		if i := strings.IndexByte(p, '/'); i >= 0 {
+			p = p[:i]
+		}
+		if p == "" {
+			continue
+		}
+		b.Path = "/" + p
+		b.MaxStreams = clampIntValue(b.MaxStreams, 0, maxWorkerLimit)
+		b.RequestsPerMin = clampIntValue(b.RequestsPerMin, 0, 100000)
+		if b.MaxStreams == 0 && b.RequestsPerMin == 0 {
+			continue
+		}
+		if i, ok := index[b.Path]; ok {
+			out[i] = b
+			continue
+		}
+		index[b.Path] = len(out)
+		out = append(out, b)
+	}
+	return out
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWorkerLimitFallsBackToLegacySettings(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AlistServer.ParallelDecryptConcurrency = 6
+	cfg.AlistServer.MaxActiveStreams = 12
+	cfg.Concurrency = nil
+
+	if got := cfg.WorkerLimit(PoolNameDecrypt); got != 6 {
+		t.Fatalf("name_decrypt=%d, want legacy 6", got)
+	}
+	if got := cfg.WorkerLimit(PoolDownloadStreams); got != 12 {
+		t.Fatalf("download_streams=%d, want legacy 12", got)
+	}
+	if got := cfg.WorkerLimit(PoolJobs); got != 2 {
+		t.Fatalf("jobs=%d, want default 2", got)
+	}
+
+	cfg.Concurrency = &ConcurrencyConfig{NameDecryptWorkers: 3, DownloadStreams: 1000}
+	if got := cfg.WorkerLimit(PoolNameDecrypt); got != 3 {
+		t.Fatalf("name_decrypt=%d, want configured 3", got)
+	}
+	if got := cfg.WorkerLimit(PoolDownloadStreams); got != maxWorkerLimit {
+		t.Fatalf("download_streams=%d, want clamp %d", got, maxWorkerLimit)
+	}
+}
+
+func TestConcurrencySectionLoadsAndEmbeddedCaps(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "conf", "config.json")
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	data := []byte(`{"profile":"embedded","concurrency":{"prefetch_workers":50,"job_workers":1}}`)
+	if err := os.WriteFile(configPath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := loadConfigAt(configPath)
+	if got := cfg.WorkerLimit(PoolPrefetch); got != 2 {
+		t.Fatalf("prefetch=%d, want embedded cap 2", got)
+	}
+	if got := cfg.WorkerLimit(PoolJobs); got != 1 {
+		t.Fatalf("jobs=%d, want 1", got)
+	}
+}
+
+func TestStorageBudgetsNormalize(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Concurrency = &ConcurrencyConfig{StorageBudgets: []StorageBudget{
+		{Path: "aliyun/movies", MaxStreams: 2},
+		{Path: "/115", RequestsPerMin: 30},
+		{Path: "/", MaxStreams: 1},
+		{Path: "/idle"},
+		{Path: "/aliyun", MaxStreams: 3, RequestsPerMin: 1000000},
+	}}
+	cfg.normalizeConcurrencyConfig()
+	got := cfg.Concurrency.StorageBudgets
+	if len(got) != 2 || got[0] != (StorageBudget{Path: "/aliyun", MaxStreams: 3, RequestsPerMin: 100000}) || got[1].Path != "/115" {
+		t.Fatalf("StorageBudgets=%+v", got)
+	}
+}
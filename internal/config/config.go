@@ -5,9 +5,12 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/netip"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strconv"
 	"strings"
@@ -18,17 +21,118 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-const Version = "1.0.0"
+// Version is the running release; release builds override it with
+// -ldflags "-X github.com/alist-encrypt-go/internal/config.Version=...".
+var Version = "1.0.0"
 
 // PasswdInfo represents encryption configuration for a path
 type PasswdInfo struct {
-	Password  string   `json:"password"`
-	EncType   string   `json:"encType"`   // "aesctr", "rc4md5", or "chacha20"
-	Describe  string   `json:"describe"`  // Description
-	Enable    bool     `json:"enable"`    // Enable encryption
-	EncName   bool     `json:"encName"`   // Enable filename encryption
-	EncSuffix string   `json:"encSuffix"` // Custom file extension
-	EncPath   []string `json:"encPath"`   // Regex patterns for path matching
+	Password           string            `json:"password"`
+	EncType            string            `json:"encType"`                      // "aesctr", "rc4md5", "chacha20", "aesgcm" or "xchacha20poly1305"
+	Describe           string            `json:"describe"`                     // Description
+	Enable             bool              `json:"enable"`                       // Enable encryption
+	EncName            bool              `json:"encName"`                      // Enable filename encryption
+	EncSuffix          string            `json:"encSuffix"`                    // Custom file extension
+	ExtPolicy          string            `json:"extPolicy"`                    // "keep" (default) or "hide": see NameSuffix
+	EncPath            []string          `json:"encPath"`                      // Regex patterns for path matching
+	Strict             bool              `json:"strict"`                       // Reject writes that would store plaintext here
+	StripMetadata      bool              `json:"stripMetadata"`                // Blank EXIF/GPS and video user data on upload
+	UploadTransforms   []string          `json:"uploadTransforms,omitempty"`   // Ordered upload stages run before encryption
+	DownloadTransforms []string          `json:"downloadTransforms,omitempty"` // Ordered download stages run after decryption
+	PasswordVersions   []PasswordVersion `json:"passwordVersions,omitempty"`   // Later content passwords: see CurrentPassword
+	NameDialect        string            `json:"nameDialect,omitempty"`        // File name codec variant: "" (default), "nopad" or "basename"
+	NameKDF            string            `json:"nameKdf,omitempty"`            // File name key KDF: "" (legacy PBKDF2), "pbkdf2-600k" or "argon2id"
+	NameKDFPrevious    string            `json:"nameKdfPrevious,omitempty"`    // KDF names not yet migrated still use; default legacy
+	MaxNameBytes       int               `json:"maxNameBytes,omitempty"`       // Stored name limit of this storage; 0 = alistServer.maxEncNameBytes, -1 = none
+	AccessWindows      []string          `json:"accessWindows,omitempty"`      // Times files may be read, e.g. "18:00-21:00": see AccessAllowed
+}
+
+// PasswordVersion is a later generation of a folder's content password.
+// Password itself is version 1.
+type PasswordVersion struct {
+	Version  int    `json:"version"` // 2..255
+	Password string `json:"password"`
+}
+
+// Extension policies for encrypted file names. The plain name, extension
+// included, is always encrypted; the policy decides what the stored name ends
+// with when no EncSuffix is configured.
+const (
+	ExtPolicyKeep = "keep" // append the real extension, e.g. "<enc>.mkv"
+	ExtPolicyHide = "hide" // append HiddenExtSuffix so file types do not leak
+)
+
+// HiddenExtSuffix is the stored suffix used by ExtPolicyHide.
+const HiddenExtSuffix = ".bin"
+
+// NameSuffix returns the suffix encrypted names under this folder are stored
+// with: EncSuffix when set, HiddenExtSuffix for ExtPolicyHide, otherwise ""
+// (keep the real extension). All name conversions must use this instead of
+// EncSuffix so listing, lookup and upload agree.
+func (p PasswdInfo) NameSuffix() string {
+	if p.EncSuffix != "" {
+		return p.EncSuffix
+	}
+	if p.ExtPolicy == ExtPolicyHide {
+		return HiddenExtSuffix
+	}
+	return ""
+}
+
+// NameConverter returns the file name converter of the rule: its password,
+// name suffix, name dialect and name KDF.
+func (p PasswdInfo) NameConverter() *encryption.FileNameConverter {
+	c := encryption.NewFileNameConverter(p.Password, p.EncType, p.NameSuffix()).WithDialect(p.NameDialect)
+	if p.NameKDF != "" {
+		// Names not migrated to NameKDF yet still list and open.
+		c.WithKDF(p.NameKDF, p.NameKDFPrevious)
+	}
+	return c
+}
+
+// CurrentPassword returns the password new uploads are encrypted with and
+// its version: the highest entry of PasswordVersions, or Password (1). File
+// names keep using Password so rotating does not rename anything; each file's
+// content header records the version it was encrypted with and
+// ContentPassword finds it again.
+func (p PasswdInfo) CurrentPassword() (string, int) {
+	password, version := p.Password, 1
+	for _, v := range p.PasswordVersions {
+		if v.Version > version && v.Version <= encryption.MaxPasswordVersion && v.Password != "" {
+			password, version = v.Password, v.Version
+		}
+	}
+	return password, version
+}
+
+// ContentPassword returns the password of version (1 for untagged content).
+// A rule without PasswordVersions has a single password, used for any tag.
+func (p PasswdInfo) ContentPassword(version int) (string, error) {
+	if version <= 1 || len(p.PasswordVersions) == 0 {
+		return p.Password, nil
+	}
+	for _, v := range p.PasswordVersions {
+		if v.Version == version && v.Password != "" {
+			return v.Password, nil
+		}
+	}
+	return "", fmt.Errorf("file is encrypted with password version %d, which is not in passwordVersions", version)
+}
+
+// Upload transform names understood by the proxy's upload pipeline.
+const (
+	UploadTransformStripMetadata = "strip_metadata"
+)
+
+// UploadTransformNames returns the ordered upload pipeline for this entry.
+// StripMetadata is shorthand for appending UploadTransformStripMetadata when
+// it is not already listed. Encryption always runs last and is not named.
+func (p PasswdInfo) UploadTransformNames() []string {
+	names := append([]string(nil), p.UploadTransforms...)
+	if p.StripMetadata && !slices.Contains(names, UploadTransformStripMetadata) {
+		names = append(names, UploadTransformStripMetadata)
+	}
+	return names
 }
 
 // StreamStrategyOverride forces stream strategy for matching paths.
@@ -37,6 +141,49 @@ type StreamStrategyOverride struct {
 	Strategy   string `json:"strategy"` // range, chunked, full
 }
 
+// WebDAVUserMapping presents a proxy-side WebDAV login that is forwarded to
+// Alist as a different (service) account. Password may be plain text or
+// "sha256:<hex>".
+type WebDAVUserMapping struct {
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	AlistUsername string `json:"alistUsername"`
+	AlistPassword string `json:"alistPassword"`
+}
+
+// ForwardedUserPaths restricts a user identified by forwardedUserHeader to
+// the given path prefixes. Username "*" applies to every request without an
+// entry of its own, including requests that carry no forwarded user.
+type ForwardedUserPaths struct {
+	Username   string   `json:"username"`
+	AllowPaths []string `json:"allowPaths"`
+}
+
+// LocalStorageMount maps an Alist mount backed by the "local" storage driver
+// onto the directory it serves on this host, so downloads under AlistPath can
+// be read and decrypted straight from LocalRoot instead of through Alist.
+type LocalStorageMount struct {
+	AlistPath string `json:"alistPath"` // Alist mount path, e.g. /nas
+	LocalRoot string `json:"localRoot"` // root folder of that storage as seen by this process
+}
+
+// CacheControlRule sets Cache-Control on successful decrypted responses. A
+// rule matches when the display path starts with PathPrefix and, for files,
+// the extension is one of Extensions (empty matches any). The first matching
+// rule in cacheControlRules wins.
+type CacheControlRule struct {
+	Target       string   `json:"target"` // "file" (default) or "list"
+	PathPrefix   string   `json:"pathPrefix"`
+	Extensions   []string `json:"extensions"`   // e.g. [".mkv", ".mp4"]
+	CacheControl string   `json:"cacheControl"` // e.g. "public, max-age=31536000, immutable"
+}
+
+// Targets for CacheControlRule.
+const (
+	CacheTargetFile = "file" // /d, /p, /dav and /redirect downloads
+	CacheTargetList = "list" // fs/list and PROPFIND listings
+)
+
 // AlistServer represents the main Alist server configuration
 type AlistServer struct {
 	Name                        string                   `json:"name"`
@@ -50,6 +197,7 @@ type AlistServer struct {
 	StreamStrategyOverrides     []StreamStrategyOverride `json:"streamStrategyOverrides"`
 	EnableSizeMap               bool                     `json:"enableSizeMap"`
 	SizeMapTtlMinutes           int                      `json:"sizeMapTtlMinutes"`
+	DeletedPathRetentionMinutes int                      `json:"deletedPathRetentionMinutes"` // how long removed paths refuse cache writes, default 10, 0 = off
 	EnableRangeCompatCache      bool                     `json:"enableRangeCompatCache"`
 	RangeFailToDowngrade        int                      `json:"rangeFailToDowngrade"`
 	RangeSuccessToRecover       int                      `json:"rangeSuccessToRecover"`
@@ -61,6 +209,54 @@ type AlistServer struct {
 	EnableDecryptedBlockCache   bool                     `json:"enableDecryptedBlockCache"`
 	DecryptedBlockCacheMb       int                      `json:"decryptedBlockCacheMb"`
 	DecryptedBlockSizeKb        int                      `json:"decryptedBlockSizeKb"`
+	EnableMediaIndex            bool                     `json:"enableMediaIndex"`       // Cache decrypted MP4 moov / MKV cues regions
+	MediaIndexCacheMb           int                      `json:"mediaIndexCacheMb"`      // default 64
+	MediaIndexMaxRegionKb       int                      `json:"mediaIndexMaxRegionKb"`  // default 8192
+	MediaIndexMinSizeBytes      int64                    `json:"mediaIndexMinSizeBytes"` // default 256MB
+	EnablePrefetch              bool                     `json:"enablePrefetch"`
+	EnableRedirectPrewarm       bool                     `json:"enableRedirectPrewarm"` // probe raw_url in the background when fs/get registers a redirect
+	EnableUploadStaging         bool                     `json:"enableUploadStaging"`
+	MaxEncNameBytes             int                      `json:"maxEncNameBytes"` // stored file name limit, default 255, -1 = none
+	LongNameAction              string                   `json:"longNameAction"`  // "reject" (default) or "shorten" names over the limit
+	UploadStagingVerifyRetries  int                      `json:"uploadStagingVerifyRetries"`
+	EnableSignedRedirect        bool                     `json:"enableSignedRedirect"`
+	SignedRedirectTTLSeconds    int                      `json:"signedRedirectTtlSeconds"`
+	SignedRedirectBindIP        bool                     `json:"signedRedirectBindIp"`
+	SignedRedirectSingleUse     bool                     `json:"signedRedirectSingleUse"`
+	FailoverHosts               []string                 `json:"failoverHosts,omitempty"` // secondary addresses of the same Alist, tried in order
+	FailoverCheckSeconds        int                      `json:"failoverCheckSeconds"`    // health check interval, default 10
+	EnableResumeTokens          bool                     `json:"enableResumeTokens"`      // X-Resume-Token on large decrypted downloads
+	ResumeTokenMinSizeMb        int                      `json:"resumeTokenMinSizeMb"`    // default 1024
+	ResumeTokenTTLHours         int                      `json:"resumeTokenTtlHours"`     // default 24
+	EnableServerTiming          bool                     `json:"enableServerTiming"`      // Server-Timing breakdown on decrypted downloads
+	MaxHops                     int                      `json:"maxHops"`                 // alist-encrypt instances allowed in a chain (LAN + VPS = 2)
+	EnableListCache             bool                     `json:"enableListCache"`
+	ListCacheTTLSeconds         int                      `json:"listCacheTtlSeconds"`
+	AdminRouteAccess            string                   `json:"adminRouteAccess"`
+	EnableDecodeHealthScan      bool                     `json:"enableDecodeHealthScan"`
+	DecodeHealthIntervalMinutes int                      `json:"decodeHealthIntervalMinutes"`
+	DecodeHealthSampleSize      int                      `json:"decodeHealthSampleSize"`
+	DecodeHealthFailPercent     int                      `json:"decodeHealthFailPercent"`
+	DecodeHealthWebhook         string                   `json:"decodeHealthWebhook"`
+	ReadVerifyPercent           int                      `json:"readVerifyPercent"` // % of full decrypted downloads hashed and checked, 0 = off
+	WebDAVUsers                 []WebDAVUserMapping      `json:"webdavUsers"`
+	WebDAVMappedUsersOnly       bool                     `json:"webdavMappedUsersOnly"`
+	ForwardedUserHeader         string                   `json:"forwardedUserHeader"` // e.g. X-Forwarded-User; empty = ignore
+	ForwardedUserPaths          []ForwardedUserPaths     `json:"forwardedUserPaths"`
+	EnableLocalDirectRead       bool                     `json:"enableLocalDirectRead"`
+	LocalStorageMounts          []LocalStorageMount      `json:"localStorageMounts"`
+	CacheControlRules           []CacheControlRule       `json:"cacheControlRules"`
+	EnableImageResize           bool                     `json:"enableImageResize"`    // serve /img/* resized variants
+	ImageCacheMb                int                      `json:"imageCacheMb"`         // on-disk variant cache, default 512
+	ImageMaxSourceMb            int                      `json:"imageMaxSourceMb"`     // largest original decoded, default 40
+	RewriteHTMLURLs             bool                     `json:"rewriteHtmlUrls"`      // make Alist URLs in proxied HTML proxy-relative
+	HTMLRewriteHosts            []string                 `json:"htmlRewriteHosts"`     // extra Alist public hosts, e.g. site_url's
+	UploadContentVersion        int                      `json:"uploadContentVersion"` // 2 (random per-file nonce header) or 1 (legacy)
+	EnableStaticCache           bool                     `json:"enableStaticCache"`    // cache Alist's js/css/fonts in memory
+	StaticCacheMb               int                      `json:"staticCacheMb"`        // default 64
+	WebDAVHTMLIndex             bool                     `json:"webdavHtmlIndex"`      // render decrypted HTML listings for directory GETs under /dav
+	WebDAVPropfindLimit         int                      `json:"webdavPropfindLimit"`  // children per Depth: 1 PROPFIND page, 0 = unlimited
+	QuietHours                  []string                 `json:"quietHours"`           // "HH:MM-HH:MM" local time; background jobs pause inside
 	FollowRedirectForDecrypt    bool                     `json:"followRedirectForDecrypt"`
 	RedirectMaxHops             int                      `json:"redirectMaxHops"`
 	AllowLooseDecode            bool                     `json:"allowLooseDecode"`
@@ -126,8 +322,34 @@ type SchemeConfig struct {
 	UnixFile     string `json:"unix_file"`
 	UnixFilePerm string `json:"unix_file_perm"`
 	EnableH2C    bool   `json:"enable_h2c"`
+	// AutoSelfSigned generates a self-signed certificate into the conf dir on
+	// startup when HTTPS is requested but no certificate is configured.
+	AutoSelfSigned  bool     `json:"auto_self_signed,omitempty"`
+	SelfSignedHosts []string `json:"self_signed_hosts,omitempty"`
+	// ClientCAFile turns on client certificate authentication for the
+	// HTTPS listener: requests must present a certificate signed by one of
+	// the PEM CAs in the file, except under ClientAuthExempt path prefixes
+	// (such as "/dav" for WebDAV clients that cannot send certificates).
+	ClientCAFile     string   `json:"client_ca_file,omitempty"`
+	ClientAuthExempt []string `json:"client_auth_exempt,omitempty"`
+	// Listeners replaces http_port / https_port with any number of
+	// listeners when set.
+	Listeners []ListenerConfig `json:"listeners,omitempty"`
+	// SFTPPort serves the decrypted view over SFTP when > 0; users log in
+	// with their Alist credentials. SFTPHostKey defaults to a key generated
+	// into the conf dir.
+	SFTPPort    int    `json:"sftp_port,omitempty"`
+	SFTPHostKey string `json:"sftp_host_key,omitempty"`
+	// DrainSeconds is how long a restart or SIGHUP reload waits for
+	// in-flight requests before the new server starts; 0 means
+	// DefaultDrainSeconds.
+	DrainSeconds int `json:"drain_seconds,omitempty"`
 }
 
+// DefaultDrainSeconds is the drain time of restarts when
+// scheme.drain_seconds is not set.
+const DefaultDrainSeconds = 30
+
 // ProxyConfig represents HTTP proxy client configuration
 type ProxyConfig struct {
 	MaxIdleConns        int         `json:"max_idle_conns"`
@@ -147,6 +369,20 @@ type ProxyConfig struct {
 	ResponseHeaderSecs  int         `json:"response_header_timeout_seconds"` // default 15
 }
 
+// HTTP2Config tunes HTTP/2 flow control for the serving listener and the
+// upstream transports. Zero values keep the golang.org/x/net defaults, which
+// throttle single-stream throughput on high-latency links (64KB initial window).
+type HTTP2Config struct {
+	MaxConcurrentStreams         int  `json:"max_concurrent_streams"`          // serving side, default 1000
+	MaxReadFrameSize             int  `json:"max_read_frame_size"`             // serving side, bytes
+	ServerConnWindowKb           int  `json:"server_conn_window_kb"`           // per-connection receive window
+	ServerStreamWindowKb         int  `json:"server_stream_window_kb"`         // per-stream receive window
+	UpstreamMaxReadFrameSize     int  `json:"upstream_max_read_frame_size"`    // bytes
+	UpstreamConnWindowKb         int  `json:"upstream_conn_window_kb"`         // per-connection receive window
+	UpstreamStreamWindowKb       int  `json:"upstream_stream_window_kb"`       // per-stream receive window
+	UpstreamStrictMaxConcurrency bool `json:"upstream_strict_max_concurrency"` // honor upstream SETTINGS_MAX_CONCURRENT_STREAMS strictly
+}
+
 // ProxyRule describes how to route one pattern.
 type ProxyRule struct {
 	ID         string `json:"id"`
@@ -158,12 +394,36 @@ type ProxyRule struct {
 	Priority   int    `json:"priority"`
 }
 
+// UpdateConfig controls the GitHub release update checker.
+type UpdateConfig struct {
+	Enable             bool   `json:"enable"`
+	Repo               string `json:"repo,omitempty"`       // owner/name, default upstream repo
+	CheckIntervalHours int    `json:"check_interval_hours"` // default 24
+	AllowApply         bool   `json:"allow_apply"`          // allow /enc-api/applyUpdate to replace the binary
+}
+
+// StatusProbeConfig controls the periodic upstream latency probe behind
+// /enc-api/status and the detail section of /ready.
+type StatusProbeConfig struct {
+	Enable          bool `json:"enable"`
+	IntervalSeconds int  `json:"interval_seconds"` // default 30
+	History         int  `json:"history"`          // samples kept per upstream, default 120
+	IncludeWebDAV   bool `json:"include_webdav"`   // also probe each webdavServer entry
+}
+
 // LogConfig represents logging configuration
 type LogConfig struct {
 	Enable bool   `json:"enable"`
 	Level  string `json:"level"`  // debug, info, warn, error
 	Format string `json:"format"` // console, json
 	Name   string `json:"name"`   // log file path
+	// Optional MaxMind DB files used to tag access logs with country / ASN.
+	GeoIPDB string `json:"geoip_db,omitempty"`
+	ASNDB   string `json:"asn_db,omitempty"`
+	// TraceBuffer is how many trace lines are kept in memory for
+	// /enc-api/trace/<request id>; 0 keeps the default 5000, negative
+	// turns the buffer off.
+	TraceBuffer int `json:"trace_buffer,omitempty"`
 }
 
 // DBConfig represents database configuration
@@ -182,23 +442,58 @@ type DBConfig struct {
 
 // Config represents the main configuration (compatible with Node.js version)
 type Config struct {
+	// ConfigVersion records which migrations in migrate.go have been applied.
+	ConfigVersion int `json:"configVersion"`
+
 	// Core settings (compatible with original)
 	AlistServer  AlistServer    `json:"alistServer"`
 	WebDAVServer []WebDAVServer `json:"webdavServer"`
 	Port         int            `json:"port"`
 
 	// Extended settings
-	Scheme    *SchemeConfig `json:"scheme,omitempty"`
-	Proxy     *ProxyConfig  `json:"proxy,omitempty"`
-	Log       *LogConfig    `json:"log,omitempty"`
-	Database  *DBConfig     `json:"database,omitempty"`
-	DataDir   string        `json:"data_dir,omitempty"`
-	JWTSecret string        `json:"jwt_secret,omitempty"`
-	JWTExpire int           `json:"jwt_expire,omitempty"`
+	Scheme *SchemeConfig `json:"scheme,omitempty"`
+	Proxy  *ProxyConfig  `json:"proxy,omitempty"`
+	HTTP2  *HTTP2Config  `json:"http2,omitempty"`
+	Log    *LogConfig    `json:"log,omitempty"`
+	Update *UpdateConfig `json:"update,omitempty"`
+	// StatusProbe samples upstream latency and availability; see
+	// StatusProbeConfig.
+	StatusProbe *StatusProbeConfig `json:"status_probe,omitempty"`
+	// OIDC lets management logins go through an OpenID Connect provider;
+	// see oidc.go.
+	OIDC *OIDCConfig `json:"oidc,omitempty"`
+	// RequestRules decide how matching requests are handled before any
+	// handler runs; see request_rules.go.
+	RequestRules []RequestRule `json:"request_rules,omitempty"`
+	// TrustedProxies lists the reverse proxies whose X-Forwarded-* headers
+	// are believed; see trusted_proxies.go.
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+	// RateLimit throttles clients globally, per address and per route;
+	// see rate_limit.go.
+	RateLimit *RateLimitConfig `json:"rate_limit,omitempty"`
+	// Bandwidth caps download speed per stream and per client; see
+	// bandwidth.go.
+	Bandwidth *BandwidthConfig `json:"bandwidth,omitempty"`
+	// Concurrency sizes the shared worker pools; see concurrency.go.
+	Concurrency *ConcurrencyConfig `json:"concurrency,omitempty"`
+	Database    *DBConfig          `json:"database,omitempty"`
+	DataDir     string             `json:"data_dir,omitempty"`
+	JWTSecret   string             `json:"jwt_secret,omitempty"`
+	JWTExpire   int                `json:"jwt_expire,omitempty"`
+	// WebUIDir overrides embedded web UI assets; files found here win.
+	WebUIDir string `json:"web_ui_dir,omitempty"`
+	// Profile applies a curated set of resource limits on load ("embedded").
+	Profile string `json:"profile,omitempty"`
+	// NodeCompat makes /enc-api answer exactly like the Node.js version
+	// (success code 200, auth failures as HTTP 200, no localization) for
+	// frontends and scripts written against it.
+	NodeCompat bool `json:"node_compat,omitempty"`
 
 	// Internal
 	configPath string
 	mu         sync.RWMutex
+	hooksMu    sync.Mutex
+	applyHooks []func(*Config)
 }
 
 var (
@@ -206,6 +501,138 @@ var (
 	cfgOnce sync.Once
 )
 
+// Values for AlistServer.AdminRouteAccess, controlling whether Alist
+// /api/admin/* routes are reachable through the proxy.
+const (
+	AdminRouteAllow = "allow" // pass through unchanged
+	AdminRouteLocal = "local" // only loopback / private-network clients
+	AdminRouteDeny  = "deny"  // never reachable through the proxy
+)
+
+// NormalizeAdminRouteAccess maps v onto a known AdminRoute* value; anything
+// unrecognised falls back to AdminRouteAllow.
+func NormalizeAdminRouteAccess(v string) string {
+	switch v = strings.ToLower(strings.TrimSpace(v)); v {
+	case AdminRouteLocal, AdminRouteDeny:
+		return v
+	case "block", "off", "false":
+		return AdminRouteDeny
+	default:
+		return AdminRouteAllow
+	}
+}
+
+// NormalizeHTMLRewriteHosts turns htmlRewriteHosts entries ("alist.example.com",
+// "https://alist.example.com/", comma-separated lists) into lower-case
+// host[:port] values without duplicates.
+func NormalizeHTMLRewriteHosts(entries []string) []string {
+	hosts := []string{}
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		for _, host := range strings.Split(entry, ",") {
+			host = strings.ToLower(strings.TrimSpace(host))
+			if i := strings.Index(host, "://"); i >= 0 {
+				host = host[i+3:]
+			}
+			host = strings.TrimPrefix(host, "//")
+			if i := strings.IndexAny(host, "/?#"); i >= 0 {
+				host = host[:i]
+			}
+			if host == "" || seen[host] {
+				continue
+			}
+			seen[host] = true
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// QuietWindow is a daily time range in minutes after local midnight. End
+// before Start wraps past midnight; Start == End covers the whole day.
+type QuietWindow struct {
+	Start int
+	End   int
+}
+
+// ParseQuietWindow parses "HH:MM-HH:MM" (24:00 is accepted as an end).
+func ParseQuietWindow(s string) (QuietWindow, bool) {
+	from, to, ok := strings.Cut(strings.TrimSpace(s), "-")
+	if !ok {
+		return QuietWindow{}, false
+	}
+	start, ok1 := parseClockMinutes(from)
+	end, ok2 := parseClockMinutes(to)
+	if !ok1 || !ok2 || start == 24*60 {
+		return QuietWindow{}, false
+	}
+	return QuietWindow{Start: start, End: end % (24 * 60)}, true
+}
+
+func parseClockMinutes(s string) (int, bool) {
+	var h, m int
+	if n, err := fmt.Sscanf(strings.TrimSpace(s), "%d:%d", &h, &m); err != nil || n != 2 {
+		return 0, false
+	}
+	if h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, false
+	}
+	return h*60 + m, true
+}
+
+// String formats the window the way ParseQuietWindow reads it.
+func (w QuietWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.Start/60, w.Start%60, w.End/60, w.End%60)
+}
+
+// Contains reports whether the local time of t falls inside the window.
+func (w QuietWindow) Contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	switch {
+	case w.Start == w.End:
+		return true
+	case w.Start < w.End:
+		return m >= w.Start && m < w.End
+	default:
+		return m >= w.Start || m < w.End
+	}
+}
+
+// NormalizeQuietHours splits comma-separated quietHours entries, drops the
+// ones that do not parse and formats the rest canonically.
+func NormalizeQuietHours(entries []string) []string {
+	windows := []string{}
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		for _, part := range strings.Split(entry, ",") {
+			if strings.TrimSpace(part) == "" {
+				continue
+			}
+			w, ok := ParseQuietWindow(part)
+			if !ok {
+				log.Warn().Str("window", part).Msg("Ignoring invalid quietHours entry, expected HH:MM-HH:MM")
+				continue
+			}
+			if key := w.String(); !seen[key] {
+				seen[key] = true
+				windows = append(windows, key)
+			}
+		}
+	}
+	return windows
+}
+
+// QuietWindows returns the parsed quietHours.
+func (s *AlistServer) QuietWindows() []QuietWindow {
+	windows := make([]QuietWindow, 0, len(s.QuietHours))
+	for _, entry := range s.QuietHours {
+		if w, ok := ParseQuietWindow(entry); ok {
+			windows = append(windows, w)
+		}
+	}
+	return windows
+}
+
 // getDefaultAlistHost returns the default Alist host based on environment
 func getDefaultAlistHost() string {
 	// Check environment variable first (for Docker deployment)
@@ -234,6 +661,7 @@ func getDefaultAlistPort() int {
 // DefaultConfig returns the default configuration
 func DefaultConfig() *Config {
 	return &Config{
+		ConfigVersion: CurrentConfigVersion,
 		AlistServer: AlistServer{
 			Name:                        "alist",
 			Path:                        "/*",
@@ -243,6 +671,7 @@ func DefaultConfig() *Config {
 			HTTPS:                       false,
 			EnableSizeMap:               true,
 			SizeMapTtlMinutes:           1440,
+			DeletedPathRetentionMinutes: 10,
 			EnableRangeCompatCache:      true,
 			RangeFailToDowngrade:        2,
 			RangeSuccessToRecover:       3,
@@ -254,6 +683,52 @@ func DefaultConfig() *Config {
 			EnableDecryptedBlockCache:   true,
 			DecryptedBlockCacheMb:       128,
 			DecryptedBlockSizeKb:        256,
+			EnableMediaIndex:            false,
+			MediaIndexCacheMb:           64,
+			MediaIndexMaxRegionKb:       8192,
+			MediaIndexMinSizeBytes:      256 * 1024 * 1024,
+			EnablePrefetch:              true,
+			EnableUploadStaging:         false,
+			MaxEncNameBytes:             255,
+			LongNameAction:              LongNameReject,
+			UploadStagingVerifyRetries:  3,
+			EnableSignedRedirect:        false,
+			SignedRedirectTTLSeconds:    3600,
+			SignedRedirectBindIP:        false,
+			SignedRedirectSingleUse:     false,
+			FailoverCheckSeconds:        10,
+			EnableResumeTokens:          true,
+			ResumeTokenMinSizeMb:        1024,
+			ResumeTokenTTLHours:         24,
+			EnableServerTiming:          false,
+			MaxHops:                     3,
+			EnableListCache:             true,
+			ListCacheTTLSeconds:         3,
+			AdminRouteAccess:            AdminRouteAllow,
+			EnableDecodeHealthScan:      false,
+			DecodeHealthIntervalMinutes: 360,
+			DecodeHealthSampleSize:      200,
+			DecodeHealthFailPercent:     5,
+			DecodeHealthWebhook:         "",
+			WebDAVUsers:                 []WebDAVUserMapping{},
+			WebDAVMappedUsersOnly:       false,
+			ForwardedUserHeader:         "",
+			ForwardedUserPaths:          []ForwardedUserPaths{},
+			EnableLocalDirectRead:       false,
+			LocalStorageMounts:          []LocalStorageMount{},
+			CacheControlRules:           []CacheControlRule{},
+			EnableImageResize:           false,
+			ImageCacheMb:                512,
+			ImageMaxSourceMb:            40,
+			RewriteHTMLURLs:             false,
+			HTMLRewriteHosts:            []string{},
+			QuietHours:                  []string{},
+			UploadContentVersion:        2,
+			EnableStaticCache:           false,
+			StaticCacheMb:               64,
+			WebDAVHTMLIndex:             false,
+			WebDAVPropfindLimit:         0,
+			ReadVerifyPercent:           0,
 			FollowRedirectForDecrypt:    true,
 			RedirectMaxHops:             2,
 			AllowLooseDecode:            false,
@@ -328,6 +803,18 @@ func DefaultConfig() *Config {
 			TLSHandshakeSeconds: 10,
 			ResponseHeaderSecs:  15,
 		},
+		Update: &UpdateConfig{
+			Enable:             false,
+			CheckIntervalHours: 24,
+		},
+		StatusProbe: &StatusProbeConfig{
+			Enable:          true,
+			IntervalSeconds: 30,
+			History:         120,
+		},
+		HTTP2: &HTTP2Config{
+			MaxConcurrentStreams: 1000,
+		},
 		Log: &LogConfig{
 			Enable: true,
 			Level:  "info",
@@ -378,10 +865,16 @@ func LoadFresh() *Config {
 // LoadFromBaseDir loads a fresh configuration rooted at the given base directory.
 // It does not touch the package singleton and is suitable for embedded/mobile use.
 func LoadFromBaseDir(baseDir string) *Config {
+	return loadConfigAt(FilePath(baseDir))
+}
+
+// FilePath returns the config.json location under baseDir (the working
+// directory when empty).
+func FilePath(baseDir string) string {
 	if strings.TrimSpace(baseDir) == "" {
 		baseDir = getWorkDir()
 	}
-	return loadConfigAt(filepath.Join(baseDir, "conf", "config.json"))
+	return filepath.Join(baseDir, "conf", "config.json")
 }
 
 func loadConfigAt(configPath string) *Config {
@@ -396,14 +889,7 @@ func loadConfigAt(configPath string) *Config {
 	}
 
 	if data, err := os.ReadFile(configPath); err == nil {
-		if migrated, migratedData := migrateLegacyRangeCompatTTL(data); migrated {
-			data = migratedData
-			if err := writeFileAtomic(configPath, migratedData, 0600); err != nil {
-				log.Warn().Err(err).Msg("Failed to persist migrated range compat config")
-			} else {
-				log.Info().Str("path", configPath).Msg("Migrated legacy rangeCompatTtlMinutes to rangeReprobeMinutes")
-			}
-		}
+		data = migrateConfigFile(configPath, data)
 		if err := json.Unmarshal(data, cfg); err != nil {
 			log.Error().Err(err).Msg("Failed to parse config file")
 		} else {
@@ -414,10 +900,8 @@ func loadConfigAt(configPath string) *Config {
 		cfg.Save()
 	}
 
-	cfg.applyEnvOverrides()
-	cfg.normalizeAlistServerTuning()
+	cfg.applyRuntimeSettings()
 	encryption.SetV2KeyCacheTTL(time.Duration(cfg.AlistServer.V2KeyCacheTTLMinutes) * time.Minute)
-	cfg.normalizeProxyConfig()
 
 	if strings.TrimSpace(cfg.JWTSecret) == "" || cfg.JWTSecret == "alist-encrypt-secret" {
 		secret, err := generateRandomSecret(32)
@@ -455,6 +939,22 @@ func loadConfigAt(configPath string) *Config {
 	return cfg
 }
 
+// applyRuntimeSettings layers the environment and profile over the parsed
+// file and clamps every section, as the server sees it at runtime.
+func (c *Config) applyRuntimeSettings() {
+	c.applyEnvOverrides()
+	c.applyProfile()
+	c.normalizeAlistServerTuning()
+	c.normalizeProxyConfig()
+	c.normalizeHTTP2Config()
+	c.normalizeUpdateConfig()
+	c.normalizeStatusProbeConfig()
+	c.normalizeOIDCConfig()
+	c.normalizeConcurrencyConfig()
+	c.normalizeRateLimitConfig()
+	c.normalizeBandwidthConfig()
+}
+
 func (c *Config) normalizeEncPaths() bool {
 	changed := false
 	if normalizePasswdListEncPaths(c.AlistServer.PasswdList) {
@@ -496,28 +996,46 @@ func normalizePasswdListEncSuffix(list []PasswdInfo) bool {
 	return changed
 }
 
+// Path returns the file the config was loaded from and is saved to.
+func (c *Config) Path() string {
+	if c.configPath == "" {
+		return filepath.Join(getWorkDir(), "conf", "config.json")
+	}
+	return c.configPath
+}
+
 // Save saves configuration to file
 func (c *Config) Save() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	configPath := c.configPath
-	if configPath == "" {
-		configPath = filepath.Join(getWorkDir(), "conf", "config.json")
-	}
+	configPath := c.Path()
 
 	// Create a snapshot for saving (without expanded paths)
 	snapshot := &Config{
-		AlistServer:  c.AlistServer,
-		WebDAVServer: c.WebDAVServer,
-		Port:         c.Port,
-		Scheme:       c.Scheme,
-		Proxy:        c.Proxy,
-		Log:          c.Log,
-		Database:     c.Database,
-		DataDir:      c.DataDir,
-		JWTSecret:    c.JWTSecret,
-		JWTExpire:    c.JWTExpire,
+		ConfigVersion:  c.ConfigVersion,
+		AlistServer:    c.AlistServer,
+		WebDAVServer:   c.WebDAVServer,
+		Port:           c.Port,
+		Scheme:         c.Scheme,
+		Proxy:          c.Proxy,
+		HTTP2:          c.HTTP2,
+		Log:            c.Log,
+		Update:         c.Update,
+		StatusProbe:    c.StatusProbe,
+		OIDC:           c.OIDC,
+		RequestRules:   c.RequestRules,
+		TrustedProxies: c.TrustedProxies,
+		RateLimit:      c.RateLimit,
+		Bandwidth:      c.Bandwidth,
+		Concurrency:    c.Concurrency,
+		Database:       c.Database,
+		DataDir:        c.DataDir,
+		JWTSecret:      c.JWTSecret,
+		JWTExpire:      c.JWTExpire,
+		WebUIDir:       c.WebUIDir,
+		Profile:        c.Profile,
+		NodeCompat:     c.NodeCompat,
 	}
 	snapshot.normalizeEncPaths()
 
@@ -573,6 +1091,41 @@ func (c *Config) applyEnvOverrides() {
 		c.Database.DisableCleanup = v
 	}
 
+	if dir := os.Getenv("WEB_UI_DIR"); dir != "" {
+		c.WebUIDir = dir
+	}
+	if c.Update != nil {
+		if v, ok := getEnvBool("UPDATE_CHECK_ENABLE"); ok {
+			c.Update.Enable = v
+		}
+		if v, ok := getEnvBool("UPDATE_ALLOW_APPLY"); ok {
+			c.Update.AllowApply = v
+		}
+	}
+	if c.StatusProbe != nil {
+		if v, ok := getEnvBool("STATUS_PROBE_ENABLE"); ok {
+			c.StatusProbe.Enable = v
+		}
+		if v, ok := getEnvInt("STATUS_PROBE_INTERVAL"); ok {
+			c.StatusProbe.IntervalSeconds = v
+		}
+	}
+	if profile := os.Getenv("PROFILE"); profile != "" {
+		c.Profile = profile
+	}
+	if v, ok := getEnvBool("NODE_COMPAT"); ok {
+		c.NodeCompat = v
+	}
+
+	if c.Log != nil {
+		if db := os.Getenv("GEOIP_DB"); db != "" {
+			c.Log.GeoIPDB = db
+		}
+		if db := os.Getenv("ASN_DB"); db != "" {
+			c.Log.ASNDB = db
+		}
+	}
+
 	if v, ok := getEnvBool("PROBE_ENABLE"); ok {
 		c.AlistServer.EnableBackgroundProbe = v
 	}
@@ -617,6 +1170,87 @@ func (c *Config) applyEnvOverrides() {
 	if v, ok := getEnvInt("DECRYPTED_BLOCK_SIZE_KB"); ok {
 		c.AlistServer.DecryptedBlockSizeKb = v
 	}
+	if v, ok := getEnvBool("MEDIA_INDEX_ENABLE"); ok {
+		c.AlistServer.EnableMediaIndex = v
+	}
+	if v, ok := getEnvInt("MEDIA_INDEX_CACHE_MB"); ok {
+		c.AlistServer.MediaIndexCacheMb = v
+	}
+	if v, ok := getEnvBool("UPLOAD_STAGING_ENABLE"); ok {
+		c.AlistServer.EnableUploadStaging = v
+	}
+	if v, ok := getEnvBool("REDIRECT_PREWARM_ENABLE"); ok {
+		c.AlistServer.EnableRedirectPrewarm = v
+	}
+	if v, ok := getEnvBool("SIGNED_REDIRECT_ENABLE"); ok {
+		c.AlistServer.EnableSignedRedirect = v
+	}
+	if v, ok := getEnvBool("RESUME_TOKEN_ENABLE"); ok {
+		c.AlistServer.EnableResumeTokens = v
+	}
+	if v, ok := getEnvBool("SERVER_TIMING_ENABLE"); ok {
+		c.AlistServer.EnableServerTiming = v
+	}
+	if v, ok := getEnvInt("MAX_HOPS"); ok {
+		c.AlistServer.MaxHops = v
+	}
+	if v, ok := getEnvInt("SIGNED_REDIRECT_TTL_SECONDS"); ok {
+		c.AlistServer.SignedRedirectTTLSeconds = v
+	}
+	if v, ok := getEnvBool("LIST_CACHE_ENABLE"); ok {
+		c.AlistServer.EnableListCache = v
+	}
+	if v, ok := getEnvInt("LIST_CACHE_TTL_SECONDS"); ok {
+		c.AlistServer.ListCacheTTLSeconds = v
+	}
+	if v := strings.TrimSpace(os.Getenv("ADMIN_ROUTE_ACCESS")); v != "" {
+		c.AlistServer.AdminRouteAccess = v
+	}
+	if v := strings.TrimSpace(os.Getenv("FORWARDED_USER_HEADER")); v != "" {
+		c.AlistServer.ForwardedUserHeader = v
+	}
+	if v, ok := getEnvBool("LOCAL_DIRECT_READ_ENABLE"); ok {
+		c.AlistServer.EnableLocalDirectRead = v
+	}
+	if v, ok := getEnvBool("IMAGE_RESIZE_ENABLE"); ok {
+		c.AlistServer.EnableImageResize = v
+	}
+	if v, ok := getEnvBool("HTML_REWRITE_ENABLE"); ok {
+		c.AlistServer.RewriteHTMLURLs = v
+	}
+	if v := strings.TrimSpace(os.Getenv("HTML_REWRITE_HOSTS")); v != "" {
+		c.AlistServer.HTMLRewriteHosts = []string{v}
+	}
+	if v, ok := getEnvInt("UPLOAD_CONTENT_VERSION"); ok {
+		c.AlistServer.UploadContentVersion = v
+	}
+	if v, ok := getEnvBool("STATIC_CACHE_ENABLE"); ok {
+		c.AlistServer.EnableStaticCache = v
+	}
+	if v, ok := getEnvInt("STATIC_CACHE_MB"); ok {
+		c.AlistServer.StaticCacheMb = v
+	}
+	if v := strings.TrimSpace(os.Getenv("QUIET_HOURS")); v != "" {
+		c.AlistServer.QuietHours = []string{v}
+	}
+	if v, ok := getEnvBool("WEBDAV_HTML_INDEX"); ok {
+		c.AlistServer.WebDAVHTMLIndex = v
+	}
+	if v, ok := getEnvInt("WEBDAV_PROPFIND_LIMIT"); ok {
+		c.AlistServer.WebDAVPropfindLimit = v
+	}
+	if v, ok := getEnvInt("READ_VERIFY_PERCENT"); ok {
+		c.AlistServer.ReadVerifyPercent = v
+	}
+	if v, ok := getEnvBool("DECODE_HEALTH_ENABLE"); ok {
+		c.AlistServer.EnableDecodeHealthScan = v
+	}
+	if v, ok := getEnvInt("DECODE_HEALTH_INTERVAL_MINUTES"); ok {
+		c.AlistServer.DecodeHealthIntervalMinutes = v
+	}
+	if v := strings.TrimSpace(os.Getenv("DECODE_HEALTH_WEBHOOK")); v != "" {
+		c.AlistServer.DecodeHealthWebhook = v
+	}
 	if v, ok := getEnvInt("RANGE_FAIL_TO_DOWNGRADE"); ok {
 		c.AlistServer.RangeFailToDowngrade = v
 	}
@@ -638,6 +1272,7 @@ func (c *Config) applyEnvOverrides() {
 	if v, ok := getEnvInt("STREAM_OVERLOAD_STATUS"); ok {
 		c.AlistServer.StreamOverloadStatus = v
 	}
+	c.applyPrefixedEnv()
 }
 
 func (c *Config) normalizeAlistServerTuning() {
@@ -675,6 +1310,14 @@ func (c *Config) normalizeAlistServerTuning() {
 	if s.ChunkedSeekMaxDiscardBytes > maxDiscard {
 		s.ChunkedSeekMaxDiscardBytes = maxDiscard
 	}
+	if s.WebDAVPropfindLimit < 0 {
+		s.WebDAVPropfindLimit = 0
+	}
+	s.ReadVerifyPercent = clampIntValue(s.ReadVerifyPercent, 0, 100)
+	if s.MaxHops <= 0 {
+		s.MaxHops = 3
+	}
+	s.MaxHops = clampIntValue(s.MaxHops, 1, 16)
 	if s.DecryptedBlockCacheMb <= 0 {
 		s.DecryptedBlockCacheMb = 128
 	}
@@ -683,6 +1326,72 @@ func (c *Config) normalizeAlistServerTuning() {
 		s.DecryptedBlockSizeKb = 256
 	}
 	s.DecryptedBlockSizeKb = clampIntValue(s.DecryptedBlockSizeKb, 32, 4096)
+	if s.MediaIndexCacheMb <= 0 {
+		s.MediaIndexCacheMb = 64
+	}
+	s.MediaIndexCacheMb = clampIntValue(s.MediaIndexCacheMb, 8, 1024)
+	if s.MediaIndexMaxRegionKb <= 0 {
+		s.MediaIndexMaxRegionKb = 8192
+	}
+	s.MediaIndexMaxRegionKb = clampIntValue(s.MediaIndexMaxRegionKb, 256, 65536)
+	if s.MediaIndexMinSizeBytes <= 0 {
+		s.MediaIndexMinSizeBytes = 256 * 1024 * 1024
+	}
+	if s.UploadStagingVerifyRetries <= 0 {
+		s.UploadStagingVerifyRetries = 3
+	}
+	s.MaxEncNameBytes = normalizeMaxNameBytes(s.MaxEncNameBytes)
+	s.LongNameAction = normalizeLongNameAction(s.LongNameAction)
+	s.UploadStagingVerifyRetries = clampIntValue(s.UploadStagingVerifyRetries, 1, 10)
+	if s.SignedRedirectTTLSeconds <= 0 {
+		s.SignedRedirectTTLSeconds = 3600
+	}
+	s.SignedRedirectTTLSeconds = clampIntValue(s.SignedRedirectTTLSeconds, 60, 7*24*3600)
+	if s.FailoverCheckSeconds <= 0 {
+		s.FailoverCheckSeconds = 10
+	}
+	s.FailoverCheckSeconds = clampIntValue(s.FailoverCheckSeconds, 2, 300)
+	if s.ResumeTokenMinSizeMb <= 0 {
+		s.ResumeTokenMinSizeMb = 1024
+	}
+	if s.ResumeTokenTTLHours <= 0 {
+		s.ResumeTokenTTLHours = 24
+	}
+	s.ResumeTokenTTLHours = clampIntValue(s.ResumeTokenTTLHours, 1, 30*24)
+	if s.ListCacheTTLSeconds <= 0 {
+		s.ListCacheTTLSeconds = 3
+	}
+	s.ListCacheTTLSeconds = clampIntValue(s.ListCacheTTLSeconds, 1, 60)
+	s.AdminRouteAccess = NormalizeAdminRouteAccess(s.AdminRouteAccess)
+	if s.ImageCacheMb <= 0 {
+		s.ImageCacheMb = 512
+	}
+	s.ImageCacheMb = clampIntValue(s.ImageCacheMb, 16, 65536)
+	if s.ImageMaxSourceMb <= 0 {
+		s.ImageMaxSourceMb = 40
+	}
+	s.ImageMaxSourceMb = clampIntValue(s.ImageMaxSourceMb, 1, 512)
+	s.HTMLRewriteHosts = NormalizeHTMLRewriteHosts(s.HTMLRewriteHosts)
+	s.QuietHours = NormalizeQuietHours(s.QuietHours)
+	if s.UploadContentVersion != 1 {
+		s.UploadContentVersion = 2
+	}
+	if s.StaticCacheMb <= 0 {
+		s.StaticCacheMb = 64
+	}
+	s.StaticCacheMb = clampIntValue(s.StaticCacheMb, 4, 1024)
+	if s.DecodeHealthIntervalMinutes <= 0 {
+		s.DecodeHealthIntervalMinutes = 360
+	}
+	s.DecodeHealthIntervalMinutes = clampIntValue(s.DecodeHealthIntervalMinutes, 5, 7*24*60)
+	if s.DecodeHealthSampleSize <= 0 {
+		s.DecodeHealthSampleSize = 200
+	}
+	s.DecodeHealthSampleSize = clampIntValue(s.DecodeHealthSampleSize, 10, 5000)
+	if s.DecodeHealthFailPercent <= 0 {
+		s.DecodeHealthFailPercent = 5
+	}
+	s.DecodeHealthFailPercent = clampIntValue(s.DecodeHealthFailPercent, 1, 100)
 	if s.V2KeyCacheTTLMinutes <= 0 {
 		s.V2KeyCacheTTLMinutes = 1440
 	}
@@ -816,6 +1525,67 @@ func (c *Config) normalizeProxyConfig() {
 	p.SelectedProviderIDs = normalizeNoProxyEntries(p.SelectedProviderIDs)
 }
 
+// HTTP/2 frame size bounds from RFC 9113 section 4.2.
+const (
+	minHTTP2FrameSize = 16 << 10
+	maxHTTP2FrameSize = 1<<24 - 1
+)
+
+func (c *Config) normalizeHTTP2Config() {
+	if c == nil {
+		return
+	}
+	if c.HTTP2 == nil {
+		c.HTTP2 = &HTTP2Config{}
+	}
+	h := c.HTTP2
+	if h.MaxConcurrentStreams <= 0 {
+		h.MaxConcurrentStreams = 1000
+	}
+	h.MaxConcurrentStreams = clampIntValue(h.MaxConcurrentStreams, 1, 10000)
+	if h.MaxReadFrameSize > 0 {
+		h.MaxReadFrameSize = clampIntValue(h.MaxReadFrameSize, minHTTP2FrameSize, maxHTTP2FrameSize)
+	}
+	if h.UpstreamMaxReadFrameSize > 0 {
+		h.UpstreamMaxReadFrameSize = clampIntValue(h.UpstreamMaxReadFrameSize, minHTTP2FrameSize, maxHTTP2FrameSize)
+	}
+	// Windows are capped at 1GiB (flow control limit is 2^31-1 bytes).
+	h.ServerConnWindowKb = clampIntValue(h.ServerConnWindowKb, 0, 1<<20)
+	h.ServerStreamWindowKb = clampIntValue(h.ServerStreamWindowKb, 0, 1<<20)
+	h.UpstreamConnWindowKb = clampIntValue(h.UpstreamConnWindowKb, 0, 1<<20)
+	h.UpstreamStreamWindowKb = clampIntValue(h.UpstreamStreamWindowKb, 0, 1<<20)
+}
+
+func (c *Config) normalizeUpdateConfig() {
+	if c == nil {
+		return
+	}
+	if c.Update == nil {
+		c.Update = &UpdateConfig{}
+	}
+	if c.Update.CheckIntervalHours <= 0 {
+		c.Update.CheckIntervalHours = 24
+	}
+	c.Update.CheckIntervalHours = clampIntValue(c.Update.CheckIntervalHours, 1, 24*7)
+}
+
+func (c *Config) normalizeStatusProbeConfig() {
+	if c == nil {
+		return
+	}
+	if c.StatusProbe == nil {
+		c.StatusProbe = &StatusProbeConfig{Enable: true}
+	}
+	if c.StatusProbe.IntervalSeconds <= 0 {
+		c.StatusProbe.IntervalSeconds = 30
+	}
+	c.StatusProbe.IntervalSeconds = clampIntValue(c.StatusProbe.IntervalSeconds, 5, 3600)
+	if c.StatusProbe.History <= 0 {
+		c.StatusProbe.History = 120
+	}
+	c.StatusProbe.History = clampIntValue(c.StatusProbe.History, 10, 2880)
+}
+
 func clampIntValue(v, min, max int) int {
 	if v < min {
 		return min
@@ -835,26 +1605,33 @@ func migrateLegacyRangeCompatTTL(data []byte) (bool, []byte) {
 	if err := json.Unmarshal(data, &raw); err != nil {
 		return false, data
 	}
+	if !migrateRangeCompatTTLRaw(raw) {
+		return false, data
+	}
+
+	out, err := json.MarshalIndent(raw, "", "\t")
+	if err != nil {
+		return false, data
+	}
+	return true, out
+}
+
+func migrateRangeCompatTTLRaw(raw map[string]interface{}) bool {
 	alistRaw, ok := raw["alistServer"].(map[string]interface{})
 	if !ok {
-		return false, data
+		return false
 	}
 
 	oldValue, hasOld := alistRaw["rangeCompatTtlMinutes"]
 	_, hasNew := alistRaw["rangeReprobeMinutes"]
 	if !hasOld {
-		return false, data
+		return false
 	}
 	if !hasNew {
 		alistRaw["rangeReprobeMinutes"] = oldValue
 	}
 	delete(alistRaw, "rangeCompatTtlMinutes")
-
-	out, err := json.MarshalIndent(raw, "", "\t")
-	if err != nil {
-		return false, data
-	}
-	return true, out
+	return true
 }
 
 func getEnvBool(key string) (bool, bool) {
@@ -862,14 +1639,7 @@ func getEnvBool(key string) (bool, bool) {
 	if value == "" {
 		return false, false
 	}
-	switch strings.ToLower(value) {
-	case "1", "true", "yes", "on":
-		return true, true
-	case "0", "false", "no", "off":
-		return false, true
-	default:
-		return false, false
-	}
+	return parseEnvBool(value)
 }
 
 func getEnvInt(key string) (int, bool) {
@@ -904,6 +1674,41 @@ func (c *Config) GetAlistURL() string {
 	return fmt.Sprintf("%s://%s:%d", scheme, c.AlistServer.ServerHost, c.AlistServer.ServerPort)
 }
 
+// GetAlistURLs returns the base URLs of the Alist server: GetAlistURL
+// first, then each failoverHosts entry. An entry without a scheme uses the
+// scheme of the primary address, and one without a port its serverPort.
+func (c *Config) GetAlistURLs() []string {
+	urls := []string{c.GetAlistURL()}
+	for _, host := range c.AlistServer.FailoverHosts {
+		if u, err := c.failoverURL(host); err == nil {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+func (c *Config) failoverURL(host string) (string, error) {
+	host = strings.TrimRight(strings.TrimSpace(host), "/")
+	if !strings.Contains(host, "://") {
+		scheme := "http"
+		if c.AlistServer.HTTPS {
+			scheme = "https"
+		}
+		if _, _, err := net.SplitHostPort(host); err != nil && c.AlistServer.ServerPort != 80 && c.AlistServer.ServerPort != 443 {
+			host = net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(c.AlistServer.ServerPort))
+		}
+		host = scheme + "://" + host
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return "", err
+	}
+	if u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return "", fmt.Errorf("%q is not a host, host:port or http(s) URL", host)
+	}
+	return u.Scheme + "://" + u.Host + strings.TrimRight(u.Path, "/"), nil
+}
+
 // GetHTTPAddr returns the HTTP listen address
 func (c *Config) GetHTTPAddr() string {
 	if c.Scheme != nil {
@@ -930,6 +1735,35 @@ func (c *Config) IsH2CEnabled() bool {
 	return c.Scheme != nil && c.Scheme.EnableH2C
 }
 
+// DrainTimeout returns how long a restart waits for in-flight requests.
+func (c *Config) DrainTimeout() time.Duration {
+	if c.Scheme == nil || c.Scheme.DrainSeconds <= 0 {
+		return DefaultDrainSeconds * time.Second
+	}
+	return time.Duration(c.Scheme.DrainSeconds) * time.Second
+}
+
+// GetSFTPAddr returns the SFTP listen address
+func (c *Config) GetSFTPAddr() string {
+	if !c.IsSFTPEnabled() {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", c.Scheme.Address, c.Scheme.SFTPPort)
+}
+
+// IsSFTPEnabled returns whether the SFTP listener is enabled
+func (c *Config) IsSFTPEnabled() bool {
+	return c.Scheme != nil && c.Scheme.SFTPPort > 0
+}
+
+// GetSFTPHostKeyPath returns the SFTP host key file, defaulting to the conf dir
+func (c *Config) GetSFTPHostKeyPath() string {
+	if c.Scheme != nil && c.Scheme.SFTPHostKey != "" {
+		return c.Scheme.SFTPHostKey
+	}
+	return filepath.Join(c.ConfDir(), "sftp_host_ed25519_key")
+}
+
 // IsUnixSocketEnabled returns whether Unix socket is enabled
 func (c *Config) IsUnixSocketEnabled() bool {
 	return c.Scheme != nil && c.Scheme.UnixFile != ""
@@ -939,11 +1773,17 @@ func (c *Config) IsUnixSocketEnabled() bool {
 func (c *Config) UpdateAlistServer(server AlistServer) error {
 	normalizePasswdListEncPaths(server.PasswdList)
 	c.mu.Lock()
+	if err := checkSelfUpstream(server, c.Scheme, c.Port); err != nil {
+		c.mu.Unlock()
+		return err
+	}
 	c.AlistServer = server
 	c.normalizeAlistServerTuning()
 	c.mu.Unlock()
 
-	return c.Save()
+	err := c.Save()
+	c.notifyApplied()
+	return err
 }
 
 // AddWebDAVServer adds a new WebDAV server config
@@ -982,33 +1822,47 @@ func (c *Config) DeleteWebDAVServer(id string) error {
 	return c.Save()
 }
 
-// UpdateScheme updates scheme configuration and saves
-// Returns true if server restart is required (H2C changed)
+// ConfDir returns the directory holding config.json.
+func (c *Config) ConfDir() string {
+	if c.configPath == "" {
+		return filepath.Join(getWorkDir(), "conf")
+	}
+	return filepath.Dir(c.configPath)
+}
+
+// UpdateScheme swaps in a new scheme configuration and saves it. It returns
+// true when a listener setting changed (see RestartReasons) and the server
+// must restart; any other change is live at once.
 func (c *Config) UpdateScheme(scheme SchemeConfig) (bool, error) {
 	c.mu.Lock()
-	oldH2C := c.Scheme != nil && c.Scheme.EnableH2C
-	newH2C := scheme.EnableH2C
-	needRestart := oldH2C != newH2C
-
-	if c.Scheme == nil {
-		c.Scheme = &SchemeConfig{}
+	if err := checkSelfUpstream(c.AlistServer, &scheme, c.Port); err != nil {
+		c.mu.Unlock()
+		return false, err
 	}
-	*c.Scheme = scheme
+	reasons := RestartReasons(c.Scheme, scheme)
+	next := scheme
+	c.Scheme = &next
 	c.mu.Unlock()
 
-	return needRestart, c.Save()
+	err := c.Save()
+	if len(reasons) > 0 {
+		log.Info().Strs("changed", reasons).Msg("Listener settings changed, restart required")
+		return true, err
+	}
+	c.notifyApplied()
+	return false, err
 }
 
-// UpdateProxy updates proxy configuration and saves.
+// UpdateProxy swaps in a new proxy configuration and saves it.
 func (c *Config) UpdateProxy(proxyCfg ProxyConfig) error {
 	c.mu.Lock()
-	if c.Proxy == nil {
-		c.Proxy = &ProxyConfig{}
-	}
-	*c.Proxy = proxyCfg
+	next := proxyCfg
+	c.Proxy = &next
 	c.normalizeProxyConfig()
 	c.mu.Unlock()
-	return c.Save()
+	err := c.Save()
+	c.notifyApplied()
+	return err
 }
 
 func getWorkDir() string {
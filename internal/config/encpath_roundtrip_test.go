@@ -0,0 +1,48 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestConfigJSONRoundTripKeepsStoredEncPath(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "conf", "config.json")
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	input := []byte(`{"configVersion":3,"jwt_secret":"0123456789abcdef0123456789abcdef","alistServer":{"passwdList":[{"password":"p","encType":"aesctr","enable":true,"encPath":["/movies/*","^/regex/.*$"]}]}}`)
+	if err := os.WriteFile(configPath, input, 0600); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"/movies/*", "^/regex/.*$"}
+
+	cfg := loadConfigAt(configPath)
+	if got := cfg.AlistServer.PasswdList[0].EncPath; !reflect.DeepEqual(got, want) {
+		t.Fatalf("loaded encPath=%v, want %v", got, want)
+	}
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	first, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg = loadConfigAt(configPath)
+	if got := cfg.AlistServer.PasswdList[0].EncPath; !reflect.DeepEqual(got, want) {
+		t.Fatalf("reloaded encPath=%v, want %v", got, want)
+	}
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	second, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Fatalf("config.json changed across load/save:\n%s\n---\n%s", first, second)
+	}
+}
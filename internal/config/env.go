@@ -0,0 +1,239 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/rs/zerolog/log"
+)
+
+// EnvPrefix starts the generic environment overrides. Every config.json
+// field can be set as EnvPrefix plus its JSON path in upper snake case, for
+// example alistServer.serverHost → AEG_ALIST_SERVER_SERVER_HOST and
+// scheme.http_port → AEG_SCHEME_HTTP_PORT. Lists, maps and objects take
+// JSON (AEG_ALIST_SERVER_PASSWD_LIST='[{...}]'); string lists also accept a
+// comma-separated value.
+const EnvPrefix = "AEG_"
+
+// envAliases are short names for the settings Docker/K8s deployments set
+// most often. The full name wins when both are present.
+var envAliases = map[string]string{
+	"AEG_ALIST_HOST":  "AEG_ALIST_SERVER_SERVER_HOST",
+	"AEG_ALIST_PORT":  "AEG_ALIST_SERVER_SERVER_PORT",
+	"AEG_ALIST_HTTPS": "AEG_ALIST_SERVER_HTTPS",
+	"AEG_PASSWD_LIST": "AEG_ALIST_SERVER_PASSWD_LIST",
+	"AEG_HTTP_PORT":   "AEG_SCHEME_HTTP_PORT",
+	"AEG_HTTPS_PORT":  "AEG_SCHEME_HTTPS_PORT",
+	"AEG_ADDRESS":     "AEG_SCHEME_ADDRESS",
+}
+
+// applyPrefixedEnv applies the AEG_* overrides. It runs after the older
+// unprefixed variables so the explicit form wins. Invalid values are logged
+// and skipped rather than failing startup.
+func (c *Config) applyPrefixedEnv() {
+	values := make(map[string]string)
+	for _, kv := range os.Environ() {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(key, EnvPrefix) {
+			continue
+		}
+		values[key] = value
+	}
+	if len(values) == 0 {
+		return
+	}
+	for alias, name := range envAliases {
+		if value, ok := values[alias]; ok {
+			if _, set := values[name]; !set {
+				values[name] = value
+			}
+			delete(values, alias)
+		}
+	}
+
+	applied := applyEnvFields(reflect.ValueOf(c).Elem(), strings.TrimSuffix(EnvPrefix, "_"), values)
+	if len(applied) > 0 {
+		sort.Strings(applied)
+		log.Info().Strs("vars", applied).Msg("Applied environment config overrides")
+	}
+	var unknown []string
+	for key := range values {
+		unknown = append(unknown, key)
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		log.Warn().Strs("vars", unknown).Msg("Ignoring environment variables that match no config field")
+	}
+}
+
+// applyEnvFields sets the fields of the struct v from values and removes the
+// keys it consumed. A key naming a whole object or list replaces it from
+// JSON; otherwise nested structs are walked, allocating nil pointers only
+// when one of their fields is set.
+func applyEnvFields(v reflect.Value, prefix string, values map[string]string) []string {
+	var applied []string
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := jsonFieldName(field)
+		if name == "" {
+			continue
+		}
+		key := prefix + "_" + envName(name)
+		fv := v.Field(i)
+		if raw, ok := values[key]; ok {
+			delete(values, key)
+			if err := setEnvValue(fv, raw); err != nil {
+				log.Warn().Err(err).Str("var", key).Msg("Ignoring invalid environment config override")
+				continue
+			}
+			applied = append(applied, key)
+			continue
+		}
+
+		target := fv.Type()
+		if target.Kind() == reflect.Ptr {
+			target = target.Elem()
+		}
+		if target.Kind() != reflect.Struct || !hasEnvPrefix(values, key+"_") {
+			continue
+		}
+		if fv.Kind() == reflect.Ptr {
+			if fv.IsNil() {
+				fv.Set(reflect.New(target))
+			}
+			fv = fv.Elem()
+		}
+		applied = append(applied, applyEnvFields(fv, key, values)...)
+	}
+	return applied
+}
+
+func hasEnvPrefix(values map[string]string, prefix string) bool {
+	for key := range values {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonFieldName(field reflect.StructField) string {
+	tag := field.Tag.Get("json")
+	if tag == "-" {
+		return ""
+	}
+	if name, _, _ := strings.Cut(tag, ","); name != "" {
+		return name
+	}
+	return field.Name
+}
+
+// envName converts a JSON field name (camelCase or snake_case) to upper
+// snake case: serverHost → SERVER_HOST, http_port → HTTP_PORT,
+// sizeMapTtlMinutes → SIZE_MAP_TTL_MINUTES, webdavHTMLIndex → WEBDAV_HTML_INDEX.
+func envName(name string) string {
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		if r == '-' || r == '.' {
+			r = '_'
+		}
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+func setEnvValue(v reflect.Value, raw string) error {
+	trimmed := strings.TrimSpace(raw)
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Bool:
+		b, ok := parseEnvBool(trimmed)
+		if !ok {
+			return fmt.Errorf("invalid bool %q", raw)
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(trimmed, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(trimmed, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(trimmed, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(trimmed, "[") {
+			list := reflect.MakeSlice(v.Type(), 0, 0)
+			for _, part := range strings.Split(trimmed, ",") {
+				if part = strings.TrimSpace(part); part != "" {
+					list = reflect.Append(list, reflect.ValueOf(part).Convert(v.Type().Elem()))
+				}
+			}
+			v.Set(list)
+			return nil
+		}
+		return setEnvJSON(v, trimmed)
+	default:
+		return setEnvJSON(v, trimmed)
+	}
+	return nil
+}
+
+// setEnvJSON replaces v with the decoded value; a bad value leaves v as is.
+// Objects are decoded over a copy of the current value so fields the JSON
+// leaves out keep their settings, as in config.json.
+func setEnvJSON(v reflect.Value, raw string) error {
+	ptr := reflect.New(v.Type())
+	switch {
+	case v.Kind() == reflect.Struct:
+		ptr.Elem().Set(v)
+	case v.Kind() == reflect.Ptr && !v.IsNil() && v.Type().Elem().Kind() == reflect.Struct:
+		elem := reflect.New(v.Type().Elem())
+		elem.Elem().Set(v.Elem())
+		ptr.Elem().Set(elem)
+	}
+	if err := json.Unmarshal([]byte(raw), ptr.Interface()); err != nil {
+		return err
+	}
+	v.Set(ptr.Elem())
+	return nil
+}
+
+func parseEnvBool(value string) (bool, bool) {
+	switch strings.ToLower(value) {
+	case "1", "true", "yes", "on":
+		return true, true
+	case "0", "false", "no", "off":
+		return false, true
+	default:
+		return false, false
+	}
+}
@@ -52,3 +52,104 @@ func TestApplyEnvOverridesV2KeyCacheTTL(t *testing.T) {
 		t.Fatalf("V2KeyCacheTTLMinutes=%d, want 2880", cfg.AlistServer.V2KeyCacheTTLMinutes)
 	}
 }
+
+func TestApplyEnvOverridesUploadContentVersion(t *testing.T) {
+	cfg := DefaultConfig()
+	if cfg.AlistServer.UploadContentVersion != 2 {
+		t.Fatalf("default UploadContentVersion=%d, want 2", cfg.AlistServer.UploadContentVersion)
+	}
+	t.Setenv("UPLOAD_CONTENT_VERSION", "1")
+	cfg.applyEnvOverrides()
+	cfg.normalizeAlistServerTuning()
+	if cfg.AlistServer.UploadContentVersion != 1 {
+		t.Fatalf("UploadContentVersion=%d, want 1", cfg.AlistServer.UploadContentVersion)
+	}
+
+	cfg.AlistServer.UploadContentVersion = 7
+	cfg.normalizeAlistServerTuning()
+	if cfg.AlistServer.UploadContentVersion != 2 {
+		t.Fatalf("unknown version normalized to %d, want 2", cfg.AlistServer.UploadContentVersion)
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	cases := map[string]string{
+		"serverHost":           "SERVER_HOST",
+		"http_port":            "HTTP_PORT",
+		"sizeMapTtlMinutes":    "SIZE_MAP_TTL_MINUTES",
+		"webdavHTMLIndex":      "WEBDAV_HTML_INDEX",
+		"v2KeyCacheTtlMinutes": "V2_KEY_CACHE_TTL_MINUTES",
+		"https":                "HTTPS",
+	}
+	for in, want := range cases {
+		if got := envName(in); got != want {
+			t.Errorf("envName(%q)=%q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestApplyPrefixedEnvOverrides(t *testing.T) {
+	t.Setenv("AEG_ALIST_HOST", "alist.internal")
+	t.Setenv("AEG_ALIST_SERVER_SERVER_PORT", "5245")
+	t.Setenv("AEG_HTTP_PORT", "8080")
+	t.Setenv("AEG_SCHEME_ENABLE_H2C", "yes")
+	t.Setenv("AEG_PASSWD_LIST", `[{"password":"secret","encType":"aesctr","enable":true,"encPath":["/movies/*"]}]`)
+	t.Setenv("AEG_PROXY_NO_PROXY", "localhost, 10.0.0.0/8")
+	t.Setenv("AEG_DATA_DIR", "/srv/data")
+
+	cfg := DefaultConfig()
+	cfg.applyEnvOverrides()
+
+	if cfg.AlistServer.ServerHost != "alist.internal" || cfg.AlistServer.ServerPort != 5245 {
+		t.Fatalf("alist=%s:%d", cfg.AlistServer.ServerHost, cfg.AlistServer.ServerPort)
+	}
+	if cfg.Scheme.HTTPPort != 8080 || !cfg.Scheme.EnableH2C || cfg.Scheme.HTTPSPort != -1 {
+		t.Fatalf("scheme=%+v", cfg.Scheme)
+	}
+	if len(cfg.AlistServer.PasswdList) != 1 || cfg.AlistServer.PasswdList[0].Password != "secret" ||
+		cfg.AlistServer.PasswdList[0].EncPath[0] != "/movies/*" {
+		t.Fatalf("passwdList=%+v", cfg.AlistServer.PasswdList)
+	}
+	if got := cfg.Proxy.NoProxy; len(got) != 2 || got[0] != "localhost" || got[1] != "10.0.0.0/8" {
+		t.Fatalf("noProxy=%v", got)
+	}
+	if cfg.DataDir != "/srv/data" {
+		t.Fatalf("dataDir=%q", cfg.DataDir)
+	}
+}
+
+func TestApplyPrefixedEnvOverridesWinsAndSkipsInvalid(t *testing.T) {
+	t.Setenv("MAX_HOPS", "5")
+	t.Setenv("AEG_ALIST_SERVER_MAX_HOPS", "7")
+	t.Setenv("AEG_ALIST_SERVER_SERVER_PORT", "not-a-port")
+	t.Setenv("AEG_ALIST_PORT", "1234")
+	t.Setenv("AEG_SCHEME", `{"http_port":9090}`)
+
+	cfg := DefaultConfig()
+	port := cfg.AlistServer.ServerPort
+	cfg.applyEnvOverrides()
+
+	if cfg.AlistServer.MaxHops != 7 {
+		t.Fatalf("MaxHops=%d, want AEG_ value 7", cfg.AlistServer.MaxHops)
+	}
+	// The full name wins over the alias even when its value is invalid.
+	if cfg.AlistServer.ServerPort != port {
+		t.Fatalf("ServerPort=%d, want unchanged %d", cfg.AlistServer.ServerPort, port)
+	}
+	// Objects are decoded over the current value.
+	if cfg.Scheme.HTTPPort != 9090 || cfg.Scheme.Address != "0.0.0.0" {
+		t.Fatalf("scheme=%+v", cfg.Scheme)
+	}
+}
+
+func TestApplyPrefixedEnvAllocatesNilSection(t *testing.T) {
+	t.Setenv("AEG_LOG_LEVEL", "debug")
+
+	cfg := DefaultConfig()
+	cfg.Log = nil
+	cfg.applyEnvOverrides()
+
+	if cfg.Log == nil || cfg.Log.Level != "debug" {
+		t.Fatalf("log=%+v", cfg.Log)
+	}
+}
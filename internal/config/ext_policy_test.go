@@ -0,0 +1,66 @@
+package config
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParsePasswdListExtPolicy(t *testing.T) {
+	list := ParsePasswdList([]interface{}{
+		map[string]interface{}{"password": "a", "extPolicy": "Hide"},
+		map[string]interface{}{"password": "b", "extPolicy": "hide", "encSuffix": "dat"},
+		map[string]interface{}{"password": "c"},
+		map[string]interface{}{"password": "d", "extPolicy": "bogus"},
+	})
+	want := []struct {
+		policy string
+		suffix string
+	}{
+		{ExtPolicyHide, HiddenExtSuffix},
+		{ExtPolicyHide, ".dat"}, // an explicit encSuffix wins
+		{ExtPolicyKeep, ""},
+		{ExtPolicyKeep, ""},
+	}
+	for i, w := range want {
+		if list[i].ExtPolicy != w.policy || list[i].NameSuffix() != w.suffix {
+			t.Fatalf("entry %d: policy=%q suffix=%q, want %q/%q", i, list[i].ExtPolicy, list[i].NameSuffix(), w.policy, w.suffix)
+		}
+	}
+}
+
+func TestNameDialectParseAndValidate(t *testing.T) {
+	list := ParsePasswdList([]interface{}{
+		map[string]interface{}{"password": "a", "nameDialect": "NoPad"},
+		map[string]interface{}{"password": "b", "nameDialect": "default"},
+		map[string]interface{}{"password": "c", "nameDialect": "basename", "extPolicy": "hide"},
+		map[string]interface{}{"password": "d", "nameDialect": "bogus"},
+	})
+	if list[0].NameDialect != "nopad" || list[1].NameDialect != "" {
+		t.Fatalf("dialects not normalized: %q %q", list[0].NameDialect, list[1].NameDialect)
+	}
+	if got := list[0].NameConverter().Dialect; got != "nopad" {
+		t.Fatalf("converter dialect=%q", got)
+	}
+
+	for i := range list {
+		list[i].Enable = true
+		list[i].EncType = "aesctr"
+		list[i].EncPath = []string{fmt.Sprintf("/x%d/*", i)}
+	}
+	issues := validatePasswdList("passwdList", list)
+	var errs, warns []string
+	for _, issue := range issues {
+		switch issue.Severity {
+		case IssueError:
+			errs = append(errs, issue.Field)
+		case IssueWarning:
+			warns = append(warns, issue.Field)
+		}
+	}
+	if len(errs) != 1 || errs[0] != "passwdList[3].nameDialect" {
+		t.Fatalf("errors=%v", errs)
+	}
+	if len(warns) != 1 || warns[0] != "passwdList[2].nameDialect" {
+		t.Fatalf("warnings=%v", warns)
+	}
+}
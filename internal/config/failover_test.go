@@ -0,0 +1,25 @@
+package config
+
+import "testing"
+
+func TestGetAlistURLsAddsFailoverHosts(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AlistServer.ServerHost = "192.168.1.10"
+	cfg.AlistServer.ServerPort = 5244
+	cfg.AlistServer.FailoverHosts = []string{"alist.example.com", "10.8.0.2:8080", "https://wan.example.com/alist/", "ftp://bad"}
+	got := cfg.GetAlistURLs()
+	want := []string{
+		"http://192.168.1.10:5244",
+		"http://alist.example.com:5244",
+		"http://10.8.0.2:8080",
+		"https://wan.example.com/alist",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("urls=%q", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("urls[%d]=%q, want %q", i, got[i], want[i])
+		}
+	}
+}
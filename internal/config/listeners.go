@@ -0,0 +1,79 @@
+package config
+
+import (
+	"net"
+	"strconv"
+
+	"github.com/alist-encrypt-go/internal/pathutil"
+)
+
+// ListenerConfig is one entry of scheme.listeners: an address the server
+// accepts connections on, e.g. 127.0.0.1:5344 for the management UI and
+// 0.0.0.0:5345 serving only "/dav".
+type ListenerConfig struct {
+	Address string `json:"address"`
+	Port    int    `json:"port"`
+	// TLS serves HTTPS with scheme.cert_file / key_file (and
+	// client_ca_file when set).
+	TLS bool `json:"tls,omitempty"`
+	// Paths limits the listener to these path prefixes; other paths get
+	// 404. Empty serves everything.
+	Paths []string `json:"paths,omitempty"`
+}
+
+// Addr returns the host:port to listen on.
+func (l ListenerConfig) Addr() string {
+	return net.JoinHostPort(l.Address, strconv.Itoa(l.Port))
+}
+
+// Serves reports whether the listener answers requests for urlPath.
+func (l ListenerConfig) Serves(urlPath string) bool {
+	if len(l.Paths) == 0 {
+		return true
+	}
+	for _, prefix := range l.Paths {
+		if pathutil.Within(urlPath, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// EffectiveListeners returns scheme.listeners when set. Otherwise it
+// describes the classic pair: http_port (fallbackPort without a scheme)
+// and https_port when HTTPS has a certificate.
+func (s *SchemeConfig) EffectiveListeners(fallbackPort int) []ListenerConfig {
+	if s == nil {
+		return []ListenerConfig{{Address: "0.0.0.0", Port: fallbackPort}}
+	}
+	if len(s.Listeners) > 0 {
+		return s.Listeners
+	}
+	listeners := []ListenerConfig{{Address: s.Address, Port: s.HTTPPort}}
+	if s.HTTPSPort > 0 && s.CertFile != "" && s.KeyFile != "" {
+		listeners = append(listeners, ListenerConfig{Address: s.Address, Port: s.HTTPSPort, TLS: true})
+	}
+	return listeners
+}
+
+// WantsTLS reports whether some listener serves HTTPS, so cert_file and
+// key_file are needed.
+func (s *SchemeConfig) WantsTLS() bool {
+	if s == nil {
+		return false
+	}
+	if len(s.Listeners) == 0 {
+		return s.HTTPSPort > 0
+	}
+	for _, l := range s.Listeners {
+		if l.TLS {
+			return true
+		}
+	}
+	return false
+}
+
+// Listeners returns the HTTP(S) listeners the server starts.
+func (c *Config) Listeners() []ListenerConfig {
+	return c.Scheme.EffectiveListeners(c.Port)
+}
@@ -0,0 +1,33 @@
+package config
+
+import "testing"
+
+func TestEffectiveListeners(t *testing.T) {
+	classic := &SchemeConfig{Address: "0.0.0.0", HTTPPort: 5344, HTTPSPort: 5345, CertFile: "c", KeyFile: "k"}
+	got := classic.EffectiveListeners(0)
+	if len(got) != 2 || got[0].Addr() != "0.0.0.0:5344" || got[0].TLS || got[1].Addr() != "0.0.0.0:5345" || !got[1].TLS {
+		t.Fatalf("classic=%+v", got)
+	}
+	if got := (&SchemeConfig{HTTPPort: 5344, HTTPSPort: 5345}).EffectiveListeners(0); len(got) != 1 {
+		t.Fatalf("https without certificate should not listen: %+v", got)
+	}
+	if got := (*SchemeConfig)(nil).EffectiveListeners(5344); len(got) != 1 || got[0].Port != 5344 {
+		t.Fatalf("nil scheme=%+v", got)
+	}
+	custom := &SchemeConfig{HTTPPort: 5344, HTTPSPort: 5345, Listeners: []ListenerConfig{{Address: "::1", Port: 5400, TLS: true}}}
+	if got := custom.EffectiveListeners(0); len(got) != 1 || got[0].Addr() != "[::1]:5400" || !custom.WantsTLS() {
+		t.Fatalf("listeners=%+v", got)
+	}
+}
+
+func TestListenerServes(t *testing.T) {
+	dav := ListenerConfig{Port: 5345, Paths: []string{"/dav/"}}
+	for path, want := range map[string]bool{"/dav": true, "/dav/a.mkv": true, "/davx": false, "/enc-api/login": false, "/": false} {
+		if got := dav.Serves(path); got != want {
+			t.Errorf("Serves(%q)=%v, want %v", path, got, want)
+		}
+	}
+	if !(ListenerConfig{Port: 5344}).Serves("/enc-api/login") {
+		t.Error("listener without paths should serve everything")
+	}
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+)
+
+// ErrSelfUpstream is returned when alistServer points back at this proxy.
+// Every request would then be proxied to itself until connections run out.
+var ErrSelfUpstream = errors.New("alistServer points back at this proxy")
+
+// CheckSelfUpstream reports whether the configured Alist server is this
+// proxy's own listener: the same port on a local address (loopback, the
+// listen address, the hostname or an interface address). A reverse proxy or
+// DNS name in front of the proxy cannot be recognised here; the loop marker
+// header catches those at the first request instead.
+func (c *Config) CheckSelfUpstream() error {
+	c.mu.RLock()
+	server := c.AlistServer
+	scheme := c.Scheme
+	port := c.Port
+	c.mu.RUnlock()
+	return checkSelfUpstream(server, scheme, port)
+}
+
+func checkSelfUpstream(server AlistServer, scheme *SchemeConfig, fallbackPort int) error {
+	host := strings.Trim(strings.ToLower(strings.TrimSpace(server.ServerHost)), "[]")
+	if host == "" {
+		return nil
+	}
+	upstreamPort := server.ServerPort
+	if upstreamPort <= 0 {
+		upstreamPort = 80
+		if server.HTTPS {
+			upstreamPort = 443
+		}
+	}
+
+	proto := "http"
+	if server.HTTPS {
+		proto = "https"
+	}
+	for _, l := range scheme.EffectiveListeners(fallbackPort) {
+		if l.Port <= 0 || l.Port != upstreamPort || l.TLS != server.HTTPS || !isLocalHost(host, l.Address) {
+			continue
+		}
+		return fmt.Errorf("%w: %s://%s:%d is the %s listener on %s; set serverHost/serverPort to the Alist server itself",
+			ErrSelfUpstream, proto, server.ServerHost, upstreamPort, proto, l.Addr())
+	}
+	return nil
+}
+
+// isLocalHost reports whether host reaches a listener bound to address.
+func isLocalHost(host, address string) bool {
+	address = strings.Trim(strings.ToLower(strings.TrimSpace(address)), "[]")
+	if address != "" && address != "0.0.0.0" && address != "::" {
+		// Bound to one address: only that address (or loopback, for a
+		// loopback listener) reaches it.
+		return host == address || (isLoopbackHost(host) && isLoopbackHost(address))
+	}
+	if isLoopbackHost(host) {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsUnspecified() {
+		return true
+	}
+	if name, err := os.Hostname(); err == nil && strings.EqualFold(name, host) {
+		return true
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func isLoopbackHost(host string) bool {
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
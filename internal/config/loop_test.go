@@ -0,0 +1,52 @@
+package config
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckSelfUpstream(t *testing.T) {
+	scheme := &SchemeConfig{Address: "0.0.0.0", HTTPPort: 5344, HTTPSPort: 5345}
+	tests := []struct {
+		name   string
+		server AlistServer
+		scheme *SchemeConfig
+		loop   bool
+	}{
+		{"loopback same port", AlistServer{ServerHost: "127.0.0.1", ServerPort: 5344}, scheme, true},
+		{"localhost same port", AlistServer{ServerHost: "localhost", ServerPort: 5344}, scheme, true},
+		{"ipv6 loopback", AlistServer{ServerHost: "[::1]", ServerPort: 5344}, scheme, true},
+		{"alist port", AlistServer{ServerHost: "127.0.0.1", ServerPort: 5244}, scheme, false},
+		{"other host", AlistServer{ServerHost: "alist", ServerPort: 5344}, scheme, false},
+		{"https without tls listener", AlistServer{ServerHost: "localhost", ServerPort: 5345, HTTPS: true}, scheme, false},
+		{"bound elsewhere", AlistServer{ServerHost: "127.0.0.1", ServerPort: 5344}, &SchemeConfig{Address: "192.0.2.10", HTTPPort: 5344}, false},
+		{"bound loopback", AlistServer{ServerHost: "localhost", ServerPort: 5344}, &SchemeConfig{Address: "127.0.0.1", HTTPPort: 5344}, true},
+		{"legacy port", AlistServer{ServerHost: "localhost", ServerPort: 5344}, nil, true},
+		{"extra listener", AlistServer{ServerHost: "localhost", ServerPort: 5346}, &SchemeConfig{HTTPPort: 5344, Listeners: []ListenerConfig{
+			{Address: "127.0.0.1", Port: 5344}, {Address: "0.0.0.0", Port: 5346, Paths: []string{"/dav"}},
+		}}, true},
+		{"listeners replace http_port", AlistServer{ServerHost: "localhost", ServerPort: 5344}, &SchemeConfig{HTTPPort: 5344, Listeners: []ListenerConfig{
+			{Address: "127.0.0.1", Port: 5350},
+		}}, false},
+	}
+	for _, tt := range tests {
+		err := checkSelfUpstream(tt.server, tt.scheme, 5344)
+		if got := errors.Is(err, ErrSelfUpstream); got != tt.loop {
+			t.Errorf("%s: err=%v, want loop=%v", tt.name, err, tt.loop)
+		}
+	}
+}
+
+func TestUpdateAlistServerRejectsSelfUpstream(t *testing.T) {
+	cfg := DefaultConfig()
+	before := cfg.AlistServer
+	server := cfg.AlistServer
+	server.ServerHost = "localhost"
+	server.ServerPort = cfg.Scheme.HTTPPort
+	if err := cfg.UpdateAlistServer(server); !errors.Is(err, ErrSelfUpstream) {
+		t.Fatalf("err=%v, want ErrSelfUpstream", err)
+	}
+	if cfg.AlistServer.ServerHost != before.ServerHost || cfg.AlistServer.ServerPort != before.ServerPort {
+		t.Fatalf("rejected update was applied: %+v", cfg.AlistServer)
+	}
+}
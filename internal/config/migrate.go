@@ -0,0 +1,154 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog/log"
+)
+
+// CurrentConfigVersion is the configVersion written by this build. Bump it
+// and append to configMigrations whenever the stored layout changes.
+const CurrentConfigVersion = 3
+
+// configMigration upgrades the raw JSON of a config file from version-1 to
+// version. Migrations run on the decoded map rather than on Config so keys
+// that no longer exist in the struct can still be read.
+type configMigration struct {
+	version int
+	name    string
+	apply   func(raw map[string]interface{})
+}
+
+var configMigrations = []configMigration{
+	{1, "rename rangeCompatTtlMinutes to rangeReprobeMinutes", func(raw map[string]interface{}) { migrateRangeCompatTTLRaw(raw) }},
+	{2, "strip /d /p /dav expansions from stored encPath", migrateExpandedEncPathsRaw},
+	{3, "move port into scheme.http_port", migratePortIntoSchemeRaw},
+}
+
+// migrateConfigData applies every migration newer than the file's
+// configVersion. It returns the upgraded JSON, the version the file was at,
+// and whether anything ran. Files from a newer build are left untouched.
+func migrateConfigData(data []byte) ([]byte, int, bool, error) {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return data, 0, false, err
+	}
+	from := 0
+	if v, ok := raw["configVersion"].(float64); ok {
+		from = int(v)
+	}
+	if from >= CurrentConfigVersion {
+		return data, from, false, nil
+	}
+	for _, m := range configMigrations {
+		if m.version <= from {
+			continue
+		}
+		m.apply(raw)
+		log.Info().Int("version", m.version).Str("migration", m.name).Msg("Applied config migration")
+	}
+	raw["configVersion"] = CurrentConfigVersion
+	out, err := json.MarshalIndent(raw, "", "\t")
+	if err != nil {
+		return data, from, false, err
+	}
+	return out, from, true, nil
+}
+
+// migrateConfigFile upgrades the config file at path in place. The original
+// is kept as config.json.v<N>.bak before the first rewrite from version N, and
+// a file that cannot be migrated is returned unchanged so Load falls back to
+// its usual parsing.
+func migrateConfigFile(path string, data []byte) []byte {
+	out, from, changed, err := migrateConfigData(data)
+	if err != nil {
+		return data
+	}
+	if from > CurrentConfigVersion {
+		log.Warn().Int("configVersion", from).Int("supported", CurrentConfigVersion).
+			Msg("Config file was written by a newer version; some settings may be ignored")
+	}
+	if !changed {
+		return data
+	}
+	backup := fmt.Sprintf("%s.v%d.bak", path, from)
+	if _, statErr := os.Stat(backup); os.IsNotExist(statErr) {
+		if err := writeFileAtomic(backup, data, 0600); err != nil {
+			log.Warn().Err(err).Msg("Failed to back up config before migration, keeping it unmigrated on disk")
+			return out
+		}
+	}
+	if err := writeFileAtomic(path, out, 0600); err != nil {
+		log.Warn().Err(err).Msg("Failed to persist migrated config")
+	} else {
+		log.Info().Str("path", path).Int("from", from).Int("to", CurrentConfigVersion).Msg("Config migrated")
+	}
+	return out
+}
+
+// migrateExpandedEncPathsRaw removes the /d, /p and /dav variants older
+// releases wrote back into encPath, so they are not expanded a second time.
+func migrateExpandedEncPathsRaw(raw map[string]interface{}) {
+	normalizeList := func(server map[string]interface{}) {
+		list, _ := server["passwdList"].([]interface{})
+		for _, item := range list {
+			passwd, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			var paths []string
+			switch v := passwd["encPath"].(type) {
+			case string:
+				paths = []string{v}
+			case []interface{}:
+				for _, p := range v {
+					if s, ok := p.(string); ok {
+						paths = append(paths, s)
+					}
+				}
+			default:
+				continue
+			}
+			normalized := NormalizeUserEncPaths(paths)
+			out := make([]interface{}, len(normalized))
+			for i, p := range normalized {
+				out[i] = p
+			}
+			passwd["encPath"] = out
+		}
+	}
+	if alist, ok := raw["alistServer"].(map[string]interface{}); ok {
+		normalizeList(alist)
+	}
+	if servers, ok := raw["webdavServer"].([]interface{}); ok {
+		for _, s := range servers {
+			if server, ok := s.(map[string]interface{}); ok {
+				normalizeList(server)
+			}
+		}
+	}
+}
+
+// migratePortIntoSchemeRaw copies the legacy top-level port into
+// scheme.http_port, which is what the server actually listens on. port is
+// kept for configs shared with the Node.js version.
+func migratePortIntoSchemeRaw(raw map[string]interface{}) {
+	port, ok := raw["port"].(float64)
+	if !ok || port <= 0 {
+		return
+	}
+	scheme, ok := raw["scheme"].(map[string]interface{})
+	if !ok {
+		raw["scheme"] = map[string]interface{}{
+			"address":    "0.0.0.0",
+			"http_port":  port,
+			"https_port": -1,
+		}
+		return
+	}
+	if v, _ := scheme["http_port"].(float64); v == 0 {
+		scheme["http_port"] = port
+	}
+}
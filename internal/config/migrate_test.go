@@ -0,0 +1,65 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadMigratesLegacyConfigOnce(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "conf", "config.json")
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	legacy := []byte(`{"port":6000,"jwt_secret":"0123456789abcdef0123456789abcdef","alistServer":{"rangeCompatTtlMinutes":45,"passwdList":[{"password":"p","encType":"aesctr","enable":true,"encPath":["/dav/movies/*","/d/movies/*","/movies/*"]}]}}`)
+	if err := os.WriteFile(configPath, legacy, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := loadConfigAt(configPath)
+	if cfg.ConfigVersion != CurrentConfigVersion {
+		t.Fatalf("configVersion=%d, want %d", cfg.ConfigVersion, CurrentConfigVersion)
+	}
+	if cfg.Scheme == nil || cfg.Scheme.HTTPPort != 6000 {
+		t.Fatalf("scheme=%+v, want http_port 6000", cfg.Scheme)
+	}
+	if got := cfg.AlistServer.PasswdList[0].EncPath; len(got) != 1 || got[0] != "/movies/*" {
+		t.Fatalf("encPath=%v, want [/movies/*]", got)
+	}
+
+	backup, err := os.ReadFile(configPath + ".v0.bak")
+	if err != nil || !bytes.Equal(backup, legacy) {
+		t.Fatalf("backup missing or altered: %v", err)
+	}
+
+	first, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw map[string]interface{}
+	if err := json.Unmarshal(first, &raw); err != nil {
+		t.Fatal(err)
+	}
+	if int(raw["configVersion"].(float64)) != CurrentConfigVersion {
+		t.Fatalf("stored configVersion=%v", raw["configVersion"])
+	}
+
+	loadConfigAt(configPath)
+	second, err := os.ReadFile(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Fatalf("second load rewrote an up-to-date config:\n%s\n---\n%s", first, second)
+	}
+}
+
+func TestMigrateConfigDataSkipsNewerVersion(t *testing.T) {
+	input := []byte(`{"configVersion":99,"port":7000}`)
+	out, from, changed, err := migrateConfigData(input)
+	if err != nil || changed || from != 99 || !bytes.Equal(out, input) {
+		t.Fatalf("changed=%v from=%d err=%v", changed, from, err)
+	}
+}
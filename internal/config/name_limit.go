@@ -0,0 +1,53 @@
+package config
+
+import "strings"
+
+// What to do with an upload or rename whose encrypted name is longer than
+// the storage allows.
+const (
+	LongNameReject  = "reject"  // fail the request with NAME_TOO_LONG
+	LongNameShorten = "shorten" // store it under a shortened, hashed name
+)
+
+const (
+	defaultMaxNameBytes = 255
+	// minNameBytes leaves room for a shortened name: an encrypted hash and
+	// a typical extension.
+	minNameBytes = 32
+)
+
+// NameByteLimit returns the longest stored (encrypted) file name rule p may
+// write, or 0 for no limit. The rule's maxNameBytes overrides
+// alistServer.maxEncNameBytes.
+func (c *Config) NameByteLimit(p *PasswdInfo) int {
+	limit := c.AlistServer.MaxEncNameBytes
+	if p != nil && p.MaxNameBytes != 0 {
+		limit = p.MaxNameBytes
+	}
+	if limit < 0 {
+		return 0
+	}
+	return limit
+}
+
+func normalizeMaxNameBytes(n int) int {
+	switch {
+	case n == 0:
+		return defaultMaxNameBytes
+	case n < 0:
+		return -1
+	}
+	return n
+}
+
+func normalizeLongNameAction(action string) string {
+	action = strings.ToLower(strings.TrimSpace(action))
+	if action == "" {
+		return LongNameReject
+	}
+	return action
+}
+
+func isLongNameAction(action string) bool {
+	return action == LongNameReject || action == LongNameShorten
+}
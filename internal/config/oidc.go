@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// OIDCConfig delegates management logins (/enc-api) to an OpenID Connect
+// provider such as Authentik or Keycloak. Users signing in through it get an
+// account of their own whose role follows their provider groups on every
+// login; password logins keep working alongside it.
+type OIDCConfig struct {
+	Enable       bool   `json:"enable"`
+	Issuer       string `json:"issuer"` // discovery base, e.g. https://auth.example.com/application/o/alist-encrypt/
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret,omitempty"`
+	// RedirectURL is the callback registered with the provider. Empty means
+	// <request origin>/enc-api/oidc/callback.
+	RedirectURL string   `json:"redirect_url,omitempty"`
+	Scopes      []string `json:"scopes,omitempty"`         // default openid profile email groups
+	UserClaim   string   `json:"username_claim,omitempty"` // default preferred_username
+	GroupsClaim string   `json:"groups_claim,omitempty"`   // default groups
+	// AdminGroups and OperatorGroups map provider groups to roles; admin
+	// wins when a user is in both.
+	AdminGroups    []string `json:"admin_groups,omitempty"`
+	OperatorGroups []string `json:"operator_groups,omitempty"`
+	// DefaultRole is given to users in none of the groups above. Empty
+	// refuses them.
+	DefaultRole string `json:"default_role,omitempty"`
+	// ButtonText labels the sign-in button on the login page.
+	ButtonText string `json:"button_text,omitempty"`
+}
+
+// OIDCEnabled reports whether management logins can go through OIDC.
+func (c *Config) OIDCEnabled() bool {
+	return c.OIDC != nil && c.OIDC.Enable && c.OIDC.Issuer != "" && c.OIDC.ClientID != ""
+}
+
+// RoleForGroups returns the role for a user in groups, or "" if the user
+// may not sign in.
+func (o *OIDCConfig) RoleForGroups(groups []string) string {
+	in := func(list []string) bool {
+		for _, group := range groups {
+			if slices.Contains(list, group) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case in(o.AdminGroups):
+		return "admin"
+	case in(o.OperatorGroups):
+		return "operator"
+	}
+	return o.DefaultRole
+}
+
+func (c *Config) normalizeOIDCConfig() {
+	if c == nil || c.OIDC == nil {
+		return
+	}
+	o := c.OIDC
+	o.Issuer = strings.TrimRight(strings.TrimSpace(o.Issuer), "/")
+	o.ClientID = strings.TrimSpace(o.ClientID)
+	o.RedirectURL = strings.TrimSpace(o.RedirectURL)
+	if len(o.Scopes) == 0 {
+		o.Scopes = []string{"openid", "profile", "email", "groups"}
+	} else if !slices.Contains(o.Scopes, "openid") {
+		o.Scopes = append([]string{"openid"}, o.Scopes...)
+	}
+	if o.UserClaim == "" {
+		o.UserClaim = "preferred_username"
+	}
+	if o.GroupsClaim == "" {
+		o.GroupsClaim = "groups"
+	}
+	o.DefaultRole = strings.ToLower(strings.TrimSpace(o.DefaultRole))
+}
+
+func validateOIDC(o *OIDCConfig) []Issue {
+	if !o.Enable {
+		return nil
+	}
+	var issues []Issue
+	add := func(severity, field, format string, args ...interface{}) {
+		issues = append(issues, Issue{severity, field, fmt.Sprintf(format, args...)})
+	}
+	if u, err := url.Parse(o.Issuer); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		add(IssueError, "oidc.issuer", "%q is not an http(s) URL; OIDC login stays off", o.Issuer)
+	} else if u.Scheme == "http" {
+		add(IssueWarning, "oidc.issuer", "uses plain http; tokens from the provider can be read on the way")
+	}
+	if o.ClientID == "" {
+		add(IssueError, "oidc.client_id", "empty; OIDC login stays off")
+	}
+	if o.RedirectURL != "" {
+		if u, err := url.Parse(o.RedirectURL); err != nil || u.Host == "" {
+			add(IssueError, "oidc.redirect_url", "%q is not an absolute URL", o.RedirectURL)
+		}
+	}
+	switch o.DefaultRole {
+	case "", "admin", "operator":
+	default:
+		add(IssueError, "oidc.default_role", "%q is not admin or operator", o.DefaultRole)
+	}
+	if len(o.AdminGroups) == 0 && len(o.OperatorGroups) == 0 && o.DefaultRole == "" {
+		add(IssueWarning, "oidc", "no admin_groups, operator_groups or default_role; every OIDC login is refused")
+	}
+	return issues
+}
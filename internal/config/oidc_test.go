@@ -0,0 +1,33 @@
+package config
+
+import "testing"
+
+func TestOIDCRoleForGroupsAndValidate(t *testing.T) {
+	o := &OIDCConfig{AdminGroups: []string{"admins"}, OperatorGroups: []string{"media"}}
+	for groups, want := range map[string]string{"admins": "admin", "media": "operator", "guests": ""} {
+		if got := o.RoleForGroups([]string{"x", groups}); got != want {
+			t.Errorf("%s: role=%q, want %q", groups, got, want)
+		}
+	}
+	if got := o.RoleForGroups([]string{"media", "admins"}); got != "admin" {
+		t.Errorf("admin should win, got %q", got)
+	}
+	o.DefaultRole = "operator"
+	if got := o.RoleForGroups(nil); got != "operator" {
+		t.Errorf("default role=%q", got)
+	}
+
+	cfg := DefaultConfig()
+	cfg.OIDC = &OIDCConfig{Enable: true, Issuer: "https://auth.example.com/realms/home/", ClientID: "encrypt", Scopes: []string{"groups"}, DefaultRole: "Viewer"}
+	cfg.normalizeOIDCConfig()
+	if cfg.OIDC.Issuer != "https://auth.example.com/realms/home" || cfg.OIDC.Scopes[0] != "openid" || cfg.OIDC.UserClaim != "preferred_username" {
+		t.Fatalf("normalized %+v", cfg.OIDC)
+	}
+	if !cfg.OIDCEnabled() {
+		t.Fatal("OIDC not enabled")
+	}
+	issues := validateOIDC(cfg.OIDC)
+	if len(issues) != 1 || issues[0].Field != "oidc.default_role" {
+		t.Fatalf("issues %v", issues)
+	}
+}
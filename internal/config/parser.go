@@ -1,6 +1,13 @@
 package config
 
-import "strings"
+import (
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/alist-encrypt-go/internal/encryption"
+)
 
 // ParsePasswdList parses a raw passwdList from JSON into PasswdInfo slice
 func ParsePasswdList(raw interface{}) []PasswdInfo {
@@ -18,13 +25,24 @@ func ParsePasswdList(raw interface{}) []PasswdInfo {
 		}
 
 		passwd := PasswdInfo{
-			Password:  getStringField(passwdMap, "password"),
-			EncType:   getStringField(passwdMap, "encType"),
-			Describe:  getStringField(passwdMap, "describe"),
-			Enable:    getBoolField(passwdMap, "enable"),
-			EncName:   getBoolField(passwdMap, "encName"),
-			EncSuffix: normalizeEncSuffixField(getStringField(passwdMap, "encSuffix")),
-			EncPath:   getStringArrayField(passwdMap, "encPath"),
+			Password:           getStringField(passwdMap, "password"),
+			EncType:            getStringField(passwdMap, "encType"),
+			Describe:           getStringField(passwdMap, "describe"),
+			Enable:             getBoolField(passwdMap, "enable"),
+			EncName:            getBoolField(passwdMap, "encName"),
+			EncSuffix:          normalizeEncSuffixField(getStringField(passwdMap, "encSuffix")),
+			ExtPolicy:          normalizeExtPolicyField(getStringField(passwdMap, "extPolicy")),
+			EncPath:            getStringArrayField(passwdMap, "encPath"),
+			Strict:             getBoolField(passwdMap, "strict"),
+			StripMetadata:      getBoolField(passwdMap, "stripMetadata"),
+			UploadTransforms:   parseTransformNames(passwdMap["uploadTransforms"]),
+			DownloadTransforms: parseTransformNames(passwdMap["downloadTransforms"]),
+			PasswordVersions:   parsePasswordVersions(passwdMap["passwordVersions"]),
+			NameDialect:        encryption.NormalizeNameDialect(getStringField(passwdMap, "nameDialect")),
+			NameKDF:            encryption.NormalizeNameKDF(getStringField(passwdMap, "nameKdf")),
+			NameKDFPrevious:    encryption.NormalizeNameKDF(getStringField(passwdMap, "nameKdfPrevious")),
+			MaxNameBytes:       getIntField(passwdMap, "maxNameBytes"),
+			AccessWindows:      parseAccessWindows(passwdMap["accessWindows"]),
 		}
 		result = append(result, passwd)
 	}
@@ -81,6 +99,24 @@ func hasField(m map[string]interface{}, key string) bool {
 	return ok
 }
 
+// getRawStringList reads an array or comma-separated string without any
+// path normalization.
+func getRawStringList(m map[string]interface{}, key string) []string {
+	switch v := m[key].(type) {
+	case []interface{}:
+		var result []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	case string:
+		return []string{v}
+	}
+	return nil
+}
+
 func getStringArrayField(m map[string]interface{}, key string) []string {
 	// Handle as array
 	if arr, ok := m[key].([]interface{}); ok {
@@ -99,6 +135,75 @@ func getStringArrayField(m map[string]interface{}, key string) []string {
 	return nil
 }
 
+// parseTransformNames accepts a JSON array or a comma-separated string
+// and returns lower-cased, de-duplicated stage names in their given order.
+// parseAccessWindows reads accessWindows as a list, or as one string with
+// windows separated by ";" (a window's weekday list uses commas).
+func parseAccessWindows(v interface{}) []string {
+	var raw []string
+	switch t := v.(type) {
+	case []interface{}:
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				raw = append(raw, s)
+			}
+		}
+	case string:
+		raw = strings.Split(t, ";")
+	}
+	var windows []string
+	for _, w := range raw {
+		if w = strings.Join(strings.Fields(w), " "); w != "" {
+			windows = append(windows, w)
+		}
+	}
+	return windows
+}
+
+func parseTransformNames(v interface{}) []string {
+	var raw []string
+	switch t := v.(type) {
+	case []interface{}:
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				raw = append(raw, s)
+			}
+		}
+	case string:
+		raw = strings.Split(t, ",")
+	}
+	var names []string
+	for _, name := range raw {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "" && !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// parsePasswordVersions keeps the entries with a usable version (2..255)
+// and password.
+func parsePasswordVersions(v interface{}) []PasswordVersion {
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+	var versions []PasswordVersion
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		entry := PasswordVersion{Version: getIntField(m, "version"), Password: getStringField(m, "password")}
+		if entry.Version < 2 || entry.Version > encryption.MaxPasswordVersion || entry.Password == "" {
+			continue
+		}
+		versions = append(versions, entry)
+	}
+	return versions
+}
+
 func normalizeEncSuffixField(v string) string {
 	v = strings.TrimSpace(v)
 	if v == "" {
@@ -110,6 +215,13 @@ func normalizeEncSuffixField(v string) string {
 	return "." + v
 }
 
+func normalizeExtPolicyField(v string) string {
+	if strings.EqualFold(strings.TrimSpace(v), ExtPolicyHide) {
+		return ExtPolicyHide
+	}
+	return ExtPolicyKeep
+}
+
 // ParseAlistServerFromMap parses an AlistServer from a raw map
 func ParseAlistServerFromMap(raw map[string]interface{}) AlistServer {
 	server := AlistServer{
@@ -122,6 +234,7 @@ func ParseAlistServerFromMap(raw map[string]interface{}) AlistServer {
 		EnableH2C:                   getBoolField(raw, "enableH2c"),
 		EnableSizeMap:               getBoolField(raw, "enableSizeMap"),
 		SizeMapTtlMinutes:           getIntField(raw, "sizeMapTtlMinutes"),
+		DeletedPathRetentionMinutes: getIntFieldWithDefault(raw, "deletedPathRetentionMinutes", 10),
 		EnableRangeCompatCache:      getBoolField(raw, "enableRangeCompatCache"),
 		RangeFailToDowngrade:        getIntField(raw, "rangeFailToDowngrade"),
 		RangeSuccessToRecover:       getIntField(raw, "rangeSuccessToRecover"),
@@ -133,6 +246,50 @@ func ParseAlistServerFromMap(raw map[string]interface{}) AlistServer {
 		EnableDecryptedBlockCache:   getBoolFieldWithDefault(raw, "enableDecryptedBlockCache", true),
 		DecryptedBlockCacheMb:       getIntField(raw, "decryptedBlockCacheMb"),
 		DecryptedBlockSizeKb:        getIntField(raw, "decryptedBlockSizeKb"),
+		EnableMediaIndex:            getBoolField(raw, "enableMediaIndex"),
+		MediaIndexCacheMb:           getIntField(raw, "mediaIndexCacheMb"),
+		MediaIndexMaxRegionKb:       getIntField(raw, "mediaIndexMaxRegionKb"),
+		MediaIndexMinSizeBytes:      getInt64Field(raw, "mediaIndexMinSizeBytes"),
+		EnablePrefetch:              getBoolFieldWithDefault(raw, "enablePrefetch", true),
+		EnableRedirectPrewarm:       getBoolField(raw, "enableRedirectPrewarm"),
+		EnableUploadStaging:         getBoolField(raw, "enableUploadStaging"),
+		UploadStagingVerifyRetries:  getIntField(raw, "uploadStagingVerifyRetries"),
+		MaxEncNameBytes:             getIntField(raw, "maxEncNameBytes"),
+		LongNameAction:              getStringField(raw, "longNameAction"),
+		EnableSignedRedirect:        getBoolField(raw, "enableSignedRedirect"),
+		SignedRedirectTTLSeconds:    getIntField(raw, "signedRedirectTtlSeconds"),
+		SignedRedirectBindIP:        getBoolField(raw, "signedRedirectBindIp"),
+		SignedRedirectSingleUse:     getBoolField(raw, "signedRedirectSingleUse"),
+		FailoverHosts:               getStringArrayField(raw, "failoverHosts"),
+		FailoverCheckSeconds:        getIntField(raw, "failoverCheckSeconds"),
+		EnableResumeTokens:          getBoolFieldWithDefault(raw, "enableResumeTokens", true),
+		ResumeTokenMinSizeMb:        getIntField(raw, "resumeTokenMinSizeMb"),
+		ResumeTokenTTLHours:         getIntField(raw, "resumeTokenTtlHours"),
+		EnableServerTiming:          getBoolField(raw, "enableServerTiming"),
+		MaxHops:                     getIntFieldWithDefault(raw, "maxHops", 3),
+		EnableListCache:             getBoolFieldWithDefault(raw, "enableListCache", true),
+		ListCacheTTLSeconds:         getIntField(raw, "listCacheTtlSeconds"),
+		AdminRouteAccess:            NormalizeAdminRouteAccess(getStringField(raw, "adminRouteAccess")),
+		ForwardedUserHeader:         strings.TrimSpace(getStringField(raw, "forwardedUserHeader")),
+		EnableLocalDirectRead:       getBoolField(raw, "enableLocalDirectRead"),
+		EnableImageResize:           getBoolField(raw, "enableImageResize"),
+		ImageCacheMb:                getIntField(raw, "imageCacheMb"),
+		ImageMaxSourceMb:            getIntField(raw, "imageMaxSourceMb"),
+		RewriteHTMLURLs:             getBoolField(raw, "rewriteHtmlUrls"),
+		HTMLRewriteHosts:            NormalizeHTMLRewriteHosts(getRawStringList(raw, "htmlRewriteHosts")),
+		UploadContentVersion:        getIntField(raw, "uploadContentVersion"),
+		EnableStaticCache:           getBoolField(raw, "enableStaticCache"),
+		StaticCacheMb:               getIntField(raw, "staticCacheMb"),
+		WebDAVHTMLIndex:             getBoolField(raw, "webdavHtmlIndex"),
+		WebDAVPropfindLimit:         getIntField(raw, "webdavPropfindLimit"),
+		ReadVerifyPercent:           getIntField(raw, "readVerifyPercent"),
+		QuietHours:                  NormalizeQuietHours(getRawStringList(raw, "quietHours")),
+		EnableDecodeHealthScan:      getBoolField(raw, "enableDecodeHealthScan"),
+		DecodeHealthIntervalMinutes: getIntField(raw, "decodeHealthIntervalMinutes"),
+		DecodeHealthSampleSize:      getIntField(raw, "decodeHealthSampleSize"),
+		DecodeHealthFailPercent:     getIntField(raw, "decodeHealthFailPercent"),
+		DecodeHealthWebhook:         getStringField(raw, "decodeHealthWebhook"),
+		WebDAVMappedUsersOnly:       getBoolField(raw, "webdavMappedUsersOnly"),
 		FollowRedirectForDecrypt:    getBoolField(raw, "followRedirectForDecrypt"),
 		RedirectMaxHops:             getIntField(raw, "redirectMaxHops"),
 		AllowLooseDecode:            getBoolField(raw, "allowLooseDecode"),
@@ -179,6 +336,18 @@ func ParseAlistServerFromMap(raw map[string]interface{}) AlistServer {
 	if overridesRaw, ok := raw["streamStrategyOverrides"]; ok {
 		server.StreamStrategyOverrides = ParseStreamStrategyOverrides(overridesRaw)
 	}
+	if usersRaw, ok := raw["webdavUsers"]; ok {
+		server.WebDAVUsers = ParseWebDAVUserMappings(usersRaw)
+	}
+	if rulesRaw, ok := raw["forwardedUserPaths"]; ok {
+		server.ForwardedUserPaths = ParseForwardedUserPaths(rulesRaw)
+	}
+	if mountsRaw, ok := raw["localStorageMounts"]; ok {
+		server.LocalStorageMounts = ParseLocalStorageMounts(mountsRaw)
+	}
+	if rulesRaw, ok := raw["cacheControlRules"]; ok {
+		server.CacheControlRules = ParseCacheControlRules(rulesRaw)
+	}
 	if !hasBoolField(raw, "enableRangeCompatCache") {
 		server.EnableRangeCompatCache = true
 	}
@@ -218,6 +387,55 @@ func ParseAlistServerFromMap(raw map[string]interface{}) AlistServer {
 		server.DecryptedBlockSizeKb = 256
 	}
 	server.DecryptedBlockSizeKb = clampInt(server.DecryptedBlockSizeKb, 32, 4096)
+	if server.MediaIndexCacheMb <= 0 {
+		server.MediaIndexCacheMb = 64
+	}
+	server.MediaIndexCacheMb = clampInt(server.MediaIndexCacheMb, 8, 1024)
+	if server.MediaIndexMaxRegionKb <= 0 {
+		server.MediaIndexMaxRegionKb = 8192
+	}
+	server.MediaIndexMaxRegionKb = clampInt(server.MediaIndexMaxRegionKb, 256, 65536)
+	if server.MediaIndexMinSizeBytes <= 0 {
+		server.MediaIndexMinSizeBytes = 256 * 1024 * 1024
+	}
+	if server.UploadStagingVerifyRetries <= 0 {
+		server.UploadStagingVerifyRetries = 3
+	}
+	server.UploadStagingVerifyRetries = clampInt(server.UploadStagingVerifyRetries, 1, 10)
+	server.MaxEncNameBytes = normalizeMaxNameBytes(server.MaxEncNameBytes)
+	server.LongNameAction = normalizeLongNameAction(server.LongNameAction)
+	if server.SignedRedirectTTLSeconds <= 0 {
+		server.SignedRedirectTTLSeconds = 3600
+	}
+	server.SignedRedirectTTLSeconds = clampInt(server.SignedRedirectTTLSeconds, 60, 7*24*3600)
+	if server.FailoverCheckSeconds <= 0 {
+		server.FailoverCheckSeconds = 10
+	}
+	server.FailoverCheckSeconds = clampInt(server.FailoverCheckSeconds, 2, 300)
+	if server.ResumeTokenMinSizeMb <= 0 {
+		server.ResumeTokenMinSizeMb = 1024
+	}
+	if server.ResumeTokenTTLHours <= 0 {
+		server.ResumeTokenTTLHours = 24
+	}
+	server.ResumeTokenTTLHours = clampInt(server.ResumeTokenTTLHours, 1, 30*24)
+	if server.ListCacheTTLSeconds <= 0 {
+		server.ListCacheTTLSeconds = 3
+	}
+	server.ListCacheTTLSeconds = clampInt(server.ListCacheTTLSeconds, 1, 60)
+	if server.DecodeHealthIntervalMinutes <= 0 {
+		server.DecodeHealthIntervalMinutes = 360
+	}
+	server.DecodeHealthIntervalMinutes = clampInt(server.DecodeHealthIntervalMinutes, 5, 7*24*60)
+	if server.DecodeHealthSampleSize <= 0 {
+		server.DecodeHealthSampleSize = 200
+	}
+	server.DecodeHealthSampleSize = clampInt(server.DecodeHealthSampleSize, 10, 5000)
+	if server.DecodeHealthFailPercent <= 0 {
+		server.DecodeHealthFailPercent = 5
+	}
+	server.DecodeHealthFailPercent = clampInt(server.DecodeHealthFailPercent, 1, 100)
+	server.ReadVerifyPercent = clampInt(server.ReadVerifyPercent, 0, 100)
 	if server.V2KeyCacheTTLMinutes <= 0 {
 		server.V2KeyCacheTTLMinutes = 1440
 	}
@@ -272,6 +490,128 @@ func ParseStreamStrategyOverrides(raw interface{}) []StreamStrategyOverride {
 	return result
 }
 
+// ParseWebDAVUserMappings parses webdavUsers entries, skipping incomplete ones.
+func ParseWebDAVUserMappings(raw interface{}) []WebDAVUserMapping {
+	var result []WebDAVUserMapping
+	items, ok := raw.([]interface{})
+	if !ok {
+		return result
+	}
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		mapping := WebDAVUserMapping{
+			Username:      getStringField(m, "username"),
+			Password:      getStringField(m, "password"),
+			AlistUsername: getStringField(m, "alistUsername"),
+			AlistPassword: getStringField(m, "alistPassword"),
+		}
+		if mapping.Username == "" || mapping.Password == "" || mapping.AlistUsername == "" {
+			continue
+		}
+		result = append(result, mapping)
+	}
+	return result
+}
+
+// ParseForwardedUserPaths parses forwardedUserPaths entries, skipping ones
+// without a username. Prefixes are cleaned to start with "/".
+func ParseForwardedUserPaths(raw interface{}) []ForwardedUserPaths {
+	var result []ForwardedUserPaths
+	items, ok := raw.([]interface{})
+	if !ok {
+		return result
+	}
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		rule := ForwardedUserPaths{Username: strings.TrimSpace(getStringField(m, "username"))}
+		if rule.Username == "" {
+			continue
+		}
+		if arr, ok := m["allowPaths"].([]interface{}); ok {
+			for _, v := range arr {
+				if p, ok := v.(string); ok && strings.TrimSpace(p) != "" {
+					rule.AllowPaths = append(rule.AllowPaths, "/"+strings.Trim(strings.TrimSpace(p), "/"))
+				}
+			}
+		}
+		result = append(result, rule)
+	}
+	return result
+}
+
+// ParseLocalStorageMounts parses localStorageMounts entries, skipping ones
+// missing either side of the mapping. Alist paths are cleaned to start with
+// "/" and local roots are made absolute.
+func ParseLocalStorageMounts(raw interface{}) []LocalStorageMount {
+	var result []LocalStorageMount
+	items, ok := raw.([]interface{})
+	if !ok {
+		return result
+	}
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		alistPath := strings.TrimSpace(getStringField(m, "alistPath"))
+		localRoot := strings.TrimSpace(getStringField(m, "localRoot"))
+		if alistPath == "" || localRoot == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(localRoot); err == nil {
+			localRoot = abs
+		}
+		result = append(result, LocalStorageMount{
+			AlistPath: path.Clean("/" + alistPath),
+			LocalRoot: localRoot,
+		})
+	}
+	return result
+}
+
+// ParseCacheControlRules parses cacheControlRules entries, skipping ones
+// without a Cache-Control value. Unknown targets fall back to
+// CacheTargetFile and extensions are lower-cased with a leading dot.
+func ParseCacheControlRules(raw interface{}) []CacheControlRule {
+	var result []CacheControlRule
+	items, ok := raw.([]interface{})
+	if !ok {
+		return result
+	}
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		rule := CacheControlRule{
+			Target:       strings.ToLower(strings.TrimSpace(getStringField(m, "target"))),
+			PathPrefix:   "/" + strings.Trim(strings.TrimSpace(getStringField(m, "pathPrefix")), "/"),
+			CacheControl: strings.TrimSpace(getStringField(m, "cacheControl")),
+		}
+		if rule.CacheControl == "" {
+			continue
+		}
+		if rule.Target != CacheTargetList {
+			rule.Target = CacheTargetFile
+		}
+		if arr, ok := m["extensions"].([]interface{}); ok {
+			for _, v := range arr {
+				if ext, ok := v.(string); ok && strings.TrimSpace(ext) != "" {
+					rule.Extensions = append(rule.Extensions, "."+strings.TrimPrefix(strings.ToLower(strings.TrimSpace(ext)), "."))
+				}
+			}
+		}
+		result = append(result, rule)
+	}
+	return result
+}
+
 // ParseWebDAVServerFromMap parses a WebDAVServer from a raw map
 func ParseWebDAVServerFromMap(raw map[string]interface{}) WebDAVServer {
 	server := WebDAVServer{
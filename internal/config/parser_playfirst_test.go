@@ -1,6 +1,9 @@
 package config
 
-import "testing"
+import (
+	"testing"
+	"time"
+)
 
 func TestParseAlistServerFromMapPlayFirstFallbackDefault(t *testing.T) {
 	raw := map[string]interface{}{
@@ -48,3 +51,37 @@ func TestParseAlistServerFromMapStreamLimitClampsInvalidStatus(t *testing.T) {
 		t.Fatalf("StreamOverloadStatus=%d, want 429", server.StreamOverloadStatus)
 	}
 }
+
+func TestParseAlistServerFromMapHTMLRewriteHosts(t *testing.T) {
+	server := ParseAlistServerFromMap(map[string]interface{}{
+		"name":             "alist",
+		"rewriteHtmlUrls":  true,
+		"htmlRewriteHosts": []interface{}{"https://Alist.Example.com/", "cdn.example.com, alist.example.com", ""},
+	})
+	if !server.RewriteHTMLURLs {
+		t.Fatal("rewriteHtmlUrls not parsed")
+	}
+	want := []string{"alist.example.com", "cdn.example.com"}
+	if len(server.HTMLRewriteHosts) != len(want) || server.HTMLRewriteHosts[0] != want[0] || server.HTMLRewriteHosts[1] != want[1] {
+		t.Fatalf("HTMLRewriteHosts=%v, want %v", server.HTMLRewriteHosts, want)
+	}
+}
+
+func TestParseAlistServerFromMapQuietHours(t *testing.T) {
+	server := ParseAlistServerFromMap(map[string]interface{}{
+		"name":       "alist",
+		"quietHours": []interface{}{"1:00-7:30", "23:00-24:00, bogus", "01:00-07:30"},
+	})
+	want := []string{"01:00-07:30", "23:00-00:00"}
+	if len(server.QuietHours) != len(want) || server.QuietHours[0] != want[0] || server.QuietHours[1] != want[1] {
+		t.Fatalf("QuietHours=%v, want %v", server.QuietHours, want)
+	}
+	late := server.QuietWindows()[1]
+	at := func(h, m int) time.Time { return time.Date(2024, 1, 1, h, m, 0, 0, time.Local) }
+	if !late.Contains(at(23, 59)) || late.Contains(at(0, 0)) || late.Contains(at(22, 59)) {
+		t.Fatalf("window %s boundaries wrong", late)
+	}
+	if w, _ := ParseQuietWindow("22:00-06:00"); !w.Contains(at(2, 0)) || w.Contains(at(6, 0)) {
+		t.Fatal("window across midnight")
+	}
+}
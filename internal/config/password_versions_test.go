@@ -0,0 +1,75 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPasswordVersionsSelectCurrentAndTaggedPassword(t *testing.T) {
+	p := PasswdInfo{Password: "v1"}
+	if pw, v := p.CurrentPassword(); pw != "v1" || v != 1 {
+		t.Fatalf("no versions: got %q/%d", pw, v)
+	}
+	if pw, err := p.ContentPassword(4); err != nil || pw != "v1" {
+		t.Fatalf("single password must serve any tag: %q %v", pw, err)
+	}
+
+	p.PasswordVersions = []PasswordVersion{{Version: 3, Password: "v3"}, {Version: 2, Password: "v2"}, {Version: 4}}
+	if pw, v := p.CurrentPassword(); pw != "v3" || v != 3 {
+		t.Fatalf("current: got %q/%d, want v3/3", pw, v)
+	}
+	for version, want := range map[int]string{0: "v1", 1: "v1", 2: "v2", 3: "v3"} {
+		if pw, err := p.ContentPassword(version); err != nil || pw != want {
+			t.Fatalf("version %d: got %q %v, want %q", version, pw, err, want)
+		}
+	}
+	if _, err := p.ContentPassword(4); err == nil {
+		t.Fatal("expected version without password to fail")
+	}
+}
+
+func TestParsePasswdListReadsPasswordVersions(t *testing.T) {
+	list := ParsePasswdList([]interface{}{map[string]interface{}{
+		"password": "v1",
+		"passwordVersions": []interface{}{
+			map[string]interface{}{"version": float64(2), "password": "v2"},
+			map[string]interface{}{"version": float64(1), "password": "dup"},
+			map[string]interface{}{"version": float64(3)},
+		},
+	}})
+	if len(list) != 1 || len(list[0].PasswordVersions) != 1 || list[0].PasswordVersions[0] != (PasswordVersion{Version: 2, Password: "v2"}) {
+		t.Fatalf("unexpected versions %+v", list)
+	}
+}
+
+func TestValidateReportsBadPasswordVersions(t *testing.T) {
+	issues := validatePasswdList("passwdList", []PasswdInfo{{
+		Password: "v1",
+		EncType:  "aesctr",
+		Enable:   true,
+		EncPath:  []string{"/enc/*"},
+		PasswordVersions: []PasswordVersion{
+			{Version: 1, Password: "x"},
+			{Version: 2, Password: "v2"},
+			{Version: 2, Password: "again"},
+			{Version: 3},
+		},
+	}})
+	var got []string
+	for _, issue := range issues {
+		got = append(got, issue.String())
+	}
+	text := strings.Join(got, "\n")
+	for _, want := range []string{
+		"passwordVersions[0].version: 1 is not between 2 and 255",
+		"passwordVersions[2].version: version 2 is listed twice",
+		"passwordVersions[3].password: empty",
+	} {
+		if !strings.Contains(text, want) {
+			t.Fatalf("missing %q in:\n%s", want, text)
+		}
+	}
+	if len(issues) != 3 {
+		t.Fatalf("expected 3 issues, got:\n%s", text)
+	}
+}
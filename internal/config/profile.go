@@ -0,0 +1,93 @@
+package config
+
+import (
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ProfileEmbedded tunes the proxy for 512MB-RAM routers and NAS boxes.
+const ProfileEmbedded = "embedded"
+
+// applyProfile lowers resource-related settings for the selected profile.
+// Values already below the profile limits are kept, so users can still tune
+// further down; the profile never raises a limit.
+func (c *Config) applyProfile() {
+	if c == nil {
+		return
+	}
+	profile := strings.ToLower(strings.TrimSpace(c.Profile))
+	switch profile {
+	case "", "default":
+		return
+	case ProfileEmbedded:
+		c.applyEmbeddedProfile()
+		log.Info().Str("profile", profile).Msg("Applied resource profile")
+	default:
+		log.Warn().Str("profile", c.Profile).Msg("Unknown config profile, ignoring")
+	}
+}
+
+func (c *Config) applyEmbeddedProfile() {
+	s := &c.AlistServer
+	s.StreamBufferKb = capPositive(s.StreamBufferKb, 128)
+	s.EnableParallelDecrypt = false
+	s.ParallelDecryptConcurrency = capPositive(s.ParallelDecryptConcurrency, 1)
+	s.DecryptedBlockCacheMb = capPositive(s.DecryptedBlockCacheMb, 16)
+	s.DecryptedBlockSizeKb = capPositive(s.DecryptedBlockSizeKb, 128)
+	s.MediaIndexCacheMb = capPositive(s.MediaIndexCacheMb, 8)
+	s.MediaIndexMaxRegionKb = capPositive(s.MediaIndexMaxRegionKb, 2048)
+	s.ImageCacheMb = capPositive(s.ImageCacheMb, 64)
+	s.ImageMaxSourceMb = capPositive(s.ImageMaxSourceMb, 16)
+	s.StaticCacheMb = capPositive(s.StaticCacheMb, 16)
+	s.EnablePrefetch = false
+	s.EnableRedirectPrewarm = false
+	s.MaxActiveStreams = capPositive(s.MaxActiveStreams, 8)
+	s.ScanConcurrency = capPositive(s.ScanConcurrency, 1)
+	s.ProbeConcurrency = capPositive(s.ProbeConcurrency, 1)
+	s.ProbeProviderConcurrency = capPositive(s.ProbeProviderConcurrency, 1)
+	s.ProbeQueueSize = capPositive(s.ProbeQueueSize, 100)
+
+	if cc := c.Concurrency; cc != nil {
+		cc.NameDecryptWorkers = capPositive(cc.NameDecryptWorkers, 1)
+		cc.PrefetchWorkers = capPositive(cc.PrefetchWorkers, 2)
+		cc.SizeResolveWorkers = capPositive(cc.SizeResolveWorkers, 4)
+		cc.JobWorkers = capPositive(cc.JobWorkers, 1)
+		cc.DownloadStreams = capPositive(cc.DownloadStreams, 8)
+		cc.ImageResizeWorkers = capPositive(cc.ImageResizeWorkers, 1)
+	}
+
+	if c.Proxy != nil {
+		c.Proxy.MaxIdleConns = capPositive(c.Proxy.MaxIdleConns, 16)
+		c.Proxy.MaxIdleConnsPerHost = capPositive(c.Proxy.MaxIdleConnsPerHost, 4)
+	}
+
+	if c.HTTP2 == nil {
+		c.HTTP2 = &HTTP2Config{}
+	}
+	h := c.HTTP2
+	h.MaxConcurrentStreams = capPositive(h.MaxConcurrentStreams, 64)
+	// Zero windows keep the small x/net defaults, which already suit this profile.
+	h.ServerConnWindowKb = capNonZero(h.ServerConnWindowKb, 1024)
+	h.ServerStreamWindowKb = capNonZero(h.ServerStreamWindowKb, 256)
+	h.UpstreamConnWindowKb = capNonZero(h.UpstreamConnWindowKb, 1024)
+	h.UpstreamStreamWindowKb = capNonZero(h.UpstreamStreamWindowKb, 256)
+	h.MaxReadFrameSize = capNonZero(h.MaxReadFrameSize, minHTTP2FrameSize)
+	h.UpstreamMaxReadFrameSize = capNonZero(h.UpstreamMaxReadFrameSize, minHTTP2FrameSize)
+}
+
+// capPositive returns max when v is unset (<= 0) or above max.
+func capPositive(v, max int) int {
+	if v <= 0 || v > max {
+		return max
+	}
+	return v
+}
+
+// capNonZero caps v at max but leaves zero ("use default") untouched.
+func capNonZero(v, max int) int {
+	if v > max {
+		return max
+	}
+	return v
+}
@@ -0,0 +1,43 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEmbeddedProfileCapsResources(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "conf", "config.json")
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	data := []byte(`{"profile":"embedded","alistServer":{"streamBufferKb":64,"maxActiveStreams":100,"enableParallelDecrypt":true},"http2":{"max_concurrent_streams":500,"server_conn_window_kb":8192}}`)
+	if err := os.WriteFile(configPath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := loadConfigAt(configPath)
+	s := cfg.AlistServer
+	if s.StreamBufferKb != 64 {
+		t.Fatalf("streamBufferKb=%d, want user value 64 kept", s.StreamBufferKb)
+	}
+	if s.MaxActiveStreams != 8 || s.EnableParallelDecrypt || s.EnablePrefetch {
+		t.Fatalf("maxActiveStreams=%d parallel=%v prefetch=%v", s.MaxActiveStreams, s.EnableParallelDecrypt, s.EnablePrefetch)
+	}
+	if s.DecryptedBlockCacheMb != 16 {
+		t.Fatalf("decryptedBlockCacheMb=%d, want 16", s.DecryptedBlockCacheMb)
+	}
+	if cfg.HTTP2.MaxConcurrentStreams != 64 || cfg.HTTP2.ServerConnWindowKb != 1024 {
+		t.Fatalf("http2=%+v", *cfg.HTTP2)
+	}
+	if cfg.HTTP2.ServerStreamWindowKb != 0 {
+		t.Fatalf("unset stream window should keep default, got %d", cfg.HTTP2.ServerStreamWindowKb)
+	}
+}
+
+func TestDefaultProfileLeavesSettings(t *testing.T) {
+	cfg := loadConfigAt(filepath.Join(t.TempDir(), "conf", "config.json"))
+	if !cfg.AlistServer.EnablePrefetch || cfg.AlistServer.MaxActiveStreams != 32 {
+		t.Fatalf("prefetch=%v maxActiveStreams=%d", cfg.AlistServer.EnablePrefetch, cfg.AlistServer.MaxActiveStreams)
+	}
+}
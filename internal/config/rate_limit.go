@@ -0,0 +1,112 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/alist-encrypt-go/internal/pathutil"
+)
+
+// RateLimitConfig throttles clients before any handler runs, so one client
+// flooding the proxy cannot starve Alist or the CPU spent decrypting. Every
+// limit is a token bucket refilled at rps requests per second and holding
+// up to burst; an rps of 0 leaves that limit off. Requests over a limit are
+// answered 429 with Retry-After.
+type RateLimitConfig struct {
+	Enable bool      `json:"enable"`
+	Global RateLimit `json:"global"` // all clients together
+	PerIP  RateLimit `json:"per_ip"` // each client address
+	// Routes limit requests under a path prefix on top of the above.
+	Routes []RouteRateLimit `json:"routes,omitempty"`
+	// Exempt lists client addresses or CIDRs that are never limited.
+	Exempt []string `json:"exempt,omitempty"`
+}
+
+// RateLimit is one token bucket; Burst 0 means one second's worth of rps.
+type RateLimit struct {
+	RPS   float64 `json:"rps"`
+	Burst int     `json:"burst"`
+}
+
+// RouteRateLimit limits requests whose URL path is Prefix or below it,
+// optionally only for some methods. Each client address gets its own
+// bucket unless Shared is set.
+type RouteRateLimit struct {
+	Prefix  string   `json:"prefix"`
+	Methods []string `json:"methods,omitempty"`
+	RateLimit
+	Shared bool `json:"shared,omitempty"`
+}
+
+// maxRateLimitBurst bounds burst so a typo cannot turn a limit off.
+const maxRateLimitBurst = 100000
+
+// GetRateLimit returns a copy of rate_limit, or nil when it is not enabled.
+func (c *Config) GetRateLimit() *RateLimitConfig {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.RateLimit == nil || !c.RateLimit.Enable {
+		return nil
+	}
+	rl := *c.RateLimit
+	rl.Routes = append([]RouteRateLimit(nil), rl.Routes...)
+	rl.Exempt = append([]string(nil), rl.Exempt...)
+	return &rl
+}
+
+func (c *Config) normalizeRateLimitConfig() {
+	if c == nil || c.RateLimit == nil {
+		return
+	}
+	rl := c.RateLimit
+	rl.Global = normalizeRateLimit(rl.Global)
+	rl.PerIP = normalizeRateLimit(rl.PerIP)
+	for i := range rl.Routes {
+		r := &rl.Routes[i]
+		r.RateLimit = normalizeRateLimit(r.RateLimit)
+		if strings.TrimSpace(r.Prefix) != "" {
+			r.Prefix = pathutil.CleanInput(r.Prefix)
+		}
+		for j, m := range r.Methods {
+			r.Methods[j] = strings.ToUpper(strings.TrimSpace(m))
+		}
+	}
+}
+
+func normalizeRateLimit(l RateLimit) RateLimit {
+	if l.RPS <= 0 || math.IsNaN(l.RPS) || math.IsInf(l.RPS, 0) {
+		return RateLimit{}
+	}
+	if l.Burst <= 0 {
+		l.Burst = int(math.Ceil(l.RPS))
+	}
+	l.Burst = clampIntValue(l.Burst, 1, maxRateLimitBurst)
+	return l
+}
+
+func validateRateLimit(rl *RateLimitConfig) []Issue {
+	if !rl.Enable {
+		return nil
+	}
+	var issues []Issue
+	add := func(severity, field, format string, args ...interface{}) {
+		issues = append(issues, Issue{severity, field, fmt.Sprintf(format, args...)})
+	}
+	if rl.Global.RPS == 0 && rl.PerIP.RPS == 0 && len(rl.Routes) == 0 {
+		add(IssueWarning, "rate_limit", "enabled without any rps; nothing is limited")
+	}
+	for i, r := range rl.Routes {
+		field := fmt.Sprintf("rate_limit.routes[%d]", i)
+		if strings.TrimSpace(r.Prefix) == "" {
+			add(IssueError, field+".prefix", "empty; the route limit is ignored")
+		}
+		if r.RPS == 0 {
+			add(IssueWarning, field+".rps", "0; the route is not limited")
+		}
+	}
+	if _, err := ParseIPNets(rl.Exempt); err != nil {
+		add(IssueError, "rate_limit.exempt", "%v; the list is ignored", err)
+	}
+	return issues
+}
@@ -0,0 +1,53 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRateLimitNormalize(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RateLimit = &RateLimitConfig{
+		Enable: true,
+		Global: RateLimit{RPS: 2.5},
+		PerIP:  RateLimit{RPS: -1, Burst: 10},
+		Routes: []RouteRateLimit{{Prefix: "d/", Methods: []string{" get"}, RateLimit: RateLimit{RPS: 1, Burst: 1 << 30}}},
+	}
+	cfg.normalizeRateLimitConfig()
+
+	rl := cfg.GetRateLimit()
+	if rl.Global != (RateLimit{RPS: 2.5, Burst: 3}) {
+		t.Fatalf("global = %+v, want burst rounded up to 3", rl.Global)
+	}
+	if rl.PerIP != (RateLimit{}) {
+		t.Fatalf("per_ip = %+v, want off", rl.PerIP)
+	}
+	r := rl.Routes[0]
+	if r.Prefix != "/d" || r.Methods[0] != "GET" || r.Burst != maxRateLimitBurst {
+		t.Fatalf("route = %+v", r)
+	}
+
+	cfg.RateLimit.Enable = false
+	if cfg.GetRateLimit() != nil {
+		t.Fatal("disabled rate_limit returned")
+	}
+}
+
+func TestValidateRateLimit(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.RateLimit = &RateLimitConfig{
+		Enable: true,
+		Routes: []RouteRateLimit{{RateLimit: RateLimit{RPS: 1}}, {Prefix: "/p"}},
+		Exempt: []string{"10.0.0.0/8", "lan"},
+	}
+	var found []string
+	for _, issue := range cfg.Validate() {
+		if strings.HasPrefix(issue.Field, "rate_limit") {
+			found = append(found, issue.Severity+" "+issue.Field)
+		}
+	}
+	want := []string{"error rate_limit.routes[0].prefix", "warning rate_limit.routes[1].rps", "error rate_limit.exempt"}
+	if strings.Join(found, ",") != strings.Join(want, ",") {
+		t.Fatalf("issues = %v, want %v", found, want)
+	}
+}
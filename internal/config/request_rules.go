@@ -0,0 +1,285 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Request rules are checked in order before a request reaches its handler;
+// the first enabled rule whose conditions all hold decides what happens to
+// it. They gather the special cases operators used to ask for (skip
+// decryption for one client, keep a folder away from a device, send big
+// downloads straight to Alist) into entries that can be read, saved and
+// tried out through the management API.
+
+// Actions for RequestRule.
+const (
+	RuleActionEncrypt     = "encrypt"         // handle normally, with the passwdList entry named by Passwd
+	RuleActionPassthrough = "passthrough"     // forward to Alist untouched, no encryption either way
+	RuleActionBlock       = "block"           // answer Status (default 403)
+	RuleActionRedirect    = "redirect_direct" // redirect the client to Alist itself
+)
+
+// RequestRule matches requests on path, method, headers and client address.
+// Empty conditions match everything. Path is a prefix of the URL path or of
+// the display path the request works on (/d/movies/a.mkv and
+// /dav/movies/a.mkv both work on /movies/a.mkv); with a "*" it must match the
+// whole path instead. Header values match case-insensitively, "*" standing
+// for any run of characters, so {"User-Agent": "*Infuse*"} matches Infuse
+// and {"X-Token": "*"} only requires the header to be present.
+type RequestRule struct {
+	Name      string            `json:"name"`
+	Disabled  bool              `json:"disabled,omitempty"`
+	Path      string            `json:"path,omitempty"`
+	Methods   []string          `json:"methods,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	ClientIPs []string          `json:"client_ips,omitempty"` // addresses or CIDRs
+	Action    string            `json:"action"`
+	// Passwd names the passwdList entry (by describe) used by "encrypt".
+	Passwd string `json:"passwd,omitempty"`
+	// Status is the answer of "block"; 403 when unset.
+	Status int `json:"status,omitempty"`
+	// RedirectBase is where "redirect_direct" sends clients, e.g. the public
+	// address of Alist; the configured Alist server when unset.
+	RedirectBase string `json:"redirect_base,omitempty"`
+}
+
+// RequestRuleInput is what a request rule sees of a request.
+type RequestRuleInput struct {
+	Method      string
+	URLPath     string
+	DisplayPath string // "" when the request does not work on a storage path
+	Header      http.Header
+	ClientIP    string
+}
+
+// RequestRuleSet is a compiled list of request rules.
+type RequestRuleSet struct {
+	rules []compiledRequestRule
+}
+
+type compiledRequestRule struct {
+	rule    RequestRule
+	index   int
+	path    *regexp.Regexp // set when Path has a wildcard
+	methods map[string]bool
+	headers map[string]*regexp.Regexp
+	nets    []*net.IPNet
+}
+
+// CompileRequestRules prepares rules for matching. Rules with errors are
+// left out and reported; disabled rules are skipped silently.
+func CompileRequestRules(rules []RequestRule) (*RequestRuleSet, []error) {
+	set := &RequestRuleSet{}
+	var errs []error
+	for i, rule := range rules {
+		if rule.Disabled {
+			continue
+		}
+		compiled, err := compileRequestRule(i, rule)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("request_rules[%d] %q: %w", i, rule.Name, err))
+			continue
+		}
+		set.rules = append(set.rules, compiled)
+	}
+	return set, errs
+}
+
+func compileRequestRule(index int, rule RequestRule) (compiledRequestRule, error) {
+	rule.Action = strings.ToLower(strings.TrimSpace(rule.Action))
+	compiled := compiledRequestRule{rule: rule, index: index}
+	switch rule.Action {
+	case RuleActionEncrypt:
+		if strings.TrimSpace(rule.Passwd) == "" {
+			return compiled, fmt.Errorf("action encrypt needs passwd")
+		}
+	case RuleActionBlock:
+		if rule.Status != 0 && (rule.Status < 400 || rule.Status > 599) {
+			return compiled, fmt.Errorf("status %d is not an HTTP error status", rule.Status)
+		}
+	case RuleActionPassthrough, RuleActionRedirect:
+	default:
+		return compiled, fmt.Errorf("unknown action %q (use encrypt, passthrough, block or redirect_direct)", rule.Action)
+	}
+	if strings.Contains(rule.Path, "*") {
+		compiled.path = globRegexp(rule.Path, false)
+	}
+	if len(rule.Methods) > 0 {
+		compiled.methods = make(map[string]bool, len(rule.Methods))
+		for _, m := range rule.Methods {
+			compiled.methods[strings.ToUpper(strings.TrimSpace(m))] = true
+		}
+	}
+	if len(rule.Headers) > 0 {
+		compiled.headers = make(map[string]*regexp.Regexp, len(rule.Headers))
+		for name, value := range rule.Headers {
+			compiled.headers[http.CanonicalHeaderKey(name)] = globRegexp(value, true)
+		}
+	}
+	nets, err := ParseIPNets(rule.ClientIPs)
+	if err != nil {
+		return compiled, fmt.Errorf("client_ips: %v", err)
+	}
+	compiled.nets = nets
+	return compiled, nil
+}
+
+// ParseIPNets parses a list of addresses and CIDRs; a bare address
+// matches only itself.
+func ParseIPNets(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, raw := range list {
+		raw = strings.TrimSpace(raw)
+		if !strings.Contains(raw, "/") {
+			ip := net.ParseIP(raw)
+			if ip == nil {
+				return nil, fmt.Errorf("%q is not an address or CIDR", raw)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an address or CIDR", raw)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// globRegexp turns a pattern where "*" matches any run of characters into an
+// anchored regexp.
+func globRegexp(pattern string, foldCase bool) *regexp.Regexp {
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	expr := "^" + strings.Join(parts, ".*") + "$"
+	if foldCase {
+		expr = "(?i)" + expr
+	}
+	return regexp.MustCompile(expr)
+}
+
+// Len returns the number of active rules.
+func (s *RequestRuleSet) Len() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.rules)
+}
+
+// Match returns the first rule matching in and its index in request_rules.
+func (s *RequestRuleSet) Match(in RequestRuleInput) (RequestRule, int, bool) {
+	if s == nil {
+		return RequestRule{}, -1, false
+	}
+	for _, r := range s.rules {
+		if r.matches(in) {
+			return r.rule, r.index, true
+		}
+	}
+	return RequestRule{}, -1, false
+}
+
+func (r compiledRequestRule) matches(in RequestRuleInput) bool {
+	if r.rule.Path != "" && !r.matchPath(in.URLPath) && (in.DisplayPath == "" || !r.matchPath(in.DisplayPath)) {
+		return false
+	}
+	if r.methods != nil && !r.methods[strings.ToUpper(in.Method)] {
+		return false
+	}
+	for name, value := range r.headers {
+		if !slices.ContainsFunc(in.Header.Values(name), value.MatchString) {
+			return false
+		}
+	}
+	if len(r.nets) > 0 {
+		ip := net.ParseIP(in.ClientIP)
+		if ip == nil {
+			return false
+		}
+		found := false
+		for _, n := range r.nets {
+			if n.Contains(ip) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func (r compiledRequestRule) matchPath(p string) bool {
+	if r.path != nil {
+		return r.path.MatchString(p)
+	}
+	prefix := strings.TrimSuffix(r.rule.Path, "/")
+	return prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// RequestRulePasswd returns the passwdList entry an encrypt rule names.
+func (c *Config) RequestRulePasswd(describe string) (*PasswdInfo, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for i := range c.AlistServer.PasswdList {
+		if c.AlistServer.PasswdList[i].Describe == describe {
+			info := c.AlistServer.PasswdList[i]
+			return &info, true
+		}
+	}
+	return nil, false
+}
+
+// UpdateRequestRules replaces request_rules and saves them. Rules that do not
+// compile are rejected as a whole so a typo cannot silently drop a block.
+func (c *Config) UpdateRequestRules(rules []RequestRule) error {
+	if _, errs := CompileRequestRules(rules); len(errs) > 0 {
+		return errs[0]
+	}
+	c.mu.Lock()
+	c.RequestRules = rules
+	c.mu.Unlock()
+	err := c.Save()
+	c.notifyApplied()
+	return err
+}
+
+// GetRequestRules returns a copy of request_rules.
+func (c *Config) GetRequestRules() []RequestRule {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]RequestRule{}, c.RequestRules...)
+}
+
+func validateRequestRules(c *Config) []Issue {
+	var issues []Issue
+	for i, rule := range c.RequestRules {
+		field := fmt.Sprintf("request_rules[%d]", i)
+		if rule.Disabled {
+			continue
+		}
+		if _, err := compileRequestRule(i, rule); err != nil {
+			issues = append(issues, Issue{IssueError, field, err.Error() + "; the rule is ignored"})
+			continue
+		}
+		if strings.EqualFold(strings.TrimSpace(rule.Action), RuleActionEncrypt) {
+			if _, ok := c.RequestRulePasswd(rule.Passwd); !ok {
+				issues = append(issues, Issue{IssueError, field + ".passwd", fmt.Sprintf("no alistServer.passwdList entry is described %q", rule.Passwd)})
+			}
+		}
+	}
+	return issues
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRequestRulesFirstMatchWins(t *testing.T) {
+	set, errs := CompileRequestRules([]RequestRule{
+		{Name: "off", Disabled: true, Action: RuleActionBlock},
+		{Name: "lan uploads", Path: "/inbox", Methods: []string{"put"}, ClientIPs: []string{"192.168.1.0/24"}, Action: RuleActionEncrypt, Passwd: "inbox"},
+		{Name: "infuse", Path: "/movies/*.mkv", Headers: map[string]string{"user-agent": "*infuse*"}, Action: RuleActionRedirect},
+		{Name: "tokened", Headers: map[string]string{"X-Token": "*"}, Action: RuleActionPassthrough},
+		{Name: "private", Path: "/private/", Action: RuleActionBlock, Status: 404},
+	})
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	header := func(kv ...string) http.Header {
+		h := http.Header{}
+		for i := 0; i+1 < len(kv); i += 2 {
+			h.Set(kv[i], kv[i+1])
+		}
+		return h
+	}
+	cases := []struct {
+		name string
+		in   RequestRuleInput
+		want string
+	}{
+		{"upload from lan", RequestRuleInput{Method: "PUT", URLPath: "/dav/inbox/a.jpg", DisplayPath: "/inbox/a.jpg", ClientIP: "192.168.1.20"}, "lan uploads"},
+		{"upload from wan", RequestRuleInput{Method: "PUT", URLPath: "/dav/inbox/a.jpg", DisplayPath: "/inbox/a.jpg", ClientIP: "8.8.8.8"}, ""},
+		{"prefix is segment aligned", RequestRuleInput{Method: "PUT", URLPath: "/dav/inboxes/a", DisplayPath: "/inboxes/a", ClientIP: "192.168.1.20"}, ""},
+		{"glob and header", RequestRuleInput{Method: "GET", URLPath: "/d/movies/a.mkv", DisplayPath: "/movies/a.mkv", Header: header("User-Agent", "Infuse/7.6")}, "infuse"},
+		{"glob needs whole path", RequestRuleInput{Method: "GET", URLPath: "/d/movies/a.mkv.srt", DisplayPath: "/movies/a.mkv.srt", Header: header("User-Agent", "Infuse/7.6")}, ""},
+		{"header presence", RequestRuleInput{Method: "GET", URLPath: "/api/me", Header: header("X-Token", "")}, "tokened"},
+		{"url path prefix", RequestRuleInput{Method: "GET", URLPath: "/private/x"}, "private"},
+		{"display path prefix", RequestRuleInput{Method: "GET", URLPath: "/p/private/x", DisplayPath: "/private/x"}, "private"},
+	}
+	for _, tc := range cases {
+		if tc.in.Header == nil {
+			tc.in.Header = http.Header{}
+		}
+		rule, _, ok := set.Match(tc.in)
+		got := ""
+		if ok {
+			got = rule.Name
+		}
+		if got != tc.want {
+			t.Errorf("%s: matched %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCompileRequestRulesReportsBadRules(t *testing.T) {
+	set, errs := CompileRequestRules([]RequestRule{
+		{Name: "typo", Action: "blok"},
+		{Name: "no passwd", Action: RuleActionEncrypt},
+		{Name: "bad cidr", Action: RuleActionBlock, ClientIPs: []string{"10.0.0.0/33"}},
+		{Name: "bad status", Action: RuleActionBlock, Status: 200},
+		{Name: "ok", Action: RuleActionBlock, ClientIPs: []string{"10.0.0.1", "fd00::/8"}},
+	})
+	if len(errs) != 4 || set.Len() != 1 {
+		t.Fatalf("errs=%v active=%d, want 4 errors and 1 rule", errs, set.Len())
+	}
+	if !strings.Contains(errs[0].Error(), `request_rules[0] "typo"`) {
+		t.Fatalf("error does not name the rule: %v", errs[0])
+	}
+	if _, _, ok := set.Match(RequestRuleInput{ClientIP: "10.0.0.1", Header: http.Header{}}); !ok {
+		t.Fatal("single address did not match")
+	}
+	if _, _, ok := set.Match(RequestRuleInput{ClientIP: "10.0.0.2", Header: http.Header{}}); ok {
+		t.Fatal("single address matched a neighbour")
+	}
+}
+
+func TestValidateReportsRequestRulePasswd(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AlistServer.PasswdList = []PasswdInfo{{Describe: "inbox", Password: "p", EncType: "aesctr", Enable: true, EncPath: []string{"/inbox/*"}}}
+	cfg.RequestRules = []RequestRule{
+		{Name: "good", Action: RuleActionEncrypt, Passwd: "inbox"},
+		{Name: "missing", Action: RuleActionEncrypt, Passwd: "outbox"},
+	}
+	var found []string
+	for _, issue := range cfg.Validate() {
+		if strings.HasPrefix(issue.Field, "request_rules") {
+			found = append(found, issue.Field)
+		}
+	}
+	if len(found) != 1 || found[0] != "request_rules[1].passwd" {
+		t.Fatalf("request rule issues = %v", found)
+	}
+}
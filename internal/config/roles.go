@@ -0,0 +1,56 @@
+package config
+
+// Operators manage the library: the passwd lists and the cache settings of
+// the Alist and WebDAV backends. Where the upstream lives, how it is
+// authenticated, listeners, TLS and JWT stay with administrators; the
+// management API keeps those fields from the running config when an operator
+// saves.
+
+// UpdateAlistServerAsOperator applies the operator-managed sections of
+// submitted (passwdList and the cache settings) to the running Alist server
+// config and saves it. Every other field keeps its current value.
+func (c *Config) UpdateAlistServerAsOperator(submitted AlistServer) error {
+	c.mu.RLock()
+	next := c.AlistServer
+	c.mu.RUnlock()
+	applyOperatorSections(&next, submitted)
+	return c.UpdateAlistServer(next)
+}
+
+// UpdateWebDAVPasswdList replaces only the passwd list of the WebDAV server
+// with the given id, for operators.
+func (c *Config) UpdateWebDAVPasswdList(id string, list []PasswdInfo) error {
+	normalizePasswdListEncPaths(list)
+	c.mu.Lock()
+	for i := range c.WebDAVServer {
+		if c.WebDAVServer[i].ID == id {
+			c.WebDAVServer[i].PasswdList = list
+			break
+		}
+	}
+	c.mu.Unlock()
+	return c.Save()
+}
+
+func applyOperatorSections(dst *AlistServer, src AlistServer) {
+	dst.PasswdList = src.PasswdList
+
+	dst.EnableSizeMap = src.EnableSizeMap
+	dst.SizeMapTtlMinutes = src.SizeMapTtlMinutes
+	dst.DeletedPathRetentionMinutes = src.DeletedPathRetentionMinutes
+	dst.EnableRangeCompatCache = src.EnableRangeCompatCache
+	dst.EnableDecryptedBlockCache = src.EnableDecryptedBlockCache
+	dst.DecryptedBlockCacheMb = src.DecryptedBlockCacheMb
+	dst.DecryptedBlockSizeKb = src.DecryptedBlockSizeKb
+	dst.EnableMediaIndex = src.EnableMediaIndex
+	dst.MediaIndexCacheMb = src.MediaIndexCacheMb
+	dst.MediaIndexMaxRegionKb = src.MediaIndexMaxRegionKb
+	dst.MediaIndexMinSizeBytes = src.MediaIndexMinSizeBytes
+	dst.EnableListCache = src.EnableListCache
+	dst.ListCacheTTLSeconds = src.ListCacheTTLSeconds
+	dst.CacheControlRules = src.CacheControlRules
+	dst.ImageCacheMb = src.ImageCacheMb
+	dst.EnableStaticCache = src.EnableStaticCache
+	dst.StaticCacheMb = src.StaticCacheMb
+	dst.NegativeCacheMinutes = src.NegativeCacheMinutes
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOperatorUpdatesKeepAdminSections(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "conf", "config.json")
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		t.Fatal(err)
+	}
+	cfg := loadConfigAt(configPath)
+	cfg.WebDAVServer = []WebDAVServer{{ID: "w1", ServerHost: "dav.lan", ServerPort: 8080}}
+
+	submitted := cfg.AlistServer
+	submitted.ServerHost = "evil.example"
+	submitted.ServerPort = 1
+	submitted.ScanPassword = "changed"
+	submitted.PasswdList = []PasswdInfo{{Password: "p", EncType: "aesctr", EncPath: []string{"/movies/*"}, Enable: true}}
+	submitted.StaticCacheMb = 96
+	if err := cfg.UpdateAlistServerAsOperator(submitted); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.AlistServer.ServerHost == "evil.example" || cfg.AlistServer.ServerPort == 1 || cfg.AlistServer.ScanPassword == "changed" {
+		t.Fatalf("operator changed admin fields: %+v", cfg.AlistServer)
+	}
+	if len(cfg.AlistServer.PasswdList) != 1 || cfg.AlistServer.StaticCacheMb != 96 {
+		t.Fatalf("operator sections not applied: passwdList=%v staticCacheMb=%d", cfg.AlistServer.PasswdList, cfg.AlistServer.StaticCacheMb)
+	}
+
+	list := []PasswdInfo{{Password: "q", EncType: "rc4", EncPath: []string{"/tv/*"}, Enable: true}}
+	if err := cfg.UpdateWebDAVPasswdList("w1", list); err != nil {
+		t.Fatal(err)
+	}
+	if got := cfg.WebDAVServer[0]; got.ServerHost != "dav.lan" || got.ServerPort != 8080 || len(got.PasswdList) != 1 {
+		t.Fatalf("webdav server=%+v", got)
+	}
+}
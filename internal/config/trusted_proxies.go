@@ -0,0 +1,48 @@
+package config
+
+import (
+	"fmt"
+	"net"
+)
+
+// defaultTrustedProxies are trusted when trusted_proxies is empty: a
+// reverse proxy on the same host, and nothing a remote client controls.
+var defaultTrustedProxies = []string{"127.0.0.0/8", "::1"}
+
+// GetTrustedProxies returns the addresses and CIDRs whose X-Forwarded-*
+// headers are believed, defaulting to loopback.
+func (c *Config) GetTrustedProxies() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.TrustedProxies) == 0 {
+		return append([]string(nil), defaultTrustedProxies...)
+	}
+	return append([]string(nil), c.TrustedProxies...)
+}
+
+func validateTrustedProxies(list []string) []Issue {
+	var issues []Issue
+	add := func(severity, field, format string, args ...interface{}) {
+		issues = append(issues, Issue{severity, field, fmt.Sprintf(format, args...)})
+	}
+	nets, err := ParseIPNets(list)
+	if err != nil {
+		add(IssueError, "trusted_proxies", "%v; only loopback is trusted", err)
+		return issues
+	}
+	for i, n := range nets {
+		if ones, _ := n.Mask.Size(); ones == 0 {
+			add(IssueWarning, fmt.Sprintf("trusted_proxies[%d]", i), "%s trusts every client; anyone can forge their address with X-Forwarded-For", n)
+		}
+	}
+	return issues
+}
+
+// TrustedProxyNets parses list, falling back to loopback when it is invalid.
+func TrustedProxyNets(list []string) []*net.IPNet {
+	nets, err := ParseIPNets(list)
+	if err != nil || len(nets) == 0 {
+		nets, _ = ParseIPNets(defaultTrustedProxies)
+	}
+	return nets
+}
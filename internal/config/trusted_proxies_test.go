@@ -0,0 +1,37 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	cfg := DefaultConfig()
+	if got := cfg.GetTrustedProxies(); strings.Join(got, ",") != "127.0.0.0/8,::1" {
+		t.Fatalf("default = %v, want loopback", got)
+	}
+	if nets := TrustedProxyNets([]string{"proxy.lan"}); len(nets) != 2 {
+		t.Fatalf("invalid list parsed to %v, want the loopback fallback", nets)
+	}
+
+	cfg.TrustedProxies = []string{"10.0.0.0/8", "0.0.0.0/0", "proxy.lan"}
+	var found []string
+	for _, issue := range cfg.Validate() {
+		if strings.HasPrefix(issue.Field, "trusted_proxies") {
+			found = append(found, issue.Severity+" "+issue.Field)
+		}
+	}
+	if strings.Join(found, ",") != "error trusted_proxies" {
+		t.Fatalf("issues = %v", found)
+	}
+	cfg.TrustedProxies = cfg.TrustedProxies[:2]
+	found = nil
+	for _, issue := range cfg.Validate() {
+		if strings.HasPrefix(issue.Field, "trusted_proxies") {
+			found = append(found, issue.Severity+" "+issue.Field)
+		}
+	}
+	if strings.Join(found, ",") != "warning trusted_proxies[1]" {
+		t.Fatalf("issues = %v", found)
+	}
+}
@@ -0,0 +1,27 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParsePasswdListUploadTransforms(t *testing.T) {
+	list := ParsePasswdList([]interface{}{
+		map[string]interface{}{"password": "a", "uploadTransforms": []interface{}{" Checksum ", "strip_metadata", "checksum"}},
+		map[string]interface{}{"password": "b", "uploadTransforms": "checksum, ,compress", "stripMetadata": true},
+		map[string]interface{}{"password": "c", "downloadTransforms": []interface{}{"Watermark"}},
+	})
+	want := [][]string{
+		{"checksum", UploadTransformStripMetadata},
+		{"checksum", "compress", UploadTransformStripMetadata},
+		nil,
+	}
+	for i, w := range want {
+		if got := list[i].UploadTransformNames(); !slices.Equal(got, w) {
+			t.Fatalf("entry %d: pipeline %v, want %v", i, got, w)
+		}
+	}
+	if !slices.Equal(list[2].DownloadTransforms, []string{"watermark"}) {
+		t.Fatalf("download pipeline %v", list[2].DownloadTransforms)
+	}
+}
@@ -0,0 +1,342 @@
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/encryption"
+)
+
+// Severities of an Issue.
+const (
+	IssueError   = "error"   // the server fails or misbehaves
+	IssueWarning = "warning" // works, probably not as intended
+)
+
+// certExpiryWarning is how early an expiring certificate is reported.
+const certExpiryWarning = 30 * 24 * time.Hour
+
+// Issue is one problem found while validating a config.
+type Issue struct {
+	Severity string
+	Field    string // JSON path, e.g. alistServer.passwdList[0].encPath[1]
+	Message  string
+}
+
+func (i Issue) String() string {
+	if i.Field == "" {
+		return fmt.Sprintf("%s: %s", i.Severity, i.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", i.Severity, i.Field, i.Message)
+}
+
+// LoadForValidation reads the config at path the way the server would
+// (migrations, environment overrides, profile and clamping) without
+// writing anything back. A file that cannot be read or parsed is reported
+// as an issue along with the defaults the server would fall back to.
+func LoadForValidation(path string) (*Config, []Issue) {
+	var issues []Issue
+	cfg := DefaultConfig()
+	cfg.configPath = path
+
+	data, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		issues = append(issues, Issue{IssueWarning, "", fmt.Sprintf("%s does not exist; the server would create it with defaults", path)})
+	case err != nil:
+		issues = append(issues, Issue{IssueError, "", fmt.Sprintf("cannot read %s: %v", path, err)})
+	default:
+		// Positions are reported against the file as written, before
+		// migrations reformat it.
+		if err := json.Unmarshal(data, DefaultConfig()); err != nil {
+			var syntaxErr *json.SyntaxError
+			consequence := "the server would ignore this value"
+			if errors.As(err, &syntaxErr) {
+				consequence = "the server would ignore the file and run with defaults"
+			}
+			issues = append(issues, Issue{IssueError, "", describeJSONError(data, err) + "; " + consequence})
+		}
+		if migrated, _, _, err := migrateConfigData(data); err == nil {
+			data = migrated
+		}
+		// Like the server, keep whatever decoded before an error.
+		_ = json.Unmarshal(data, cfg)
+	}
+
+	cfg.applyRuntimeSettings()
+	if cfg.Scheme == nil {
+		cfg.Scheme = &SchemeConfig{Address: "0.0.0.0", HTTPPort: cfg.Port, HTTPSPort: -1}
+	} else if cfg.Scheme.HTTPPort == 0 {
+		cfg.Scheme.HTTPPort = cfg.Port
+	}
+	return cfg, issues
+}
+
+// FileErrors loads the config at path like LoadForValidation and returns
+// its errors, the issues a restart must not run into. Warnings are left to
+// `config validate`.
+func FileErrors(path string) []Issue {
+	cfg, issues := LoadForValidation(path)
+	var errs []Issue
+	for _, issue := range append(issues, cfg.Validate()...) {
+		if issue.Severity == IssueError {
+			errs = append(errs, issue)
+		}
+	}
+	return errs
+}
+
+// describeJSONError points at the line and column of a syntax or type error.
+func describeJSONError(data []byte, err error) string {
+	var offset int64 = -1
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+		if typeErr.Field != "" {
+			err = fmt.Errorf("%s must be %s, not %s", typeErr.Field, typeErr.Type, typeErr.Value)
+		}
+	}
+	if offset < 0 || offset > int64(len(data)) {
+		return fmt.Sprintf("invalid JSON: %v", err)
+	}
+	before := data[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := int(offset) - bytes.LastIndexByte(before, '\n')
+	return fmt.Sprintf("invalid JSON at line %d, column %d: %v", line, col, err)
+}
+
+// Validate checks the settings the server would otherwise only trip over at
+// request time: the Alist address, every passwdList rule, the request rules,
+// the TLS certificate and a proxy pointing at itself. It does not touch the
+// network.
+func (c *Config) Validate() []Issue {
+	var issues []Issue
+	add := func(severity, field, format string, args ...interface{}) {
+		issues = append(issues, Issue{severity, field, fmt.Sprintf(format, args...)})
+	}
+
+	if strings.TrimSpace(c.AlistServer.ServerHost) == "" {
+		add(IssueError, "alistServer.serverHost", "empty; set the Alist host (or ALIST_HOST)")
+	}
+	if p := c.AlistServer.ServerPort; p < 1 || p > 65535 {
+		add(IssueError, "alistServer.serverPort", "%d is not a valid port", p)
+	}
+	if !isLongNameAction(c.AlistServer.LongNameAction) {
+		add(IssueError, "alistServer.longNameAction", "%q is not supported (use reject or shorten)", c.AlistServer.LongNameAction)
+	}
+	if n := c.AlistServer.MaxEncNameBytes; n > 0 && n < minNameBytes {
+		add(IssueError, "alistServer.maxEncNameBytes", "%d is below %d; use -1 to turn the check off", n, minNameBytes)
+	}
+	if n := c.AlistServer.DeletedPathRetentionMinutes; n < 0 {
+		add(IssueError, "alistServer.deletedPathRetentionMinutes", "%d is negative; use 0 to turn tombstones off", n)
+	}
+	for i, host := range c.AlistServer.FailoverHosts {
+		if _, err := c.failoverURL(host); err != nil {
+			add(IssueError, fmt.Sprintf("alistServer.failoverHosts[%d]", i), "%v; the entry is ignored", err)
+		}
+	}
+	issues = append(issues, validatePasswdList("alistServer.passwdList", c.AlistServer.PasswdList)...)
+	for i, server := range c.WebDAVServer {
+		issues = append(issues, validatePasswdList(fmt.Sprintf("webdavServer[%d].passwdList", i), server.PasswdList)...)
+	}
+
+	issues = append(issues, validateRequestRules(c)...)
+
+	if c.Scheme != nil {
+		issues = append(issues, validateScheme(c.Scheme)...)
+	}
+	if c.OIDC != nil {
+		issues = append(issues, validateOIDC(c.OIDC)...)
+	}
+	issues = append(issues, validateTrustedProxies(c.TrustedProxies)...)
+	if c.RateLimit != nil {
+		issues = append(issues, validateRateLimit(c.RateLimit)...)
+	}
+	if c.Bandwidth != nil {
+		issues = append(issues, validateBandwidth(c.Bandwidth)...)
+	}
+	if err := c.CheckSelfUpstream(); err != nil {
+		add(IssueError, "alistServer", "%v", err)
+	}
+	if c.Database != nil && strings.TrimSpace(c.Database.Type) != "" && strings.TrimSpace(c.Database.DSN) == "" {
+		add(IssueWarning, "database.dsn", "database.type is %q but dsn is empty; BoltDB is used instead", c.Database.Type)
+	}
+	return issues
+}
+
+func validatePasswdList(field string, list []PasswdInfo) []Issue {
+	var issues []Issue
+	add := func(severity, field, format string, args ...interface{}) {
+		issues = append(issues, Issue{severity, field, fmt.Sprintf(format, args...)})
+	}
+	owner := make(map[string]int)
+	for i, p := range list {
+		rule := fmt.Sprintf("%s[%d]", field, i)
+		if !p.Enable {
+			continue
+		}
+		if p.Password == "" {
+			add(IssueError, rule+".password", "empty; files under this rule cannot be decrypted")
+		}
+		if !encryption.IsSupportedEncType(p.EncType) {
+			add(IssueError, rule+".encType", "%q is not supported (use aesctr, rc4md5, chacha20, aesgcm or xchacha20poly1305)", p.EncType)
+		}
+		if !encryption.IsSupportedNameDialect(p.NameDialect) {
+			add(IssueError, rule+".nameDialect", "%q is not supported (use default, nopad or basename)", p.NameDialect)
+		} else if encryption.NormalizeNameDialect(p.NameDialect) == encryption.NameDialectBaseName && p.NameSuffix() != "" {
+			add(IssueWarning, rule+".nameDialect", "basename keeps extensions in clear; encSuffix and extPolicy hide are ignored")
+		}
+		if !encryption.IsSupportedNameKDF(p.NameKDF) {
+			add(IssueError, rule+".nameKdf", "%q is not supported (use legacy, pbkdf2-600k or argon2id)", p.NameKDF)
+		}
+		if !encryption.IsSupportedNameKDF(p.NameKDFPrevious) {
+			add(IssueError, rule+".nameKdfPrevious", "%q is not supported (use legacy, pbkdf2-600k or argon2id)", p.NameKDFPrevious)
+		}
+		if p.MaxNameBytes < -1 || (p.MaxNameBytes > 0 && p.MaxNameBytes < minNameBytes) {
+			add(IssueError, rule+".maxNameBytes", "%d is not -1, 0 or at least %d", p.MaxNameBytes, minNameBytes)
+		}
+		for j, window := range p.AccessWindows {
+			if _, err := ParseAccessWindow(window); err != nil {
+				add(IssueError, fmt.Sprintf("%s.accessWindows[%d]", rule, j), "%q: %v", window, err)
+			}
+		}
+		seen := map[int]bool{1: true}
+		for j, v := range p.PasswordVersions {
+			entry := fmt.Sprintf("%s.passwordVersions[%d]", rule, j)
+			switch {
+			case v.Version < 2 || v.Version > encryption.MaxPasswordVersion:
+				add(IssueError, entry+".version", "%d is not between 2 and %d (password is version 1)", v.Version, encryption.MaxPasswordVersion)
+			case seen[v.Version]:
+				add(IssueError, entry+".version", "version %d is listed twice", v.Version)
+			case v.Password == "":
+				add(IssueError, entry+".password", "empty; files tagged with version %d cannot be decrypted", v.Version)
+			}
+			seen[v.Version] = true
+		}
+		matched := false
+		for j, pattern := range p.EncPath {
+			if strings.TrimSpace(pattern) == "" {
+				continue
+			}
+			matched = true
+			if err := encryption.CheckPathPattern(pattern); err != nil {
+				add(IssueWarning, fmt.Sprintf("%s.encPath[%d]", rule, j), "%q: %v", pattern, err)
+			}
+			if first, ok := owner[pattern]; ok {
+				add(IssueWarning, fmt.Sprintf("%s.encPath[%d]", rule, j), "%q is also used by %s[%d], which takes precedence", pattern, field, first)
+			} else {
+				owner[pattern] = i
+			}
+		}
+		if !matched {
+			add(IssueWarning, rule+".encPath", "no paths; the rule never applies")
+		}
+	}
+	return issues
+}
+
+func validateScheme(s *SchemeConfig) []Issue {
+	var issues []Issue
+	add := func(severity, field, format string, args ...interface{}) {
+		issues = append(issues, Issue{severity, field, fmt.Sprintf(format, args...)})
+	}
+	if s.HTTPPort < 0 || s.HTTPPort > 65535 {
+		add(IssueError, "scheme.http_port", "%d is not a valid port", s.HTTPPort)
+	}
+	if s.HTTPSPort > 65535 {
+		add(IssueError, "scheme.https_port", "%d is not a valid port", s.HTTPSPort)
+	}
+	if s.HTTPSPort > 0 && s.HTTPSPort == s.HTTPPort {
+		add(IssueError, "scheme.https_port", "same as http_port (%d)", s.HTTPPort)
+	}
+	if s.DrainSeconds < 0 {
+		add(IssueError, "scheme.drain_seconds", "%d is negative; use 0 for the default of %d", s.DrainSeconds, DefaultDrainSeconds)
+	}
+	seen := make(map[string]bool, len(s.Listeners))
+	for i, l := range s.Listeners {
+		field := fmt.Sprintf("scheme.listeners[%d]", i)
+		if l.Port <= 0 || l.Port > 65535 {
+			add(IssueError, field+".port", "%d is not a valid port", l.Port)
+		} else if seen[l.Addr()] {
+			add(IssueError, field, "%s is listed more than once", l.Addr())
+		}
+		seen[l.Addr()] = true
+		for _, prefix := range l.Paths {
+			if !strings.HasPrefix(prefix, "/") {
+				add(IssueError, field+".paths", "%q must start with /", prefix)
+			}
+		}
+	}
+	if len(s.Listeners) > 0 && s.HTTPSPort > 0 {
+		add(IssueWarning, "scheme.https_port", "ignored while listeners are set; add a listener with \"tls\": true instead")
+	}
+	if !s.WantsTLS() {
+		if s.ClientCAFile != "" {
+			add(IssueWarning, "scheme.client_ca_file", "client certificates are only checked on HTTPS listeners, and none is configured")
+		}
+		return issues
+	}
+	if s.ClientCAFile != "" {
+		if _, err := LoadClientCAs(s.ClientCAFile); err != nil {
+			add(IssueError, "scheme.client_ca_file", "%v", err)
+		}
+		for _, l := range s.EffectiveListeners(0) {
+			if !l.TLS && l.Port > 0 {
+				add(IssueWarning, "scheme.client_ca_file", "%s serves plain HTTP without client certificates; disable it unless only a trusted reverse proxy can reach it", l.Addr())
+			}
+		}
+	}
+	for _, prefix := range s.ClientAuthExempt {
+		if !strings.HasPrefix(prefix, "/") {
+			add(IssueError, "scheme.client_auth_exempt", "%q must start with /", prefix)
+		}
+	}
+
+	if s.CertFile == "" || s.KeyFile == "" {
+		switch {
+		case s.AutoSelfSigned:
+		case len(s.Listeners) > 0:
+			add(IssueError, "scheme.cert_file", "a tls listener needs cert_file/key_file (set them, or auto_self_signed)")
+		default:
+			add(IssueError, "scheme.cert_file", "https_port is %d but cert_file/key_file are not set; HTTPS stays off (set them, or auto_self_signed)", s.HTTPSPort)
+		}
+		return issues
+	}
+	_, certErr := os.Stat(s.CertFile)
+	_, keyErr := os.Stat(s.KeyFile)
+	if s.AutoSelfSigned && errors.Is(certErr, os.ErrNotExist) && errors.Is(keyErr, os.ErrNotExist) {
+		add(IssueWarning, "scheme.cert_file", "%s does not exist yet; a self-signed certificate will be generated on startup", s.CertFile)
+		return issues
+	}
+	pair, err := tls.LoadX509KeyPair(s.CertFile, s.KeyFile)
+	if err != nil {
+		add(IssueError, "scheme.cert_file", "cannot load certificate %s with key %s: %v", s.CertFile, s.KeyFile, err)
+		return issues
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		add(IssueError, "scheme.cert_file", "cannot parse certificate %s: %v", s.CertFile, err)
+		return issues
+	}
+	now := time.Now()
+	switch {
+	case now.After(leaf.NotAfter):
+		add(IssueError, "scheme.cert_file", "certificate expired on %s", leaf.NotAfter.Format(time.DateOnly))
+	case leaf.NotAfter.Sub(now) < certExpiryWarning:
+		add(IssueWarning, "scheme.cert_file", "certificate expires on %s", leaf.NotAfter.Format(time.DateOnly))
+	case now.Before(leaf.NotBefore):
+		add(IssueWarning, "scheme.cert_file", "certificate is not valid before %s", leaf.NotBefore.Format(time.DateOnly))
+	}
+	return issues
+}
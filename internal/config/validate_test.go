@@ -0,0 +1,133 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/certgen"
+)
+
+func issueFields(issues []Issue, severity string) []string {
+	var fields []string
+	for _, issue := range issues {
+		if issue.Severity == severity {
+			fields = append(fields, issue.Field)
+		}
+	}
+	return fields
+}
+
+func TestValidatePasswdList(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.AlistServer.ServerHost = "alist.example"
+	cfg.AlistServer.PasswdList = []PasswdInfo{
+		{Enable: true, Password: "a", EncType: "aesctr", EncPath: []string{"/movies/*", "/bad/(2024"}},
+		{Enable: true, Password: "", EncType: "mix", EncPath: []string{"/movies/*"}},
+		{Enable: true, Password: "c", EncType: "aesctr"},
+		{Enable: false, Password: "", EncType: "mix"},
+	}
+	issues := cfg.Validate()
+
+	errs := strings.Join(issueFields(issues, IssueError), " ")
+	for _, want := range []string{"passwdList[1].password", "passwdList[1].encType"} {
+		if !strings.Contains(errs, want) {
+			t.Errorf("errors %q missing %s", errs, want)
+		}
+	}
+	if strings.Contains(errs, "passwdList[3]") {
+		t.Errorf("disabled rule validated: %q", errs)
+	}
+	warns := strings.Join(issueFields(issues, IssueWarning), " ")
+	for _, want := range []string{"passwdList[0].encPath[1]", "passwdList[1].encPath[0]", "passwdList[2].encPath"} {
+		if !strings.Contains(warns, want) {
+			t.Errorf("warnings %q missing %s", warns, want)
+		}
+	}
+}
+
+func TestValidateScheme(t *testing.T) {
+	dir := t.TempDir()
+	certPath, keyPath, err := certgen.WriteFiles(dir, certgen.Options{Hosts: []string{"localhost"}, Validity: 10 * 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("WriteFiles: %v", err)
+	}
+
+	issues := validateScheme(&SchemeConfig{HTTPPort: 5344, HTTPSPort: 5345, CertFile: certPath, KeyFile: keyPath})
+	if len(issues) != 1 || issues[0].Severity != IssueWarning || !strings.Contains(issues[0].Message, "expires") {
+		t.Fatalf("expiring cert: %v", issues)
+	}
+	issues = validateScheme(&SchemeConfig{HTTPPort: 5344, HTTPSPort: 5345, CertFile: certPath, KeyFile: certPath})
+	if len(issues) != 1 || issues[0].Severity != IssueError {
+		t.Fatalf("cert as key: %v", issues)
+	}
+	issues = validateScheme(&SchemeConfig{HTTPPort: 5344, HTTPSPort: 5344})
+	if len(issues) != 2 {
+		t.Fatalf("same port without cert: %v", issues)
+	}
+	missing := filepath.Join(dir, "missing.pem")
+	issues = validateScheme(&SchemeConfig{HTTPPort: 5344, HTTPSPort: 5345, CertFile: missing, KeyFile: missing, AutoSelfSigned: true})
+	if len(issues) != 1 || issues[0].Severity != IssueWarning {
+		t.Fatalf("auto self-signed: %v", issues)
+	}
+	issues = validateScheme(&SchemeConfig{HTTPPort: 5344, HTTPSPort: -1, DrainSeconds: -1})
+	if len(issues) != 1 || issues[0].Field != "scheme.drain_seconds" {
+		t.Fatalf("negative drain: %v", issues)
+	}
+
+	cfg := &Config{}
+	if got := cfg.DrainTimeout(); got != DefaultDrainSeconds*time.Second {
+		t.Fatalf("default drain = %v", got)
+	}
+	cfg.Scheme = &SchemeConfig{DrainSeconds: 120}
+	if got := cfg.DrainTimeout(); got != 2*time.Minute {
+		t.Fatalf("drain = %v", got)
+	}
+}
+
+func TestValidateSchemeListeners(t *testing.T) {
+	issues := validateScheme(&SchemeConfig{HTTPPort: 5344, Listeners: []ListenerConfig{
+		{Address: "127.0.0.1", Port: 5344},
+		{Address: "0.0.0.0", Port: 5345, Paths: []string{"/dav"}},
+	}})
+	if len(issues) != 0 {
+		t.Fatalf("valid listeners: %v", issues)
+	}
+	issues = validateScheme(&SchemeConfig{HTTPPort: 5344, Listeners: []ListenerConfig{
+		{Address: "127.0.0.1", Port: 5344},
+		{Address: "127.0.0.1", Port: 5344},
+		{Port: 70000},
+		{Port: 5346, Paths: []string{"dav"}},
+		{Port: 5347, TLS: true},
+	}})
+	fields := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		fields = append(fields, issue.Field)
+	}
+	want := "scheme.listeners[1] scheme.listeners[2].port scheme.listeners[3].paths scheme.cert_file"
+	if got := strings.Join(fields, " "); got != want {
+		t.Fatalf("issues=%v", issues)
+	}
+}
+
+func TestLoadForValidationReportsJSONPosition(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte("{\n  \"port\": 5344,\n  \"alistServer\": {\n    \"serverPort\": \"x\"\n  }\n}\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, issues := LoadForValidation(path)
+	if len(issues) != 1 || issues[0].Severity != IssueError || !strings.Contains(issues[0].Message, "line 4") {
+		t.Fatalf("issues=%v", issues)
+	}
+	if cfg == nil || cfg.Scheme == nil {
+		t.Fatalf("cfg=%+v", cfg)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Fatalf("validation wrote files: %v", entries)
+	}
+}
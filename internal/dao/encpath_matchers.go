@@ -0,0 +1,61 @@
+package dao
+
+import (
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/encryption"
+)
+
+// passwdMatcher is the runtime form of one password rule. Config keeps the
+// encPath patterns exactly as the user wrote them; the /d, /p and /dav
+// variants needed to match proxied URLs only ever live here.
+type passwdMatcher struct {
+	info    *config.PasswdInfo
+	source  []string // the EncPath slice this matcher was built from
+	matcher *encryption.PathMatcher
+	baseLen int
+}
+
+// passwdMatchers returns matchers for the current password list, rebuilding
+// them when the list (or any rule's EncPath) has been replaced.
+func (d *PasswdDAO) passwdMatchers() []passwdMatcher {
+	list := d.cfg.AlistServer.PasswdList
+	d.matchersMu.Lock()
+	defer d.matchersMu.Unlock()
+	if !matchersCurrent(d.matchers, list) {
+		d.matchers = buildPasswdMatchers(list)
+	}
+	return d.matchers
+}
+
+func buildPasswdMatchers(list []config.PasswdInfo) []passwdMatcher {
+	out := make([]passwdMatcher, len(list))
+	for i := range list {
+		out[i] = passwdMatcher{
+			info:    &list[i],
+			source:  list[i].EncPath,
+			matcher: encryption.NewPathMatcher(list[i].EncPath),
+			baseLen: longestEncPathLen(list[i].EncPath),
+		}
+	}
+	return out
+}
+
+func matchersCurrent(matchers []passwdMatcher, list []config.PasswdInfo) bool {
+	if len(matchers) != len(list) {
+		return false
+	}
+	for i := range list {
+		m := matchers[i]
+		if m.info != &list[i] || !sameSlice(m.source, list[i].EncPath) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameSlice(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	return len(a) == 0 || &a[0] == &b[0]
+}
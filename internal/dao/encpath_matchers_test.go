@@ -0,0 +1,52 @@
+package dao
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/storage"
+)
+
+func TestPasswdDAOMatchesExpandedPathsWithoutMutatingConfig(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AlistServer.PasswdList = []config.PasswdInfo{
+		{Password: "a", EncType: "aesctr", Enable: true, EncPath: []string{"/movies/*"}},
+		{Password: "b", EncType: "aesctr", Enable: true, EncPath: []string{"/movies/private/*"}},
+	}
+	d := &PasswdDAO{cfg: cfg, cache: storage.NewCache(time.Minute)}
+	defer d.Stop()
+
+	for _, p := range []string{"/movies/a.mp4", "/d/movies/a.mp4", "/p/movies/a.mp4", "/dav/movies/a.mp4"} {
+		info, ok := d.FindByPath(p)
+		if !ok || info.Password != "a" {
+			t.Fatalf("FindByPath(%q) = %+v, %v", p, info, ok)
+		}
+	}
+	if info, ok := d.FindByPath("/dav/movies/private/x.mkv"); !ok || info.Password != "b" {
+		t.Fatalf("most specific rule not preferred: %+v", info)
+	}
+	if got := cfg.AlistServer.PasswdList[0].EncPath; !reflect.DeepEqual(got, []string{"/movies/*"}) {
+		t.Fatalf("stored encPath mutated: %v", got)
+	}
+}
+
+func TestPasswdDAORebuildsMatchersWhenListReplaced(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AlistServer.PasswdList = []config.PasswdInfo{
+		{Password: "a", EncType: "aesctr", Enable: true, EncPath: []string{"/movies/*"}},
+	}
+	d := &PasswdDAO{cfg: cfg, cache: storage.NewCache(time.Minute)}
+	defer d.Stop()
+
+	if !d.MatchDir("/movies") {
+		t.Fatal("expected /movies to match")
+	}
+	cfg.AlistServer.PasswdList = []config.PasswdInfo{
+		{Password: "a", EncType: "aesctr", Enable: true, EncPath: []string{"/music/*"}},
+	}
+	if _, ok := d.PathFindPasswd("/d/music/song.flac"); !ok {
+		t.Fatal("matchers were not rebuilt after the list was replaced")
+	}
+}
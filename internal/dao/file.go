@@ -1,8 +1,10 @@
 package dao
 
 import (
+	"context"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
@@ -54,6 +56,11 @@ type FileDAO struct {
 	store          *storage.Store
 	pathCache      *PathCache // Unified high-performance cache
 	fileMetaWriter FileMetaStoreWriter
+
+	// Removed paths; see tombstone.go.
+	tombs       tombstones
+	hooksMu     sync.Mutex
+	forgetHooks []func(displayPath string)
 }
 
 const mediaSizePreserveThreshold = 100 * 1024
@@ -96,10 +103,10 @@ func mergeCachedFileSize(existing *FileInfo, incoming *FileInfo) int64 {
 	if incoming.Size <= 0 {
 		return existing.Size
 	}
-	if incoming.ContentVersion == encryption.ContentVersionV2 {
+	if incoming.ContentVersion >= encryption.ContentVersionV2 {
 		return incoming.Size
 	}
-	if existing.ContentVersion == encryption.ContentVersionV2 && existing.Size > 0 {
+	if existing.ContentVersion >= encryption.ContentVersionV2 && existing.Size > 0 {
 		if existing.CiphertextSize > 0 && incoming.Size == existing.CiphertextSize {
 			return existing.Size
 		}
@@ -114,7 +121,7 @@ func mergeCachedPathSize(existing *PathEntry, incomingSize int64, isDir bool, pa
 	if existing == nil || incomingSize <= 0 {
 		return incomingSize
 	}
-	if existing.ContentVersion == encryption.ContentVersionV2 && existing.Size > 0 {
+	if existing.ContentVersion >= encryption.ContentVersionV2 && existing.Size > 0 {
 		if existing.CiphertextSize > 0 && incomingSize == existing.CiphertextSize {
 			return existing.Size
 		}
@@ -180,6 +187,9 @@ func (d *FileDAO) Get(path string) (*FileInfo, bool) {
 
 // Set stores file info
 func (d *FileDAO) Set(info *FileInfo) error {
+	if d.Buried(info.Path) || d.Buried(info.EncryptedPath) {
+		return nil
+	}
 	if existing, ok := d.Get(info.Path); ok && existing != nil {
 		if info.EncryptedPath == "" {
 			info.EncryptedPath = existing.EncryptedPath
@@ -261,6 +271,9 @@ func (d *FileDAO) Set(info *FileInfo) error {
 // from an existing cache entry or assembling from a complete upstream response).
 // This avoids the Get() round-trip that Set() performs to fill in missing fields.
 func (d *FileDAO) SetComplete(info *FileInfo) error {
+	if d.Buried(info.Path) || d.Buried(info.EncryptedPath) {
+		return nil
+	}
 	now := time.Now()
 	upstreamFetchedAt := info.UpstreamFetchedAt
 	if upstreamFetchedAt.IsZero() {
@@ -305,6 +318,9 @@ func (d *FileDAO) Delete(path string) error {
 
 // SetEncPathMapping caches the display path to encrypted path mapping with file info
 func (d *FileDAO) SetEncPathMapping(displayPath, encryptedPath string) {
+	if d.Buried(displayPath) || d.Buried(encryptedPath) {
+		return
+	}
 	// Check if we already have this mapping with file info
 	if existing, ok := d.pathCache.GetByDispPath(displayPath); ok {
 		// Update encrypted path if needed
@@ -328,6 +344,9 @@ func (d *FileDAO) SetEncPathMapping(displayPath, encryptedPath string) {
 
 // SetEncPathMappingWithInfo caches mapping with full file info (recommended)
 func (d *FileDAO) SetEncPathMappingWithInfo(displayPath, encryptedPath, name string, size int64, isDir bool) {
+	if d.Buried(displayPath) || d.Buried(encryptedPath) {
+		return
+	}
 	var existing *PathEntry
 	if cached, ok := d.pathCache.GetByDispPath(displayPath); ok && cached != nil {
 		existing = cached
@@ -405,6 +424,9 @@ func (d *FileDAO) GetFileSize(path string) (int64, bool) {
 
 // SetFileSize caches file size with TTL (default 24 hours for stability)
 func (d *FileDAO) SetFileSize(path string, size int64, ttl time.Duration) {
+	if d.Buried(path) {
+		return
+	}
 	if ttl == 0 {
 		ttl = 24 * time.Hour
 	}
@@ -473,7 +495,9 @@ func (d *FileDAO) FileSizeCacheStats() map[string]interface{} {
 
 // PathCacheStats returns full path cache statistics
 func (d *FileDAO) PathCacheStats() map[string]interface{} {
-	return d.pathCache.Stats()
+	stats := d.pathCache.Stats()
+	stats["tombstones"] = d.tombs.count.Load()
+	return stats
 }
 
 // cleanupPathCache runs periodic cleanup of expired entries
@@ -483,6 +507,7 @@ func (d *FileDAO) cleanupPathCache() {
 
 	for range ticker.C {
 		d.pathCache.CleanExpired()
+		d.sweepTombstones()
 	}
 }
 
@@ -521,6 +546,9 @@ func (d *FileDAO) SetFromAlistResponse(path string, data map[string]interface{})
 type PasswdDAO struct {
 	cfg   *config.Config
 	cache *storage.Cache
+
+	matchersMu sync.Mutex
+	matchers   []passwdMatcher
 }
 
 // NewPasswdDAO creates a new password DAO
@@ -611,12 +639,11 @@ func (d *PasswdDAO) MatchDir(dirPath string) bool {
 	}
 
 	probePath := buildProbePath(dirPath)
-	for i := range d.cfg.AlistServer.PasswdList {
-		passwdInfo := &d.cfg.AlistServer.PasswdList[i]
-		if !passwdInfo.Enable {
+	for _, m := range d.passwdMatchers() {
+		if !m.info.Enable {
 			continue
 		}
-		if encryption.PathExec(passwdInfo.EncPath, probePath) {
+		if m.matcher.Match(probePath) {
 			d.cache.Set(cacheKey, true)
 			return true
 		}
@@ -627,26 +654,27 @@ func (d *PasswdDAO) MatchDir(dirPath string) bool {
 }
 
 func (d *PasswdDAO) findByPathInternal(urlPath string) (*config.PasswdInfo, bool) {
+	if bestMatch := d.bestMatch(urlPath); bestMatch != nil {
+		return bestMatch, true
+	}
+	return nil, false
+}
+
+// bestMatch returns the enabled rule whose patterns match urlPath, preferring
+// the most specific (longest base path) one.
+func (d *PasswdDAO) bestMatch(urlPath string) *config.PasswdInfo {
 	var bestMatch *config.PasswdInfo
 	var bestLen int
-	for i := range d.cfg.AlistServer.PasswdList {
-		passwdInfo := &d.cfg.AlistServer.PasswdList[i]
-		if !passwdInfo.Enable {
+	for _, m := range d.passwdMatchers() {
+		if !m.info.Enable || !m.matcher.Match(urlPath) {
 			continue
 		}
-		if encryption.PathExec(passwdInfo.EncPath, urlPath) {
-			// Prefer the most specific (longest base path) match
-			matchLen := longestEncPathLen(passwdInfo.EncPath)
-			if bestMatch == nil || matchLen > bestLen {
-				bestMatch = passwdInfo
-				bestLen = matchLen
-			}
+		if bestMatch == nil || m.baseLen > bestLen {
+			bestMatch = m.info
+			bestLen = m.baseLen
 		}
 	}
-	if bestMatch != nil {
-		return bestMatch, true
-	}
-	return nil, false
+	return bestMatch
 }
 
 func longestEncPathLen(encPaths []string) int {
@@ -664,46 +692,66 @@ func longestEncPathLen(encPaths []string) int {
 // PathFindPasswd finds password config matching URL path with encPath patterns.
 // Returns the most specific (longest base path) match with folder password decoding.
 func (d *PasswdDAO) PathFindPasswd(urlPath string) (*config.PasswdInfo, bool) {
-	all := d.GetAll()
+	if bestMatch := d.bestMatch(urlPath); bestMatch != nil {
+		return withFolderPasswd(bestMatch, urlPath), true
+	}
 
-	var bestMatch *config.PasswdInfo
-	var bestLen int
-	for _, passwdInfo := range all {
-		if !passwdInfo.Enable {
+	return nil, false
+}
+
+// withFolderPasswd returns a copy of info, switched to the password of the
+// first encoded folder name in urlPath if there is one.
+func withFolderPasswd(info *config.PasswdInfo, urlPath string) *config.PasswdInfo {
+	newPasswdInfo := *info // Copy
+	folders := strings.Split(urlPath, "/")
+	for _, folderName := range folders {
+		if folderName == "" {
 			continue
 		}
-		if encryption.PathExec(passwdInfo.EncPath, urlPath) {
-			matchLen := longestEncPathLen(passwdInfo.EncPath)
-			if bestMatch == nil || matchLen > bestLen {
-				bestMatch = passwdInfo
-				bestLen = matchLen
-			}
+		decoded, _ := url.QueryUnescape(folderName)
+		folderEncType, folderPasswd, ok := encryption.DecodeFolderName(
+			info.Password,
+			info.EncType,
+			decoded,
+		)
+		if ok {
+			newPasswdInfo.EncType = folderEncType
+			newPasswdInfo.Password = folderPasswd
+			newPasswdInfo.PasswordVersions = nil
+			return &newPasswdInfo
 		}
 	}
+	return &newPasswdInfo
+}
 
-	if bestMatch != nil {
-		newPasswdInfo := *bestMatch // Copy
-		folders := strings.Split(urlPath, "/")
-		for _, folderName := range folders {
-			if folderName == "" {
-				continue
-			}
-			decoded, _ := url.QueryUnescape(folderName)
-			folderEncType, folderPasswd, ok := encryption.DecodeFolderName(
-				bestMatch.Password,
-				bestMatch.EncType,
-				decoded,
-			)
-			if ok {
-				newPasswdInfo.EncType = folderEncType
-				newPasswdInfo.Password = folderPasswd
-				return &newPasswdInfo, true
-			}
-		}
-		return &newPasswdInfo, true
+type passwdOverrideKey struct{}
+
+// WithPasswdOverride makes info the passwd config of the request carrying
+// ctx, whatever encPath says. Request rules with the encrypt action set it.
+func WithPasswdOverride(ctx context.Context, info *config.PasswdInfo) context.Context {
+	return context.WithValue(ctx, passwdOverrideKey{}, info)
+}
+
+func passwdOverride(ctx context.Context) (*config.PasswdInfo, bool) {
+	info, ok := ctx.Value(passwdOverrideKey{}).(*config.PasswdInfo)
+	return info, ok && info != nil
+}
+
+// FindByPathFor is FindByPath for a request, honouring WithPasswdOverride.
+func (d *PasswdDAO) FindByPathFor(ctx context.Context, urlPath string) (*config.PasswdInfo, bool) {
+	if info, ok := passwdOverride(ctx); ok {
+		return info, true
 	}
+	return d.FindByPath(urlPath)
+}
 
-	return nil, false
+// PathFindPasswdFor is PathFindPasswd for a request, honouring
+// WithPasswdOverride. Folder passwords in urlPath still apply.
+func (d *PasswdDAO) PathFindPasswdFor(ctx context.Context, urlPath string) (*config.PasswdInfo, bool) {
+	if info, ok := passwdOverride(ctx); ok {
+		return withFolderPasswd(info, urlPath), true
+	}
+	return d.PathFindPasswd(urlPath)
 }
 
 func buildProbePath(dirPath string) string {
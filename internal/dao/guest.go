@@ -0,0 +1,150 @@
+package dao
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/storage"
+)
+
+// guestCodeAlphabet leaves out characters that are easy to misread when a
+// code is passed on by hand.
+const (
+	guestCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+	guestCodeLength   = 12
+)
+
+var ErrGuestCodeNotFound = errors.New("guest code not found")
+
+// GuestCode grants read-only access to one display directory, and everything
+// below it, until ExpiresAt.
+type GuestCode struct {
+	Code      string    `json:"code"`
+	Path      string    `json:"path"`
+	Note      string    `json:"note,omitempty"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Expired reports whether the code no longer grants access.
+func (g *GuestCode) Expired(now time.Time) bool {
+	return !now.Before(g.ExpiresAt)
+}
+
+// GuestCodeDAO stores guest codes in BoltDB. Expired codes are dropped the
+// next time codes are listed or created.
+type GuestCodeDAO struct {
+	store *storage.Store
+}
+
+// NewGuestCodeDAO creates a guest code DAO.
+func NewGuestCodeDAO(store *storage.Store) *GuestCodeDAO {
+	return &GuestCodeDAO{store: store}
+}
+
+// Create issues a code for dir valid for ttl.
+func (d *GuestCodeDAO) Create(dir, note, createdBy string, ttl time.Duration) (*GuestCode, error) {
+	code, err := newGuestCode()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	g := &GuestCode{
+		Code:      code,
+		Path:      dir,
+		Note:      strings.TrimSpace(note),
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+	if _, err := d.List(); err != nil {
+		return nil, err
+	}
+	if err := d.store.SetJSON(storage.BucketGuests, code, g); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Get returns the code if it exists and has not expired.
+func (d *GuestCodeDAO) Get(code string) (*GuestCode, error) {
+	var g GuestCode
+	if err := d.store.GetJSON(storage.BucketGuests, code, &g); err != nil {
+		return nil, err
+	}
+	if g.Code == "" || g.Expired(time.Now()) {
+		return nil, ErrGuestCodeNotFound
+	}
+	return &g, nil
+}
+
+// List returns the codes that have not expired, newest first, and deletes
+// the expired ones.
+func (d *GuestCodeDAO) List() ([]GuestCode, error) {
+	all, err := d.store.GetAll(storage.BucketGuests)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	codes := make([]GuestCode, 0, len(all))
+	var expired []string
+	for key, value := range all {
+		var g GuestCode
+		if err := json.Unmarshal(value, &g); err != nil || g.Expired(now) {
+			expired = append(expired, key)
+			continue
+		}
+		codes = append(codes, g)
+	}
+	if len(expired) > 0 {
+		err = d.store.UpdateBucket(storage.BucketGuests, func(tx *storage.BucketTx) error {
+			for _, key := range expired {
+				if err := tx.Delete(key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(codes, func(i, j int) bool { return codes[i].CreatedAt.After(codes[j].CreatedAt) })
+	return codes, nil
+}
+
+// Delete revokes a code.
+func (d *GuestCodeDAO) Delete(code string) error {
+	var g GuestCode
+	if err := d.store.GetJSON(storage.BucketGuests, code, &g); err != nil {
+		return err
+	}
+	if g.Code == "" {
+		return ErrGuestCodeNotFound
+	}
+	return d.store.Delete(storage.BucketGuests, code)
+}
+
+func newGuestCode() (string, error) {
+	// Bytes at or above limit are drawn again so every character is equally
+	// likely.
+	limit := byte(256 - 256%len(guestCodeAlphabet))
+	code := make([]byte, 0, guestCodeLength)
+	buf := make([]byte, guestCodeLength)
+	for len(code) < guestCodeLength {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if b < limit && len(code) < guestCodeLength {
+				code = append(code, guestCodeAlphabet[int(b)%len(guestCodeAlphabet)])
+			}
+		}
+	}
+	return string(code), nil
+}
@@ -0,0 +1,29 @@
+package dao
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/storage"
+)
+
+func TestGuestCodeDAOExpires(t *testing.T) {
+	store, err := storage.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	codes := NewGuestCodeDAO(store)
+	expired, err := codes.Create("/a", "", "admin", -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := codes.Get(expired.Code); err != ErrGuestCodeNotFound {
+		t.Fatalf("expired code err=%v", err)
+	}
+	live, _ := codes.Create("/b", "", "admin", time.Hour)
+	list, err := codes.List()
+	if err != nil || len(list) != 1 || list[0].Code != live.Code || len(live.Code) != 12 {
+		t.Fatalf("list=%v err=%v", list, err)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/alist-encrypt-go/internal/pathutil"
 )
 
 // PathEntry stores all path-related information in one place
@@ -220,6 +222,25 @@ func (c *PathCache) Delete(encPath string) {
 	shard.mu.Unlock()
 }
 
+// DeleteUnder removes every entry whose encrypted or display path is dir
+// or lies below it, and returns how many were removed.
+func (c *PathCache) DeleteUnder(dir string) int {
+	var encPaths []string
+	for _, shard := range c.shards {
+		shard.mu.RLock()
+		for encPath, entry := range shard.byEncPath {
+			if pathutil.Within(encPath, dir) || (entry.DisplayPath != "" && pathutil.Within(entry.DisplayPath, dir)) {
+				encPaths = append(encPaths, encPath)
+			}
+		}
+		shard.mu.RUnlock()
+	}
+	for _, encPath := range encPaths {
+		c.Delete(encPath)
+	}
+	return len(encPaths)
+}
+
 // evictOldest removes expired entries, or oldest 10% if no expired
 func (c *PathCache) evictOldest(shard *pathCacheShard) {
 	now := time.Now().UnixNano()
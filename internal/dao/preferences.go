@@ -0,0 +1,90 @@
+package dao
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+
+	"github.com/alist-encrypt-go/internal/storage"
+)
+
+// MaxPreferencesBytes bounds one user's stored preferences blob.
+const MaxPreferencesBytes = 64 << 10
+
+var (
+	ErrPreferencesTooLarge = errors.New("preferences too large")
+	ErrPreferencesInvalid  = errors.New("preferences must be a JSON object")
+)
+
+// PreferencesDAO stores an opaque JSON object per management UI user, e.g.
+// table layouts, default paths and locale.
+type PreferencesDAO struct {
+	store *storage.Store
+}
+
+// NewPreferencesDAO creates a new preferences DAO
+func NewPreferencesDAO(store *storage.Store) *PreferencesDAO {
+	return &PreferencesDAO{store: store}
+}
+
+// Get returns the stored preferences of username, or an empty object.
+func (d *PreferencesDAO) Get(username string) (json.RawMessage, error) {
+	data, err := d.store.Get(storage.BucketPrefs, username)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return json.RawMessage("{}"), nil
+	}
+	return json.RawMessage(data), nil
+}
+
+// Set replaces the preferences of username. prefs must be a JSON object no
+// larger than MaxPreferencesBytes once compacted.
+func (d *PreferencesDAO) Set(username string, prefs []byte) error {
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, prefs); err != nil {
+		return ErrPreferencesInvalid
+	}
+	if compact.Len() == 0 || compact.Bytes()[0] != '{' {
+		return ErrPreferencesInvalid
+	}
+	if compact.Len() > MaxPreferencesBytes {
+		return ErrPreferencesTooLarge
+	}
+	return d.store.Set(storage.BucketPrefs, username, compact.Bytes())
+}
+
+// Rename moves the preferences of username to newUsername, replacing any
+// stored under the new name.
+func (d *PreferencesDAO) Rename(username, newUsername string) error {
+	return d.store.UpdateBucket(storage.BucketPrefs, func(tx *storage.BucketTx) error {
+		var prefs json.RawMessage
+		if err := tx.GetJSON(username, &prefs); err != nil {
+			return err
+		}
+		if prefs == nil {
+			return nil
+		}
+		if err := tx.SetJSON(newUsername, prefs); err != nil {
+			return err
+		}
+		return tx.Delete(username)
+	})
+}
+
+// Locale returns the "locale" preference of username, or "" when unset or
+// unreadable.
+func (d *PreferencesDAO) Locale(username string) string {
+	prefs, err := d.Get(username)
+	if err != nil {
+		return ""
+	}
+	var p struct {
+		Locale string `json:"locale"`
+	}
+	if json.Unmarshal(prefs, &p) != nil {
+		return ""
+	}
+	return p.Locale
+}
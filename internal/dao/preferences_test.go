@@ -0,0 +1,56 @@
+package dao
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/storage"
+)
+
+func TestPreferencesDAOSetGetRename(t *testing.T) {
+	store, err := storage.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	prefs := NewPreferencesDAO(store)
+
+	got, err := prefs.Get("admin")
+	if err != nil || string(got) != "{}" {
+		t.Fatalf("empty prefs = %s, err=%v", got, err)
+	}
+	if err := prefs.Set("admin", []byte(`{ "locale": "zh-CN", "columns": [1, 2] }`)); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	got, _ = prefs.Get("admin")
+	if string(got) != `{"locale":"zh-CN","columns":[1,2]}` {
+		t.Fatalf("stored prefs = %s", got)
+	}
+
+	for _, bad := range []string{`[1]`, `"x"`, `{`, ``} {
+		if err := prefs.Set("admin", []byte(bad)); !errors.Is(err, ErrPreferencesInvalid) {
+			t.Fatalf("Set(%q) err=%v, want ErrPreferencesInvalid", bad, err)
+		}
+	}
+	big := `{"x":"` + strings.Repeat("a", MaxPreferencesBytes) + `"}`
+	if err := prefs.Set("admin", []byte(big)); !errors.Is(err, ErrPreferencesTooLarge) {
+		t.Fatalf("oversized err=%v", err)
+	}
+
+	if err := prefs.Rename("admin", "root"); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	if got, _ := prefs.Get("admin"); string(got) != "{}" {
+		t.Fatalf("old name still has prefs: %s", got)
+	}
+	if got, _ := prefs.Get("root"); string(got) != `{"locale":"zh-CN","columns":[1,2]}` {
+		t.Fatalf("renamed prefs = %s", got)
+	}
+	if got := prefs.Locale("root"); got != "zh-CN" {
+		t.Fatalf("locale = %q", got)
+	}
+	if got := prefs.Locale("admin"); got != "" {
+		t.Fatalf("unset locale = %q", got)
+	}
+}
@@ -0,0 +1,297 @@
+package dao
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/storage"
+)
+
+const (
+	tokenKeyPrefix   = "id:"
+	userKeyPrefix    = "user:"
+	sessionKeyPrefix = "session:"
+	sinceKey         = "meta:since"
+	secretKey        = "meta:secret"
+)
+
+// Session is a management token issued at login, recorded so the user can
+// see where they are logged in and end single sessions.
+type Session struct {
+	ID        string    `json:"id"`
+	Username  string    `json:"username"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	RemoteIP  string    `json:"remote_ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+}
+
+// TokenDAO records management tokens revoked before they expire: single
+// tokens by ID on logout, and every token of a user issued before a cutoff
+// when the user logs out everywhere, changes password or is renamed. The
+// list is kept in memory since it is checked on every authenticated request;
+// BoltDB keeps it across restarts.
+//
+// With a store it also keeps the sessions issued at login. A token issued
+// since session tracking began is only valid while its session exists, so
+// ending a session, or clearing them all when the JWT secret changes, logs
+// the token out even if it was copied elsewhere.
+type TokenDAO struct {
+	store *storage.Store
+
+	mu       sync.RWMutex
+	ids      map[string]time.Time // token ID -> token expiry
+	cutoffs  map[string]time.Time // username -> tokens issued before are revoked
+	sessions map[string]*Session  // token ID -> session
+	since    time.Time            // tokens issued from here on need a session
+}
+
+// NewTokenDAO creates a token DAO and loads the stored revocations and
+// sessions.
+func NewTokenDAO(store *storage.Store) *TokenDAO {
+	d := &TokenDAO{
+		store:    store,
+		ids:      make(map[string]time.Time),
+		cutoffs:  make(map[string]time.Time),
+		sessions: make(map[string]*Session),
+	}
+	if store == nil {
+		return d
+	}
+	all, err := store.GetAll(storage.BucketTokens)
+	if err != nil {
+		return d
+	}
+	now := time.Now()
+	var expired []string
+	for key, value := range all {
+		if strings.HasPrefix(key, sessionKeyPrefix) {
+			var sess Session
+			if json.Unmarshal(value, &sess) != nil || sess.ExpiresAt.Before(now) {
+				expired = append(expired, key)
+				continue
+			}
+			d.sessions[sess.ID] = &sess
+			continue
+		}
+		var t time.Time
+		if err := json.Unmarshal(value, &t); err != nil {
+			continue
+		}
+		switch {
+		case strings.HasPrefix(key, tokenKeyPrefix) && t.After(now):
+			d.ids[strings.TrimPrefix(key, tokenKeyPrefix)] = t
+		case strings.HasPrefix(key, userKeyPrefix):
+			d.cutoffs[strings.TrimPrefix(key, userKeyPrefix)] = t
+		case key == sinceKey:
+			d.since = t
+		}
+	}
+	if d.since.IsZero() {
+		// Tokens issued before this version have no session and stay valid
+		// until they expire.
+		d.since = now.Truncate(time.Second)
+		_ = d.persist(sinceKey, d.since, expired)
+	} else if len(expired) > 0 {
+		_ = d.deleteKeys(expired)
+	}
+	return d
+}
+
+// BindSecret ties the stored sessions to the JWT secret. When the secret
+// differs from the one they were issued with, every session is dropped:
+// their tokens no longer verify anyway and should not be listed.
+func (d *TokenDAO) BindSecret(secret string) error {
+	if d == nil || d.store == nil {
+		return nil
+	}
+	sum := sha256.Sum256([]byte(secret))
+	fingerprint := hex.EncodeToString(sum[:])
+	var stored string
+	if err := d.store.GetJSON(storage.BucketTokens, secretKey, &stored); err != nil {
+		return err
+	}
+	if stored == fingerprint {
+		return nil
+	}
+	d.mu.Lock()
+	keys := make([]string, 0, len(d.sessions))
+	for id := range d.sessions {
+		keys = append(keys, sessionKeyPrefix+id)
+	}
+	d.sessions = make(map[string]*Session)
+	d.mu.Unlock()
+	return d.persist(secretKey, fingerprint, keys)
+}
+
+// RecordSession stores the session of a newly issued token.
+func (d *TokenDAO) RecordSession(sess Session) error {
+	if d == nil || sess.ID == "" {
+		return nil
+	}
+	d.mu.Lock()
+	d.sessions[sess.ID] = &sess
+	expired := d.pruneLocked()
+	d.mu.Unlock()
+	return d.persist(sessionKeyPrefix+sess.ID, &sess, expired)
+}
+
+// Sessions returns the live sessions of username, newest first.
+func (d *TokenDAO) Sessions(username string) []Session {
+	if d == nil {
+		return nil
+	}
+	now := time.Now()
+	d.mu.RLock()
+	var out []Session
+	for _, sess := range d.sessions {
+		if sess.Username == username && sess.ExpiresAt.After(now) {
+			out = append(out, *sess)
+		}
+	}
+	d.mu.RUnlock()
+	sort.Slice(out, func(a, b int) bool { return out[a].IssuedAt.After(out[b].IssuedAt) })
+	return out
+}
+
+// Session returns the session with the given token ID.
+func (d *TokenDAO) Session(id string) (Session, bool) {
+	if d == nil {
+		return Session{}, false
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	sess, ok := d.sessions[id]
+	if !ok {
+		return Session{}, false
+	}
+	return *sess, true
+}
+
+// Revoke revokes the token with the given ID until it expires. Expired
+// tokens are rejected anyway and are not recorded.
+func (d *TokenDAO) Revoke(id string, expires time.Time) error {
+	if id == "" || expires.Before(time.Now()) {
+		return nil
+	}
+	d.mu.Lock()
+	d.ids[id] = expires
+	delete(d.sessions, id)
+	expired := append(d.pruneLocked(), sessionKeyPrefix+id)
+	d.mu.Unlock()
+	return d.persist(tokenKeyPrefix+id, expires, expired)
+}
+
+// RevokeUser revokes every token of username issued until now. The cutoff
+// keeps full precision, so tokens issued earlier in the same second go too;
+// tokens from before issue times carried nanoseconds only have whole
+// seconds, and are revoked if issued in the cutoff's second.
+func (d *TokenDAO) RevokeUser(username string) error {
+	at := time.Now()
+	d.mu.Lock()
+	d.cutoffs[username] = at
+	var ended []string
+	for id, sess := range d.sessions {
+		if sess.Username == username && sess.IssuedAt.Before(at) {
+			delete(d.sessions, id)
+			ended = append(ended, sessionKeyPrefix+id)
+		}
+	}
+	d.mu.Unlock()
+	return d.persist(userKeyPrefix+username, at, ended)
+}
+
+// IssueTime returns the issue time for a new token of username: now, or
+// just after the user's revocation cutoff when the clock has not moved past
+// it, so logging in again right after a revocation yields a valid token.
+func (d *TokenDAO) IssueTime(username string) time.Time {
+	now := time.Now()
+	if d == nil {
+		return now
+	}
+	d.mu.RLock()
+	cutoff, ok := d.cutoffs[username]
+	d.mu.RUnlock()
+	if ok && !now.After(cutoff) {
+		return cutoff.Add(time.Nanosecond)
+	}
+	return now
+}
+
+// Revoked reports whether the token with the given ID, issued to username at
+// issuedAt, has been revoked, or was issued since session tracking began and
+// its session has ended.
+func (d *TokenDAO) Revoked(id, username string, issuedAt time.Time) bool {
+	if d == nil {
+		return false
+	}
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if _, ok := d.ids[id]; ok && id != "" {
+		return true
+	}
+	if cutoff, ok := d.cutoffs[username]; ok {
+		if issuedAt.Equal(issuedAt.Truncate(time.Second)) {
+			// Whole-second iat: issued somewhere within that second.
+			cutoff = cutoff.Truncate(time.Second).Add(time.Second)
+		}
+		if issuedAt.Before(cutoff) {
+			return true
+		}
+	}
+	if d.since.IsZero() || id == "" || issuedAt.Before(d.since) {
+		return false
+	}
+	_, ok := d.sessions[id]
+	return !ok
+}
+
+// pruneLocked drops revocations and sessions of tokens that have expired
+// anyway and returns their keys.
+func (d *TokenDAO) pruneLocked() []string {
+	now := time.Now()
+	var expired []string
+	for id, expires := range d.ids {
+		if expires.Before(now) {
+			delete(d.ids, id)
+			expired = append(expired, tokenKeyPrefix+id)
+		}
+	}
+	for id, sess := range d.sessions {
+		if sess.ExpiresAt.Before(now) {
+			delete(d.sessions, id)
+			expired = append(expired, sessionKeyPrefix+id)
+		}
+	}
+	return expired
+}
+
+func (d *TokenDAO) persist(key string, value interface{}, remove []string) error {
+	if d.store == nil {
+		return nil
+	}
+	return d.store.UpdateBucket(storage.BucketTokens, func(tx *storage.BucketTx) error {
+		for _, k := range remove {
+			if err := tx.Delete(k); err != nil {
+				return err
+			}
+		}
+		return tx.SetJSON(key, value)
+	})
+}
+
+func (d *TokenDAO) deleteKeys(keys []string) error {
+	return d.store.UpdateBucket(storage.BucketTokens, func(tx *storage.BucketTx) error {
+		for _, k := range keys {
+			if err := tx.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
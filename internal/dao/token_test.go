@@ -0,0 +1,144 @@
+package dao
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/storage"
+)
+
+func TestTokenDAORevocationsPersist(t *testing.T) {
+	dir := t.TempDir()
+	store, err := storage.NewStore(dir)
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	tokens := NewTokenDAO(store)
+	issued := time.Now().Add(-time.Hour)
+	if err := tokens.Revoke("logged-out", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tokens.Revoke("long-expired", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tokens.RevokeUser("bob"); err != nil {
+		t.Fatal(err)
+	}
+	if !tokens.Revoked("logged-out", "alice", issued) || tokens.Revoked("other", "alice", issued) {
+		t.Fatal("revocation by ID did not apply to exactly that token")
+	}
+	if !tokens.Revoked("any", "bob", issued) {
+		t.Fatal("token issued before RevokeUser is still valid")
+	}
+	later := time.Now().Add(time.Second)
+	if err := tokens.RecordSession(Session{ID: "later", Username: "bob", IssuedAt: later, ExpiresAt: later.Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if tokens.Revoked("later", "bob", later) {
+		t.Fatal("token issued after RevokeUser was revoked")
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = storage.NewStore(dir)
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	reloaded := NewTokenDAO(store)
+	if !reloaded.Revoked("logged-out", "alice", issued) || !reloaded.Revoked("any", "bob", issued) {
+		t.Fatal("revocations were not restored from the store")
+	}
+	if _, ok := reloaded.ids["long-expired"]; ok {
+		t.Fatal("revocation of an expired token was kept")
+	}
+}
+
+func TestTokenDAOSessions(t *testing.T) {
+	dir := t.TempDir()
+	store, err := storage.NewStore(dir)
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	tokens := NewTokenDAO(store)
+	if err := tokens.BindSecret("secret-a"); err != nil {
+		t.Fatal(err)
+	}
+	legacy := time.Now().Add(-time.Hour)
+	if tokens.Revoked("from-old-version", "alice", legacy) {
+		t.Fatal("token issued before sessions were tracked was rejected")
+	}
+	now := time.Now().Truncate(time.Second)
+	if !tokens.Revoked("unknown", "alice", now) {
+		t.Fatal("token without a session was accepted")
+	}
+	for _, id := range []string{"laptop", "phone"} {
+		if err := tokens.RecordSession(Session{ID: id, Username: "alice", IssuedAt: now, ExpiresAt: now.Add(time.Hour)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if tokens.Revoked("laptop", "alice", now) || len(tokens.Sessions("alice")) != 2 {
+		t.Fatal("recorded sessions are not valid")
+	}
+	if err := tokens.Revoke("phone", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if got := tokens.Sessions("alice"); len(got) != 1 || got[0].ID != "laptop" {
+		t.Fatalf("sessions after logout: %+v", got)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err = storage.NewStore(dir)
+	if err != nil {
+		t.Fatalf("reopen store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	reloaded := NewTokenDAO(store)
+	if err := reloaded.BindSecret("secret-a"); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Revoked("laptop", "alice", now) || !reloaded.Revoked("phone", "alice", now) {
+		t.Fatal("sessions were not restored from the store")
+	}
+	if reloaded.Revoked("from-old-version", "alice", legacy) {
+		t.Fatal("session tracking start moved on restart")
+	}
+	if err := reloaded.BindSecret("secret-b"); err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.Sessions("alice")) != 0 || !reloaded.Revoked("laptop", "alice", now) {
+		t.Fatal("sessions survived a JWT secret change")
+	}
+}
+
+func TestTokenDAORevokeUserCoversSameSecond(t *testing.T) {
+	tokens := NewTokenDAO(nil)
+	if err := tokens.RevokeUser("bob"); err != nil {
+		t.Fatal(err)
+	}
+	cutoff := tokens.cutoffs["bob"]
+	if !tokens.Revoked("", "bob", cutoff.Add(-time.Nanosecond)) {
+		t.Fatal("token issued just before the cutoff is still valid")
+	}
+	if !tokens.Revoked("", "bob", cutoff.Truncate(time.Second)) {
+		t.Fatal("whole-second token from the cutoff's second is still valid")
+	}
+	if tokens.Revoked("", "bob", cutoff.Truncate(time.Second).Add(time.Second)) {
+		t.Fatal("whole-second token from the next second was revoked")
+	}
+
+	issued := tokens.IssueTime("bob")
+	if !issued.After(cutoff) {
+		t.Fatalf("issue time %v is not after cutoff %v", issued, cutoff)
+	}
+	if tokens.Revoked("", "bob", issued) {
+		t.Fatal("token issued right after RevokeUser was revoked")
+	}
+	tokens.cutoffs["bob"] = time.Now().Add(time.Minute)
+	if issued := tokens.IssueTime("bob"); !issued.After(tokens.cutoffs["bob"]) {
+		t.Fatal("issue time did not move past a cutoff ahead of the clock")
+	}
+}
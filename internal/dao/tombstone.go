@@ -0,0 +1,160 @@
+package dao
+
+import (
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/pathutil"
+	"github.com/alist-encrypt-go/internal/storage"
+)
+
+// Removing a path purges what the caches know about it and everything
+// below it, then keeps a tombstone for deletedPathRetentionMinutes. While
+// the tombstone lasts, cache writes at or below the path are dropped: a
+// listing or fs/get that was in flight during the delete would otherwise
+// put the removed file back, and a later upload or rename to the same path
+// would pick up its stale encrypted name and size. Operations that create
+// a path revive it.
+
+// tombstone marks a removed path. pair is the other side of the same file
+// (encrypted path for a display path and back), revived together.
+type tombstone struct {
+	at   time.Time
+	pair string
+}
+
+type tombstones struct {
+	mu     sync.RWMutex
+	byPath map[string]tombstone
+	count  atomic.Int64
+}
+
+func deletedPathRetention() time.Duration {
+	return time.Duration(config.Get().AlistServer.DeletedPathRetentionMinutes) * time.Minute
+}
+
+// Forget is called after displayPath was removed upstream. It drops the
+// path and everything below it from the path cache, the persisted file
+// info and size maps and the encrypted-name mapping, tells the OnForget
+// hooks, and leaves a tombstone.
+func (d *FileDAO) Forget(displayPath string) {
+	if d == nil || displayPath == "" {
+		return
+	}
+	displayPath = pathutil.Clean(displayPath)
+	if displayPath == "/" {
+		return
+	}
+	paths := []string{displayPath}
+	if encPath, ok := d.GetEncPath(displayPath); ok && encPath != "" && pathutil.Clean(encPath) != displayPath {
+		paths = append(paths, pathutil.Clean(encPath))
+	}
+	removed := 0
+	for _, p := range paths {
+		removed += d.pathCache.DeleteUnder(p)
+		for _, bucket := range [][]byte{storage.BucketFileInfo, storage.BucketFileSize} {
+			_ = d.store.Delete(bucket, p)
+			n, _ := d.store.DeletePrefix(bucket, p+"/")
+			removed += n
+		}
+	}
+	if retention := deletedPathRetention(); retention > 0 {
+		now := time.Now()
+		d.tombs.mu.Lock()
+		if d.tombs.byPath == nil {
+			d.tombs.byPath = make(map[string]tombstone)
+		}
+		for i, p := range paths {
+			d.tombs.byPath[p] = tombstone{at: now, pair: paths[len(paths)-1-i]}
+		}
+		d.tombs.count.Store(int64(len(d.tombs.byPath)))
+		d.tombs.mu.Unlock()
+	}
+	log.Debug().Str("path", displayPath).Int("removed", removed).Msg("Forgot removed path")
+
+	d.hooksMu.Lock()
+	hooks := d.forgetHooks
+	d.hooksMu.Unlock()
+	for _, fn := range hooks {
+		fn(displayPath)
+	}
+}
+
+// OnForget registers fn to run for every path passed to Forget, so caches
+// outside the DAO (download strategies, for one) drop their entries too.
+func (d *FileDAO) OnForget(fn func(displayPath string)) {
+	if d == nil {
+		return
+	}
+	d.hooksMu.Lock()
+	d.forgetHooks = append(d.forgetHooks, fn)
+	d.hooksMu.Unlock()
+}
+
+// Revive is called after p was created upstream (upload, mkdir, rename or
+// move target). It lifts the tombstones on p and its parents so the new
+// file is cached again.
+func (d *FileDAO) Revive(p string) {
+	if d == nil || d.tombs.count.Load() == 0 || p == "" {
+		return
+	}
+	p = pathutil.Clean(p)
+	d.tombs.mu.Lock()
+	defer d.tombs.mu.Unlock()
+	for {
+		if t, ok := d.tombs.byPath[p]; ok {
+			delete(d.tombs.byPath, p)
+			if t.pair != p {
+				delete(d.tombs.byPath, t.pair)
+			}
+		}
+		if p == "/" {
+			break
+		}
+		p = path.Dir(p)
+	}
+	d.tombs.count.Store(int64(len(d.tombs.byPath)))
+}
+
+// Buried reports whether p or one of its parents has a live tombstone.
+func (d *FileDAO) Buried(p string) bool {
+	if d == nil || d.tombs.count.Load() == 0 || p == "" {
+		return false
+	}
+	retention := deletedPathRetention()
+	if retention <= 0 {
+		return false
+	}
+	p = pathutil.Clean(p)
+	d.tombs.mu.RLock()
+	defer d.tombs.mu.RUnlock()
+	for {
+		if t, ok := d.tombs.byPath[p]; ok && time.Since(t.at) < retention {
+			return true
+		}
+		if p == "/" {
+			return false
+		}
+		p = path.Dir(p)
+	}
+}
+
+// sweepTombstones drops tombstones older than the retention and returns
+// how many are left.
+func (d *FileDAO) sweepTombstones() int {
+	retention := deletedPathRetention()
+	d.tombs.mu.Lock()
+	defer d.tombs.mu.Unlock()
+	for p, t := range d.tombs.byPath {
+		if retention <= 0 || time.Since(t.at) >= retention {
+			delete(d.tombs.byPath, p)
+		}
+	}
+	d.tombs.count.Store(int64(len(d.tombs.byPath)))
+	return len(d.tombs.byPath)
+}
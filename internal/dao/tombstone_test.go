@@ -0,0 +1,102 @@
+package dao
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+func withDeletedPathRetention(t *testing.T, minutes int) {
+	t.Helper()
+	cfg := config.Get()
+	old := cfg.AlistServer.DeletedPathRetentionMinutes
+	cfg.AlistServer.DeletedPathRetentionMinutes = minutes
+	t.Cleanup(func() { cfg.AlistServer.DeletedPathRetentionMinutes = old })
+}
+
+func TestForgetPurgesSubtree(t *testing.T) {
+	withDeletedPathRetention(t, 10)
+	fileDAO := newTestFileDAO(t)
+	fileDAO.SetEncPathMappingWithInfo("/movies/a.mp4", "/movies/enc-a.bin", "a.mp4", 1<<20, false)
+	fileDAO.SetFileSize("/movies/a.mp4", 1<<20, time.Hour)
+	_ = fileDAO.Set(&FileInfo{Path: "/movies/sub/b.mp4", Name: "b.mp4", Size: 42})
+	_ = fileDAO.Set(&FileInfo{Path: "/moviesx/c.mp4", Name: "c.mp4", Size: 7})
+
+	var hooked []string
+	fileDAO.OnForget(func(p string) { hooked = append(hooked, p) })
+	fileDAO.Forget("/movies/")
+
+	if _, ok := fileDAO.GetEncPath("/movies/a.mp4"); ok {
+		t.Fatal("encrypted-name mapping survived")
+	}
+	if _, ok := fileDAO.GetFileSize("/movies/a.mp4"); ok {
+		t.Fatal("size survived")
+	}
+	if _, ok := fileDAO.Get("/movies/sub/b.mp4"); ok {
+		t.Fatal("file info below the removed directory survived")
+	}
+	if _, ok := fileDAO.Get("/moviesx/c.mp4"); !ok {
+		t.Fatal("sibling with a common name prefix was purged")
+	}
+	if len(hooked) != 1 || hooked[0] != "/movies" {
+		t.Fatalf("hooks got %q", hooked)
+	}
+}
+
+func TestForgetBlocksGhostWritesUntilRevived(t *testing.T) {
+	withDeletedPathRetention(t, 10)
+	fileDAO := newTestFileDAO(t)
+	fileDAO.SetEncPathMapping("/a/old.mp4", "/a/enc-old.bin")
+	fileDAO.Forget("/a/old.mp4")
+
+	// A listing that was in flight during the delete reports the file again.
+	fileDAO.SetEncPathMappingWithInfo("/a/old.mp4", "/a/enc-old.bin", "old.mp4", 100, false)
+	fileDAO.SetFileSize("/a/old.mp4", 100, time.Hour)
+	if _, ok := fileDAO.GetEncPath("/a/old.mp4"); ok {
+		t.Fatal("ghost mapping was cached")
+	}
+	if _, ok := fileDAO.GetFileSize("/a/old.mp4"); ok {
+		t.Fatal("ghost size was cached")
+	}
+	if !fileDAO.Buried("/a/enc-old.bin") {
+		t.Fatal("encrypted side of the removed file is not tombstoned")
+	}
+
+	// An upload to the same path brings it back.
+	fileDAO.Revive("/a/old.mp4")
+	if fileDAO.Buried("/a/old.mp4") || fileDAO.Buried("/a/enc-old.bin") {
+		t.Fatal("revive left a tombstone")
+	}
+	fileDAO.SetEncPathMapping("/a/old.mp4", "/a/enc-new.bin")
+	if got, ok := fileDAO.GetEncPath("/a/old.mp4"); !ok || got != "/a/enc-new.bin" {
+		t.Fatalf("GetEncPath = %q, %v", got, ok)
+	}
+}
+
+func TestTombstonesOffAndSweep(t *testing.T) {
+	withDeletedPathRetention(t, 0)
+	fileDAO := newTestFileDAO(t)
+	fileDAO.Forget("/a")
+	if fileDAO.Buried("/a/b") {
+		t.Fatal("tombstone kept with retention 0")
+	}
+
+	withDeletedPathRetention(t, 10)
+	fileDAO.Forget("/a")
+	if !fileDAO.Buried("/a/b") {
+		t.Fatal("path below a removed directory is not buried")
+	}
+	if n := fileDAO.sweepTombstones(); n != 1 {
+		t.Fatalf("sweep left %d tombstones, want 1", n)
+	}
+	fileDAO.tombs.mu.Lock()
+	fileDAO.tombs.byPath["/a"] = tombstone{at: time.Now().Add(-11 * time.Minute), pair: "/a"}
+	fileDAO.tombs.mu.Unlock()
+	if fileDAO.Buried("/a") {
+		t.Fatal("expired tombstone still buries")
+	}
+	if n := fileDAO.sweepTombstones(); n != 0 {
+		t.Fatalf("sweep left %d tombstones, want 0", n)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/rs/zerolog/log"
 	"golang.org/x/crypto/argon2"
 
 	"github.com/alist-encrypt-go/internal/storage"
@@ -18,6 +19,16 @@ var (
 	ErrUserNotFound    = errors.New("user not found")
 	ErrInvalidPassword = errors.New("invalid password")
 	ErrUserExists      = errors.New("user already exists")
+	ErrPasswordExpired = errors.New("password expired")
+	ErrInvalidRole     = errors.New("invalid role")
+)
+
+// Roles of management API accounts. Administrators have full access;
+// operators manage passwd lists and caches but not listeners, TLS, JWT or
+// the upstream connection. Accounts stored without a role are administrators.
+const (
+	RoleAdmin    = "admin"
+	RoleOperator = "operator"
 )
 
 // Argon2 parameters (OWASP recommended)
@@ -33,6 +44,26 @@ const (
 type User struct {
 	Username     string `json:"username"`
 	PasswordHash string `json:"password_hash"`
+	// PasswordExpired is set when an administrator expires accounts still
+	// on the legacy SHA256 format; the stored hash is no longer accepted.
+	PasswordExpired bool   `json:"password_expired,omitempty"`
+	Role            string `json:"role,omitempty"`
+	// Provider names the identity provider that manages the account
+	// ("oidc"). Such accounts have no password and cannot log in with one.
+	Provider string `json:"provider,omitempty"`
+}
+
+// EffectiveRole returns the user's role, defaulting to RoleAdmin.
+func (u *User) EffectiveRole() string {
+	if u.Role == "" {
+		return RoleAdmin
+	}
+	return u.Role
+}
+
+// ValidRole reports whether role is one the management API understands.
+func ValidRole(role string) bool {
+	return role == RoleAdmin || role == RoleOperator
 }
 
 // UserDAO handles user data access
@@ -86,6 +117,21 @@ func verifyPassword(password, encodedHash string) bool {
 	return subtle.ConstantTimeCompare(computedHash, expectedHash) == 1
 }
 
+// isLegacyHash reports whether encodedHash is not in the Argon2id
+// base64(salt):base64(hash) format, i.e. verifyPassword would fall back to
+// verifyLegacyPassword for it.
+func isLegacyHash(encodedHash string) bool {
+	parts := splitHash(encodedHash)
+	if len(parts) != 2 {
+		return true
+	}
+	if _, err := base64.StdEncoding.DecodeString(parts[0]); err != nil {
+		return true
+	}
+	_, err := base64.StdEncoding.DecodeString(parts[1])
+	return err != nil
+}
+
 // splitHash splits the encoded hash by colon
 func splitHash(s string) []string {
 	for i := 0; i < len(s); i++ {
@@ -104,6 +150,14 @@ func verifyLegacyPassword(password, hash string) bool {
 
 // Create creates a new user
 func (d *UserDAO) Create(username, password string) error {
+	return d.CreateWithRole(username, password, "")
+}
+
+// CreateWithRole creates a new user with the given role; "" means RoleAdmin.
+func (d *UserDAO) CreateWithRole(username, password, role string) error {
+	if role != "" && !ValidRole(role) {
+		return ErrInvalidRole
+	}
 	// Check if user exists
 	var existing User
 	if err := d.store.GetJSON(storage.BucketUsers, username, &existing); err != nil {
@@ -121,6 +175,7 @@ func (d *UserDAO) Create(username, password string) error {
 	user := User{
 		Username:     username,
 		PasswordHash: hash,
+		Role:         role,
 	}
 	return d.store.SetJSON(storage.BucketUsers, username, user)
 }
@@ -134,12 +189,102 @@ func (d *UserDAO) Validate(username, password string) error {
 	if user.Username == "" {
 		return ErrUserNotFound
 	}
-	if !verifyPassword(password, user.PasswordHash) {
+	if user.PasswordExpired {
+		return ErrPasswordExpired
+	}
+	if user.Provider != "" || !verifyPassword(password, user.PasswordHash) {
 		return ErrInvalidPassword
 	}
+	if isLegacyHash(user.PasswordHash) {
+		// The plaintext is only available here, so legacy hashes are
+		// upgraded on the first successful login.
+		if err := d.rehash(username, password); err != nil {
+			log.Warn().Err(err).Str("username", username).Msg("Failed to upgrade legacy password hash")
+		}
+	}
 	return nil
 }
 
+// rehash replaces a legacy SHA256 hash with Argon2id, unless the hash changed
+// since it was verified.
+func (d *UserDAO) rehash(username, password string) error {
+	hash, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
+	return d.store.UpdateBucket(storage.BucketUsers, func(tx *storage.BucketTx) error {
+		var user User
+		if err := tx.GetJSON(username, &user); err != nil {
+			return err
+		}
+		if user.Username == "" || !isLegacyHash(user.PasswordHash) {
+			return nil
+		}
+		user.PasswordHash = hash
+		if err := tx.SetJSON(username, user); err != nil {
+			return err
+		}
+		log.Info().Str("username", username).Msg("Upgraded legacy password hash to Argon2id")
+		return nil
+	})
+}
+
+// CountLegacyHashes returns how many accounts still have a SHA256 hash and
+// have not been expired.
+func (d *UserDAO) CountLegacyHashes() (int, error) {
+	users, err := d.store.ListKeys(storage.BucketUsers)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, username := range users {
+		user, err := d.Get(username)
+		if err != nil {
+			continue
+		}
+		if user.Provider == "" && !user.PasswordExpired && isLegacyHash(user.PasswordHash) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// ExpireLegacyHashes marks every account still on a SHA256 hash as expired,
+// except the named one, and returns the usernames it expired. Expired
+// accounts cannot log in until their password is reset.
+func (d *UserDAO) ExpireLegacyHashes(except string) ([]string, error) {
+	users, err := d.store.ListKeys(storage.BucketUsers)
+	if err != nil {
+		return nil, err
+	}
+	var expired []string
+	err = d.store.UpdateBucket(storage.BucketUsers, func(tx *storage.BucketTx) error {
+		expired = expired[:0]
+		for _, username := range users {
+			if username == except {
+				continue
+			}
+			var user User
+			if err := tx.GetJSON(username, &user); err != nil {
+				return err
+			}
+			if user.Username == "" || user.Provider != "" || user.PasswordExpired || !isLegacyHash(user.PasswordHash) {
+				continue
+			}
+			user.PasswordExpired = true
+			if err := tx.SetJSON(username, user); err != nil {
+				return err
+			}
+			expired = append(expired, username)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return expired, nil
+}
+
 // Get retrieves a user
 func (d *UserDAO) Get(username string) (*User, error) {
 	var user User
@@ -163,9 +308,64 @@ func (d *UserDAO) UpdatePassword(username, newPassword string) error {
 		return err
 	}
 	user.PasswordHash = hash
+	user.PasswordExpired = false
 	return d.store.SetJSON(storage.BucketUsers, username, user)
 }
 
+// Role returns the effective role of username, or "" when it does not exist.
+func (d *UserDAO) Role(username string) string {
+	user, err := d.Get(username)
+	if err != nil {
+		return ""
+	}
+	return user.EffectiveRole()
+}
+
+// SetRole changes the role of an existing user.
+func (d *UserDAO) SetRole(username, role string) error {
+	if !ValidRole(role) {
+		return ErrInvalidRole
+	}
+	return d.store.UpdateBucket(storage.BucketUsers, func(tx *storage.BucketTx) error {
+		var user User
+		if err := tx.GetJSON(username, &user); err != nil {
+			return err
+		}
+		if user.Username == "" {
+			return ErrUserNotFound
+		}
+		user.Role = role
+		if role == RoleAdmin {
+			user.Role = ""
+		}
+		return tx.SetJSON(username, user)
+	})
+}
+
+// UpsertExternal creates or updates the account of a user signed in through
+// provider and sets its role. It returns ErrUserExists when username is a
+// local account, so a provider cannot take over a password account that
+// happens to share its name.
+func (d *UserDAO) UpsertExternal(username, provider, role string) error {
+	if !ValidRole(role) {
+		return ErrInvalidRole
+	}
+	return d.store.UpdateBucket(storage.BucketUsers, func(tx *storage.BucketTx) error {
+		var user User
+		if err := tx.GetJSON(username, &user); err != nil {
+			return err
+		}
+		if user.Username != "" && user.Provider != provider {
+			return ErrUserExists
+		}
+		user = User{Username: username, Provider: provider, Role: role}
+		if role == RoleAdmin {
+			user.Role = ""
+		}
+		return tx.SetJSON(username, user)
+	})
+}
+
 // Rename atomically renames a user after validating the current password.
 func (d *UserDAO) Rename(username, password, newUsername string) error {
 	return d.store.UpdateBucket(storage.BucketUsers, func(tx *storage.BucketTx) error {
@@ -176,9 +376,19 @@ func (d *UserDAO) Rename(username, password, newUsername string) error {
 		if user.Username == "" {
 			return ErrUserNotFound
 		}
+		if user.PasswordExpired {
+			return ErrPasswordExpired
+		}
 		if !verifyPassword(password, user.PasswordHash) {
 			return ErrInvalidPassword
 		}
+		if isLegacyHash(user.PasswordHash) {
+			hash, err := hashPassword(password)
+			if err != nil {
+				return err
+			}
+			user.PasswordHash = hash
+		}
 
 		var existing User
 		if err := tx.GetJSON(newUsername, &existing); err != nil {
@@ -1,6 +1,8 @@
 package dao
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"testing"
 
 	"github.com/alist-encrypt-go/internal/storage"
@@ -77,3 +79,125 @@ func TestUserDAORenameSuccess(t *testing.T) {
 		t.Fatalf("old user err=%v, want %v", err, ErrUserNotFound)
 	}
 }
+
+func legacyHash(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestValidateUpgradesLegacyHash(t *testing.T) {
+	store, err := storage.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	dao := NewUserDAO(store)
+	if err := store.SetJSON(storage.BucketUsers, "old", User{Username: "old", PasswordHash: legacyHash("password123")}); err != nil {
+		t.Fatalf("seed legacy user: %v", err)
+	}
+	if n, err := dao.CountLegacyHashes(); err != nil || n != 1 {
+		t.Fatalf("legacy count=%d err=%v, want 1", n, err)
+	}
+	if err := dao.Validate("old", "wrong"); err != ErrInvalidPassword {
+		t.Fatalf("wrong password err=%v, want %v", err, ErrInvalidPassword)
+	}
+	if n, _ := dao.CountLegacyHashes(); n != 1 {
+		t.Fatalf("failed login must not rehash, legacy count=%d", n)
+	}
+	if err := dao.Validate("old", "password123"); err != nil {
+		t.Fatalf("validate legacy: %v", err)
+	}
+	user, err := dao.Get("old")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if isLegacyHash(user.PasswordHash) {
+		t.Fatalf("hash was not upgraded: %q", user.PasswordHash)
+	}
+	if n, _ := dao.CountLegacyHashes(); n != 0 {
+		t.Fatalf("legacy count=%d after login, want 0", n)
+	}
+	if err := dao.Validate("old", "password123"); err != nil {
+		t.Fatalf("validate upgraded hash: %v", err)
+	}
+}
+
+func TestExpireLegacyHashes(t *testing.T) {
+	store, err := storage.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	dao := NewUserDAO(store)
+	if err := dao.Create("admin", "password123"); err != nil {
+		t.Fatalf("create admin: %v", err)
+	}
+	for _, name := range []string{"caller", "stale"} {
+		if err := store.SetJSON(storage.BucketUsers, name, User{Username: name, PasswordHash: legacyHash("password456")}); err != nil {
+			t.Fatalf("seed %s: %v", name, err)
+		}
+	}
+
+	expired, err := dao.ExpireLegacyHashes("caller")
+	if err != nil {
+		t.Fatalf("expire: %v", err)
+	}
+	if len(expired) != 1 || expired[0] != "stale" {
+		t.Fatalf("expired=%v, want [stale]", expired)
+	}
+	if err := dao.Validate("stale", "password456"); err != ErrPasswordExpired {
+		t.Fatalf("stale err=%v, want %v", err, ErrPasswordExpired)
+	}
+	if err := dao.Validate("admin", "password123"); err != nil {
+		t.Fatalf("argon2 account must stay valid: %v", err)
+	}
+	if n, _ := dao.CountLegacyHashes(); n != 1 {
+		t.Fatalf("legacy count=%d, want 1 (caller only)", n)
+	}
+
+	if err := dao.UpdatePassword("stale", "password789"); err != nil {
+		t.Fatalf("reset: %v", err)
+	}
+	if err := dao.Validate("stale", "password789"); err != nil {
+		t.Fatalf("reset account should validate: %v", err)
+	}
+}
+
+func TestUserRoles(t *testing.T) {
+	store, err := storage.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+
+	dao := NewUserDAO(store)
+	if err := dao.Create("admin", "password123"); err != nil {
+		t.Fatalf("create admin: %v", err)
+	}
+	if err := dao.CreateWithRole("op", "password456", RoleOperator); err != nil {
+		t.Fatalf("create operator: %v", err)
+	}
+	if err := dao.CreateWithRole("bad", "password456", "root"); err != ErrInvalidRole {
+		t.Fatalf("invalid role err=%v, want %v", err, ErrInvalidRole)
+	}
+	if got := dao.Role("admin"); got != RoleAdmin {
+		t.Fatalf("admin role=%q", got)
+	}
+	if got := dao.Role("op"); got != RoleOperator {
+		t.Fatalf("op role=%q", got)
+	}
+	if got := dao.Role("missing"); got != "" {
+		t.Fatalf("missing role=%q, want empty", got)
+	}
+	if err := dao.SetRole("op", RoleAdmin); err != nil {
+		t.Fatalf("promote: %v", err)
+	}
+	if got := dao.Role("op"); got != RoleAdmin {
+		t.Fatalf("promoted role=%q", got)
+	}
+	if err := dao.SetRole("missing", RoleOperator); err != ErrUserNotFound {
+		t.Fatalf("set role on missing err=%v, want %v", err, ErrUserNotFound)
+	}
+}
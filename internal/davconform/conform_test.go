@@ -0,0 +1,69 @@
+package davconform
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestScenarios(t *testing.T) {
+	t.Chdir(t.TempDir())
+	env, err := Start(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer env.Close()
+
+	scenarios, err := Scenarios()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scenarios) == 0 {
+		t.Fatal("no scenarios embedded")
+	}
+	for _, s := range scenarios {
+		t.Run(s.Client, func(t *testing.T) {
+			result := Run(http.DefaultClient, env.URL, s)
+			for _, step := range result.Steps {
+				if len(step.Failures) > 0 {
+					t.Errorf("%s (%s %s): %s", step.Name, step.Method, step.Path, strings.Join(step.Failures, "; "))
+				}
+			}
+		})
+	}
+
+	// Whatever the clients did, no plain file name may reach the upstream.
+	plain := map[string]bool{}
+	for _, s := range scenarios {
+		for _, step := range s.Steps {
+			if p, err := url.PathUnescape(step.Path); err == nil && strings.HasPrefix(p, "/dav"+EncryptedDir+"/") {
+				plain[path.Base(p)] = true
+			}
+		}
+	}
+	for _, f := range env.Upstream.Files() {
+		if strings.HasPrefix(f, EncryptedDir+"/") && plain[path.Base(f)] {
+			t.Errorf("upstream stores %s under its plain name", f)
+		}
+	}
+	if env.Upstream.Stored(EncryptedDir+"/movie.mkv") != nil {
+		t.Error("seeded fixture stored under its plain name")
+	}
+}
+
+func TestFixtureSlice(t *testing.T) {
+	fixtures := map[string][]byte{"f": []byte("0123456789")}
+	for ref, want := range map[string]string{"f": "0123456789", "f#2-4": "234", "f#7-": "789"} {
+		got, err := fixtureSlice(fixtures, ref)
+		if err != nil || string(got) != want {
+			t.Errorf("%s: got %q err=%v, want %q", ref, got, err, want)
+		}
+	}
+	for _, ref := range []string{"g", "f#5-3", "f#0-10", "f#x-"} {
+		if _, err := fixtureSlice(fixtures, ref); err == nil {
+			t.Errorf("%s: expected an error", ref)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package davconform
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/server"
+)
+
+// Env is a proxy built with server.New and pointed at a fresh Upstream.
+type Env struct {
+	URL      string // proxy base URL
+	Upstream *Upstream
+
+	upstream *httptest.Server
+	proxy    *httptest.Server
+	srv      *server.Server
+}
+
+// Start builds the environment. The proxy keeps its config and BoltDB
+// under dataDir; callers run it from a scratch working directory since the
+// config file is written there.
+func Start(dataDir string) (*Env, error) {
+	upstream, err := NewUpstream()
+	if err != nil {
+		return nil, err
+	}
+	env := &Env{Upstream: upstream, upstream: httptest.NewServer(upstream)}
+	upstreamURL, _ := url.Parse(env.upstream.URL)
+	port, _ := strconv.Atoi(upstreamURL.Port())
+
+	cfg := config.Get()
+	cfg.DataDir = filepath.Clean(dataDir)
+	cfg.AlistServer.ServerHost = upstreamURL.Hostname()
+	cfg.AlistServer.ServerPort = port
+	cfg.AlistServer.HTTPS = false
+	cfg.AlistServer.EnableListCache = false
+	cfg.AlistServer.PasswdList = []config.PasswdInfo{Rule()}
+
+	env.srv, err = server.New(cfg)
+	if err != nil {
+		env.upstream.Close()
+		return nil, err
+	}
+	env.proxy = httptest.NewServer(env.srv.Handler())
+	env.URL = env.proxy.URL
+	return env, nil
+}
+
+// Close stops the proxy and the upstream.
+func (e *Env) Close() {
+	e.proxy.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_ = e.srv.Shutdown(ctx)
+	e.upstream.Close()
+}
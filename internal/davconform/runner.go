@@ -0,0 +1,191 @@
+package davconform
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// StepResult is the outcome of one step. Failures is empty when it passed.
+type StepResult struct {
+	Name     string
+	Method   string
+	Path     string
+	Status   int
+	Failures []string
+}
+
+// Result is the outcome of one scenario.
+type Result struct {
+	Client string
+	Steps  []StepResult
+}
+
+// Passed reports whether every step passed.
+func (r Result) Passed() bool {
+	for _, s := range r.Steps {
+		if len(s.Failures) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Run replays s against the proxy at baseURL. A step whose request fails
+// outright stops the scenario, since later steps depend on it.
+func Run(client *http.Client, baseURL string, s Scenario) Result {
+	fixtures := Fixtures()
+	vars := make(map[string]string)
+	result := Result{Client: s.Client}
+	for _, step := range s.Steps {
+		sr := runStep(client, baseURL, s.UserAgent, step, fixtures, vars)
+		result.Steps = append(result.Steps, sr)
+		if sr.Status == 0 {
+			break
+		}
+	}
+	return result
+}
+
+func runStep(client *http.Client, baseURL, userAgent string, step Step, fixtures map[string][]byte, vars map[string]string) StepResult {
+	sr := StepResult{Name: step.Name, Method: step.Method, Path: expand(step.Path, vars)}
+	fail := func(format string, args ...interface{}) {
+		sr.Failures = append(sr.Failures, fmt.Sprintf(format, args...))
+	}
+
+	body := []byte(step.Body)
+	if step.BodyFixture != "" {
+		data, ok := fixtures[step.BodyFixture]
+		if !ok {
+			fail("unknown body fixture %q", step.BodyFixture)
+			return sr
+		}
+		body = data
+	}
+	var reader io.Reader
+	if len(body) > 0 {
+		reader = bytes.NewReader(body)
+		if step.Chunked {
+			// Hide the length from net/http so it sends chunked.
+			reader = io.MultiReader(reader)
+		}
+	}
+	req, err := http.NewRequest(step.Method, baseURL+sr.Path, reader)
+	if err != nil {
+		fail("build request: %v", err)
+		return sr
+	}
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	for k, v := range step.Headers {
+		req.Header.Set(k, expand(v, vars))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		fail("request: %v", err)
+		return sr
+	}
+	defer resp.Body.Close()
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		fail("read body: %v", err)
+	}
+	sr.Status = resp.StatusCode
+	for name, header := range step.Capture {
+		vars[name] = resp.Header.Get(header)
+	}
+	check(step.Expect, resp, got, fixtures, fail)
+	return sr
+}
+
+func check(e Expect, resp *http.Response, body []byte, fixtures map[string][]byte, fail func(string, ...interface{})) {
+	if e.Status != 0 && resp.StatusCode != e.Status {
+		fail("status %d, want %d", resp.StatusCode, e.Status)
+	}
+	if len(e.StatusIn) > 0 && !containsInt(e.StatusIn, resp.StatusCode) {
+		fail("status %d, want one of %v", resp.StatusCode, e.StatusIn)
+	}
+	for name, want := range e.Headers {
+		values := resp.Header.Values(name)
+		if len(values) == 0 {
+			fail("missing header %s", name)
+		} else if got := strings.Join(values, ", "); !strings.Contains(got, want) {
+			fail("header %s: %q does not contain %q", name, got, want)
+		}
+	}
+	for _, name := range e.NoHeaders {
+		if got := resp.Header.Get(name); got != "" {
+			fail("unexpected header %s: %q", name, got)
+		}
+	}
+	for _, want := range e.BodyContains {
+		if !bytes.Contains(body, []byte(want)) {
+			fail("body does not contain %q", want)
+		}
+	}
+	for _, unwanted := range e.BodyLacks {
+		if bytes.Contains(body, []byte(unwanted)) {
+			fail("body contains %q", unwanted)
+		}
+	}
+	if e.EmptyBody && len(body) > 0 {
+		fail("body has %d bytes, want none", len(body))
+	}
+	if e.BodyFixture != "" {
+		want, err := fixtureSlice(fixtures, e.BodyFixture)
+		switch {
+		case err != nil:
+			fail("%v", err)
+		case !bytes.Equal(body, want):
+			fail("body (%d bytes) differs from %s (%d bytes)", len(body), e.BodyFixture, len(want))
+		}
+	}
+}
+
+// fixtureSlice resolves "name" or "name#start-end" (inclusive; an empty end
+// means to the end of the fixture).
+func fixtureSlice(fixtures map[string][]byte, ref string) ([]byte, error) {
+	name, span, ranged := strings.Cut(ref, "#")
+	data, ok := fixtures[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown fixture %q", name)
+	}
+	if !ranged {
+		return data, nil
+	}
+	startStr, endStr, _ := strings.Cut(span, "-")
+	start, err := strconv.Atoi(startStr)
+	if err != nil {
+		return nil, fmt.Errorf("bad fixture range %q", ref)
+	}
+	end := len(data) - 1
+	if endStr != "" {
+		if end, err = strconv.Atoi(endStr); err != nil {
+			return nil, fmt.Errorf("bad fixture range %q", ref)
+		}
+	}
+	if start < 0 || start > end || end >= len(data) {
+		return nil, fmt.Errorf("fixture range %q outside %d bytes", ref, len(data))
+	}
+	return data[start : end+1], nil
+}
+
+func expand(s string, vars map[string]string) string {
+	for name, value := range vars {
+		s = strings.ReplaceAll(s, "{{"+name+"}}", value)
+	}
+	return s
+}
+
+func containsInt(list []int, v int) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
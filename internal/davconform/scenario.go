@@ -0,0 +1,84 @@
+// Package davconform replays request sequences recorded from real WebDAV
+// clients (rclone, Windows Explorer, macOS Finder, Kodi, Infuse) against the
+// proxy backed by a fake Alist, and checks the status, headers and bodies
+// each client depends on. It guards the WebDAV paths that only break with
+// one client's particular habits: Depth handling, LOCK before PUT, chunked
+// uploads, AppleDouble probes and odd Range requests.
+//
+// Scenarios live in scenarios/*.json and are embedded. They are run by the
+// package tests and by cmd/dav-conform.
+package davconform
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+)
+
+//go:embed scenarios/*.json
+var scenarioFiles embed.FS
+
+// Scenario is one client's recorded session.
+type Scenario struct {
+	Client      string `json:"client"`
+	Description string `json:"description"`
+	UserAgent   string `json:"userAgent"`
+	Steps       []Step `json:"steps"`
+}
+
+// Step is one request and what the client needs from the response. Header
+// values and paths may refer to captured values as {{name}}.
+type Step struct {
+	Name    string            `json:"name"`
+	Method  string            `json:"method"`
+	Path    string            `json:"path"`
+	Headers map[string]string `json:"headers,omitempty"`
+	Body    string            `json:"body,omitempty"`
+	// BodyFixture sends a fixture's plaintext as the body.
+	BodyFixture string `json:"bodyFixture,omitempty"`
+	// Chunked sends the body without a Content-Length.
+	Chunked bool   `json:"chunked,omitempty"`
+	Expect  Expect `json:"expect"`
+	// Capture stores response header values for later steps, name → header.
+	Capture map[string]string `json:"capture,omitempty"`
+}
+
+// Expect lists the checks on a response. Zero values are not checked.
+type Expect struct {
+	Status   int   `json:"status,omitempty"`
+	StatusIn []int `json:"statusIn,omitempty"`
+	// Headers must be present and contain the value ("" only checks presence).
+	Headers map[string]string `json:"headers,omitempty"`
+	// NoHeaders must be absent.
+	NoHeaders    []string `json:"noHeaders,omitempty"`
+	BodyContains []string `json:"bodyContains,omitempty"`
+	BodyLacks    []string `json:"bodyLacks,omitempty"`
+	// BodyFixture is "name" or "name#start-end": the body must equal that
+	// fixture's plaintext (or the inclusive byte range of it).
+	BodyFixture string `json:"bodyFixture,omitempty"`
+	EmptyBody   bool   `json:"emptyBody,omitempty"`
+}
+
+// Scenarios returns the embedded scenarios ordered by client name.
+func Scenarios() ([]Scenario, error) {
+	entries, err := scenarioFiles.ReadDir("scenarios")
+	if err != nil {
+		return nil, err
+	}
+	var out []Scenario
+	for _, entry := range entries {
+		data, err := scenarioFiles.ReadFile(path.Join("scenarios", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var s Scenario
+		if err := json.Unmarshal(data, &s); err != nil {
+			return nil, fmt.Errorf("%s: %w", entry.Name(), err)
+		}
+		out = append(out, s)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Client < out[j].Client })
+	return out, nil
+}
@@ -0,0 +1,194 @@
+package davconform
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/encryption"
+)
+
+// The encrypted folder the scenarios work in and the rule guarding it.
+const (
+	EncryptedDir = "/enc"
+	PlainDir     = "/plain"
+	password     = "conform-password"
+)
+
+// Rule is the passwdList entry the proxy under test is configured with.
+func Rule() config.PasswdInfo {
+	return config.PasswdInfo{
+		Password: password,
+		EncType:  "aesctr",
+		Enable:   true,
+		EncName:  true,
+		EncPath:  []string{EncryptedDir + "/*"},
+	}
+}
+
+// fixture is a file seeded into the upstream. Files under EncryptedDir are
+// stored encrypted, with encrypted names; folder names stay plain.
+type fixture struct {
+	name string // key scenarios refer to
+	path string // display path
+	size int
+}
+
+var fixtures = []fixture{
+	{name: "movie", path: EncryptedDir + "/movie.mkv", size: 300*1024 + 17},
+	{name: "episode", path: EncryptedDir + "/剧集 S01/第01集.mp4", size: 96*1024 + 5},
+	{name: "readme", path: PlainDir + "/readme.txt", size: 4096},
+	// Bodies scenarios upload; not seeded.
+	{name: "upload", size: 200*1024 + 123},
+	{name: "small", size: 512},
+}
+
+// Fixtures returns the plaintext of every fixture by name. Contents are
+// deterministic so recorded expectations stay valid across runs.
+func Fixtures() map[string][]byte {
+	out := make(map[string][]byte, len(fixtures))
+	for _, f := range fixtures {
+		out[f.name] = fixtureBytes(f.name, f.size)
+	}
+	return out
+}
+
+// fixtureBytes stamps every line with its offset, so a range decrypted from
+// the wrong position never matches. Random bytes would trip the proxy's
+// check for output that still looks encrypted.
+func fixtureBytes(name string, size int) []byte {
+	var b bytes.Buffer
+	for b.Len() < size {
+		fmt.Fprintf(&b, "%s offset %08d: plain text served by the proxy\n", name, b.Len())
+	}
+	return b.Bytes()[:size]
+}
+
+// Upstream is a fake Alist: a real WebDAV server (golang.org/x/net/webdav,
+// in memory) under /dav and an API that answers every call with an empty
+// success, which is all the proxy's WebDAV paths need.
+type Upstream struct {
+	fs  webdav.FileSystem
+	dav *webdav.Handler
+}
+
+// NewUpstream creates the fake Alist seeded with the fixtures.
+func NewUpstream() (*Upstream, error) {
+	fs := webdav.NewMemFS()
+	u := &Upstream{
+		fs:  fs,
+		dav: &webdav.Handler{Prefix: "/dav", FileSystem: fs, LockSystem: webdav.NewMemLS()},
+	}
+	rule := Rule()
+	names := rule.NameConverter()
+	ctx := context.Background()
+	for name, plain := range Fixtures() {
+		f := findFixture(name)
+		if f.path == "" {
+			continue
+		}
+		data, realPath := plain, f.path
+		if strings.HasPrefix(f.path, EncryptedDir+"/") {
+			enc, err := encryption.NewLatestContentEncryptor(rule.Password, rule.EncType, int64(len(plain)))
+			if err != nil {
+				return nil, err
+			}
+			r, err := enc.EncryptReader(bytes.NewReader(plain), 0)
+			if err != nil {
+				return nil, err
+			}
+			if data, err = io.ReadAll(r); err != nil {
+				return nil, err
+			}
+			realPath = path.Join(path.Dir(f.path), names.ToRealName(path.Base(f.path)))
+		}
+		if err := u.write(ctx, realPath, data); err != nil {
+			return nil, fmt.Errorf("seed %s: %w", f.path, err)
+		}
+	}
+	return u, nil
+}
+
+func findFixture(name string) fixture {
+	for _, f := range fixtures {
+		if f.name == name {
+			return f
+		}
+	}
+	return fixture{}
+}
+
+func (u *Upstream) write(ctx context.Context, p string, data []byte) error {
+	dir := "/"
+	for _, part := range strings.Split(strings.Trim(path.Dir(p), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		dir = path.Join(dir, part)
+		if err := u.fs.Mkdir(ctx, dir, 0o755); err != nil && !os.IsExist(err) {
+			return err
+		}
+	}
+	f, err := u.fs.OpenFile(ctx, p, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Stored returns the raw upstream bytes at p, or nil.
+func (u *Upstream) Stored(p string) []byte {
+	f, err := u.fs.OpenFile(context.Background(), p, os.O_RDONLY, 0)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	data, _ := io.ReadAll(f)
+	return data
+}
+
+// Files returns the path of every file stored upstream.
+func (u *Upstream) Files() []string {
+	var out []string
+	var walk func(dir string)
+	walk = func(dir string) {
+		f, err := u.fs.OpenFile(context.Background(), dir, os.O_RDONLY, 0)
+		if err != nil {
+			return
+		}
+		infos, _ := f.Readdir(-1)
+		f.Close()
+		for _, info := range infos {
+			p := path.Join(dir, info.Name())
+			if info.IsDir() {
+				walk(p)
+			} else {
+				out = append(out, p)
+			}
+		}
+	}
+	walk("/")
+	return out
+}
+
+func (u *Upstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/dav" || strings.HasPrefix(r.URL.Path, "/dav/") {
+		u.dav.ServeHTTP(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"code": 200, "message": "success", "data": nil})
+}
@@ -0,0 +1,197 @@
+package encryption
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func sealAESGCM(t *testing.T, password string, plain []byte) ([]byte, ContentMeta) {
+	t.Helper()
+	enc, err := NewLatestContentEncryptor(password, "aesgcm", int64(len(plain)))
+	if err != nil {
+		t.Fatalf("new encryptor: %v", err)
+	}
+	reader, err := enc.EncryptReader(bytes.NewReader(plain), 0)
+	if err != nil {
+		t.Fatalf("encrypt reader: %v", err)
+	}
+	ciphertext, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("read ciphertext: %v", err)
+	}
+	if int64(len(ciphertext)) != enc.Meta.TotalCiphertextSize() {
+		t.Fatalf("ciphertext size=%d want=%d", len(ciphertext), enc.Meta.TotalCiphertextSize())
+	}
+	return ciphertext, enc.Meta
+}
+
+func aesgcmPlain(n int) []byte {
+	plain := make([]byte, n)
+	for i := range plain {
+		plain[i] = byte(i*7 + i/AEADChunkSize)
+	}
+	return plain
+}
+
+func TestAESGCMRoundtrip(t *testing.T) {
+	for _, size := range []int{0, 1, AEADChunkSize, 3*AEADChunkSize + 123} {
+		plain := aesgcmPlain(size)
+		ciphertext, _ := sealAESGCM(t, "pw", plain)
+		reader, meta, err := AutoDecryptReader("pw", EncTypeAESGCM, bytes.NewReader(ciphertext), int64(len(ciphertext)))
+		if err != nil {
+			t.Fatalf("size %d: auto decrypt: %v", size, err)
+		}
+		if !meta.IsAEAD() || meta.PlainSize != int64(size) {
+			t.Fatalf("size %d: meta=%+v", size, meta)
+		}
+		got, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("size %d: read: %v", size, err)
+		}
+		if !bytes.Equal(got, plain) {
+			t.Fatalf("size %d: plaintext mismatch", size)
+		}
+	}
+}
+
+func TestAESGCMDetectsTampering(t *testing.T) {
+	plain := aesgcmPlain(3 * AEADChunkSize)
+	ciphertext, meta := sealAESGCM(t, "pw", plain)
+	_, tamperAt, _ := meta.AEADChunkSpan(2*AEADChunkSize, 2*AEADChunkSize)
+
+	cases := map[string]func([]byte) []byte{
+		"flipped byte": func(c []byte) []byte { c[tamperAt+10] ^= 1; return c },
+		"truncated":    func(c []byte) []byte { return c[:len(c)-1] },
+		"wrong size":   func(c []byte) []byte { c[31]--; return c },
+	}
+	for name, mutate := range cases {
+		tampered := mutate(append([]byte(nil), ciphertext...))
+		reader, _, err := AutoDecryptReader("pw", EncTypeAESGCM, bytes.NewReader(tampered), int64(len(tampered)))
+		if err != nil {
+			t.Fatalf("%s: auto decrypt: %v", name, err)
+		}
+		got, err := io.ReadAll(reader)
+		if !errors.Is(err, ErrContentAuthFailed) {
+			t.Fatalf("%s: err=%v, want ErrContentAuthFailed", name, err)
+		}
+		if !bytes.Equal(got, plain[:len(got)]) {
+			t.Fatalf("%s: unverified bytes were released", name)
+		}
+	}
+
+	reader, _, err := AutoDecryptReader("other", EncTypeAESGCM, bytes.NewReader(ciphertext), int64(len(ciphertext)))
+	if err != nil {
+		t.Fatalf("wrong password: auto decrypt: %v", err)
+	}
+	if got, err := io.ReadAll(reader); !errors.Is(err, ErrContentAuthFailed) || len(got) != 0 {
+		t.Fatalf("wrong password: got %d bytes err=%v", len(got), err)
+	}
+}
+
+func TestAESGCMChunkSpanSeek(t *testing.T) {
+	plain := aesgcmPlain(4*AEADChunkSize + 500)
+	ciphertext, meta := sealAESGCM(t, "pw", plain)
+	content, err := NewAEADContent("pw", meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range [][2]int64{{0, 9}, {AEADChunkSize - 3, AEADChunkSize + 3}, {2*AEADChunkSize + 17, int64(len(plain)) - 1}} {
+		first, cStart, cEnd := meta.AEADChunkSpan(r[0], r[1])
+		if cEnd >= int64(len(ciphertext)) {
+			t.Fatalf("range %v: span end %d past ciphertext", r, cEnd)
+		}
+		reader := content.DecryptReader(bytes.NewReader(ciphertext[cStart:cEnd+1]), first)
+		if err := skip(reader, r[0]-first*AEADChunkSize); err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, r[1]-r[0]+1)
+		if _, err := io.ReadFull(reader, got); err != nil {
+			t.Fatalf("range %v: %v", r, err)
+		}
+		if !bytes.Equal(got, plain[r[0]:r[1]+1]) {
+			t.Fatalf("range %v: plaintext mismatch", r)
+		}
+	}
+}
+
+func skip(r io.Reader, n int64) error {
+	_, err := io.CopyN(io.Discard, r, n)
+	return err
+}
+
+func TestAESGCMRejectsResumeAndLegacyCipher(t *testing.T) {
+	enc, err := NewLatestContentEncryptor("pw", "aes-gcm", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := enc.EncryptReader(bytes.NewReader(nil), 5); err == nil {
+		t.Fatal("expected resume to be rejected")
+	}
+	if _, err := NewFlowEnc("pw", "aesgcm", 10); err == nil {
+		t.Fatal("expected headerless aesgcm cipher to be rejected")
+	}
+	if _, err := BuildV2Header(EncTypeAESGCM, 10, make([]byte, 16)); err == nil {
+		t.Fatal("expected v2 header for aesgcm to be rejected")
+	}
+}
+
+func TestXChaCha20Poly1305Content(t *testing.T) {
+	plain := aesgcmPlain(2*AEADChunkSize + 77)
+	enc, err := NewLatestContentEncryptor("pw", "xchacha20-poly1305", int64(len(plain)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := enc.EncryptReader(bytes.NewReader(plain), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(ciphertext[:6]) != "XCHAPO" || int64(len(ciphertext)) != AEADCiphertextSize(int64(len(plain))) {
+		t.Fatalf("magic=%q size=%d", ciphertext[:6], len(ciphertext))
+	}
+
+	// Seek into the second chunk the way a range request does.
+	meta := enc.Meta
+	content, err := NewAEADContent("pw", meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start, end := int64(AEADChunkSize+5), int64(2*AEADChunkSize+10)
+	first, cStart, cEnd := meta.AEADChunkSpan(start, end)
+	opened := content.DecryptReader(bytes.NewReader(ciphertext[cStart:cEnd+1]), first)
+	if err := skip(opened, start-first*AEADChunkSize); err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, end-start+1)
+	if _, err := io.ReadFull(opened, got); err != nil || !bytes.Equal(got, plain[start:end+1]) {
+		t.Fatalf("seek read err=%v equal=%v", err, bytes.Equal(got, plain[start:end+1]))
+	}
+
+	tampered := append([]byte(nil), ciphertext...)
+	tampered[cStart+3] ^= 1
+	auto, _, err := AutoDecryptReader("pw", EncTypeXChaCha20Poly1305, bytes.NewReader(tampered), int64(len(tampered)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(auto); !errors.Is(err, ErrContentAuthFailed) {
+		t.Fatalf("tampered err=%v", err)
+	}
+
+	// The same salt under the other suite must not open: keys are separated.
+	meta.EncType = EncTypeAESGCM
+	other, err := NewAEADContent("pw", meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(other.DecryptReader(bytes.NewReader(ciphertext[32:]), 0)); !errors.Is(err, ErrContentAuthFailed) {
+		t.Fatalf("cross-suite open err=%v", err)
+	}
+	if !IsAEADEncType("xchacha") || IsAEADEncType("chacha20") {
+		t.Fatal("IsAEADEncType mismatch")
+	}
+}
@@ -5,8 +5,12 @@ import (
 	"testing"
 )
 
-// BenchmarkCipherEncrypt benchmarks encryption throughput
+// BenchmarkCipherEncrypt benchmarks encryption throughput. Compare runs with
+// and without -tags purego (which turns off the assembly in crypto/aes and
+// x/crypto/chacha20) to see what the CPU features reported by CPUFeatures
+// are worth.
 func BenchmarkCipherEncrypt(b *testing.B) {
+	b.Logf("cpu features: %v", CPUFeatures())
 	sizes := []struct {
 		name string
 		size int
@@ -113,3 +117,28 @@ func BenchmarkMixBase64(b *testing.B) {
 		mix64.Decode(encoded)
 	}
 }
+
+// BenchmarkRC4MD5Keystream compares the segment-wise RC4 loop with the
+// byte-at-a-time reference it replaced.
+func BenchmarkRC4MD5Keystream(b *testing.B) {
+	const size = 4 * 1024 * 1024
+	data := make([]byte, size)
+	rand.Read(data)
+	impls := []struct {
+		name    string
+		encrypt func(*RC4MD5, []byte)
+	}{
+		{"segmented", (*RC4MD5).Encrypt},
+		{"reference", referenceRC4MD5Encrypt},
+	}
+	for _, impl := range impls {
+		b.Run(impl.name, func(b *testing.B) {
+			c, _ := NewRC4MD5("benchmarkpassword", size)
+			b.SetBytes(size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				impl.encrypt(c, data)
+			}
+		})
+	}
+}
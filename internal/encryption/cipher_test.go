@@ -192,3 +192,54 @@ func min(a, b int) int {
 	}
 	return b
 }
+
+// referenceRC4MD5Encrypt is the original byte-at-a-time PRGA, kept to pin the
+// optimized loop to identical output across segment resets.
+func referenceRC4MD5Encrypt(r *RC4MD5, data []byte) {
+	for k := 0; k < len(data); k++ {
+		r.i = (r.i + 1) % 256
+		r.j = (r.j + int(r.sbox[r.i])) % 256
+		r.sbox[r.i], r.sbox[r.j] = r.sbox[r.j], r.sbox[r.i]
+		data[k] ^= r.sbox[(int(r.sbox[r.i])+int(r.sbox[r.j]))%256]
+		r.position++
+		if r.position%segmentPosition == 0 {
+			r.resetKSA()
+		}
+	}
+}
+
+func TestRC4MD5MatchesReferenceAcrossSegments(t *testing.T) {
+	const size = 2*segmentPosition + 12345
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+
+	for _, start := range []int64{0, 1, segmentPosition - 3, segmentPosition + 99} {
+		fast, _ := NewRC4MD5("testpassword", size)
+		ref, _ := NewRC4MD5("testpassword", size)
+		if err := fast.SetPosition(start); err != nil {
+			t.Fatal(err)
+		}
+		if err := ref.SetPosition(start); err != nil {
+			t.Fatal(err)
+		}
+		got := append([]byte(nil), data[start:]...)
+		want := append([]byte(nil), data[start:]...)
+		// Odd chunk sizes make chunks straddle segment boundaries.
+		for off := 0; off < len(got); off += 77777 {
+			end := off + 77777
+			if end > len(got) {
+				end = len(got)
+			}
+			fast.Encrypt(got[off:end])
+			referenceRC4MD5Encrypt(ref, want[off:end])
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("start=%d: optimized RC4 output differs from reference", start)
+		}
+		if fast.Position() != ref.Position() {
+			t.Fatalf("start=%d: position %d != %d", start, fast.Position(), ref.Position())
+		}
+	}
+}
@@ -18,20 +18,37 @@ const (
 	contentHeaderMagicLen = 6
 	contentHeaderSize     = 32
 	contentHeaderReserved = 0
+
+	// MaxPasswordVersion is the largest password version a content header
+	// can record.
+	MaxPasswordVersion = 255
+	// aeadSizeMask keeps the plaintext size bits of a V3 size field; the top
+	// byte carries the password version.
+	aeadSizeMask = 1<<56 - 1
 )
 
-// TODO(V3): Consider using ChaCha20-Poly1305 or AES-GCM (AEAD modes) for
-// authenticated encryption. Current V2 uses plain stream ciphers without
-// integrity verification, making ciphertext tampering undetectable.
+// Password versions let a folder rotate its password gradually. Version 1 is
+// the folder's original password and is never written: untagged headers (and
+// headerless V1 content) mean version 1, so existing files are unchanged.
+// Later versions are recorded in the header, in the reserved flags byte (7)
+// of V2 and in the top byte of the plaintext size field (24) of V3, whose
+// flags byte holds the chunk shift. V2 readers that predate versions ignore
+// the flags byte and still decrypt tagged files given the right password.
+
+// V2 uses plain stream ciphers without integrity verification, so tampering
+// goes undetected; folders that need integrity use aesgcm or xchacha20poly1305
+// (V3, see aesgcm.go).
 
 func ContentHeaderSize() int64 {
 	return contentHeaderSize
 }
 
 var contentHeaderMagic = map[EncType]string{
-	EncTypeAESCTR:   "AECTR2",
-	EncTypeChaCha20: "CHC202",
-	EncTypeRC4MD5:   "RC4MD2",
+	EncTypeAESCTR:            "AECTR2",
+	EncTypeChaCha20:          "CHC202",
+	EncTypeRC4MD5:            "RC4MD2",
+	EncTypeAESGCM:            "AESGCM",
+	EncTypeXChaCha20Poly1305: "XCHAPO",
 }
 
 type ContentMeta struct {
@@ -41,6 +58,9 @@ type ContentMeta struct {
 	PlainSize      int64
 	CiphertextSize int64
 	NonceField     []byte
+	// PasswordVersion is the password version recorded in the header; 0
+	// for untagged content, which was encrypted with version 1.
+	PasswordVersion int
 }
 
 func LegacyContentMeta(encType EncType, ciphertextSize int64) ContentMeta {
@@ -53,11 +73,37 @@ func LegacyContentMeta(encType EncType, ciphertextSize int64) ContentMeta {
 	}
 }
 
+// EffectivePasswordVersion is the password version the content was
+// encrypted with: PasswordVersion, or 1 for untagged content.
+func (m ContentMeta) EffectivePasswordVersion() int {
+	if m.PasswordVersion <= 1 {
+		return 1
+	}
+	return m.PasswordVersion
+}
+
 func (m ContentMeta) IsV2() bool {
 	return m.Version == ContentVersionV2
 }
 
+// IsAEAD reports whether the content is chunked authenticated content (V3).
+func (m ContentMeta) IsAEAD() bool {
+	return m.Version == ContentVersionV3
+}
+
+// HasContentHeader reports whether the content starts with a content header,
+// i.e. whether PlainSize was read from the file rather than guessed.
+func (m ContentMeta) HasContentHeader() bool {
+	return m.Version >= ContentVersionV2
+}
+
+// UpstreamOffset maps a plaintext offset to its ciphertext offset. For AEAD
+// content that is the start of the sealed chunk holding plainOffset.
 func (m ContentMeta) UpstreamOffset(plainOffset int64) int64 {
+	if m.IsAEAD() {
+		_, start, _ := m.AEADChunkSpan(plainOffset, plainOffset)
+		return start
+	}
 	if !m.IsV2() {
 		return plainOffset
 	}
@@ -68,6 +114,9 @@ func (m ContentMeta) TotalCiphertextSize() int64 {
 	if m.CiphertextSize > 0 {
 		return m.CiphertextSize
 	}
+	if m.IsAEAD() {
+		return AEADCiphertextSize(m.PlainSize)
+	}
 	if m.IsV2() && m.PlainSize > 0 {
 		return m.PlainSize + m.HeaderLen
 	}
@@ -83,6 +132,13 @@ func generateRandomNonceField() ([]byte, error) {
 }
 
 func BuildV2Header(encType EncType, plainSize int64, nonceField []byte) ([]byte, error) {
+	if IsAEADEncType(string(encType)) {
+		return nil, fmt.Errorf("%s content uses the v3 header", encType)
+	}
+	return buildContentHeader(encType, ContentVersionV2, contentHeaderReserved, 0, plainSize, nonceField)
+}
+
+func buildContentHeader(encType EncType, version int, flags byte, passwordVersion int, plainSize int64, nonceField []byte) ([]byte, error) {
 	magic, ok := contentHeaderMagic[encType]
 	if !ok {
 		return nil, fmt.Errorf("unsupported v2 content header encType: %s", encType)
@@ -93,12 +149,27 @@ func BuildV2Header(encType EncType, plainSize int64, nonceField []byte) ([]byte,
 	if len(nonceField) != 16 {
 		return nil, fmt.Errorf("nonce field must be 16 bytes")
 	}
+	if passwordVersion < 0 || passwordVersion > MaxPasswordVersion {
+		return nil, fmt.Errorf("password version %d out of range", passwordVersion)
+	}
+	if passwordVersion == 1 {
+		passwordVersion = 0
+	}
 	header := make([]byte, contentHeaderSize)
 	copy(header[:contentHeaderMagicLen], []byte(magic))
-	header[6] = byte(ContentVersionV2)
-	header[7] = contentHeaderReserved
+	header[6] = byte(version)
+	header[7] = flags
 	copy(header[8:24], nonceField)
-	binary.BigEndian.PutUint64(header[24:32], uint64(plainSize))
+	sizeField := uint64(plainSize)
+	if version == ContentVersionV3 {
+		if sizeField > aeadSizeMask {
+			return nil, fmt.Errorf("plain size too large")
+		}
+		sizeField |= uint64(passwordVersion) << 56
+	} else if passwordVersion > 0 {
+		header[7] = byte(passwordVersion)
+	}
+	binary.BigEndian.PutUint64(header[24:32], sizeField)
 	return header, nil
 }
 
@@ -115,29 +186,55 @@ func ParseContentHeader(encType EncType, prefix []byte, ciphertextSize int64) (C
 		return meta, false, fmt.Errorf("incomplete v2 content header")
 	}
 	version := int(prefix[6])
-	if version != ContentVersionV2 {
+	wantVersion := ContentVersionV2
+	if IsAEADEncType(string(encType)) {
+		wantVersion = ContentVersionV3
+		if prefix[7] != aeadChunkShift {
+			return meta, false, fmt.Errorf("unsupported %s chunk shift: %d", encType, prefix[7])
+		}
+	}
+	if version != wantVersion {
 		return meta, false, fmt.Errorf("unsupported content version: %d", version)
 	}
-	plainSize := int64(binary.BigEndian.Uint64(prefix[24:32]))
+	sizeField := binary.BigEndian.Uint64(prefix[24:32])
+	passwordVersion := int(prefix[7])
+	if version == ContentVersionV3 {
+		passwordVersion = int(sizeField >> 56)
+		sizeField &= aeadSizeMask
+	}
+	plainSize := int64(sizeField)
 	if plainSize < 0 {
 		return meta, false, fmt.Errorf("invalid plaintext size in content header")
 	}
 	nonceField := append([]byte(nil), prefix[8:24]...)
 	meta = ContentMeta{
-		EncType:        encType,
-		Version:        ContentVersionV2,
-		HeaderLen:      contentHeaderSize,
-		PlainSize:      plainSize,
-		CiphertextSize: ciphertextSize,
-		NonceField:     nonceField,
+		EncType:         encType,
+		Version:         version,
+		HeaderLen:       contentHeaderSize,
+		PlainSize:       plainSize,
+		CiphertextSize:  ciphertextSize,
+		NonceField:      nonceField,
+		PasswordVersion: passwordVersion,
 	}
 	if meta.CiphertextSize <= 0 {
-		meta.CiphertextSize = meta.PlainSize + meta.HeaderLen
+		meta.CiphertextSize = meta.TotalCiphertextSize()
 	}
 	return meta, true, nil
 }
 
+// PasswordLookup returns the password of a password version (1 for
+// untagged content).
+type PasswordLookup func(passwordVersion int) (string, error)
+
+// AutoDecryptReader decrypts content in any format with password, whatever
+// password version its header records.
 func AutoDecryptReader(password string, encType EncType, ciphertext io.Reader, ciphertextSize int64) (io.Reader, ContentMeta, error) {
+	return AutoDecryptReaderWithLookup(func(int) (string, error) { return password, nil }, encType, ciphertext, ciphertextSize)
+}
+
+// AutoDecryptReaderWithLookup is AutoDecryptReader for folders with several
+// password versions: the password is picked by the version in the header.
+func AutoDecryptReaderWithLookup(lookup PasswordLookup, encType EncType, ciphertext io.Reader, ciphertextSize int64) (io.Reader, ContentMeta, error) {
 	encType = EncType(normalizeEncType(string(encType)))
 	if encType == "" {
 		encType = EncTypeAESCTR
@@ -156,6 +253,17 @@ func AutoDecryptReader(password string, encType EncType, ciphertext io.Reader, c
 	if err != nil {
 		return nil, ContentMeta{}, err
 	}
+	password, err := lookup(meta.EffectivePasswordVersion())
+	if err != nil {
+		return nil, ContentMeta{}, err
+	}
+	if ok && meta.IsAEAD() {
+		content, err := NewAEADContent(password, meta)
+		if err != nil {
+			return nil, ContentMeta{}, err
+		}
+		return content.DecryptReader(ciphertext, 0), meta, nil
+	}
 	if ok {
 		cipherImpl, err := NewCipherV2(encType, password, meta.PlainSize, meta.NonceField)
 		if err != nil {
@@ -176,17 +284,66 @@ type ContentEncryptor struct {
 	Cipher Cipher
 	Meta   ContentMeta
 	Header []byte
+
+	// aead is set instead of Cipher for authenticated (V3) content.
+	aead *AEADContent
 }
 
 func NewLatestContentEncryptor(password, encType string, plainSize int64) (*ContentEncryptor, error) {
+	return NewContentEncryptor(password, encType, plainSize, ContentVersionV2)
+}
+
+// NewContentEncryptor encrypts new content in the given format version.
+// ContentVersionV1 writes the legacy headerless layout whose keystream only
+// depends on password and size, for readers that predate the header; any
+// other version writes V2. Authenticated types always write V3.
+func NewContentEncryptor(password, encType string, plainSize int64, version int) (*ContentEncryptor, error) {
+	return NewVersionedContentEncryptor(password, encType, plainSize, version, 1)
+}
+
+// NewVersionedContentEncryptor is NewContentEncryptor for a password other
+// than version 1 of its folder: passwordVersion is recorded in the header.
+// Headerless V1 content cannot record it and is refused.
+func NewVersionedContentEncryptor(password, encType string, plainSize int64, version, passwordVersion int) (*ContentEncryptor, error) {
+	if passwordVersion < 1 || passwordVersion > MaxPasswordVersion {
+		return nil, fmt.Errorf("password version %d out of range", passwordVersion)
+	}
 	normalized := EncType(normalizeEncType(encType))
 	if normalized == "" {
 		normalized = EncTypeAESCTR
 	}
+	if version == ContentVersionV1 && !IsAEADEncType(string(normalized)) {
+		if passwordVersion > 1 {
+			return nil, fmt.Errorf("headerless v1 content cannot record password version %d", passwordVersion)
+		}
+		cipherImpl, err := NewCipher(normalized, password, plainSize)
+		if err != nil {
+			return nil, err
+		}
+		return &ContentEncryptor{Cipher: cipherImpl, Meta: LegacyContentMeta(normalized, plainSize)}, nil
+	}
 	nonceField, err := generateRandomNonceField()
 	if err != nil {
 		return nil, err
 	}
+	if IsAEADEncType(string(normalized)) {
+		meta := ContentMeta{
+			EncType:        normalized,
+			Version:        ContentVersionV3,
+			HeaderLen:      contentHeaderSize,
+			PlainSize:      plainSize,
+			CiphertextSize: AEADCiphertextSize(plainSize),
+			NonceField:     nonceField,
+		}
+		if passwordVersion > 1 {
+			meta.PasswordVersion = passwordVersion
+		}
+		content, err := NewAEADContent(password, meta)
+		if err != nil {
+			return nil, err
+		}
+		return &ContentEncryptor{Meta: meta, Header: content.Header(), aead: content}, nil
+	}
 	meta := ContentMeta{
 		EncType:        normalized,
 		Version:        ContentVersionV2,
@@ -195,7 +352,10 @@ func NewLatestContentEncryptor(password, encType string, plainSize int64) (*Cont
 		CiphertextSize: plainSize + contentHeaderSize,
 		NonceField:     nonceField,
 	}
-	header, err := BuildV2Header(normalized, plainSize, nonceField)
+	if passwordVersion > 1 {
+		meta.PasswordVersion = passwordVersion
+	}
+	header, err := buildContentHeader(normalized, ContentVersionV2, contentHeaderReserved, meta.PasswordVersion, plainSize, nonceField)
 	if err != nil {
 		return nil, err
 	}
@@ -211,6 +371,12 @@ func NewLatestContentEncryptor(password, encType string, plainSize int64) (*Cont
 }
 
 func (e *ContentEncryptor) EncryptReader(r io.Reader, startOffset int64) (io.Reader, error) {
+	if e != nil && e.aead != nil {
+		if startOffset != 0 {
+			return nil, fmt.Errorf("%s content cannot be encrypted from offset %d", e.Meta.EncType, startOffset)
+		}
+		return e.aead.EncryptReader(r), nil
+	}
 	if e == nil || e.Cipher == nil {
 		return nil, fmt.Errorf("content encryptor is nil")
 	}
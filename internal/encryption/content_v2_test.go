@@ -64,6 +64,36 @@ func TestLatestContentEncryptorUsesRandomNonceField(t *testing.T) {
 	}
 }
 
+func TestContentEncryptorV1WritesLegacyLayout(t *testing.T) {
+	plain := bytes.Repeat([]byte("legacy-upload-"), 300)
+	size := int64(len(plain))
+	enc, err := NewContentEncryptor("test-password", "chacha20", size, ContentVersionV1)
+	if err != nil {
+		t.Fatalf("v1 encryptor: %v", err)
+	}
+	if enc.Meta.HasContentHeader() || len(enc.Header) != 0 {
+		t.Fatalf("v1 meta=%+v header=%d bytes", enc.Meta, len(enc.Header))
+	}
+	reader, err := enc.EncryptReader(bytes.NewReader(plain), 0)
+	if err != nil {
+		t.Fatalf("encrypt reader: %v", err)
+	}
+	ciphertext, _ := io.ReadAll(reader)
+	legacy, err := NewFlowEnc("test-password", "chacha20", size)
+	if err != nil {
+		t.Fatalf("legacy flow: %v", err)
+	}
+	want, _ := io.ReadAll(legacy.EncryptReader(bytes.NewReader(plain)))
+	if !bytes.Equal(ciphertext, want) {
+		t.Fatal("v1 ciphertext differs from the legacy flow cipher")
+	}
+
+	aead, err := NewContentEncryptor("test-password", "aesgcm", size, ContentVersionV1)
+	if err != nil || !aead.Meta.IsAEAD() {
+		t.Fatalf("aesgcm with v1 requested: meta=%+v err=%v", aead.Meta, err)
+	}
+}
+
 func TestAutoDecryptReaderMaintainsLegacyCompatibility(t *testing.T) {
 	password := "legacy-password"
 	fileSize := int64(4096)
@@ -151,3 +181,73 @@ func TestV2PBKDF2CacheHitExtendsTTL(t *testing.T) {
 		}
 	}
 }
+
+func TestVersionedContentEncryptorTagsHeader(t *testing.T) {
+	plain := bytes.Repeat([]byte("rotated-password-"), 5000)
+	passwords := map[int]string{1: "first-password", 3: "third-password"}
+	lookup := func(version int) (string, error) {
+		if p, ok := passwords[version]; ok {
+			return p, nil
+		}
+		return "", io.ErrUnexpectedEOF
+	}
+	for _, encType := range []string{"aesctr", "chacha20", "rc4md5", "aesgcm", "xchacha20poly1305"} {
+		t.Run(encType, func(t *testing.T) {
+			for _, version := range []int{1, 3} {
+				enc, err := NewVersionedContentEncryptor(passwords[version], encType, int64(len(plain)), ContentVersionV2, version)
+				if err != nil {
+					t.Fatalf("v%d encryptor: %v", version, err)
+				}
+				reader, err := enc.EncryptReader(bytes.NewReader(plain), 0)
+				if err != nil {
+					t.Fatalf("encrypt reader: %v", err)
+				}
+				ciphertext, _ := io.ReadAll(reader)
+				meta, ok, err := ParseContentHeader(EncType(encType), ciphertext, int64(len(ciphertext)))
+				if err != nil || !ok {
+					t.Fatalf("parse header ok=%v err=%v", ok, err)
+				}
+				if meta.EffectivePasswordVersion() != version || meta.PlainSize != int64(len(plain)) {
+					t.Fatalf("v%d header: version=%d plainSize=%d", version, meta.PasswordVersion, meta.PlainSize)
+				}
+				if version == 1 && meta.PasswordVersion != 0 {
+					t.Fatalf("version 1 must stay untagged, got %d", meta.PasswordVersion)
+				}
+				decReader, _, err := AutoDecryptReaderWithLookup(lookup, EncType(encType), bytes.NewReader(ciphertext), int64(len(ciphertext)))
+				if err != nil {
+					t.Fatalf("v%d decrypt reader: %v", version, err)
+				}
+				decrypted, err := io.ReadAll(decReader)
+				if err != nil || !bytes.Equal(decrypted, plain) {
+					t.Fatalf("v%d decrypt mismatch (err=%v)", version, err)
+				}
+			}
+		})
+	}
+}
+
+func TestVersionedContentEncryptorRefusesHeaderlessV2Password(t *testing.T) {
+	if _, err := NewVersionedContentEncryptor("pw", "aesctr", 10, ContentVersionV1, 2); err == nil {
+		t.Fatal("expected headerless content with password version 2 to be refused")
+	}
+	if _, err := NewVersionedContentEncryptor("pw", "aesctr", 10, ContentVersionV2, MaxPasswordVersion+1); err == nil {
+		t.Fatal("expected out-of-range password version to be refused")
+	}
+}
+
+func TestAutoDecryptReaderWithLookupReportsUnknownVersion(t *testing.T) {
+	enc, err := NewVersionedContentEncryptor("second", "aesctr", 4, ContentVersionV2, 2)
+	if err != nil {
+		t.Fatalf("encryptor: %v", err)
+	}
+	reader, _ := enc.EncryptReader(strings.NewReader("data"), 0)
+	ciphertext, _ := io.ReadAll(reader)
+	var asked int
+	_, _, err = AutoDecryptReaderWithLookup(func(version int) (string, error) {
+		asked = version
+		return "", io.ErrUnexpectedEOF
+	}, EncTypeAESCTR, bytes.NewReader(ciphertext), int64(len(ciphertext)))
+	if err == nil || asked != 2 {
+		t.Fatalf("asked=%d err=%v, want lookup of version 2 to fail", asked, err)
+	}
+}
@@ -0,0 +1,34 @@
+package encryption
+
+import "runtime"
+
+// This file only reports CPU features. The ciphers themselves come from
+// crypto/aes and x/crypto/chacha20, which pick their assembly paths on their
+// own; nothing here selects or replaces an implementation.
+
+// HardwareAES reports whether crypto/aes can use CPU AES instructions on
+// this machine. Without them Go falls back to table-based AES, which on
+// low-end boxes is several times slower than ChaCha20.
+func HardwareAES() bool {
+	return hasHardwareAES
+}
+
+// PreferredEncType returns the cheapest streaming cipher for this CPU.
+func PreferredEncType() EncType {
+	if hasHardwareAES {
+		return EncTypeAESCTR
+	}
+	return EncTypeChaCha20
+}
+
+// CPUFeatures reports the CPU features the standard cipher implementations
+// can use on this build and machine, for stats and startup diagnostics.
+func CPUFeatures() map[string]interface{} {
+	return map[string]interface{}{
+		"arch":          runtime.GOARCH,
+		"hardware_aes":  hasHardwareAES,
+		"chacha20_simd": hasChaCha20SIMD,
+		"preferred_enc": string(PreferredEncType()),
+		"purego":        pureGoBuild,
+	}
+}
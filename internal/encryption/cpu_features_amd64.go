@@ -0,0 +1,13 @@
+//go:build amd64 && !purego
+
+package encryption
+
+import "golang.org/x/sys/cpu"
+
+// Mirrors the feature set crypto/aes needs for its AES-NI path.
+var hasHardwareAES = cpu.X86.HasAES && cpu.X86.HasSSE41 && cpu.X86.HasSSSE3
+
+// x/crypto/chacha20 has no amd64 assembly; it uses the generic code.
+const hasChaCha20SIMD = false
+
+const pureGoBuild = false
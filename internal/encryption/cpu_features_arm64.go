@@ -0,0 +1,14 @@
+//go:build arm64 && !purego
+
+package encryption
+
+import "golang.org/x/sys/cpu"
+
+// Cortex-A53/A72 boards without the crypto extension (e.g. Raspberry Pi 3/4)
+// report false here and run AES in software.
+var hasHardwareAES = cpu.ARM64.HasAES
+
+// x/crypto/chacha20 ships a NEON implementation for arm64.
+const hasChaCha20SIMD = true
+
+const pureGoBuild = false
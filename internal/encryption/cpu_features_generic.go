@@ -0,0 +1,11 @@
+//go:build !amd64 && !arm64 && !purego
+
+package encryption
+
+// Other architectures are treated as having no AES instructions so aesctr
+// users get the ChaCha20 recommendation.
+var hasHardwareAES = false
+
+const hasChaCha20SIMD = false
+
+const pureGoBuild = false
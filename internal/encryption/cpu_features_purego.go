@@ -0,0 +1,11 @@
+//go:build purego
+
+package encryption
+
+// -tags purego disables assembly in crypto/aes and x/crypto/chacha20 alike,
+// so report the generic code paths.
+var hasHardwareAES = false
+
+const hasChaCha20SIMD = false
+
+const pureGoBuild = true
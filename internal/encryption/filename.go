@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"errors"
+	"fmt"
 	"net/url"
 	"path"
 	"regexp"
@@ -261,67 +262,19 @@ var crc6 = NewCRC6()
 // EncodeName encrypts a filename using password and encryption type
 // Uses cached PBKDF2 key and MixBase64 instance for performance
 func EncodeName(password, encType, plainName string) string {
-	passwdOutward := GetPasswdOutward(password, encType)
-	mix64 := GetCachedMixBase64(passwdOutward)
-
-	encodedName := mix64.EncodeString(plainName)
-
-	// Calculate CRC6 checksum
-	checkData := encodedName + passwdOutward
-	crc6Bit := crc6.Checksum([]byte(checkData))
-	crc6Check := GetSourceChar(crc6Bit)
-
-	return encodedName + string(crc6Check)
+	return EncodeNameDialect(password, encType, plainName, NameDialectDefault)
 }
 
 // DecodeName decrypts a filename, returns empty string if decryption fails
 // Uses cached PBKDF2 key and MixBase64 instance for performance
 func DecodeName(password, encType, encodedName string) string {
-	if len(encodedName) < 2 {
-		return ""
-	}
-
-	crc6Check := encodedName[len(encodedName)-1]
-	passwdOutward := GetPasswdOutward(password, encType)
-	mix64 := GetCachedMixBase64(passwdOutward)
-
-	subEncName := encodedName[:len(encodedName)-1]
-
-	// Verify CRC6
-	checkData := subEncName + passwdOutward
-	crc6Bit := crc6.Checksum([]byte(checkData))
-	if GetSourceChar(crc6Bit) != crc6Check {
-		return ""
-	}
-
-	// Decode
-	decoded, err := mix64.DecodeString(subEncName)
-	if err != nil {
-		return ""
-	}
-
-	return decoded
+	return DecodeNameDialect(password, encType, encodedName, NameDialectDefault)
 }
 
 // DecodeNameLoose attempts decode without CRC verification and applies heuristics.
 // Returns empty string if the result looks invalid.
 func DecodeNameLoose(password, encType, encodedName string) string {
-	if len(encodedName) < 2 {
-		return ""
-	}
-
-	passwdOutward := GetPasswdOutward(password, encType)
-	mix64 := GetCachedMixBase64(passwdOutward)
-
-	subEncName := encodedName[:len(encodedName)-1]
-	decoded, err := mix64.DecodeString(subEncName)
-	if err != nil {
-		return ""
-	}
-	if !isMostlyPrintable(decoded) {
-		return ""
-	}
-	return decoded
+	return DecodeNameLooseDialect(password, encType, encodedName, NameDialectDefault)
 }
 
 // ConvertShowName converts encrypted filename to display name
@@ -337,6 +290,16 @@ func ConvertShowNameWithOptions(password, encType, pathText string, allowLoose b
 // ConvertShowNameWithSuffixOptions converts encrypted filename to display name with
 // optional configured encrypted suffix and loose decode fallback.
 func ConvertShowNameWithSuffixOptions(password, encType, pathText, encSuffix string, allowLoose bool) string {
+	return ConvertShowNameDialect(password, encType, pathText, encSuffix, NameDialectDefault, allowLoose)
+}
+
+// ConvertShowNameDialect converts an encrypted filename to its display name
+// with the given name dialect.
+func ConvertShowNameDialect(password, encType, pathText, encSuffix, dialect string, allowLoose bool) string {
+	return newNameCodec(password, encType, dialect, NameKDFLegacy).showName(pathText, encSuffix, allowLoose)
+}
+
+func (c nameCodec) showName(pathText, encSuffix string, allowLoose bool) string {
 	// URL decode the path using PathUnescape (NOT QueryUnescape!)
 	// QueryUnescape converts '+' to space, but '+' is valid in MixBase64
 	decoded, err := url.PathUnescape(pathText)
@@ -348,6 +311,17 @@ func ConvertShowNameWithSuffixOptions(password, encType, pathText, encSuffix str
 	ext := path.Ext(fileName)
 	encName := strings.TrimSuffix(fileName, ext)
 	normSuffix := NormalizeEncSuffix(encSuffix)
+	if c.dialect == NameDialectBaseName {
+		// The extension was never encrypted and stays in clear.
+		showName := c.decode(encName)
+		if showName == "" && allowLoose {
+			showName = c.decodeLoose(encName)
+		}
+		if showName == "" {
+			return OrigPrefix + fileName
+		}
+		return showName + ext
+	}
 
 	// Keep legacy (faster) behavior when encrypted suffix is not in use.
 	// Hidden extension flow is enabled only when the configured suffix matches.
@@ -356,27 +330,27 @@ func ConvertShowNameWithSuffixOptions(password, encType, pathText, encSuffix str
 	showName := ""
 	dupSuffix := ""
 	if !useHiddenSuffixFlow {
-		showName = DecodeName(password, encType, encName)
+		showName = c.decode(encName)
 		if showName == "" && allowLoose {
-			showName = DecodeNameLoose(password, encType, encName)
+			showName = c.decodeLoose(encName)
 		}
 	} else {
-		showName = DecodeName(password, encType, encName)
+		showName = c.decode(encName)
 		if showName == "" {
 			trimmed, suffix, ok := splitTrailingDuplicateSuffix(encName)
 			if ok {
-				showName = DecodeName(password, encType, trimmed)
+				showName = c.decode(trimmed)
 				if showName != "" {
 					dupSuffix = suffix
 				}
 			}
 		}
 		if showName == "" && allowLoose {
-			showName = DecodeNameLoose(password, encType, encName)
+			showName = c.decodeLoose(encName)
 			if showName == "" {
 				trimmed, suffix, ok := splitTrailingDuplicateSuffix(encName)
 				if ok {
-					showName = DecodeNameLoose(password, encType, trimmed)
+					showName = c.decodeLoose(trimmed)
 					if showName != "" {
 						dupSuffix = suffix
 					}
@@ -446,6 +420,16 @@ func ConvertRealName(password, encType, pathText string) string {
 
 // ConvertRealNameWithSuffix converts display filename to encrypted name with custom suffix
 func ConvertRealNameWithSuffix(password, encType, pathText, encSuffix string) string {
+	return ConvertRealNameDialect(password, encType, pathText, encSuffix, NameDialectDefault)
+}
+
+// ConvertRealNameDialect converts a display filename to its encrypted name
+// with the given name dialect.
+func ConvertRealNameDialect(password, encType, pathText, encSuffix, dialect string) string {
+	return newNameCodec(password, encType, dialect, NameKDFLegacy).realName(pathText, encSuffix)
+}
+
+func (c nameCodec) realName(pathText, encSuffix string) string {
 	fileName := path.Base(pathText)
 
 	// Check if it's an original (unencrypted) file
@@ -461,6 +445,9 @@ func ConvertRealNameWithSuffix(password, encType, pathText, encSuffix string) st
 	}
 
 	ext := path.Ext(decoded)
+	if c.dialect == NameDialectBaseName {
+		return c.encode(strings.TrimSuffix(decoded, ext)) + ext
+	}
 	encSuffix = NormalizeEncSuffix(encSuffix)
 	if encSuffix != "" {
 		ext = encSuffix
@@ -468,7 +455,7 @@ func ConvertRealNameWithSuffix(password, encType, pathText, encSuffix string) st
 
 	// Keep behavior consistent with upload/display flow:
 	// encrypt full filename (including original extension), then append output suffix.
-	encName := EncodeName(password, encType, decoded)
+	encName := c.encode(decoded)
 
 	return encName + ext
 }
@@ -519,6 +506,13 @@ func DecodeFolderName(password, encType, encodedName string) (folderEncType, fol
 	return decoded[:sep], decoded[sep+1:], true
 }
 
+// IsReadableName reports whether a decoded name reads as text. A name
+// decoded under the wrong key passes the check character 1 time in 64 but
+// is almost never valid, printable UTF-8.
+func IsReadableName(s string) bool {
+	return isMostlyPrintable(s)
+}
+
 func isMostlyPrintable(s string) bool {
 	if s == "" || !utf8.ValidString(s) {
 		return false
@@ -540,26 +534,74 @@ func isMostlyPrintable(s string) bool {
 	return printable*100/total >= 85
 }
 
-// PathMatcher checks if a path matches encryption patterns
+// PathMatcher checks if a path matches encryption patterns. The stored
+// patterns are kept as written; the effective patterns (each rule plus its
+// /d, /p and /dav variants) are derived once at construction.
 type PathMatcher struct {
-	patterns []string
+	patterns  []string
+	effective []string
 }
 
 // NewPathMatcher creates a new path matcher from pattern strings
 func NewPathMatcher(patterns []string) *PathMatcher {
-	return &PathMatcher{patterns: patterns}
+	pm := &PathMatcher{patterns: patterns}
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if !strings.HasPrefix(pattern, "/") && !looksLikeRegexPattern(pattern) {
+			pattern = "/" + pattern
+		}
+		pm.effective = append(pm.effective, expandRuntimePathPatterns(pattern)...)
+	}
+	return pm
+}
+
+// Patterns returns the stored patterns the matcher was built from.
+func (pm *PathMatcher) Patterns() []string {
+	return pm.patterns
+}
+
+// Effective returns the expanded patterns actually matched against.
+func (pm *PathMatcher) Effective() []string {
+	return pm.effective
 }
 
 // Match checks if path matches any pattern
 func (pm *PathMatcher) Match(urlPath string) bool {
-	for _, pattern := range pm.patterns {
-		if pathMatchOne(pattern, urlPath) {
+	urlPath = strings.TrimSpace(urlPath)
+	if urlPath == "" {
+		return false
+	}
+	if !strings.HasPrefix(urlPath, "/") {
+		urlPath = "/" + urlPath
+	}
+	for _, pattern := range pm.effective {
+		if matchPattern(pattern, urlPath) {
 			return true
 		}
 	}
 	return false
 }
 
+// CheckPathPattern reports an encPath pattern that does not behave as
+// written: one that looks like a regular expression but does not compile is
+// silently matched as a wildcard only.
+func CheckPathPattern(pattern string) error {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" {
+		return errors.New("empty pattern")
+	}
+	if !looksLikeRegexPattern(pattern) {
+		return nil
+	}
+	if _, err := regexp.Compile(pattern); err != nil {
+		return fmt.Errorf("invalid regular expression, only matched as a wildcard: %w", err)
+	}
+	return nil
+}
+
 // PathExec checks if URL matches any encryption path pattern
 func PathExec(encPaths []string, urlPath string) bool {
 	for _, pattern := range encPaths {
@@ -11,6 +11,11 @@ type FileNameConverter struct {
 	Password  string
 	EncType   string
 	EncSuffix string
+	Dialect   string // name dialect, see NameDialectDefault
+	KDF       string // filename key KDF names are written with, see NameKDFLegacy
+	// ReadKDFs are tried, in order, for names that do not decode with KDF:
+	// the KDF a folder used before its names were migrated.
+	ReadKDFs []string
 }
 
 // NewFileNameConverter creates a new filename converter
@@ -22,14 +27,64 @@ func NewFileNameConverter(password, encType, encSuffix string) *FileNameConverte
 	}
 }
 
+// WithDialect sets the name dialect and returns c.
+func (c *FileNameConverter) WithDialect(dialect string) *FileNameConverter {
+	c.Dialect = NormalizeNameDialect(dialect)
+	return c
+}
+
+// WithKDF sets the filename key KDF and the KDFs names may still have been
+// written with, and returns c.
+func (c *FileNameConverter) WithKDF(kdf string, readKDFs ...string) *FileNameConverter {
+	c.KDF = NormalizeNameKDF(kdf)
+	c.ReadKDFs = nil
+	for _, k := range readKDFs {
+		if k = NormalizeNameKDF(k); k != c.KDF {
+			c.ReadKDFs = append(c.ReadKDFs, k)
+		}
+	}
+	return c
+}
+
+func (c *FileNameConverter) codec(kdf string) nameCodec {
+	return newNameCodec(c.Password, c.EncType, c.Dialect, kdf)
+}
+
+// decodeWith returns the first name decode yields under KDF and then
+// ReadKDFs. With ReadKDFs a strict decode is only taken if it reads as
+// text: the check character alone lets 1 in 64 names written under another
+// key through.
+func (c *FileNameConverter) decodeWith(decode func(nameCodec) string, failed func(string) bool) string {
+	name := decode(c.codec(c.KDF))
+	if len(c.ReadKDFs) == 0 || (!failed(name) && isMostlyPrintable(name)) {
+		return name
+	}
+	for _, kdf := range c.ReadKDFs {
+		if other := decode(c.codec(kdf)); !failed(other) && isMostlyPrintable(other) {
+			return other
+		}
+	}
+	return name
+}
+
+func emptyName(name string) bool { return name == "" }
+
+func origName(name string) bool { return strings.HasPrefix(name, OrigPrefix) }
+
 // EncryptFileName encrypts a plain filename
 func (c *FileNameConverter) EncryptFileName(plainName string) string {
-	return EncodeName(c.Password, c.EncType, plainName)
+	return c.codec(c.KDF).encode(plainName)
 }
 
 // DecryptFileName decrypts an encrypted filename
 func (c *FileNameConverter) DecryptFileName(encryptedName string) string {
-	return DecodeName(c.Password, c.EncType, encryptedName)
+	return c.decodeWith(func(nc nameCodec) string { return nc.decode(encryptedName) }, emptyName)
+}
+
+// DecodeFileName decrypts the stored name of a file, extension included,
+// and returns "" if it does not verify.
+func (c *FileNameConverter) DecodeFileName(fileName string, allowLoose bool) string {
+	return c.decodeWith(func(nc nameCodec) string { return nc.decodeFileName(fileName, allowLoose) }, emptyName)
 }
 
 // EncryptPath encrypts the filename portion of a path
@@ -81,12 +136,18 @@ func (c *FileNameConverter) DecryptPath(encryptedPath string) string {
 
 // ToDisplayName converts an encrypted filename to display name
 func (c *FileNameConverter) ToDisplayName(pathText string) string {
-	return ConvertShowName(c.Password, c.EncType, pathText)
+	return c.ShowName(pathText, false)
+}
+
+// ShowName converts an encrypted filename to its display name, optionally
+// falling back to a loose decode; names that do not decode get OrigPrefix.
+func (c *FileNameConverter) ShowName(pathText string, allowLoose bool) string {
+	return c.decodeWith(func(nc nameCodec) string { return nc.showName(pathText, c.EncSuffix, allowLoose) }, origName)
 }
 
 // ToRealName converts a display filename to encrypted name
 func (c *FileNameConverter) ToRealName(pathText string) string {
-	return ConvertRealNameWithSuffix(c.Password, c.EncType, pathText, c.EncSuffix)
+	return c.codec(c.KDF).realName(pathText, c.EncSuffix)
 }
 
 // IsOriginalFile checks if a filename is marked as original (failed decryption)
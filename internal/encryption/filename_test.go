@@ -174,6 +174,22 @@ func TestFileNameConverter(t *testing.T) {
 			t.Errorf("Got %q, want %q", result, "file.mp4")
 		}
 	})
+
+	t.Run("hidden extension round trip", func(t *testing.T) {
+		hidden := NewFileNameConverter("testpass", "aesctr", ".bin")
+		real := hidden.ToRealName("movie.mkv")
+		if !strings.HasSuffix(real, ".bin") || strings.Contains(real, ".mkv") {
+			t.Fatalf("ToRealName=%q leaks the extension", real)
+		}
+		if got := hidden.ToDisplayName(real); got != "movie.mkv" {
+			t.Fatalf("ToDisplayName=%q, want movie.mkv", got)
+		}
+		// Names stored before switching policy keep decoding.
+		legacy := converter.ToRealName("movie.mkv")
+		if got := hidden.ToDisplayName(legacy); got != "movie.mkv" {
+			t.Fatalf("legacy ToDisplayName=%q, want movie.mkv", got)
+		}
+	})
 }
 
 // TestFolderNameEncryption tests folder password encoding
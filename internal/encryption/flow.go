@@ -19,6 +19,10 @@ const (
 	EncTypeAESCTR   EncType = "aesctr"
 	EncTypeRC4MD5   EncType = "rc4md5"
 	EncTypeChaCha20 EncType = "chacha20"
+	// EncTypeAESGCM is chunked AES-256-GCM; it only exists as V3 content.
+	EncTypeAESGCM EncType = "aesgcm"
+	// EncTypeXChaCha20Poly1305 is chunked XChaCha20-Poly1305, also V3 only.
+	EncTypeXChaCha20Poly1305 EncType = "xchacha20poly1305"
 )
 
 // Cipher interface for encryption/decryption
@@ -46,7 +50,7 @@ type cacheEntry[V any] struct {
 }
 
 // passwdOutwardCache caches PBKDF2-derived keys to avoid repeated computation
-// Key format: "password:encType"
+// Key format: "password:encType", plus ":kdf" for NameKey's other KDFs
 var (
 	passwdOutwardCache   = make(map[string]*cacheEntry[string])
 	passwdOutwardCacheMu sync.RWMutex
@@ -198,14 +202,7 @@ func GetPasswdOutward(password, encType string) string {
 	passwdOutwardCacheMu.RUnlock()
 
 	// Compute PBKDF2 key
-	salt := "AES-CTR"
-	switch encType {
-	case "rc4md5":
-		salt = "RC4" // Match Node.js alist-encrypt rc4Md5.js PBKDF2 salt
-	case "chacha20":
-		salt = "ChaCha20"
-	}
-	key := pbkdf2.Key([]byte(password), []byte(salt), 1000, 16, sha256.New)
+	key := pbkdf2.Key([]byte(password), []byte(nameKeySalt(encType)), 1000, 16, sha256.New)
 	result := hex.EncodeToString(key)
 
 	// Store in cache with TTL
@@ -219,11 +216,42 @@ func GetPasswdOutward(password, encType string) string {
 	return result
 }
 
+// nameKeySalt returns the salt of the filename key of a normalized encType.
+func nameKeySalt(encType string) string {
+	switch encType {
+	case "rc4md5":
+		return "RC4" // Match Node.js alist-encrypt rc4Md5.js PBKDF2 salt
+	case "chacha20":
+		return "ChaCha20"
+	}
+	return "AES-CTR"
+}
+
+// NormalizeEncType maps an encType alias ("aes-ctr", "rc4", "xchacha", ...)
+// to its canonical name; "" stays "" and means aesctr.
+func NormalizeEncType(encType string) EncType {
+	return EncType(normalizeEncType(encType))
+}
+
+// IsSupportedEncType reports whether encType (after normalization) is one
+// this build can read and write; "" means the default.
+func IsSupportedEncType(encType string) bool {
+	switch NormalizeEncType(encType) {
+	case "", EncTypeAESCTR, EncTypeRC4MD5, EncTypeChaCha20, EncTypeAESGCM, EncTypeXChaCha20Poly1305:
+		return true
+	}
+	return false
+}
+
 func normalizeEncType(encType string) string {
 	encType = strings.ToLower(strings.TrimSpace(encType))
 	switch encType {
-	case "", "aesctr", "chacha20", "rc4md5":
+	case "", "aesctr", "chacha20", "rc4md5", "aesgcm", "xchacha20poly1305":
 		return encType
+	case "aes-gcm", "aes_gcm":
+		return "aesgcm"
+	case "xchacha20-poly1305", "xchacha20_poly1305", "xchacha":
+		return "xchacha20poly1305"
 	case "aes-ctr", "aes_ctr":
 		return "aesctr"
 	case "rc4":
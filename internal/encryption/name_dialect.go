@@ -0,0 +1,191 @@
+package encryption
+
+import (
+	"path"
+	"strings"
+)
+
+// Name dialects select the file name codec variant of a passwd rule. All of
+// them encode with MixBase64 and end in the CRC6 check character; some forks
+// of OpenList-Encrypt differ in the details, so folders they wrote need the
+// matching dialect to list and to keep names consistent on upload.
+const (
+	// NameDialectDefault encodes the whole name, extension included, keeps
+	// the MixBase64 padding and appends the clear extension (or encSuffix).
+	// It is what alist-encrypt and OpenList-Encrypt write.
+	NameDialectDefault = ""
+	// NameDialectNoPad is the default codec with the trailing padding
+	// characters dropped; the check character covers the unpadded text.
+	NameDialectNoPad = "nopad"
+	// NameDialectBaseName encodes the name without its extension and keeps
+	// the extension in clear; encSuffix does not apply.
+	NameDialectBaseName = "basename"
+)
+
+// NameDialects lists the supported dialects, default first.
+var NameDialects = []string{"default", NameDialectNoPad, NameDialectBaseName}
+
+// NormalizeNameDialect lower-cases a configured dialect and maps "default"
+// to "". Unknown values are returned as they are so validation can report
+// them.
+func NormalizeNameDialect(dialect string) string {
+	dialect = strings.ToLower(strings.TrimSpace(dialect))
+	if dialect == "default" {
+		return NameDialectDefault
+	}
+	return dialect
+}
+
+// IsSupportedNameDialect reports whether dialect names a known codec.
+func IsSupportedNameDialect(dialect string) bool {
+	switch NormalizeNameDialect(dialect) {
+	case NameDialectDefault, NameDialectNoPad, NameDialectBaseName:
+		return true
+	}
+	return false
+}
+
+// nameCodec encodes single names under one filename key and dialect.
+type nameCodec struct {
+	key     string // hex filename key, see NameKey
+	mix64   *MixBase64
+	dialect string
+}
+
+func newNameCodec(password, encType, dialect, kdf string) nameCodec {
+	key := NameKey(password, encType, kdf)
+	return nameCodec{key: key, mix64: GetCachedMixBase64(key), dialect: dialect}
+}
+
+func (c nameCodec) encode(plainName string) string {
+	encodedName := c.mix64.EncodeString(plainName)
+	if c.dialect == NameDialectNoPad {
+		encodedName = strings.TrimRight(encodedName, string(c.mix64.chars[64]))
+	}
+
+	// Calculate CRC6 checksum
+	checkData := encodedName + c.key
+	crc6Bit := crc6.Checksum([]byte(checkData))
+	crc6Check := GetSourceChar(crc6Bit)
+
+	return encodedName + string(crc6Check)
+}
+
+func (c nameCodec) decode(encodedName string) string {
+	if len(encodedName) < 2 {
+		return ""
+	}
+
+	crc6Check := encodedName[len(encodedName)-1]
+	subEncName := encodedName[:len(encodedName)-1]
+
+	// Verify CRC6
+	checkData := subEncName + c.key
+	crc6Bit := crc6.Checksum([]byte(checkData))
+	if GetSourceChar(crc6Bit) != crc6Check {
+		return ""
+	}
+
+	decoded, err := c.mix64.DecodeString(repadName(c.mix64, subEncName, c.dialect))
+	if err != nil {
+		return ""
+	}
+	return decoded
+}
+
+func (c nameCodec) decodeLoose(encodedName string) string {
+	if len(encodedName) < 2 {
+		return ""
+	}
+
+	subEncName := encodedName[:len(encodedName)-1]
+	decoded, err := c.mix64.DecodeString(repadName(c.mix64, subEncName, c.dialect))
+	if err != nil {
+		return ""
+	}
+	if !isMostlyPrintable(decoded) {
+		return ""
+	}
+	return decoded
+}
+
+// EncodeNameDialect encrypts a filename with the given dialect.
+func EncodeNameDialect(password, encType, plainName, dialect string) string {
+	return newNameCodec(password, encType, dialect, NameKDFLegacy).encode(plainName)
+}
+
+// DecodeNameDialect decrypts a filename written with the given dialect and
+// returns "" if it does not verify.
+func DecodeNameDialect(password, encType, encodedName, dialect string) string {
+	return newNameCodec(password, encType, dialect, NameKDFLegacy).decode(encodedName)
+}
+
+// DecodeNameLooseDialect is DecodeNameLoose for the given dialect.
+func DecodeNameLooseDialect(password, encType, encodedName, dialect string) string {
+	return newNameCodec(password, encType, dialect, NameKDFLegacy).decodeLoose(encodedName)
+}
+
+// repadName restores the padding NameDialectNoPad drops.
+func repadName(mix64 *MixBase64, encoded, dialect string) string {
+	if dialect != NameDialectNoPad || len(encoded)%4 == 0 {
+		return encoded
+	}
+	return encoded + strings.Repeat(string(mix64.chars[64]), 4-len(encoded)%4)
+}
+
+// DecodeFileName decrypts the stored name of a file (extension included) and
+// returns "" if it does not verify.
+func DecodeFileName(password, encType, fileName, dialect string, allowLoose bool) string {
+	return newNameCodec(password, encType, dialect, NameKDFLegacy).decodeFileName(fileName, allowLoose)
+}
+
+func (c nameCodec) decodeFileName(fileName string, allowLoose bool) string {
+	ext := path.Ext(fileName)
+	base := strings.TrimSuffix(fileName, ext)
+	name := c.decode(base)
+	if name == "" && allowLoose {
+		name = c.decodeLoose(base)
+	}
+	if name != "" && c.dialect == NameDialectBaseName {
+		name += ext
+	}
+	return name
+}
+
+// DialectMatch is a dialect a stored name decodes with.
+type DialectMatch struct {
+	Dialect  string `json:"dialect"`
+	ShowName string `json:"showName"`
+}
+
+// DetectNameDialects returns the dialects that decode fileName, a stored
+// name under a rule with the given password, encType and encSuffix. Names
+// are decoded strictly. A name is only taken for the default or nopad
+// dialect if its clear extension repeats the decoded one (or is encSuffix),
+// and for basename only if it does not, since all three share the check
+// character.
+func DetectNameDialects(password, encType, fileName, encSuffix string) []DialectMatch {
+	ext := path.Ext(fileName)
+	suffix := NormalizeEncSuffix(encSuffix)
+	var matches []DialectMatch
+	for _, dialect := range NameDialects {
+		dialect = NormalizeNameDialect(dialect)
+		show := DecodeFileName(password, encType, fileName, dialect, false)
+		if show == "" {
+			continue
+		}
+		if dialect == NameDialectBaseName {
+			if ext != "" && strings.HasSuffix(strings.TrimSuffix(show, ext), ext) {
+				continue
+			}
+		} else if ext != "" && ext != suffix && path.Ext(show) != ext {
+			continue
+		}
+		if dialect == NameDialectDefault {
+			matches = append(matches, DialectMatch{Dialect: "default", ShowName: show})
+			continue
+		}
+		matches = append(matches, DialectMatch{Dialect: dialect, ShowName: show})
+	}
+	return matches
+}
@@ -0,0 +1,73 @@
+package encryption
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNameDialectRoundTrip(t *testing.T) {
+	names := []string{"a.mkv", "episode 01.mkv", "照片.jpg", "README"}
+	for _, dialect := range []string{NameDialectDefault, NameDialectNoPad, NameDialectBaseName} {
+		c := NewFileNameConverter("testpass", "aesctr", "").WithDialect(dialect)
+		for _, name := range names {
+			real := c.ToRealName(name)
+			if got := c.ToDisplayName(real); got != name {
+				t.Fatalf("%q %s: %q -> %q -> %q", dialect, name, name, real, got)
+			}
+			if got := DecodeFileName("testpass", "aesctr", real, dialect, false); got != name {
+				t.Fatalf("%q DecodeFileName(%q)=%q", dialect, real, got)
+			}
+		}
+	}
+}
+
+func TestNameDialectDifferences(t *testing.T) {
+	mix64 := GetCachedMixBase64(GetPasswdOutward("testpass", "aesctr"))
+	pad := string(mix64.chars[64])
+
+	// "a.mkv" is 5 bytes, so the default codec pads it.
+	padded := ConvertRealNameDialect("testpass", "aesctr", "a.mkv", "", NameDialectDefault)
+	unpadded := ConvertRealNameDialect("testpass", "aesctr", "a.mkv", "", NameDialectNoPad)
+	if !strings.Contains(padded, pad) || strings.Contains(unpadded, pad) {
+		t.Fatalf("padded=%q unpadded=%q pad=%q", padded, unpadded, pad)
+	}
+	if got := ConvertShowNameDialect("testpass", "aesctr", unpadded, "", NameDialectDefault, false); !IsOriginalFile(got) {
+		t.Fatalf("default dialect should not read nopad names, got %q", got)
+	}
+	// nopad still reads names written by the default codec.
+	if got := ConvertShowNameDialect("testpass", "aesctr", padded, "", NameDialectNoPad, false); got != "a.mkv" {
+		t.Fatalf("nopad reading a padded name: %q", got)
+	}
+
+	base := ConvertRealNameDialect("testpass", "aesctr", "movie.mkv", ".bin", NameDialectBaseName)
+	if want := EncodeName("testpass", "aesctr", "movie") + ".mkv"; base != want {
+		t.Fatalf("basename=%q, want %q", base, want)
+	}
+}
+
+func TestDetectNameDialects(t *testing.T) {
+	dialects := func(matches []DialectMatch) string {
+		var out []string
+		for _, m := range matches {
+			out = append(out, m.Dialect+"="+m.ShowName)
+		}
+		return strings.Join(out, ",")
+	}
+	cases := []struct {
+		dialect string
+		want    string
+	}{
+		{NameDialectDefault, "default=a.mkv,nopad=a.mkv"},
+		{NameDialectNoPad, "nopad=a.mkv"},
+		{NameDialectBaseName, "basename=a.mkv"},
+	}
+	for _, tc := range cases {
+		stored := ConvertRealNameDialect("testpass", "aesctr", "a.mkv", "", tc.dialect)
+		if got := dialects(DetectNameDialects("testpass", "aesctr", stored, "")); got != tc.want {
+			t.Fatalf("%q: detected %q, want %q", tc.dialect, got, tc.want)
+		}
+	}
+	if got := DetectNameDialects("wrong", "aesctr", ConvertRealName("testpass", "aesctr", "a.mkv"), ""); len(got) != 0 {
+		t.Fatalf("wrong password detected %v", got)
+	}
+}
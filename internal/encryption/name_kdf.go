@@ -0,0 +1,108 @@
+package encryption
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Name KDFs derive the filename key of a passwd rule from its password. The
+// key seeds the MixBase64 alphabet and the check character, so names written
+// under one KDF do not decode under another; changing a rule's KDF needs its
+// stored names migrated (see the name KDF job).
+const (
+	// NameKDFLegacy is PBKDF2-SHA256 with 1000 iterations, what
+	// alist-encrypt and OpenList-Encrypt use.
+	NameKDFLegacy = ""
+	// NameKDFPBKDF2 is PBKDF2-SHA256 with 600000 iterations.
+	NameKDFPBKDF2 = "pbkdf2-600k"
+	// NameKDFArgon2id is Argon2id with 19 MiB of memory and two passes.
+	NameKDFArgon2id = "argon2id"
+)
+
+// NameKDFFunc derives a 16 byte filename key. salt depends on the encType.
+type NameKDFFunc func(password, salt []byte) []byte
+
+var (
+	nameKDFMu sync.RWMutex
+	nameKDFs  = map[string]NameKDFFunc{
+		NameKDFPBKDF2: func(password, salt []byte) []byte {
+			return pbkdf2.Key(password, salt, 600000, 16, sha256.New)
+		},
+		NameKDFArgon2id: func(password, salt []byte) []byte {
+			// Argon2 wants at least 8 bytes of salt; "RC4" is shorter.
+			return argon2.IDKey(password, append([]byte("alist-encrypt-name:"), salt...), 2, 19*1024, 1, 16)
+		},
+	}
+)
+
+// RegisterNameKDF adds or replaces a name KDF. Built-in names should not be
+// replaced once names have been written with them.
+func RegisterNameKDF(name string, fn NameKDFFunc) {
+	nameKDFMu.Lock()
+	nameKDFs[NormalizeNameKDF(name)] = fn
+	nameKDFMu.Unlock()
+}
+
+// NormalizeNameKDF lower-cases a configured KDF and maps "legacy" and
+// "pbkdf2" to "". Unknown values are returned as they are so validation can
+// report them.
+func NormalizeNameKDF(kdf string) string {
+	kdf = strings.ToLower(strings.TrimSpace(kdf))
+	if kdf == "legacy" || kdf == "pbkdf2" {
+		return NameKDFLegacy
+	}
+	return kdf
+}
+
+// IsSupportedNameKDF reports whether kdf names a known KDF.
+func IsSupportedNameKDF(kdf string) bool {
+	kdf = NormalizeNameKDF(kdf)
+	if kdf == NameKDFLegacy {
+		return true
+	}
+	nameKDFMu.RLock()
+	defer nameKDFMu.RUnlock()
+	_, ok := nameKDFs[kdf]
+	return ok
+}
+
+// NameKey returns the hex filename key for password and encType under kdf;
+// NameKDFLegacy gives GetPasswdOutward. Keys are cached like
+// GetPasswdOutward's, since the stronger KDFs are deliberately slow. An
+// unknown kdf falls back to the legacy key.
+func NameKey(password, encType, kdf string) string {
+	kdf = NormalizeNameKDF(kdf)
+	if kdf == NameKDFLegacy {
+		return GetPasswdOutward(password, encType)
+	}
+	nameKDFMu.RLock()
+	fn, ok := nameKDFs[kdf]
+	nameKDFMu.RUnlock()
+	if !ok {
+		return GetPasswdOutward(password, encType)
+	}
+	encType = normalizeEncType(encType)
+	cacheKey := password + ":" + encType + ":" + kdf
+
+	passwdOutwardCacheMu.RLock()
+	if entry, ok := passwdOutwardCache[cacheKey]; ok && time.Now().Before(entry.expireAt) {
+		passwdOutwardCacheMu.RUnlock()
+		return entry.value
+	}
+	passwdOutwardCacheMu.RUnlock()
+
+	result := hex.EncodeToString(fn([]byte(password), []byte(nameKeySalt(encType))))
+	passwdOutwardCacheMu.Lock()
+	passwdOutwardCache[cacheKey] = &cacheEntry[string]{
+		value:    result,
+		expireAt: time.Now().Add(cacheEntryTTL),
+	}
+	passwdOutwardCacheMu.Unlock()
+	return result
+}
@@ -0,0 +1,55 @@
+package encryption
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+func TestNameKDFRoundTrip(t *testing.T) {
+	legacy := NewFileNameConverter("testpass", "aesctr", "")
+	for _, kdf := range []string{NameKDFPBKDF2, NameKDFArgon2id} {
+		c := NewFileNameConverter("testpass", "aesctr", "").WithKDF(kdf)
+		real := c.ToRealName("episode 01.mkv")
+		if real == legacy.ToRealName("episode 01.mkv") {
+			t.Fatalf("%s wrote the legacy name", kdf)
+		}
+		if got := c.ToDisplayName(real); got != "episode 01.mkv" {
+			t.Fatalf("%s: %q -> %q", kdf, real, got)
+		}
+	}
+}
+
+func TestNameKDFReadsPreviousKDF(t *testing.T) {
+	legacyName := NewFileNameConverter("testpass", "aesctr", "").ToRealName("a.mkv")
+	c := NewFileNameConverter("testpass", "aesctr", "").WithKDF(NameKDFArgon2id, NameKDFLegacy)
+	if got := c.ShowName(legacyName, false); got != "a.mkv" {
+		t.Fatalf("legacy name shown as %q", got)
+	}
+	if real := c.ToRealName("a.mkv"); real == legacyName || c.ShowName(real, false) != "a.mkv" {
+		t.Fatalf("new names should use argon2id, got %q", real)
+	}
+	// Once the previous KDF equals the current one there is no fallback.
+	strict := NewFileNameConverter("testpass", "aesctr", "").WithKDF(NameKDFArgon2id, NameKDFArgon2id)
+	if got := strict.ShowName(legacyName, false); got == "a.mkv" {
+		t.Fatalf("strict converter still read the legacy name")
+	}
+}
+
+func TestRegisterNameKDF(t *testing.T) {
+	RegisterNameKDF("Test-Sha", func(password, salt []byte) []byte {
+		sum := sha256.Sum256(append(password, salt...))
+		return sum[:16]
+	})
+	if !IsSupportedNameKDF("test-sha") || IsSupportedNameKDF("scrypt") {
+		t.Fatal("registry lookup")
+	}
+	if NormalizeNameKDF(" Legacy ") != NameKDFLegacy || NormalizeNameKDF("pbkdf2") != NameKDFLegacy {
+		t.Fatal("legacy aliases")
+	}
+	if NameKey("testpass", "aesctr", "test-sha") == GetPasswdOutward("testpass", "aesctr") {
+		t.Fatal("custom KDF gave the legacy key")
+	}
+	if NameKey("testpass", "aesctr", "unknown") != GetPasswdOutward("testpass", "aesctr") {
+		t.Fatal("unknown KDF should fall back to legacy")
+	}
+}
@@ -130,11 +130,15 @@ func (r *RC4MD5) SetPosition(position int64) error {
 
 // prgaAdvance advances the PRGA without producing output
 func (r *RC4MD5) prgaAdvance(count int) {
+	i, j := uint8(r.i), uint8(r.j)
+	sbox := &r.sbox
 	for k := 0; k < count; k++ {
-		r.i = (r.i + 1) % 256
-		r.j = (r.j + int(r.sbox[r.i])) % 256
-		r.sbox[r.i], r.sbox[r.j] = r.sbox[r.j], r.sbox[r.i]
+		i++
+		x := sbox[i]
+		j += x
+		sbox[i], sbox[j] = sbox[j], x
 	}
+	r.i, r.j = int(i), int(j)
 }
 
 // Position returns the current stream position
@@ -154,25 +158,37 @@ func (r *RC4MD5) BlockSize() int {
 
 // Encrypt encrypts data in place with segmentation
 func (r *RC4MD5) Encrypt(data []byte) {
-	for k := 0; k < len(data); k++ {
-		r.i = (r.i + 1) % 256
-		r.j = (r.j + int(r.sbox[r.i])) % 256
-
-		// Swap
-		r.sbox[r.i], r.sbox[r.j] = r.sbox[r.j], r.sbox[r.i]
-
-		// XOR with keystream
-		data[k] ^= r.sbox[(int(r.sbox[r.i])+int(r.sbox[r.j]))%256]
-
-		r.position++
-
-		// Reset every 1MB
+	for len(data) > 0 {
+		n := int(segmentPosition - r.position%segmentPosition)
+		if n > len(data) {
+			n = len(data)
+		}
+		r.xorKeyStream(data[:n])
+		r.position += int64(n)
+		data = data[n:]
 		if r.position%segmentPosition == 0 {
 			r.resetKSA()
 		}
 	}
 }
 
+// xorKeyStream runs the RC4 PRGA over data, which must not cross a segment
+// boundary. uint8 indices wrap for free and keep the S-box lookups
+// bounds-check free, which is most of the win over the byte-at-a-time loop.
+func (r *RC4MD5) xorKeyStream(data []byte) {
+	i, j := uint8(r.i), uint8(r.j)
+	sbox := &r.sbox
+	for k := range data {
+		i++
+		x := sbox[i]
+		j += x
+		y := sbox[j]
+		sbox[i], sbox[j] = y, x
+		data[k] ^= sbox[x+y]
+	}
+	r.i, r.j = int(i), int(j)
+}
+
 // Decrypt decrypts data in place (same as encrypt for RC4)
 func (r *RC4MD5) Decrypt(data []byte) {
 	r.Encrypt(data)
@@ -25,6 +25,12 @@ func init() {
 	Register(EncTypeChaCha20, func(password string, fileSize int64) (Cipher, error) {
 		return NewChaCha20(password, fileSize)
 	})
+	Register(EncTypeAESGCM, func(password string, fileSize int64) (Cipher, error) {
+		return nil, errAEADNeedsHeader
+	})
+	Register(EncTypeXChaCha20Poly1305, func(password string, fileSize int64) (Cipher, error) {
+		return nil, errAEADNeedsHeader
+	})
 }
 
 // Register adds a cipher factory to the registry
@@ -61,6 +67,8 @@ func NewCipherV2(encType EncType, password string, plainSize int64, nonceField [
 		return NewChaCha20V2(password, plainSize, nonceField)
 	case "":
 		return NewAESCTRV2(password, plainSize, nonceField)
+	case EncTypeAESGCM, EncTypeXChaCha20Poly1305:
+		return nil, errAEADNeedsHeader
 	default:
 		return nil, fmt.Errorf("unsupported v2 encryption type: %s", encType)
 	}
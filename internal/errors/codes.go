@@ -0,0 +1,129 @@
+package errors
+
+import "net/http"
+
+// Code is a stable, machine-readable error identifier. It is sent as
+// "error_code" in JSON bodies and in the X-Enc-Error header so clients and
+// the UI can branch on it instead of parsing messages. Published values must
+// never be renamed.
+type Code string
+
+// HeaderErrorCode carries the Code on every error response from proxy routes.
+const HeaderErrorCode = "X-Enc-Error"
+
+const (
+	CodeBadRequest   Code = "BAD_REQUEST"
+	CodeUnauthorized Code = "UNAUTHORIZED"
+	CodeForbidden    Code = "FORBIDDEN"
+	CodeNotFound     Code = "NOT_FOUND"
+	CodeInternal     Code = "INTERNAL"
+
+	// Upstream (Alist or storage) failures
+	CodeUpstreamUnreachable   Code = "UPSTREAM_UNREACHABLE"
+	CodeUpstreamTooLarge      Code = "UPSTREAM_RESPONSE_TOO_LARGE"
+	CodeUpstreamRejected      Code = "UPSTREAM_REJECTED"
+	CodeUpstreamFailed        Code = "UPSTREAM_FAILED"
+	CodeUpstreamCircuitOpen   Code = "UPSTREAM_CIRCUIT_OPEN"
+	CodeRangeUnsupported      Code = "UPSTREAM_RANGE_UNSUPPORTED"
+	CodeRangeUnsatisfiable    Code = "UPSTREAM_RANGE_UNSATISFIABLE"
+	CodeRangeInvalid          Code = "RANGE_INVALID"
+	CodeSeekTooLarge          Code = "SEEK_TOO_LARGE"
+	CodeSizeUnknown           Code = "ENC_SIZE_UNKNOWN"
+	CodeEncryptFailed         Code = "ENCRYPT_FAILED"
+	CodeDecryptFailed         Code = "DECRYPT_FAILED"
+	CodeDecryptValidation     Code = "DECRYPT_VALIDATION_FAILED"
+	CodeNameDecodeFailed      Code = "NAME_DECODE_FAILED"
+	CodeUploadVerifyFailed    Code = "UPLOAD_VERIFY_FAILED"
+	CodeStrictPlaintextWrite  Code = "STRICT_PLAINTEXT_WRITE"
+	CodeNameTooLong           Code = "NAME_TOO_LONG"
+	CodeAdminRouteBlocked     Code = "ADMIN_ROUTE_BLOCKED"
+	CodeOutsideAccessWindow   Code = "OUTSIDE_ACCESS_WINDOW"
+	CodeClientCertRequired    Code = "CLIENT_CERT_REQUIRED"
+	CodeRequestEntityTooLarge Code = "REQUEST_TOO_LARGE"
+	CodeRateLimited           Code = "RATE_LIMITED"
+)
+
+// WithCode attaches a stable code to the error and returns it.
+func (e *AppError) WithCode(code Code) *AppError {
+	e.Reason = code
+	return e
+}
+
+// CodeOf returns the stable code for err, falling back to one derived from
+// the numeric ErrorCode.
+func CodeOf(err error) Code {
+	appErr, ok := err.(*AppError)
+	if !ok {
+		return CodeInternal
+	}
+	if appErr.Reason != "" {
+		return appErr.Reason
+	}
+	switch appErr.Code {
+	case ErrCodeBadRequest:
+		return CodeBadRequest
+	case ErrCodeUnauthorized:
+		return CodeUnauthorized
+	case ErrCodeForbidden:
+		return CodeForbidden
+	case ErrCodeNotFound:
+		return CodeNotFound
+	case ErrCodeProxy:
+		return CodeUpstreamUnreachable
+	case ErrCodeEncryption:
+		return CodeEncryptFailed
+	case ErrCodeDecryption:
+		return CodeDecryptFailed
+	default:
+		return CodeInternal
+	}
+}
+
+// CodeForFailure maps the proxy's internal stream failure reasons
+// (StreamOutcome.FailureReason) onto stable codes.
+func CodeForFailure(reason string) Code {
+	switch reason {
+	case "range_unsupported":
+		return CodeRangeUnsupported
+	case "range_unsatisfiable":
+		return CodeRangeUnsatisfiable
+	case "range_invalid":
+		return CodeRangeInvalid
+	case "chunked_seek_too_large":
+		return CodeSeekTooLarge
+	case "decrypt_validation_failed":
+		return CodeDecryptValidation
+	case "circuit_open":
+		return CodeUpstreamCircuitOpen
+	case "upstream_4xx":
+		return CodeUpstreamRejected
+	case "upstream_5xx":
+		return CodeUpstreamFailed
+	default:
+		return CodeDecryptFailed
+	}
+}
+
+// CodeForStatus picks a generic code for plain HTTP errors that have none.
+func CodeForStatus(status int) Code {
+	switch status {
+	case http.StatusBadRequest:
+		return CodeBadRequest
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusRequestEntityTooLarge:
+		return CodeRequestEntityTooLarge
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusRequestedRangeNotSatisfiable:
+		return CodeRangeUnsatisfiable
+	case http.StatusBadGateway:
+		return CodeUpstreamUnreachable
+	default:
+		return CodeInternal
+	}
+}
@@ -26,6 +26,7 @@ const (
 // AppError represents a structured application error
 type AppError struct {
 	Code       ErrorCode `json:"code"`
+	Reason     Code      `json:"error_code,omitempty"`
 	Message    string    `json:"message"`
 	HTTPStatus int       `json:"-"`
 	Cause      error     `json:"-"`
@@ -178,14 +179,16 @@ func ToHTTPStatus(err error) int {
 func ToJSON(err error) []byte {
 	if appErr, ok := err.(*AppError); ok {
 		data, _ := json.Marshal(map[string]interface{}{
-			"code": appErr.Code,
-			"msg":  appErr.Message,
+			"code":       appErr.Code,
+			"error_code": CodeOf(appErr),
+			"msg":        appErr.Message,
 		})
 		return data
 	}
 	data, _ := json.Marshal(map[string]interface{}{
-		"code": ErrCodeInternal,
-		"msg":  err.Error(),
+		"code":       ErrCodeInternal,
+		"error_code": CodeInternal,
+		"msg":        err.Error(),
 	})
 	return data
 }
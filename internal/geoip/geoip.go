@@ -0,0 +1,142 @@
+// Package geoip tags client addresses with coarse country / ASN information
+// read from local MaxMind DB (GeoLite2 / DB-IP compatible) files.
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const maxCachedLookups = 4096
+
+// Info is the coarse location of a client address.
+type Info struct {
+	Country string `json:"country,omitempty"`
+	City    string `json:"city,omitempty"`
+	ASN     uint   `json:"asn,omitempty"`
+	ASOrg   string `json:"as_org,omitempty"`
+}
+
+// Empty reports whether no field was resolved.
+func (i Info) Empty() bool {
+	return i.Country == "" && i.City == "" && i.ASN == 0 && i.ASOrg == ""
+}
+
+// String renders the info as space separated key=value pairs for log lines.
+func (i Info) String() string {
+	parts := make([]string, 0, 3)
+	if i.Country != "" {
+		parts = append(parts, "geo="+i.Country)
+	}
+	if i.City != "" {
+		parts = append(parts, "city="+strconv.Quote(i.City))
+	}
+	if i.ASN != 0 {
+		asn := "asn=AS" + strconv.FormatUint(uint64(i.ASN), 10)
+		if i.ASOrg != "" {
+			asn += " as_org=" + strconv.Quote(i.ASOrg)
+		}
+		parts = append(parts, asn)
+	}
+	return strings.Join(parts, " ")
+}
+
+// Resolver looks up addresses in an optional location database and an
+// optional ASN database. A nil *Resolver is valid and resolves nothing.
+type Resolver struct {
+	location *mmdbReader
+	asn      *mmdbReader
+
+	mu    sync.Mutex
+	cache map[string]Info
+}
+
+// Open loads the given databases. Either path may be empty; when both are
+// empty Open returns (nil, nil).
+func Open(locationDB, asnDB string) (*Resolver, error) {
+	locationDB = strings.TrimSpace(locationDB)
+	asnDB = strings.TrimSpace(asnDB)
+	if locationDB == "" && asnDB == "" {
+		return nil, nil
+	}
+	r := &Resolver{cache: make(map[string]Info)}
+	var err error
+	if locationDB != "" {
+		if r.location, err = openMMDB(locationDB); err != nil {
+			return nil, fmt.Errorf("open geoip db %s: %w", locationDB, err)
+		}
+	}
+	if asnDB != "" {
+		if r.asn, err = openMMDB(asnDB); err != nil {
+			return nil, fmt.Errorf("open asn db %s: %w", asnDB, err)
+		}
+	}
+	return r, nil
+}
+
+// Lookup resolves a host or host:port string. Private, loopback and
+// unparseable addresses resolve to an empty Info.
+func (r *Resolver) Lookup(addr string) Info {
+	if r == nil {
+		return Info{}
+	}
+	host := strings.TrimSpace(addr)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+		return Info{}
+	}
+	key := ip.String()
+
+	r.mu.Lock()
+	if info, ok := r.cache[key]; ok {
+		r.mu.Unlock()
+		return info
+	}
+	r.mu.Unlock()
+
+	var info Info
+	if r.location != nil {
+		if record, err := r.location.lookup(ip); err == nil {
+			info.Country = stringAt(record, "country", "iso_code")
+			if info.Country == "" {
+				info.Country = stringAt(record, "registered_country", "iso_code")
+			}
+			info.City = stringAt(record, "city", "names", "en")
+		}
+	}
+	if r.asn != nil {
+		if record, err := r.asn.lookup(ip); err == nil {
+			if m, ok := record.(map[string]interface{}); ok {
+				info.ASN = uint(asUint64(m["autonomous_system_number"]))
+				info.ASOrg, _ = m["autonomous_system_organization"].(string)
+			}
+		}
+	}
+
+	r.mu.Lock()
+	if len(r.cache) >= maxCachedLookups {
+		r.cache = make(map[string]Info)
+	}
+	r.cache[key] = info
+	r.mu.Unlock()
+	return info
+}
+
+func stringAt(record interface{}, keys ...string) string {
+	cur := record
+	for _, key := range keys {
+		m, ok := cur.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		cur = m[key]
+	}
+	s, _ := cur.(string)
+	return s
+}
@@ -0,0 +1,116 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+// Minimal MaxMind DB encoder used to build fixtures.
+func encodeValue(buf *bytes.Buffer, v interface{}) {
+	switch val := v.(type) {
+	case string:
+		if len(val) < 29 {
+			buf.WriteByte(byte(2<<5 | len(val)))
+		} else {
+			buf.WriteByte(byte(2<<5 | 29))
+			buf.WriteByte(byte(len(val) - 29))
+		}
+		buf.WriteString(val)
+	case uint32:
+		buf.WriteByte(byte(6<<5 | 4))
+		_ = binary.Write(buf, binary.BigEndian, val)
+	case map[string]interface{}:
+		buf.WriteByte(byte(7<<5 | len(val)))
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			encodeValue(buf, k)
+			encodeValue(buf, val[k])
+		}
+	default:
+		panic("unsupported fixture type")
+	}
+}
+
+// buildIPv4DB writes a record-size-24 IPv4 database with a single /8 network.
+func buildIPv4DB(t *testing.T, firstOctet byte, record map[string]interface{}) string {
+	t.Helper()
+	const nodeCount = 8
+	var tree bytes.Buffer
+	for i := 0; i < nodeCount; i++ {
+		next := uint32(i + 1)
+		if i == nodeCount-1 {
+			next = nodeCount + 16 // data section offset 0
+		}
+		records := [2]uint32{nodeCount, nodeCount}
+		records[(firstOctet>>(7-uint(i)))&1] = next
+		for _, rec := range records {
+			tree.Write([]byte{byte(rec >> 16), byte(rec >> 8), byte(rec)})
+		}
+	}
+
+	var out bytes.Buffer
+	out.Write(tree.Bytes())
+	out.Write(make([]byte, 16))
+	encodeValue(&out, record)
+	out.Write(metadataMarker)
+	encodeValue(&out, map[string]interface{}{
+		"node_count":    uint32(nodeCount),
+		"record_size":   uint32(24),
+		"ip_version":    uint32(4),
+		"database_type": "Test",
+	})
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(path, out.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestResolverLooksUpCountryAndASN(t *testing.T) {
+	locationDB := buildIPv4DB(t, 1, map[string]interface{}{
+		"country": map[string]interface{}{"iso_code": "AU"},
+		"city":    map[string]interface{}{"names": map[string]interface{}{"en": "Sydney"}},
+	})
+	asnDB := buildIPv4DB(t, 1, map[string]interface{}{
+		"autonomous_system_number":       uint32(13335),
+		"autonomous_system_organization": "CLOUDFLARENET",
+	})
+
+	r, err := Open(locationDB, asnDB)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	info := r.Lookup("1.1.1.1:443")
+	if info.Country != "AU" || info.City != "Sydney" || info.ASN != 13335 || info.ASOrg != "CLOUDFLARENET" {
+		t.Fatalf("info=%+v", info)
+	}
+	if got := info.String(); got != `geo=AU city="Sydney" asn=AS13335 as_org="CLOUDFLARENET"` {
+		t.Fatalf("String()=%s", got)
+	}
+
+	if info := r.Lookup("2.2.2.2"); !info.Empty() {
+		t.Fatalf("unexpected match %+v", info)
+	}
+	if info := r.Lookup("192.168.1.2"); !info.Empty() {
+		t.Fatalf("private address should not be tagged: %+v", info)
+	}
+}
+
+func TestOpenWithoutDatabasesReturnsNil(t *testing.T) {
+	r, err := Open("", " ")
+	if err != nil || r != nil {
+		t.Fatalf("r=%v err=%v", r, err)
+	}
+	if info := r.Lookup("1.1.1.1"); !info.Empty() {
+		t.Fatalf("nil resolver returned %+v", info)
+	}
+}
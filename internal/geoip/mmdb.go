@@ -0,0 +1,322 @@
+package geoip
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"os"
+)
+
+// metadataMarker precedes the metadata map at the end of every MaxMind DB file.
+var metadataMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+const dataSectionSeparator = 16
+
+// mmdbReader is a minimal reader for the MaxMind DB format
+// (https://maxmind.github.io/MaxMind-DB/). It loads the whole file into
+// memory and decodes records into generic Go values.
+type mmdbReader struct {
+	buf          []byte
+	nodeCount    uint
+	recordSize   uint
+	ipVersion    uint
+	databaseType string
+	treeSize     uint
+	data         []byte
+	ipv4Start    uint
+}
+
+func openMMDB(path string) (*mmdbReader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return newMMDBReader(buf)
+}
+
+func newMMDBReader(buf []byte) (*mmdbReader, error) {
+	idx := bytes.LastIndex(buf, metadataMarker)
+	if idx < 0 {
+		return nil, errors.New("mmdb: metadata marker not found")
+	}
+	metaSection := buf[idx+len(metadataMarker):]
+	raw, _, err := (&mmdbDecoder{buf: metaSection}).decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("mmdb: decode metadata: %w", err)
+	}
+	meta, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil, errors.New("mmdb: metadata is not a map")
+	}
+
+	r := &mmdbReader{
+		buf:        buf,
+		nodeCount:  uint(asUint64(meta["node_count"])),
+		recordSize: uint(asUint64(meta["record_size"])),
+		ipVersion:  uint(asUint64(meta["ip_version"])),
+	}
+	r.databaseType, _ = meta["database_type"].(string)
+	switch r.recordSize {
+	case 24, 28, 32:
+	default:
+		return nil, fmt.Errorf("mmdb: unsupported record size %d", r.recordSize)
+	}
+	r.treeSize = r.nodeCount * r.recordSize / 4
+	dataStart := r.treeSize + dataSectionSeparator
+	if dataStart > uint(idx) {
+		return nil, errors.New("mmdb: search tree exceeds file size")
+	}
+	r.data = buf[dataStart:idx]
+
+	if r.ipVersion == 6 {
+		node := uint(0)
+		for i := 0; i < 96 && node < r.nodeCount; i++ {
+			node = r.readNode(node, 0)
+		}
+		r.ipv4Start = node
+	}
+	return r, nil
+}
+
+func (r *mmdbReader) readNode(node uint, bit uint) uint {
+	base := node * r.recordSize / 4
+	b := r.buf[base:]
+	switch r.recordSize {
+	case 24:
+		off := bit * 3
+		return uint(b[off])<<16 | uint(b[off+1])<<8 | uint(b[off+2])
+	case 28:
+		if bit == 0 {
+			return uint(b[3]&0xF0)<<20 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])
+		}
+		return uint(b[3]&0x0F)<<24 | uint(b[4])<<16 | uint(b[5])<<8 | uint(b[6])
+	default:
+		off := bit * 4
+		return uint(binary.BigEndian.Uint32(b[off : off+4]))
+	}
+}
+
+// lookup returns the decoded record for ip, or nil when the address is not
+// in the database.
+func (r *mmdbReader) lookup(ip net.IP) (interface{}, error) {
+	node := uint(0)
+	addr := ip.To16()
+	bits := 128
+	if v4 := ip.To4(); v4 != nil {
+		addr = v4
+		bits = 32
+		if r.ipVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else if r.ipVersion == 4 {
+		return nil, nil
+	}
+	if addr == nil {
+		return nil, fmt.Errorf("mmdb: invalid ip %v", ip)
+	}
+
+	for i := 0; i < bits && node < r.nodeCount; i++ {
+		bit := uint(addr[i>>3]>>(7-uint(i&7))) & 1
+		node = r.readNode(node, bit)
+	}
+	if node == r.nodeCount {
+		return nil, nil
+	}
+	if node < r.nodeCount {
+		return nil, errors.New("mmdb: invalid search tree")
+	}
+	offset := node - r.nodeCount - dataSectionSeparator
+	if offset >= uint(len(r.data)) {
+		return nil, errors.New("mmdb: data pointer out of range")
+	}
+	value, _, err := (&mmdbDecoder{buf: r.data}).decode(offset)
+	return value, err
+}
+
+type mmdbDecoder struct {
+	buf []byte
+}
+
+const (
+	mmdbExtended  = 0
+	mmdbPointer   = 1
+	mmdbString    = 2
+	mmdbDouble    = 3
+	mmdbBytes     = 4
+	mmdbUint16    = 5
+	mmdbUint32    = 6
+	mmdbMap       = 7
+	mmdbInt32     = 8
+	mmdbUint64    = 9
+	mmdbUint128   = 10
+	mmdbArray     = 11
+	mmdbContainer = 12
+	mmdbEndMarker = 13
+	mmdbBool      = 14
+	mmdbFloat     = 15
+)
+
+func (d *mmdbDecoder) need(offset, n uint) error {
+	if offset+n > uint(len(d.buf)) {
+		return errors.New("mmdb: unexpected end of data")
+	}
+	return nil
+}
+
+// decode decodes the value at offset and returns it with the offset of the
+// next value. Pointers are followed transparently.
+func (d *mmdbDecoder) decode(offset uint) (interface{}, uint, error) {
+	if err := d.need(offset, 1); err != nil {
+		return nil, 0, err
+	}
+	ctrl := d.buf[offset]
+	offset++
+	typ := uint(ctrl >> 5)
+
+	if typ == mmdbPointer {
+		ptr, next, err := d.pointer(ctrl, offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		value, _, err := d.decode(ptr)
+		return value, next, err
+	}
+	if typ == mmdbExtended {
+		if err := d.need(offset, 1); err != nil {
+			return nil, 0, err
+		}
+		typ = 7 + uint(d.buf[offset])
+		offset++
+	}
+
+	size := uint(ctrl & 0x1f)
+	switch {
+	case size == 29:
+		if err := d.need(offset, 1); err != nil {
+			return nil, 0, err
+		}
+		size = 29 + uint(d.buf[offset])
+		offset++
+	case size == 30:
+		if err := d.need(offset, 2); err != nil {
+			return nil, 0, err
+		}
+		size = 285 + (uint(d.buf[offset])<<8 | uint(d.buf[offset+1]))
+		offset += 2
+	case size == 31:
+		if err := d.need(offset, 3); err != nil {
+			return nil, 0, err
+		}
+		size = 65821 + (uint(d.buf[offset])<<16 | uint(d.buf[offset+1])<<8 | uint(d.buf[offset+2]))
+		offset += 3
+	}
+
+	switch typ {
+	case mmdbMap:
+		m := make(map[string]interface{}, size)
+		for i := uint(0); i < size; i++ {
+			key, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, after, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			if k, ok := key.(string); ok {
+				m[k] = value
+			}
+			offset = after
+		}
+		return m, offset, nil
+	case mmdbArray:
+		arr := make([]interface{}, 0, size)
+		for i := uint(0); i < size; i++ {
+			value, next, err := d.decode(offset)
+			if err != nil {
+				return nil, 0, err
+			}
+			arr = append(arr, value)
+			offset = next
+		}
+		return arr, offset, nil
+	case mmdbBool:
+		return size != 0, offset, nil
+	case mmdbContainer, mmdbEndMarker:
+		return nil, offset, nil
+	}
+
+	if err := d.need(offset, size); err != nil {
+		return nil, 0, err
+	}
+	raw := d.buf[offset : offset+size]
+	next := offset + size
+	switch typ {
+	case mmdbString:
+		return string(raw), next, nil
+	case mmdbBytes:
+		return append([]byte(nil), raw...), next, nil
+	case mmdbDouble:
+		if size != 8 {
+			return nil, 0, errors.New("mmdb: invalid double size")
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(raw)), next, nil
+	case mmdbFloat:
+		if size != 4 {
+			return nil, 0, errors.New("mmdb: invalid float size")
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(raw))), next, nil
+	case mmdbUint16, mmdbUint32, mmdbUint64:
+		var v uint64
+		for _, b := range raw {
+			v = v<<8 | uint64(b)
+		}
+		return v, next, nil
+	case mmdbInt32:
+		var v uint32
+		for _, b := range raw {
+			v = v<<8 | uint32(b)
+		}
+		return int64(int32(v)), next, nil
+	case mmdbUint128:
+		return new(big.Int).SetBytes(raw), next, nil
+	}
+	return nil, 0, fmt.Errorf("mmdb: unknown data type %d", typ)
+}
+
+func (d *mmdbDecoder) pointer(ctrl byte, offset uint) (ptr uint, next uint, err error) {
+	ss := uint(ctrl>>3) & 0x3
+	vvv := uint(ctrl & 0x7)
+	n := ss + 1
+	if err := d.need(offset, n); err != nil {
+		return 0, 0, err
+	}
+	b := d.buf[offset : offset+n]
+	switch ss {
+	case 0:
+		ptr = vvv<<8 | uint(b[0])
+	case 1:
+		ptr = (vvv<<16 | uint(b[0])<<8 | uint(b[1])) + 2048
+	case 2:
+		ptr = (vvv<<24 | uint(b[0])<<16 | uint(b[1])<<8 | uint(b[2])) + 526336
+	default:
+		ptr = uint(binary.BigEndian.Uint32(b))
+	}
+	return ptr, offset + n, nil
+}
+
+func asUint64(v interface{}) uint64 {
+	switch n := v.(type) {
+	case uint64:
+		return n
+	case int64:
+		if n > 0 {
+			return uint64(n)
+		}
+	}
+	return 0
+}
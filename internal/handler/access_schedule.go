@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/errors"
+	"github.com/alist-encrypt-go/internal/trace"
+)
+
+// accessNow is the clock access windows are checked against.
+var accessNow = time.Now
+
+var accessBlockedPage = template.Must(template.New("blocked").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Not available now</title>
+<style>body{font-family:sans-serif;max-width:32em;margin:4em auto;padding:0 1em;color:#333}code{background:#eee;padding:0 .3em}</style></head>
+<body><h1>Not available now</h1><p>{{.Path}} can only be opened during:</p><ul>{{range .Windows}}<li><code>{{.}}</code></li>{{end}}</ul>
+<p>Server time is {{.Now}}.</p></body></html>
+`))
+
+// allowAccessNow enforces the access windows of rule p on a read of
+// displayPath. Outside them it answers 403, as a page for browsers and as
+// JSON otherwise, records the attempt in audit and returns false.
+func allowAccessNow(w http.ResponseWriter, r *http.Request, p *config.PasswdInfo, displayPath string, audit *AuditLog) bool {
+	now := accessNow()
+	if p == nil || p.AccessAllowed(now) {
+		return true
+	}
+	log.Info().Str("path", displayPath).Str("remote", remoteIP(r)).Strs("windows", p.AccessWindows).Msg("Blocked read outside access window")
+	audit.Record(AuditEntry{
+		Actor:     AuditActor(r),
+		Action:    "access.blocked",
+		Path:      displayPath,
+		Status:    http.StatusForbidden,
+		RemoteIP:  remoteIP(r),
+		RequestID: trace.GetRequestID(r.Context()),
+	})
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set(errors.HeaderErrorCode, string(errors.CodeOutsideAccessWindow))
+		w.WriteHeader(http.StatusForbidden)
+		_ = accessBlockedPage.Execute(w, map[string]interface{}{
+			"Path":    displayPath,
+			"Windows": p.AccessWindows,
+			"Now":     now.In(time.Local).Format("Mon 15:04 MST"),
+		})
+		return false
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	respondAlistError(w, http.StatusForbidden, errors.CodeOutsideAccessWindow,
+		displayPath+" can only be opened during: "+strings.Join(p.AccessWindows, "; "))
+	return false
+}
@@ -0,0 +1,54 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/errors"
+)
+
+func TestHandleDownloadOutsideAccessWindowIsForbidden(t *testing.T) {
+	cfg := config.Get()
+	original := cfg.AlistServer
+	t.Cleanup(func() { cfg.AlistServer = original })
+	cfg.AlistServer.PasswdList = []config.PasswdInfo{{
+		Password: "secret", EncType: "aesctr", Enable: true, EncPath: []string{"/kids/*"},
+		AccessWindows: []string{"18:00-21:00"},
+	}}
+	accessNow = func() time.Time { return time.Date(2026, time.October, 16, 9, 0, 0, 0, time.Local) }
+	t.Cleanup(func() { accessNow = time.Now })
+
+	handler := newTestProxyHandler(t, cfg)
+	audit, _ := newTestAuditLog(t)
+	handler.SetAuditLog(audit)
+
+	rec := httptest.NewRecorder()
+	handler.HandleDownload(rec, httptest.NewRequest(http.MethodGet, "/d/kids/cartoon.mp4", nil))
+	if rec.Code != http.StatusForbidden || rec.Header().Get(errors.HeaderErrorCode) != string(errors.CodeOutsideAccessWindow) {
+		t.Fatalf("status=%d code=%q", rec.Code, rec.Header().Get(errors.HeaderErrorCode))
+	}
+	if !strings.Contains(rec.Body.String(), `"error_code":"OUTSIDE_ACCESS_WINDOW"`) || !strings.Contains(rec.Body.String(), "18:00-21:00") {
+		t.Fatalf("json body=%s", rec.Body.String())
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/d/kids/cartoon.mp4", nil)
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	rec = httptest.NewRecorder()
+	handler.HandleDownload(rec, req)
+	if rec.Code != http.StatusForbidden || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") ||
+		!strings.Contains(rec.Body.String(), "<code>18:00-21:00</code>") {
+		t.Fatalf("html status=%d body=%s", rec.Code, rec.Body.String())
+	}
+
+	entries, err := audit.Query(AuditFilter{Action: "access.blocked"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Path != "/kids/cartoon.mp4" || entries[0].Status != http.StatusForbidden {
+		t.Fatalf("audit=%+v", entries)
+	}
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/errors"
+)
+
+// HandleAdminAPI gates Alist /api/admin/* routes according to
+// adminRouteAccess before handing them to the regular passthrough. Exposing
+// the proxy publicly should not implicitly expose the Alist admin panel.
+func (h *ProxyHandler) HandleAdminAPI(w http.ResponseWriter, r *http.Request) {
+	if !h.adminRouteAllowed(r) {
+		log.Warn().
+			Str("path", r.URL.Path).
+			Str("remote", r.RemoteAddr).
+			Str("mode", h.cfg.AlistServer.AdminRouteAccess).
+			Msg("Blocked Alist admin route")
+		respondAlistError(w, http.StatusForbidden, errors.CodeAdminRouteBlocked, "admin routes are not reachable through this proxy")
+		return
+	}
+	h.HandleProxy(w, r)
+}
+
+func (h *ProxyHandler) adminRouteAllowed(r *http.Request) bool {
+	switch config.NormalizeAdminRouteAccess(h.cfg.AlistServer.AdminRouteAccess) {
+	case config.AdminRouteDeny:
+		return false
+	case config.AdminRouteLocal:
+		ip := net.ParseIP(redirectClientIP(r))
+		return ip != nil && (ip.IsLoopback() || ip.IsPrivate())
+	default:
+		return true
+	}
+}
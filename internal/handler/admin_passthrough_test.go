@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+func TestAdminRouteAccessModes(t *testing.T) {
+	cases := []struct {
+		mode   string
+		remote string
+		want   bool
+	}{
+		{config.AdminRouteAllow, "203.0.113.9:5000", true},
+		{config.AdminRouteDeny, "127.0.0.1:5000", false},
+		{config.AdminRouteLocal, "127.0.0.1:5000", true},
+		{config.AdminRouteLocal, "192.168.1.20:5000", true},
+		{config.AdminRouteLocal, "203.0.113.9:5000", false},
+		{"bogus", "203.0.113.9:5000", true},
+	}
+	for _, tc := range cases {
+		cfg := config.DefaultConfig()
+		cfg.AlistServer.AdminRouteAccess = tc.mode
+		h := newTestProxyHandler(t, cfg)
+
+		req := httptest.NewRequest(http.MethodGet, "/api/admin/setting/list", nil)
+		req.RemoteAddr = tc.remote
+		if got := h.adminRouteAllowed(req); got != tc.want {
+			t.Errorf("mode=%s remote=%s allowed=%v want %v", tc.mode, tc.remote, got, tc.want)
+		}
+	}
+}
+
+func TestHandleAdminAPIDenyDoesNotReachUpstream(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AlistServer.AdminRouteAccess = config.AdminRouteDeny
+	h := newTestProxyHandler(t, cfg)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/user/update", nil)
+	rec := httptest.NewRecorder()
+	h.HandleAdminAPI(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status=%d body=%s", rec.Code, rec.Body.String())
+	}
+}
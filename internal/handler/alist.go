@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"net/url"
 	"path"
 	"strings"
 	"sync"
@@ -19,9 +18,12 @@ import (
 	"github.com/alist-encrypt-go/internal/config"
 	"github.com/alist-encrypt-go/internal/dao"
 	"github.com/alist-encrypt-go/internal/encryption"
+	"github.com/alist-encrypt-go/internal/errors"
 	"github.com/alist-encrypt-go/internal/httputil"
+	"github.com/alist-encrypt-go/internal/pathutil"
 	"github.com/alist-encrypt-go/internal/proxy"
 	"github.com/alist-encrypt-go/internal/trace"
+	"github.com/alist-encrypt-go/internal/workers"
 )
 
 // AlistHandler handles Alist API interception
@@ -36,10 +38,23 @@ type AlistHandler struct {
 	probe        *ProbeScheduler
 	dirSyncStore DirSyncStore
 	dirSyncStart sync.Once
+	maintenance  *MaintenanceGate
+	readVerifier *ReadVerifier
+
+	uploadJournal    *UploadJournal
+	uploadRecoveryMu sync.Mutex
+	nameKDFJobs      *NameKDFJobs
+
 	dirSyncGroup singleflight.Group
 	fsMetaGroup  singleflight.Group
 	fsMetaMu     sync.Mutex
 	fsMetaCache  map[string]fsMetaCacheEntry
+	listGroup    singleflight.Group
+	listMu       sync.Mutex
+	listCache    map[string]listCacheEntry
+
+	decodeHealthMu   sync.Mutex
+	decodeHealthLast *DecodeHealthReport
 
 	fsMetaRequests         uint64
 	fsMetaCacheHits        uint64
@@ -48,6 +63,15 @@ type AlistHandler struct {
 	fsMetaRefreshBypass    uint64
 	fsMetaFailureFastHits  uint64
 	fsMetaFailureStores    uint64
+
+	listCacheHits        uint64
+	listSingleflightHits uint64
+	listUpstreamFetches  uint64
+
+	prewarming        sync.Map // raw_url -> struct{} while a prewarm probe runs
+	prewarmProbes     uint64
+	prewarmFailures   uint64
+	prewarmSkipsKnown uint64
 }
 
 type fsMetaCacheEntry struct {
@@ -89,6 +113,18 @@ func (h *AlistHandler) SetDirSyncStore(store DirSyncStore) {
 	h.dirSyncStore = store
 }
 
+// SetMaintenanceGate pauses the directory crawler, decode health checks and
+// re-encryption jobs during quiet hours.
+func (h *AlistHandler) SetMaintenanceGate(gate *MaintenanceGate) {
+	h.maintenance = gate
+}
+
+// SetReadVerifier records plaintext hashes of staged uploads for read
+// verification.
+func (h *AlistHandler) SetReadVerifier(verifier *ReadVerifier) {
+	h.readVerifier = verifier
+}
+
 func (h *AlistHandler) Stats() map[string]interface{} {
 	if h == nil {
 		return map[string]interface{}{}
@@ -96,6 +132,9 @@ func (h *AlistHandler) Stats() map[string]interface{} {
 	h.fsMetaMu.Lock()
 	fsMetaEntries := len(h.fsMetaCache)
 	h.fsMetaMu.Unlock()
+	h.listMu.Lock()
+	listEntries := len(h.listCache)
+	h.listMu.Unlock()
 	return map[string]interface{}{
 		"fs_metadata": map[string]interface{}{
 			"requests":          atomic.LoadUint64(&h.fsMetaRequests),
@@ -110,6 +149,21 @@ func (h *AlistHandler) Stats() map[string]interface{} {
 			"fail_ttl_seconds":  int(fsMetaFailureCacheTTL.Seconds()),
 			"max_entries":       maxFSMetaCacheEntries,
 		},
+		"fs_list": map[string]interface{}{
+			"cache_hits":        atomic.LoadUint64(&h.listCacheHits),
+			"singleflight_hits": atomic.LoadUint64(&h.listSingleflightHits),
+			"upstream_fetches":  atomic.LoadUint64(&h.listUpstreamFetches),
+			"entries":           listEntries,
+			"ttl_seconds":       int(h.listCacheTTL().Seconds()),
+			"max_entries":       maxListCacheEntries,
+		},
+		"redirect_prewarm": map[string]interface{}{
+			"enabled":     h.cfg != nil && h.cfg.AlistServer.EnableRedirectPrewarm,
+			"probes":      atomic.LoadUint64(&h.prewarmProbes),
+			"failures":    atomic.LoadUint64(&h.prewarmFailures),
+			"skips_known": atomic.LoadUint64(&h.prewarmSkipsKnown),
+		},
+		"decode_health": h.LastDecodeHealthReport(),
 	}
 }
 
@@ -121,7 +175,6 @@ type decryptResult struct {
 
 const (
 	parallelDecryptThreshold = 5
-	maxParallelDecryptLimit  = 32
 	fsMetaHotCacheTTL        = 10 * time.Second
 	fsMetaFailureCacheTTL    = 2 * time.Second
 	maxFSMetaCacheEntries    = 512
@@ -141,23 +194,14 @@ func (h *AlistHandler) parallelDecryptEnabled() bool {
 	return h.cfg != nil && h.cfg.AlistServer.EnableParallelDecrypt
 }
 
-func (h *AlistHandler) parallelDecryptLimit() int {
-	limit := 4
-	if h.cfg != nil && h.cfg.AlistServer.ParallelDecryptConcurrency > 0 {
-		limit = h.cfg.AlistServer.ParallelDecryptConcurrency
-	}
-	if limit < 1 {
-		limit = 1
-	}
-	if limit > maxParallelDecryptLimit {
-		limit = maxParallelDecryptLimit
-	}
-	return limit
+// nameDecryptPool bounds parallel filename decryption across all listings.
+func (h *AlistHandler) nameDecryptPool() *workers.Pool {
+	return workers.Shared(config.PoolNameDecrypt, h.cfg.WorkerLimit(config.PoolNameDecrypt))
 }
 
 func (h *AlistHandler) convertShowName(passwdInfo *config.PasswdInfo, name string) string {
 	allowLoose := h.cfg != nil && h.cfg.AlistServer.AllowLooseDecode
-	return encryption.ConvertShowNameWithSuffixOptions(passwdInfo.Password, passwdInfo.EncType, name, passwdInfo.EncSuffix, allowLoose)
+	return passwdInfo.NameConverter().ShowName(name, allowLoose)
 }
 
 // normalizeDecryptedListItem keeps display fields aligned with decrypted filename,
@@ -291,14 +335,15 @@ func (h *AlistHandler) resolveRemoveName(dirPath, name string, passwdInfo *confi
 		return path.Base(encPath)
 	}
 
-	if fileInfo, ok := h.fileDAO.Get(url.QueryEscape(displayPath)); ok && fileInfo != nil && fileInfo.Path != "" {
-		if base := path.Base(fileInfo.Path); base != "" {
-			return base
-		}
+	// The path cache answers for encrypted paths too; only an entry whose
+	// display path is this one tells us the stored name.
+	key := pathutil.Display(displayPath).Key()
+	if fileInfo, ok := h.fileDAO.Get(key); ok && fileInfo != nil && fileInfo.Path == key && fileInfo.EncryptedPath != "" {
+		return path.Base(fileInfo.EncryptedPath)
 	}
 
 	if passwdInfo != nil && passwdInfo.EncName {
-		converter := encryption.NewFileNameConverter(passwdInfo.Password, passwdInfo.EncType, passwdInfo.EncSuffix)
+		converter := passwdInfo.NameConverter()
 		fileName := path.Base(name)
 		encBase := strings.TrimSuffix(fileName, path.Ext(fileName))
 		if converter.DecryptFileName(encBase) != "" {
@@ -577,7 +622,7 @@ func (h *AlistHandler) HandleFsSearch(w http.ResponseWriter, r *http.Request) {
 		respBody, err := readLimitedBody(resp, maxProxyResponseBody)
 		if err != nil {
 			log.Warn().Err(err).Msg("Upstream response body read failed")
-			http.Error(w, "Bad gateway: upstream response too large", http.StatusBadGateway)
+			RespondCodedError(w, errors.CodeUpstreamTooLarge, "Bad gateway: upstream response too large", http.StatusBadGateway)
 			return
 		}
 		RespondRaw(w, resp.StatusCode, resp.Header.Get("Content-Type"), respBody)
@@ -597,7 +642,7 @@ func (h *AlistHandler) HandleFsSearch(w http.ResponseWriter, r *http.Request) {
 		respBody, err := readLimitedBody(resp, maxProxyResponseBody)
 		if err != nil {
 			log.Warn().Err(err).Msg("Upstream response body read failed")
-			http.Error(w, "Bad gateway: upstream response too large", http.StatusBadGateway)
+			RespondCodedError(w, errors.CodeUpstreamTooLarge, "Bad gateway: upstream response too large", http.StatusBadGateway)
 			return
 		}
 		RespondRaw(w, resp.StatusCode, resp.Header.Get("Content-Type"), respBody)
@@ -671,11 +716,18 @@ func (h *AlistHandler) HandleFsList(w http.ResponseWriter, r *http.Request) {
 
 	dirPath, _ := reqData["path"].(string)
 	trace.Logf(r.Context(), "list", "Handling fs list for path: %s", dirPath)
+	w = withCacheControl(w, cacheControlFor(h.cfg, config.CacheTargetList, dirPath))
 	h.ensureDirSyncLoop()
 	authHash := authScopeHash(h.requestAuthHeaders(r))
 	scopeKey := buildDirScopeKey(dirPath, authHash)
-	if h.dirSyncStore != nil {
-		if snap, ok, _ := h.dirSyncStore.GetSnapshot(r.Context(), scopeKey); ok && snap != nil && len(snap.PayloadJSON) > 0 {
+	// Snapshots hold whole directories, so only non-paginated requests (or a
+	// first page large enough to hold the snapshot) are served from them.
+	page, perPage := parseListPage(body)
+	fitsSnapshot := func(snap *DirListSnapshot) bool {
+		return page <= 1 && (perPage <= 0 || snap.ItemCount <= perPage)
+	}
+	if h.dirSyncStore != nil && page <= 1 {
+		if snap, ok, _ := h.dirSyncStore.GetSnapshot(r.Context(), scopeKey); ok && snap != nil && len(snap.PayloadJSON) > 0 && fitsSnapshot(snap) {
 			if isSuccessfulListPayload(snap.PayloadJSON) {
 				if valid, reason := validateSnapshotForDir(dirPath, snap); valid {
 					h.serveSnapshot(w, snap, "snapshot")
@@ -695,7 +747,7 @@ func (h *AlistHandler) HandleFsList(w http.ResponseWriter, r *http.Request) {
 		}
 		if h.scanConfigured() {
 			scanScopeKey := buildDirScopeKey(dirPath, dirSyncScopeScan)
-			if snap, ok, _ := h.dirSyncStore.GetSnapshot(r.Context(), scanScopeKey); ok && snap != nil && len(snap.PayloadJSON) > 0 {
+			if snap, ok, _ := h.dirSyncStore.GetSnapshot(r.Context(), scanScopeKey); ok && snap != nil && len(snap.PayloadJSON) > 0 && fitsSnapshot(snap) {
 				if isSuccessfulListPayload(snap.PayloadJSON) {
 					if valid, reason := validateSnapshotForDir(dirPath, snap); valid {
 						h.serveSnapshot(w, snap, "background_scan")
@@ -716,13 +768,13 @@ func (h *AlistHandler) HandleFsList(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	statusCode, _, payload, itemCount, err := h.liveFsListResponse(r, body, dirPath, true)
+	statusCode, payload, itemCount, err := h.fetchFsListShared(r, body, dirPath, authHash)
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to proxy fs/list")
 		RespondHTTPErrorWithStatus(w, "Proxy error", http.StatusBadGateway)
 		return
 	}
-	if h.dirSyncStore != nil && statusCode >= 200 && statusCode < 300 && isSuccessfulListPayload(payload) {
+	if h.dirSyncStore != nil && statusCode >= 200 && statusCode < 300 && isSuccessfulListPayload(payload) && isCompleteListPayload(page, payload) {
 		h.persistSnapshot(r.Context(), dirPath, scopeKey, authHash, payload, itemCount, dirSyncModeReq, "")
 	}
 	RespondRaw(w, statusCode, "application/json", payload)
@@ -738,6 +790,52 @@ func (h *AlistHandler) HandleFsLink(w http.ResponseWriter, r *http.Request) {
 	h.handleFsGetOrLink(w, r, "/api/fs/link")
 }
 
+// HandleFsOther intercepts /api/fs/other (driver-specific operations such as
+// video_preview) so the path reaches Alist under its encrypted name and any
+// returned file link is routed through decryption.
+func (h *AlistHandler) HandleFsOther(w http.ResponseWriter, r *http.Request) {
+	body, err := readLimitedRequestBody(r)
+	if err != nil {
+		RespondHTTPErrorWithStatus(w, "Failed to read request", http.StatusBadRequest)
+		return
+	}
+
+	var reqData map[string]interface{}
+	if err := json.Unmarshal(body, &reqData); err != nil {
+		RespondHTTPErrorWithStatus(w, "Invalid JSON", http.StatusBadRequest)
+		return
+	}
+
+	originalPath, _ := reqData["path"].(string)
+	filePath := originalPath
+	passwdInfo, found := h.resolveFsFileRule(r, filePath)
+	if found {
+		filePath = h.realFsFilePath(r, filePath, passwdInfo)
+		reqData["path"] = filePath
+		body, _ = json.Marshal(reqData)
+	}
+
+	targetURL := httputil.BuildTargetURL(h.cfg.GetAlistURL(), "/api/fs/other", nil)
+	upstream, err := h.fetchFSMetaFromAlist(r, "/api/fs/other", targetURL, body)
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to proxy fs/other")
+		RespondHTTPErrorWithStatus(w, "Proxy error", http.StatusBadGateway)
+		return
+	}
+
+	var respData map[string]interface{}
+	if !found || json.Unmarshal(upstream.Body, &respData) != nil {
+		RespondRaw(w, upstream.StatusCode, upstream.Header.Get("Content-Type"), upstream.Body)
+		return
+	}
+	if data, ok := respData["data"].(map[string]interface{}); ok {
+		if field := fsLinkField(data); field != "" {
+			h.rewriteFsLink(r, data, field, filePath, originalPath, passwdInfo)
+		}
+	}
+	RespondJSON(w, upstream.StatusCode, respData)
+}
+
 func (h *AlistHandler) handleFsGetOrLink(w http.ResponseWriter, r *http.Request, apiPath string) {
 	body, err := readLimitedRequestBody(r)
 	if err != nil {
@@ -755,37 +853,10 @@ func (h *AlistHandler) handleFsGetOrLink(w http.ResponseWriter, r *http.Request,
 	originalPath := filePath
 	trace.Logf(r.Context(), "get", "Processing %s path: %s", apiPath, filePath)
 
-	// Check if filename encryption is needed
-	passwdInfo, found := h.passwdDAO.PathFindPasswd(filePath)
-	if !found {
-		// Fallback: check for X-OpenEncrypt-Rule-* headers from openencrypt-android
-		if headerInfo := PasswdInfoFromOpenEncryptHeaders(r); headerInfo != nil {
-			passwdInfo = headerInfo
-			found = true
-			trace.Logf(r.Context(), "get", "Using encryption config from X-OpenEncrypt-Rule headers")
-		}
-	}
-	if found && passwdInfo.EncName {
-		if !h.isEncryptedDirRoot(filePath) {
-			// Check if it's a directory first
-			fileInfo, exists := h.fileDAO.Get(url.QueryEscape(filePath))
-			if !exists || !fileInfo.IsDir {
-				// First try to get cached encrypted path
-				if encPath, ok := h.fileDAO.GetEncPath(filePath); ok {
-					filePath = encPath
-					reqData["path"] = filePath
-					trace.Logf(r.Context(), "get", "Using cached enc path: %s -> %s", originalPath, filePath)
-				} else {
-					// Fallback: re-encrypt (for backwards compatibility)
-					converter := encryption.NewFileNameConverter(passwdInfo.Password, passwdInfo.EncType, passwdInfo.EncSuffix)
-					fileName := path.Base(filePath)
-					realName := converter.ToRealName(fileName)
-					filePath = path.Dir(filePath) + "/" + realName
-					reqData["path"] = filePath
-					trace.Logf(r.Context(), "get", "Fallback enc: %s -> %s", originalPath, filePath)
-				}
-			}
-		}
+	passwdInfo, found := h.resolveFsFileRule(r, filePath)
+	if found {
+		filePath = h.realFsFilePath(r, filePath, passwdInfo)
+		reqData["path"] = filePath
 	}
 
 	// Marshal updated request
@@ -835,40 +906,9 @@ func (h *AlistHandler) handleFsGetOrLink(w http.ResponseWriter, r *http.Request,
 				}
 			}
 
-			// Modify raw_url for encrypted files
-			if rawURL, ok := data["raw_url"].(string); ok && rawURL != "" {
-				ciphertextSize := int64(0)
-				if size, ok := data["size"].(float64); ok {
-					ciphertextSize = int64(size)
-				}
-				meta := h.inspectContentMetaWithFallback(r, rawURL, filePath, ciphertextSize, passwdInfo)
-				fileSize := ciphertextSize
-				if meta.IsV2() && meta.PlainSize > 0 {
-					fileSize = meta.PlainSize
-					data["size"] = float64(fileSize)
-				}
-				if fileSize > 0 {
-					h.upsertMetaFromListing(r.Context(), originalPath, fileSize)
-				}
-				h.enqueueProbeFromList(r, originalPath, fileSize)
-				_ = h.fileDAO.Set(&dao.FileInfo{
-					Path:           originalPath,
-					EncryptedPath:  filePath,
-					Name:           path.Base(originalPath),
-					Size:           fileSize,
-					CiphertextSize: ciphertextSize,
-					ContentVersion: meta.Version,
-					HeaderLen:      meta.HeaderLen,
-					NonceField:     append([]byte(nil), meta.NonceField...),
-					IsDir:          false,
-					RawURL:         rawURL,
-					Sign:           func() string { v, _ := data["sign"].(string); return v }(),
-				})
-
-				// Register redirect and update URL
-				key := h.proxyHandler.RegisterRedirect(rawURL, fileSize, passwdInfo, originalPath)
-				redirectPath := buildRedirectPath(key, originalPath, true)
-				data["raw_url"] = buildRedirectURL(r, redirectPath)
+			// Modify raw_url (fs/get) or url (fs/link) for encrypted files
+			if field := fsLinkField(data); field != "" {
+				h.rewriteFsLink(r, data, field, filePath, originalPath, passwdInfo)
 			} else {
 				h.fileDAO.SetFromAlistResponse(originalPath, data)
 			}
@@ -887,6 +927,97 @@ func (h *AlistHandler) handleFsGetOrLink(w http.ResponseWriter, r *http.Request,
 	RespondJSON(w, upstream.StatusCode, respData)
 }
 
+// fsLinkField returns the response field carrying the upstream file URL:
+// raw_url for fs/get, url for fs/link.
+func fsLinkField(data map[string]interface{}) string {
+	for _, field := range []string{"raw_url", "url"} {
+		if v, ok := data[field].(string); ok && v != "" {
+			return field
+		}
+	}
+	return ""
+}
+
+// rewriteFsLink replaces data[field] with a signed redirect that decrypts the
+// upstream file, caching its metadata on the way. fs/link responses carry no
+// size, so the size cached from an earlier listing is used instead.
+func (h *AlistHandler) rewriteFsLink(r *http.Request, data map[string]interface{}, field, filePath, originalPath string, passwdInfo *config.PasswdInfo) {
+	rawURL := data[field].(string)
+	ciphertextSize := int64(0)
+	if size, ok := data["size"].(float64); ok {
+		ciphertextSize = int64(size)
+	} else if cached, ok := h.fileDAO.Get(originalPath); ok && cached != nil {
+		ciphertextSize = cached.CiphertextSize
+		if ciphertextSize <= 0 {
+			ciphertextSize = cached.Size
+		}
+	}
+	meta := h.inspectContentMetaWithFallback(r, rawURL, filePath, ciphertextSize, passwdInfo)
+	fileSize := ciphertextSize
+	if meta.HasContentHeader() && meta.PlainSize > 0 {
+		fileSize = meta.PlainSize
+		if _, ok := data["size"]; ok {
+			data["size"] = float64(fileSize)
+		}
+	}
+	if fileSize > 0 {
+		h.upsertMetaFromListing(r.Context(), originalPath, fileSize)
+	}
+	h.enqueueProbeFromList(r, originalPath, fileSize)
+	_ = h.fileDAO.Set(&dao.FileInfo{
+		Path:           originalPath,
+		EncryptedPath:  filePath,
+		Name:           path.Base(originalPath),
+		Size:           fileSize,
+		CiphertextSize: ciphertextSize,
+		ContentVersion: meta.Version,
+		HeaderLen:      meta.HeaderLen,
+		NonceField:     append([]byte(nil), meta.NonceField...),
+		IsDir:          false,
+		RawURL:         rawURL,
+		Sign:           func() string { v, _ := data["sign"].(string); return v }(),
+	})
+
+	// Register redirect and update URL
+	key := h.proxyHandler.RegisterRedirect(rawURL, fileSize, passwdInfo, originalPath)
+	h.prewarmRedirect(r, rawURL, originalPath, meta)
+	redirectPath := h.proxyHandler.signRedirectPath(r, buildRedirectPath(key, originalPath, true))
+	data[field] = buildRedirectURL(r, redirectPath)
+}
+
+// resolveFsFileRule finds the password rule for an fs API path, falling back
+// to X-OpenEncrypt-Rule-* headers sent by openencrypt-android.
+func (h *AlistHandler) resolveFsFileRule(r *http.Request, filePath string) (*config.PasswdInfo, bool) {
+	passwdInfo, found := h.passwdDAO.PathFindPasswdFor(r.Context(), filePath)
+	if !found {
+		if headerInfo := PasswdInfoFromOpenEncryptHeaders(r); headerInfo != nil {
+			trace.Logf(r.Context(), "get", "Using encryption config from X-OpenEncrypt-Rule headers")
+			return headerInfo, true
+		}
+	}
+	return passwdInfo, found
+}
+
+// realFsFilePath maps a display file path to its encrypted upstream path.
+// Directories and the encrypted root keep their names.
+func (h *AlistHandler) realFsFilePath(r *http.Request, filePath string, passwdInfo *config.PasswdInfo) string {
+	if passwdInfo == nil || !passwdInfo.EncName || h.isEncryptedDirRoot(filePath) {
+		return filePath
+	}
+	if fileInfo, exists := h.fileDAO.Get(pathutil.Display(filePath).Key()); exists && fileInfo.IsDir {
+		return filePath
+	}
+	if encPath, ok := h.fileDAO.GetEncPath(filePath); ok {
+		trace.Logf(r.Context(), "get", "Using cached enc path: %s -> %s", filePath, encPath)
+		return encPath
+	}
+	// Fallback: re-encrypt (for backwards compatibility)
+	converter := passwdInfo.NameConverter()
+	realPath := path.Dir(filePath) + "/" + converter.ToRealName(path.Base(filePath))
+	trace.Logf(r.Context(), "get", "Fallback enc: %s -> %s", filePath, realPath)
+	return realPath
+}
+
 func (h *AlistHandler) fetchFSMetaUpstream(r *http.Request, apiPath, targetURL string, body []byte, cacheAllowed bool) (*fsMetaUpstreamResponse, bool, bool, error) {
 	atomic.AddUint64(&h.fsMetaRequests, 1)
 	if !cacheAllowed {
@@ -1059,7 +1190,7 @@ func (h *AlistHandler) inspectContentMetaWithFallback(r *http.Request, rawURL, e
 	authVariants := buildProbeAuthVariants(h.cfg, r.Header)
 	for _, headers := range authVariants {
 		meta := h.streamProxy.InspectEncryptedContent(r.Context(), rawURL, headers, passwdInfo, ciphertextSize)
-		if meta.IsV2() && meta.PlainSize > 0 {
+		if meta.HasContentHeader() && meta.PlainSize > 0 {
 			return meta
 		}
 	}
@@ -1087,7 +1218,7 @@ func (h *AlistHandler) inspectContentMetaWithFallback(r *http.Request, rawURL, e
 		seen[candidate] = struct{}{}
 		for _, headers := range authVariants {
 			fallback := h.streamProxy.InspectEncryptedContent(r.Context(), candidate, headers, passwdInfo, ciphertextSize)
-			if fallback.IsV2() && fallback.PlainSize > 0 {
+			if fallback.HasContentHeader() && fallback.PlainSize > 0 {
 				trace.Logf(r.Context(), "get", "Detected V2 content via fallback probe target=%s plain=%d cipher=%d", candidate, fallback.PlainSize, fallback.CiphertextSize)
 				return fallback
 			}
@@ -1144,12 +1275,15 @@ func (h *AlistHandler) enqueueProbeFromList(r *http.Request, displayPath string,
 func (h *AlistHandler) HandleFsPut(w http.ResponseWriter, r *http.Request) {
 	uploadPath := r.Header.Get("File-Path")
 	if uploadPath != "" {
-		uploadPath, _ = url.QueryUnescape(uploadPath)
+		uploadPath, _ = pathutil.UnescapeFilePath(uploadPath)
 	} else {
 		uploadPath = "/-"
 	}
+	// Whatever the outcome, the parent listing may now be stale.
+	defer h.InvalidateListCache(path.Dir(uploadPath))
+	h.fileDAO.Revive(uploadPath)
 
-	passwdInfo, found := h.passwdDAO.PathFindPasswd(uploadPath)
+	passwdInfo, found := h.passwdDAO.PathFindPasswdFor(r.Context(), uploadPath)
 	if !found {
 		// No encryption, proxy directly
 		targetURL := httputil.BuildTargetURL(h.cfg.GetAlistURL(), "/api/fs/put", r)
@@ -1184,25 +1318,36 @@ func (h *AlistHandler) HandleFsPut(w http.ResponseWriter, r *http.Request) {
 	// Handle filename encryption
 	var encryptedPath string
 	if passwdInfo.EncName {
-		converter := encryption.NewFileNameConverter(passwdInfo.Password, passwdInfo.EncType, passwdInfo.EncSuffix)
-		fileName := path.Base(uploadPath)
-		ext := passwdInfo.EncSuffix
-		if ext == "" {
-			ext = path.Ext(fileName)
+		encName, shownName, err := storedNameFor(h.cfg, passwdInfo, path.Base(uploadPath), true)
+		if err != nil {
+			respondAlistError(w, http.StatusBadRequest, errors.CodeNameTooLong, err.Error())
+			return
 		}
-		encName := converter.EncryptFileName(fileName)
-		encryptedPath = path.Dir(uploadPath) + "/" + encName + ext
-		r.Header.Set("File-Path", url.QueryEscape(encryptedPath))
+		uploadPath = path.Join(path.Dir(uploadPath), shownName)
+		encryptedPath = path.Dir(uploadPath) + "/" + encName
+		h.fileDAO.Revive(uploadPath)
+		r.Header.Set("File-Path", pathutil.EscapeFilePath(encryptedPath))
 		log.Debug().Str("original", uploadPath).Str("encrypted", encryptedPath).Msg("Encrypted filename for upload")
 	}
 
 	// Encrypt and upload
 	targetURL := httputil.BuildTargetURL(h.cfg.GetAlistURL(), "/api/fs/put", r)
 
-	if err := h.streamProxy.ProxyUploadEncrypt(w, r, targetURL, passwdInfo, fileSize, startOffset); err != nil {
-		log.Error().Err(err).Str("path", uploadPath).Msg("Failed to encrypt upload")
-		RespondHTTPErrorWithStatus(w, "Encryption error", http.StatusBadGateway)
-		return
+	if h.uploadStagingEnabled(r, hasRange) {
+		finalPath := uploadPath
+		if encryptedPath != "" {
+			finalPath = encryptedPath
+		}
+		if !h.putStaged(w, r, targetURL, passwdInfo, fileSize, finalPath, uploadPath) {
+			return
+		}
+	} else {
+		h.readVerifier.Forget(uploadPath)
+		if err := h.streamProxy.ProxyUploadEncrypt(w, r, targetURL, passwdInfo, fileSize, startOffset); err != nil {
+			log.Error().Err(err).Str("path", uploadPath).Msg("Failed to encrypt upload")
+			RespondCodedError(w, errors.CodeEncryptFailed, "Encryption error", http.StatusBadGateway)
+			return
+		}
 	}
 
 	// Update cache mapping after successful upload
@@ -1273,7 +1418,7 @@ func (h *AlistHandler) HandleFsRemove(w http.ResponseWriter, r *http.Request) {
 	respBody, err := readLimitedBody(resp, maxProxyResponseBody)
 	if err != nil {
 		log.Warn().Err(err).Msg("Upstream response body read failed")
-		http.Error(w, "Bad gateway: upstream response too large", http.StatusBadGateway)
+		RespondCodedError(w, errors.CodeUpstreamTooLarge, "Bad gateway: upstream response too large", http.StatusBadGateway)
 		return
 	}
 
@@ -1281,11 +1426,10 @@ func (h *AlistHandler) HandleFsRemove(w http.ResponseWriter, r *http.Request) {
 	var respData map[string]interface{}
 	if err := json.Unmarshal(respBody, &respData); err == nil {
 		if code, ok := respData["code"].(float64); ok && code == 200 {
+			h.InvalidateListCache(reqData.Dir)
 			for _, name := range reqData.Names {
 				displayPath := path.Join(reqData.Dir, name)
-				h.fileDAO.DeleteEncPathMapping(displayPath)
-				h.fileDAO.InvalidateDisplayPath(displayPath)
-				h.fileDAO.Delete(url.QueryEscape(displayPath))
+				h.fileDAO.Forget(displayPath)
 				if h.probe != nil {
 					h.probe.InvalidateWarm(displayPath, "fs_remove")
 				}
@@ -1321,28 +1465,27 @@ func (h *AlistHandler) HandleFsRename(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if found && passwdInfo.EncName {
-		converter := encryption.NewFileNameConverter(passwdInfo.Password, passwdInfo.EncType, passwdInfo.EncSuffix)
+		converter := passwdInfo.NameConverter()
 
 		// Check if it's a file (not directory)
-		fileInfo, exists := h.fileDAO.Get(url.QueryEscape(reqData.Path))
+		fileInfo, exists := h.fileDAO.Get(pathutil.Display(reqData.Path).Key())
 		if !exists {
 			// Try with encrypted name
 			realName := converter.ToRealName(reqData.Path)
 			realPath := path.Dir(reqData.Path) + "/" + realName
-			fileInfo, exists = h.fileDAO.Get(url.QueryEscape(realPath))
+			fileInfo, exists = h.fileDAO.Get(pathutil.Display(realPath).Key())
 		}
 
 		if !exists || !fileInfo.IsDir {
-			ext := passwdInfo.EncSuffix
-			if ext == "" {
-				ext = path.Ext(reqData.Name)
+			newEncName, _, err := storedNameFor(h.cfg, passwdInfo, reqData.Name, true)
+			if err != nil {
+				respondAlistError(w, http.StatusBadRequest, errors.CodeNameTooLong, err.Error())
+				return
 			}
-
 			realOldName := converter.ToRealName(reqData.Path)
-			newEncName := converter.EncryptFileName(reqData.Name)
 
 			modifiedReq["path"] = path.Dir(reqData.Path) + "/" + realOldName
-			modifiedReq["name"] = newEncName + ext
+			modifiedReq["name"] = newEncName
 		}
 	}
 
@@ -1371,7 +1514,7 @@ func (h *AlistHandler) HandleFsRename(w http.ResponseWriter, r *http.Request) {
 	respBody, err := readLimitedBody(resp, maxProxyResponseBody)
 	if err != nil {
 		log.Warn().Err(err).Msg("Upstream response body read failed")
-		http.Error(w, "Bad gateway: upstream response too large", http.StatusBadGateway)
+		RespondCodedError(w, errors.CodeUpstreamTooLarge, "Bad gateway: upstream response too large", http.StatusBadGateway)
 		return
 	}
 
@@ -1379,17 +1522,18 @@ func (h *AlistHandler) HandleFsRename(w http.ResponseWriter, r *http.Request) {
 	var respData map[string]interface{}
 	if err := json.Unmarshal(respBody, &respData); err == nil {
 		if code, ok := respData["code"].(float64); ok && code == 200 {
-			// Delete old path mapping
-			h.fileDAO.DeleteEncPathMapping(reqData.Path)
-			h.fileDAO.InvalidateDisplayPath(reqData.Path)
-			h.fileDAO.Delete(url.QueryEscape(reqData.Path))
+			h.InvalidateListCache(path.Dir(reqData.Path), reqData.Path)
+			// Drop the old path and everything cached below it
+			h.fileDAO.Forget(reqData.Path)
 			if h.probe != nil {
 				h.probe.InvalidateWarm(reqData.Path, "fs_rename_source")
 			}
 
+			newDisplayPath := path.Dir(reqData.Path) + "/" + reqData.Name
+			h.fileDAO.Revive(newDisplayPath)
+
 			// Add new path mapping if filename encryption is enabled
 			if found && passwdInfo.EncName {
-				newDisplayPath := path.Dir(reqData.Path) + "/" + reqData.Name
 				newEncPath := modifiedReq["path"].(string)[:len(path.Dir(reqData.Path))+1] + modifiedReq["name"].(string)
 				h.fileDAO.SetEncPathMapping(newDisplayPath, newEncPath)
 				log.Debug().Str("old", reqData.Path).Str("new", newDisplayPath).Msg("Updated cache for renamed file")
@@ -1427,17 +1571,25 @@ func (h *AlistHandler) handleCopyOrMove(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
+	for _, name := range reqData.Names {
+		dst := path.Join(reqData.DstDir, name)
+		if _, violated := strictPlaintextRule(h.passwdDAO, path.Join(reqData.SrcDir, name), dst); violated {
+			respondAlistError(w, http.StatusForbidden, errors.CodeStrictPlaintextWrite, strictPlaintextMessage(dst, endpoint))
+			return
+		}
+	}
+
 	passwdInfo, found := h.passwdDAO.PathFindPasswd(reqData.SrcDir)
 	fileNames := reqData.Names
 
 	if found && passwdInfo.EncName {
-		converter := encryption.NewFileNameConverter(passwdInfo.Password, passwdInfo.EncType, passwdInfo.EncSuffix)
+		converter := passwdInfo.NameConverter()
 		fileNames = make([]string, 0, len(reqData.Names))
 		for _, name := range reqData.Names {
 			if encryption.IsOriginalFile(name) {
 				fileNames = append(fileNames, encryption.StripOriginalPrefix(name))
 			} else {
-				ext := passwdInfo.EncSuffix
+				ext := passwdInfo.NameSuffix()
 				if ext == "" {
 					ext = path.Ext(name)
 				}
@@ -1477,7 +1629,7 @@ func (h *AlistHandler) handleCopyOrMove(w http.ResponseWriter, r *http.Request,
 	respBody, err := readLimitedBody(resp, maxProxyResponseBody)
 	if err != nil {
 		log.Warn().Err(err).Msg("Upstream response body read failed")
-		http.Error(w, "Bad gateway: upstream response too large", http.StatusBadGateway)
+		RespondCodedError(w, errors.CodeUpstreamTooLarge, "Bad gateway: upstream response too large", http.StatusBadGateway)
 		return
 	}
 
@@ -1486,24 +1638,24 @@ func (h *AlistHandler) handleCopyOrMove(w http.ResponseWriter, r *http.Request,
 	if err := json.Unmarshal(respBody, &respData); err == nil {
 		if code, ok := respData["code"].(float64); ok && code == 200 {
 			isMove := endpoint == "/api/fs/move"
+			h.InvalidateListCache(reqData.SrcDir, reqData.DstDir)
 			for i, name := range reqData.Names {
 				srcDisplayPath := path.Join(reqData.SrcDir, name)
 				dstDisplayPath := path.Join(reqData.DstDir, name)
 
 				// For move operations, delete the source cache entry
 				if isMove {
-					h.fileDAO.DeleteEncPathMapping(srcDisplayPath)
-					h.fileDAO.InvalidateDisplayPath(srcDisplayPath)
-					h.fileDAO.Delete(url.QueryEscape(srcDisplayPath))
+					h.fileDAO.Forget(srcDisplayPath)
 					if h.probe != nil {
 						h.probe.InvalidateWarm(srcDisplayPath, "fs_move_source")
 					}
 				}
+				h.fileDAO.Revive(dstDisplayPath)
 
 				// Add destination path mapping if filename encryption is enabled
 				if found && passwdInfo.EncName && i < len(fileNames) {
-					dstEncPath := path.Join(reqData.DstDir, fileNames[i])
-					h.fileDAO.SetEncPathMapping(dstDisplayPath, dstEncPath)
+					dstName := h.reconcileCopySuffix(r, passwdInfo, reqData.DstDir, name, fileNames[i])
+					h.fileDAO.SetEncPathMapping(dstDisplayPath, path.Join(reqData.DstDir, dstName))
 				}
 			}
 			log.Debug().Str("endpoint", endpoint).Int("count", len(reqData.Names)).Msg("Updated cache for moved/copied files")
@@ -1512,3 +1664,40 @@ func (h *AlistHandler) handleCopyOrMove(w http.ResponseWriter, r *http.Request,
 
 	RespondRaw(w, resp.StatusCode, "application/json", respBody)
 }
+
+// reconcileCopySuffix renames a copied/moved file whose destination folder
+// uses a different encSuffix with the same key, so later name resolution in
+// the destination finds it. It returns the encrypted name now in place.
+func (h *AlistHandler) reconcileCopySuffix(r *http.Request, srcPasswd *config.PasswdInfo, dstDir, displayName, copiedName string) string {
+	if encryption.IsOriginalFile(displayName) {
+		return copiedName
+	}
+	dstPasswd, ok := h.passwdDAO.PathFindPasswd(path.Join(dstDir, displayName))
+	if !ok || !dstPasswd.EncName {
+		return copiedName
+	}
+	if dstPasswd.Password != srcPasswd.Password || dstPasswd.EncType != srcPasswd.EncType {
+		log.Warn().Str("dst_dir", dstDir).Str("name", displayName).Msg("Copy target uses a different encryption key; name left unchanged")
+		return copiedName
+	}
+	if encryption.NormalizeEncSuffix(dstPasswd.NameSuffix()) == encryption.NormalizeEncSuffix(srcPasswd.NameSuffix()) {
+		return copiedName
+	}
+
+	converter := dstPasswd.NameConverter()
+	wantName := converter.ToRealName(displayName)
+	if wantName == copiedName {
+		return copiedName
+	}
+	err := h.alistAPICall(r.Context(), r, "/api/fs/rename", map[string]interface{}{
+		"path": path.Join(dstDir, copiedName),
+		"name": wantName,
+	}, nil)
+	if err != nil {
+		// Cross-storage copies run as Alist tasks and may not exist yet.
+		log.Warn().Err(err).Str("dst_dir", dstDir).Str("name", copiedName).Msg("Failed to apply destination encSuffix after copy")
+		return copiedName
+	}
+	log.Debug().Str("dst_dir", dstDir).Str("from", copiedName).Str("to", wantName).Msg("Renamed copied file to destination encSuffix")
+	return wantName
+}
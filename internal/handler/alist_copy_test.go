@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/encryption"
+)
+
+func TestHandleFsCopyRenamesToDestinationSuffix(t *testing.T) {
+	src := config.PasswdInfo{Password: "pw", EncType: "aesctr", Enable: true, EncName: true, EncSuffix: ".bin", EncPath: []string{"/a/*"}}
+	dst := config.PasswdInfo{Password: "pw", EncType: "aesctr", Enable: true, EncName: true, EncSuffix: ".dat", EncPath: []string{"/b/*"}}
+
+	var renames []map[string]interface{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/fs/copy", func(w http.ResponseWriter, r *http.Request) {
+		writeJSONResponse(w, map[string]interface{}{"code": 200, "message": "success"})
+	})
+	mux.HandleFunc("/api/fs/rename", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		renames = append(renames, req)
+		writeJSONResponse(w, map[string]interface{}{"code": 200, "message": "success"})
+	})
+	srv := newSocketTestServer(t, mux)
+	defer srv.Close()
+
+	handler, fileDAO := newTestAlistHandler(t, srv.URL, &src)
+	handler.cfg.AlistServer.PasswdList = []config.PasswdInfo{src, dst}
+
+	body, _ := json.Marshal(map[string]interface{}{"src_dir": "/a", "dst_dir": "/b", "names": []string{"movie.mp4"}})
+	req := httptest.NewRequest(http.MethodPost, "http://proxy.local/api/fs/copy", bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	handler.HandleFsCopy(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	encName := encryption.EncodeName("pw", "aesctr", "movie.mp4")
+	if len(renames) != 1 {
+		t.Fatalf("renames=%v", renames)
+	}
+	if renames[0]["path"] != "/b/"+encName+".bin" || renames[0]["name"] != encName+".dat" {
+		t.Fatalf("unexpected rename: %v", renames[0])
+	}
+	if got, ok := fileDAO.GetEncPath("/b/movie.mp4"); !ok || got != "/b/"+encName+".dat" {
+		t.Fatalf("destination mapping=%q ok=%v", got, ok)
+	}
+}
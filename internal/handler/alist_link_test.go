@@ -90,6 +90,76 @@ func TestHandleFsLinkUsesEncryptedPathAndWrapsRawURL(t *testing.T) {
 	}
 }
 
+func TestHandleFsLinkAndOtherWrapURLField(t *testing.T) {
+	passwd := &config.PasswdInfo{
+		Password:  "testpass",
+		EncType:   "aesctr",
+		Enable:    true,
+		EncName:   true,
+		EncSuffix: ".bin",
+		EncPath:   []string{"/enc/*"},
+	}
+	converter := encryption.NewFileNameConverter(passwd.Password, passwd.EncType, passwd.EncSuffix)
+	displayPath := "/enc/demo.mp4"
+	encryptedPath := "/enc/" + converter.ToRealName("demo.mp4")
+	upstreamURL := "http://cdn.local/file"
+
+	seen := map[string]map[string]interface{}{}
+	handler, fileDAO := newTestAlistHandler(t, "http://proxy.local:80", passwd)
+	handler.httpClient = &http.Client{Transport: rtFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.Host == "cdn.local" {
+			return &http.Response{StatusCode: http.StatusNotFound, Header: http.Header{}, Body: io.NopCloser(bytes.NewReader(nil))}, nil
+		}
+		body, _ := io.ReadAll(r.Body)
+		var req map[string]interface{}
+		_ = json.Unmarshal(body, &req)
+		seen[r.URL.Path] = req
+		// Neither fs/link nor fs/other returns raw_url: the link is in "url".
+		return jsonResponse(200, map[string]interface{}{
+			"code": 200,
+			"data": map[string]interface{}{"url": upstreamURL, "header": map[string]interface{}{}},
+		}), nil
+	})}
+	fileDAO.SetEncPathMapping(displayPath, encryptedPath)
+
+	for _, tc := range []struct {
+		api    string
+		handle func(http.ResponseWriter, *http.Request)
+		extra  map[string]interface{}
+	}{
+		{"/api/fs/link", handler.HandleFsLink, nil},
+		{"/api/fs/other", handler.HandleFsOther, map[string]interface{}{"method": "video_preview"}},
+	} {
+		reqData := map[string]interface{}{"path": displayPath}
+		for k, v := range tc.extra {
+			reqData[k] = v
+		}
+		reqBody, _ := json.Marshal(reqData)
+		req := httptest.NewRequest(http.MethodPost, "http://proxy.local"+tc.api, bytes.NewReader(reqBody))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		tc.handle(rec, req)
+
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s status=%d body=%s", tc.api, rec.Code, rec.Body.String())
+		}
+		if got, _ := seen[tc.api]["path"].(string); got != encryptedPath {
+			t.Fatalf("%s upstream path=%q, want %q", tc.api, got, encryptedPath)
+		}
+		if tc.extra != nil && seen[tc.api]["method"] != "video_preview" {
+			t.Fatalf("%s dropped request fields: %v", tc.api, seen[tc.api])
+		}
+		var resp map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("decode response: %v", err)
+		}
+		data, _ := resp["data"].(map[string]interface{})
+		if got, _ := data["url"].(string); got == "" || got == upstreamURL {
+			t.Fatalf("%s url=%q, want redirect wrapper", tc.api, got)
+		}
+	}
+}
+
 func TestHandleFsGetUsesShortHotCacheForRepeatedMetadata(t *testing.T) {
 	passwd := &config.PasswdInfo{
 		Password: "testpass",
@@ -676,6 +676,70 @@ func TestResolveRemoveNameAvoidsDoubleEncryption(t *testing.T) {
 	}
 }
 
+func TestFsRemoveBuriesRemovedPath(t *testing.T) {
+	cfg := config.Get()
+	oldRetention := cfg.AlistServer.DeletedPathRetentionMinutes
+	cfg.AlistServer.DeletedPathRetentionMinutes = 10
+	defer func() { cfg.AlistServer.DeletedPathRetentionMinutes = oldRetention }()
+
+	passwd := &config.PasswdInfo{
+		Password: "testpass",
+		EncType:  "aesctr",
+		Enable:   true,
+		EncName:  true,
+		EncPath:  []string{"/encrypt/*"},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/fs/remove", func(w http.ResponseWriter, r *http.Request) {
+		writeJSONResponse(w, map[string]interface{}{"code": 200, "message": "success"})
+	})
+	srv := newSocketTestServer(t, mux)
+	defer srv.Close()
+
+	handler, fileDAO := newTestAlistHandler(t, srv.URL, passwd)
+	fileDAO.SetEncPathMappingWithInfo("/encrypt/season/a.mp4", "/encrypt/season/enc-a.bin", "a.mp4", 1<<20, false)
+	fileDAO.SetFileSize("/encrypt/season/a.mp4", 1<<20, time.Hour)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/fs/remove", strings.NewReader(`{"dir":"/encrypt","names":["season"]}`))
+	rec := httptest.NewRecorder()
+	handler.HandleFsRemove(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body=%s", rec.Code, rec.Body.String())
+	}
+
+	// A listing that raced the remove must not bring the file back.
+	fileDAO.SetEncPathMapping("/encrypt/season/a.mp4", "/encrypt/season/enc-a.bin")
+	if _, ok := fileDAO.GetEncPath("/encrypt/season/a.mp4"); ok {
+		t.Fatal("mapping below the removed folder was cached again")
+	}
+	if _, ok := fileDAO.GetFileSize("/encrypt/season/a.mp4"); ok {
+		t.Fatal("size below the removed folder survived")
+	}
+}
+
+func TestRealFsFilePathKeepsCachedDirectoryName(t *testing.T) {
+	passwd := &config.PasswdInfo{
+		Password: "testpass",
+		EncType:  "aesctr",
+		Enable:   true,
+		EncName:  true,
+		EncPath:  []string{"/encrypt/*"},
+	}
+	handler, fileDAO := newTestAlistHandler(t, "http://127.0.0.1:1", passwd)
+	if err := fileDAO.Set(&dao.FileInfo{Path: "/encrypt/season 1", Name: "season 1", IsDir: true}); err != nil {
+		t.Fatalf("cache dir: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/fs/get", nil)
+	// The cache is keyed by the clean display path, however the client
+	// spelled it.
+	for _, p := range []string{"/encrypt/season 1", "/encrypt//season 1/"} {
+		if got := handler.realFsFilePath(req, p, passwd); got != p {
+			t.Fatalf("realFsFilePath(%q) = %q, want the directory name kept", p, got)
+		}
+	}
+}
+
 func TestResolveRemoveNameStripsOriginalPrefix(t *testing.T) {
 	passwd := &config.PasswdInfo{
 		Password:  "testpass",
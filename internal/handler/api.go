@@ -2,6 +2,8 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
 	"io/fs"
 	"net/http"
 	"os"
@@ -11,13 +13,19 @@ import (
 	"strings"
 	"time"
 
+	"github.com/rs/zerolog/log"
+
 	"github.com/alist-encrypt-go/internal/appservice"
 	"github.com/alist-encrypt-go/internal/auth"
 	"github.com/alist-encrypt-go/internal/config"
 	"github.com/alist-encrypt-go/internal/dao"
+	apperrors "github.com/alist-encrypt-go/internal/errors"
+	"github.com/alist-encrypt-go/internal/pathutil"
 	"github.com/alist-encrypt-go/internal/proxydict"
 	"github.com/alist-encrypt-go/internal/restart"
 	"github.com/alist-encrypt-go/internal/storage/mysqlstore"
+	"github.com/alist-encrypt-go/internal/trace"
+	"github.com/alist-encrypt-go/internal/update"
 )
 
 // APIHandler handles /enc-api/* routes
@@ -29,6 +37,9 @@ type APIHandler struct {
 	mysqlStore *mysqlstore.Store
 	dictMgr    *proxydict.Manager
 	svc        *appservice.Service
+	updates    *update.Checker
+	audit      *AuditLog
+	oidc       oidcFlow
 }
 
 var deprecatedRangeCompatTTLWarned uint32
@@ -68,7 +79,11 @@ func (h *APIHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userInfo, token, err := h.svc.Login(req.Username, req.Password)
+	userInfo, token, err := h.svc.Login(req.Username, req.Password, clientInfo(r))
+	if errors.Is(err, dao.ErrPasswordExpired) {
+		RespondAPIError(w, 500, "password expired, ask an administrator to reset it")
+		return
+	}
 	if err != nil {
 		// Match Node.js error message exactly: "passwword error" (note the typo in original)
 		RespondAPIError(w, 500, "passwword error")
@@ -82,11 +97,147 @@ func (h *APIHandler) Login(w http.ResponseWriter, r *http.Request) {
 
 // GetUserInfo returns current user info
 func (h *APIHandler) GetUserInfo(w http.ResponseWriter, r *http.Request) {
-	// Get actual username from database
-	data, _ := h.svc.UserInfo()
+	data, _ := h.svc.UserInfo(trace.GetLoginUser(r.Context()))
 	RespondSuccess(w, data)
 }
 
+// SetUpdateChecker enables update status reporting and /enc-api/applyUpdate.
+func (h *APIHandler) SetUpdateChecker(c *update.Checker) {
+	h.updates = c
+	h.svc.SetUpdateChecker(c)
+}
+
+// ApplyUpdate downloads the latest release binary, swaps it in and restarts
+// the process into it. Requires update.allow_apply.
+func (h *APIHandler) ApplyUpdate(w http.ResponseWriter, r *http.Request) {
+	if h.updates == nil {
+		RespondAPIError(w, 400, "update checker is disabled")
+		return
+	}
+	status, err := h.updates.Apply(r.Context())
+	if err != nil {
+		if errors.Is(err, update.ErrApplyDisabled) || errors.Is(err, update.ErrNoUpdate) {
+			RespondAPIError(w, 400, err.Error())
+			return
+		}
+		log.Error().Err(err).Msg("Binary update failed")
+		RespondAPIError(w, 500, err.Error())
+		return
+	}
+	h.audit.RecordRequest(r, "system.update", status.Latest, "")
+	log.Info().Str("from", status.Current).Str("to", status.Latest).Msg("Binary updated, restarting")
+	RespondSuccess(w, map[string]interface{}{
+		"message": "update applied, restarting",
+		"status":  status,
+	})
+	go func() {
+		time.Sleep(100 * time.Millisecond) // Let response complete
+		restart.TriggerExec()
+	}()
+}
+
+// SetAuditLog records configuration and account changes in audit.
+func (h *APIHandler) SetAuditLog(audit *AuditLog) {
+	h.audit = audit
+}
+
+// SetPreferencesDAO enables /enc-api/preferences.
+func (h *APIHandler) SetPreferencesDAO(d *dao.PreferencesDAO) {
+	h.svc.SetPreferencesDAO(d)
+}
+
+// SetTokenDAO enables /enc-api/logout and revoking tokens when credentials
+// change.
+func (h *APIHandler) SetTokenDAO(d *dao.TokenDAO) {
+	h.svc.SetTokenDAO(d)
+}
+
+// Logout revokes the token the request was made with. With {"all": true} it
+// revokes every token of the user, logging out all sessions.
+func (h *APIHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		All bool `json:"all"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+			RespondAPIError(w, 400, "Invalid request: "+err.Error())
+			return
+		}
+	}
+	if err := h.svc.Logout(auth.TokenFromRequest(r), req.All); err != nil {
+		RespondAPIError(w, 500, err.Error())
+		return
+	}
+	RespondSuccessMsg(w, "operation successful")
+}
+
+// clientInfo describes the client of r for the session list.
+func clientInfo(r *http.Request) appservice.ClientInfo {
+	return appservice.ClientInfo{RemoteIP: remoteIP(r), UserAgent: r.UserAgent()}
+}
+
+// HandleSessions lists (GET) the login sessions of the current user, the
+// one the request was made with marked as current. POST {"id": ...} logs
+// out one of them.
+func (h *APIHandler) HandleSessions(w http.ResponseWriter, r *http.Request) {
+	token := auth.TokenFromRequest(r)
+	if r.Method == http.MethodGet {
+		sessions, err := h.svc.Sessions(token)
+		if err != nil {
+			RespondAPIError(w, 500, err.Error())
+			return
+		}
+		RespondSuccess(w, map[string]interface{}{"sessions": sessions})
+		return
+	}
+	var req struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.ID == "" {
+		RespondAPIError(w, 400, "Invalid request: id is required")
+		return
+	}
+	if err := h.svc.EndSession(token, req.ID); err != nil {
+		RespondAPIError(w, 500, err.Error())
+		return
+	}
+	RespondSuccessMsg(w, "operation successful")
+}
+
+// HandlePreferences reads (GET) or replaces (POST/PUT) the management UI
+// preferences of the logged-in user. The body is stored as an opaque JSON
+// object, so the UI decides what it keeps there.
+func (h *APIHandler) HandlePreferences(w http.ResponseWriter, r *http.Request) {
+	username := trace.GetLoginUser(r.Context())
+	switch r.Method {
+	case http.MethodGet:
+		prefs, err := h.svc.GetPreferences(username)
+		if err != nil {
+			RespondAPIError(w, 500, err.Error())
+			return
+		}
+		RespondSuccess(w, prefs)
+	case http.MethodPost, http.MethodPut:
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 2*dao.MaxPreferencesBytes))
+		if err != nil {
+			RespondAPIError(w, 400, dao.ErrPreferencesTooLarge.Error())
+			return
+		}
+		if err := h.svc.SavePreferences(username, body); err != nil {
+			if errors.Is(err, dao.ErrPreferencesInvalid) || errors.Is(err, dao.ErrPreferencesTooLarge) {
+				RespondAPIError(w, 400, err.Error())
+				return
+			}
+			RespondAPIError(w, 500, err.Error())
+			return
+		}
+		RespondSuccessMsg(w, "preferences saved")
+	default:
+		w.Header().Set("Allow", "GET, POST, PUT")
+		RespondAPIError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
 // GetBuildInfo returns lightweight capability metadata for platform-specific clients.
 func (h *APIHandler) GetBuildInfo(w http.ResponseWriter, r *http.Request) {
 	RespondSuccess(w, h.svc.BuildInfo())
@@ -104,8 +255,13 @@ func (h *APIHandler) UpdatePasswd(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	caller := trace.GetLoginUser(r.Context())
+	if !h.mayManageAccount(caller, req.Username) {
+		RespondAPIError(w, 403, "permission denied")
+		return
+	}
 	// Check minimum password length (original uses 7, message says 8)
-	if err := h.svc.UpdatePassword(req.Username, req.Password, req.NewPassword); err != nil {
+	if err := h.svc.UpdatePassword(caller, req.Username, req.Password, req.NewPassword); err != nil {
 		if strings.Contains(err.Error(), "too short") {
 			RespondAPIError(w, 500, err.Error())
 			return
@@ -113,9 +269,79 @@ func (h *APIHandler) UpdatePasswd(w http.ResponseWriter, r *http.Request) {
 		RespondAPIError(w, 500, "password error")
 		return
 	}
+	h.audit.RecordRequest(r, "user.password", req.Username, "")
 	RespondSuccessMsg(w, "update success")
 }
 
+// ExpireLegacyPasswords expires the accounts whose password is still stored
+// as a legacy SHA256 hash. The logged-in account is left alone; expired
+// accounts are reset through /enc-api/updatePasswd.
+func (h *APIHandler) ExpireLegacyPasswords(w http.ResponseWriter, r *http.Request) {
+	data, err := h.svc.ExpireLegacyPasswords(trace.GetLoginUser(r.Context()))
+	if err != nil {
+		RespondAPIError(w, 500, err.Error())
+		return
+	}
+	if expired, _ := data["expired"].([]string); len(expired) > 0 {
+		log.Warn().Strs("usernames", expired).Msg("Expired accounts with legacy password hashes")
+		for _, username := range expired {
+			h.audit.RecordRequest(r, "user.expire", username, "")
+		}
+	}
+	RespondSuccess(w, data)
+}
+
+// mayManageAccount reports whether caller may change username's credentials:
+// administrators may change any account, operators only their own. Requests
+// without a login user (tests, internal callers) are not restricted.
+func (h *APIHandler) mayManageAccount(caller, username string) bool {
+	if caller == "" || caller == username {
+		return true
+	}
+	return h.svc.UserRole(caller) == dao.RoleAdmin
+}
+
+// CreateUser adds a management account. role is "admin" or "operator"
+// (default).
+func (h *APIHandler) CreateUser(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+		Role     string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		RespondAPIError(w, 500, "Invalid request")
+		return
+	}
+	if req.Role == "" {
+		req.Role = dao.RoleOperator
+	}
+	if err := h.svc.CreateUser(req.Username, req.Password, req.Role); err != nil {
+		RespondAPIError(w, 400, err.Error())
+		return
+	}
+	h.audit.RecordRequest(r, "user.create", req.Username, req.Role)
+	RespondSuccessMsg(w, "operation successful")
+}
+
+// SetUserRole changes the role of another management account.
+func (h *APIHandler) SetUserRole(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Username string `json:"username"`
+		Role     string `json:"role"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		RespondAPIError(w, 500, "Invalid request")
+		return
+	}
+	if err := h.svc.SetUserRole(trace.GetLoginUser(r.Context()), req.Username, req.Role); err != nil {
+		RespondAPIError(w, 400, err.Error())
+		return
+	}
+	h.audit.RecordRequest(r, "user.role", req.Username, req.Role)
+	RespondSuccessMsg(w, "operation successful")
+}
+
 // UpdateUsername updates user username
 func (h *APIHandler) UpdateUsername(w http.ResponseWriter, r *http.Request) {
 	var req struct {
@@ -128,6 +354,10 @@ func (h *APIHandler) UpdateUsername(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !h.mayManageAccount(trace.GetLoginUser(r.Context()), req.Username) {
+		RespondAPIError(w, 403, "permission denied")
+		return
+	}
 	if err := h.svc.UpdateUsername(req.Username, req.Password, req.NewUsername); err != nil {
 		if strings.Contains(err.Error(), "too short") {
 			RespondAPIError(w, 500, err.Error())
@@ -136,6 +366,7 @@ func (h *APIHandler) UpdateUsername(w http.ResponseWriter, r *http.Request) {
 		RespondAPIError(w, 500, "password error")
 		return
 	}
+	h.audit.RecordRequest(r, "user.rename", req.Username, req.NewUsername)
 	RespondSuccessMsg(w, "update success")
 }
 
@@ -151,7 +382,7 @@ func (h *APIHandler) SaveAlistConfig(w http.ResponseWriter, r *http.Request) {
 		RespondAPIError(w, 500, "Invalid request: "+err.Error())
 		return
 	}
-	if err := h.svc.SaveAlistConfig(raw); err != nil {
+	if err := h.svc.SaveAlistConfig(raw, h.svc.UserRole(trace.GetLoginUser(r.Context()))); err != nil {
 		if strings.Contains(err.Error(), "deprecated") {
 			RespondAPIError(w, 500, err.Error())
 			return
@@ -159,6 +390,7 @@ func (h *APIHandler) SaveAlistConfig(w http.ResponseWriter, r *http.Request) {
 		RespondAPIError(w, 500, err.Error())
 		return
 	}
+	h.audit.RecordRequest(r, "config.alist", "", "")
 	RespondSuccessMsg(w, "save ok")
 }
 
@@ -216,6 +448,8 @@ func (h *APIHandler) SaveWebdavConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	name, _ := raw["name"].(string)
+	h.audit.RecordRequest(r, "config.webdav.add", name, "")
 	RespondSuccess(w, h.svc.GetWebdavConfig())
 }
 
@@ -227,11 +461,13 @@ func (h *APIHandler) UpdateWebdavConfig(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if err := h.svc.UpdateWebdavConfig(raw); err != nil {
+	if err := h.svc.UpdateWebdavConfig(raw, h.svc.UserRole(trace.GetLoginUser(r.Context()))); err != nil {
 		RespondAPIError(w, 500, err.Error())
 		return
 	}
 
+	id, _ := raw["id"].(string)
+	h.audit.RecordRequest(r, "config.webdav.update", id, "")
 	RespondSuccess(w, h.svc.GetWebdavConfig())
 }
 
@@ -250,6 +486,7 @@ func (h *APIHandler) DelWebdavConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	h.audit.RecordRequest(r, "config.webdav.delete", req.ID, "")
 	RespondSuccess(w, h.svc.GetWebdavConfig())
 }
 
@@ -283,13 +520,29 @@ func (h *APIHandler) DecodeFoldName(w http.ResponseWriter, r *http.Request) {
 
 	data, err := h.svc.DecodeFolderName(req.Password, req.EncType, req.FolderNameEnc)
 	if err != nil {
-		RespondAPIError(w, 500, "folderName is error")
+		RespondAPIErrorCode(w, 500, apperrors.CodeNameDecodeFailed, "folderName is error")
 		return
 	}
 
 	RespondSuccess(w, data)
 }
 
+// Identify reports which file name dialects stored names decode with, for
+// picking nameDialect when taking over folders written by another fork.
+func (h *APIHandler) Identify(w http.ResponseWriter, r *http.Request) {
+	var req appservice.IdentifyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		RespondAPIError(w, 400, "Invalid request: "+err.Error())
+		return
+	}
+	data, err := h.svc.IdentifyNames(req)
+	if err != nil {
+		RespondAPIError(w, 400, err.Error())
+		return
+	}
+	RespondSuccess(w, data)
+}
+
 // GetSchemeConfig returns server scheme configuration
 func (h *APIHandler) GetSchemeConfig(w http.ResponseWriter, r *http.Request) {
 	RespondSuccess(w, h.svc.GetSchemeConfig())
@@ -310,20 +563,52 @@ func (h *APIHandler) SaveSchemeConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// ?restart=false only saves; the caller applies the change later
+	// through /enc-api/restart, e.g. after saving several settings.
+	applyNow := needRestart && r.URL.Query().Get("restart") != "false"
+	h.audit.RecordRequest(r, "config.scheme", "", "")
 	RespondSuccess(w, map[string]interface{}{
 		"message":     "save ok",
 		"needRestart": needRestart,
+		"restarting":  applyNow,
 	})
 
 	// Trigger restart asynchronously if needed
-	if needRestart {
-		go func() {
-			time.Sleep(100 * time.Millisecond) // Let response complete
-			restart.Trigger()
-		}()
+	if applyNow {
+		restartSoon()
 	}
 }
 
+// Restart restarts the server in-process, applying listener, certificate
+// and H2C changes saved with ?restart=false. The config file is checked
+// first; with errors the server keeps running and the errors are returned.
+func (h *APIHandler) Restart(w http.ResponseWriter, r *http.Request) {
+	if !restart.Available() {
+		RespondAPIError(w, 400, "restart is not available in this mode")
+		return
+	}
+	if errs := config.FileErrors(h.cfg.Path()); len(errs) > 0 {
+		msgs := make([]string, len(errs))
+		for i, issue := range errs {
+			msgs[i] = issue.String()
+		}
+		RespondAPIError(w, 400, "config has errors, not restarting: "+strings.Join(msgs, "; "))
+		return
+	}
+	h.audit.RecordRequest(r, "system.restart", "", "")
+	log.Info().Msg("Restart requested via API")
+	RespondSuccess(w, map[string]interface{}{"message": "restarting"})
+	restartSoon()
+}
+
+// restartSoon triggers a restart once the current response has gone out.
+func restartSoon() {
+	go func() {
+		time.Sleep(100 * time.Millisecond) // Let response complete
+		restart.Trigger()
+	}()
+}
+
 // ExportFileMeta exports file metadata from MySQL for external sync
 func (h *APIHandler) ExportFileMeta(w http.ResponseWriter, r *http.Request) {
 	if h.mysqlStore == nil {
@@ -572,6 +857,7 @@ func (h *APIHandler) CleanupLegacyBoltDB(w http.ResponseWriter, r *http.Request)
 		RespondAPIError(w, 500, err.Error())
 		return
 	}
+	h.audit.RecordRequest(r, "system.cleanup_legacy_boltdb", "", "")
 	RespondSuccessMsg(w, msg)
 }
 
@@ -591,9 +877,89 @@ func (h *APIHandler) SaveProxyRoutingConfig(w http.ResponseWriter, r *http.Reque
 		RespondAPIError(w, 500, err.Error())
 		return
 	}
+	h.audit.RecordRequest(r, "config.proxy", "", "")
+	RespondSuccessMsg(w, "save ok")
+}
+
+// GetRequestRules returns request_rules.
+func (h *APIHandler) GetRequestRules(w http.ResponseWriter, r *http.Request) {
+	RespondSuccess(w, h.svc.GetRequestRules())
+}
+
+// SaveRequestRules replaces request_rules. A rule that does not compile
+// rejects the whole list.
+func (h *APIHandler) SaveRequestRules(w http.ResponseWriter, r *http.Request) {
+	var rules []config.RequestRule
+	if err := json.NewDecoder(r.Body).Decode(&rules); err != nil {
+		RespondAPIError(w, 400, "Invalid request: "+err.Error())
+		return
+	}
+	if err := h.svc.SaveRequestRules(rules); err != nil {
+		RespondAPIError(w, 400, "Invalid request: "+err.Error())
+		return
+	}
+	h.audit.RecordRequest(r, "config.request_rules", "", "")
 	RespondSuccessMsg(w, "save ok")
 }
 
+// TestRequestRules reports which request rule a described request would
+// match, against the saved rules or the unsaved ones given in rules.
+func (h *APIHandler) TestRequestRules(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Method      string                `json:"method"`
+		Path        string                `json:"path"`
+		DisplayPath string                `json:"display_path"`
+		Headers     map[string]string     `json:"headers"`
+		ClientIP    string                `json:"client_ip"`
+		Rules       *[]config.RequestRule `json:"rules"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		RespondAPIError(w, 400, "Invalid request: "+err.Error())
+		return
+	}
+	rules := h.svc.GetRequestRules()
+	if req.Rules != nil {
+		rules = *req.Rules
+	}
+	set, errs := config.CompileRequestRules(rules)
+	in := config.RequestRuleInput{
+		Method:      req.Method,
+		URLPath:     req.Path,
+		DisplayPath: req.DisplayPath,
+		Header:      http.Header{},
+		ClientIP:    req.ClientIP,
+	}
+	if in.Method == "" {
+		in.Method = http.MethodGet
+	}
+	if in.DisplayPath == "" {
+		if p, ok := pathutil.StripRoute(req.Path); ok {
+			in.DisplayPath = p.String()
+		}
+	}
+	for name, value := range req.Headers {
+		in.Header.Set(name, value)
+	}
+	errMsgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		errMsgs = append(errMsgs, err.Error())
+	}
+	data := map[string]interface{}{
+		"matched": false,
+		"errors":  errMsgs,
+	}
+	if rule, index, ok := set.Match(in); ok {
+		data["matched"] = true
+		data["index"] = index
+		data["rule"] = rule
+		if rule.Action == config.RuleActionEncrypt {
+			_, found := h.cfg.RequestRulePasswd(rule.Passwd)
+			data["passwd_found"] = found
+		}
+	}
+	RespondSuccess(w, data)
+}
+
 // HandleCheckFilePath validates a local file path exists and counts files.
 func HandleCheckFilePath(w http.ResponseWriter, r *http.Request) {
 	var req struct {
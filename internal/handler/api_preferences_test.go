@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/dao"
+	"github.com/alist-encrypt-go/internal/storage"
+	"github.com/alist-encrypt-go/internal/trace"
+)
+
+func TestHandlePreferencesPerLoginUser(t *testing.T) {
+	store, err := storage.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	h := NewAPIHandler(config.DefaultConfig(), dao.NewUserDAO(store), nil, nil)
+	h.SetPreferencesDAO(dao.NewPreferencesDAO(store))
+
+	call := func(method, user, body string) (int, json.RawMessage) {
+		req := httptest.NewRequest(method, "/enc-api/preferences", strings.NewReader(body))
+		req = req.WithContext(trace.WithLoginUser(req.Context(), user))
+		rr := httptest.NewRecorder()
+		h.HandlePreferences(rr, req)
+		var resp struct {
+			Code int             `json:"code"`
+			Data json.RawMessage `json:"data"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("unmarshal %s: %v", rr.Body.String(), err)
+		}
+		return resp.Code, resp.Data
+	}
+
+	if code, _ := call(http.MethodPost, "admin", `{"locale":"en","defaultPath":"/media"}`); code != 0 {
+		t.Fatalf("save code=%d", code)
+	}
+	if code, data := call(http.MethodGet, "admin", ""); code != 0 || string(data) != `{"locale":"en","defaultPath":"/media"}` {
+		t.Fatalf("admin prefs code=%d data=%s", code, data)
+	}
+	if _, data := call(http.MethodGet, "other", ""); string(data) != `{}` {
+		t.Fatalf("other user sees %s", data)
+	}
+	if code, _ := call(http.MethodPut, "admin", `["not","an","object"]`); code != 400 {
+		t.Fatalf("invalid body code=%d, want 400", code)
+	}
+}
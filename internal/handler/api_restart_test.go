@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/restart"
+)
+
+func callRestart(t *testing.T, h *APIHandler) int {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	h.Restart(rr, httptest.NewRequest(http.MethodPost, "/enc-api/restart", nil))
+	var resp struct {
+		Code int `json:"code"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("unmarshal response: %v", err)
+	}
+	return resp.Code
+}
+
+func TestRestartChecksConfigFirst(t *testing.T) {
+	cfg := config.LoadFromBaseDir(t.TempDir())
+	if err := cfg.Save(); err != nil {
+		t.Fatal(err)
+	}
+	h := NewAPIHandler(cfg, nil, nil, nil)
+
+	if code := callRestart(t, h); code != 400 {
+		t.Fatalf("code=%d without a restart loop, want 400", code)
+	}
+
+	ch := make(chan struct{})
+	restart.SetChan(ch)
+	defer restart.SetChan(nil)
+
+	// A broken file would restart the server into defaults.
+	valid, err := os.ReadFile(cfg.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(cfg.Path(), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if code := callRestart(t, h); code != 400 {
+		t.Fatalf("code=%d with a broken config, want 400", code)
+	}
+	select {
+	case <-ch:
+		t.Fatal("restarted with a broken config")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	if err := os.WriteFile(cfg.Path(), valid, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if code := callRestart(t, h); code != 0 {
+		t.Fatalf("code=%d, want 0", code)
+	}
+	select {
+	case <-ch:
+	case <-time.After(2 * time.Second):
+		t.Fatal("restart not triggered")
+	}
+}
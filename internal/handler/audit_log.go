@@ -0,0 +1,275 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/storage"
+	"github.com/alist-encrypt-go/internal/trace"
+)
+
+const (
+	auditFlushInterval = 5 * time.Second
+	auditRetainDays    = 90
+	auditKeyLayout     = "20060102T150405.000000000Z"
+	auditMaxQuery      = 1000
+)
+
+// AuditEntry is one mutating action. Action is "<area>.<verb>", e.g.
+// "config.alist", "user.password", "webdav.delete" or "fs.put"; Path is the
+// display path (or config id) acted on and Target the destination of a move
+// or copy. Status is the HTTP status the proxy answered with, where known.
+type AuditEntry struct {
+	Time     time.Time `json:"time"`
+	Actor    string    `json:"actor"`
+	Action   string    `json:"action"`
+	Path     string    `json:"path,omitempty"`
+	Target   string    `json:"target,omitempty"`
+	Status   int       `json:"status,omitempty"`
+	RemoteIP string    `json:"remote_ip,omitempty"`
+	// RequestID links the entry to the request's trace (/enc-api/trace/<id>).
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// AuditFilter narrows Query. Empty fields match everything; Action and Path
+// match by prefix.
+type AuditFilter struct {
+	Actor     string
+	Action    string
+	Path      string
+	RequestID string
+	Since     time.Time
+	Until     time.Time
+	Limit     int
+}
+
+// AuditLog keeps the audit trail in its own BoltDB bucket, one record per
+// action keyed by UTC time so the bucket is in chronological order. Entries
+// are queued in memory and written every auditFlushInterval, so requests never
+// wait on a database write; Query flushes first.
+type AuditLog struct {
+	store *storage.Store
+
+	mu      sync.Mutex
+	pending []AuditEntry
+	seq     uint32
+}
+
+// NewAuditLog creates an audit log persisting into store.
+func NewAuditLog(store *storage.Store) *AuditLog {
+	if store == nil {
+		return nil
+	}
+	return &AuditLog{store: store}
+}
+
+// Record queues entry, stamping the time if it is unset.
+func (a *AuditLog) Record(entry AuditEntry) {
+	if a == nil || entry.Action == "" {
+		return
+	}
+	if entry.Time.IsZero() {
+		entry.Time = time.Now()
+	}
+	if entry.Actor == "" {
+		entry.Actor = "anonymous"
+	}
+	a.mu.Lock()
+	a.pending = append(a.pending, entry)
+	a.mu.Unlock()
+}
+
+// RecordRequest records a management action by the user logged in on r.
+func (a *AuditLog) RecordRequest(r *http.Request, action, path, target string) {
+	if a == nil {
+		return
+	}
+	a.Record(AuditEntry{
+		Actor:     AuditActor(r),
+		Action:    action,
+		Path:      path,
+		Target:    target,
+		RemoteIP:  remoteIP(r),
+		RequestID: trace.GetRequestID(r.Context()),
+	})
+}
+
+// Start flushes queued entries periodically until ctx is cancelled, then
+// flushes once more.
+func (a *AuditLog) Start(ctx context.Context) {
+	if a == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(auditFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				a.Flush()
+				return
+			case <-ticker.C:
+				a.Flush()
+			}
+		}
+	}()
+}
+
+// Flush writes queued entries and prunes those older than auditRetainDays.
+func (a *AuditLog) Flush() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	pending := a.pending
+	a.pending = nil
+	a.mu.Unlock()
+	if len(pending) == 0 {
+		return
+	}
+
+	err := a.store.UpdateBucket(storage.BucketAudit, func(tx *storage.BucketTx) error {
+		for _, entry := range pending {
+			a.seq++
+			key := fmt.Sprintf("%s-%08d", entry.Time.UTC().Format(auditKeyLayout), a.seq)
+			if err := tx.SetJSON(key, entry); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Warn().Err(err).Int("entries", len(pending)).Msg("Failed to persist audit log")
+		return
+	}
+	cutoff := time.Now().AddDate(0, 0, -auditRetainDays).UTC().Format(auditKeyLayout)
+	if _, err := a.store.DeleteBefore(storage.BucketAudit, cutoff); err != nil {
+		log.Warn().Err(err).Msg("Failed to prune audit log")
+	}
+}
+
+// Query returns the newest entries matching f, newest first.
+func (a *AuditLog) Query(f AuditFilter) ([]AuditEntry, error) {
+	a.Flush()
+	limit := f.Limit
+	if limit <= 0 || limit > auditMaxQuery {
+		limit = auditMaxQuery
+	}
+	var sinceKey, untilKey string
+	if !f.Since.IsZero() {
+		sinceKey = f.Since.UTC().Format(auditKeyLayout)
+	}
+	if !f.Until.IsZero() {
+		untilKey = f.Until.UTC().Format(auditKeyLayout)
+	}
+	out := []AuditEntry{}
+	err := a.store.ForEachReverse(storage.BucketAudit, func(key string, value []byte) bool {
+		if sinceKey != "" && key < sinceKey {
+			return false
+		}
+		if untilKey != "" && key > untilKey {
+			return true
+		}
+		var entry AuditEntry
+		if err := json.Unmarshal(value, &entry); err != nil {
+			return true
+		}
+		if f.Actor != "" && entry.Actor != f.Actor {
+			return true
+		}
+		if f.Action != "" && !strings.HasPrefix(entry.Action, f.Action) {
+			return true
+		}
+		if f.Path != "" && !strings.HasPrefix(entry.Path, f.Path) && !strings.HasPrefix(entry.Target, f.Path) {
+			return true
+		}
+		if f.RequestID != "" && entry.RequestID != f.RequestID {
+			return true
+		}
+		out = append(out, entry)
+		return len(out) < limit
+	})
+	return out, err
+}
+
+// HandleAudit serves /enc-api/audit?actor=&action=&path=&request_id=&since=&until=&limit=.
+// since and until are RFC 3339 times; action and path match by prefix.
+func (a *AuditLog) HandleAudit(w http.ResponseWriter, r *http.Request) {
+	if a == nil {
+		RespondAPIError(w, 503, "audit log unavailable")
+		return
+	}
+	q := r.URL.Query()
+	f := AuditFilter{
+		Actor:     q.Get("actor"),
+		Action:    q.Get("action"),
+		Path:      q.Get("path"),
+		RequestID: q.Get("request_id"),
+	}
+	for name, dst := range map[string]*time.Time{"since": &f.Since, "until": &f.Until} {
+		raw := q.Get(name)
+		if raw == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			RespondAPIError(w, 400, "Invalid request: "+name+" must be an RFC 3339 time")
+			return
+		}
+		*dst = t
+	}
+	f.Limit, _ = strconv.Atoi(q.Get("limit"))
+	if f.Limit <= 0 {
+		f.Limit = 100
+	}
+	f.Limit = clampInt(f.Limit, 1, auditMaxQuery)
+
+	entries, err := a.Query(f)
+	if err != nil {
+		RespondAPIError(w, 500, err.Error())
+		return
+	}
+	RespondSuccess(w, map[string]interface{}{
+		"items": entries,
+	})
+}
+
+// AuditActor names who made r: the management login, then the user from
+// forwardedUserHeader, then the Basic auth user. Requests authenticated only
+// by an Alist token are attributed to "token:" and a short hash of it, which
+// identifies the session without storing the credential.
+func AuditActor(r *http.Request) string {
+	if user := trace.GetLoginUser(r.Context()); user != "" {
+		return user
+	}
+	if user := trace.GetUser(r.Context()); user != "" {
+		return user
+	}
+	if user, _, ok := r.BasicAuth(); ok && user != "" {
+		return user
+	}
+	if token := strings.TrimSpace(r.Header.Get("Authorization")); token != "" {
+		sum := sha256.Sum256([]byte(token))
+		return "token:" + hex.EncodeToString(sum[:4])
+	}
+	return "anonymous"
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,126 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/storage"
+	"github.com/alist-encrypt-go/internal/trace"
+)
+
+func newTestAuditLog(t *testing.T) (*AuditLog, *storage.Store) {
+	t.Helper()
+	store, err := storage.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return NewAuditLog(store), store
+}
+
+func TestAuditLogQueryFiltersNewestFirst(t *testing.T) {
+	audit, _ := newTestAuditLog(t)
+	base := time.Now().Add(-time.Hour)
+	audit.Record(AuditEntry{Time: base, Actor: "admin", Action: "config.alist"})
+	audit.Record(AuditEntry{Time: base.Add(time.Minute), Actor: "alice", Action: "webdav.delete", Path: "/movies/a.mkv", Status: 204})
+	audit.Record(AuditEntry{Time: base.Add(2 * time.Minute), Actor: "alice", Action: "webdav.move", Path: "/movies/b.mkv", Target: "/archive/b.mkv", Status: 201})
+	audit.Record(AuditEntry{Time: base.Add(3 * time.Minute), Actor: "bob", Action: "fs.put", Path: "/tv/c.mp4", Status: 200})
+
+	all, err := audit.Query(AuditFilter{})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(all) != 4 || all[0].Action != "fs.put" || all[3].Action != "config.alist" {
+		t.Fatalf("all=%+v, want newest first", all)
+	}
+
+	cases := []struct {
+		filter AuditFilter
+		want   []string
+	}{
+		{AuditFilter{Actor: "alice"}, []string{"webdav.move", "webdav.delete"}},
+		{AuditFilter{Action: "webdav."}, []string{"webdav.move", "webdav.delete"}},
+		{AuditFilter{Path: "/archive"}, []string{"webdav.move"}},
+		{AuditFilter{Since: base.Add(90 * time.Second)}, []string{"fs.put", "webdav.move"}},
+		{AuditFilter{Until: base.Add(90 * time.Second)}, []string{"webdav.delete", "config.alist"}},
+		{AuditFilter{Limit: 1}, []string{"fs.put"}},
+	}
+	for _, tc := range cases {
+		got, err := audit.Query(tc.filter)
+		if err != nil {
+			t.Fatalf("Query(%+v): %v", tc.filter, err)
+		}
+		var actions []string
+		for _, e := range got {
+			actions = append(actions, e.Action)
+		}
+		if len(actions) != len(tc.want) {
+			t.Fatalf("Query(%+v)=%v, want %v", tc.filter, actions, tc.want)
+		}
+		for i := range actions {
+			if actions[i] != tc.want[i] {
+				t.Fatalf("Query(%+v)=%v, want %v", tc.filter, actions, tc.want)
+			}
+		}
+	}
+}
+
+func TestAuditLogPrunesOldEntries(t *testing.T) {
+	audit, store := newTestAuditLog(t)
+	audit.Record(AuditEntry{Time: time.Now().AddDate(0, 0, -auditRetainDays-1), Actor: "admin", Action: "config.scheme"})
+	audit.Record(AuditEntry{Actor: "admin", Action: "config.proxy"})
+	audit.Flush()
+
+	keys, err := store.ListKeys(storage.BucketAudit)
+	if err != nil {
+		t.Fatalf("ListKeys: %v", err)
+	}
+	if len(keys) != 1 {
+		t.Fatalf("keys=%v, want only the recent entry", keys)
+	}
+}
+
+func TestAuditActorAndHandler(t *testing.T) {
+	audit, _ := newTestAuditLog(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/enc-api/saveAlistConfig", nil)
+	req = req.WithContext(trace.WithLoginUser(req.Context(), "admin"))
+	audit.RecordRequest(req, "config.alist", "", "")
+
+	dav := httptest.NewRequest("DELETE", "/dav/movies/a.mkv", nil)
+	dav.SetBasicAuth("alice", "secret")
+	if got := AuditActor(dav); got != "alice" {
+		t.Fatalf("basic auth actor=%q", got)
+	}
+	api := httptest.NewRequest(http.MethodPost, "/api/fs/remove", nil)
+	api.Header.Set("Authorization", "alist-token")
+	if got := AuditActor(api); len(got) != len("token:")+8 || got[:6] != "token:" {
+		t.Fatalf("token actor=%q", got)
+	}
+
+	rr := httptest.NewRecorder()
+	audit.HandleAudit(rr, httptest.NewRequest(http.MethodGet, "/enc-api/audit?actor=admin", nil))
+	var body struct {
+		Data struct {
+			Items []AuditEntry `json:"items"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode %q: %v", rr.Body.String(), err)
+	}
+	if len(body.Data.Items) != 1 || body.Data.Items[0].Action != "config.alist" || body.Data.Items[0].RemoteIP == "" {
+		t.Fatalf("items=%+v", body.Data.Items)
+	}
+
+	rr = httptest.NewRecorder()
+	audit.HandleAudit(rr, httptest.NewRequest(http.MethodGet, "/enc-api/audit?since=yesterday", nil))
+	var bad struct {
+		Code int `json:"code"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &bad); err != nil || bad.Code != 400 {
+		t.Fatalf("invalid since: body=%q", rr.Body.String())
+	}
+}
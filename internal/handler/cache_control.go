@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/pathutil"
+)
+
+// cacheControlFor returns the Cache-Control value of the first
+// cacheControlRules entry for target that matches displayPath, or "".
+func cacheControlFor(cfg *config.Config, target, displayPath string) string {
+	if cfg == nil || len(cfg.AlistServer.CacheControlRules) == 0 || displayPath == "" {
+		return ""
+	}
+	clean := pathutil.Clean(displayPath)
+	ext := strings.ToLower(path.Ext(clean))
+	for _, rule := range cfg.AlistServer.CacheControlRules {
+		value := strings.TrimSpace(rule.CacheControl)
+		if value == "" {
+			continue
+		}
+		ruleTarget := strings.ToLower(strings.TrimSpace(rule.Target))
+		if ruleTarget != config.CacheTargetList {
+			ruleTarget = config.CacheTargetFile
+		}
+		if ruleTarget != target {
+			continue
+		}
+		if !pathutil.Within(clean, pathutil.CleanInput(rule.PathPrefix)) {
+			continue
+		}
+		if target == config.CacheTargetFile && len(rule.Extensions) > 0 && !matchesExtension(rule.Extensions, ext) {
+			continue
+		}
+		return value
+	}
+	return ""
+}
+
+func matchesExtension(exts []string, ext string) bool {
+	if ext == "" {
+		return false
+	}
+	for _, e := range exts {
+		if strings.TrimPrefix(strings.ToLower(strings.TrimSpace(e)), ".") == ext[1:] {
+			return true
+		}
+	}
+	return false
+}
+
+// withCacheControl wraps w so value replaces any upstream Cache-Control on
+// 2xx and 304 responses. Errors keep whatever the upstream sent, so a
+// transient failure is never cached for a year. An empty value returns w.
+func withCacheControl(w http.ResponseWriter, value string) http.ResponseWriter {
+	if value == "" {
+		return w
+	}
+	return &cacheControlWriter{ResponseWriter: w, value: value}
+}
+
+type cacheControlWriter struct {
+	http.ResponseWriter
+	value       string
+	wroteHeader bool
+}
+
+func (w *cacheControlWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if (status >= 200 && status < 300) || status == http.StatusNotModified {
+			w.Header().Set("Cache-Control", w.value)
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *cacheControlWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *cacheControlWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *cacheControlWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
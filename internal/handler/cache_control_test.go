@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+func TestCacheControlForMatchesFirstRule(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.AlistServer.CacheControlRules = []config.CacheControlRule{
+		{Target: "list", PathPrefix: "/", CacheControl: "no-store"},
+		{PathPrefix: "/media", Extensions: []string{"MKV", ".mp4"}, CacheControl: "public, max-age=31536000, immutable"},
+		{PathPrefix: "/", CacheControl: "private, max-age=60"},
+	}
+	cases := []struct {
+		target, path, want string
+	}{
+		{config.CacheTargetFile, "/media/a/movie.mkv", "public, max-age=31536000, immutable"},
+		{config.CacheTargetFile, "/media/a/movie.MP4", "public, max-age=31536000, immutable"},
+		{config.CacheTargetFile, "/media/a/notes.txt", "private, max-age=60"},
+		{config.CacheTargetFile, "/mediax/movie.mkv", "private, max-age=60"},
+		{config.CacheTargetList, "/media/a", "no-store"},
+	}
+	for _, tc := range cases {
+		if got := cacheControlFor(cfg, tc.target, tc.path); got != tc.want {
+			t.Errorf("cacheControlFor(%s, %s) = %q, want %q", tc.target, tc.path, got, tc.want)
+		}
+	}
+	if got := cacheControlFor(&config.Config{}, config.CacheTargetFile, "/media/a.mkv"); got != "" {
+		t.Errorf("expected no policy without rules, got %q", got)
+	}
+}
+
+func TestCacheControlWriterOnlyOverridesSuccess(t *testing.T) {
+	rec := httptest.NewRecorder()
+	w := withCacheControl(rec, "public, max-age=600")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusPartialContent)
+	if got := rec.Header().Get("Cache-Control"); got != "public, max-age=600" {
+		t.Fatalf("expected policy on 206, got %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	w = withCacheControl(rec, "public, max-age=600")
+	w.WriteHeader(http.StatusBadGateway)
+	if got := rec.Header().Get("Cache-Control"); got != "" {
+		t.Fatalf("expected no policy on 502, got %q", got)
+	}
+
+	rec = httptest.NewRecorder()
+	w = withCacheControl(rec, "no-store")
+	_, _ = w.Write([]byte("{}"))
+	if got := rec.Header().Get("Cache-Control"); got != "no-store" {
+		t.Fatalf("expected policy on implicit 200, got %q", got)
+	}
+}
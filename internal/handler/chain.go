@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/httputil"
+	"github.com/alist-encrypt-go/internal/proxy"
+)
+
+// HandlePassThrough forwards a request to Alist without any encryption
+// handling. It serves requests from a chained alist-encrypt instance that
+// already encrypts the path (proxy.OwnedHeader): decrypting or encrypting
+// here as well would apply a second layer. Upstream URLs in redirects and
+// text bodies are still rewritten so the earlier instance can follow them.
+func (h *ProxyHandler) HandlePassThrough(w http.ResponseWriter, r *http.Request) {
+	targetURL := httputil.BuildTargetURL(h.cfg.GetAlistURL(), r.URL.Path, r)
+	proxyReq, err := httputil.NewRequest(r.Method, targetURL).
+		WithContext(r.Context()).
+		WithBodyReader(r.Body).
+		CopyHeaders(r).
+		WithForwardedHeaders(r).
+		Build()
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to create pass-through request")
+		RespondHTTPErrorWithStatus(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	resp, err := h.client.Do(proxyReq)
+	if err != nil {
+		log.Error().Err(err).Str("target", targetURL).Msg("Failed to pass request through")
+		RespondHTTPErrorWithStatus(w, "Proxy error", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	if location := resp.Header.Get("Location"); location != "" {
+		httputil.CopyResponseHeaders(w, resp)
+		w.Header().Set("Location", rewriteUpstreamLocation(r, h.cfg.GetAlistURL(), location))
+		w.WriteHeader(resp.StatusCode)
+		return
+	}
+	if shouldRewriteTextResponse(resp.Header.Get("Content-Type")) {
+		body, err := readLimitedBody(resp, maxProxyResponseBody)
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to read textual pass-through response body")
+			RespondHTTPErrorWithStatus(w, "Proxy error", http.StatusBadGateway)
+			return
+		}
+		body = rewriteUpstreamTextBody(r, h.cfg.GetAlistURL(), body)
+		httputil.CopyResponseHeaders(w, resp, "Content-Length")
+		w.WriteHeader(resp.StatusCode)
+		_, _ = w.Write(body)
+		return
+	}
+
+	httputil.CopyResponseHeaders(w, resp)
+	w.WriteHeader(resp.StatusCode)
+	buf := proxy.GetBuffer()
+	defer proxy.PutBuffer(buf)
+	io.CopyBuffer(w, resp.Body, *buf)
+}
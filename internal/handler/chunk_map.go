@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/encryption"
+	"github.com/alist-encrypt-go/internal/httputil"
+)
+
+const (
+	defaultChunkMapChunkKb = 4096
+	minChunkMapChunkKb     = 64
+	maxChunkMapChunkKb     = 65536
+)
+
+// chunkMapEntry describes one fixed-size plaintext chunk and where its
+// ciphertext lives in the remote object.
+type chunkMapEntry struct {
+	Index        int    `json:"index"`
+	PlainOffset  int64  `json:"plain_offset"`
+	CipherOffset int64  `json:"cipher_offset"`
+	Size         int64  `json:"size"`
+	SHA256       string `json:"sha256,omitempty"`
+}
+
+// chunkMap is the layout returned by /enc-api/chunkMap. Because content is
+// encrypted with a seekable stream cipher, chunk i of the plaintext maps to
+// ciphertext [header_len + i*chunk_size, ...) and can be rewritten in place.
+type chunkMap struct {
+	Path         string          `json:"path"`
+	RealPath     string          `json:"real_path"`
+	EncType      string          `json:"enc_type"`
+	Version      int             `json:"version"`
+	HeaderLen    int64           `json:"header_len"`
+	HeaderSHA256 string          `json:"header_sha256,omitempty"`
+	PlainSize    int64           `json:"plain_size"`
+	CipherSize   int64           `json:"cipher_size"`
+	ChunkSize    int64           `json:"chunk_size"`
+	Chunks       []chunkMapEntry `json:"chunks"`
+}
+
+func buildChunkMap(meta encryption.ContentMeta, chunkSize int64) *chunkMap {
+	plainSize := meta.PlainSize
+	if !meta.IsV2() {
+		plainSize = meta.TotalCiphertextSize()
+	}
+	m := &chunkMap{
+		EncType:    string(meta.EncType),
+		Version:    meta.Version,
+		HeaderLen:  meta.HeaderLen,
+		PlainSize:  plainSize,
+		CipherSize: meta.TotalCiphertextSize(),
+		ChunkSize:  chunkSize,
+	}
+	for offset, i := int64(0), 0; offset < plainSize; offset, i = offset+chunkSize, i+1 {
+		size := chunkSize
+		if remaining := plainSize - offset; remaining < size {
+			size = remaining
+		}
+		m.Chunks = append(m.Chunks, chunkMapEntry{
+			Index:        i,
+			PlainOffset:  offset,
+			CipherOffset: meta.UpstreamOffset(offset),
+			Size:         size,
+		})
+	}
+	return m
+}
+
+// hashChunks fills SHA-256 digests of the ciphertext for the header and
+// every chunk by reading the whole object sequentially.
+func (m *chunkMap) hashChunks(ciphertext io.Reader) error {
+	if m.HeaderLen > 0 {
+		h := sha256.New()
+		if _, err := io.CopyN(h, ciphertext, m.HeaderLen); err != nil {
+			return fmt.Errorf("hash header: %w", err)
+		}
+		m.HeaderSHA256 = hex.EncodeToString(h.Sum(nil))
+	}
+	for i := range m.Chunks {
+		h := sha256.New()
+		if _, err := io.CopyN(h, ciphertext, m.Chunks[i].Size); err != nil {
+			return fmt.Errorf("hash chunk %d: %w", i, err)
+		}
+		m.Chunks[i].SHA256 = hex.EncodeToString(h.Sum(nil))
+	}
+	return nil
+}
+
+// HandleChunkMap returns the chunk layout of a remote encrypted file so sync
+// tools can upload only changed ranges. The Alist token is taken from the
+// X-Alist-Token header, falling back to the configured scan credentials.
+func (h *AlistHandler) HandleChunkMap(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Path    string `json:"path"`
+		ChunkKb int    `json:"chunk_kb"`
+		Hash    bool   `json:"hash"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Path) == "" {
+		RespondAPIError(w, 400, "path is required")
+		return
+	}
+	if req.ChunkKb <= 0 {
+		req.ChunkKb = defaultChunkMapChunkKb
+	}
+	req.ChunkKb = clampChunkMapKb(req.ChunkKb)
+
+	passwdInfo, found := h.passwdDAO.PathFindPasswd(req.Path)
+	if !found {
+		RespondAPIError(w, 400, "path is not under an encrypted folder")
+		return
+	}
+	allowLoose := h.cfg != nil && h.cfg.AlistServer.AllowLooseDecode
+	realPath, _ := resolveEncryptedRealPath(h.fileDAO, passwdInfo, req.Path, allowLoose)
+
+	authHeaders := make(http.Header)
+	if token := strings.TrimSpace(r.Header.Get("X-Alist-Token")); token != "" {
+		authHeaders.Set("Authorization", token)
+	}
+	var (
+		fetch    rawURLFetchResult
+		auth     http.Header
+		alistURL = h.cfg.GetAlistURL()
+	)
+	for _, variant := range buildProbeAuthVariants(h.cfg, authHeaders) {
+		fetch = fetchRawURLViaAPI(r.Context(), alistURL, req.Path, realPath, variant, h.fileDAO, "/api/fs/get")
+		if fetch.RawURL != "" {
+			auth = variant
+			break
+		}
+	}
+	if fetch.RawURL == "" {
+		RespondAPIError(w, 502, "failed to resolve raw url: "+fetch.FailureReason)
+		return
+	}
+	rawURL := fetch.RawURL
+	if strings.HasPrefix(rawURL, "/") {
+		rawURL = alistURL + rawURL
+	}
+
+	rangeEnd := encryption.ContentHeaderSize() - 1
+	if req.Hash {
+		rangeEnd = -1
+	}
+	if !strings.HasPrefix(rawURL, alistURL+"/") {
+		// Never forward Alist credentials to third-party storage hosts.
+		auth = nil
+	}
+	body, err := h.openCiphertext(r.Context(), rawURL, auth, rangeEnd)
+	if err != nil {
+		log.Warn().Err(err).Str("path", req.Path).Msg("Chunk map fetch failed")
+		RespondAPIError(w, 502, "failed to read remote file")
+		return
+	}
+	defer body.Close()
+
+	prefix := make([]byte, encryption.ContentHeaderSize())
+	n, err := io.ReadFull(body, prefix)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		RespondAPIError(w, 502, "failed to read content header")
+		return
+	}
+	prefix = prefix[:n]
+	meta, _, err := encryption.ParseContentHeader(encryption.EncType(passwdInfo.EncType), prefix, fetch.Size)
+	if err != nil {
+		RespondAPIError(w, 500, "invalid content header: "+err.Error())
+		return
+	}
+
+	m := buildChunkMap(meta, int64(req.ChunkKb)*1024)
+	m.Path = req.Path
+	m.RealPath = realPath
+	if req.Hash {
+		// The header bytes were already consumed while sniffing; replay them.
+		if err := m.hashChunks(io.MultiReader(bytes.NewReader(prefix), body)); err != nil {
+			RespondAPIError(w, 502, err.Error())
+			return
+		}
+	}
+	RespondSuccess(w, m)
+}
+
+func (h *AlistHandler) openCiphertext(ctx context.Context, rawURL string, auth http.Header, rangeEnd int64) (io.ReadCloser, error) {
+	builder := httputil.NewRequest(http.MethodGet, rawURL).WithContext(ctx)
+	if auth != nil && auth.Get("Authorization") != "" {
+		builder = builder.WithHeader("Authorization", auth.Get("Authorization"))
+	}
+	if rangeEnd >= 0 {
+		builder = builder.WithHeader("Range", fmt.Sprintf("bytes=0-%d", rangeEnd))
+	}
+	req, err := builder.Build()
+	if err != nil {
+		return nil, err
+	}
+	client := h.httpClient
+	if rangeEnd < 0 {
+		// Hashing streams the whole object; drop the API request timeout.
+		client = &http.Client{Transport: h.httpClient.Transport}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+		resp.Body.Close()
+		return nil, fmt.Errorf("upstream status %d", resp.StatusCode)
+	}
+	return resp.Body, nil
+}
+
+func clampChunkMapKb(v int) int {
+	if v < minChunkMapChunkKb {
+		return minChunkMapChunkKb
+	}
+	if v > maxChunkMapChunkKb {
+		return maxChunkMapChunkKb
+	}
+	return v
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/encryption"
+)
+
+func TestBuildChunkMapOffsetsSkipV2Header(t *testing.T) {
+	enc, err := encryption.NewLatestContentEncryptor("pw", "aesctr", 10*1024+5)
+	if err != nil {
+		t.Fatalf("encryptor: %v", err)
+	}
+	m := buildChunkMap(enc.Meta, 4096)
+	if len(m.Chunks) != 3 {
+		t.Fatalf("chunks=%d, want 3", len(m.Chunks))
+	}
+	header := encryption.ContentHeaderSize()
+	if m.HeaderLen != header || m.Chunks[1].CipherOffset != header+4096 {
+		t.Fatalf("header=%d chunk1 cipher offset=%d", m.HeaderLen, m.Chunks[1].CipherOffset)
+	}
+	if last := m.Chunks[2]; last.Size != 10*1024+5-8192 {
+		t.Fatalf("last chunk size=%d", last.Size)
+	}
+}
+
+func TestChunkMapHashesCiphertext(t *testing.T) {
+	plain := bytes.Repeat([]byte("0123456789"), 1000)
+	enc, err := encryption.NewLatestContentEncryptor("pw", "aesctr", int64(len(plain)))
+	if err != nil {
+		t.Fatalf("encryptor: %v", err)
+	}
+	reader, err := enc.EncryptReader(bytes.NewReader(plain), 0)
+	if err != nil {
+		t.Fatalf("encrypt reader: %v", err)
+	}
+	ciphertext, _ := io.ReadAll(reader)
+
+	m := buildChunkMap(enc.Meta, 4096)
+	if err := m.hashChunks(bytes.NewReader(ciphertext)); err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	first := m.Chunks[0]
+	sum := sha256.Sum256(ciphertext[first.CipherOffset : first.CipherOffset+first.Size])
+	if first.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Fatal("chunk hash does not cover the expected ciphertext range")
+	}
+	if m.HeaderSHA256 == "" {
+		t.Fatal("expected header hash")
+	}
+}
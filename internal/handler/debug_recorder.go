@@ -0,0 +1,376 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/trace"
+)
+
+const (
+	debugRecorderDefaultMinutes = 10
+	debugRecorderMaxMinutes     = 60
+	debugRecorderMaxBodyKB      = 1024
+	debugRecorderMaxEntries     = 5000
+)
+
+// debugRecorderRedactedHeaders never reach the capture file.
+var debugRecorderRedactedHeaders = []string{"Authorization", "Authorizetoken", "Cookie", "Set-Cookie", "Proxy-Authorization"}
+
+// DebugRecordEntry is one captured request/response pair, written as a JSON
+// line. Body previews are base64 in JSON and only present when bodyKB > 0.
+type DebugRecordEntry struct {
+	Time            time.Time   `json:"time"`
+	DurationMs      float64     `json:"duration_ms"`
+	Method          string      `json:"method"`
+	URL             string      `json:"url"`
+	Remote          string      `json:"remote"`
+	User            string      `json:"user,omitempty"`
+	RequestHeaders  http.Header `json:"request_headers"`
+	RequestBytes    int64       `json:"request_bytes"`
+	RequestBody     []byte      `json:"request_body,omitempty"`
+	Status          int         `json:"status"`
+	ResponseHeaders http.Header `json:"response_headers"`
+	ResponseBytes   int64       `json:"response_bytes"`
+	ResponseBody    []byte      `json:"response_body,omitempty"`
+}
+
+// DebugRecordingInfo describes the active or a finished recording.
+type DebugRecordingInfo struct {
+	ID        string    `json:"id"`
+	Pattern   string    `json:"pattern"`
+	BodyKB    int       `json:"body_kb"`
+	StartedAt time.Time `json:"started_at"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+	Entries   int       `json:"entries"`
+	Size      int64     `json:"size"`
+	Active    bool      `json:"active"`
+}
+
+type debugRecording struct {
+	info DebugRecordingInfo
+	file *os.File
+}
+
+// DebugRecorder captures request/response metadata for paths matching an
+// admin-supplied pattern for a limited time, so WebDAV client quirks can be
+// attached to bug reports instead of being guessed at. Captures live in
+// dir as <id>.jsonl.
+type DebugRecorder struct {
+	dir    string
+	mu     sync.Mutex
+	active *debugRecording
+}
+
+// NewDebugRecorder creates a recorder storing captures under dir.
+func NewDebugRecorder(dir string) *DebugRecorder {
+	return &DebugRecorder{dir: dir}
+}
+
+// Start begins a recording, replacing any active one.
+func (d *DebugRecorder) Start(pattern string, minutes, bodyKB int) (DebugRecordingInfo, error) {
+	pattern = strings.TrimSpace(pattern)
+	if pattern == "" || !strings.HasPrefix(pattern, "/") {
+		return DebugRecordingInfo{}, fmt.Errorf("pattern must be an absolute path or glob")
+	}
+	if _, err := path.Match(strings.TrimSuffix(pattern, "*"), "/"); err != nil {
+		return DebugRecordingInfo{}, fmt.Errorf("invalid pattern: %w", err)
+	}
+	if minutes <= 0 {
+		minutes = debugRecorderDefaultMinutes
+	}
+	minutes = clampInt(minutes, 1, debugRecorderMaxMinutes)
+	bodyKB = clampInt(bodyKB, 0, debugRecorderMaxBodyKB)
+
+	if err := os.MkdirAll(d.dir, 0755); err != nil {
+		return DebugRecordingInfo{}, err
+	}
+	now := time.Now()
+	id := now.Format("20060102-150405")
+	file, err := os.OpenFile(filepath.Join(d.dir, id+".jsonl"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return DebugRecordingInfo{}, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stopLocked()
+	d.active = &debugRecording{
+		info: DebugRecordingInfo{
+			ID:        id,
+			Pattern:   pattern,
+			BodyKB:    bodyKB,
+			StartedAt: now,
+			ExpiresAt: now.Add(time.Duration(minutes) * time.Minute),
+			Active:    true,
+		},
+		file: file,
+	}
+	log.Warn().Str("pattern", pattern).Int("minutes", minutes).Int("body_kb", bodyKB).Str("id", id).Msg("Debug recorder started")
+	return d.active.info, nil
+}
+
+// Stop ends the active recording, if any.
+func (d *DebugRecorder) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.stopLocked()
+}
+
+func (d *DebugRecorder) stopLocked() {
+	if d.active == nil {
+		return
+	}
+	d.active.file.Close()
+	log.Info().Str("id", d.active.info.ID).Int("entries", d.active.info.Entries).Msg("Debug recorder stopped")
+	d.active = nil
+}
+
+// session returns the active recording if r should be captured.
+func (d *DebugRecorder) session(r *http.Request) (pattern string, bodyKB int, ok bool) {
+	if d == nil || strings.HasPrefix(r.URL.Path, "/enc-api/debugRecorder") {
+		return "", 0, false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.active == nil {
+		return "", 0, false
+	}
+	if time.Now().After(d.active.info.ExpiresAt) || d.active.info.Entries >= debugRecorderMaxEntries {
+		d.stopLocked()
+		return "", 0, false
+	}
+	if !matchDebugRecorderPattern(d.active.info.Pattern, r.URL.Path) {
+		return "", 0, false
+	}
+	return d.active.info.Pattern, d.active.info.BodyKB, true
+}
+
+// matchDebugRecorderPattern matches a path.Match glob; a trailing "*" also
+// matches everything below that prefix, so "/dav/*" covers nested paths.
+func matchDebugRecorderPattern(pattern, p string) bool {
+	if ok, _ := path.Match(pattern, p); ok {
+		return true
+	}
+	if prefix, found := strings.CutSuffix(pattern, "*"); found {
+		return strings.HasPrefix(p, prefix)
+	}
+	return false
+}
+
+func (d *DebugRecorder) write(pattern string, entry *DebugRecordEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	// The recording may have been stopped or replaced while the request ran.
+	if d.active == nil || d.active.info.Pattern != pattern {
+		return
+	}
+	if _, err := d.active.file.Write(append(line, '\n')); err != nil {
+		log.Warn().Err(err).Msg("Debug recorder write failed")
+		return
+	}
+	d.active.info.Entries++
+}
+
+// Wrap captures r and the response written through w when a recording is
+// active for r's path. It returns the writer and request next should use and
+// a finish func to call once next has returned.
+func (d *DebugRecorder) Wrap(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, *http.Request, func(status int)) {
+	pattern, bodyKB, ok := d.session(r)
+	if !ok {
+		return w, r, func(int) {}
+	}
+	limit := bodyKB * 1024
+	entry := &DebugRecordEntry{
+		Time:           time.Now(),
+		Method:         r.Method,
+		URL:            r.URL.String(),
+		Remote:         r.RemoteAddr,
+		User:           trace.GetUser(r.Context()),
+		RequestHeaders: redactDebugHeaders(r.Header),
+	}
+	reqCapture := &debugCaptureBuffer{limit: limit}
+	if r.Body != nil && r.Body != http.NoBody {
+		r.Body = &debugCaptureBody{ReadCloser: r.Body, capture: reqCapture}
+	}
+	respCapture := &debugCaptureBuffer{limit: limit}
+	rw := &debugCaptureWriter{ResponseWriter: w, capture: respCapture}
+
+	return rw, r, func(status int) {
+		entry.DurationMs = float64(time.Since(entry.Time).Microseconds()) / 1000
+		if status == 0 {
+			status = rw.status
+		}
+		entry.Status = status
+		entry.ResponseHeaders = redactDebugHeaders(w.Header())
+		entry.RequestBytes, entry.RequestBody = reqCapture.total, reqCapture.bytes()
+		entry.ResponseBytes, entry.ResponseBody = respCapture.total, respCapture.bytes()
+		d.write(pattern, entry)
+	}
+}
+
+// Status reports the active recording (if any) and the stored captures.
+func (d *DebugRecorder) Status() (active *DebugRecordingInfo, stored []DebugRecordingInfo) {
+	d.mu.Lock()
+	if d.active != nil {
+		info := d.active.info
+		active = &info
+	}
+	d.mu.Unlock()
+
+	entries, _ := os.ReadDir(d.dir)
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".jsonl")
+		if !ok || entry.IsDir() {
+			continue
+		}
+		info := DebugRecordingInfo{ID: id, Active: active != nil && active.ID == id}
+		if fi, err := entry.Info(); err == nil {
+			info.Size = fi.Size()
+			info.StartedAt = fi.ModTime()
+		}
+		stored = append(stored, info)
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].ID > stored[j].ID })
+	return active, stored
+}
+
+func redactDebugHeaders(h http.Header) http.Header {
+	out := h.Clone()
+	for _, name := range debugRecorderRedactedHeaders {
+		if out.Get(name) != "" {
+			out.Set(name, "[redacted]")
+		}
+	}
+	return out
+}
+
+type debugCaptureBuffer struct {
+	buf   bytes.Buffer
+	limit int
+	total int64
+}
+
+func (c *debugCaptureBuffer) add(p []byte) {
+	c.total += int64(len(p))
+	if room := c.limit - c.buf.Len(); room > 0 {
+		if len(p) > room {
+			p = p[:room]
+		}
+		c.buf.Write(p)
+	}
+}
+
+func (c *debugCaptureBuffer) bytes() []byte {
+	if c.buf.Len() == 0 {
+		return nil
+	}
+	return c.buf.Bytes()
+}
+
+type debugCaptureBody struct {
+	io.ReadCloser
+	capture *debugCaptureBuffer
+}
+
+func (b *debugCaptureBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.capture.add(p[:n])
+	return n, err
+}
+
+type debugCaptureWriter struct {
+	http.ResponseWriter
+	capture *debugCaptureBuffer
+	status  int
+}
+
+func (w *debugCaptureWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *debugCaptureWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.capture.add(p[:n])
+	return n, err
+}
+
+func (w *debugCaptureWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// HandleDebugRecorderStart starts a capture: {"pattern":"/dav/*","minutes":10,"bodyKB":4}.
+func (d *DebugRecorder) HandleDebugRecorderStart(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Pattern string `json:"pattern"`
+		Minutes int    `json:"minutes"`
+		BodyKB  int    `json:"bodyKB"`
+	}
+	body, err := readLimitedRequestBody(r)
+	if err != nil || json.Unmarshal(body, &req) != nil {
+		RespondAPIError(w, 400, "Invalid request")
+		return
+	}
+	info, err := d.Start(req.Pattern, req.Minutes, req.BodyKB)
+	if err != nil {
+		RespondAPIError(w, 400, err.Error())
+		return
+	}
+	RespondSuccess(w, info)
+}
+
+// HandleDebugRecorderStop stops the active capture.
+func (d *DebugRecorder) HandleDebugRecorderStop(w http.ResponseWriter, r *http.Request) {
+	d.Stop()
+	RespondSuccessMsg(w, "stopped")
+}
+
+// HandleDebugRecorderStatus lists the active capture and stored files.
+func (d *DebugRecorder) HandleDebugRecorderStatus(w http.ResponseWriter, r *http.Request) {
+	active, stored := d.Status()
+	RespondSuccess(w, map[string]interface{}{
+		"active":     active,
+		"recordings": stored,
+	})
+}
+
+// HandleDebugRecorderDownload serves one capture file by id.
+func (d *DebugRecorder) HandleDebugRecorderDownload(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("id")
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		RespondHTTPErrorWithStatus(w, "invalid id", http.StatusBadRequest)
+		return
+	}
+	file := filepath.Join(d.dir, id+".jsonl")
+	if _, err := os.Stat(file); err != nil {
+		RespondHTTPErrorWithStatus(w, "recording not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="debug-`+id+`.jsonl"`)
+	http.ServeFile(w, r, file)
+}
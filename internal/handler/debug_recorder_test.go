@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDebugRecorderCapturesMatchingRequests(t *testing.T) {
+	dir := t.TempDir()
+	rec := NewDebugRecorder(dir)
+	info, err := rec.Start("/dav/*", 1, 1)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+
+	serve := func(method, target, body string) {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Authorization", "Basic c2VjcmV0")
+		w, r, finish := rec.Wrap(httptest.NewRecorder(), req)
+		buf := make([]byte, 4096)
+		for {
+			if _, err := r.Body.Read(buf); err != nil {
+				break
+			}
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusMultiStatus)
+		w.Write([]byte(strings.Repeat("x", 2048)))
+		finish(0)
+	}
+	serve("PROPFIND", "/dav/movies/a.mkv", "<propfind/>")
+	serve("GET", "/api/fs/list", "")
+	rec.Stop()
+
+	f, err := os.Open(filepath.Join(dir, info.ID+".jsonl"))
+	if err != nil {
+		t.Fatalf("open capture: %v", err)
+	}
+	defer f.Close()
+	var entries []DebugRecordEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 1<<20), 1<<20)
+	for scanner.Scan() {
+		var e DebugRecordEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("decode entry: %v", err)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("entries=%d, want only the /dav request", len(entries))
+	}
+	e := entries[0]
+	if e.Method != "PROPFIND" || e.Status != http.StatusMultiStatus {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	if got := e.RequestHeaders.Get("Authorization"); got != "[redacted]" {
+		t.Fatalf("Authorization=%q, want redacted", got)
+	}
+	if string(e.RequestBody) != "<propfind/>" {
+		t.Fatalf("request body=%q", e.RequestBody)
+	}
+	if e.ResponseBytes != 2048 || len(e.ResponseBody) != 1024 {
+		t.Fatalf("response bytes=%d preview=%d, want 2048/1024", e.ResponseBytes, len(e.ResponseBody))
+	}
+}
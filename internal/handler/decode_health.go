@@ -0,0 +1,240 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/encryption"
+	"github.com/alist-encrypt-go/internal/proxy"
+)
+
+const (
+	decodeHealthMaxDepth       = 4
+	decodeHealthMaxDirs        = 64
+	decodeHealthMinSamples     = 10
+	decodeHealthMaxExamples    = 20
+	decodeHealthWebhookTimeout = 10 * time.Second
+)
+
+// DecodeHealthRootStat counts sampled names under one encrypted root.
+type DecodeHealthRootStat struct {
+	Sampled  int    `json:"sampled"`
+	Failures int    `json:"failures"`
+	Error    string `json:"error,omitempty"`
+}
+
+// DecodeHealthReport is the outcome of one decode-health scan. A failure is
+// a stored filename that does not decode with the configured password and
+// would be shown with the orig_ prefix.
+type DecodeHealthReport struct {
+	StartedAt   time.Time                       `json:"started_at"`
+	FinishedAt  time.Time                       `json:"finished_at"`
+	Sampled     int                             `json:"sampled"`
+	Failures    int                             `json:"failures"`
+	FailPercent float64                         `json:"fail_percent"`
+	Threshold   int                             `json:"threshold_percent"`
+	Alert       bool                            `json:"alert"`
+	Roots       map[string]DecodeHealthRootStat `json:"roots"`
+	Examples    []string                        `json:"examples,omitempty"`
+}
+
+// StartDecodeHealthLoop runs the decode-health scan every
+// decodeHealthIntervalMinutes until ctx is cancelled. It is a no-op unless
+// enableDecodeHealthScan is set.
+func (h *AlistHandler) StartDecodeHealthLoop(ctx context.Context) {
+	if h == nil || h.cfg == nil || !h.cfg.AlistServer.EnableDecodeHealthScan {
+		return
+	}
+	interval := time.Duration(h.cfg.AlistServer.DecodeHealthIntervalMinutes) * time.Minute
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Error().Interface("panic", r).Msg("Decode health scheduler panicked")
+			}
+		}()
+		// Let the proxy and Alist settle before the first pass.
+		timer := time.NewTimer(time.Minute)
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+			if h.maintenance.Wait(ctx, jobDecodeHealth) != nil {
+				return
+			}
+			h.RunDecodeHealthScan(ctx)
+			timer.Reset(interval)
+		}
+	}()
+}
+
+// RunDecodeHealthScan samples filenames across the encrypted roots, records
+// the report for Stats and alerts when the failure ratio crosses the
+// configured threshold.
+func (h *AlistHandler) RunDecodeHealthScan(ctx context.Context) DecodeHealthReport {
+	report := h.scanDecodeHealth(ctx, h.scanAuthHeaders())
+	h.decodeHealthMu.Lock()
+	h.decodeHealthLast = &report
+	h.decodeHealthMu.Unlock()
+
+	event := log.Info()
+	if report.Alert {
+		event = log.Warn()
+	}
+	event.
+		Int("sampled", report.Sampled).
+		Int("failures", report.Failures).
+		Float64("fail_percent", report.FailPercent).
+		Int("threshold_percent", report.Threshold).
+		Strs("examples", report.Examples).
+		Msg("Filename decode health scan finished")
+	if report.Alert {
+		h.sendDecodeHealthWebhook(ctx, report)
+	}
+	return report
+}
+
+func (h *AlistHandler) scanDecodeHealth(ctx context.Context, auth http.Header) DecodeHealthReport {
+	report := DecodeHealthReport{
+		StartedAt: time.Now(),
+		Threshold: h.cfg.AlistServer.DecodeHealthFailPercent,
+		Roots:     make(map[string]DecodeHealthRootStat),
+	}
+	roots := h.collectEncryptedSearchRoots()
+	if len(roots) > 0 {
+		perRoot := h.cfg.AlistServer.DecodeHealthSampleSize / len(roots)
+		if perRoot < 1 {
+			perRoot = 1
+		}
+		for _, root := range roots {
+			if ctx.Err() != nil {
+				break
+			}
+			stat, examples := h.sampleDecodeHealthRoot(ctx, root, perRoot, auth)
+			report.Roots[root] = stat
+			report.Sampled += stat.Sampled
+			report.Failures += stat.Failures
+			for _, ex := range examples {
+				if len(report.Examples) < decodeHealthMaxExamples {
+					report.Examples = append(report.Examples, ex)
+				}
+			}
+		}
+	}
+	if report.Sampled > 0 {
+		report.FailPercent = float64(report.Failures) * 100 / float64(report.Sampled)
+	}
+	report.Alert = report.Sampled >= decodeHealthMinSamples && report.FailPercent >= float64(report.Threshold)
+	report.FinishedAt = time.Now()
+	return report
+}
+
+// sampleDecodeHealthRoot walks root breadth-first (bounded in depth and
+// directory count) and tries to decode up to limit file names.
+func (h *AlistHandler) sampleDecodeHealthRoot(ctx context.Context, root string, limit int, auth http.Header) (DecodeHealthRootStat, []string) {
+	type node struct {
+		path  string
+		depth int
+	}
+	var (
+		stat     DecodeHealthRootStat
+		examples []string
+	)
+	queue := []node{{path: root}}
+	for dirs := 0; len(queue) > 0 && dirs < decodeHealthMaxDirs && stat.Sampled < limit; dirs++ {
+		current := queue[0]
+		queue = queue[1:]
+		if waitStorageRequest(ctx, current.path) != nil {
+			break
+		}
+
+		content, err := h.listAlistDir(ctx, current.path, auth)
+		if err != nil {
+			if current.path == root {
+				stat.Error = err.Error()
+			}
+			continue
+		}
+		var passwdInfo *config.PasswdInfo
+		if h.passwdDAO.MatchDir(current.path) {
+			passwdInfo, _ = h.passwdDAO.FindByDir(current.path)
+		}
+		for _, raw := range content {
+			fileData, ok := raw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _ := fileData["name"].(string)
+			if name == "" {
+				continue
+			}
+			if isDir, _ := fileData["is_dir"].(bool); isDir {
+				if current.depth < decodeHealthMaxDepth {
+					queue = append(queue, node{path: path.Join(current.path, name), depth: current.depth + 1})
+				}
+				continue
+			}
+			if passwdInfo == nil || !passwdInfo.EncName || stat.Sampled >= limit {
+				continue
+			}
+			stat.Sampled++
+			if encryption.IsOriginalFile(h.convertShowName(passwdInfo, name)) {
+				stat.Failures++
+				examples = append(examples, path.Join(current.path, name))
+			}
+		}
+	}
+	return stat, examples
+}
+
+func (h *AlistHandler) sendDecodeHealthWebhook(ctx context.Context, report DecodeHealthReport) {
+	target := h.cfg.AlistServer.DecodeHealthWebhook
+	if target == "" {
+		return
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"event":  "decode_health_alert",
+		"report": report,
+	})
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		log.Warn().Err(err).Msg("Invalid decode health webhook URL")
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := proxy.NewHTTPClient(h.cfg, decodeHealthWebhookTimeout).Do(req)
+	if err != nil {
+		log.Warn().Err(err).Msg("Decode health webhook failed")
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		log.Warn().Int("status", resp.StatusCode).Msg("Decode health webhook rejected alert")
+	}
+}
+
+// LastDecodeHealthReport returns the most recent scan report, or nil.
+func (h *AlistHandler) LastDecodeHealthReport() *DecodeHealthReport {
+	if h == nil {
+		return nil
+	}
+	h.decodeHealthMu.Lock()
+	defer h.decodeHealthMu.Unlock()
+	if h.decodeHealthLast == nil {
+		return nil
+	}
+	report := *h.decodeHealthLast
+	return &report
+}
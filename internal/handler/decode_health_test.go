@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/encryption"
+)
+
+func TestRunDecodeHealthScanAlertsOnFailures(t *testing.T) {
+	passwd := &config.PasswdInfo{
+		Password: "testpass",
+		EncType:  "aesctr",
+		Enable:   true,
+		EncName:  true,
+		EncPath:  []string{"/vault/*"},
+	}
+	converter := encryption.NewFileNameConverter(passwd.Password, passwd.EncType, passwd.EncSuffix)
+
+	content := make([]interface{}, 0, 12)
+	for i := 0; i < 10; i++ {
+		content = append(content, map[string]interface{}{"name": converter.ToRealName(fmt.Sprintf("ok-%d.mp4", i)), "is_dir": false})
+	}
+	content = append(content,
+		map[string]interface{}{"name": "plain-upload.mp4", "is_dir": false},
+		map[string]interface{}{"name": "another-plain.mkv", "is_dir": false},
+	)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/fs/list", func(w http.ResponseWriter, r *http.Request) {
+		writeJSONResponse(w, map[string]interface{}{
+			"code":    200,
+			"message": "success",
+			"data":    map[string]interface{}{"content": content, "total": float64(len(content))},
+		})
+	})
+	srv := newSocketTestServer(t, mux)
+	defer srv.Close()
+
+	alerts := make(chan DecodeHealthReport, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload struct {
+			Event  string             `json:"event"`
+			Report DecodeHealthReport `json:"report"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		if payload.Event == "decode_health_alert" {
+			alerts <- payload.Report
+		}
+	}))
+	defer hook.Close()
+
+	handler, _ := newTestAlistHandler(t, srv.URL, passwd)
+	handler.cfg.AlistServer.DecodeHealthSampleSize = 200
+	handler.cfg.AlistServer.DecodeHealthFailPercent = 10
+	handler.cfg.AlistServer.DecodeHealthWebhook = hook.URL
+
+	report := handler.RunDecodeHealthScan(context.Background())
+	if report.Sampled != 12 || report.Failures != 2 || !report.Alert {
+		t.Fatalf("report=%+v", report)
+	}
+	select {
+	case got := <-alerts:
+		if got.Failures != 2 {
+			t.Fatalf("webhook report=%+v", got)
+		}
+	default:
+		t.Fatal("expected webhook alert")
+	}
+	if last := handler.LastDecodeHealthReport(); last == nil || last.Failures != 2 {
+		t.Fatalf("last report=%+v", last)
+	}
+
+	handler.cfg.AlistServer.DecodeHealthFailPercent = 50
+	if report := handler.RunDecodeHealthScan(context.Background()); report.Alert {
+		t.Fatalf("unexpected alert below threshold: %+v", report)
+	}
+}
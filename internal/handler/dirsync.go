@@ -16,6 +16,7 @@ import (
 
 	"github.com/alist-encrypt-go/internal/config"
 	"github.com/alist-encrypt-go/internal/encryption"
+	"github.com/alist-encrypt-go/internal/pathutil"
 	"github.com/rs/zerolog/log"
 )
 
@@ -179,24 +180,9 @@ func payloadResponseCode(payload []byte) int {
 	}
 }
 
-func normalizeDirPath(dirPath string) string {
-	dirPath = strings.TrimSpace(dirPath)
-	if dirPath == "" {
-		return "/"
-	}
-	cleaned := path.Clean(dirPath)
-	if cleaned == "." {
-		return "/"
-	}
-	if !strings.HasPrefix(cleaned, "/") {
-		cleaned = "/" + cleaned
-	}
-	return cleaned
-}
-
 func listItemBelongsToDir(dirPath, childPath, name string) bool {
-	dirPath = normalizeDirPath(dirPath)
-	childPath = normalizeDirPath(childPath)
+	dirPath = pathutil.CleanInput(dirPath)
+	childPath = pathutil.CleanInput(childPath)
 	if dirPath == "/" {
 		trimmed := strings.TrimPrefix(childPath, "/")
 		if trimmed == "" {
@@ -220,8 +206,8 @@ func validateSnapshotForDir(dirPath string, snap *DirListSnapshot) (bool, string
 	if snap == nil {
 		return false, "snapshot missing"
 	}
-	expected := normalizeDirPath(dirPath)
-	if got := normalizeDirPath(snap.DisplayPath); got != expected {
+	expected := pathutil.CleanInput(dirPath)
+	if got := pathutil.CleanInput(snap.DisplayPath); got != expected {
 		return false, fmt.Sprintf("snapshot display path mismatch: got=%s want=%s", got, expected)
 	}
 	if len(snap.PayloadJSON) == 0 {
@@ -325,17 +311,19 @@ func (h *AlistHandler) updateSnapshotSyncing(ctx context.Context, scopeKey strin
 	_ = h.dirSyncStore.UpsertSnapshot(ctx, *snap)
 }
 
-func (h *AlistHandler) liveFsListResponse(r *http.Request, body []byte, dirPath string, enableProbe bool) (int, map[string]interface{}, []byte, int, error) {
-	allowDecrypt := h.passwdDAO.MatchDir(dirPath)
-	var dirPasswd *config.PasswdInfo
-	if allowDecrypt {
-		if passwdInfo, ok := h.passwdDAO.FindByDir(dirPath); ok {
-			dirPasswd = passwdInfo
-		}
+// listDecryptScope returns the password rule for names listed in dirPath.
+func (h *AlistHandler) listDecryptScope(dirPath string) (*config.PasswdInfo, bool) {
+	if !h.passwdDAO.MatchDir(dirPath) {
+		return nil, false
 	}
-	if dirPasswd == nil {
-		allowDecrypt = false
+	if passwdInfo, ok := h.passwdDAO.FindByDir(dirPath); ok {
+		return passwdInfo, true
 	}
+	return nil, false
+}
+
+func (h *AlistHandler) liveFsListResponse(r *http.Request, body []byte, dirPath string, enableProbe bool) (int, map[string]interface{}, []byte, int, error) {
+	dirPasswd, allowDecrypt := h.listDecryptScope(dirPath)
 
 	targetURL := h.cfg.GetAlistURL() + "/api/fs/list"
 	proxyReq, err := http.NewRequestWithContext(r.Context(), http.MethodPost, targetURL, bytes.NewReader(body))
@@ -363,6 +351,22 @@ func (h *AlistHandler) liveFsListResponse(r *http.Request, body []byte, dirPath
 		return resp.StatusCode, nil, respBody, 0, nil
 	}
 
+	page, perPage := parseListPage(body)
+	itemCount := h.rewriteFsListPayload(r, respData, dirPath, dirPasswd, allowDecrypt, enableProbe, isPartialListing(page, perPage, respData))
+
+	encoded, err := json.Marshal(respData)
+	if err != nil {
+		return resp.StatusCode, respData, respBody, itemCount, nil
+	}
+	return resp.StatusCode, respData, encoded, itemCount, nil
+}
+
+// rewriteFsListPayload decrypts names in an fs/list response in place and
+// returns the number of upstream items. Cover images are folded into their
+// video's thumb and hidden only for complete listings: on a partial page the
+// matching video may sit on another page, and dropping items would shift the
+// client's page boundaries.
+func (h *AlistHandler) rewriteFsListPayload(r *http.Request, respData map[string]interface{}, dirPath string, dirPasswd *config.PasswdInfo, allowDecrypt, enableProbe, partial bool) int {
 	itemCount := 0
 	if code, ok := respData["code"].(float64); ok && code == 200 {
 		if data, ok := respData["data"].(map[string]interface{}); ok {
@@ -427,11 +431,14 @@ func (h *AlistHandler) liveFsListResponse(r *http.Request, body []byte, dirPath
 					useParallel := h.parallelDecryptEnabled() && len(tasks) >= parallelDecryptThreshold
 					if useParallel {
 						results := make(chan decryptResult, len(tasks))
-						semaphore := make(chan struct{}, h.parallelDecryptLimit())
+						pool := h.nameDecryptPool()
 						for _, task := range tasks {
-							semaphore <- struct{}{}
+							if err := pool.Acquire(r.Context()); err != nil {
+								results <- decryptResult{index: task.index, showName: h.convertShowName(task.passwdInfo, task.name)}
+								continue
+							}
 							go func(t decryptTask) {
-								defer func() { <-semaphore }()
+								defer pool.Release()
 								showName := h.convertShowName(t.passwdInfo, t.name)
 								results <- decryptResult{index: t.index, showName: showName}
 							}(task)
@@ -465,7 +472,7 @@ func (h *AlistHandler) liveFsListResponse(r *http.Request, body []byte, dirPath
 					}
 				}
 
-				if len(omitNames) > 0 {
+				if len(omitNames) > 0 && !partial {
 					var filtered []interface{}
 					for _, item := range content {
 						if fileData, ok := item.(map[string]interface{}); ok {
@@ -483,16 +490,15 @@ func (h *AlistHandler) liveFsListResponse(r *http.Request, body []byte, dirPath
 						}
 					}
 					data["content"] = filtered
+					if total, ok := data["total"].(float64); ok {
+						data["total"] = total - float64(len(content)-len(filtered))
+					}
 				}
 			}
 		}
 	}
 
-	encoded, err := json.Marshal(respData)
-	if err != nil {
-		return resp.StatusCode, respData, respBody, itemCount, nil
-	}
-	return resp.StatusCode, respData, encoded, itemCount, nil
+	return itemCount
 }
 
 func (h *AlistHandler) refreshDirSnapshotAsync(dirPath string, body []byte, headers http.Header, scopeKey string, sourceMode string) {
@@ -510,7 +516,8 @@ func (h *AlistHandler) refreshDirSnapshotAsync(dirPath string, body []byte, head
 				h.updateSnapshotSyncing(context.Background(), scopeKey, false, liveErr.Error())
 				return nil, liveErr
 			}
-			if status >= 200 && status < 300 && isSuccessfulListPayload(payload) {
+			page, _ := parseListPage(body)
+			if status >= 200 && status < 300 && isSuccessfulListPayload(payload) && isCompleteListPayload(page, payload) {
 				h.persistSnapshot(context.Background(), dirPath, scopeKey, authScopeHash(headers), payload, itemCount, sourceMode, "")
 				return nil, nil
 			}
@@ -581,8 +588,13 @@ func (h *AlistHandler) runDirSyncScan(jobType string) {
 	}
 
 	for len(queue) > 0 {
+		// A scan started by hand from the UI is not held by quiet hours.
+		if jobType != "manual_scan" {
+			_ = h.maintenance.Wait(context.Background(), jobDirSync)
+		}
 		node := queue[0]
 		queue = queue[1:]
+		_ = waitStorageRequest(context.Background(), node.path)
 		status.TotalDirsDiscovered = len(seen)
 		scopeKey := buildDirScopeKey(node.path, dirSyncScopeScan)
 		headers := h.scanAuthHeaders()
@@ -603,24 +615,13 @@ func (h *AlistHandler) runDirSyncScan(jobType string) {
 			continue
 		}
 
-		reqBody, _ := json.Marshal(map[string]interface{}{
-			"path":     node.path,
-			"page":     1,
-			"per_page": 1000,
-			"refresh":  false,
-		})
-		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://dirsync.local/api/fs/list", bytes.NewReader(reqBody))
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodPost, "http://dirsync.local/api/fs/list", nil)
 		req.Header = headers
-		req.Header.Set("Content-Type", "application/json")
-		respStatus, respData, payload, itemCount, err := h.liveFsListResponse(req, reqBody, node.path, true)
+		respData, payload, itemCount, err := h.fullFsListResponse(req, node.path, headers, true)
 		status.DirsScanned++
-		if err != nil || respStatus < 200 || respStatus >= 300 || !isSuccessfulListPayload(payload) {
+		if err != nil {
 			status.DirsFailed++
-			if err != nil {
-				status.LastError = err.Error()
-			} else if code := payloadResponseCode(payload); code != 0 {
-				status.LastError = "upstream list returned code " + strconv.Itoa(code)
-			}
+			status.LastError = err.Error()
 			status.UpdatedAt = time.Now()
 			_ = h.dirSyncStore.UpsertStatus(context.Background(), status)
 			continue
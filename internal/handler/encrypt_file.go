@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
@@ -14,7 +15,9 @@ import (
 	"sync"
 	"time"
 
+	"github.com/alist-encrypt-go/internal/config"
 	"github.com/alist-encrypt-go/internal/encryption"
+	"github.com/alist-encrypt-go/internal/workers"
 	"github.com/rs/zerolog/log"
 )
 
@@ -24,6 +27,7 @@ type EncryptTask struct {
 	Operation  string    `json:"operation"` // "enc" or "dec"
 	Password   string    `json:"-"`
 	EncType    string    `json:"encType"`
+	Version    int       `json:"contentVersion,omitempty"` // content format for "enc"; 0 = latest
 	SrcPath    string    `json:"srcPath"`
 	DstPath    string    `json:"dstPath"`
 	EncName    bool      `json:"encName"`
@@ -31,7 +35,7 @@ type EncryptTask struct {
 	DoneFiles  int       `json:"doneFiles"`
 	TotalBytes int64     `json:"totalBytes"`
 	DoneBytes  int64     `json:"doneBytes"`
-	Status     string    `json:"status"` // "running", "done", "error"
+	Status     string    `json:"status"` // "queued", "running", "done", "error"
 	Error      string    `json:"error,omitempty"`
 	CreatedAt  time.Time `json:"createdAt"`
 	UpdatedAt  time.Time `json:"updatedAt"`
@@ -91,6 +95,7 @@ func HandleEncryptFile(w http.ResponseWriter, r *http.Request) {
 		SrcPath   string `json:"folderPath"` // match old API field name
 		DstPath   string `json:"outPath"`
 		EncName   bool   `json:"encName"`
+		Version   int    `json:"contentVersion"` // 1 = legacy headerless, otherwise v2
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		RespondAPIError(w, 500, "Invalid request")
@@ -156,12 +161,13 @@ func HandleEncryptFile(w http.ResponseWriter, r *http.Request) {
 		Operation:  req.Operation,
 		Password:   req.Password,
 		EncType:    req.EncType,
+		Version:    req.Version,
 		SrcPath:    req.SrcPath,
 		DstPath:    req.DstPath,
 		EncName:    req.EncName,
 		TotalFiles: len(files),
 		TotalBytes: totalBytes,
-		Status:     "running",
+		Status:     "queued",
 		CreatedAt:  time.Now(),
 		UpdatedAt:  time.Now(),
 		cancel:     make(chan struct{}),
@@ -174,7 +180,33 @@ func HandleEncryptFile(w http.ResponseWriter, r *http.Request) {
 		Int("files", len(files)).Int64("bytes", totalBytes).
 		Msg("Encrypt task started")
 
-	go runEncryptTask(task, files)
+	go func() {
+		// Tasks wait in "queued" until a jobs slot frees up.
+		jobs := workers.Shared(config.PoolJobs, config.Get().WorkerLimit(config.PoolJobs))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			select {
+			case <-task.cancel:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		if err := jobs.Acquire(ctx); err != nil {
+			task.mu.Lock()
+			task.Status = "error"
+			task.Error = "canceled"
+			task.UpdatedAt = time.Now()
+			task.mu.Unlock()
+			return
+		}
+		defer jobs.Release()
+		task.mu.Lock()
+		task.Status = "running"
+		task.UpdatedAt = time.Now()
+		task.mu.Unlock()
+		runEncryptTask(task, files)
+	}()
 
 	RespondSuccess(w, map[string]interface{}{
 		"taskId":     task.ID,
@@ -294,7 +326,7 @@ func runEncryptTask(task *EncryptTask, files []string) {
 		}
 		fileSize := fileInfo.Size()
 
-		if err := processFile(filePath, outTemp, task.Password, task.EncType, fileSize, task.Operation); err != nil {
+		if err := processFile(filePath, outTemp, task.Password, task.EncType, fileSize, task.Operation, task.Version); err != nil {
 			task.mu.Lock()
 			task.Status = "error"
 			task.Error = fmt.Sprintf("process %s: %v", filePath, err)
@@ -323,7 +355,7 @@ func runEncryptTask(task *EncryptTask, files []string) {
 		Int64("bytes", task.DoneBytes).Msg("Encrypt task completed")
 }
 
-func processFile(src, dst, password, encType string, fileSize int64, operation string) error {
+func processFile(src, dst, password, encType string, fileSize int64, operation string, version int) error {
 	in, err := os.Open(src)
 	if err != nil {
 		return fmt.Errorf("open src: %w", err)
@@ -337,7 +369,7 @@ func processFile(src, dst, password, encType string, fileSize int64, operation s
 	defer out.Close()
 
 	if operation == "enc" {
-		enc, err := encryption.NewLatestContentEncryptor(password, encType, fileSize)
+		enc, err := encryption.NewContentEncryptor(password, encType, fileSize, version)
 		if err != nil {
 			return fmt.Errorf("create cipher: %w", err)
 		}
@@ -14,6 +14,7 @@ import (
 	"github.com/alist-encrypt-go/internal/config"
 	"github.com/alist-encrypt-go/internal/dao"
 	"github.com/alist-encrypt-go/internal/proxy"
+	"github.com/alist-encrypt-go/internal/workers"
 	"github.com/rs/zerolog/log"
 )
 
@@ -21,8 +22,7 @@ import (
 type FileSizeResolver struct {
 	fileDAO          *dao.FileDAO
 	metaStore        FileMetaStore
-	semaphore        chan struct{} // Limit concurrent HTTP requests
-	maxWorkers       int
+	pool             *workers.Pool // Limit concurrent HTTP requests
 	minMetaSizeBytes int64
 	maxRedirects     int
 
@@ -80,9 +80,13 @@ const (
 )
 
 // NewFileSizeResolver creates a new file size resolver
+// NewFileSizeResolver creates a resolver. maxWorkers <= 0 uses the shared
+// size_resolve pool sized by config.Concurrency; a positive value gives the
+// resolver a private pool of that size.
 func NewFileSizeResolver(cfg *config.Config, fileDAO *dao.FileDAO, metaStore FileMetaStore, maxWorkers int, minMetaSizeBytes int64, maxRedirects int) *FileSizeResolver {
+	pool := workers.New(config.PoolSizeResolve, maxWorkers)
 	if maxWorkers <= 0 {
-		maxWorkers = 20
+		pool = workers.Shared(config.PoolSizeResolve, cfg.WorkerLimit(config.PoolSizeResolve))
 	}
 	if maxRedirects <= 0 {
 		maxRedirects = 2
@@ -91,8 +95,7 @@ func NewFileSizeResolver(cfg *config.Config, fileDAO *dao.FileDAO, metaStore Fil
 	return &FileSizeResolver{
 		fileDAO:          fileDAO,
 		metaStore:        metaStore,
-		semaphore:        make(chan struct{}, maxWorkers),
-		maxWorkers:       maxWorkers,
+		pool:             pool,
 		minMetaSizeBytes: minMetaSizeBytes,
 		client:           proxy.NewHTTPClient(cfg, 15*time.Second),
 		maxRedirects:     maxRedirects,
@@ -407,16 +410,15 @@ func (r *FileSizeResolver) tryHEADWithRetry(ctx context.Context, file FileItem,
 	}
 
 	// Acquire semaphore with timeout
-	select {
-	case r.semaphore <- struct{}{}:
-		defer func() { <-r.semaphore }()
-	case <-ctx.Done():
-		sendResult(SizeResult{Path: file.DisplayPath, Source: SourceHEAD, Error: ctx.Err()})
-		return
-	case <-time.After(5 * time.Second):
-		sendResult(SizeResult{Path: file.DisplayPath, Source: SourceHEAD, Error: ErrSemaphoreTimeout})
+	if !r.pool.AcquireTimeout(ctx, 5*time.Second) {
+		err := ctx.Err()
+		if err == nil {
+			err = ErrSemaphoreTimeout
+		}
+		sendResult(SizeResult{Path: file.DisplayPath, Source: SourceHEAD, Error: err})
 		return
 	}
+	defer r.pool.Release()
 
 	// Retry loop
 	var lastErr error
@@ -462,16 +464,15 @@ func (r *FileSizeResolver) tryRangeWithRetry(ctx context.Context, file FileItem,
 		return
 	}
 
-	select {
-	case r.semaphore <- struct{}{}:
-		defer func() { <-r.semaphore }()
-	case <-ctx.Done():
-		sendResult(SizeResult{Path: file.DisplayPath, Source: SourceRange, Error: ctx.Err()})
-		return
-	case <-time.After(5 * time.Second):
-		sendResult(SizeResult{Path: file.DisplayPath, Source: SourceRange, Error: ErrSemaphoreTimeout})
+	if !r.pool.AcquireTimeout(ctx, 5*time.Second) {
+		err := ctx.Err()
+		if err == nil {
+			err = ErrSemaphoreTimeout
+		}
+		sendResult(SizeResult{Path: file.DisplayPath, Source: SourceRange, Error: err})
 		return
 	}
+	defer r.pool.Release()
 
 	var lastErr error
 	for attempt := 0; attempt < 2; attempt++ {
@@ -914,7 +915,7 @@ func (r *FileSizeResolver) Stats() map[string]interface{} {
 		"circuit_breaks": circuitBreaks,
 		"hot_cache_hits": hotCacheHits,
 		"hit_rate":       hitRate,
-		"max_workers":    r.maxWorkers,
+		"max_workers":    r.pool.Size(),
 	}
 }
 
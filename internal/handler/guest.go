@@ -0,0 +1,431 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/dao"
+	"github.com/alist-encrypt-go/internal/pathutil"
+)
+
+const (
+	guestDefaultHours = 48
+	guestMaxHours     = 30 * 24
+	guestSessionIdle  = 24 * time.Hour
+	guestAuthTTL      = 30 * time.Minute
+	guestCookie       = "aeg_guest"
+)
+
+// guestSession is one browser or player using a guest code. Browsers are
+// told apart by a cookie scoped to the code; players that do not keep
+// cookies count by address.
+type guestSession struct {
+	RemoteIP  string    `json:"remote_ip"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Requests  int       `json:"requests"`
+}
+
+// GuestHandler serves /guest/{code}/: read-only browsing and streaming of
+// the directory a guest code was issued for, without a management login.
+// Listings and downloads go through the regular decrypting handlers with the
+// configured scan credentials, so guests see the same decrypted names and
+// plain sizes as the web UI.
+type GuestHandler struct {
+	cfg   *config.Config
+	codes *dao.GuestCodeDAO
+	alist *AlistHandler
+	proxy *ProxyHandler
+	audit *AuditLog
+
+	mu       sync.Mutex
+	sessions map[string]map[string]*guestSession  // code -> session key
+	streams  map[string]map[*guestStream]struct{} // code -> open downloads
+	auth     http.Header
+	authAt   time.Time
+}
+
+// NewGuestHandler creates the guest route handler.
+func NewGuestHandler(cfg *config.Config, codes *dao.GuestCodeDAO, alist *AlistHandler, proxy *ProxyHandler) *GuestHandler {
+	return &GuestHandler{
+		cfg:      cfg,
+		codes:    codes,
+		alist:    alist,
+		proxy:    proxy,
+		sessions: make(map[string]map[string]*guestSession),
+		streams:  make(map[string]map[*guestStream]struct{}),
+	}
+}
+
+// SetAuditLog records code creation and revocation in audit.
+func (g *GuestHandler) SetAuditLog(audit *AuditLog) {
+	g.audit = audit
+}
+
+type guestCodeView struct {
+	dao.GuestCode
+	URL      string          `json:"url"`
+	Sessions []*guestSession `json:"sessions"`
+}
+
+func (g *GuestHandler) view(r *http.Request, code dao.GuestCode) guestCodeView {
+	v := guestCodeView{GuestCode: code, URL: requestOrigin(r) + "/guest/" + code.Code + "/", Sessions: []*guestSession{}}
+	g.mu.Lock()
+	for _, s := range g.sessions[code.Code] {
+		cp := *s
+		v.Sessions = append(v.Sessions, &cp)
+	}
+	g.mu.Unlock()
+	sort.Slice(v.Sessions, func(i, j int) bool { return v.Sessions[i].LastSeen.After(v.Sessions[j].LastSeen) })
+	return v
+}
+
+// HandleCodes serves /enc-api/guestCodes: GET lists the active codes with
+// their sessions, POST {"path","hours","note"} creates one.
+func (g *GuestHandler) HandleCodes(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		codes, err := g.codes.List()
+		if err != nil {
+			RespondAPIError(w, 500, err.Error())
+			return
+		}
+		views := make([]guestCodeView, 0, len(codes))
+		for _, code := range codes {
+			views = append(views, g.view(r, code))
+		}
+		RespondSuccess(w, map[string]interface{}{"codes": views})
+		return
+	}
+	var req struct {
+		Path  string `json:"path"`
+		Hours int    `json:"hours"`
+		Note  string `json:"note"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		RespondAPIError(w, 400, "Invalid request: "+err.Error())
+		return
+	}
+	if strings.TrimSpace(req.Path) == "" {
+		RespondAPIError(w, 400, "path is required")
+		return
+	}
+	if req.Hours <= 0 {
+		req.Hours = guestDefaultHours
+	}
+	if req.Hours > guestMaxHours {
+		RespondAPIError(w, 400, "hours must be at most "+strconv.Itoa(guestMaxHours))
+		return
+	}
+	dir := pathutil.CleanInput(req.Path)
+	code, err := g.codes.Create(dir, req.Note, AuditActor(r), time.Duration(req.Hours)*time.Hour)
+	if err != nil {
+		RespondAPIError(w, 500, err.Error())
+		return
+	}
+	g.audit.RecordRequest(r, "guest.create", dir, code.Code)
+	RespondSuccess(w, g.view(r, *code))
+}
+
+// HandleRevoke serves POST /enc-api/guestCodes/revoke {"code"}. The code
+// stops working at once: downloads still open on it are cancelled, so
+// players in the middle of a file are cut off too.
+func (g *GuestHandler) HandleRevoke(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		RespondAPIError(w, 400, "Invalid request: "+err.Error())
+		return
+	}
+	code := strings.ToLower(strings.TrimSpace(req.Code))
+	if err := g.codes.Delete(code); err != nil {
+		if errors.Is(err, dao.ErrGuestCodeNotFound) {
+			RespondAPIError(w, 404, "guest code not found")
+			return
+		}
+		RespondAPIError(w, 500, err.Error())
+		return
+	}
+	g.mu.Lock()
+	delete(g.sessions, code)
+	for s := range g.streams[code] {
+		s.cancel()
+	}
+	delete(g.streams, code)
+	g.mu.Unlock()
+	g.audit.RecordRequest(r, "guest.revoke", "", code)
+	RespondSuccess(w, nil)
+}
+
+// HandleGuest serves GET and HEAD on /guest/{code}/{path}: paths ending in
+// "/" list a directory, anything else streams the decrypted file.
+func (g *GuestHandler) HandleGuest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		RespondHTTPErrorWithStatus(w, "Guest access is read-only", http.StatusMethodNotAllowed)
+		return
+	}
+	rest := strings.TrimPrefix(r.URL.Path, "/guest/")
+	codeText, sub, hasSlash := strings.Cut(rest, "/")
+	code, err := g.codes.Get(strings.ToLower(codeText))
+	if err != nil {
+		g.forget(strings.ToLower(codeText))
+		writeGuestMessage(w, http.StatusNotFound, "This link has expired or was revoked.")
+		return
+	}
+	if !hasSlash {
+		http.Redirect(w, r, "/guest/"+code.Code+"/", http.StatusMovedPermanently)
+		return
+	}
+	displayPath := path.Join(code.Path, "/"+sub)
+	if !pathutil.Within(displayPath, code.Path) {
+		writeGuestMessage(w, http.StatusNotFound, "Not found.")
+		return
+	}
+	g.touch(w, r, code)
+
+	if sub == "" || strings.HasSuffix(sub, "/") {
+		g.serveIndex(w, r, code, displayPath)
+		return
+	}
+	g.serveFile(w, r, code.Code, displayPath)
+}
+
+// touch records the request on the caller's session, issuing a session
+// cookie to browsers on their first visit.
+func (g *GuestHandler) touch(w http.ResponseWriter, r *http.Request, code *dao.GuestCode) {
+	key := ""
+	if c, err := r.Cookie(guestCookie); err == nil && c.Value != "" {
+		key = "s:" + c.Value
+	} else if r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html") {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err == nil {
+			id := hex.EncodeToString(b)
+			http.SetCookie(w, &http.Cookie{
+				Name:     guestCookie,
+				Value:    id,
+				Path:     "/guest/" + code.Code + "/",
+				Expires:  code.ExpiresAt,
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+			})
+			key = "s:" + id
+		}
+	}
+	ip := remoteIP(r)
+	if key == "" {
+		key = "ip:" + ip
+	}
+	now := time.Now()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	sessions := g.sessions[code.Code]
+	if sessions == nil {
+		sessions = make(map[string]*guestSession)
+		g.sessions[code.Code] = sessions
+	}
+	for k, s := range sessions {
+		if now.Sub(s.LastSeen) > guestSessionIdle {
+			delete(sessions, k)
+		}
+	}
+	s := sessions[key]
+	if s == nil {
+		s = &guestSession{FirstSeen: now, UserAgent: r.UserAgent()}
+		sessions[key] = s
+		log.Info().Str("path", code.Path).Str("remote", ip).Msg("Guest session started")
+	}
+	s.RemoteIP = ip
+	s.LastSeen = now
+	s.Requests++
+}
+
+func (g *GuestHandler) forget(code string) {
+	g.mu.Lock()
+	delete(g.sessions, code)
+	g.mu.Unlock()
+}
+
+// upstreamAuth returns the scan credentials for Alist, reusing a fetched
+// token for guestAuthTTL rather than logging in on every request.
+func (g *GuestHandler) upstreamAuth() http.Header {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.auth) == 0 || time.Since(g.authAt) > guestAuthTTL {
+		g.auth = g.alist.scanAuthHeaders()
+		g.authAt = time.Now()
+	}
+	return g.auth.Clone()
+}
+
+// guestRequest turns a guest request into an internal one for the regular
+// handlers: the guest's own cookies and credentials are dropped and the scan
+// credentials used instead.
+func (g *GuestHandler) guestRequest(r *http.Request, method, urlPath string, body []byte) *http.Request {
+	req := r.Clone(r.Context())
+	req.Method = method
+	req.URL = &url.URL{Path: urlPath}
+	req.RequestURI = ""
+	req.Header.Del("Cookie")
+	req.Header.Del("Authorization")
+	req.Header.Del("Authorizetoken")
+	for key, values := range g.upstreamAuth() {
+		req.Header[key] = values
+	}
+	if body != nil {
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Del("Range")
+		req.Header.Del("Accept-Encoding")
+	}
+	return req
+}
+
+// guestStream is a download open on a guest code, cancelled when the code
+// is revoked.
+type guestStream struct {
+	cancel context.CancelFunc
+}
+
+func (g *GuestHandler) serveFile(w http.ResponseWriter, r *http.Request, code, displayPath string) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	stream := &guestStream{cancel: cancel}
+	g.mu.Lock()
+	if g.streams[code] == nil {
+		g.streams[code] = make(map[*guestStream]struct{})
+	}
+	g.streams[code][stream] = struct{}{}
+	g.mu.Unlock()
+	defer g.endStream(code, stream)
+
+	// A revoke between the code lookup and the registration above would
+	// have missed this stream.
+	if _, err := g.codes.Get(code); err != nil {
+		writeGuestMessage(w, http.StatusNotFound, "This link has expired or was revoked.")
+		return
+	}
+	g.proxy.HandleDownload(w, g.guestRequest(r.WithContext(ctx), r.Method, "/d"+displayPath, nil))
+}
+
+func (g *GuestHandler) endStream(code string, stream *guestStream) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	streams := g.streams[code]
+	delete(streams, stream)
+	if len(streams) == 0 {
+		delete(g.streams, code)
+	}
+}
+
+var guestIndexTemplate = template.Must(template.New("guest").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>{{.Title}}</title>
+<style>body{font-family:sans-serif;margin:2em}td{padding:2px 1.5em 2px 0}td.size{text-align:right;font-family:monospace}.note{color:#666}</style>
+</head><body>
+<h1>{{.Title}}</h1>
+{{if .Note}}<p class="note">{{.Note}}</p>{{end}}
+<table>
+{{if .Parent}}<tr><td><a href="{{.Parent}}">../</a></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{.Href}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td><td class="size">{{.Size}}</td></tr>
+{{end}}</table>
+<p class="note">Shared until {{.Expires}}.</p>
+</body></html>
+`))
+
+func (g *GuestHandler) serveIndex(w http.ResponseWriter, r *http.Request, code *dao.GuestCode, displayPath string) {
+	body, _ := json.Marshal(map[string]interface{}{"path": displayPath, "page": 1, "per_page": 0, "refresh": false})
+	rec := newStagedUploadRecorder()
+	g.alist.HandleFsList(rec, g.guestRequest(r, http.MethodPost, "/api/fs/list", body))
+	var resp struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Data    struct {
+			Content []struct {
+				Name  string `json:"name"`
+				Size  int64  `json:"size"`
+				IsDir bool   `json:"is_dir"`
+			} `json:"content"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.body.Bytes(), &resp); err != nil || resp.Code != http.StatusOK {
+		log.Warn().Int("status", rec.status).Int("code", resp.Code).Str("message", resp.Message).Str("path", displayPath).Msg("Guest listing failed")
+		writeGuestMessage(w, http.StatusBadGateway, "This folder cannot be listed right now.")
+		return
+	}
+
+	base := "/guest/" + code.Code
+	rel := strings.TrimPrefix(displayPath, strings.TrimSuffix(code.Path, "/"))
+	var entries []webdavIndexEntry
+	for _, item := range resp.Data.Content {
+		if item.Name == "" {
+			continue
+		}
+		href := (&url.URL{Path: base + path.Join("/", rel, item.Name)}).EscapedPath()
+		size := ""
+		if item.IsDir {
+			href += "/"
+		} else {
+			size = strconv.FormatInt(item.Size, 10)
+		}
+		entries = append(entries, webdavIndexEntry{Href: href, Name: item.Name, Size: size, IsDir: item.IsDir})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].IsDir != entries[j].IsDir {
+			return entries[i].IsDir
+		}
+		return strings.ToLower(entries[i].Name) < strings.ToLower(entries[j].Name)
+	})
+	parent := ""
+	if displayPath != code.Path {
+		parent = (&url.URL{Path: base + path.Join("/", path.Dir(rel))}).EscapedPath()
+		if !strings.HasSuffix(parent, "/") {
+			parent += "/"
+		}
+	}
+	var page bytes.Buffer
+	if err := guestIndexTemplate.Execute(&page, map[string]interface{}{
+		"Title":   path.Base(code.Path) + rel,
+		"Note":    code.Note,
+		"Parent":  parent,
+		"Entries": entries,
+		"Expires": code.ExpiresAt.Format("2006-01-02 15:04"),
+	}); err != nil {
+		log.Error().Err(err).Str("path", displayPath).Msg("Guest index render failed")
+		RespondHTTPErrorWithStatus(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(page.Len()))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(page.Bytes())
+	}
+}
+
+func writeGuestMessage(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	_ = template.Must(template.New("msg").Parse(`<!DOCTYPE html><html><head><meta charset="utf-8"><title>Guest access</title></head><body style="font-family:sans-serif;margin:2em"><p>{{.}}</p></body></html>`)).Execute(w, message)
+}
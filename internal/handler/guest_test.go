@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/dao"
+	"github.com/alist-encrypt-go/internal/storage"
+)
+
+func TestGuestCodeBrowsesOnlyItsDirectory(t *testing.T) {
+	var listAuth []string
+	var listed []string
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/fs/list":
+			var req struct {
+				Path string `json:"path"`
+			}
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			listAuth = append(listAuth, r.Header.Get("Authorization"))
+			listed = append(listed, req.Path)
+			content := []map[string]interface{}{
+				{"name": "Season 1", "is_dir": true},
+				{"name": "notes.txt", "size": 5},
+			}
+			writeJSONResponse(w, map[string]interface{}{"code": 200, "message": "success", "data": map[string]interface{}{"content": content, "total": len(content)}})
+		case "/api/fs/get":
+			http.NotFound(w, r)
+		case "/d/shows/Demo/notes.txt":
+			if r.Header.Get("Authorization") != "scan-token" {
+				t.Errorf("download authorization=%q", r.Header.Get("Authorization"))
+			}
+			http.ServeContent(w, r, "notes.txt", time.Time{}, strings.NewReader("hello"))
+		default:
+			t.Errorf("unexpected upstream path %s", r.URL.Path)
+			http.NotFound(w, r)
+		}
+	}))
+	defer backend.Close()
+
+	alist, _ := newTestAlistHandler(t, backend.URL, &config.PasswdInfo{Password: "p", EncType: "aesctr", Enable: true, EncPath: []string{"/private/*"}})
+	cfg := config.Get()
+	original := cfg.AlistServer.ScanAuthHeader
+	cfg.AlistServer.ScanAuthHeader = "scan-token"
+	t.Cleanup(func() { cfg.AlistServer.ScanAuthHeader = original })
+
+	store, err := storage.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	g := NewGuestHandler(cfg, dao.NewGuestCodeDAO(store), alist, alist.proxyHandler)
+
+	rr := httptest.NewRecorder()
+	g.HandleCodes(rr, httptest.NewRequest(http.MethodPost, "http://enc.example/enc-api/guestCodes", bytes.NewBufferString(`{"path":"/shows/Demo/","hours":2,"note":"weekend"}`)))
+	var created struct {
+		Data struct {
+			Code string `json:"code"`
+			Path string `json:"path"`
+			URL  string `json:"url"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &created); err != nil || created.Data.Code == "" {
+		t.Fatalf("create: %s", rr.Body.String())
+	}
+	code := created.Data.Code
+	if created.Data.Path != "/shows/Demo" || created.Data.URL != "http://enc.example/guest/"+code+"/" {
+		t.Fatalf("created %+v", created.Data)
+	}
+
+	get := func(method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		req.Header.Set("Accept", "text/html")
+		req.Header.Set("Authorization", "guest-supplied")
+		rr := httptest.NewRecorder()
+		g.HandleGuest(rr, req)
+		return rr
+	}
+
+	if rr := get(http.MethodGet, "/guest/"+code); rr.Code != http.StatusMovedPermanently {
+		t.Fatalf("bare code: %d", rr.Code)
+	}
+	rr = get(http.MethodGet, "/guest/"+code+"/")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("index %d: %s", rr.Code, rr.Body.String())
+	}
+	body := rr.Body.String()
+	if !strings.Contains(body, `href="/guest/`+code+`/Season%201/"`) || !strings.Contains(body, `href="/guest/`+code+`/notes.txt"`) || strings.Contains(body, "../") {
+		t.Fatalf("index body: %s", body)
+	}
+	if len(rr.Result().Cookies()) != 1 {
+		t.Fatalf("expected a session cookie")
+	}
+	if rr := get(http.MethodGet, "/guest/"+code+"/Season%201/"); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `href="/guest/`+code+`/"`) {
+		t.Fatalf("subdir %d: %s", rr.Code, rr.Body.String())
+	}
+	if listed[0] != "/shows/Demo" || listed[1] != "/shows/Demo/Season 1" || listAuth[0] != "scan-token" {
+		t.Fatalf("listed %v with %v", listed, listAuth)
+	}
+
+	if rr := get(http.MethodGet, "/guest/"+code+"/notes.txt"); rr.Code != http.StatusOK || rr.Body.String() != "hello" {
+		t.Fatalf("download %d: %q", rr.Code, rr.Body.String())
+	}
+	if rr := get(http.MethodGet, "/guest/"+code+"/../../private/"); rr.Code != http.StatusNotFound || len(listed) != 2 {
+		t.Fatalf("escape %d, listed %v", rr.Code, listed)
+	}
+	if rr := get(http.MethodPut, "/guest/"+code+"/notes.txt"); rr.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("put %d", rr.Code)
+	}
+
+	rr = httptest.NewRecorder()
+	g.HandleCodes(rr, httptest.NewRequest(http.MethodGet, "/enc-api/guestCodes", nil))
+	if !strings.Contains(rr.Body.String(), `"requests":`) {
+		t.Fatalf("list: %s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	g.HandleRevoke(rr, httptest.NewRequest(http.MethodPost, "/enc-api/guestCodes/revoke", bytes.NewBufferString(`{"code":"`+code+`"}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("revoke %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := get(http.MethodGet, "/guest/"+code+"/"); rr.Code != http.StatusNotFound {
+		t.Fatalf("revoked code still works: %d", rr.Code)
+	}
+}
+
+func TestGuestRevokeCancelsOpenDownloads(t *testing.T) {
+	started := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/fs/get":
+			http.NotFound(w, r)
+		case "/d/shows/Demo/movie.mkv":
+			w.Header().Set("Content-Length", "1048576")
+			_, _ = w.Write([]byte("first chunk"))
+			w.(http.Flusher).Flush()
+			close(started)
+			select {
+			case <-r.Context().Done():
+			case <-time.After(10 * time.Second):
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer backend.Close()
+
+	alist, _ := newTestAlistHandler(t, backend.URL, &config.PasswdInfo{Password: "p", EncType: "aesctr", Enable: true, EncPath: []string{"/private/*"}})
+	store, err := storage.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	codes := dao.NewGuestCodeDAO(store)
+	g := NewGuestHandler(config.Get(), codes, alist, alist.proxyHandler)
+	code, err := codes.Create("/shows/Demo", "", "admin", time.Hour)
+	if err != nil {
+		t.Fatalf("create code: %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g.HandleGuest(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/guest/"+code.Code+"/movie.mkv", nil))
+	}()
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("download never reached upstream")
+	}
+
+	rr := httptest.NewRecorder()
+	g.HandleRevoke(rr, httptest.NewRequest(http.MethodPost, "/enc-api/guestCodes/revoke", bytes.NewBufferString(`{"code":"`+code.Code+`"}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("revoke %d: %s", rr.Code, rr.Body.String())
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("open download kept running after revoke")
+	}
+}
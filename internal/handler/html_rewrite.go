@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+// htmlResourceAttr matches attributes that load or link a resource, with their
+// quoted value. og:/twitter: meta content is left alone: it must stay absolute.
+var htmlResourceAttr = regexp.MustCompile(`(?i)(\s(?:src|href|srcset|action|poster|data-src)\s*=\s*)("[^"]*"|'[^']*')`)
+
+// cssURL matches url(...) references in inline styles and <style> blocks.
+var cssURL = regexp.MustCompile(`(?i)(url\(\s*)("[^"]*"|'[^']*'|[^)'"\s]+)`)
+
+// absoluteURLStart matches a scheme-qualified or protocol-relative URL at the
+// start of a value or of a srcset candidate; group 2 is the host.
+var absoluteURLStart = regexp.MustCompile(`(?i)(^\s*|,\s*)(?:https?:)?//([^/\s?#,'"]+)`)
+
+// htmlRewriteHosts returns the hosts whose absolute URLs become proxy-relative
+// in proxied HTML, or nil when the rewrite is off.
+func htmlRewriteHosts(server config.AlistServer, upstreamBaseURL string) map[string]bool {
+	if !server.RewriteHTMLURLs {
+		return nil
+	}
+	hosts := make(map[string]bool)
+	if parsed, err := url.Parse(strings.TrimSpace(upstreamBaseURL)); err == nil && parsed.Host != "" {
+		hosts[strings.ToLower(parsed.Host)] = true
+	}
+	for _, host := range server.HTMLRewriteHosts {
+		hosts[host] = true
+	}
+	return hosts
+}
+
+// rewriteHTMLResourceURLs turns absolute URLs pointing at one of hosts into
+// root-relative ones inside resource attributes and CSS url() references, so
+// assets load through the proxy whatever Alist's site_url says. URLs that only
+// appear inside another URL (e.g. ?redirect=https://...) are kept.
+func rewriteHTMLResourceURLs(body []byte, hosts map[string]bool) []byte {
+	if len(hosts) == 0 || len(body) == 0 {
+		return body
+	}
+	rewrite := func(re *regexp.Regexp) func([]byte) []byte {
+		return func(match []byte) []byte {
+			parts := re.FindSubmatch(match)
+			value := string(parts[2])
+			quote := ""
+			if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') {
+				quote, value = value[:1], value[1:len(value)-1]
+			}
+			rewritten := relativizeURLs(value, hosts)
+			if rewritten == value {
+				return match
+			}
+			out := append([]byte{}, parts[1]...)
+			return append(out, quote+rewritten+quote...)
+		}
+	}
+	out := htmlResourceAttr.ReplaceAllFunc(body, rewrite(htmlResourceAttr))
+	return cssURL.ReplaceAllFunc(out, rewrite(cssURL))
+}
+
+func relativizeURLs(value string, hosts map[string]bool) string {
+	matches := absoluteURLStart.FindAllStringSubmatchIndex(value, -1)
+	if matches == nil {
+		return value
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range matches {
+		if !hosts[strings.ToLower(value[m[4]:m[5]])] {
+			continue
+		}
+		b.WriteString(value[last:m[3]])
+		if m[1] == len(value) || value[m[1]] != '/' {
+			b.WriteByte('/')
+		}
+		last = m[1]
+	}
+	b.WriteString(value[last:])
+	return b.String()
+}
@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+func TestRewriteHTMLResourceURLs(t *testing.T) {
+	hosts := map[string]bool{"alist.example.com": true, "alist:5244": true}
+	cases := map[string]string{
+		`<script src="https://alist.example.com/assets/index.js"></script>`:                     `<script src="/assets/index.js"></script>`,
+		`<link rel="icon" href='//ALIST.example.com/favicon.ico'>`:                              `<link rel="icon" href='/favicon.ico'>`,
+		`<a href="http://alist:5244">home</a>`:                                                  `<a href="/">home</a>`,
+		`<img srcset="https://alist.example.com/a.png 1x, https://alist.example.com/b.png 2x">`: `<img srcset="/a.png 1x, /b.png 2x">`,
+		`<div style="background:url(https://alist.example.com/bg.jpg)"></div>`:                  `<div style="background:url(/bg.jpg)"></div>`,
+		`<style>.x{background:url('http://alist:5244/x.png')}</style>`:                          `<style>.x{background:url('/x.png')}</style>`,
+		// Other hosts, embedded URLs and og: metadata stay as they are.
+		`<script src="https://cdn.example.net/app.js"></script>`:                   `<script src="https://cdn.example.net/app.js"></script>`,
+		`<a href="https://sso.example.net/?next=https://alist.example.com/">x</a>`: `<a href="https://sso.example.net/?next=https://alist.example.com/">x</a>`,
+		`<meta property="og:image" content="https://alist.example.com/logo.png">`:  `<meta property="og:image" content="https://alist.example.com/logo.png">`,
+	}
+	for in, want := range cases {
+		if got := string(rewriteHTMLResourceURLs([]byte(in), hosts)); got != want {
+			t.Errorf("rewrite(%s)\n got %s\nwant %s", in, got, want)
+		}
+	}
+	if got := string(rewriteHTMLResourceURLs([]byte(`<a href="https://alist.example.com/">`), nil)); got != `<a href="https://alist.example.com/">` {
+		t.Fatalf("rewrite without hosts changed body: %s", got)
+	}
+}
+
+func TestHandleProxyRewritesHTMLForSiteURL(t *testing.T) {
+	const page = `<html><head><script src="https://alist.example.com/assets/index.js"></script></head></html>`
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(page))
+	}))
+	t.Cleanup(upstream.Close)
+	parsed, _ := url.Parse(upstream.URL)
+	port, _ := strconv.Atoi(parsed.Port())
+
+	for _, enabled := range []bool{false, true} {
+		cfg := config.DefaultConfig()
+		cfg.AlistServer.ServerHost = parsed.Hostname()
+		cfg.AlistServer.ServerPort = port
+		cfg.AlistServer.RewriteHTMLURLs = enabled
+		cfg.AlistServer.HTMLRewriteHosts = config.NormalizeHTMLRewriteHosts([]string{"https://alist.example.com/"})
+
+		rec := httptest.NewRecorder()
+		newTestProxyHandler(t, cfg).HandleProxy(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		rewritten := strings.Contains(rec.Body.String(), `src="/assets/index.js"`)
+		if rec.Code != http.StatusOK || rewritten != enabled {
+			t.Fatalf("enabled=%v: status=%d body=%s", enabled, rec.Code, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,489 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif" // register the GIF decoder
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/dao"
+	"github.com/alist-encrypt-go/internal/errors"
+	"github.com/alist-encrypt-go/internal/trace"
+	"github.com/alist-encrypt-go/internal/workers"
+)
+
+const (
+	imageMaxDimension     = 4096
+	imageMaxSourcePixels  = 64 * 1024 * 1024 // refuse decompression bombs
+	imageDefaultQuality   = 80
+	imageCacheSubdir      = "img-cache"
+	imagePruneLowWaterPct = 90
+)
+
+// imageVariant describes one requested rendition of a source image.
+type imageVariant struct {
+	Width   int
+	Height  int
+	Quality int
+}
+
+// ImageResizer serves /img/<path>?w=&h=&q= by fetching the decrypted original
+// through the regular download handler, scaling it down and caching the
+// result on disk. Variants are keyed by the caller's auth scope so a cache
+// hit never shows one user's image to another.
+type ImageResizer struct {
+	cfg        *config.Config
+	fileDAO    *dao.FileDAO
+	source     http.HandlerFunc
+	dir        string
+	maxBytes   atomic.Int64
+	pool       *workers.Pool
+	group      singleflight.Group // dedupes concurrent renders of one variant
+	pruning    atomic.Bool
+	sizeKnown  atomic.Bool // cacheBytes was measured by a prune walk
+	cacheBytes atomic.Int64
+	hits       uint64
+	generated  uint64
+	failures   uint64
+}
+
+// NewImageResizer creates a resizer whose originals come from source (the
+// /d download handler) and whose variants are cached under dataDir.
+func NewImageResizer(cfg *config.Config, fileDAO *dao.FileDAO, source http.HandlerFunc, dataDir string) *ImageResizer {
+	ir := &ImageResizer{
+		cfg:     cfg,
+		fileDAO: fileDAO,
+		source:  source,
+		dir:     filepath.Join(dataDir, imageCacheSubdir),
+		pool:    workers.Shared(config.PoolImageResize, cfg.WorkerLimit(config.PoolImageResize)),
+	}
+	ir.ApplyConfig(cfg)
+	return ir
+}
+
+// ApplyConfig picks up a changed imageCacheMb; a smaller cache is trimmed by
+// the next prune.
+func (ir *ImageResizer) ApplyConfig(cfg *config.Config) {
+	cacheMb := 512
+	if cfg != nil && cfg.AlistServer.ImageCacheMb > 0 {
+		cacheMb = cfg.AlistServer.ImageCacheMb
+	}
+	ir.maxBytes.Store(int64(cacheMb) * 1024 * 1024)
+}
+
+// Stats returns cache hit and generation counters.
+func (ir *ImageResizer) Stats() map[string]interface{} {
+	return map[string]interface{}{
+		"hits":      atomic.LoadUint64(&ir.hits),
+		"generated": atomic.LoadUint64(&ir.generated),
+		"failures":  atomic.LoadUint64(&ir.failures),
+	}
+}
+
+// HandleImage handles GET/HEAD /img/*path.
+func (ir *ImageResizer) HandleImage(w http.ResponseWriter, r *http.Request) {
+	if ir.cfg == nil || !ir.cfg.AlistServer.EnableImageResize {
+		RespondCodedError(w, errors.CodeNotFound, "image resizing is disabled", http.StatusNotFound)
+		return
+	}
+	displayPath := path.Clean("/" + strings.TrimPrefix(r.URL.Path, "/img"))
+	if displayPath == "/" {
+		RespondCodedError(w, errors.CodeBadRequest, "missing image path", http.StatusBadRequest)
+		return
+	}
+	variant, err := parseImageVariant(r.URL.Query())
+	if err != nil {
+		RespondCodedError(w, errors.CodeBadRequest, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w = withCacheControl(w, cacheControlFor(ir.cfg, config.CacheTargetFile, displayPath))
+	authHeaders := make(http.Header)
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		authHeaders.Set("Authorization", auth)
+	}
+	if cookie := r.Header.Get("Cookie"); cookie != "" {
+		authHeaders.Set("Cookie", cookie)
+	}
+	authHash := authScopeHash(authHeaders)
+
+	if key, ok := ir.cacheKey(displayPath, authHash, variant); ok {
+		if ir.serveCached(w, r, key, displayPath) {
+			atomic.AddUint64(&ir.hits, 1)
+			return
+		}
+	}
+
+	flightKey := fmt.Sprintf("%s\n%s\n%dx%d@%d", authHash, displayPath, variant.Width, variant.Height, variant.Quality)
+	result, err, _ := ir.group.Do(flightKey, func() (interface{}, error) {
+		if err := ir.pool.Acquire(r.Context()); err != nil {
+			return nil, err
+		}
+		defer ir.pool.Release()
+		src, err := ir.fetchSource(r, displayPath)
+		if err != nil {
+			return nil, err
+		}
+		return resizeImage(src, variant)
+	})
+	if err != nil {
+		atomic.AddUint64(&ir.failures, 1)
+		trace.Logf(r.Context(), "image", "Resize failed for %s: %v", displayPath, err)
+		RespondCodedError(w, errors.CodeDecryptFailed, "failed to render image: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	data := result.([]byte)
+	atomic.AddUint64(&ir.generated, 1)
+
+	// The download populated the file cache, so a key can be derived now
+	// even if it could not before.
+	if key, ok := ir.cacheKey(displayPath, authHash, variant); ok {
+		ir.store(key, data)
+		if ir.serveCached(w, r, key, displayPath) {
+			return
+		}
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(data))
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+}
+
+func parseImageVariant(q map[string][]string) (imageVariant, error) {
+	v := imageVariant{Quality: imageDefaultQuality}
+	parse := func(name string, min, max int) (int, error) {
+		vals := q[name]
+		if len(vals) == 0 || vals[0] == "" {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(vals[0])
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("%s must be between %d and %d", name, min, max)
+		}
+		return n, nil
+	}
+	var err error
+	if v.Width, err = parse("w", 1, imageMaxDimension); err != nil {
+		return v, err
+	}
+	if v.Height, err = parse("h", 1, imageMaxDimension); err != nil {
+		return v, err
+	}
+	quality, err := parse("q", 1, 100)
+	if err != nil {
+		return v, err
+	}
+	if quality > 0 {
+		v.Quality = quality
+	}
+	return v, nil
+}
+
+// cacheKey identifies a variant of the current version of displayPath. It
+// needs the cached file size and modification time; without them a changed
+// original could not be told apart from the cached one.
+func (ir *ImageResizer) cacheKey(displayPath, authHash string, v imageVariant) (string, bool) {
+	if ir.fileDAO == nil {
+		return "", false
+	}
+	info, ok := ir.fileDAO.Get(displayPath)
+	if !ok || info == nil || info.Size <= 0 {
+		return "", false
+	}
+	raw := fmt.Sprintf("%s\n%d\n%d\n%s\n%dx%d@%d", displayPath, info.Size, info.Modified.Unix(), authHash, v.Width, v.Height, v.Quality)
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:]), true
+}
+
+func (ir *ImageResizer) cachePath(key string) string {
+	return filepath.Join(ir.dir, key[:2], key)
+}
+
+func (ir *ImageResizer) serveCached(w http.ResponseWriter, r *http.Request, key, displayPath string) bool {
+	p := ir.cachePath(key)
+	f, err := os.Open(p)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	now := time.Now()
+	_ = os.Chtimes(p, now, now) // keep recently used variants at the back of the prune order
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return false
+	}
+	w.Header().Set("Content-Type", http.DetectContentType(head[:n]))
+	w.Header().Set("ETag", `"`+key[:32]+`"`)
+	http.ServeContent(w, r, path.Base(displayPath), st.ModTime(), f)
+	return true
+}
+
+func (ir *ImageResizer) store(key string, data []byte) {
+	p := ir.cachePath(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		log.Warn().Err(err).Str("path", p).Msg("Failed to cache image variant")
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), key[:8]+"-*.tmp")
+	if err != nil {
+		log.Warn().Err(err).Str("path", p).Msg("Failed to cache image variant")
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), p)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		log.Warn().Err(err).Str("path", p).Msg("Failed to cache image variant")
+		return
+	}
+	total := ir.cacheBytes.Add(int64(len(data)))
+	if (!ir.sizeKnown.Load() || total > ir.maxBytes.Load()) && ir.pruning.CompareAndSwap(false, true) {
+		go func() {
+			defer ir.pruning.Store(false)
+			ir.prune()
+		}()
+	}
+}
+
+// prune measures the cache and, when it exceeds its budget, deletes the least
+// recently used variants down to imagePruneLowWaterPct of it.
+func (ir *ImageResizer) prune() {
+	type entry struct {
+		path string
+		size int64
+		mod  time.Time
+	}
+	var entries []entry
+	var total int64
+	_ = filepath.Walk(ir.dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return nil
+		}
+		entries = append(entries, entry{p, info.Size(), info.ModTime()})
+		total += info.Size()
+		return nil
+	})
+	defer func() {
+		ir.cacheBytes.Store(total)
+		ir.sizeKnown.Store(true)
+	}()
+	if total <= ir.maxBytes.Load() {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].mod.Before(entries[j].mod) })
+	target := ir.maxBytes.Load() * imagePruneLowWaterPct / 100
+	removed := 0
+	for _, e := range entries {
+		if total <= target {
+			break
+		}
+		if os.Remove(e.path) == nil {
+			total -= e.size
+			removed++
+		}
+	}
+	log.Info().Int("removed", removed).Int64("bytes", total).Msg("Pruned image variant cache")
+}
+
+// fetchSource runs the download handler for displayPath in-process and
+// returns the decrypted body, so auth, name and content decryption follow
+// exactly the /d path.
+func (ir *ImageResizer) fetchSource(r *http.Request, displayPath string) ([]byte, error) {
+	if ir.source == nil {
+		return nil, fmt.Errorf("no image source configured")
+	}
+	limit := int64(40) << 20
+	if ir.cfg.AlistServer.ImageMaxSourceMb > 0 {
+		limit = int64(ir.cfg.AlistServer.ImageMaxSourceMb) << 20
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Minute)
+	defer cancel()
+	src := r.Clone(ctx)
+	src.Method = http.MethodGet
+	src.URL.Path = "/d" + displayPath
+	src.URL.RawPath = ""
+	query := src.URL.Query()
+	query.Del("w")
+	query.Del("h")
+	query.Del("q")
+	src.URL.RawQuery = query.Encode()
+	src.RequestURI = src.URL.RequestURI()
+	for _, h := range []string{"Range", "If-Range", "If-None-Match", "If-Modified-Since"} {
+		src.Header.Del(h)
+	}
+
+	buf := &imageSourceWriter{header: make(http.Header), limit: limit}
+	ir.source(buf, src)
+	if buf.overflow {
+		return nil, fmt.Errorf("source image exceeds %d MB", limit>>20)
+	}
+	if buf.status != 0 && buf.status != http.StatusOK {
+		return nil, fmt.Errorf("source returned status %d", buf.status)
+	}
+	return buf.body.Bytes(), nil
+}
+
+// imageSourceWriter buffers a download response up to limit bytes.
+type imageSourceWriter struct {
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	limit    int64
+	overflow bool
+}
+
+func (w *imageSourceWriter) Header() http.Header { return w.header }
+
+func (w *imageSourceWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *imageSourceWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if int64(w.body.Len()+len(p)) > w.limit {
+		w.overflow = true
+		return 0, fmt.Errorf("image source too large")
+	}
+	return w.body.Write(p)
+}
+
+// resizeImage decodes a JPEG, PNG or GIF (first frame), scales it down to fit
+// within v.Width x v.Height keeping the aspect ratio, and re-encodes it.
+// Images are never enlarged. Opaque output is JPEG at v.Quality; images with
+// transparency stay PNG.
+func resizeImage(data []byte, v imageVariant) ([]byte, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("unsupported image: %w", err)
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > imageMaxSourcePixels {
+		return nil, fmt.Errorf("image too large: %dx%d", cfg.Width, cfg.Height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	dw, dh := fitDimensions(cfg.Width, cfg.Height, v.Width, v.Height)
+	dst := scaleBox(src, dw, dh)
+
+	var out bytes.Buffer
+	if format != "jpeg" && !isOpaque(dst) {
+		err = png.Encode(&out, dst)
+	} else {
+		err = jpeg.Encode(&out, dst, &jpeg.Options{Quality: v.Quality})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// fitDimensions returns the largest size within maxW x maxH (0 = unbounded,
+// capped at imageMaxDimension) with the aspect ratio of w x h.
+func fitDimensions(w, h, maxW, maxH int) (int, int) {
+	if maxW <= 0 || maxW > imageMaxDimension {
+		maxW = imageMaxDimension
+	}
+	if maxH <= 0 || maxH > imageMaxDimension {
+		maxH = imageMaxDimension
+	}
+	if w <= maxW && h <= maxH {
+		return w, h
+	}
+	scale := float64(maxW) / float64(w)
+	if s := float64(maxH) / float64(h); s < scale {
+		scale = s
+	}
+	dw, dh := int(float64(w)*scale+0.5), int(float64(h)*scale+0.5)
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+	return dw, dh
+}
+
+// scaleBox downsamples src to dw x dh by averaging every source pixel that
+// falls into each destination pixel.
+func scaleBox(src image.Image, dw, dh int) *image.NRGBA {
+	b := src.Bounds()
+	sw, sh := b.Dx(), b.Dy()
+	rgba := image.NewNRGBA(image.Rect(0, 0, sw, sh))
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Src)
+	if sw == dw && sh == dh {
+		return rgba
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := y*sh/dh, (y+1)*sh/dh
+		if y1 <= y0 {
+			y1 = y0 + 1
+		}
+		for x := 0; x < dw; x++ {
+			x0, x1 := x*sw/dw, (x+1)*sw/dw
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				row := rgba.Pix[sy*rgba.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					pa := uint64(p[3])
+					r += uint64(p[0]) * pa
+					g += uint64(p[1]) * pa
+					bl += uint64(p[2]) * pa
+					a += pa
+					n++
+				}
+			}
+			o := dst.Pix[y*dst.Stride+x*4:]
+			if a > 0 {
+				o[0], o[1], o[2] = uint8(r/a), uint8(g/a), uint8(bl/a)
+			}
+			o[3] = uint8(a / n)
+		}
+	}
+	return dst
+}
+
+func isOpaque(img *image.NRGBA) bool {
+	for i := 3; i < len(img.Pix); i += 4 {
+		if img.Pix[i] != 0xff {
+			return false
+		}
+	}
+	return true
+}
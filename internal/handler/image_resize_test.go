@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/dao"
+	"github.com/alist-encrypt-go/internal/storage"
+)
+
+func testPNG(t *testing.T, w, h int, alpha uint8) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: alpha})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestResizeImageFitsBoxAndKeepsAlpha(t *testing.T) {
+	out, err := resizeImage(testPNG(t, 400, 200, 0xff), imageVariant{Width: 100, Height: 100, Quality: 70})
+	if err != nil {
+		t.Fatalf("resize: %v", err)
+	}
+	img, format, err := image.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("decode output: %v", err)
+	}
+	if format != "jpeg" || img.Bounds().Dx() != 100 || img.Bounds().Dy() != 50 {
+		t.Fatalf("got %s %v, want jpeg 100x50", format, img.Bounds())
+	}
+
+	out, err = resizeImage(testPNG(t, 40, 40, 0x80), imageVariant{Width: 400, Quality: 70})
+	if err != nil {
+		t.Fatalf("resize: %v", err)
+	}
+	img, format, err = image.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("decode output: %v", err)
+	}
+	if format != "png" || img.Bounds().Dx() != 40 {
+		t.Fatalf("got %s %v, want unscaled png", format, img.Bounds())
+	}
+}
+
+func TestParseImageVariantRejectsOutOfRange(t *testing.T) {
+	if _, err := parseImageVariant(map[string][]string{"w": {"0"}}); err == nil {
+		t.Fatal("expected error for w=0")
+	}
+	if _, err := parseImageVariant(map[string][]string{"q": {"101"}}); err == nil {
+		t.Fatal("expected error for q=101")
+	}
+	v, err := parseImageVariant(map[string][]string{"h": {"300"}})
+	if err != nil || v.Height != 300 || v.Quality != imageDefaultQuality {
+		t.Fatalf("unexpected variant %+v err=%v", v, err)
+	}
+}
+
+func TestHandleImageCachesVariantOnDisk(t *testing.T) {
+	store, err := storage.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	defer store.Close()
+	fileDAO := dao.NewFileDAO(store)
+	original := testPNG(t, 300, 300, 0xff)
+
+	var sourceCalls int32
+	source := func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&sourceCalls, 1)
+		if r.URL.Path != "/d/photos/a.png" || r.URL.Query().Get("w") != "" || r.Header.Get("Range") != "" {
+			t.Errorf("unexpected source request %s range=%q", r.URL.String(), r.Header.Get("Range"))
+		}
+		_ = fileDAO.Set(&dao.FileInfo{Path: "/photos/a.png", Name: "a.png", Size: int64(len(original)), Modified: time.Unix(1700000000, 0)})
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(original)
+	}
+	cfg := config.DefaultConfig()
+	cfg.AlistServer.EnableImageResize = true
+	ir := NewImageResizer(cfg, fileDAO, source, t.TempDir())
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/img/photos/a.png?w=120&sign=abc", nil)
+		req.Header.Set("Range", "bytes=0-")
+		ir.HandleImage(rec, req)
+		if rec.Code != http.StatusOK && rec.Code != http.StatusPartialContent {
+			t.Fatalf("request %d: status %d body=%s", i, rec.Code, rec.Body.String())
+		}
+		img, err := jpeg.Decode(bytes.NewReader(rec.Body.Bytes()))
+		if err != nil || img.Bounds().Dx() != 120 {
+			t.Fatalf("request %d: bad variant err=%v", i, err)
+		}
+	}
+	if got := atomic.LoadInt32(&sourceCalls); got != 1 {
+		t.Fatalf("expected second request to hit the disk cache, source called %d times", got)
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/img/photos/a.png?w=120", nil)
+	req.Header.Set("Authorization", "other-user")
+	ir.HandleImage(rec, req)
+	if got := atomic.LoadInt32(&sourceCalls); got != 2 {
+		t.Fatalf("expected a different auth scope to miss the cache, source called %d times", got)
+	}
+}
@@ -0,0 +1,406 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/httputil"
+	"github.com/alist-encrypt-go/internal/pathutil"
+	"github.com/alist-encrypt-go/internal/workers"
+)
+
+const ingestMaxJobs = 50
+
+// IngestStatus is the progress of an ingest job as reported by
+// /enc-api/ingest/status. Status takes the re-encryption job states.
+type IngestStatus struct {
+	ID         string    `json:"id"`
+	URL        string    `json:"url"`
+	Path       string    `json:"path"` // display path of the stored file
+	Status     string    `json:"status"`
+	TotalBytes int64     `json:"totalBytes"`
+	DoneBytes  int64     `json:"doneBytes"`
+	Percent    float64   `json:"percent"`
+	Error      string    `json:"error,omitempty"`
+	CreatedAt  time.Time `json:"createdAt"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+}
+
+// IngestJob downloads a URL and stores it, encrypted, in an encrypted
+// folder. The body streams from the source through the folder's upload
+// pipeline into a staged upload, so a failed transfer leaves no partial file
+// behind. Only a source that does not announce its length touches local
+// disk.
+type IngestJob struct {
+	IngestStatus
+
+	dir    string
+	name   string
+	auth   http.Header
+	cancel context.CancelFunc
+	mu     sync.Mutex
+}
+
+func (j *IngestJob) snapshot() IngestStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status := j.IngestStatus
+	status.Percent = calcPercent(j.DoneBytes, j.TotalBytes)
+	return status
+}
+
+func (j *IngestJob) update(fn func(j *IngestJob)) {
+	j.mu.Lock()
+	fn(j)
+	j.UpdatedAt = time.Now()
+	j.mu.Unlock()
+}
+
+func (j *IngestJob) finished() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.Status == ReencryptDone || j.Status == ReencryptError || j.Status == ReencryptCanceled
+}
+
+// ingestJobStore keeps the most recent jobs in memory.
+type ingestJobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*IngestJob
+}
+
+var ingestJobs = &ingestJobStore{jobs: make(map[string]*IngestJob)}
+
+func (s *ingestJobStore) add(job *IngestJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = job
+	if len(s.jobs) <= ingestMaxJobs {
+		return
+	}
+	var oldest *IngestJob
+	for _, j := range s.jobs {
+		if j.finished() && (oldest == nil || j.CreatedAt.Before(oldest.CreatedAt)) {
+			oldest = j
+		}
+	}
+	if oldest != nil {
+		delete(s.jobs, oldest.ID)
+	}
+}
+
+func (s *ingestJobStore) get(id string) *IngestJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.jobs[id]
+}
+
+func (s *ingestJobStore) list() []IngestStatus {
+	s.mu.Lock()
+	jobs := make([]*IngestJob, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, j)
+	}
+	s.mu.Unlock()
+	out := make([]IngestStatus, 0, len(jobs))
+	for _, j := range jobs {
+		out = append(out, j.snapshot())
+	}
+	sort.Slice(out, func(i, k int) bool { return out[i].CreatedAt.After(out[k].CreatedAt) })
+	return out
+}
+
+// HandleIngestStart serves POST /enc-api/ingest. The body names a public
+// http(s) URL and the encrypted folder to store it in; name defaults to the
+// source's Content-Disposition filename or the last URL segment. A file of
+// the same name is replaced. The Alist token is taken from X-Alist-Token,
+// falling back to the configured scan credentials.
+func (h *AlistHandler) HandleIngestStart(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL  string `json:"url"`
+		Path string `json:"path"`
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		RespondAPIError(w, 400, "Invalid request")
+		return
+	}
+	source, err := url.Parse(strings.TrimSpace(req.URL))
+	if err != nil || (source.Scheme != "http" && source.Scheme != "https") || source.Host == "" {
+		RespondAPIError(w, 400, "url must be an http or https URL")
+		return
+	}
+	dir := strings.TrimSpace(req.Path)
+	if dir == "" {
+		RespondAPIError(w, 400, "path is required")
+		return
+	}
+	dir = pathutil.CleanInput(dir)
+	if !h.passwdDAO.MatchDir(dir) {
+		RespondAPIError(w, 400, "path is not under an encrypted folder")
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name != "" && !validIngestName(name) {
+		RespondAPIError(w, 400, "invalid name")
+		return
+	}
+
+	auth := make(http.Header)
+	if token := strings.TrimSpace(r.Header.Get("X-Alist-Token")); token != "" {
+		auth.Set("Authorization", token)
+	} else {
+		auth = h.scanAuthHeaders()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &IngestJob{
+		IngestStatus: IngestStatus{
+			ID:        generateTaskID(),
+			URL:       source.String(),
+			Path:      path.Join(dir, name),
+			Status:    ReencryptQueued,
+			CreatedAt: time.Now(),
+			UpdatedAt: time.Now(),
+		},
+		dir:    dir,
+		name:   name,
+		auth:   auth,
+		cancel: cancel,
+	}
+	ingestJobs.add(job)
+	log.Info().Str("job_id", job.ID).Str("url", job.URL).Str("path", dir).Msg("Ingest job queued")
+
+	go func() {
+		defer cancel()
+		jobs := workers.Shared(config.PoolJobs, config.Get().WorkerLimit(config.PoolJobs))
+		if err := jobs.Acquire(ctx); err != nil {
+			job.update(func(j *IngestJob) { j.Status = ReencryptCanceled })
+			return
+		}
+		defer jobs.Release()
+		h.runIngestJob(ctx, job)
+	}()
+
+	RespondSuccess(w, job.snapshot())
+}
+
+// HandleIngestStatus serves GET /enc-api/ingest/status?id=...; without an id
+// it lists the recent jobs.
+func (h *AlistHandler) HandleIngestStatus(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(r.URL.Query().Get("id"))
+	if id == "" {
+		RespondSuccess(w, map[string]interface{}{"jobs": ingestJobs.list()})
+		return
+	}
+	job := ingestJobs.get(id)
+	if job == nil {
+		RespondAPIError(w, 404, "Task not found")
+		return
+	}
+	RespondSuccess(w, job.snapshot())
+}
+
+// HandleIngestCancel serves POST /enc-api/ingest/cancel?id=....
+func (h *AlistHandler) HandleIngestCancel(w http.ResponseWriter, r *http.Request) {
+	job := ingestJobs.get(strings.TrimSpace(r.URL.Query().Get("id")))
+	if job == nil {
+		RespondAPIError(w, 404, "Task not found")
+		return
+	}
+	job.cancel()
+	RespondSuccessMsg(w, "stopped")
+}
+
+func (h *AlistHandler) runIngestJob(ctx context.Context, job *IngestJob) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error().Interface("panic", r).Str("job_id", job.ID).Msg("Ingest job panicked")
+			job.update(func(j *IngestJob) {
+				j.Status = ReencryptError
+				j.Error = fmt.Sprintf("panic: %v", r)
+			})
+		}
+	}()
+	if h.maintenance.Paused() {
+		job.update(func(j *IngestJob) { j.Status = ReencryptPaused })
+		_ = h.maintenance.Wait(ctx, jobIngest)
+	}
+	job.update(func(j *IngestJob) { j.Status = ReencryptRunning })
+
+	err := h.ingest(ctx, job)
+	job.update(func(j *IngestJob) {
+		switch {
+		case ctx.Err() != nil:
+			j.Status = ReencryptCanceled
+		case err != nil:
+			j.Status = ReencryptError
+			j.Error = err.Error()
+		default:
+			j.Status = ReencryptDone
+		}
+	})
+	snap := job.snapshot()
+	if err != nil && ctx.Err() == nil {
+		log.Warn().Err(err).Str("job_id", job.ID).Str("url", job.URL).Msg("Ingest job failed")
+		return
+	}
+	log.Info().Str("job_id", job.ID).Str("status", snap.Status).Str("path", snap.Path).
+		Int64("bytes", snap.DoneBytes).Msg("Ingest job finished")
+}
+
+// ingest downloads the job's URL and uploads it through the staging path.
+func (h *AlistHandler) ingest(ctx context.Context, job *IngestJob) error {
+	rule, ok := h.passwdDAO.FindByDir(job.dir)
+	if !ok {
+		return fmt.Errorf("%s is no longer under an encrypted folder", job.dir)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, job.URL, nil)
+	if err != nil {
+		return err
+	}
+	// Share links commonly redirect to the storage host.
+	client := &http.Client{Transport: h.httpClient.Transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("download: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download: status %d", resp.StatusCode)
+	}
+	var body io.Reader = resp.Body
+	size := resp.ContentLength
+	if size < 0 {
+		// The header records the plaintext size, so a download of unknown
+		// length is spooled to a temporary file first.
+		spool, n, err := spoolIngest(resp.Body, job)
+		if err != nil {
+			return fmt.Errorf("download: %w", err)
+		}
+		defer func() {
+			spool.Close()
+			os.Remove(spool.Name())
+		}()
+		body, size = spool, n
+		job.update(func(j *IngestJob) { j.DoneBytes = 0 })
+	}
+	name := job.name
+	if name == "" {
+		name = ingestFileName(resp)
+	}
+	storedName := name
+	if rule.EncName {
+		var err error
+		if storedName, name, err = storedNameFor(h.cfg, rule, name, true); err != nil {
+			return err
+		}
+	}
+	displayPath := path.Join(job.dir, name)
+	job.update(func(j *IngestJob) {
+		j.Path = displayPath
+		j.TotalBytes = size
+	})
+
+	release, err := acquireStorageStream(ctx, displayPath)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if err := waitStorageRequest(ctx, displayPath); err != nil {
+		return err
+	}
+
+	finalPath := path.Join(h.realDirPath(job.dir), storedName)
+	apiReq, err := http.NewRequestWithContext(ctx, http.MethodPut, "http://ingest.local/api/fs/put", &ingestProgressReader{r: body, job: job})
+	if err != nil {
+		return err
+	}
+	for key, values := range job.auth {
+		apiReq.Header[key] = append([]string(nil), values...)
+	}
+	apiReq.ContentLength = size
+	apiReq.Header.Set("Content-Length", strconv.FormatInt(size, 10))
+	apiReq.Header.Set("Content-Type", "application/octet-stream")
+	apiReq.Header.Set("File-Path", pathutil.EscapeFilePath(finalPath))
+	targetURL := httputil.BuildTargetURL(h.cfg.GetAlistURL(), "/api/fs/put", nil)
+	rec := newStagedUploadRecorder()
+	if !h.putStaged(rec, apiReq, targetURL, rule, size, finalPath, displayPath) {
+		return fmt.Errorf("upload: status %d: %s", rec.status, strings.TrimSpace(rec.body.String()))
+	}
+
+	h.fileDAO.Revive(displayPath)
+	h.fileDAO.InvalidateDisplayPath(displayPath)
+	if rule.EncName {
+		h.fileDAO.SetEncPathMapping(displayPath, finalPath)
+	}
+	h.InvalidateListCache(job.dir)
+	return nil
+}
+
+// spoolIngest copies r to a temporary file and rewinds it.
+func spoolIngest(r io.Reader, job *IngestJob) (*os.File, int64, error) {
+	f, err := os.CreateTemp("", "aeg-ingest-*")
+	if err != nil {
+		return nil, 0, err
+	}
+	n, err := io.Copy(f, &ingestProgressReader{r: r, job: job})
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, 0, err
+	}
+	return f, n, nil
+}
+
+// ingestFileName names a download after its Content-Disposition filename,
+// else the last segment of the final URL.
+func ingestFileName(resp *http.Response) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		if name := path.Base(strings.ReplaceAll(params["filename"], "\\", "/")); validIngestName(name) {
+			return name
+		}
+	}
+	if resp.Request != nil && resp.Request.URL != nil {
+		if name := path.Base(resp.Request.URL.Path); validIngestName(name) {
+			return name
+		}
+	}
+	return "download"
+}
+
+func validIngestName(name string) bool {
+	return name != "" && name != "." && name != ".." && name != "/" && !strings.ContainsAny(name, "/\\")
+}
+
+// ingestProgressReader adds downloaded bytes to the job's progress.
+type ingestProgressReader struct {
+	r   io.Reader
+	job *IngestJob
+}
+
+func (p *ingestProgressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.job.update(func(j *IngestJob) { j.DoneBytes += int64(n) })
+	}
+	return n, err
+}
@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/encryption"
+)
+
+func startIngestForTest(t *testing.T, handler *AlistHandler, body string) IngestStatus {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.HandleIngestStart(rec, httptest.NewRequest(http.MethodPost, "/enc-api/ingest", strings.NewReader(body)))
+	var started struct {
+		Data IngestStatus `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &started); err != nil || started.Data.ID == "" {
+		t.Fatalf("start: %s", rec.Body.String())
+	}
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if job := ingestJobs.get(started.Data.ID); job != nil && job.finished() {
+			return job.snapshot()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", started.Data.ID)
+	return IngestStatus{}
+}
+
+func TestIngestStoresEncryptedDownload(t *testing.T) {
+	passwd := &config.PasswdInfo{
+		Password: "ingest-pass",
+		EncType:  "aesctr",
+		Enable:   true,
+		EncName:  true,
+		EncPath:  []string{"/vault/*"},
+	}
+	plain := bytes.Repeat([]byte("shared-link-"), 20000)
+	fs := &fakeAlistFS{files: map[string][]byte{}}
+	mux := http.NewServeMux()
+	mux.Handle("/", fs.handler())
+	mux.HandleFunc("/share/abc", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Disposition", `attachment; filename="holiday.mp4"`)
+		_, _ = w.Write(plain)
+	})
+	srv := newSocketTestServer(t, mux)
+	defer srv.Close()
+	handler, _ := newTestAlistHandler(t, srv.URL, passwd)
+
+	job := startIngestForTest(t, handler, `{"url":"`+srv.URL+`/share/abc","path":"/vault"}`)
+	if job.Status != ReencryptDone || job.Path != "/vault/holiday.mp4" || job.DoneBytes != int64(len(plain)) || job.Percent != 100 {
+		t.Fatalf("job=%+v", job)
+	}
+	realName := encryption.NewFileNameConverter(passwd.Password, passwd.EncType, "").ToRealName("holiday.mp4")
+	stored, ok := fs.files["/vault/"+realName]
+	if !ok || len(fs.files) != 1 {
+		t.Fatalf("stored files: %v", len(fs.files))
+	}
+	reader, _, err := encryption.AutoDecryptReader(passwd.Password, encryption.EncTypeAESCTR, bytes.NewReader(stored), int64(len(stored)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(reader); !bytes.Equal(got, plain) {
+		t.Fatal("ingested content does not decrypt to the source")
+	}
+}
+
+func TestIngestFailsWithoutLeavingFiles(t *testing.T) {
+	passwd := &config.PasswdInfo{Password: "p", EncType: "aesctr", Enable: true, EncPath: []string{"/vault/*"}}
+	fs := &fakeAlistFS{files: map[string][]byte{}}
+	mux := http.NewServeMux()
+	mux.Handle("/", fs.handler())
+	mux.HandleFunc("/share/missing", http.NotFound)
+	srv := newSocketTestServer(t, mux)
+	defer srv.Close()
+	handler, _ := newTestAlistHandler(t, srv.URL, passwd)
+
+	job := startIngestForTest(t, handler, `{"url":"`+srv.URL+`/share/missing","path":"/vault","name":"x.bin"}`)
+	if job.Status != ReencryptError || !strings.Contains(job.Error, "status 404") || len(fs.files) != 0 {
+		t.Fatalf("job=%+v files=%d", job, len(fs.files))
+	}
+}
+
+func TestIngestStartValidatesRequest(t *testing.T) {
+	passwd := &config.PasswdInfo{Password: "p", EncType: "aesctr", Enable: true, EncPath: []string{"/vault/*"}}
+	handler, _ := newTestAlistHandler(t, "http://127.0.0.1:1", passwd)
+	for _, body := range []string{
+		`{"url":"file:///etc/passwd","path":"/vault"}`,
+		`{"url":"http://example.com/a","path":"/plain"}`,
+		`{"url":"http://example.com/a","path":"/vault","name":"../x"}`,
+	} {
+		rec := httptest.NewRecorder()
+		handler.HandleIngestStart(rec, httptest.NewRequest(http.MethodPost, "/enc-api/ingest", strings.NewReader(body)))
+		if !strings.Contains(rec.Body.String(), `"code":400`) {
+			t.Fatalf("%s: body=%s", body, rec.Body.String())
+		}
+	}
+}
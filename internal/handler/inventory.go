@@ -0,0 +1,231 @@
+package handler
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/pathutil"
+)
+
+const (
+	inventoryMaxDepth = 32
+	inventoryMaxItems = 200000
+	inventoryPerPage  = 1000
+	inventoryMaxPages = 1000
+)
+
+// InventoryItem is one row of a decrypted directory inventory.
+type InventoryItem struct {
+	Path          string `json:"path"`
+	Name          string `json:"name"`
+	EncryptedName string `json:"encrypted_name"`
+	EncryptedPath string `json:"encrypted_path"`
+	IsDir         bool   `json:"is_dir"`
+	Size          int64  `json:"size"`
+	Modified      string `json:"modified"`
+	Encrypted     bool   `json:"encrypted"`
+}
+
+var inventoryCSVHeader = []string{"path", "name", "encrypted_name", "encrypted_path", "is_dir", "size", "modified", "encrypted"}
+
+func (it InventoryItem) csvRecord() []string {
+	return []string{
+		it.Path,
+		it.Name,
+		it.EncryptedName,
+		it.EncryptedPath,
+		strconv.FormatBool(it.IsDir),
+		strconv.FormatInt(it.Size, 10),
+		it.Modified,
+		strconv.FormatBool(it.Encrypted),
+	}
+}
+
+// HandleInventory serves /enc-api/inventory?path=...&format=csv|json[&depth=N]
+// as a downloadable listing of everything under path, with display names next
+// to the names actually stored upstream. The Alist token is taken from
+// X-Alist-Token, falling back to the configured scan credentials.
+func (h *AlistHandler) HandleInventory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	root := strings.TrimSpace(query.Get("path"))
+	if root == "" {
+		RespondAPIError(w, 400, "path is required")
+		return
+	}
+	root = pathutil.CleanInput(root)
+
+	format := strings.ToLower(strings.TrimSpace(query.Get("format")))
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		RespondAPIError(w, 400, "format must be csv or json")
+		return
+	}
+	depth := inventoryMaxDepth
+	if v := query.Get("depth"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed >= 0 && parsed < inventoryMaxDepth {
+			depth = parsed
+		}
+	}
+
+	auth := make(http.Header)
+	if token := strings.TrimSpace(r.Header.Get("X-Alist-Token")); token != "" {
+		auth.Set("Authorization", token)
+	} else {
+		auth = h.scanAuthHeaders()
+	}
+
+	items, truncated, err := h.collectInventory(r.Context(), root, depth, auth)
+	if err != nil {
+		log.Warn().Err(err).Str("path", root).Msg("Inventory walk failed")
+		RespondAPIError(w, 502, "failed to list "+root+": "+err.Error())
+		return
+	}
+
+	name := strings.Trim(strings.ReplaceAll(root, "/", "_"), "_")
+	if name == "" {
+		name = "root"
+	}
+	filename := fmt.Sprintf("inventory-%s-%s.%s", name, time.Now().Format("20060102-150405"), format)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	if truncated {
+		w.Header().Set("X-Inventory-Truncated", "true")
+	}
+
+	if format == "json" {
+		RespondJSON(w, http.StatusOK, map[string]interface{}{
+			"path":      root,
+			"count":     len(items),
+			"truncated": truncated,
+			"items":     items,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	cw := csv.NewWriter(w)
+	_ = cw.Write(inventoryCSVHeader)
+	for _, it := range items {
+		_ = cw.Write(it.csvRecord())
+	}
+	cw.Flush()
+}
+
+// collectInventory walks root breadth-first. Encrypted names are resolved
+// with the password configured for each directory, and directories are
+// recursed into by their upstream (encrypted) path.
+func (h *AlistHandler) collectInventory(ctx context.Context, root string, maxDepth int, auth http.Header) ([]InventoryItem, bool, error) {
+	type node struct {
+		displayPath string
+		realPath    string
+		depth       int
+	}
+
+	queue := []node{{displayPath: root, realPath: h.realDirPath(root)}}
+	var items []InventoryItem
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		content, err := h.listAlistDir(ctx, current.realPath, auth)
+		if err != nil {
+			if current.displayPath == root {
+				return nil, false, err
+			}
+			log.Warn().Err(err).Str("path", current.displayPath).Msg("Inventory skipped unreadable directory")
+			continue
+		}
+		var passwdInfo *config.PasswdInfo
+		if h.passwdDAO.MatchDir(current.displayPath) {
+			passwdInfo, _ = h.passwdDAO.FindByDir(current.displayPath)
+		}
+		encrypted := passwdInfo != nil
+		for _, raw := range content {
+			fileData, ok := raw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			rawName, _ := fileData["name"].(string)
+			if rawName == "" {
+				continue
+			}
+			isDir, _ := fileData["is_dir"].(bool)
+			displayName := rawName
+			if encrypted && passwdInfo.EncName {
+				if showName := h.convertShowName(passwdInfo, rawName); showName != "" {
+					displayName = showName
+				}
+			}
+			item := InventoryItem{
+				Path:          path.Join(current.displayPath, displayName),
+				Name:          displayName,
+				EncryptedName: rawName,
+				EncryptedPath: path.Join(current.realPath, rawName),
+				IsDir:         isDir,
+				Size:          inventoryInt64(fileData["size"]),
+				Modified:      inventoryString(fileData["modified"]),
+				Encrypted:     encrypted && !isDir,
+			}
+			items = append(items, item)
+			if len(items) >= inventoryMaxItems {
+				return items, true, nil
+			}
+			if isDir && current.depth < maxDepth {
+				queue = append(queue, node{displayPath: item.Path, realPath: item.EncryptedPath, depth: current.depth + 1})
+			}
+		}
+	}
+	return items, false, nil
+}
+
+// realDirPath maps a display directory to the path it is stored under. Only a
+// directory nested inside an encrypted folder has an encrypted name of its
+// own; the configured encPath root itself is stored as-is.
+func (h *AlistHandler) realDirPath(dir string) string {
+	if passwdInfo, ok := h.passwdDAO.FindByDir(path.Dir(dir)); ok && passwdInfo.EncName && h.passwdDAO.MatchDir(path.Dir(dir)) {
+		allowLoose := h.cfg != nil && h.cfg.AlistServer.AllowLooseDecode
+		if realPath, _ := resolveEncryptedRealPath(h.fileDAO, passwdInfo, dir, allowLoose); realPath != "" {
+			return realPath
+		}
+	}
+	return dir
+}
+
+// listAlistDir returns the raw fs/list content of realPath across all pages.
+func (h *AlistHandler) listAlistDir(ctx context.Context, realPath string, auth http.Header) ([]interface{}, error) {
+	respData, err := h.listFullDir(ctx, realPath, auth)
+	if err != nil {
+		return nil, err
+	}
+	data, _ := respData["data"].(map[string]interface{})
+	content, _ := data["content"].([]interface{})
+	return content, nil
+}
+
+func inventoryInt64(v interface{}) int64 {
+	switch n := v.(type) {
+	case float64:
+		return int64(n)
+	case json.Number:
+		i, _ := n.Int64()
+		return i
+	}
+	return 0
+}
+
+func inventoryString(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/encryption"
+)
+
+func TestHandleInventoryWalksAndDecryptsNames(t *testing.T) {
+	passwd := &config.PasswdInfo{
+		Password: "testpass",
+		EncType:  "aesctr",
+		Enable:   true,
+		EncName:  true,
+		EncPath:  []string{"/vault/*"},
+	}
+	converter := encryption.NewFileNameConverter(passwd.Password, passwd.EncType, passwd.EncSuffix)
+	dirRaw := converter.ToRealName("season1")
+	leafDisplay := "episode01.mkv"
+	leafRaw := converter.ToRealName(leafDisplay)
+
+	var gotAuth string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/fs/list", func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		body, _ := io.ReadAll(r.Body)
+		var req struct {
+			Path string `json:"path"`
+		}
+		_ = json.Unmarshal(body, &req)
+		var content []interface{}
+		switch req.Path {
+		case "/vault":
+			content = []interface{}{
+				map[string]interface{}{"name": dirRaw, "is_dir": true, "size": float64(0), "modified": "2026-01-02T03:04:05Z"},
+			}
+		case "/vault/" + dirRaw:
+			content = []interface{}{
+				map[string]interface{}{"name": leafRaw, "is_dir": false, "size": float64(4096), "modified": "2026-01-02T03:04:06Z"},
+			}
+		default:
+			t.Errorf("unexpected list path %q", req.Path)
+		}
+		writeJSONResponse(w, map[string]interface{}{
+			"code":    200,
+			"message": "success",
+			"data":    map[string]interface{}{"content": content, "total": float64(len(content))},
+		})
+	})
+	srv := newSocketTestServer(t, mux)
+	defer srv.Close()
+
+	handler, _ := newTestAlistHandler(t, srv.URL, passwd)
+
+	req := httptest.NewRequest(http.MethodGet, "/enc-api/inventory?path=/vault", nil)
+	req.Header.Set("X-Alist-Token", "alist-token")
+	rec := httptest.NewRecorder()
+	handler.HandleInventory(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if gotAuth != "alist-token" {
+		t.Fatalf("upstream Authorization=%q", gotAuth)
+	}
+	if !strings.HasPrefix(rec.Header().Get("Content-Disposition"), "attachment;") {
+		t.Fatalf("missing attachment disposition: %q", rec.Header().Get("Content-Disposition"))
+	}
+
+	records, err := csv.NewReader(rec.Body).ReadAll()
+	if err != nil {
+		t.Fatalf("parse csv: %v", err)
+	}
+	if len(records) != 3 {
+		t.Fatalf("rows=%d want header+2: %v", len(records), records)
+	}
+	leaf := records[2]
+	if leaf[0] != "/vault/season1/"+leafDisplay || leaf[1] != leafDisplay || leaf[2] != leafRaw {
+		t.Fatalf("leaf row=%v", leaf)
+	}
+	if leaf[3] != "/vault/"+dirRaw+"/"+leafRaw || leaf[5] != "4096" || leaf[7] != "true" {
+		t.Fatalf("leaf row=%v", leaf)
+	}
+}
+
+func TestHandleInventoryRequiresPath(t *testing.T) {
+	handler := &AlistHandler{}
+	rec := httptest.NewRecorder()
+	handler.HandleInventory(rec, httptest.NewRequest(http.MethodGet, "/enc-api/inventory", nil))
+	if !strings.Contains(rec.Body.String(), "path is required") {
+		t.Fatalf("body=%s", rec.Body.String())
+	}
+}
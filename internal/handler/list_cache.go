@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/pathutil"
+)
+
+const maxListCacheEntries = 256
+
+// listCacheEntry is a decrypted fs/list response shared by identical requests.
+type listCacheEntry struct {
+	Dir        string
+	StatusCode int
+	Payload    []byte
+	ItemCount  int
+	ExpiresAt  time.Time
+}
+
+type listFetchResult struct {
+	StatusCode int
+	Payload    []byte
+	ItemCount  int
+	CacheHit   bool
+}
+
+func (h *AlistHandler) listCacheTTL() time.Duration {
+	if h.cfg == nil || !h.cfg.AlistServer.EnableListCache || h.cfg.AlistServer.ListCacheTTLSeconds <= 0 {
+		return 0
+	}
+	return time.Duration(h.cfg.AlistServer.ListCacheTTLSeconds) * time.Second
+}
+
+// listCacheKey identifies a listing by the full request body (path, page,
+// per_page, password, refresh) and the caller's auth identity.
+func listCacheKey(body []byte, authHash string) string {
+	bodyHash := sha256.Sum256(body)
+	return fmt.Sprintf("%x|%s", bodyHash[:12], authHash)
+}
+
+// fetchFsListShared collapses concurrent identical fs/list calls into one
+// upstream request and serves repeats from a short-lived cache. A request
+// with refresh=true skips the cache read but still refreshes the entry.
+func (h *AlistHandler) fetchFsListShared(r *http.Request, body []byte, dirPath, authHash string) (int, []byte, int, error) {
+	key := listCacheKey(body, authHash)
+	ttl := h.listCacheTTL()
+	refresh := listRequestForcesRefresh(body)
+	if ttl > 0 && !refresh {
+		if entry, ok := h.getListCache(key); ok {
+			atomic.AddUint64(&h.listCacheHits, 1)
+			return entry.StatusCode, entry.Payload, entry.ItemCount, nil
+		}
+	}
+
+	// The shared fetch must not die with whichever caller started it.
+	shared := r.WithContext(context.WithoutCancel(r.Context()))
+	result, err, wasShared := h.listGroup.Do(key, func() (interface{}, error) {
+		if ttl > 0 && !refresh {
+			if entry, ok := h.getListCache(key); ok {
+				return listFetchResult{StatusCode: entry.StatusCode, Payload: entry.Payload, ItemCount: entry.ItemCount, CacheHit: true}, nil
+			}
+		}
+		atomic.AddUint64(&h.listUpstreamFetches, 1)
+		statusCode, _, payload, itemCount, err := h.liveFsListResponse(shared, body, dirPath, true)
+		if err != nil {
+			return nil, err
+		}
+		if ttl > 0 && statusCode >= 200 && statusCode < 300 && isSuccessfulListPayload(payload) {
+			h.setListCache(key, listCacheEntry{
+				Dir:        pathutil.CleanInput(dirPath),
+				StatusCode: statusCode,
+				Payload:    payload,
+				ItemCount:  itemCount,
+				ExpiresAt:  time.Now().Add(ttl),
+			})
+		}
+		return listFetchResult{StatusCode: statusCode, Payload: payload, ItemCount: itemCount}, nil
+	})
+	if err != nil {
+		return 0, nil, 0, err
+	}
+	if wasShared {
+		atomic.AddUint64(&h.listSingleflightHits, 1)
+	}
+	fetched := result.(listFetchResult)
+	if fetched.CacheHit {
+		atomic.AddUint64(&h.listCacheHits, 1)
+	}
+	return fetched.StatusCode, fetched.Payload, fetched.ItemCount, nil
+}
+
+func listRequestForcesRefresh(body []byte) bool {
+	var req struct {
+		Refresh bool `json:"refresh"`
+	}
+	_ = json.Unmarshal(body, &req)
+	return req.Refresh
+}
+
+func (h *AlistHandler) getListCache(key string) (listCacheEntry, bool) {
+	h.listMu.Lock()
+	defer h.listMu.Unlock()
+	entry, ok := h.listCache[key]
+	if !ok {
+		return listCacheEntry{}, false
+	}
+	if time.Now().After(entry.ExpiresAt) {
+		delete(h.listCache, key)
+		return listCacheEntry{}, false
+	}
+	return entry, true
+}
+
+func (h *AlistHandler) setListCache(key string, entry listCacheEntry) {
+	h.listMu.Lock()
+	defer h.listMu.Unlock()
+	if h.listCache == nil {
+		h.listCache = make(map[string]listCacheEntry)
+	}
+	if len(h.listCache) >= maxListCacheEntries {
+		now := time.Now()
+		for k, e := range h.listCache {
+			if now.After(e.ExpiresAt) {
+				delete(h.listCache, k)
+			}
+		}
+		for k := range h.listCache {
+			if len(h.listCache) < maxListCacheEntries {
+				break
+			}
+			delete(h.listCache, k)
+		}
+	}
+	h.listCache[key] = entry
+}
+
+// InvalidateListCache drops cached listings of the given directories and
+// everything below them. With no arguments the whole cache is cleared.
+func (h *AlistHandler) InvalidateListCache(dirs ...string) {
+	if h == nil {
+		return
+	}
+	h.listMu.Lock()
+	defer h.listMu.Unlock()
+	if len(dirs) == 0 {
+		h.listCache = nil
+		return
+	}
+	for key, entry := range h.listCache {
+		for _, dir := range dirs {
+			dir = pathutil.CleanInput(dir)
+			if entry.Dir == dir || dir == "/" || strings.HasPrefix(entry.Dir, dir+"/") {
+				delete(h.listCache, key)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+func TestHandleFsListCoalescesAndCachesListings(t *testing.T) {
+	passwd := &config.PasswdInfo{
+		Password: "testpass",
+		EncType:  "aesctr",
+		Enable:   true,
+		EncName:  true,
+		EncPath:  []string{"/user_storage/encrypt/*"},
+	}
+
+	var hits int32
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/fs/list", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		time.Sleep(50 * time.Millisecond)
+		writeJSONResponse(w, map[string]interface{}{
+			"code":    200,
+			"message": "success",
+			"data": map[string]interface{}{
+				"content": []interface{}{},
+				"total":   float64(0),
+			},
+		})
+	})
+	srv := newSocketTestServer(t, mux)
+	defer srv.Close()
+
+	handler, _ := newTestAlistHandler(t, srv.URL, passwd)
+	handler.cfg.AlistServer.EnableListCache = true
+	handler.cfg.AlistServer.ListCacheTTLSeconds = 30
+
+	list := func(body, auth string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/fs/list", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", auth)
+		rec := httptest.NewRecorder()
+		handler.HandleFsList(rec, req)
+		return rec.Code
+	}
+	body := `{"path":"/user_storage/encrypt","page":1,"per_page":100}`
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if code := list(body, "token-a"); code != http.StatusOK {
+				t.Errorf("status=%d", code)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := atomic.LoadInt32(&hits); got != 1 {
+		t.Fatalf("concurrent listings hit upstream %d times, want 1", got)
+	}
+
+	list(body, "token-a")
+	if got := atomic.LoadInt32(&hits); got != 1 {
+		t.Fatalf("cached listing hit upstream, hits=%d", got)
+	}
+
+	list(body, "token-b")
+	if got := atomic.LoadInt32(&hits); got != 2 {
+		t.Fatalf("other auth identity should not share cache, hits=%d", got)
+	}
+
+	list(`{"path":"/user_storage/encrypt","page":2,"per_page":100}`, "token-a")
+	if got := atomic.LoadInt32(&hits); got != 3 {
+		t.Fatalf("other page should not share cache, hits=%d", got)
+	}
+
+	handler.InvalidateListCache("/user_storage")
+	list(body, "token-a")
+	if got := atomic.LoadInt32(&hits); got != 4 {
+		t.Fatalf("invalidated listing was served from cache, hits=%d", got)
+	}
+}
+
+func TestInvalidateListCacheScopesToSubtree(t *testing.T) {
+	h := &AlistHandler{}
+	expires := time.Now().Add(time.Minute)
+	h.setListCache("a", listCacheEntry{Dir: "/media/tv", ExpiresAt: expires})
+	h.setListCache("b", listCacheEntry{Dir: "/media/tv/show", ExpiresAt: expires})
+	h.setListCache("c", listCacheEntry{Dir: "/media/tvshows", ExpiresAt: expires})
+
+	h.InvalidateListCache("/media/tv/")
+	if _, ok := h.getListCache("a"); ok {
+		t.Fatal("expected /media/tv to be invalidated")
+	}
+	if _, ok := h.getListCache("b"); ok {
+		t.Fatal("expected /media/tv/show to be invalidated")
+	}
+	if _, ok := h.getListCache("c"); !ok {
+		t.Fatal("sibling /media/tvshows must survive")
+	}
+}
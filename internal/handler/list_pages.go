@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/alist-encrypt-go/internal/httputil"
+)
+
+// parseListPage extracts page and per_page from an fs/list request body.
+// Zero per_page means the client asked for the whole directory.
+func parseListPage(body []byte) (int, int) {
+	var req struct {
+		Page    int `json:"page"`
+		PerPage int `json:"per_page"`
+	}
+	_ = json.Unmarshal(body, &req)
+	if req.Page < 1 {
+		req.Page = 1
+	}
+	if req.PerPage < 0 {
+		req.PerPage = 0
+	}
+	return req.Page, req.PerPage
+}
+
+// isPartialListing reports whether an fs/list response holds only part of
+// the directory: a page past the first, or fewer items than data.total.
+func isPartialListing(page, perPage int, respData map[string]interface{}) bool {
+	if page > 1 {
+		return true
+	}
+	data, _ := respData["data"].(map[string]interface{})
+	content, _ := data["content"].([]interface{})
+	total, ok := data["total"].(float64)
+	if !ok {
+		return perPage > 0 && len(content) >= perPage
+	}
+	return int(total) > len(content)
+}
+
+// isCompleteListPayload reports whether a rewritten fs/list payload for page
+// covers the whole directory and can stand in for it as a snapshot.
+func isCompleteListPayload(page int, payload []byte) bool {
+	if page > 1 {
+		return false
+	}
+	var respData map[string]interface{}
+	if err := json.Unmarshal(payload, &respData); err != nil {
+		return false
+	}
+	return !isPartialListing(page, 0, respData)
+}
+
+// listFullDir fetches every page of realPath from Alist and returns the
+// first page's response with data.content holding all items and data.total
+// matching it. Jobs that need a whole directory (scans, inventories) use this
+// instead of trusting a single page.
+func (h *AlistHandler) listFullDir(ctx context.Context, realPath string, auth http.Header) (map[string]interface{}, error) {
+	targetURL := httputil.BuildTargetURL(h.cfg.GetAlistURL(), "/api/fs/list", nil)
+	var first map[string]interface{}
+	var all []interface{}
+	for page := 1; page <= inventoryMaxPages; page++ {
+		body, _ := json.Marshal(map[string]interface{}{
+			"path":     realPath,
+			"page":     page,
+			"per_page": inventoryPerPage,
+			"refresh":  false,
+		})
+		builder := httputil.NewRequest(http.MethodPost, targetURL).
+			WithContext(ctx).
+			WithBody(body).
+			WithHeader("Content-Type", "application/json")
+		for key, values := range auth {
+			for _, v := range values {
+				builder = builder.WithHeader(key, v)
+			}
+		}
+		req, err := builder.Build()
+		if err != nil {
+			return nil, err
+		}
+		resp, err := h.httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		respBody, err := readLimitedBody(resp, maxProxyResponseBody)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		var respData map[string]interface{}
+		if err := json.Unmarshal(respBody, &respData); err != nil {
+			return nil, err
+		}
+		if code, _ := respData["code"].(float64); code != 200 {
+			message, _ := respData["message"].(string)
+			return nil, fmt.Errorf("alist code %d: %s", int(code), message)
+		}
+		data, _ := respData["data"].(map[string]interface{})
+		content, _ := data["content"].([]interface{})
+		total, _ := data["total"].(float64)
+		if first == nil {
+			first = respData
+		}
+		all = append(all, content...)
+		if len(content) < inventoryPerPage || (total > 0 && len(all) >= int(total)) {
+			break
+		}
+	}
+	data, _ := first["data"].(map[string]interface{})
+	if data == nil {
+		data = map[string]interface{}{}
+		first["data"] = data
+	}
+	if all == nil {
+		all = []interface{}{}
+	}
+	data["content"] = all
+	data["total"] = float64(len(all))
+	return first, nil
+}
+
+// fullFsListResponse lists the whole of dirPath and rewrites it like a
+// complete fs/list response, returning the payload and upstream item count.
+func (h *AlistHandler) fullFsListResponse(r *http.Request, dirPath string, auth http.Header, enableProbe bool) (map[string]interface{}, []byte, int, error) {
+	respData, err := h.listFullDir(r.Context(), dirPath, auth)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	dirPasswd, allowDecrypt := h.listDecryptScope(dirPath)
+	itemCount := h.rewriteFsListPayload(r, respData, dirPath, dirPasswd, allowDecrypt, enableProbe, false)
+	payload, err := json.Marshal(respData)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return respData, payload, itemCount, nil
+}
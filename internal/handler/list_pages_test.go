@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+func TestLiveFsListKeepsCoversOnPartialPages(t *testing.T) {
+	passwd := &config.PasswdInfo{
+		Password: "testpass",
+		EncType:  "aesctr",
+		Enable:   true,
+		EncPath:  []string{"/media/*"},
+	}
+	items := []interface{}{
+		map[string]interface{}{"name": "movie.mp4", "type": float64(2), "size": float64(10)},
+		map[string]interface{}{"name": "movie.jpg", "type": float64(5), "size": float64(1)},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/fs/list", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			PerPage int `json:"per_page"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		total := 2
+		if req.PerPage == 2 {
+			total = 5 // more entries on later pages
+		}
+		writeJSONResponse(w, map[string]interface{}{
+			"code": 200,
+			"data": map[string]interface{}{"content": cloneListItems(items), "total": float64(total)},
+		})
+	})
+	srv := newSocketTestServer(t, mux)
+	defer srv.Close()
+	handler, _ := newTestAlistHandler(t, srv.URL, passwd)
+
+	list := func(body string) map[string]interface{} {
+		req := httptest.NewRequest(http.MethodPost, "/api/fs/list", strings.NewReader(body))
+		_, respData, _, _, err := handler.liveFsListResponse(req, []byte(body), "/media", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return respData["data"].(map[string]interface{})
+	}
+
+	full := list(`{"path":"/media"}`)
+	if content := full["content"].([]interface{}); len(content) != 1 || full["total"].(float64) != 1 {
+		t.Fatalf("full listing should fold the cover: content=%v total=%v", content, full["total"])
+	}
+
+	partial := list(`{"path":"/media","page":1,"per_page":2}`)
+	content := partial["content"].([]interface{})
+	if len(content) != 2 || partial["total"].(float64) != 5 {
+		t.Fatalf("partial page must keep its items: content=%v total=%v", content, partial["total"])
+	}
+	if thumb := content[0].(map[string]interface{})["thumb"]; thumb != "/d/media/movie.jpg" {
+		t.Fatalf("thumb=%v, want same-page cover", thumb)
+	}
+}
+
+func TestListFullDirMergesPages(t *testing.T) {
+	const total = inventoryPerPage + 5
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/fs/list", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Page    int `json:"page"`
+			PerPage int `json:"per_page"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		var content []interface{}
+		for i := (req.Page - 1) * req.PerPage; i < total && i < req.Page*req.PerPage; i++ {
+			content = append(content, map[string]interface{}{"name": fmt.Sprintf("f%d", i)})
+		}
+		writeJSONResponse(w, map[string]interface{}{
+			"code": 200,
+			"data": map[string]interface{}{"content": content, "total": float64(total), "readme": "hi"},
+		})
+	})
+	srv := newSocketTestServer(t, mux)
+	defer srv.Close()
+	handler, _ := newTestAlistHandler(t, srv.URL, &config.PasswdInfo{EncPath: []string{"/x/*"}})
+
+	respData, err := handler.listFullDir(httptest.NewRequest(http.MethodGet, "/", nil).Context(), "/big", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := respData["data"].(map[string]interface{})
+	if got := len(data["content"].([]interface{})); got != total || data["total"].(float64) != total {
+		t.Fatalf("merged %d items, total=%v, want %d", got, data["total"], total)
+	}
+	if data["readme"] != "hi" {
+		t.Fatalf("first page fields should be kept, got %v", data["readme"])
+	}
+	if isPartialListing(1, 0, respData) {
+		t.Fatal("merged listing should be complete")
+	}
+}
+
+func cloneListItems(items []interface{}) []interface{} {
+	out := make([]interface{}, len(items))
+	for i, item := range items {
+		m := map[string]interface{}{}
+		for k, v := range item.(map[string]interface{}) {
+			m[k] = v
+		}
+		out[i] = m
+	}
+	return out
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/dao"
+	"github.com/alist-encrypt-go/internal/httputil"
+	"github.com/alist-encrypt-go/internal/pathutil"
+)
+
+// localDirectPath maps the encrypted Alist path of a download onto a file
+// under one of the configured localStorageMounts. The longest matching mount
+// wins. It returns "" when direct reads are disabled, no mount matches, or
+// the proxy has never seen the file in a listing/fs/get. The cached entry is
+// shared by all clients, so it does not authorize anything by itself:
+// ServeLocalDecrypt still checks each request against Alist first.
+func localDirectPath(cfg *config.Config, fileDAO *dao.FileDAO, displayPath, encryptedPath string) string {
+	if cfg == nil || !cfg.AlistServer.EnableLocalDirectRead || encryptedPath == "" {
+		return ""
+	}
+	if fileDAO == nil {
+		return ""
+	}
+	if _, ok := fileDAO.Get(displayPath); !ok {
+		return ""
+	}
+	return mapLocalStoragePath(cfg.AlistServer.LocalStorageMounts, encryptedPath)
+}
+
+// localDirectAuthURL is the Alist URL a /d or /p download is checked against
+// before a local direct read: the encrypted path with the client's own sign.
+// The sign handed out in fs/list and fs/get was computed by Alist for the
+// encrypted path, so Alist verifies it exactly as it would for the download.
+// The upstream target itself cannot be used: it may be a cached raw_url whose
+// sign belongs to whoever listed the file first.
+func localDirectAuthURL(cfg *config.Config, r *http.Request, encryptedURLPath string) string {
+	query := ""
+	if sign := r.URL.Query().Get("sign"); sign != "" {
+		query = "sign=" + url.QueryEscape(sign)
+	}
+	return httputil.BuildTargetURLWithQuery(cfg.GetAlistURL(), encryptedURLPath, query)
+}
+
+// mapLocalStoragePath resolves alistPath against mounts. The result always
+// stays inside the mount's LocalRoot.
+func mapLocalStoragePath(mounts []config.LocalStorageMount, alistPath string) string {
+	clean := pathutil.Clean(alistPath)
+	best := -1
+	bestLen := -1
+	for i, m := range mounts {
+		if strings.TrimSpace(m.LocalRoot) == "" || strings.TrimSpace(m.AlistPath) == "" {
+			continue
+		}
+		prefix := pathutil.CleanInput(m.AlistPath)
+		if !pathutil.Within(clean, prefix) || len(prefix) <= bestLen {
+			continue
+		}
+		best, bestLen = i, len(prefix)
+	}
+	if best < 0 {
+		return ""
+	}
+	rel := strings.TrimPrefix(clean, pathutil.CleanInput(mounts[best].AlistPath))
+	if rel == "" || rel == "/" {
+		return ""
+	}
+	return filepath.Join(filepath.Clean(mounts[best].LocalRoot), filepath.FromSlash(rel))
+}
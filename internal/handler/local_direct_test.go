@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+func TestMapLocalStoragePath(t *testing.T) {
+	mounts := []config.LocalStorageMount{
+		{AlistPath: "/nas", LocalRoot: "/srv/nas"},
+		{AlistPath: "/nas/movies", LocalRoot: "/mnt/movies"},
+	}
+	cases := map[string]string{
+		"/nas/docs/a.bin":         filepath.FromSlash("/srv/nas/docs/a.bin"),
+		"/nas/movies/x/enc.mkv":   filepath.FromSlash("/mnt/movies/x/enc.mkv"),
+		"/nasty/a.bin":            "",
+		"/nas":                    "",
+		"/nas/../etc/passwd":      "",
+		"/nas/docs/../../../etc":  "",
+		"/nas/movies/../docs/a.b": filepath.FromSlash("/srv/nas/docs/a.b"),
+	}
+	for in, want := range cases {
+		if got := mapLocalStoragePath(mounts, in); got != want {
+			t.Errorf("mapLocalStoragePath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestLocalDirectAuthURLCarriesOnlyClientSign(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.AlistServer.ServerHost = "alist"
+	cfg.AlistServer.ServerPort = 5244
+	cases := map[string]string{
+		"/d/media/movie.mkv?sign=abc%3D:0&x=1": "http://alist:5244/d/enc/x.mkv?sign=abc%3D%3A0",
+		"/d/media/movie.mkv":                   "http://alist:5244/d/enc/x.mkv",
+	}
+	for target, want := range cases {
+		r := httptest.NewRequest("GET", target, nil)
+		if got := localDirectAuthURL(cfg, r, "/d/enc/x.mkv"); got != want {
+			t.Errorf("localDirectAuthURL(%q) = %q, want %q", target, got, want)
+		}
+	}
+}
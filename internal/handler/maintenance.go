@@ -0,0 +1,249 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+// Background job names reported by /enc-api/jobs.
+const (
+	jobProbe          = "probe"
+	jobDirSync        = "dirsync"
+	jobStartupProbe   = "startup_probe"
+	jobDecodeHealth   = "decode_health"
+	jobReencrypt      = "reencrypt"
+	jobIngest         = "ingest"
+	jobUploadRecovery = "upload_recovery"
+	jobNameKDF        = "name_kdf"
+)
+
+// Maintenance override modes.
+const (
+	MaintenanceAuto   = "auto"   // follow quietHours
+	MaintenancePause  = "pause"  // hold jobs regardless of the clock
+	MaintenanceResume = "resume" // run jobs even inside a quiet window
+)
+
+// maintenancePollInterval bounds how long a paused job sleeps before
+// looking at the clock and config again.
+const maintenancePollInterval = time.Minute
+
+// MaintenanceGate holds background jobs (probing, crawlers, decode health
+// checks, re-encryption, ingest) during the configured quiet hours, so they
+// stay clear of cloud-drive rate limits and household bandwidth at those
+// times.
+// Jobs call Wait between units of work; requests from clients are never
+// held. A manual override from the jobs API takes precedence over the
+// clock until it expires or is set back to auto. A nil gate never pauses.
+type MaintenanceGate struct {
+	cfg *config.Config
+	now func() time.Time
+
+	mu            sync.Mutex
+	override      string
+	overrideUntil time.Time
+	changed       chan struct{}
+	waiting       map[string]int
+}
+
+// NewMaintenanceGate creates a gate driven by cfg.AlistServer.QuietHours.
+func NewMaintenanceGate(cfg *config.Config) *MaintenanceGate {
+	return &MaintenanceGate{
+		cfg:      cfg,
+		now:      time.Now,
+		override: MaintenanceAuto,
+		changed:  make(chan struct{}),
+		waiting:  make(map[string]int),
+	}
+}
+
+// stateLocked reports whether jobs are held at now, why, and when that is
+// next due to change (zero when only a manual change will end it).
+func (g *MaintenanceGate) stateLocked(now time.Time) (paused bool, reason string, until time.Time) {
+	if g.override != MaintenanceAuto && !g.overrideUntil.IsZero() && !now.Before(g.overrideUntil) {
+		g.override, g.overrideUntil = MaintenanceAuto, time.Time{}
+	}
+	switch g.override {
+	case MaintenancePause:
+		return true, "manual", g.overrideUntil
+	case MaintenanceResume:
+		return false, "manual", g.overrideUntil
+	}
+	if g.cfg == nil {
+		return false, "", time.Time{}
+	}
+	for _, w := range g.cfg.AlistServer.QuietWindows() {
+		if w.Contains(now) {
+			return true, "quiet_hours " + w.String(), quietWindowEnd(w, now)
+		}
+	}
+	return false, "", time.Time{}
+}
+
+// quietWindowEnd returns the first moment after now that lies outside w.
+func quietWindowEnd(w config.QuietWindow, now time.Time) time.Time {
+	if w.Start == w.End {
+		return time.Time{}
+	}
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	end := midnight.Add(time.Duration(w.End) * time.Minute)
+	if !end.After(now) {
+		end = end.AddDate(0, 0, 1)
+	}
+	return end
+}
+
+// Paused reports whether background jobs should hold right now.
+func (g *MaintenanceGate) Paused() bool {
+	if g == nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	paused, _, _ := g.stateLocked(g.now())
+	return paused
+}
+
+// Wait blocks while jobs are paused and returns ctx.Err() once they may run
+// (nil) or ctx ends.
+func (g *MaintenanceGate) Wait(ctx context.Context, job string) error {
+	if g == nil {
+		return ctx.Err()
+	}
+	counted := false
+	defer func() {
+		if counted {
+			g.mu.Lock()
+			g.waiting[job]--
+			if g.waiting[job] <= 0 {
+				delete(g.waiting, job)
+			}
+			g.mu.Unlock()
+		}
+	}()
+	for {
+		now := g.now()
+		g.mu.Lock()
+		paused, reason, until := g.stateLocked(now)
+		changed := g.changed
+		first := paused && !counted
+		if first {
+			counted = true
+			g.waiting[job]++
+		}
+		g.mu.Unlock()
+		if !paused {
+			return ctx.Err()
+		}
+		if first {
+			log.Info().Str("job", job).Str("reason", reason).Msg("Background job paused for maintenance window")
+		}
+
+		sleep := maintenancePollInterval
+		if !until.IsZero() && until.Sub(now) < sleep {
+			sleep = until.Sub(now)
+		}
+		timer := time.NewTimer(sleep)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-changed:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// SetOverride switches the gate to mode for d (zero keeps it until the next
+// change) and wakes waiting jobs.
+func (g *MaintenanceGate) SetOverride(mode string, d time.Duration) bool {
+	switch mode {
+	case MaintenanceAuto, MaintenancePause, MaintenanceResume:
+	default:
+		return false
+	}
+	g.mu.Lock()
+	g.override = mode
+	g.overrideUntil = time.Time{}
+	if mode != MaintenanceAuto && d > 0 {
+		g.overrideUntil = g.now().Add(d)
+	}
+	close(g.changed)
+	g.changed = make(chan struct{})
+	g.mu.Unlock()
+	log.Info().Str("mode", mode).Dur("for", d).Msg("Maintenance override changed")
+	return true
+}
+
+// MaintenanceStatus is the gate state reported by /enc-api/jobs.
+type MaintenanceStatus struct {
+	Paused        bool           `json:"paused"`
+	Reason        string         `json:"reason,omitempty"`
+	Until         string         `json:"until,omitempty"`
+	Override      string         `json:"override"`
+	OverrideUntil string         `json:"overrideUntil,omitempty"`
+	QuietHours    []string       `json:"quietHours"`
+	Waiting       map[string]int `json:"waiting"`
+}
+
+// Status snapshots the gate.
+func (g *MaintenanceGate) Status() MaintenanceStatus {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	paused, reason, until := g.stateLocked(g.now())
+	status := MaintenanceStatus{
+		Paused:     paused,
+		Reason:     reason,
+		Until:      formatTimeValue(until),
+		Override:   g.override,
+		QuietHours: []string{},
+		Waiting:    make(map[string]int, len(g.waiting)),
+	}
+	if !g.overrideUntil.IsZero() {
+		status.OverrideUntil = formatTimeValue(g.overrideUntil)
+	}
+	if g.cfg != nil {
+		status.QuietHours = append(status.QuietHours, g.cfg.AlistServer.QuietHours...)
+	}
+	for job, n := range g.waiting {
+		status.Waiting[job] = n
+	}
+	return status
+}
+
+// HandleJobs serves GET /enc-api/jobs: the maintenance state and the
+// re-encryption jobs.
+func (g *MaintenanceGate) HandleJobs(w http.ResponseWriter, r *http.Request) {
+	RespondSuccess(w, map[string]interface{}{
+		"maintenance": g.Status(),
+		"reencrypt":   reencryptJobs.list(),
+	})
+}
+
+// HandleJobsOverride serves POST /enc-api/jobs/override with
+// {"mode":"pause"|"resume"|"auto","minutes":N}. Overrides live in memory
+// and are gone after a restart.
+func (g *MaintenanceGate) HandleJobsOverride(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Mode    string `json:"mode"`
+		Minutes int    `json:"minutes"`
+	}
+	body, err := readLimitedRequestBody(r)
+	if err != nil || json.Unmarshal(body, &req) != nil || req.Minutes < 0 {
+		RespondAPIError(w, 400, "Invalid request")
+		return
+	}
+	if !g.SetOverride(req.Mode, time.Duration(req.Minutes)*time.Minute) {
+		RespondAPIError(w, 400, "mode must be pause, resume or auto")
+		return
+	}
+	RespondSuccess(w, g.Status())
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+func TestMaintenanceGateQuietHoursAndOverride(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AlistServer.QuietHours = []string{"01:00-07:00"}
+	gate := NewMaintenanceGate(cfg)
+	now := time.Date(2024, 5, 1, 2, 30, 0, 0, time.Local)
+	gate.now = func() time.Time { return now }
+
+	if !gate.Paused() {
+		t.Fatal("02:30 is inside 01:00-07:00")
+	}
+	if st := gate.Status(); st.Until != time.Date(2024, 5, 1, 7, 0, 0, 0, time.Local).Format(time.RFC3339) {
+		t.Fatalf("until=%q", st.Until)
+	}
+
+	// A paused job waits and is released by a manual resume.
+	released := make(chan error, 1)
+	go func() { released <- gate.Wait(context.Background(), jobDirSync) }()
+	deadline := time.Now().Add(2 * time.Second)
+	for gate.Status().Waiting[jobDirSync] != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("job never started waiting")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	rec := httptest.NewRecorder()
+	gate.HandleJobsOverride(rec, httptest.NewRequest(http.MethodPost, "/enc-api/jobs/override", strings.NewReader(`{"mode":"resume","minutes":30}`)))
+	if !strings.Contains(rec.Body.String(), `"override":"resume"`) {
+		t.Fatalf("override: %s", rec.Body.String())
+	}
+	select {
+	case err := <-released:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("resume did not release the waiting job")
+	}
+
+	// The override expires back to the schedule.
+	now = now.Add(31 * time.Minute)
+	if !gate.Paused() || gate.Status().Override != MaintenanceAuto {
+		t.Fatal("expired resume should fall back to quiet hours")
+	}
+
+	now = time.Date(2024, 5, 1, 12, 0, 0, 0, time.Local)
+	if gate.Paused() {
+		t.Fatal("noon is outside the quiet window")
+	}
+	gate.SetOverride(MaintenancePause, 0)
+	if !gate.Paused() {
+		t.Fatal("manual pause ignored")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := gate.Wait(ctx, jobProbe); err == nil {
+		t.Fatal("canceled wait should return the context error")
+	}
+
+	rec = httptest.NewRecorder()
+	gate.HandleJobsOverride(rec, httptest.NewRequest(http.MethodPost, "/enc-api/jobs/override", strings.NewReader(`{"mode":"later"}`)))
+	if !strings.Contains(rec.Body.String(), `"code":400`) {
+		t.Fatalf("bad mode: %s", rec.Body.String())
+	}
+
+	var nilGate *MaintenanceGate
+	if nilGate.Paused() || nilGate.Wait(context.Background(), jobProbe) != nil {
+		t.Fatal("nil gate must never pause")
+	}
+}
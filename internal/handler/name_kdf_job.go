@@ -0,0 +1,481 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/encryption"
+	"github.com/alist-encrypt-go/internal/pathutil"
+	"github.com/alist-encrypt-go/internal/storage"
+	"github.com/alist-encrypt-go/internal/workers"
+)
+
+const (
+	// nameKDFBatchSize is how many renames run between progress
+	// checkpoints; nameKDFBatchPause is the rest the storage gets after each.
+	nameKDFBatchSize  = 50
+	nameKDFBatchPause = 2 * time.Second
+)
+
+// NameKDFJob migrates the stored names under an encrypted folder from one
+// filename KDF to the rule's current one (nameKdf), renaming each file in
+// place. Its progress is kept in BoltDB ("namekdfjobs"); an unfinished job
+// is picked up again after a restart, and names a previous run already
+// migrated are skipped, so running it again is always safe. Contents are not
+// touched: file keys do not depend on the name KDF.
+type NameKDFJob struct {
+	ID        string             `json:"id"`
+	Path      string             `json:"path"`
+	FromKDF   string             `json:"fromKdf"`
+	ToKDF     string             `json:"toKdf"`
+	Status    string             `json:"status"` // the Reencrypt* states
+	Dirs      int                `json:"dirs"`
+	Renamed   int                `json:"renamed"`
+	Skipped   int                `json:"skipped"`
+	Failed    int                `json:"failed"`
+	Batches   int                `json:"batches"`
+	Current   string             `json:"current,omitempty"`
+	Failures  []ReencryptFailure `json:"failures,omitempty"`
+	Error     string             `json:"error,omitempty"`
+	CreatedAt time.Time          `json:"createdAt"`
+	UpdatedAt time.Time          `json:"updatedAt"`
+}
+
+func (j *NameKDFJob) finished() bool {
+	return j.Status == ReencryptDone || j.Status == ReencryptError || j.Status == ReencryptCanceled
+}
+
+// NameKDFJobs runs and persists name KDF migrations.
+type NameKDFJobs struct {
+	store *storage.Store
+
+	mu      sync.Mutex
+	jobs    map[string]*NameKDFJob
+	cancels map[string]context.CancelFunc
+}
+
+// NewNameKDFJobs creates the job registry, loading the jobs of earlier runs.
+func NewNameKDFJobs(store *storage.Store) *NameKDFJobs {
+	s := &NameKDFJobs{store: store, jobs: make(map[string]*NameKDFJob), cancels: make(map[string]context.CancelFunc)}
+	if store == nil {
+		return s
+	}
+	all, err := store.GetAll(storage.BucketNameKDF)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to load name KDF jobs")
+		return s
+	}
+	for _, value := range all {
+		var job NameKDFJob
+		if json.Unmarshal(value, &job) == nil && job.ID != "" {
+			s.jobs[job.ID] = &job
+		}
+	}
+	return s
+}
+
+// update changes job under the lock and, with persist, saves it.
+func (s *NameKDFJobs) update(job *NameKDFJob, persist bool, fn func(j *NameKDFJob)) {
+	s.mu.Lock()
+	fn(job)
+	job.UpdatedAt = time.Now()
+	snapshot := *job
+	s.mu.Unlock()
+	if persist && s.store != nil {
+		if err := s.store.SetJSON(storage.BucketNameKDF, snapshot.ID, &snapshot); err != nil {
+			log.Warn().Err(err).Str("job_id", snapshot.ID).Msg("Failed to save name KDF job progress")
+		}
+	}
+}
+
+// add registers and saves a new job.
+func (s *NameKDFJobs) add(job *NameKDFJob) {
+	s.mu.Lock()
+	s.jobs[job.ID] = job
+	s.mu.Unlock()
+	s.update(job, true, func(*NameKDFJob) {})
+}
+
+func (s *NameKDFJobs) fail(job *NameKDFJob, filePath string, err error) {
+	s.update(job, false, func(j *NameKDFJob) {
+		j.Failed++
+		if len(j.Failures) < reencryptMaxFailures {
+			j.Failures = append(j.Failures, ReencryptFailure{Path: filePath, Error: err.Error()})
+		}
+	})
+}
+
+func (s *NameKDFJobs) get(id string) (NameKDFJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return NameKDFJob{}, false
+	}
+	return *job, true
+}
+
+// list returns all jobs, newest first.
+func (s *NameKDFJobs) list() []NameKDFJob {
+	s.mu.Lock()
+	out := make([]NameKDFJob, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		out = append(out, *job)
+	}
+	s.mu.Unlock()
+	sort.Slice(out, func(a, b int) bool { return out[a].CreatedAt.After(out[b].CreatedAt) })
+	return out
+}
+
+func (s *NameKDFJobs) unfinished() []*NameKDFJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []*NameKDFJob
+	for _, job := range s.jobs {
+		if !job.finished() {
+			out = append(out, job)
+		}
+	}
+	return out
+}
+
+// running returns the unfinished job under or above dir, if any.
+func (s *NameKDFJobs) running(dir string) *NameKDFJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range s.jobs {
+		if !job.finished() && (pathutil.Within(dir, job.Path) || pathutil.Within(job.Path, dir)) {
+			return job
+		}
+	}
+	return nil
+}
+
+// SetNameKDFJobs enables /enc-api/nameKdf.
+func (h *AlistHandler) SetNameKDFJobs(jobs *NameKDFJobs) {
+	h.nameKDFJobs = jobs
+}
+
+// ResumeNameKDFJobs restarts the jobs an earlier process left unfinished.
+// They continue with the configured scan credentials.
+func (h *AlistHandler) ResumeNameKDFJobs() {
+	if h.nameKDFJobs == nil {
+		return
+	}
+	for _, job := range h.nameKDFJobs.unfinished() {
+		log.Info().Str("job_id", job.ID).Str("path", job.Path).Msg("Resuming name KDF migration")
+		h.startNameKDFJob(job, nil)
+	}
+}
+
+// HandleNameKDFStart serves POST /enc-api/nameKdf/start. The body names an
+// encrypted folder whose rule has a new nameKdf and, optionally, the KDF
+// its names were written with (fromKdf, default the rule's nameKdfPrevious).
+// The Alist token is taken from X-Alist-Token, falling back to the
+// configured scan credentials.
+func (h *AlistHandler) HandleNameKDFStart(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Path    string  `json:"path"`
+		FromKDF *string `json:"fromKdf"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		RespondAPIError(w, 400, "Invalid request")
+		return
+	}
+	if h.nameKDFJobs == nil {
+		RespondAPIError(w, 500, "name KDF jobs not initialized")
+		return
+	}
+	root := pathutil.CleanInput(req.Path)
+	rule, ok := h.passwdDAO.FindByDir(root)
+	if strings.TrimSpace(req.Path) == "" || !ok || !h.passwdDAO.MatchDir(root) {
+		RespondAPIError(w, 400, "path is not under an encrypted folder")
+		return
+	}
+	if !rule.EncName {
+		RespondAPIError(w, 400, "the folder's rule does not encrypt file names")
+		return
+	}
+	from := rule.NameKDFPrevious
+	if req.FromKDF != nil {
+		from = *req.FromKDF
+	}
+	from = encryption.NormalizeNameKDF(from)
+	to := encryption.NormalizeNameKDF(rule.NameKDF)
+	if !encryption.IsSupportedNameKDF(from) {
+		RespondAPIError(w, 400, "unsupported fromKdf")
+		return
+	}
+	if from == to {
+		RespondAPIError(w, 400, "names are already written with this KDF; set nameKdf on the rule first")
+		return
+	}
+	if h.nameKDFJobs.running(root) != nil {
+		RespondAPIError(w, 409, "a name KDF job is already running for this folder")
+		return
+	}
+
+	auth := make(http.Header)
+	if token := strings.TrimSpace(r.Header.Get("X-Alist-Token")); token != "" {
+		auth.Set("Authorization", token)
+	} else {
+		auth = h.scanAuthHeaders()
+	}
+	now := time.Now()
+	job := &NameKDFJob{
+		ID:        generateTaskID(),
+		Path:      root,
+		FromKDF:   from,
+		ToKDF:     to,
+		Status:    ReencryptQueued,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	h.nameKDFJobs.add(job)
+	log.Info().Str("job_id", job.ID).Str("path", root).Str("from", from).Str("to", to).Msg("Name KDF migration queued")
+	h.startNameKDFJob(job, auth)
+
+	snapshot, _ := h.nameKDFJobs.get(job.ID)
+	RespondSuccess(w, snapshot)
+}
+
+// HandleNameKDFStatus serves GET /enc-api/nameKdf/status?id=...; without an
+// id it lists all jobs.
+func (h *AlistHandler) HandleNameKDFStatus(w http.ResponseWriter, r *http.Request) {
+	if h.nameKDFJobs == nil {
+		RespondAPIError(w, 500, "name KDF jobs not initialized")
+		return
+	}
+	id := strings.TrimSpace(r.URL.Query().Get("id"))
+	if id == "" {
+		RespondSuccess(w, map[string]interface{}{"jobs": h.nameKDFJobs.list()})
+		return
+	}
+	job, ok := h.nameKDFJobs.get(id)
+	if !ok {
+		RespondAPIError(w, 404, "Task not found")
+		return
+	}
+	RespondSuccess(w, job)
+}
+
+// HandleNameKDFCancel serves POST /enc-api/nameKdf/cancel?id=.... The job
+// stops after the rename in progress; starting it again continues where it
+// stopped.
+func (h *AlistHandler) HandleNameKDFCancel(w http.ResponseWriter, r *http.Request) {
+	if h.nameKDFJobs == nil {
+		RespondAPIError(w, 500, "name KDF jobs not initialized")
+		return
+	}
+	id := strings.TrimSpace(r.URL.Query().Get("id"))
+	h.nameKDFJobs.mu.Lock()
+	cancel, ok := h.nameKDFJobs.cancels[id]
+	h.nameKDFJobs.mu.Unlock()
+	if !ok {
+		RespondAPIError(w, 404, "Task not found")
+		return
+	}
+	cancel()
+	RespondSuccessMsg(w, "stopped")
+}
+
+// startNameKDFJob runs job in the background once a jobs slot is free; a
+// nil auth means the scan credentials.
+func (h *AlistHandler) startNameKDFJob(job *NameKDFJob, auth http.Header) {
+	jobs := h.nameKDFJobs
+	ctx, cancel := context.WithCancel(context.Background())
+	jobs.mu.Lock()
+	jobs.cancels[job.ID] = cancel
+	jobs.mu.Unlock()
+	go func() {
+		defer func() {
+			cancel()
+			jobs.mu.Lock()
+			delete(jobs.cancels, job.ID)
+			jobs.mu.Unlock()
+		}()
+		pool := workers.Shared(config.PoolJobs, config.Get().WorkerLimit(config.PoolJobs))
+		if err := pool.Acquire(ctx); err != nil {
+			jobs.update(job, true, func(j *NameKDFJob) { j.Status = ReencryptCanceled })
+			return
+		}
+		defer pool.Release()
+		if auth == nil {
+			auth = h.scanAuthHeaders()
+		}
+		h.runNameKDFJob(ctx, job, auth)
+	}()
+}
+
+func (h *AlistHandler) runNameKDFJob(ctx context.Context, job *NameKDFJob, auth http.Header) {
+	jobs := h.nameKDFJobs
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error().Interface("panic", r).Str("job_id", job.ID).Msg("Name KDF job panicked")
+			jobs.update(job, true, func(j *NameKDFJob) {
+				j.Status = ReencryptError
+				j.Error = fmt.Sprintf("panic: %v", r)
+			})
+		}
+	}()
+	// A resumed run walks the whole folder again: what an earlier run
+	// renamed is counted as skipped, so only Renamed and Batches add up.
+	jobs.update(job, true, func(j *NameKDFJob) {
+		j.Status = ReencryptRunning
+		j.Error = ""
+		j.Dirs, j.Skipped, j.Failed, j.Failures = 0, 0, 0, nil
+	})
+
+	rule, ok := h.passwdDAO.FindByDir(job.Path)
+	if !ok || encryption.NormalizeNameKDF(rule.NameKDF) != job.ToKDF {
+		jobs.update(job, true, func(j *NameKDFJob) {
+			j.Status = ReencryptError
+			j.Error = "the folder's nameKdf changed since the job was started"
+		})
+		return
+	}
+	err := h.migrateNameKDF(ctx, job, rule, auth)
+	jobs.update(job, true, func(j *NameKDFJob) {
+		j.Current = ""
+		switch {
+		case ctx.Err() != nil:
+			j.Status = ReencryptCanceled
+		case err != nil:
+			j.Status = ReencryptError
+			j.Error = err.Error()
+		default:
+			j.Status = ReencryptDone
+		}
+	})
+	snapshot, _ := jobs.get(job.ID)
+	log.Info().Str("job_id", job.ID).Str("status", snapshot.Status).Int("renamed", snapshot.Renamed).
+		Int("skipped", snapshot.Skipped).Int("failed", snapshot.Failed).Msg("Name KDF migration finished")
+}
+
+// migrateNameKDF walks job.Path breadth-first like collectReencryptFiles,
+// renaming one directory at a time.
+func (h *AlistHandler) migrateNameKDF(ctx context.Context, job *NameKDFJob, rule *config.PasswdInfo, auth http.Header) error {
+	jobs := h.nameKDFJobs
+	allowLoose := h.cfg != nil && h.cfg.AlistServer.AllowLooseDecode
+	newNames := encryption.NewFileNameConverter(rule.Password, rule.EncType, rule.NameSuffix()).WithDialect(rule.NameDialect).WithKDF(job.ToKDF)
+	oldNames := encryption.NewFileNameConverter(rule.Password, rule.EncType, rule.NameSuffix()).WithDialect(rule.NameDialect).WithKDF(job.FromKDF)
+	type node struct {
+		displayDir, realDir string
+		depth               int
+	}
+	queue := []node{{displayDir: job.Path, realDir: h.realDirPath(job.Path)}}
+	inBatch := 0
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		content, err := h.listAlistDir(ctx, current.realDir, auth)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			if current.displayDir == job.Path {
+				return fmt.Errorf("failed to list %s: %w", job.Path, err)
+			}
+			jobs.fail(job, current.displayDir, err)
+			continue
+		}
+		existing := make(map[string]bool, len(content))
+		for _, raw := range content {
+			if fileData, ok := raw.(map[string]interface{}); ok {
+				name, _ := fileData["name"].(string)
+				existing[name] = true
+			}
+		}
+		renamed := false
+		for _, raw := range content {
+			fileData, ok := raw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _ := fileData["name"].(string)
+			if name == "" || strings.HasSuffix(name, uploadStagingSuffix) {
+				continue
+			}
+			if isDir, _ := fileData["is_dir"].(bool); isDir {
+				displayDir := path.Join(current.displayDir, name)
+				if sub, ok := h.passwdDAO.FindByDir(displayDir); !ok || sub.Password != rule.Password || sub.EncType != rule.EncType {
+					continue
+				}
+				if current.depth < inventoryMaxDepth {
+					queue = append(queue, node{displayDir: displayDir, realDir: path.Join(current.realDir, name), depth: current.depth + 1})
+				}
+				continue
+			}
+
+			if shown := newNames.ShowName(name, false); !encryption.IsOriginalFile(shown) && encryption.IsReadableName(shown) {
+				// Migrated by an earlier run.
+				jobs.update(job, false, func(j *NameKDFJob) { j.Skipped++ })
+				continue
+			}
+			shown := oldNames.ShowName(name, allowLoose)
+			if encryption.IsOriginalFile(shown) || !encryption.IsReadableName(shown) {
+				// Plain files and names under another key stay as they are.
+				jobs.update(job, false, func(j *NameKDFJob) { j.Skipped++ })
+				continue
+			}
+			displayPath := path.Join(current.displayDir, shown)
+			newName := newNames.ToRealName(shown)
+			if existing[newName] {
+				jobs.fail(job, displayPath, fmt.Errorf("%s already exists", newName))
+				continue
+			}
+			if h.maintenance.Paused() {
+				jobs.update(job, true, func(j *NameKDFJob) { j.Status = ReencryptPaused })
+				_ = h.maintenance.Wait(ctx, jobNameKDF)
+				jobs.update(job, false, func(j *NameKDFJob) { j.Status = ReencryptRunning })
+			}
+			if err := waitStorageRequest(ctx, displayPath); err != nil {
+				return err
+			}
+			jobs.update(job, false, func(j *NameKDFJob) { j.Current = displayPath })
+			apiReq, _ := http.NewRequestWithContext(ctx, http.MethodPost, "http://local/", nil)
+			apiReq.Header = auth
+			err := h.alistAPICall(ctx, apiReq, "/api/fs/rename", map[string]interface{}{
+				"path": path.Join(current.realDir, name),
+				"name": newName,
+			}, nil)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				jobs.fail(job, displayPath, err)
+				continue
+			}
+			existing[newName] = true
+			renamed = true
+			h.fileDAO.DeleteEncPathMapping(displayPath)
+			h.fileDAO.InvalidateDisplayPath(displayPath)
+			jobs.update(job, false, func(j *NameKDFJob) { j.Renamed++ })
+
+			if inBatch++; inBatch >= nameKDFBatchSize {
+				inBatch = 0
+				jobs.update(job, true, func(j *NameKDFJob) { j.Batches++ })
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(nameKDFBatchPause):
+				}
+			}
+		}
+		if renamed {
+			h.InvalidateListCache(current.displayDir)
+		}
+		jobs.update(job, true, func(j *NameKDFJob) { j.Dirs++ })
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/encryption"
+	"github.com/alist-encrypt-go/internal/storage"
+)
+
+func TestNameKDFJobRenamesLegacyNames(t *testing.T) {
+	passwd := &config.PasswdInfo{
+		Password: "name-pass",
+		EncType:  "aesctr",
+		Enable:   true,
+		EncName:  true,
+		EncPath:  []string{"/vault/*"},
+		NameKDF:  encryption.NameKDFArgon2id,
+	}
+	legacy := encryption.NewFileNameConverter(passwd.Password, passwd.EncType, "")
+	upgraded := encryption.NewFileNameConverter(passwd.Password, passwd.EncType, "").WithKDF(encryption.NameKDFArgon2id)
+	fs := &fakeAlistFS{files: map[string][]byte{
+		"/vault/" + legacy.ToRealName("movie.mkv"):  []byte("a"),
+		"/vault/" + upgraded.ToRealName("done.txt"): []byte("b"),
+		"/vault/readme.md":                          []byte("c"),
+	}}
+	srv := newSocketTestServer(t, fs.handler())
+	defer srv.Close()
+	handler, _ := newTestAlistHandler(t, srv.URL, passwd)
+	store, err := storage.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	handler.SetNameKDFJobs(NewNameKDFJobs(store))
+
+	// Before the migration the rule still lists the legacy name.
+	if shown := passwd.NameConverter().ShowName(legacy.ToRealName("movie.mkv"), false); shown != "movie.mkv" {
+		t.Fatalf("legacy name shown as %q", shown)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/enc-api/nameKdf/start", strings.NewReader(`{"path":"/vault"}`))
+	req.Header.Set("X-Alist-Token", "alist-token")
+	rec := httptest.NewRecorder()
+	handler.HandleNameKDFStart(rec, req)
+	var started struct {
+		Data NameKDFJob `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &started); err != nil || started.Data.ID == "" {
+		t.Fatalf("start: %s", rec.Body.String())
+	}
+
+	var job NameKDFJob
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if job, _ = handler.nameKDFJobs.get(started.Data.ID); job.finished() {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if job.Status != ReencryptDone || job.Renamed != 1 || job.Skipped != 2 || job.Failed != 0 {
+		t.Fatalf("job=%+v", job)
+	}
+	for _, name := range []string{upgraded.ToRealName("movie.mkv"), upgraded.ToRealName("done.txt"), "readme.md"} {
+		if _, ok := fs.files["/vault/"+name]; !ok {
+			t.Fatalf("%s missing after migration: %v", name, fs.files)
+		}
+	}
+
+	reloaded, ok := NewNameKDFJobs(store).get(job.ID)
+	if !ok || reloaded.Status != ReencryptDone || reloaded.Renamed != 1 {
+		t.Fatalf("persisted job=%+v", reloaded)
+	}
+}
+
+func TestNameKDFStartRejectsUnchangedKDF(t *testing.T) {
+	passwd := &config.PasswdInfo{Password: "p", EncType: "aesctr", Enable: true, EncName: true, EncPath: []string{"/vault/*"}}
+	handler, _ := newTestAlistHandler(t, "http://127.0.0.1:1", passwd)
+	handler.SetNameKDFJobs(NewNameKDFJobs(nil))
+	rec := httptest.NewRecorder()
+	handler.HandleNameKDFStart(rec, httptest.NewRequest(http.MethodPost, "/enc-api/nameKdf/start", strings.NewReader(`{"path":"/vault"}`)))
+	if !strings.Contains(rec.Body.String(), "already written with this KDF") {
+		t.Fatalf("body=%s", rec.Body.String())
+	}
+}
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"sort"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+// shortNameHashLen is the number of hex digits of the original name's hash
+// kept in a shortened name, so siblings cut to the same prefix stay apart.
+const shortNameHashLen = 8
+
+// nameTooLongError reports an encrypted name the storage would refuse.
+type nameTooLongError struct {
+	Name  string
+	Bytes int
+	Limit int
+}
+
+func (e *nameTooLongError) Error() string {
+	return fmt.Sprintf("file name %q is too long for this storage once encrypted (%d bytes, limit %d); use a shorter name", e.Name, e.Bytes, e.Limit)
+}
+
+// storedNameFor returns the encrypted name displayName is written under by
+// rule p, checked against the storage's name limit. With shorten (and
+// longNameAction "shorten") a name over the limit is replaced by a shorter
+// display name, returned as shownName, that still fits; otherwise the
+// error is a *nameTooLongError.
+func storedNameFor(cfg *config.Config, p *config.PasswdInfo, displayName string, shorten bool) (storedName, shownName string, err error) {
+	converter := p.NameConverter()
+	storedName = converter.ToRealName(displayName)
+	limit := cfg.NameByteLimit(p)
+	if limit <= 0 || len(storedName) <= limit {
+		return storedName, displayName, nil
+	}
+	tooLong := &nameTooLongError{Name: displayName, Bytes: len(storedName), Limit: limit}
+	if !shorten || cfg.AlistServer.LongNameAction != config.LongNameShorten {
+		return "", "", tooLong
+	}
+
+	// Keep as much of the name as fits: the encrypted length grows with the
+	// kept prefix, so the longest fitting prefix is found by bisection.
+	sum := sha256.Sum256([]byte(displayName))
+	tag := "~" + hex.EncodeToString(sum[:])[:shortNameHashLen]
+	ext := path.Ext(displayName)
+	base := []rune(displayName[:len(displayName)-len(ext)])
+	candidate := func(n int) string { return string(base[:n]) + tag + ext }
+	n := sort.Search(len(base)+1, func(n int) bool {
+		return len(converter.ToRealName(candidate(n))) > limit
+	}) - 1
+	if n < 0 {
+		return "", "", tooLong
+	}
+	shownName = candidate(n)
+	log.Info().Str("name", displayName).Str("shortened", shownName).Int("limit", limit).Msg("Shortened file name over the storage limit")
+	return converter.ToRealName(shownName), shownName, nil
+}
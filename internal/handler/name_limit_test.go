@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/errors"
+)
+
+func TestStoredNameForEnforcesStorageLimit(t *testing.T) {
+	cfg := config.DefaultConfig()
+	rule := &config.PasswdInfo{Password: "pw", EncType: "aesctr", EncName: true, Enable: true, MaxNameBytes: 120}
+	long := strings.Repeat("第一季 很长的文件名 ", 8) + ".mkv"
+
+	if stored, shown, err := storedNameFor(cfg, rule, "short.mkv", true); err != nil || shown != "short.mkv" || stored != rule.NameConverter().ToRealName("short.mkv") {
+		t.Fatalf("short name: stored=%q shown=%q err=%v", stored, shown, err)
+	}
+
+	_, _, err := storedNameFor(cfg, rule, long, true)
+	tooLong, ok := err.(*nameTooLongError)
+	if !ok || tooLong.Limit != 120 || tooLong.Bytes <= 120 {
+		t.Fatalf("reject: err=%v", err)
+	}
+
+	cfg.AlistServer.LongNameAction = config.LongNameShorten
+	stored, shown, err := storedNameFor(cfg, rule, long, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) > 120 || path.Ext(shown) != ".mkv" || !strings.HasPrefix(long, strings.Split(shown, "~")[0]) {
+		t.Fatalf("shortened stored=%q (%d bytes) shown=%q", stored, len(stored), shown)
+	}
+	if got := rule.NameConverter().ShowName(stored, false); got != shown {
+		t.Fatalf("shortened name decodes to %q, want %q", got, shown)
+	}
+	// Deterministic, so resumed chunks and retries land on the same object.
+	if again, _, _ := storedNameFor(cfg, rule, long, true); again != stored {
+		t.Fatalf("shortening is not stable: %q vs %q", again, stored)
+	}
+	// WebDAV never shortens.
+	if _, _, err := storedNameFor(cfg, rule, long, false); err == nil {
+		t.Fatal("shortened without permission")
+	}
+	rule.MaxNameBytes = -1
+	if _, shown, err := storedNameFor(cfg, rule, long, true); err != nil || shown != long {
+		t.Fatalf("unlimited rule: shown=%q err=%v", shown, err)
+	}
+}
+
+func TestHandleFsPutRejectsOverlongEncryptedName(t *testing.T) {
+	upstream := 0
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream++
+	}))
+	defer backend.Close()
+	passwd := &config.PasswdInfo{Password: "pw", EncType: "aesctr", EncName: true, Enable: true, EncPath: []string{"/enc/*"}}
+	handler, _ := newTestAlistHandler(t, backend.URL, passwd)
+
+	rec := httptest.NewRecorder()
+	handler.HandleFsPut(rec, newStagedPutRequest("/enc/"+strings.Repeat("a", 240)+".mp4", []byte("data")))
+	var resp struct {
+		Code      int    `json:"code"`
+		ErrorCode string `json:"error_code"`
+		Message   string `json:"message"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+	if rec.Code != http.StatusBadRequest || resp.ErrorCode != string(errors.CodeNameTooLong) || !strings.Contains(resp.Message, "limit 255") {
+		t.Fatalf("status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if upstream != 0 {
+		t.Fatalf("upload reached Alist %d times", upstream)
+	}
+}
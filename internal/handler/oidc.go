@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/auth"
+	"github.com/alist-encrypt-go/internal/dao"
+	"github.com/alist-encrypt-go/internal/proxy"
+)
+
+const (
+	oidcProviderName = "oidc"
+	oidcStateCookie  = "aeg_oidc_state"
+	oidcStateTTL     = 10 * time.Minute
+	oidcMaxPending   = 1024
+	oidcCallbackPath = "/enc-api/oidc/callback"
+)
+
+// oidcLogin is a login sent to the provider that has not come back yet.
+type oidcLogin struct {
+	nonce       string
+	verifier    string
+	redirectURL string
+	expires     time.Time
+}
+
+// oidcFlow holds the provider client, rebuilt when its settings change, and
+// the logins in flight keyed by state.
+type oidcFlow struct {
+	mu       sync.Mutex
+	provider *auth.OIDCProvider
+	settings string
+	pending  map[string]oidcLogin
+}
+
+// oidcProvider returns the relying party for the current oidc settings.
+func (h *APIHandler) oidcProvider() *auth.OIDCProvider {
+	o := h.cfg.OIDC
+	settings := o.Issuer + "\n" + o.ClientID + "\n" + o.ClientSecret
+	h.oidc.mu.Lock()
+	defer h.oidc.mu.Unlock()
+	if h.oidc.provider == nil || h.oidc.settings != settings {
+		h.oidc.provider = auth.NewOIDCProvider(o.Issuer, o.ClientID, o.ClientSecret, proxy.NewHTTPClient(h.cfg, 15*time.Second))
+		h.oidc.settings = settings
+	}
+	return h.oidc.provider
+}
+
+// OIDCLogin serves /enc-api/oidc/login: it redirects the browser to the
+// provider. The state is also set as a cookie so a callback only completes
+// in the browser that started the login.
+func (h *APIHandler) OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	if !h.cfg.OIDCEnabled() {
+		RespondAPIError(w, 404, "OIDC login is not enabled")
+		return
+	}
+	state, err1 := auth.NewOIDCSecret()
+	nonce, err2 := auth.NewOIDCSecret()
+	verifier, err3 := auth.NewOIDCSecret()
+	if err := errors.Join(err1, err2, err3); err != nil {
+		RespondAPIError(w, 500, err.Error())
+		return
+	}
+	redirectURL := h.cfg.OIDC.RedirectURL
+	if redirectURL == "" {
+		redirectURL = requestOrigin(r) + oidcCallbackPath
+	}
+
+	now := time.Now()
+	h.oidc.mu.Lock()
+	if h.oidc.pending == nil {
+		h.oidc.pending = make(map[string]oidcLogin)
+	}
+	for key, login := range h.oidc.pending {
+		if now.After(login.expires) {
+			delete(h.oidc.pending, key)
+		}
+	}
+	full := len(h.oidc.pending) >= oidcMaxPending
+	if !full {
+		h.oidc.pending[state] = oidcLogin{nonce: nonce, verifier: verifier, redirectURL: redirectURL, expires: now.Add(oidcStateTTL)}
+	}
+	h.oidc.mu.Unlock()
+	if full {
+		RespondHTTPErrorWithStatus(w, "Too many OIDC logins in progress", http.StatusServiceUnavailable)
+		return
+	}
+
+	target, err := h.oidcProvider().AuthURL(r.Context(), redirectURL, state, nonce, verifier, h.cfg.OIDC.Scopes)
+	if err != nil {
+		log.Warn().Err(err).Str("issuer", h.cfg.OIDC.Issuer).Msg("OIDC provider unavailable")
+		RespondHTTPErrorWithStatus(w, "OIDC provider unavailable", http.StatusBadGateway)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/enc-api/oidc/",
+		MaxAge:   int(oidcStateTTL / time.Second),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(requestOrigin(r), "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// OIDCCallback serves /enc-api/oidc/callback: it redeems the code, verifies
+// the ID token, maps the user's groups to a role and hands a management
+// token to the web UI.
+func (h *APIHandler) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if !h.cfg.OIDCEnabled() {
+		RespondAPIError(w, 404, "OIDC login is not enabled")
+		return
+	}
+	q := r.URL.Query()
+	state := q.Get("state")
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/enc-api/oidc/", MaxAge: -1})
+
+	h.oidc.mu.Lock()
+	login, ok := h.oidc.pending[state]
+	delete(h.oidc.pending, state)
+	h.oidc.mu.Unlock()
+	cookie, _ := r.Cookie(oidcStateCookie)
+	if state == "" || !ok || time.Now().After(login.expires) || cookie == nil || cookie.Value != state {
+		writeOIDCPage(w, http.StatusBadRequest, "", "The login expired or was started in another browser. Please try again.")
+		return
+	}
+	if e := q.Get("error"); e != "" {
+		writeOIDCPage(w, http.StatusUnauthorized, "", "The identity provider refused the login: "+strings.TrimSpace(e+" "+q.Get("error_description")))
+		return
+	}
+
+	provider := h.oidcProvider()
+	rawIDToken, err := provider.Exchange(r.Context(), q.Get("code"), login.redirectURL, login.verifier)
+	if err != nil {
+		log.Warn().Err(err).Msg("OIDC code exchange failed")
+		writeOIDCPage(w, http.StatusBadGateway, "", "Could not complete the login with the identity provider.")
+		return
+	}
+	claims, err := provider.Verify(r.Context(), rawIDToken, login.nonce)
+	if err != nil {
+		log.Warn().Err(err).Msg("OIDC ID token rejected")
+		writeOIDCPage(w, http.StatusUnauthorized, "", "The identity provider's answer could not be verified.")
+		return
+	}
+	username := strings.TrimSpace(auth.ClaimString(claims, h.cfg.OIDC.UserClaim))
+	if username == "" {
+		log.Warn().Str("claim", h.cfg.OIDC.UserClaim).Msg("OIDC ID token has no username claim")
+		writeOIDCPage(w, http.StatusUnauthorized, "", "The identity provider did not send a username ("+h.cfg.OIDC.UserClaim+").")
+		return
+	}
+	groups := auth.ClaimStrings(claims, h.cfg.OIDC.GroupsClaim)
+	role := h.cfg.OIDC.RoleForGroups(groups)
+	if role == "" {
+		log.Info().Str("username", username).Strs("groups", groups).Msg("OIDC login refused: no group maps to a role")
+		writeOIDCPage(w, http.StatusForbidden, "", username+" is not in a group allowed to manage this server.")
+		return
+	}
+
+	_, token, err := h.svc.LoginExternal(username, oidcProviderName, role, clientInfo(r))
+	if errors.Is(err, dao.ErrUserExists) {
+		writeOIDCPage(w, http.StatusConflict, "", username+" is a local account; sign in with its password instead.")
+		return
+	}
+	if err != nil {
+		log.Error().Err(err).Str("username", username).Msg("OIDC login failed")
+		writeOIDCPage(w, http.StatusInternalServerError, "", "Login failed.")
+		return
+	}
+	h.audit.Record(AuditEntry{Actor: username, Action: "user.login.oidc", Target: role, RemoteIP: remoteIP(r)})
+	log.Info().Str("username", username).Str("role", role).Msg("OIDC login")
+	writeOIDCPage(w, http.StatusOK, token, "")
+}
+
+// oidcPage finishes the browser side of a login: on success it stores the
+// token where the web UI keeps it (the persisted "basic" store) and opens the
+// UI, otherwise it shows the reason.
+var oidcPage = template.Must(template.New("oidc").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width, initial-scale=1"><title>Login</title></head>
+<body style="font-family: sans-serif; padding: 2em">
+{{if .Token}}<p>Signing in…</p>
+<script>
+try {
+  var store = JSON.parse(localStorage.getItem("basic") || "{}");
+  store.token = {{.Token}};
+  localStorage.setItem("basic", JSON.stringify(store));
+} catch (e) {
+  localStorage.setItem("basic", JSON.stringify({token: {{.Token}}}));
+}
+location.replace("/public/index.html#/");
+</script>
+{{else}}<p>{{.Message}}</p><p><a href="/public/index.html#/login">Back to login</a></p>{{end}}
+</body></html>
+`))
+
+func writeOIDCPage(w http.ResponseWriter, status int, token, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.WriteHeader(status)
+	_ = oidcPage.Execute(w, struct{ Token, Message string }{token, message})
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/dao"
+	"github.com/alist-encrypt-go/internal/storage"
+)
+
+// newOIDCTestProvider serves discovery, JWKS and a token endpoint that
+// answers any code with an ID token for username in groups, echoing the
+// nonce from the last authorization URL passed to setNonce.
+func newOIDCTestProvider(t *testing.T) (srv *httptest.Server, login func(username string, groups ...string), setNonce func(string)) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nonce, user string
+	var userGroups []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 srv.URL,
+			"authorization_endpoint": srv.URL + "/authorize",
+			"token_endpoint":         srv.URL + "/token",
+			"jwks_uri":               srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA", "kid": "k",
+			"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+			"iss": srv.URL, "aud": "encrypt", "exp": time.Now().Add(time.Hour).Unix(),
+			"nonce": nonce, "preferred_username": user, "groups": userGroups,
+		})
+		token.Header["kid"] = "k"
+		raw, _ := token.SignedString(key)
+		json.NewEncoder(w).Encode(map[string]string{"id_token": raw})
+	})
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, func(username string, groups ...string) { user, userGroups = username, groups }, func(n string) { nonce = n }
+}
+
+func TestOIDCLoginMapsGroupsToRoles(t *testing.T) {
+	provider, as, setNonce := newOIDCTestProvider(t)
+	store, err := storage.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	users := dao.NewUserDAO(store)
+	if err := users.Create("admin", "local-password"); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.DefaultConfig()
+	cfg.OIDC = &config.OIDCConfig{
+		Enable: true, Issuer: provider.URL, ClientID: "encrypt",
+		Scopes: []string{"openid", "groups"}, UserClaim: "preferred_username", GroupsClaim: "groups",
+		AdminGroups: []string{"admins"}, OperatorGroups: []string{"media"},
+	}
+	h := NewAPIHandler(cfg, users, nil, nil)
+
+	signIn := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.OIDCLogin(rr, httptest.NewRequest(http.MethodGet, "http://enc.example/enc-api/oidc/login", nil))
+		if rr.Code != http.StatusFound {
+			t.Fatalf("login status %d: %s", rr.Code, rr.Body.String())
+		}
+		loc, _ := url.Parse(rr.Header().Get("Location"))
+		q := loc.Query()
+		if q.Get("redirect_uri") != "http://enc.example/enc-api/oidc/callback" {
+			t.Fatalf("redirect_uri %q", q.Get("redirect_uri"))
+		}
+		setNonce(q.Get("nonce"))
+		req := httptest.NewRequest(http.MethodGet, "http://enc.example/enc-api/oidc/callback?code=c&state="+q.Get("state"), nil)
+		for _, c := range rr.Result().Cookies() {
+			req.AddCookie(c)
+		}
+		rr = httptest.NewRecorder()
+		h.OIDCCallback(rr, req)
+		return rr
+	}
+
+	as("alice", "media")
+	rr := signIn()
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `localStorage.setItem("basic"`) {
+		t.Fatalf("callback %d: %s", rr.Code, rr.Body.String())
+	}
+	if role := users.Role("alice"); role != dao.RoleOperator {
+		t.Fatalf("role=%q", role)
+	}
+	if err := users.Validate("alice", ""); err == nil {
+		t.Fatal("OIDC account accepted a password login")
+	}
+
+	// Groups are re-read on every login.
+	as("alice", "media", "admins")
+	if rr := signIn(); rr.Code != http.StatusOK || users.Role("alice") != dao.RoleAdmin {
+		t.Fatalf("promotion: %d role=%q", rr.Code, users.Role("alice"))
+	}
+
+	as("bob", "guests")
+	if rr := signIn(); rr.Code != http.StatusForbidden {
+		t.Fatalf("unmapped groups: %d", rr.Code)
+	}
+	as("admin", "admins")
+	if rr := signIn(); rr.Code != http.StatusConflict {
+		t.Fatalf("local account takeover: %d", rr.Code)
+	}
+
+	// A callback without the state cookie is refused.
+	rr = httptest.NewRecorder()
+	h.OIDCLogin(rr, httptest.NewRequest(http.MethodGet, "http://enc.example/enc-api/oidc/login", nil))
+	loc, _ := url.Parse(rr.Header().Get("Location"))
+	rr = httptest.NewRecorder()
+	h.OIDCCallback(rr, httptest.NewRequest(http.MethodGet, "http://enc.example/enc-api/oidc/callback?code=c&state="+loc.Query().Get("state"), nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("callback without cookie: %d", rr.Code)
+	}
+}
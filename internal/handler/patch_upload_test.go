@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/encryption"
+)
+
+func TestPatchUploadSplicesIntoEncryptedFile(t *testing.T) {
+	passwd := &config.PasswdInfo{Password: "patch-pass", EncType: "chacha20", Enable: true, EncName: true, EncPath: []string{"/vault/*"}}
+	realPath := "/vault/" + encryption.NewFileNameConverter(passwd.Password, passwd.EncType, "").ToRealName("archive.bin")
+	plain := bytes.Repeat([]byte("0123456789"), 3000)
+	fs := &fakeAlistFS{files: map[string][]byte{realPath: sealForTest(t, passwd.Password, passwd.EncType, plain)}}
+	srv := newSocketTestServer(t, fs.handler())
+	defer srv.Close()
+	handler, _ := newTestAlistHandler(t, srv.URL, passwd)
+
+	patch := func(offset int, data string) string {
+		req := httptest.NewRequest(http.MethodPut, "/enc-api/patch?path=/vault/archive.bin&offset="+strconv.Itoa(offset), strings.NewReader(data))
+		req.Header.Set("X-Alist-Token", "alist-token")
+		rec := httptest.NewRecorder()
+		handler.HandlePatchUpload(rec, req)
+		return rec.Body.String()
+	}
+	stored := func() []byte {
+		data := fs.files[realPath]
+		reader, _, err := encryption.AutoDecryptReader(passwd.Password, encryption.EncType(passwd.EncType), bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, _ := io.ReadAll(reader)
+		return out
+	}
+
+	if body := patch(12345, "PATCHED"); !strings.Contains(body, `"code":0`) {
+		t.Fatalf("patch: %s", body)
+	}
+	want := append([]byte(nil), plain...)
+	copy(want[12345:], "PATCHED")
+	if got := stored(); !bytes.Equal(got, want) {
+		t.Fatal("patched content mismatch")
+	}
+
+	if body := patch(len(want)-3, "TAIL+"); !strings.Contains(body, `"size":30002`) {
+		t.Fatalf("extending patch: %s", body)
+	}
+	want = append(want[:len(want)-3], "TAIL+"...)
+	if got := stored(); !bytes.Equal(got, want) {
+		t.Fatalf("extended content mismatch: %q", got[len(got)-8:])
+	}
+	if len(fs.files) != 1 {
+		t.Fatalf("leftover files: %d", len(fs.files))
+	}
+
+	if body := patch(len(want)+1, "x"); !strings.Contains(body, `"code":400`) {
+		t.Fatalf("patch past EOF: %s", body)
+	}
+}
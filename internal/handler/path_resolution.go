@@ -19,7 +19,8 @@ const (
 )
 
 func resolveEncryptedRealPath(fileDAO *dao.FileDAO, passwdInfo *config.PasswdInfo, displayPath string, allowLoose bool) (string, string) {
-	if passwdInfo == nil || !passwdInfo.EncName {
+	// A trailing slash names a folder, and folder names are never encrypted.
+	if passwdInfo == nil || !passwdInfo.EncName || strings.HasSuffix(displayPath, "/") {
 		return displayPath, pathModePlain
 	}
 
@@ -38,14 +39,8 @@ func resolveEncryptedRealPath(fileDAO *dao.FileDAO, passwdInfo *config.PasswdInf
 		return path.Join(path.Dir(displayPath), realName), pathModeOriginalPassthrough
 	}
 
-	converter := encryption.NewFileNameConverter(passwdInfo.Password, passwdInfo.EncType, passwdInfo.EncSuffix)
-	decryptedName := encryption.ConvertShowNameWithSuffixOptions(
-		passwdInfo.Password,
-		passwdInfo.EncType,
-		fileName,
-		passwdInfo.EncSuffix,
-		allowLoose,
-	)
+	converter := passwdInfo.NameConverter()
+	decryptedName := converter.ShowName(fileName, allowLoose)
 	if decryptedName != "" && !encryption.IsOriginalFile(decryptedName) && decryptedName != fileName {
 		if converter.ToRealName(decryptedName) == fileName {
 			return displayPath, pathModeEncryptedNamePassthrough
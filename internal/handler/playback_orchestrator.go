@@ -12,6 +12,7 @@ import (
 	"github.com/alist-encrypt-go/internal/config"
 	"github.com/alist-encrypt-go/internal/dao"
 	"github.com/alist-encrypt-go/internal/encryption"
+	"github.com/alist-encrypt-go/internal/errors"
 	"github.com/alist-encrypt-go/internal/httputil"
 	"github.com/alist-encrypt-go/internal/proxy"
 )
@@ -37,6 +38,15 @@ type decryptPlaybackRequest struct {
 	ConsumerScenario string
 	FailureLogMsg    string
 	LogCategory      string
+	// LocalPath, when set, is tried first: once Alist accepts the client's
+	// credentials for LocalAuthURL the file is decrypted straight from disk,
+	// and the upstream path is used if they are refused or the file cannot
+	// be opened.
+	LocalPath    string
+	LocalAuthURL string
+
+	PlayStats    *PlaybackStats
+	ReadVerifier *ReadVerifier
 
 	FinalPassthroughCount *uint64
 	SizeConflictCount     *uint64
@@ -46,6 +56,11 @@ type decryptPlaybackRequest struct {
 }
 
 func executeDecryptPlayback(req decryptPlaybackRequest) {
+	cachePath := req.FileItem.DisplayPath
+	if cachePath == "" {
+		cachePath = req.Path
+	}
+	req.ResponseWriter = withCacheControl(req.ResponseWriter, cacheControlFor(req.Config, config.CacheTargetFile, cachePath))
 	w := req.ResponseWriter
 	r := req.Request
 	if req.StreamProxy != nil {
@@ -61,6 +76,36 @@ func executeDecryptPlayback(req decryptPlaybackRequest) {
 		}
 		defer release()
 	}
+	releaseStorage, ok := acquireClientStorageStream(w, r, cachePath)
+	if !ok {
+		return
+	}
+	defer releaseStorage()
+	if req.PlayStats != nil {
+		counter := &playbackCountingWriter{ResponseWriter: w}
+		w = counter
+		req.ResponseWriter = counter
+		displayPath, play := req.FileItem.DisplayPath, isPlaybackStart(r)
+		if displayPath == "" {
+			displayPath = req.Path
+		}
+		defer func() { req.PlayStats.Record(displayPath, counter.n, play && counter.n > 0) }()
+	}
+	if req.ReadVerifier != nil {
+		displayPath := req.FileItem.DisplayPath
+		if displayPath == "" {
+			displayPath = req.Path
+		}
+		hashed, done := req.ReadVerifier.Wrap(w, r, displayPath)
+		defer done()
+		w = hashed
+		req.ResponseWriter = hashed
+	}
+	if req.LocalPath != "" && req.StreamProxy != nil {
+		if req.StreamProxy.ServeLocalDecrypt(w, r, req.LocalAuthURL, req.LocalPath, req.PasswdInfo) {
+			return
+		}
+	}
 	fileSize := req.InitialSize
 	authHeaders := make(http.Header)
 	if auth := r.Header.Get("Authorization"); auth != "" {
@@ -124,7 +169,7 @@ func executeDecryptPlayback(req decryptPlaybackRequest) {
 
 	if fileSize == 0 {
 		if req.Config == nil || req.Config.AlistServer.SizeUnknownStrict {
-			RespondHTTPErrorWithStatus(w, "Unable to determine encrypted file size", http.StatusBadGateway)
+			RespondCodedError(w, errors.CodeSizeUnknown, "Unable to determine encrypted file size", http.StatusBadGateway)
 			return
 		}
 		if err := req.StreamProxy.ProxyRequest(w, r, req.TargetURL); err != nil {
@@ -362,15 +407,21 @@ func executeDecryptPlayback(req decryptPlaybackRequest) {
 		RespondHTTPErrorWithStatus(w, "Range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
 		return
 	}
+	if lastFailure == "range_invalid" {
+		// The client asked for bytes past the end (Content-Range: bytes */size
+		// is already set); the file itself is fine.
+		RespondHTTPErrorWithStatus(w, "Range not satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
 	if lastErr != nil {
 		invalidatePlaybackState(req, lastFailure)
 		log.Error().Err(lastErr).Str("path", req.Path).Str("failure", lastFailure).Msg(req.FailureLogMsg)
-		RespondHTTPErrorWithStatus(w, "Decryption error: "+lastFailure, http.StatusBadGateway)
+		RespondCodedError(w, errors.CodeForFailure(lastFailure), "Decryption error: "+lastFailure, http.StatusBadGateway)
 		return
 	}
 	invalidatePlaybackState(req, lastFailure)
 	log.Error().Str("path", req.Path).Str("failure", lastFailure).Msg(req.FailureLogMsg)
-	RespondHTTPErrorWithStatus(w, "Decryption failed: "+lastFailure, http.StatusBadGateway)
+	RespondCodedError(w, errors.CodeForFailure(lastFailure), "Decryption failed: "+lastFailure, http.StatusBadGateway)
 }
 
 func isWebDAVUpstreamFailure(reason string) bool {
@@ -467,7 +518,7 @@ func inspectPlaybackContentMeta(req decryptPlaybackRequest, authHeaders http.Hea
 			if meta.EncType == "" {
 				meta.EncType = encryption.EncType(req.PasswdInfo.EncType)
 			}
-			if meta.IsV2() && meta.PlainSize > 0 {
+			if meta.HasContentHeader() && meta.PlainSize > 0 {
 				log.Info().
 					Str("category", "playback").
 					Str("consumer_scenario", req.ConsumerScenario).
@@ -552,7 +603,7 @@ func shouldSkipHTTPContentMetaInspection(req decryptPlaybackRequest, fallbackSiz
 }
 
 func cachePlaybackContentMeta(req decryptPlaybackRequest, meta encryption.ContentMeta) {
-	if req.FileDAO == nil || req.FileItem.DisplayPath == "" || !meta.IsV2() || meta.PlainSize <= 0 {
+	if req.FileDAO == nil || req.FileItem.DisplayPath == "" || !meta.HasContentHeader() || meta.PlainSize <= 0 {
 		return
 	}
 	info := &dao.FileInfo{
@@ -622,6 +673,9 @@ func maybeEnqueueFirstFrameWarmup(req decryptPlaybackRequest, authHeaders http.H
 	if !firstFrameHint || req.Probe == nil || req.Request == nil || req.Request.Method != http.MethodGet {
 		return
 	}
+	if req.Config != nil && !req.Config.AlistServer.EnablePrefetch {
+		return
+	}
 	reportedSize := size
 	if expectedBytes > reportedSize {
 		reportedSize = expectedBytes
@@ -0,0 +1,278 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/storage"
+)
+
+const (
+	playbackStatsFlushInterval = time.Minute
+	playbackStatsRetainDays    = 90
+	playbackStatsDayLayout     = "2006-01-02"
+)
+
+// PlaybackCounter is one file's totals within a daily rollup.
+type PlaybackCounter struct {
+	Plays      int64     `json:"plays"`
+	Bytes      int64     `json:"bytes"`
+	LastPlayed time.Time `json:"last_played"`
+}
+
+// PlaybackTopEntry is one row of the top-content report.
+type PlaybackTopEntry struct {
+	Path string `json:"path"`
+	PlaybackCounter
+	Days int `json:"days_played"`
+}
+
+// PlaybackStats aggregates decrypted playback per display path. Counters are
+// kept in memory and merged into one BoltDB record per day ("YYYY-MM-DD" →
+// path → counter) every playbackStatsFlushInterval, so streaming never waits
+// on a database write.
+type PlaybackStats struct {
+	store *storage.Store
+
+	mu      sync.Mutex
+	pending map[string]map[string]*PlaybackCounter // day -> path -> delta
+}
+
+// NewPlaybackStats creates a collector persisting into store.
+func NewPlaybackStats(store *storage.Store) *PlaybackStats {
+	if store == nil {
+		return nil
+	}
+	return &PlaybackStats{store: store, pending: make(map[string]map[string]*PlaybackCounter)}
+}
+
+// Record adds bytes streamed for displayPath. play marks the request as the
+// start of a playback (no Range, or a range starting at 0) so seeks within one
+// viewing do not inflate the play count.
+func (p *PlaybackStats) Record(displayPath string, bytes int64, play bool) {
+	if p == nil || displayPath == "" || (bytes <= 0 && !play) {
+		return
+	}
+	now := time.Now()
+	day := now.Format(playbackStatsDayLayout)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	files := p.pending[day]
+	if files == nil {
+		files = make(map[string]*PlaybackCounter)
+		p.pending[day] = files
+	}
+	c := files[displayPath]
+	if c == nil {
+		c = &PlaybackCounter{}
+		files[displayPath] = c
+	}
+	c.Bytes += bytes
+	if play {
+		c.Plays++
+	}
+	c.LastPlayed = now
+}
+
+// Start flushes pending counters periodically until ctx is cancelled, then
+// flushes once more.
+func (p *PlaybackStats) Start(ctx context.Context) {
+	if p == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(playbackStatsFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				p.Flush()
+				return
+			case <-ticker.C:
+				p.Flush()
+			}
+		}
+	}()
+}
+
+// Flush merges pending counters into the daily rollups and prunes days older
+// than playbackStatsRetainDays.
+func (p *PlaybackStats) Flush() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	pending := p.pending
+	if len(pending) == 0 {
+		p.mu.Unlock()
+		return
+	}
+	p.pending = make(map[string]map[string]*PlaybackCounter)
+	p.mu.Unlock()
+
+	cutoff := time.Now().AddDate(0, 0, -playbackStatsRetainDays).Format(playbackStatsDayLayout)
+	err := p.store.UpdateBucket(storage.BucketPlayback, func(tx *storage.BucketTx) error {
+		for day, files := range pending {
+			rollup := map[string]*PlaybackCounter{}
+			if err := tx.GetJSON(day, &rollup); err != nil {
+				return err
+			}
+			for filePath, delta := range files {
+				c := rollup[filePath]
+				if c == nil {
+					c = &PlaybackCounter{}
+					rollup[filePath] = c
+				}
+				c.Plays += delta.Plays
+				c.Bytes += delta.Bytes
+				if delta.LastPlayed.After(c.LastPlayed) {
+					c.LastPlayed = delta.LastPlayed
+				}
+			}
+			if err := tx.SetJSON(day, rollup); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to persist playback stats")
+		return
+	}
+	if days, err := p.store.ListKeys(storage.BucketPlayback); err == nil {
+		for _, day := range days {
+			if day < cutoff {
+				_ = p.store.Delete(storage.BucketPlayback, day)
+			}
+		}
+	}
+}
+
+// Top returns the most played files over the last days days, ordered by
+// plays (or bytes when byBytes), limited to limit rows.
+func (p *PlaybackStats) Top(days, limit int, byBytes bool) ([]PlaybackTopEntry, error) {
+	p.Flush()
+	all, err := p.store.GetAll(storage.BucketPlayback)
+	if err != nil {
+		return nil, err
+	}
+	from := time.Now().AddDate(0, 0, -(days - 1)).Format(playbackStatsDayLayout)
+	totals := map[string]*PlaybackTopEntry{}
+	for day, raw := range all {
+		if day < from {
+			continue
+		}
+		rollup := map[string]*PlaybackCounter{}
+		if err := json.Unmarshal(raw, &rollup); err != nil {
+			continue
+		}
+		for filePath, c := range rollup {
+			entry := totals[filePath]
+			if entry == nil {
+				entry = &PlaybackTopEntry{Path: filePath}
+				totals[filePath] = entry
+			}
+			entry.Plays += c.Plays
+			entry.Bytes += c.Bytes
+			entry.Days++
+			if c.LastPlayed.After(entry.LastPlayed) {
+				entry.LastPlayed = c.LastPlayed
+			}
+		}
+	}
+	out := make([]PlaybackTopEntry, 0, len(totals))
+	for _, entry := range totals {
+		out = append(out, *entry)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		if byBytes && a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		if a.Plays != b.Plays {
+			return a.Plays > b.Plays
+		}
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return a.Path < b.Path
+	})
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+// HandleTopReport serves /enc-api/reports/top?days=7&limit=50&sort=plays|bytes.
+func (p *PlaybackStats) HandleTopReport(w http.ResponseWriter, r *http.Request) {
+	if p == nil {
+		RespondAPIError(w, 503, "playback stats unavailable")
+		return
+	}
+	q := r.URL.Query()
+	days, _ := strconv.Atoi(q.Get("days"))
+	if days <= 0 {
+		days = 7
+	}
+	days = clampInt(days, 1, playbackStatsRetainDays)
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if limit <= 0 {
+		limit = 50
+	}
+	limit = clampInt(limit, 1, 1000)
+	sortBy := "plays"
+	if strings.EqualFold(q.Get("sort"), "bytes") {
+		sortBy = "bytes"
+	}
+
+	entries, err := p.Top(days, limit, sortBy == "bytes")
+	if err != nil {
+		RespondAPIError(w, 500, err.Error())
+		return
+	}
+	RespondSuccess(w, map[string]interface{}{
+		"days":  days,
+		"sort":  sortBy,
+		"items": entries,
+	})
+}
+
+// isPlaybackStart reports whether a request begins a viewing rather than
+// seeking within one.
+func isPlaybackStart(r *http.Request) bool {
+	if r.Method == http.MethodHead {
+		return false
+	}
+	rangeHeader := strings.TrimSpace(r.Header.Get("Range"))
+	return rangeHeader == "" || strings.HasPrefix(rangeHeader, "bytes=0-")
+}
+
+// playbackCountingWriter counts body bytes delivered to the client.
+type playbackCountingWriter struct {
+	http.ResponseWriter
+	n int64
+}
+
+func (w *playbackCountingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *playbackCountingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *playbackCountingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
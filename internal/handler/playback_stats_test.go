@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/storage"
+)
+
+func TestPlaybackStatsTopReport(t *testing.T) {
+	store, err := storage.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer store.Close()
+	stats := NewPlaybackStats(store)
+
+	stats.Record("/movies/a.mkv", 100, true)
+	stats.Flush()
+	stats.Record("/movies/a.mkv", 50, false) // seek within the same viewing
+	stats.Record("/movies/b.mkv", 1000, true)
+	stats.Record("/movies/a.mkv", 10, true)
+
+	rec := httptest.NewRecorder()
+	stats.HandleTopReport(rec, httptest.NewRequest(http.MethodGet, "/enc-api/reports/top?days=1", nil))
+	var resp struct {
+		Data struct {
+			Items []PlaybackTopEntry `json:"items"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v body=%s", err, rec.Body.String())
+	}
+	items := resp.Data.Items
+	if len(items) != 2 || items[0].Path != "/movies/a.mkv" || items[0].Plays != 2 || items[0].Bytes != 160 {
+		t.Fatalf("by plays: %+v", items)
+	}
+
+	top, err := stats.Top(1, 1, true)
+	if err != nil || len(top) != 1 || top[0].Path != "/movies/b.mkv" {
+		t.Fatalf("by bytes: %+v err=%v", top, err)
+	}
+}
+
+func TestIsPlaybackStart(t *testing.T) {
+	for rangeHeader, want := range map[string]bool{"": true, "bytes=0-": true, "bytes=0-1023": true, "bytes=4096-": false} {
+		r := httptest.NewRequest(http.MethodGet, "/d/a.mkv", nil)
+		if rangeHeader != "" {
+			r.Header.Set("Range", rangeHeader)
+		}
+		if got := isPlaybackStart(r); got != want {
+			t.Fatalf("Range %q: got %v, want %v", rangeHeader, got, want)
+		}
+	}
+}
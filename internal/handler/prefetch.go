@@ -10,31 +10,34 @@ import (
 	"github.com/alist-encrypt-go/internal/dao"
 	"github.com/alist-encrypt-go/internal/httputil"
 	"github.com/alist-encrypt-go/internal/proxy"
+	"github.com/alist-encrypt-go/internal/workers"
 	"github.com/rs/zerolog/log"
 )
 
 // PrefetchManager handles background prefetching of file metadata
 type PrefetchManager struct {
-	fileDAO      *dao.FileDAO
-	cfg          *config.Config
-	semaphore    chan struct{} // Limit concurrent prefetch requests
-	activeJobs   sync.Map      // Track active prefetch jobs to avoid duplicates
-	maxWorkers   int
-	shutdownChan chan struct{}
+	fileDAO     *dao.FileDAO
+	cfg         *config.Config
+	pool        *workers.Pool // Limit concurrent prefetch requests
+	activeJobs  sync.Map      // Track active prefetch jobs to avoid duplicates
+	shutdownCtx context.Context
+	shutdown    context.CancelFunc
 }
 
 // NewPrefetchManager creates a new prefetch manager
 func NewPrefetchManager(cfg *config.Config, fileDAO *dao.FileDAO, maxWorkers int) *PrefetchManager {
+	pool := workers.New(config.PoolPrefetch, maxWorkers)
 	if maxWorkers <= 0 {
-		maxWorkers = 10 // Default: 10 concurrent prefetch workers
+		pool = workers.Shared(config.PoolPrefetch, cfg.WorkerLimit(config.PoolPrefetch))
 	}
 
+	shutdownCtx, shutdown := context.WithCancel(context.Background())
 	pm := &PrefetchManager{
-		fileDAO:      fileDAO,
-		cfg:          cfg,
-		semaphore:    make(chan struct{}, maxWorkers),
-		maxWorkers:   maxWorkers,
-		shutdownChan: make(chan struct{}),
+		fileDAO:     fileDAO,
+		cfg:         cfg,
+		pool:        pool,
+		shutdownCtx: shutdownCtx,
+		shutdown:    shutdown,
 	}
 
 	return pm
@@ -57,12 +60,10 @@ func (pm *PrefetchManager) PrefetchFileSize(displayPath, encryptedPath string, p
 		defer pm.activeJobs.Delete(encryptedPath)
 
 		// Acquire semaphore (limit concurrent requests)
-		select {
-		case pm.semaphore <- struct{}{}:
-			defer func() { <-pm.semaphore }()
-		case <-pm.shutdownChan:
+		if err := pm.pool.Acquire(pm.shutdownCtx); err != nil {
 			return
 		}
+		defer pm.pool.Release()
 
 		// Add small delay to avoid thundering herd
 		time.Sleep(50 * time.Millisecond)
@@ -162,7 +163,7 @@ func (pm *PrefetchManager) PrefetchBatchWithAuth(items []PrefetchItem, r *http.R
 
 // Shutdown gracefully stops the prefetch manager
 func (pm *PrefetchManager) Shutdown() {
-	close(pm.shutdownChan)
+	pm.shutdown()
 }
 
 // Stats returns prefetch manager statistics
@@ -174,7 +175,7 @@ func (pm *PrefetchManager) Stats() map[string]interface{} {
 	})
 
 	return map[string]interface{}{
-		"max_workers":     pm.maxWorkers,
+		"max_workers":     pm.pool.Size(),
 		"active_jobs":     activeCount,
 		"file_size_cache": pm.fileDAO.FileSizeCacheStats(),
 	}
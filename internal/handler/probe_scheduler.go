@@ -28,6 +28,7 @@ type ProbeScheduler struct {
 	metaStore FileMetaStore
 	stream    *proxy.StreamProxy
 	enabled   bool
+	gate      *MaintenanceGate
 
 	queue    chan probeItem
 	workers  int
@@ -246,6 +247,11 @@ func NewProbeScheduler(cfg *config.Config, fileDAO *dao.FileDAO, metaStore FileM
 	return ps
 }
 
+// SetMaintenanceGate holds queued probes during quiet hours.
+func (ps *ProbeScheduler) SetMaintenanceGate(gate *MaintenanceGate) {
+	ps.gate = gate
+}
+
 func (ps *ProbeScheduler) Enqueue(file FileItem, authHeaders http.Header) {
 	ps.EnqueueWithSource(file, authHeaders, 0, probeSourceUnspecified)
 }
@@ -386,6 +392,8 @@ func (ps *ProbeScheduler) shouldProbeRange(file FileItem, size int64) bool {
 
 func (ps *ProbeScheduler) worker() {
 	for item := range ps.queue {
+		_ = ps.gate.Wait(context.Background(), jobProbe)
+		_ = waitStorageRequest(context.Background(), item.file.DisplayPath)
 		ps.runItem(item)
 	}
 }
@@ -463,7 +471,7 @@ func (ps *ProbeScheduler) runItem(item probeItem) {
 			atomic.AddUint64(&ps.filesRawURLFetched, 1)
 			if item.file.PasswdInfo != nil && ps.stream != nil {
 				meta := ps.stream.InspectEncryptedContent(context.Background(), rawURLResult.RawURL, authHeaders, item.file.PasswdInfo, rawURLResult.Size)
-				if meta.IsV2() && meta.PlainSize > 0 {
+				if meta.HasContentHeader() && meta.PlainSize > 0 {
 					cached := &dao.FileInfo{
 						Path:              item.file.DisplayPath,
 						EncryptedPath:     item.file.EncryptedPath,
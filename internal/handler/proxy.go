@@ -1,11 +1,9 @@
 package handler
 
 import (
+	"bytes"
 	"context"
-	"crypto/md5"
-	"encoding/hex"
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
 	"net/url"
@@ -19,7 +17,9 @@ import (
 
 	"github.com/alist-encrypt-go/internal/config"
 	"github.com/alist-encrypt-go/internal/dao"
+	"github.com/alist-encrypt-go/internal/errors"
 	"github.com/alist-encrypt-go/internal/httputil"
+	"github.com/alist-encrypt-go/internal/pathutil"
 	"github.com/alist-encrypt-go/internal/proxy"
 	"github.com/alist-encrypt-go/internal/trace"
 )
@@ -31,14 +31,20 @@ type ProxyHandler struct {
 	fileDAO               *dao.FileDAO
 	passwdDAO             *dao.PasswdDAO
 	redirectMap           sync.Map // key -> redirect info
+	redirectCount         int64
 	client                *proxy.Client
 	shortClient           *http.Client // shared short-timeout client for HEAD/probe ops
-	redirectKeys          []string
-	keysMu                sync.Mutex
+	keyring               *redirectKeyring
+	signer                *redirectSigner // nil unless enableSignedRedirect
+	resumer               *resumeSigner   // nil unless enableResumeTokens
 	strategyCache         *StrategyCache
 	sizeResolver          *FileSizeResolver
 	strategySel           *StrategySelector
 	probe                 *ProbeScheduler
+	playStats             *PlaybackStats
+	readVerifier          *ReadVerifier
+	audit                 *AuditLog
+	staticCache           *staticAssetCache
 	finalPassthroughCount uint64
 	sizeConflictCount     uint64
 	strategyFallbackCount uint64
@@ -68,20 +74,9 @@ type redirectInfo struct {
 
 // Stats returns proxy handler statistics
 func (h *ProxyHandler) Stats() map[string]interface{} {
-	redirectCount := 0
-	h.redirectMap.Range(func(_, _ interface{}) bool {
-		redirectCount++
-		return true
-	})
-
-	h.keysMu.Lock()
-	keysLen := len(h.redirectKeys)
-	h.keysMu.Unlock()
-
 	return map[string]interface{}{
 		"redirects": map[string]interface{}{
-			"entries": redirectCount,
-			"keys":    keysLen,
+			"entries": atomic.LoadInt64(&h.redirectCount),
 			"max":     maxRedirectEntries,
 		},
 		"strategy_cache": h.strategyCache.Stats(),
@@ -106,7 +101,8 @@ func (h *ProxyHandler) Stats() map[string]interface{} {
 			}
 			return nil
 		}(),
-		"prefetch": h.prefetchStats(),
+		"prefetch":     h.prefetchStats(),
+		"static_cache": h.staticCache.Stats(),
 	}
 }
 
@@ -136,10 +132,28 @@ func NewProxyHandler(cfg *config.Config, streamProxy *proxy.StreamProxy, fileDAO
 		client:        proxy.NewClient(cfg),
 		shortClient:   proxy.NewHTTPClientWithTransport(sharedTransport, 10*time.Second),
 		strategyCache: NewStrategyCache(1000),
-		sizeResolver:  NewFileSizeResolver(cfg, fileDAO, metaStore, 20, getMinMetaSize(cfg), getRedirectMaxHops(cfg)),
+		sizeResolver:  NewFileSizeResolver(cfg, fileDAO, metaStore, 0, getMinMetaSize(cfg), getRedirectMaxHops(cfg)),
 		strategySel:   selector,
+		staticCache:   newStaticAssetCache(staticCacheMb(cfg)),
 		stopCleanup:   make(chan struct{}),
 	}
+	jwtSecret := ""
+	if cfg != nil {
+		jwtSecret = cfg.JWTSecret
+	}
+	h.keyring = newRedirectKeyring(jwtSecret)
+	fileDAO.OnForget(h.strategyCache.InvalidateUnder)
+	if cfg != nil && cfg.AlistServer.EnableSignedRedirect {
+		h.signer = newRedirectSigner(cfg.JWTSecret,
+			time.Duration(cfg.AlistServer.SignedRedirectTTLSeconds)*time.Second,
+			cfg.AlistServer.SignedRedirectBindIP,
+			cfg.AlistServer.SignedRedirectSingleUse)
+	}
+	if cfg != nil && cfg.AlistServer.EnableResumeTokens {
+		h.resumer = newResumeSigner(cfg.JWTSecret,
+			time.Duration(cfg.AlistServer.ResumeTokenTTLHours)*time.Hour,
+			int64(cfg.AlistServer.ResumeTokenMinSizeMb)*1024*1024)
+	}
 	if h.streamProxy != nil {
 		h.streamProxy.SetRedirectRewriter(h.rewriteRedirectLocation)
 	}
@@ -157,10 +171,30 @@ func (h *ProxyHandler) Stop() {
 	})
 }
 
+// ApplyConfig resizes the static asset cache after staticCacheMb changed.
+func (h *ProxyHandler) ApplyConfig(cfg *config.Config) {
+	h.staticCache.resize(staticCacheMb(cfg))
+}
+
 func (h *ProxyHandler) SetProbeScheduler(probe *ProbeScheduler) {
 	h.probe = probe
 }
 
+// SetPlaybackStats enables per-file playback accounting.
+func (h *ProxyHandler) SetPlaybackStats(stats *PlaybackStats) {
+	h.playStats = stats
+}
+
+// SetAuditLog records reads blocked by access windows in audit.
+func (h *ProxyHandler) SetAuditLog(audit *AuditLog) {
+	h.audit = audit
+}
+
+// SetReadVerifier enables sampled hash checks of decrypted downloads.
+func (h *ProxyHandler) SetReadVerifier(verifier *ReadVerifier) {
+	h.readVerifier = verifier
+}
+
 func (h *ProxyHandler) cleanupRedirects() {
 	ticker := time.NewTicker(5 * time.Minute)
 	defer ticker.Stop()
@@ -169,34 +203,37 @@ func (h *ProxyHandler) cleanupRedirects() {
 		case <-h.stopCleanup:
 			return
 		case <-ticker.C:
-			now := time.Now()
-			h.redirectMap.Range(func(key, value interface{}) bool {
-				info := value.(*redirectInfo)
-				if now.After(info.ExpiresAt) {
-					h.redirectMap.Delete(key)
-				}
-				return true
-			})
-
-			// Cleanup redirectKeys slice to prevent memory leak
-			// Remove entries that no longer exist in the map
-			h.cleanupRedirectKeys()
+			h.evictRedirects(time.Now(), maxRedirectEntries)
+			if h.signer != nil {
+				h.signer.purge()
+			}
 		}
 	}
 }
 
-// cleanupRedirectKeys removes stale entries from redirectKeys slice
-func (h *ProxyHandler) cleanupRedirectKeys() {
-	h.keysMu.Lock()
-	defer h.keysMu.Unlock()
-
-	validKeys := make([]string, 0, len(h.redirectKeys))
-	for _, key := range h.redirectKeys {
-		if _, ok := h.redirectMap.Load(key); ok {
-			validKeys = append(validKeys, key)
+// evictRedirects drops expired entries and, if the map is still above limit,
+// arbitrary further entries. Evicted keys stay valid: HandleRedirect rebuilds
+// their metadata from the display path on the next request.
+func (h *ProxyHandler) evictRedirects(now time.Time, limit int64) {
+	h.redirectMap.Range(func(key, value interface{}) bool {
+		if now.After(value.(*redirectInfo).ExpiresAt) {
+			h.deleteRedirect(key)
 		}
+		return true
+	})
+	if atomic.LoadInt64(&h.redirectCount) <= limit {
+		return
+	}
+	h.redirectMap.Range(func(key, _ interface{}) bool {
+		h.deleteRedirect(key)
+		return atomic.LoadInt64(&h.redirectCount) > limit
+	})
+}
+
+func (h *ProxyHandler) deleteRedirect(key interface{}) {
+	if _, loaded := h.redirectMap.LoadAndDelete(key); loaded {
+		atomic.AddInt64(&h.redirectCount, -1)
 	}
-	h.redirectKeys = validKeys
 }
 
 // HandleRedirect handles /redirect/:key for 302 redirect decryption
@@ -206,14 +243,26 @@ func (h *ProxyHandler) HandleRedirect(w http.ResponseWriter, r *http.Request) {
 		RespondHTTPErrorWithStatus(w, "Missing key", http.StatusBadRequest)
 		return
 	}
+	if h.signer != nil {
+		if err := h.signer.Verify(r); err != nil {
+			log.Warn().Err(err).Str("key", key).Str("remote", r.RemoteAddr).Msg("Rejected redirect request")
+			RespondHTTPErrorWithStatus(w, "Redirect link rejected: "+err.Error(), http.StatusForbidden)
+			return
+		}
+	}
 
-	value, ok := h.redirectMap.Load(key)
-	if !ok {
+	info := h.lookupRedirect(r, key)
+	if info == nil {
 		RespondHTTPErrorWithStatus(w, "Redirect key not found or expired", http.StatusNotFound)
 		return
 	}
-
-	info := value.(*redirectInfo)
+	displayPath := info.DisplayPath
+	if displayPath == "" {
+		displayPath = resolveRedirectDisplayPath(r)
+	}
+	if rule, ok := h.passwdDAO.FindByPath(displayPath); ok && !allowAccessNow(w, r, rule, displayPath, h.audit) {
+		return
+	}
 
 	decodeParam := r.URL.Query().Get("decode")
 	decryptEnabled := decodeParam != "0"
@@ -233,10 +282,6 @@ func (h *ProxyHandler) HandleRedirect(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	displayPath := info.DisplayPath
-	if displayPath == "" {
-		displayPath = resolveRedirectDisplayPath(r)
-	}
 	if displayPath != "" {
 		if refreshed := h.refreshRedirectMetadata(r, displayPath, info); refreshed != nil {
 			info = refreshed
@@ -247,7 +292,7 @@ func (h *ProxyHandler) HandleRedirect(w http.ResponseWriter, r *http.Request) {
 		// silently return encrypted content. SizeUnknownStrict only
 		// controls non-decrypt paths.
 		log.Warn().Str("key", key).Msg("Decryption requested but file size is unknown, refusing to serve encrypted content")
-		RespondHTTPErrorWithStatus(w, "Unable to determine encrypted file size for decryption", http.StatusBadGateway)
+		RespondCodedError(w, errors.CodeSizeUnknown, "Unable to determine encrypted file size for decryption", http.StatusBadGateway)
 		return
 	}
 
@@ -259,8 +304,10 @@ func (h *ProxyHandler) HandleRedirect(w http.ResponseWriter, r *http.Request) {
 	}
 	if lookupInfo, found := h.passwdDAO.FindByPath(info.DisplayPath); found && lookupInfo != nil {
 		passwdInfo.Password = lookupInfo.Password
+		passwdInfo.PasswordVersions = lookupInfo.PasswordVersions
 		passwdInfo.EncType = lookupInfo.EncType
 		passwdInfo.EncName = lookupInfo.EncName
+		passwdInfo.NameDialect = lookupInfo.NameDialect
 	}
 	proxy.StripWebDAVHeaders(r)
 	r.Host = ""
@@ -306,6 +353,8 @@ func (h *ProxyHandler) HandleRedirect(w http.ResponseWriter, r *http.Request) {
 		ConsumerScenario:      consumerScenarioRedirect,
 		FailureLogMsg:         "Failed to proxy redirect",
 		LogCategory:           "redirect",
+		PlayStats:             h.playStats,
+		ReadVerifier:          h.readVerifier,
 		FinalPassthroughCount: &h.finalPassthroughCount,
 		SizeConflictCount:     &h.sizeConflictCount,
 		FirstFrameCount:       &h.firstFrameCount,
@@ -324,30 +373,53 @@ func (h *ProxyHandler) RegisterRedirect(url string, fileSize int64, passwdInfo *
 		encName = passwdInfo.EncName
 		compatKey = buildRangeCompatStorageKey(passwdInfo, displayPath)
 	}
-	hash := md5.Sum([]byte(fmt.Sprintf("%s:%d:%d", url, fileSize, time.Now().UnixNano())))
-	key := hex.EncodeToString(hash[:])
-
-	h.redirectMap.Store(key, &redirectInfo{
+	key, expiresAt := h.keyring.Key(redirectKeyIdentity(url, displayPath), time.Now())
+	h.storeRedirect(key, &redirectInfo{
 		URL:         url,
 		FileSize:    fileSize,
 		EncType:     encType,
 		EncName:     encName,
 		DisplayPath: displayPath,
 		CompatKey:   compatKey,
-		ExpiresAt:   time.Now().Add(72 * time.Hour),
+		ExpiresAt:   expiresAt,
 	})
+	return key
+}
 
-	// LRU eviction
-	h.keysMu.Lock()
-	h.redirectKeys = append(h.redirectKeys, key)
-	for len(h.redirectKeys) > maxRedirectEntries {
-		oldKey := h.redirectKeys[0]
-		h.redirectKeys = h.redirectKeys[1:]
-		h.redirectMap.Delete(oldKey)
+func (h *ProxyHandler) storeRedirect(key string, info *redirectInfo) {
+	if _, loaded := h.redirectMap.Swap(key, info); !loaded {
+		if atomic.AddInt64(&h.redirectCount, 1) > maxRedirectEntries {
+			h.evictRedirects(time.Now(), maxRedirectEntries)
+		}
 	}
-	h.keysMu.Unlock()
+}
 
-	return key
+// lookupRedirect resolves a redirect key. Malformed and expired keys are
+// rejected from the key itself; keys whose metadata was evicted are rebuilt
+// when the request carries the display path they were issued for.
+func (h *ProxyHandler) lookupRedirect(r *http.Request, key string) *redirectInfo {
+	expiresAt, ok := parseRedirectKey(key)
+	if !ok || time.Now().After(expiresAt) {
+		return nil
+	}
+	if value, ok := h.redirectMap.Load(key); ok {
+		return value.(*redirectInfo)
+	}
+	displayPath := resolveRedirectDisplayPath(r)
+	if displayPath == "" || !h.keyring.Verify(key, redirectKeyIdentity("", displayPath)) {
+		return nil
+	}
+	info := &redirectInfo{DisplayPath: displayPath, ExpiresAt: expiresAt}
+	if passwdInfo, found := h.passwdDAO.FindByPath(displayPath); found && passwdInfo != nil {
+		info.EncType = passwdInfo.EncType
+		info.EncName = passwdInfo.EncName
+		info.CompatKey = buildRangeCompatStorageKey(passwdInfo, displayPath)
+	}
+	if h.refreshRedirectMetadata(r, displayPath, info) == nil || info.URL == "" {
+		return nil
+	}
+	h.storeRedirect(key, info)
+	return info
 }
 
 func (h *ProxyHandler) rewriteRedirectLocation(req *http.Request, location string, fileSize int64, passwdInfo *config.PasswdInfo) (string, bool) {
@@ -369,7 +441,9 @@ func (h *ProxyHandler) rewriteRedirectLocation(req *http.Request, location strin
 		}
 	}
 
-	return buildRedirectPath(key, lastURL, true), true
+	// The signature covers the path this proxy sees; the prefix is only for
+	// the client to find its way back through the reverse proxy.
+	return httputil.ForwardedPrefix(req) + h.signRedirectPath(req, buildRedirectPath(key, lastURL, true)), true
 }
 
 func redirectCompatKey(info *redirectInfo, passwdInfo *config.PasswdInfo, displayPath string) string {
@@ -440,20 +514,11 @@ func resolveRedirectDisplayPath(r *http.Request) string {
 	return ""
 }
 
+// redirectDisplayPathFromURLPath is the storage path of a /d, /p or /dav
+// URL, or "".
 func redirectDisplayPathFromURLPath(rawPath string) string {
-	if rawPath == "" {
-		return ""
-	}
-	switch {
-	case strings.HasPrefix(rawPath, "/d/"):
-		return strings.TrimPrefix(rawPath, "/d")
-	case strings.HasPrefix(rawPath, "/p/"):
-		return strings.TrimPrefix(rawPath, "/p")
-	case strings.HasPrefix(rawPath, "/dav/"):
-		return strings.TrimPrefix(rawPath, "/dav")
-	default:
-		return ""
-	}
+	p, _ := pathutil.StripRoute(rawPath)
+	return p.String()
 }
 
 // convertDisplayToRealPath converts a display path to encrypted path for downloads
@@ -465,13 +530,15 @@ func (h *ProxyHandler) convertDisplayToRealPath(displayPath string, passwdInfo *
 
 // HandleDownload handles /d/* and /p/* download requests with decryption
 func (h *ProxyHandler) HandleDownload(w http.ResponseWriter, r *http.Request) {
-	displayPath := strings.TrimPrefix(r.URL.Path, "/d")
-	displayPath = strings.TrimPrefix(displayPath, "/p")
+	displayPath := redirectDisplayPathFromURLPath(r.URL.Path)
 	r = r.WithContext(proxy.WithDisplayName(r.Context(), path.Base(displayPath)))
 
 	trace.Logf(r.Context(), "download", "Processing: display=%s", displayPath)
 
-	passwdInfo, found := h.passwdDAO.FindByPath(displayPath)
+	passwdInfo, found := h.passwdDAO.FindByPathFor(r.Context(), displayPath)
+	if found && !allowAccessNow(w, r, passwdInfo, displayPath, h.audit) {
+		return
+	}
 	if !found {
 		// Fallback: check for X-OpenEncrypt-Rule-* headers from openencrypt-android
 		if headerInfo := PasswdInfoFromOpenEncryptHeaders(r); headerInfo != nil {
@@ -505,16 +572,32 @@ func (h *ProxyHandler) HandleDownload(w http.ResponseWriter, r *http.Request) {
 		urlPrefix = "/p"
 	}
 
-	// Fetch fresh upstream metadata if cache is cold or stale.
-	cachedInfo, hasCache := h.fileDAO.Get(displayPath)
-	stale := hasCache && cachedInfo != nil && !cachedRawURLFresh(cachedInfo, h.upstreamStalenessThreshold())
-	if !hasCache || cachedInfo == nil ||
-		cachedInfo.Size <= 0 || strings.TrimSpace(cachedInfo.RawURL) == "" || stale {
-		h.prefetchDownloadMetadata(r, displayPath, realPath, stale)
+	resumed, ok := h.resumeDownload(w, r, displayPath)
+	if !ok {
+		return
 	}
+	if resumed != nil {
+		realPath = resumed.RealPath
+	}
+
+	var fileInfo *dao.FileInfo
+	var usedStrategy StrategyType
+	if resumed != nil {
+		// The token carries what the interrupted download negotiated.
+		fileInfo, usedStrategy = resumed.FileInfo(), resumed.Strategy
+		trace.Logf(r.Context(), "download", "Resuming with token: size=%d strategy=%s", fileInfo.Size, usedStrategy)
+	} else {
+		// Fetch fresh upstream metadata if cache is cold or stale.
+		cachedInfo, hasCache := h.fileDAO.Get(displayPath)
+		stale := hasCache && cachedInfo != nil && !cachedRawURLFresh(cachedInfo, h.upstreamStalenessThreshold())
+		if !hasCache || cachedInfo == nil ||
+			cachedInfo.Size <= 0 || strings.TrimSpace(cachedInfo.RawURL) == "" || stale {
+			h.prefetchDownloadMetadata(r, displayPath, realPath, stale)
+		}
 
-	// Look up file info by DISPLAY path (how PROPFIND/fs/list cached it)
-	fileInfo, usedStrategy := h.getFileSizeWithStrategy(displayPath, realPath, urlPrefix, r)
+		// Look up file info by DISPLAY path (how PROPFIND/fs/list cached it)
+		fileInfo, usedStrategy = h.getFileSizeWithStrategy(displayPath, realPath, urlPrefix, r)
+	}
 
 	trace.Logf(r.Context(), "download", "File size: %d, strategy: %s", fileInfo.Size, usedStrategy)
 
@@ -531,6 +614,12 @@ func (h *ProxyHandler) HandleDownload(w http.ResponseWriter, r *http.Request) {
 		targetURL = httputil.BuildTargetURLWithQuery(h.cfg.GetAlistURL(), urlPrefix+realPath, "")
 	}
 
+	if h.resumer != nil {
+		if token := h.resumer.Issue(fileInfo, realPath, usedStrategy, requestRangeStart(r)); token != "" {
+			w.Header().Set(resumeTokenHeader, token)
+		}
+	}
+
 	trace.Logf(r.Context(), "decrypt", "Decrypting with fileSize=%d", fileInfo.Size)
 	fileItem := FileItem{
 		DisplayPath:      displayPath,
@@ -558,6 +647,10 @@ func (h *ProxyHandler) HandleDownload(w http.ResponseWriter, r *http.Request) {
 		CompatKey:             buildRangeCompatStorageKey(passwdInfo, displayPath),
 		ConsumerScenario:      consumerScenarioHTTP,
 		FailureLogMsg:         "Failed to decrypt download",
+		LocalPath:             localDirectPath(h.cfg, h.fileDAO, displayPath, realPath),
+		LocalAuthURL:          localDirectAuthURL(h.cfg, r, urlPrefix+realPath),
+		PlayStats:             h.playStats,
+		ReadVerifier:          h.readVerifier,
 		FinalPassthroughCount: &h.finalPassthroughCount,
 		SizeConflictCount:     &h.sizeConflictCount,
 		FirstFrameCount:       &h.firstFrameCount,
@@ -678,9 +771,43 @@ func (h *ProxyHandler) upstreamStalenessThreshold() time.Duration {
 	return defaultUpstreamStalenessMins * time.Minute
 }
 
+// reviveCreatedPath lifts the tombstone on the path a passed-through form
+// upload or mkdir creates, so the new entry is cached again. The mkdir body
+// is put back for the upstream request.
+func (h *ProxyHandler) reviveCreatedPath(r *http.Request) {
+	switch r.URL.Path {
+	case "/api/fs/form":
+		if p, err := pathutil.UnescapeFilePath(r.Header.Get("File-Path")); err == nil {
+			h.fileDAO.Revive(p)
+		}
+	case "/api/fs/mkdir":
+		if r.Body == nil {
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+		if err != nil {
+			return
+		}
+		var req struct {
+			Path string `json:"path"`
+		}
+		if json.Unmarshal(body, &req) == nil {
+			h.fileDAO.Revive(req.Path)
+		}
+	}
+}
+
 // HandleProxy handles catch-all proxy to Alist
 func (h *ProxyHandler) HandleProxy(w http.ResponseWriter, r *http.Request) {
 	log.Debug().Str("path", r.URL.Path).Str("method", r.Method).Msg("Proxying request")
+	if h.rejectStrictPlaintextWrite(w, r) {
+		return
+	}
+	h.reviveCreatedPath(r)
+	if h.serveStaticAsset(w, r) {
+		return
+	}
 	targetURL := httputil.BuildTargetURL(h.cfg.GetAlistURL(), r.URL.Path, r)
 	log.Debug().Str("target", targetURL).Msg("Target URL")
 
@@ -717,9 +844,7 @@ func (h *ProxyHandler) HandleProxy(w http.ResponseWriter, r *http.Request) {
 				redirectPath := parsedLoc.Path
 				// Get original request path (display path) for cache lookup
 				// Strip /d or /p prefix to match how paths are cached in fs/get
-				originalPath := r.URL.Path
-				displayPath := strings.TrimPrefix(originalPath, "/d")
-				displayPath = strings.TrimPrefix(displayPath, "/p")
+				displayPath := redirectDisplayPathFromURLPath(r.URL.Path)
 
 				if passwdInfo, found := h.passwdDAO.FindByPath(redirectPath); found {
 					var fileSize int64
@@ -767,7 +892,7 @@ func (h *ProxyHandler) HandleProxy(w http.ResponseWriter, r *http.Request) {
 					if r.URL != nil {
 						lastURL = r.URL.RequestURI()
 					}
-					w.Header().Set("Location", buildRedirectPath(key, lastURL, true))
+					w.Header().Set("Location", httputil.ForwardedPrefix(r)+h.signRedirectPath(r, buildRedirectPath(key, lastURL, true)))
 					w.WriteHeader(resp.StatusCode)
 					return
 				}
@@ -786,6 +911,9 @@ func (h *ProxyHandler) HandleProxy(w http.ResponseWriter, r *http.Request) {
 			RespondHTTPErrorWithStatus(w, "Proxy error", http.StatusBadGateway)
 			return
 		}
+		if strings.Contains(strings.ToLower(contentType), "text/html") {
+			body = rewriteHTMLResourceURLs(body, htmlRewriteHosts(h.cfg.AlistServer, h.cfg.GetAlistURL()))
+		}
 		body = rewriteUpstreamTextBody(r, h.cfg.GetAlistURL(), body)
 		httputil.CopyResponseHeaders(w, resp, "Content-Length")
 		w.WriteHeader(resp.StatusCode)
@@ -798,5 +926,7 @@ func (h *ProxyHandler) HandleProxy(w http.ResponseWriter, r *http.Request) {
 
 	buf := proxy.GetBuffer()
 	defer proxy.PutBuffer(buf)
-	io.CopyBuffer(w, resp.Body, *buf)
+	out, done := h.streamProxy.Throttle(w, r)
+	defer done()
+	io.CopyBuffer(out, resp.Body, *buf)
 }
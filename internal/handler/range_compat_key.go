@@ -4,6 +4,7 @@ import (
 	"strings"
 
 	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/pathutil"
 )
 
 func buildRangeCompatStorageKey(passwdInfo *config.PasswdInfo, displayPath string) string {
@@ -55,17 +56,11 @@ func extractPatternLiteralPrefix(pattern string) string {
 	return strings.TrimRight(b.String(), "/")
 }
 
-func normalizePath(path string) string {
-	path = strings.TrimSpace(path)
-	if path == "" {
+// normalizePath is pathutil.CleanInput, except that a blank path stays ""
+// and so matches no prefix.
+func normalizePath(p string) string {
+	if strings.TrimSpace(p) == "" {
 		return ""
 	}
-	if !strings.HasPrefix(path, "/") {
-		path = "/" + path
-	}
-	path = strings.TrimRight(path, "/")
-	if path == "" {
-		return "/"
-	}
-	return path
+	return pathutil.CleanInput(p)
 }
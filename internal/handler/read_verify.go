@@ -0,0 +1,271 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/storage"
+)
+
+// Sources of a ContentHash.
+const (
+	contentHashFromUpload = "upload" // hashed while encrypting the upload
+	contentHashFromRead   = "read"   // first complete sampled download
+)
+
+// ContentHash is the manifest entry of one file: the SHA-256 of its
+// plaintext and enough of the upstream validators to notice a replaced file.
+type ContentHash struct {
+	SHA256       string    `json:"sha256"`
+	Size         int64     `json:"size"`
+	Source       string    `json:"source"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	RecordedAt   time.Time `json:"recorded_at"`
+	VerifiedAt   time.Time `json:"verified_at,omitempty"`
+	Mismatches   int       `json:"mismatches,omitempty"`
+}
+
+// ReadVerifier tees readVerifyPercent of complete decrypted downloads into
+// SHA-256 and compares the result with the stored manifest (BoltDB
+// "contenthash", display path → ContentHash). Files without an entry get
+// one from their first complete sampled read, so later reads are checked
+// against it. Mismatches are only logged and counted: the client already
+// has the bytes, this is background assurance that data stays decryptable.
+type ReadVerifier struct {
+	cfg   *config.Config
+	store *storage.Store
+
+	sampled    uint64
+	verified   uint64
+	recorded   uint64
+	mismatches uint64
+	incomplete uint64
+}
+
+// NewReadVerifier creates a verifier persisting into store.
+func NewReadVerifier(cfg *config.Config, store *storage.Store) *ReadVerifier {
+	if cfg == nil || store == nil {
+		return nil
+	}
+	return &ReadVerifier{cfg: cfg, store: store}
+}
+
+func (v *ReadVerifier) percent() int {
+	if v == nil {
+		return 0
+	}
+	return v.cfg.AlistServer.ReadVerifyPercent
+}
+
+// Wrap returns w hashing the response when this request is sampled, and a
+// function to call once the response is done. Only requests that can cover
+// the whole file (GET without Range or from byte 0) are sampled.
+func (v *ReadVerifier) Wrap(w http.ResponseWriter, r *http.Request, displayPath string) (http.ResponseWriter, func()) {
+	percent := v.percent()
+	if percent <= 0 || displayPath == "" || r.Method != http.MethodGet || !isPlaybackStart(r) {
+		return w, func() {}
+	}
+	if percent < 100 && rand.Intn(100) >= percent {
+		return w, func() {}
+	}
+	atomic.AddUint64(&v.sampled, 1)
+	hw := &hashingWriter{ResponseWriter: w, hasher: sha256.New(), total: -1}
+	return hw, func() { v.finish(displayPath, hw) }
+}
+
+func (v *ReadVerifier) finish(displayPath string, hw *hashingWriter) {
+	if hw.total <= 0 || hw.n != hw.total {
+		atomic.AddUint64(&v.incomplete, 1)
+		return
+	}
+	sum := hex.EncodeToString(hw.hasher.Sum(nil))
+	header := hw.Header()
+	etag, lastModified := header.Get("ETag"), header.Get("Last-Modified")
+	now := time.Now()
+
+	var mismatch *ContentHash
+	err := v.store.UpdateBucket(storage.BucketHashes, func(tx *storage.BucketTx) error {
+		var entry ContentHash
+		if err := tx.GetJSON(displayPath, &entry); err != nil {
+			return err
+		}
+		switch {
+		case entry.SHA256 == "" || entry.Size != hw.total || replacedUpstream(entry, etag, lastModified):
+			atomic.AddUint64(&v.recorded, 1)
+			entry = ContentHash{Source: contentHashFromRead, RecordedAt: now, VerifiedAt: now}
+		case entry.SHA256 == sum:
+			atomic.AddUint64(&v.verified, 1)
+			entry.VerifiedAt = now
+		default:
+			atomic.AddUint64(&v.mismatches, 1)
+			entry.Mismatches++
+			prev := entry
+			mismatch = &prev
+			return tx.SetJSON(displayPath, entry)
+		}
+		entry.SHA256 = sum
+		entry.Size = hw.total
+		entry.ETag = etag
+		entry.LastModified = lastModified
+		return tx.SetJSON(displayPath, entry)
+	})
+	if err != nil {
+		log.Warn().Err(err).Str("path", displayPath).Msg("Failed to store read verification result")
+	}
+	if mismatch != nil {
+		log.Error().
+			Str("category", "read_verify").
+			Str("path", displayPath).
+			Int64("size", hw.total).
+			Str("expected_sha256", mismatch.SHA256).
+			Str("got_sha256", sum).
+			Str("expected_source", mismatch.Source).
+			Time("recorded_at", mismatch.RecordedAt).
+			Int("mismatches", mismatch.Mismatches).
+			Msg("Decrypted content does not match its recorded hash")
+	}
+}
+
+// replacedUpstream reports whether the upstream validators show the file was
+// replaced since entry was recorded. Missing validators prove nothing.
+func replacedUpstream(entry ContentHash, etag, lastModified string) bool {
+	if entry.ETag != "" && etag != "" {
+		return entry.ETag != etag
+	}
+	if entry.LastModified != "" && lastModified != "" {
+		return entry.LastModified != lastModified
+	}
+	return false
+}
+
+// RecordUpload stores the plaintext hash of a completed upload as the
+// manifest entry for displayPath.
+func (v *ReadVerifier) RecordUpload(displayPath string, size int64, sum []byte) {
+	if v == nil || displayPath == "" || size <= 0 {
+		return
+	}
+	entry := ContentHash{
+		SHA256:     hex.EncodeToString(sum),
+		Size:       size,
+		Source:     contentHashFromUpload,
+		RecordedAt: time.Now(),
+	}
+	if err := v.store.SetJSON(storage.BucketHashes, displayPath, entry); err != nil {
+		log.Warn().Err(err).Str("path", displayPath).Msg("Failed to store upload content hash")
+	}
+}
+
+// Forget drops the manifest entry of displayPath before it is overwritten by
+// an upload whose plaintext hash is not known.
+func (v *ReadVerifier) Forget(displayPath string) {
+	if v == nil || displayPath == "" {
+		return
+	}
+	if err := v.store.Delete(storage.BucketHashes, displayPath); err != nil {
+		log.Warn().Err(err).Str("path", displayPath).Msg("Failed to drop content hash")
+	}
+}
+
+// uploadHasher returns a hasher for the plaintext of an upload to rule, or
+// nil when verification is off or upload transforms will change the bytes.
+func (v *ReadVerifier) uploadHasher(rule *config.PasswdInfo) hash.Hash {
+	if v.percent() <= 0 || rule == nil || rule.StripMetadata || len(rule.UploadTransforms) > 0 {
+		return nil
+	}
+	return sha256.New()
+}
+
+// Stats returns counters for /enc-api/getStats.
+func (v *ReadVerifier) Stats() map[string]interface{} {
+	if v == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"percent":    v.percent(),
+		"sampled":    atomic.LoadUint64(&v.sampled),
+		"verified":   atomic.LoadUint64(&v.verified),
+		"recorded":   atomic.LoadUint64(&v.recorded),
+		"mismatches": atomic.LoadUint64(&v.mismatches),
+		"incomplete": atomic.LoadUint64(&v.incomplete),
+	}
+}
+
+// hashingWriter hashes a response that covers the whole file: a 200 with a
+// Content-Length, or a 206 whose Content-Range starts at 0 and runs to the
+// end. Other responses are passed through unhashed (total stays -1).
+type hashingWriter struct {
+	http.ResponseWriter
+	hasher      hash.Hash
+	n           int64
+	total       int64
+	wroteHeader bool
+}
+
+func (w *hashingWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.total = fullResponseSize(status, w.Header())
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *hashingWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(p)
+	if n > 0 && w.total > 0 {
+		w.hasher.Write(p[:n])
+		w.n += int64(n)
+	}
+	return n, err
+}
+
+func (w *hashingWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *hashingWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// fullResponseSize returns the file size when a response carries the whole
+// file, or -1.
+func fullResponseSize(status int, header http.Header) int64 {
+	switch status {
+	case http.StatusOK:
+		if n, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil && n > 0 {
+			return n
+		}
+	case http.StatusPartialContent:
+		// bytes 0-(size-1)/size
+		spec, ok := strings.CutPrefix(strings.TrimSpace(header.Get("Content-Range")), "bytes ")
+		if !ok {
+			return -1
+		}
+		span, sizeStr, ok := strings.Cut(spec, "/")
+		if !ok {
+			return -1
+		}
+		start, end, ok := strings.Cut(span, "-")
+		size, err := strconv.ParseInt(sizeStr, 10, 64)
+		if !ok || err != nil || start != "0" || end != strconv.FormatInt(size-1, 10) {
+			return -1
+		}
+		return size
+	}
+	return -1
+}
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/storage"
+)
+
+func newTestReadVerifier(t *testing.T) *ReadVerifier {
+	t.Helper()
+	store, err := storage.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	cfg := config.DefaultConfig()
+	cfg.AlistServer.ReadVerifyPercent = 100
+	return NewReadVerifier(cfg, store)
+}
+
+func serveVerified(v *ReadVerifier, rangeHeader string, status int, headers map[string]string, body string) {
+	req := httptest.NewRequest(http.MethodGet, "/d/movies/a.mkv", nil)
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+	w, done := v.Wrap(httptest.NewRecorder(), req, "/movies/a.mkv")
+	for k, val := range headers {
+		w.Header().Set(k, val)
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(body))
+	done()
+}
+
+func TestReadVerifierRecordsThenVerifies(t *testing.T) {
+	v := newTestReadVerifier(t)
+	full := map[string]string{"Content-Length": "5", "ETag": `"v1"`}
+
+	serveVerified(v, "", http.StatusOK, full, "hello")
+	serveVerified(v, "bytes=0-", http.StatusPartialContent, map[string]string{"Content-Range": "bytes 0-4/5", "ETag": `"v1"`}, "hello")
+	serveVerified(v, "", http.StatusOK, full, "jello")
+	// A new ETag means the file was replaced: record instead of flagging.
+	serveVerified(v, "", http.StatusOK, map[string]string{"Content-Length": "5", "ETag": `"v2"`}, "jello")
+	// Partial responses cannot be compared.
+	serveVerified(v, "bytes=0-", http.StatusPartialContent, map[string]string{"Content-Range": "bytes 0-1/5"}, "je")
+
+	stats := v.Stats()
+	if stats["sampled"] != uint64(5) || stats["recorded"] != uint64(2) || stats["verified"] != uint64(1) ||
+		stats["mismatches"] != uint64(1) || stats["incomplete"] != uint64(1) {
+		t.Fatalf("stats=%v", stats)
+	}
+	var entry ContentHash
+	if err := v.store.GetJSON(storage.BucketHashes, "/movies/a.mkv", &entry); err != nil {
+		t.Fatalf("GetJSON: %v", err)
+	}
+	if entry.ETag != `"v2"` || entry.Source != contentHashFromRead || entry.Mismatches != 0 {
+		t.Fatalf("entry=%+v", entry)
+	}
+}
+
+func TestReadVerifierChecksUploadHash(t *testing.T) {
+	v := newTestReadVerifier(t)
+	sum := sha256.Sum256([]byte("hello"))
+	v.RecordUpload("/movies/a.mkv", 5, sum[:])
+
+	serveVerified(v, "", http.StatusOK, map[string]string{"Content-Length": "5"}, "hellO")
+	if stats := v.Stats(); stats["mismatches"] != uint64(1) {
+		t.Fatalf("stats=%v", stats)
+	}
+	serveVerified(v, "", http.StatusOK, map[string]string{"Content-Length": "5"}, "hello")
+	if stats := v.Stats(); stats["verified"] != uint64(1) {
+		t.Fatalf("stats=%v", stats)
+	}
+
+	v.Forget("/movies/a.mkv")
+	var entry ContentHash
+	if err := v.store.GetJSON(storage.BucketHashes, "/movies/a.mkv", &entry); err != nil || entry.SHA256 != "" {
+		t.Fatalf("entry=%+v err=%v", entry, err)
+	}
+}
+
+func TestReadVerifierSkipsWhenDisabledOrSeeking(t *testing.T) {
+	v := newTestReadVerifier(t)
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/d/movies/a.mkv", nil)
+	req.Header.Set("Range", "bytes=100-")
+	if w, _ := v.Wrap(rec, req, "/movies/a.mkv"); w != http.ResponseWriter(rec) {
+		t.Fatalf("seek request was sampled")
+	}
+	v.cfg.AlistServer.ReadVerifyPercent = 0
+	if w, _ := v.Wrap(rec, httptest.NewRequest(http.MethodGet, "/d/movies/a.mkv", nil), "/movies/a.mkv"); w != http.ResponseWriter(rec) {
+		t.Fatalf("sampled with verification off")
+	}
+	if v.uploadHasher(&config.PasswdInfo{StripMetadata: true}) != nil {
+		t.Fatalf("upload with transforms must not be hashed")
+	}
+}
+
+func TestFullResponseSize(t *testing.T) {
+	cases := []struct {
+		status int
+		header string
+		want   int64
+	}{
+		{http.StatusOK, "", -1},
+		{http.StatusPartialContent, "bytes 0-9/10", 10},
+		{http.StatusPartialContent, "bytes 0-8/10", -1},
+		{http.StatusPartialContent, "bytes 1-9/10", -1},
+		{http.StatusPartialContent, "bytes 0-9/*", -1},
+	}
+	for _, c := range cases {
+		h := http.Header{}
+		if c.status == http.StatusPartialContent {
+			h.Set("Content-Range", c.header)
+		}
+		if got := fullResponseSize(c.status, h); got != c.want {
+			t.Errorf("fullResponseSize(%d, %q)=%d, want %d", c.status, c.header, got, c.want)
+		}
+	}
+	h := http.Header{}
+	h.Set("Content-Length", strconv.Itoa(42))
+	if got := fullResponseSize(http.StatusOK, h); got != 42 {
+		t.Errorf("200 with length: %d", got)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+
+	"github.com/alist-encrypt-go/internal/httputil"
 )
 
 func buildRedirectPath(key, lastURL string, decode bool) string {
@@ -26,52 +28,32 @@ func buildRedirectURL(r *http.Request, redirectPath string) string {
 	if r == nil {
 		return redirectPath
 	}
-	origin := r.Header.Get("Origin")
-	if origin != "" {
-		return strings.TrimRight(origin, "/") + redirectPath
-	}
-	proto := r.Header.Get("X-Forwarded-Proto")
-	if proto == "" {
-		if r.TLS != nil {
-			proto = "https"
-		} else {
-			proto = "http"
-		}
-	}
-	host := r.Header.Get("X-Forwarded-Host")
-	if host == "" {
-		host = r.Host
-	}
-	if host == "" {
-		return redirectPath
+	origin := requestOrigin(r)
+	if origin == "" {
+		return httputil.ForwardedPrefix(r) + redirectPath
 	}
-	return proto + "://" + host + redirectPath
+	return origin + redirectPath
 }
 
 func requestOrigin(r *http.Request) string {
 	if r == nil {
 		return ""
 	}
+	// Under X-Forwarded-Prefix the origin includes the prefix, so paths
+	// appended to it stay behind the reverse proxy's mount point.
+	prefix := httputil.ForwardedPrefix(r)
 	origin := r.Header.Get("Origin")
 	if origin != "" {
-		return strings.TrimRight(origin, "/")
-	}
-	proto := r.Header.Get("X-Forwarded-Proto")
-	if proto == "" {
-		if r.TLS != nil {
-			proto = "https"
-		} else {
-			proto = "http"
-		}
+		return strings.TrimRight(origin, "/") + prefix
 	}
-	host := r.Header.Get("X-Forwarded-Host")
-	if host == "" {
+	host, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Host"), ",")
+	if host = strings.TrimSpace(host); host == "" {
 		host = r.Host
 	}
 	if host == "" {
 		return ""
 	}
-	return proto + "://" + host
+	return httputil.ForwardedProto(r) + "://" + host + prefix
 }
 
 func rewriteUpstreamLocation(r *http.Request, upstreamBaseURL, location string) string {
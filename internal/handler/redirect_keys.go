@@ -0,0 +1,92 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// redirectKeyTTL is the maximum lifetime of a redirect key.
+	redirectKeyTTL = 72 * time.Hour
+	// redirectKeyBucket rounds expiry down so the same file maps to the same
+	// key for a day; keys therefore live between 48h and 72h.
+	redirectKeyBucket = 24 * time.Hour
+	redirectKeyMACLen = 16
+)
+
+// redirectKeyring derives reproducible /redirect keys of the form
+// "<expiry base36>-<hex HMAC(identity, expiry)>". Expiry is embedded in the
+// key, so expired or malformed keys are rejected without a map lookup, and a
+// key for a known display path can be re-validated after the metadata map
+// has been evicted or the process restarted.
+type redirectKeyring struct {
+	secret []byte
+}
+
+func newRedirectKeyring(jwtSecret string) *redirectKeyring {
+	seed := []byte(jwtSecret)
+	if len(seed) == 0 {
+		// No configured secret (tests, embedded use): keys stay valid for
+		// this process only.
+		seed = make([]byte, 32)
+		_, _ = rand.Read(seed)
+	}
+	mac := hmac.New(sha256.New, seed)
+	mac.Write([]byte("alist-encrypt-go/redirect-key/v1"))
+	return &redirectKeyring{secret: mac.Sum(nil)}
+}
+
+func (k *redirectKeyring) mac(identity string, expires int64) string {
+	mac := hmac.New(sha256.New, k.secret)
+	mac.Write([]byte(identity))
+	mac.Write([]byte{0})
+	mac.Write([]byte(strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil)[:redirectKeyMACLen])
+}
+
+// Key returns the redirect key for identity and when it expires.
+func (k *redirectKeyring) Key(identity string, now time.Time) (string, time.Time) {
+	expiresAt := now.Truncate(redirectKeyBucket).Add(redirectKeyTTL)
+	expires := expiresAt.Unix()
+	return strconv.FormatInt(expires, 36) + "-" + k.mac(identity, expires), expiresAt
+}
+
+// parseRedirectKey extracts the embedded expiry. ok is false for malformed keys.
+func parseRedirectKey(key string) (expiresAt time.Time, ok bool) {
+	expPart, macPart, found := strings.Cut(key, "-")
+	if !found || len(macPart) != redirectKeyMACLen*2 {
+		return time.Time{}, false
+	}
+	if _, err := hex.DecodeString(macPart); err != nil {
+		return time.Time{}, false
+	}
+	expires, err := strconv.ParseInt(expPart, 36, 64)
+	if err != nil || expires <= 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(expires, 0), true
+}
+
+// Verify reports whether key was issued for identity.
+func (k *redirectKeyring) Verify(key, identity string) bool {
+	expiresAt, ok := parseRedirectKey(key)
+	if !ok {
+		return false
+	}
+	_, macPart, _ := strings.Cut(key, "-")
+	return hmac.Equal([]byte(macPart), []byte(k.mac(identity, expiresAt.Unix())))
+}
+
+// redirectKeyIdentity is what a key is derived from: the display path when
+// known (stable across raw_url refreshes), otherwise the upstream URL.
+func redirectKeyIdentity(url, displayPath string) string {
+	if displayPath != "" {
+		return "path:" + displayPath
+	}
+	return "url:" + url
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+func TestRedirectKeyIsReproducibleAndSelfExpiring(t *testing.T) {
+	ring := newRedirectKeyring("secret")
+	now := time.Date(2026, 1, 2, 10, 0, 0, 0, time.UTC)
+	identity := redirectKeyIdentity("https://cdn.example.com/a?sign=1", "/enc/a.mp4")
+
+	key, expiresAt := ring.Key(identity, now)
+	again, _ := ring.Key(redirectKeyIdentity("https://cdn.example.com/a?sign=2", "/enc/a.mp4"), now.Add(time.Hour))
+	if key != again {
+		t.Fatalf("keys differ for same path within a bucket: %s vs %s", key, again)
+	}
+	if ttl := expiresAt.Sub(now); ttl < 2*redirectKeyBucket || ttl > redirectKeyTTL {
+		t.Fatalf("ttl=%v", ttl)
+	}
+	parsed, ok := parseRedirectKey(key)
+	if !ok || !parsed.Equal(expiresAt) {
+		t.Fatalf("parse=%v ok=%v want %v", parsed, ok, expiresAt)
+	}
+	if !ring.Verify(key, identity) {
+		t.Fatal("expected key to verify")
+	}
+	if ring.Verify(key, redirectKeyIdentity("", "/enc/b.mp4")) {
+		t.Fatal("key must not verify for another path")
+	}
+	if newRedirectKeyring("other").Verify(key, identity) {
+		t.Fatal("key must not verify with another secret")
+	}
+	if _, ok := parseRedirectKey("0123456789abcdef0123456789abcdef"); ok {
+		t.Fatal("legacy md5 keys should be rejected as malformed")
+	}
+}
+
+func TestLookupRedirectRejectsExpiredKeyWithoutMapLookup(t *testing.T) {
+	handler := newTestProxyHandler(t, config.DefaultConfig())
+	key, _ := handler.keyring.Key("path:/enc/a.mp4", time.Now().Add(-redirectKeyTTL-time.Hour))
+	handler.redirectMap.Store(key, &redirectInfo{URL: "https://cdn.example.com/a", ExpiresAt: time.Now().Add(time.Hour)})
+
+	if info := handler.lookupRedirect(httptest.NewRequest("GET", "/redirect/"+key, nil), key); info != nil {
+		t.Fatalf("expired key resolved to %+v", info)
+	}
+}
+
+func TestStoreRedirectEvictsAboveLimit(t *testing.T) {
+	handler := newTestProxyHandler(t, config.DefaultConfig())
+	for _, p := range []string{"/a", "/b", "/c"} {
+		key, exp := handler.keyring.Key(redirectKeyIdentity("", p), time.Now())
+		handler.storeRedirect(key, &redirectInfo{DisplayPath: p, ExpiresAt: exp})
+	}
+	// Re-registering the same path must not grow the map.
+	key, exp := handler.keyring.Key(redirectKeyIdentity("", "/a"), time.Now())
+	handler.storeRedirect(key, &redirectInfo{DisplayPath: "/a", ExpiresAt: exp})
+	if got := handler.redirectCount; got != 3 {
+		t.Fatalf("count=%d, want 3", got)
+	}
+	handler.evictRedirects(time.Now(), 1)
+	if got := handler.redirectCount; got != 1 {
+		t.Fatalf("count after evict=%d, want 1", got)
+	}
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/encryption"
+	"github.com/alist-encrypt-go/internal/trace"
+)
+
+// redirectPrewarmTimeout bounds one background prewarm probe.
+const redirectPrewarmTimeout = 15 * time.Second
+
+// prewarmRedirect probes rawURL in the background after fs/get registered a
+// redirect for it, when enableRedirectPrewarm is set. The probe reads one
+// byte, so the connection to the storage host is open by the time the
+// player asks for the redirect. A link the storage rejects is dropped from
+// the file cache, and the redirect fetches a fresh one instead of failing
+// on the first play.
+//
+// Files whose content header was just read from rawURL are skipped: that
+// read already proved the link and opened the connection. Only one probe
+// per URL runs at a time.
+func (h *AlistHandler) prewarmRedirect(r *http.Request, rawURL, displayPath string, meta encryption.ContentMeta) {
+	if h == nil || h.cfg == nil || !h.cfg.AlistServer.EnableRedirectPrewarm || h.streamProxy == nil || rawURL == "" {
+		return
+	}
+	if meta.HasContentHeader() {
+		atomic.AddUint64(&h.prewarmSkipsKnown, 1)
+		return
+	}
+	if _, running := h.prewarming.LoadOrStore(rawURL, struct{}{}); running {
+		return
+	}
+	headers := make(http.Header)
+	for _, name := range []string{"Authorization", "Cookie", "User-Agent"} {
+		if v := r.Header.Get(name); v != "" {
+			headers.Set(name, v)
+		}
+	}
+	trace.Logf(r.Context(), "get", "Queued redirect prewarm, display=%s", displayPath)
+	atomic.AddUint64(&h.prewarmProbes, 1)
+	go func() {
+		defer h.prewarming.Delete(rawURL)
+		ctx, cancel := context.WithTimeout(context.Background(), redirectPrewarmTimeout)
+		defer cancel()
+		started := time.Now()
+		status, err := h.streamProxy.Prewarm(ctx, rawURL, headers)
+		if err == nil && status < http.StatusBadRequest {
+			log.Debug().
+				Str("category", "redirect_prewarm").
+				Str("display_path", displayPath).
+				Int("status", status).
+				Dur("elapsed", time.Since(started)).
+				Msg("Prewarmed redirect target")
+			return
+		}
+		atomic.AddUint64(&h.prewarmFailures, 1)
+		log.Warn().
+			Err(err).
+			Str("category", "redirect_prewarm").
+			Str("display_path", displayPath).
+			Int("status", status).
+			Msg("Redirect target failed prewarm probe; dropping cached raw_url")
+		if err == nil && h.fileDAO != nil {
+			h.fileDAO.InvalidateDisplayPath(displayPath)
+		}
+	}()
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/dao"
+	"github.com/alist-encrypt-go/internal/encryption"
+)
+
+func TestPrewarmRedirectDropsRejectedLink(t *testing.T) {
+	var probes int32
+	storage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&probes, 1)
+		if r.Header.Get("Range") != "bytes=0-0" {
+			t.Errorf("range=%q", r.Header.Get("Range"))
+		}
+		if r.URL.Path == "/expired.mkv" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Range", "bytes 0-0/10")
+		w.WriteHeader(http.StatusPartialContent)
+		_, _ = w.Write([]byte("x"))
+	}))
+	defer storage.Close()
+
+	passwd := &config.PasswdInfo{Password: "p", EncType: "aesctr", Enable: true, EncPath: []string{"/vault/*"}}
+	h, fileDAO := newTestAlistHandler(t, storage.URL, passwd)
+	h.cfg.AlistServer.EnableRedirectPrewarm = true
+	legacy := encryption.LegacyContentMeta(encryption.EncTypeAESCTR, 10)
+	req := httptest.NewRequest(http.MethodPost, "/api/fs/get", nil)
+
+	for _, name := range []string{"ok.mkv", "expired.mkv"} {
+		_ = fileDAO.Set(&dao.FileInfo{Path: "/vault/" + name, Name: name, Size: 10, RawURL: storage.URL + "/" + name})
+		h.prewarmRedirect(req, storage.URL+"/"+name, "/vault/"+name, legacy)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadUint64(&h.prewarmFailures) == 0 || atomic.LoadInt32(&probes) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("probes=%d failures=%d", atomic.LoadInt32(&probes), atomic.LoadUint64(&h.prewarmFailures))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	for time.Now().Before(deadline) {
+		if info, ok := fileDAO.Get("/vault/expired.mkv"); !ok || info.RawURL == "" {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if info, ok := fileDAO.Get("/vault/expired.mkv"); ok && info.RawURL != "" {
+		t.Fatalf("rejected link still cached: %q", info.RawURL)
+	}
+	if info, ok := fileDAO.Get("/vault/ok.mkv"); !ok || info.RawURL == "" {
+		t.Fatal("working link was dropped")
+	}
+
+	// A content header read from the link already warmed it.
+	v2 := encryption.ContentMeta{Version: encryption.ContentVersionV2, PlainSize: 10}
+	h.prewarmRedirect(req, storage.URL+"/ok.mkv", "/vault/ok.mkv", v2)
+	if atomic.LoadUint64(&h.prewarmSkipsKnown) != 1 {
+		t.Fatal("prewarm should skip links whose header was just read")
+	}
+}
@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errRedirectSignatureMissing = errors.New("missing signature")
+	errRedirectSignatureInvalid = errors.New("invalid signature")
+	errRedirectSignatureExpired = errors.New("link expired")
+	errRedirectSignatureUsed    = errors.New("link already used")
+)
+
+// singleUseReuseWindow is how long a single-use link stays usable by the
+// client that first opened it after its latest request. Players retry and
+// issue a Range request per seek, so the first hit only claims the link; it
+// is burnt once that client has been idle this long, or for anyone else.
+const singleUseReuseWindow = 2 * time.Minute
+
+// redirectSigner issues and verifies HMAC-signed, expiring /redirect URLs.
+// A signature covers the URL path, every query parameter except sig (so
+// decode and lastUrl cannot be swapped), the expiry and, when IP binding is
+// on, the client address that requested the link. Single-use links are
+// tracked in memory until they expire.
+type redirectSigner struct {
+	secret    []byte
+	ttl       time.Duration
+	bindIP    bool
+	singleUse bool
+	mu        sync.Mutex
+	used      map[string]*redirectUse // signature -> claim
+	now       func() time.Time
+}
+
+// redirectUse records which client claimed a single-use link.
+type redirectUse struct {
+	clientIP string
+	last     time.Time
+	expires  time.Time
+}
+
+func newRedirectSigner(jwtSecret string, ttl time.Duration, bindIP, singleUse bool) *redirectSigner {
+	// Derive a dedicated key so redirect signatures can never be replayed as JWTs.
+	mac := hmac.New(sha256.New, []byte(jwtSecret))
+	mac.Write([]byte("alist-encrypt-go/redirect-sign/v1"))
+	return &redirectSigner{
+		secret:    mac.Sum(nil),
+		ttl:       ttl,
+		bindIP:    bindIP,
+		singleUse: singleUse,
+		used:      make(map[string]*redirectUse),
+		now:       time.Now,
+	}
+}
+
+// sign MACs urlPath and the canonical encoding of values, which must not
+// contain sig. values includes exp (and ip when bound).
+func (s *redirectSigner) sign(urlPath string, values url.Values, clientIP string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(urlPath))
+	mac.Write([]byte{0})
+	mac.Write([]byte(values.Encode()))
+	mac.Write([]byte{0})
+	mac.Write([]byte(clientIP))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// SignPath appends exp/sig (and ip=1 when bound) query parameters to a
+// relative redirect path such as "/redirect/<key>?decode=1".
+func (s *redirectSigner) SignPath(r *http.Request, redirectPath string) string {
+	parsed, err := url.Parse(redirectPath)
+	if err != nil {
+		return redirectPath
+	}
+	expires := s.now().Add(s.ttl).Unix()
+	clientIP := ""
+	values := parsed.Query()
+	values.Del("sig")
+	if s.bindIP {
+		clientIP = redirectClientIP(r)
+		values.Set("ip", "1")
+	}
+	values.Set("exp", strconv.FormatInt(expires, 10))
+	values.Set("sig", s.sign(parsed.Path, values, clientIP))
+	parsed.RawQuery = values.Encode()
+	return parsed.String()
+}
+
+// Verify checks the signature on an incoming request and, for single-use
+// links, claims it for the requesting client.
+func (s *redirectSigner) Verify(r *http.Request) error {
+	query := r.URL.Query()
+	sig := query.Get("sig")
+	expRaw := query.Get("exp")
+	if sig == "" || expRaw == "" {
+		return errRedirectSignatureMissing
+	}
+	expires, err := strconv.ParseInt(expRaw, 10, 64)
+	if err != nil {
+		return errRedirectSignatureInvalid
+	}
+	clientIP := ""
+	if query.Get("ip") == "1" {
+		clientIP = redirectClientIP(r)
+	} else if s.bindIP {
+		return errRedirectSignatureInvalid
+	}
+	query.Del("sig")
+	expected := s.sign(r.URL.Path, query, clientIP)
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return errRedirectSignatureInvalid
+	}
+	now := s.now()
+	if now.Unix() > expires {
+		return errRedirectSignatureExpired
+	}
+	if s.singleUse {
+		return s.claim(sig, redirectClientIP(r), now, time.Unix(expires, 0))
+	}
+	return nil
+}
+
+// claim lets the first client of a single-use link keep using it while it
+// stays active, and rejects everyone else.
+func (s *redirectSigner) claim(sig, clientIP string, now, expires time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	use, ok := s.used[sig]
+	if !ok {
+		s.used[sig] = &redirectUse{clientIP: clientIP, last: now, expires: expires}
+		return nil
+	}
+	if use.clientIP != clientIP || now.Sub(use.last) > singleUseReuseWindow {
+		return errRedirectSignatureUsed
+	}
+	use.last = now
+	return nil
+}
+
+// purge drops single-use records whose links have expired anyway.
+func (s *redirectSigner) purge() {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for sig, use := range s.used {
+		if now.After(use.expires) {
+			delete(s.used, sig)
+		}
+	}
+}
+
+func redirectClientIP(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+	if err != nil {
+		return strings.TrimSpace(r.RemoteAddr)
+	}
+	return host
+}
+
+// signRedirectPath signs a redirect path when signed redirects are enabled.
+func (h *ProxyHandler) signRedirectPath(r *http.Request, redirectPath string) string {
+	if h == nil || h.signer == nil {
+		return redirectPath
+	}
+	return h.signer.SignPath(r, redirectPath)
+}
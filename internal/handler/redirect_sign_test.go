@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestRedirectSigner(bindIP, singleUse bool, now time.Time) *redirectSigner {
+	s := newRedirectSigner("test-secret", time.Hour, bindIP, singleUse)
+	s.now = func() time.Time { return now }
+	return s
+}
+
+func TestRedirectSignerRoundTrip(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	s := newTestRedirectSigner(false, false, now)
+	signed := s.SignPath(httptest.NewRequest("GET", "/api/fs/get", nil), "/redirect/abc?decode=1")
+
+	req := httptest.NewRequest("GET", signed, nil)
+	if err := s.Verify(req); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	if err := s.Verify(httptest.NewRequest("GET", signed, nil)); err != nil {
+		t.Fatalf("reuse without single-use should pass: %v", err)
+	}
+
+	tampered := httptest.NewRequest("GET", "/redirect/abd"+signed[len("/redirect/abc"):], nil)
+	if err := s.Verify(tampered); err != errRedirectSignatureInvalid {
+		t.Fatalf("tampered key err=%v", err)
+	}
+	if err := s.Verify(httptest.NewRequest("GET", "/redirect/abc?decode=1", nil)); err != errRedirectSignatureMissing {
+		t.Fatalf("unsigned err=%v", err)
+	}
+
+	s.now = func() time.Time { return now.Add(2 * time.Hour) }
+	if err := s.Verify(httptest.NewRequest("GET", signed, nil)); err != errRedirectSignatureExpired {
+		t.Fatalf("expired err=%v", err)
+	}
+}
+
+func TestRedirectSignerBindsClientIP(t *testing.T) {
+	s := newTestRedirectSigner(true, false, time.Unix(1_700_000_000, 0))
+	issuer := httptest.NewRequest("GET", "/api/fs/get", nil)
+	issuer.RemoteAddr = "10.0.0.5:4000"
+	signed := s.SignPath(issuer, "/redirect/abc")
+
+	same := httptest.NewRequest("GET", signed, nil)
+	same.RemoteAddr = "10.0.0.5:5123"
+	if err := s.Verify(same); err != nil {
+		t.Fatalf("same client: %v", err)
+	}
+	other := httptest.NewRequest("GET", signed, nil)
+	other.RemoteAddr = "10.0.0.6:5123"
+	if err := s.Verify(other); err != errRedirectSignatureInvalid {
+		t.Fatalf("other client err=%v", err)
+	}
+}
+
+func TestRedirectSignerCoversQuery(t *testing.T) {
+	s := newTestRedirectSigner(false, false, time.Unix(1_700_000_000, 0))
+	signed := s.SignPath(nil, "/redirect/abc?decode=1&lastUrl=%2Fmedia%2Fa.mkv")
+	if err := s.Verify(httptest.NewRequest("GET", signed, nil)); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+	for _, edit := range [][2]string{
+		{"lastUrl=%2Fmedia%2Fa.mkv", "lastUrl=%2Fother%2Fa.mkv"},
+		{"decode=1", "decode=0"},
+		{"decode=1&", ""},
+	} {
+		tampered := strings.Replace(signed, edit[0], edit[1], 1)
+		if tampered == signed {
+			t.Fatalf("edit %q did not apply to %s", edit[0], signed)
+		}
+		if err := s.Verify(httptest.NewRequest("GET", tampered, nil)); err != errRedirectSignatureInvalid {
+			t.Fatalf("%s: err=%v", tampered, err)
+		}
+	}
+	if err := s.Verify(httptest.NewRequest("GET", signed+"&lastUrl=%2Fother", nil)); err != errRedirectSignatureInvalid {
+		t.Fatalf("appended param err=%v", err)
+	}
+}
+
+func TestRedirectSignerSingleUse(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	s := newTestRedirectSigner(false, true, now)
+	signed := s.SignPath(nil, "/redirect/abc")
+	request := func(remote, rangeHeader string) error {
+		r := httptest.NewRequest("GET", signed, nil)
+		r.RemoteAddr = remote
+		if rangeHeader != "" {
+			r.Header.Set("Range", rangeHeader)
+		}
+		return s.Verify(r)
+	}
+
+	// A player opening the link and then seeking must keep working.
+	if err := request("10.0.0.5:4000", "bytes=0-"); err != nil {
+		t.Fatalf("first range: %v", err)
+	}
+	s.now = func() time.Time { return now.Add(30 * time.Second) }
+	if err := request("10.0.0.5:4001", "bytes=1048576-"); err != nil {
+		t.Fatalf("second range from same client: %v", err)
+	}
+	if err := request("10.0.0.6:4000", "bytes=0-"); err != errRedirectSignatureUsed {
+		t.Fatalf("other client err=%v", err)
+	}
+
+	s.now = func() time.Time { return now.Add(30*time.Second + singleUseReuseWindow + time.Second) }
+	if err := request("10.0.0.5:4002", "bytes=0-"); err != errRedirectSignatureUsed {
+		t.Fatalf("reuse after idle window err=%v", err)
+	}
+
+	s.now = func() time.Time { return now.Add(2 * time.Hour) }
+	s.purge()
+	if len(s.used) != 0 {
+		t.Fatal("expected purge to drop expired single-use record")
+	}
+}
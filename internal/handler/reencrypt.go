@@ -0,0 +1,597 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/encryption"
+	"github.com/alist-encrypt-go/internal/httputil"
+	"github.com/alist-encrypt-go/internal/pathutil"
+	"github.com/alist-encrypt-go/internal/workers"
+)
+
+const (
+	reencryptMaxFailures = 100
+	reencryptMaxJobs     = 50
+)
+
+// Re-encryption job states.
+const (
+	ReencryptQueued   = "queued"
+	ReencryptRunning  = "running"
+	ReencryptPaused   = "paused" // held by a maintenance window
+	ReencryptDone     = "done"
+	ReencryptError    = "error"
+	ReencryptCanceled = "canceled"
+)
+
+// ReencryptFailure records one file the job could not re-encrypt.
+type ReencryptFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// ReencryptStatus is the progress of a re-encryption job as reported by
+// /enc-api/reencrypt/status.
+type ReencryptStatus struct {
+	ID         string             `json:"id"`
+	Path       string             `json:"path"`
+	OldEncType string             `json:"oldEncType"`
+	EncType    string             `json:"encType"`
+	Status     string             `json:"status"`
+	TotalFiles int                `json:"totalFiles"`
+	DoneFiles  int                `json:"doneFiles"`
+	Skipped    int                `json:"skippedFiles"`
+	Failed     int                `json:"failedFiles"`
+	TotalBytes int64              `json:"totalBytes"`
+	DoneBytes  int64              `json:"doneBytes"`
+	Percent    float64            `json:"percent"`
+	Current    string             `json:"current,omitempty"`
+	Failures   []ReencryptFailure `json:"failures,omitempty"`
+	Error      string             `json:"error,omitempty"`
+	CreatedAt  time.Time          `json:"createdAt"`
+	UpdatedAt  time.Time          `json:"updatedAt"`
+}
+
+// ReencryptJob rewrites every file under an encrypted folder from an old
+// password/encType to the folder's current rule. Files are downloaded,
+// decrypted, re-encrypted and uploaded through the staging path, so an
+// original is only replaced once its re-encrypted copy has been verified.
+type ReencryptJob struct {
+	ReencryptStatus
+
+	oldPassword string
+	target      config.PasswdInfo
+	auth        http.Header
+	cancel      context.CancelFunc
+	mu          sync.Mutex
+}
+
+// snapshot copies the job's progress for JSON output.
+func (j *ReencryptJob) snapshot() ReencryptStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status := j.ReencryptStatus
+	status.Percent = calcPercent(j.DoneBytes, j.TotalBytes)
+	status.Failures = append([]ReencryptFailure(nil), j.Failures...)
+	return status
+}
+
+func (j *ReencryptJob) update(fn func(j *ReencryptJob)) {
+	j.mu.Lock()
+	fn(j)
+	j.UpdatedAt = time.Now()
+	j.mu.Unlock()
+}
+
+func (j *ReencryptJob) fail(filePath string, err error) {
+	j.update(func(j *ReencryptJob) {
+		j.Failed++
+		if len(j.Failures) < reencryptMaxFailures {
+			j.Failures = append(j.Failures, ReencryptFailure{Path: filePath, Error: err.Error()})
+		}
+	})
+}
+
+func (j *ReencryptJob) finished() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.Status == ReencryptDone || j.Status == ReencryptError || j.Status == ReencryptCanceled
+}
+
+// reencryptJobStore keeps the most recent jobs in memory.
+type reencryptJobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*ReencryptJob
+}
+
+var reencryptJobs = &reencryptJobStore{jobs: make(map[string]*ReencryptJob)}
+
+func (s *reencryptJobStore) add(job *ReencryptJob) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = job
+	if len(s.jobs) <= reencryptMaxJobs {
+		return
+	}
+	var oldest *ReencryptJob
+	for _, j := range s.jobs {
+		if j.finished() && (oldest == nil || j.CreatedAt.Before(oldest.CreatedAt)) {
+			oldest = j
+		}
+	}
+	if oldest != nil {
+		delete(s.jobs, oldest.ID)
+	}
+}
+
+func (s *reencryptJobStore) get(id string) *ReencryptJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.jobs[id]
+}
+
+// running returns the unfinished job under or above dir, if any.
+func (s *reencryptJobStore) running(dir string) *ReencryptJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		if !j.finished() && (pathutil.Within(dir, j.Path) || pathutil.Within(j.Path, dir)) {
+			return j
+		}
+	}
+	return nil
+}
+
+func (s *reencryptJobStore) list() []ReencryptStatus {
+	s.mu.Lock()
+	jobs := make([]*ReencryptJob, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, j)
+	}
+	s.mu.Unlock()
+	out := make([]ReencryptStatus, 0, len(jobs))
+	for _, j := range jobs {
+		out = append(out, j.snapshot())
+	}
+	sort.Slice(out, func(i, k int) bool { return out[i].CreatedAt.After(out[k].CreatedAt) })
+	return out
+}
+
+// reencryptFile is one file found by the walk.
+type reencryptFile struct {
+	displayDir string
+	realDir    string
+	name       string
+	size       int64
+}
+
+// HandleReencryptStart serves POST /enc-api/reencrypt/start. The body names
+// an encrypted folder and the password (and encType, when it changed) its
+// files were written with; they are rewritten with the folder's current
+// rule. The Alist token is taken from X-Alist-Token, falling back to the
+// configured scan credentials.
+func (h *AlistHandler) HandleReencryptStart(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Path        string `json:"path"`
+		OldPassword string `json:"oldPassword"`
+		OldEncType  string `json:"oldEncType"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		RespondAPIError(w, 400, "Invalid request")
+		return
+	}
+	root := strings.TrimSpace(req.Path)
+	if root == "" {
+		RespondAPIError(w, 400, "path is required")
+		return
+	}
+	root = pathutil.CleanInput(root)
+	if req.OldPassword == "" {
+		RespondAPIError(w, 400, "oldPassword is required")
+		return
+	}
+	if !h.passwdDAO.MatchDir(root) {
+		RespondAPIError(w, 400, "path is not under an encrypted folder")
+		return
+	}
+	rule, ok := h.passwdDAO.FindByDir(root)
+	if !ok {
+		RespondAPIError(w, 400, "path is not under an encrypted folder")
+		return
+	}
+	newEncType := encryption.NormalizeEncType(rule.EncType)
+	if newEncType == "" {
+		newEncType = encryption.EncTypeAESCTR
+	}
+	oldEncType := encryption.NormalizeEncType(req.OldEncType)
+	if oldEncType == "" {
+		oldEncType = newEncType
+	}
+	if !encryption.IsRegistered(oldEncType) {
+		RespondAPIError(w, 400, "unsupported oldEncType")
+		return
+	}
+	if current, _ := rule.CurrentPassword(); req.OldPassword == current && oldEncType == newEncType {
+		RespondAPIError(w, 400, "the folder already uses this password and encType")
+		return
+	}
+	if reencryptJobs.running(root) != nil {
+		RespondAPIError(w, 409, "a re-encryption job is already running for this folder")
+		return
+	}
+
+	auth := make(http.Header)
+	if token := strings.TrimSpace(r.Header.Get("X-Alist-Token")); token != "" {
+		auth.Set("Authorization", token)
+	} else {
+		auth = h.scanAuthHeaders()
+	}
+
+	// The rewritten content must be exactly what was stored, so the
+	// folder's upload transforms are not applied again.
+	target := *rule
+	target.UploadTransforms = nil
+	target.StripMetadata = false
+
+	ctx, cancel := context.WithCancel(context.Background())
+	job := &ReencryptJob{
+		ReencryptStatus: ReencryptStatus{
+			ID:         generateTaskID(),
+			Path:       root,
+			OldEncType: string(oldEncType),
+			EncType:    string(newEncType),
+			Status:     ReencryptQueued,
+			CreatedAt:  time.Now(),
+			UpdatedAt:  time.Now(),
+		},
+		oldPassword: req.OldPassword,
+		target:      target,
+		auth:        auth,
+		cancel:      cancel,
+	}
+	reencryptJobs.add(job)
+	log.Info().Str("job_id", job.ID).Str("path", root).Str("old_enc_type", string(oldEncType)).
+		Str("enc_type", string(newEncType)).Msg("Re-encryption job queued")
+
+	go func() {
+		defer cancel()
+		// Jobs wait in "queued" until a jobs slot frees up.
+		jobs := workers.Shared(config.PoolJobs, config.Get().WorkerLimit(config.PoolJobs))
+		if err := jobs.Acquire(ctx); err != nil {
+			job.update(func(j *ReencryptJob) { j.Status = ReencryptCanceled })
+			return
+		}
+		defer jobs.Release()
+		h.runReencryptJob(ctx, job)
+	}()
+
+	RespondSuccess(w, job.snapshot())
+}
+
+// HandleReencryptStatus serves GET /enc-api/reencrypt/status?id=...; without
+// an id it lists the recent jobs.
+func (h *AlistHandler) HandleReencryptStatus(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(r.URL.Query().Get("id"))
+	if id == "" {
+		RespondSuccess(w, map[string]interface{}{"jobs": reencryptJobs.list()})
+		return
+	}
+	job := reencryptJobs.get(id)
+	if job == nil {
+		RespondAPIError(w, 404, "Task not found")
+		return
+	}
+	RespondSuccess(w, job.snapshot())
+}
+
+// HandleReencryptCancel serves POST /enc-api/reencrypt/cancel?id=.... The
+// file being rewritten is abandoned before its original is replaced.
+func (h *AlistHandler) HandleReencryptCancel(w http.ResponseWriter, r *http.Request) {
+	job := reencryptJobs.get(strings.TrimSpace(r.URL.Query().Get("id")))
+	if job == nil {
+		RespondAPIError(w, 404, "Task not found")
+		return
+	}
+	job.cancel()
+	RespondSuccessMsg(w, "stopped")
+}
+
+func (h *AlistHandler) runReencryptJob(ctx context.Context, job *ReencryptJob) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error().Interface("panic", r).Str("job_id", job.ID).Msg("Re-encryption job panicked")
+			job.update(func(j *ReencryptJob) {
+				j.Status = ReencryptError
+				j.Error = fmt.Sprintf("panic: %v", r)
+			})
+		}
+	}()
+	job.update(func(j *ReencryptJob) { j.Status = ReencryptRunning })
+
+	files, err := h.collectReencryptFiles(ctx, job.Path, &job.target, job.auth)
+	if err != nil {
+		job.update(func(j *ReencryptJob) {
+			j.Status = ReencryptError
+			j.Error = "failed to list " + job.Path + ": " + err.Error()
+		})
+		return
+	}
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	job.update(func(j *ReencryptJob) {
+		j.TotalFiles = len(files)
+		j.TotalBytes = total
+	})
+
+	for _, f := range files {
+		if h.maintenance.Paused() {
+			job.update(func(j *ReencryptJob) { j.Status = ReencryptPaused })
+			_ = h.maintenance.Wait(ctx, jobReencrypt)
+			job.update(func(j *ReencryptJob) { j.Status = ReencryptRunning })
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		displayPath := path.Join(f.displayDir, f.name)
+		job.update(func(j *ReencryptJob) { j.Current = displayPath })
+		release, err := acquireStorageStream(ctx, displayPath)
+		if err == nil {
+			err = waitStorageRequest(ctx, displayPath)
+		}
+		if err != nil {
+			if release != nil {
+				release()
+			}
+			break
+		}
+		skipped, err := h.reencryptFile(ctx, job, f)
+		release()
+		switch {
+		case err != nil && ctx.Err() != nil:
+			// Canceled mid-file; the original was left in place.
+		case err != nil:
+			log.Warn().Err(err).Str("job_id", job.ID).Str("path", displayPath).Msg("Re-encryption failed for file")
+			job.fail(displayPath, err)
+		case skipped:
+			job.update(func(j *ReencryptJob) { j.Skipped++ })
+		default:
+			job.update(func(j *ReencryptJob) { j.DoneFiles++ })
+		}
+	}
+
+	job.update(func(j *ReencryptJob) {
+		j.Current = ""
+		if ctx.Err() != nil {
+			j.Status = ReencryptCanceled
+			return
+		}
+		j.Status = ReencryptDone
+	})
+	snap := job.snapshot()
+	log.Info().Str("job_id", job.ID).Str("status", snap.Status).Int("files", snap.DoneFiles).
+		Int("skipped", snap.Skipped).Int("failed", snap.Failed).Msg("Re-encryption job finished")
+}
+
+// collectReencryptFiles walks root breadth-first by upstream path. Folder
+// names are not rewritten, so subdirectories keep their stored names.
+// Subfolders governed by a different rule (another encPath or a folder
+// password) are left for a job of their own.
+func (h *AlistHandler) collectReencryptFiles(ctx context.Context, root string, target *config.PasswdInfo, auth http.Header) ([]reencryptFile, error) {
+	type node struct {
+		displayDir string
+		realDir    string
+		depth      int
+	}
+	queue := []node{{displayDir: root, realDir: h.realDirPath(root)}}
+	var files []reencryptFile
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		current := queue[0]
+		queue = queue[1:]
+
+		content, err := h.listAlistDir(ctx, current.realDir, auth)
+		if err != nil {
+			if current.displayDir == root {
+				return nil, err
+			}
+			log.Warn().Err(err).Str("path", current.displayDir).Msg("Re-encryption skipped unreadable directory")
+			continue
+		}
+		for _, raw := range content {
+			fileData, ok := raw.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _ := fileData["name"].(string)
+			if name == "" || strings.HasSuffix(name, uploadStagingSuffix) {
+				continue
+			}
+			if isDir, _ := fileData["is_dir"].(bool); isDir {
+				displayDir := path.Join(current.displayDir, name)
+				if rule, ok := h.passwdDAO.FindByDir(displayDir); !ok || rule.Password != target.Password || rule.EncType != target.EncType {
+					log.Info().Str("path", displayDir).Msg("Re-encryption skipped folder with a different rule")
+					continue
+				}
+				if current.depth < inventoryMaxDepth {
+					queue = append(queue, node{
+						displayDir: displayDir,
+						realDir:    path.Join(current.realDir, name),
+						depth:      current.depth + 1,
+					})
+				}
+				continue
+			}
+			files = append(files, reencryptFile{
+				displayDir: current.displayDir,
+				realDir:    current.realDir,
+				name:       name,
+				size:       inventoryInt64(fileData["size"]),
+			})
+			if len(files) >= inventoryMaxItems {
+				return files, nil
+			}
+		}
+	}
+	return files, nil
+}
+
+// reencryptFile rewrites one file. It reports skipped for files whose name
+// already decodes with the new password, i.e. ones a previous run finished,
+// and for files whose header already carries the current password version.
+func (h *AlistHandler) reencryptFile(ctx context.Context, job *ReencryptJob, f reencryptFile) (bool, error) {
+	target := &job.target
+	newName := f.name
+	displayName := f.name
+	if target.EncName {
+		allowLoose := h.cfg != nil && h.cfg.AlistServer.AllowLooseDecode
+		old := encryption.NewFileNameConverter(job.oldPassword, job.OldEncType, target.NameSuffix()).WithDialect(target.NameDialect)
+		if target.NameKDF != "" {
+			old.WithKDF(target.NameKDF, target.NameKDFPrevious)
+		}
+		displayName = old.ShowName(f.name, allowLoose)
+		if encryption.IsOriginalFile(displayName) {
+			if !encryption.IsOriginalFile(h.convertShowName(target, f.name)) {
+				return true, nil
+			}
+			return false, fmt.Errorf("name does not decode with the old password")
+		}
+		newName = target.NameConverter().ToRealName(displayName)
+	}
+	realPath := path.Join(f.realDir, f.name)
+	finalPath := path.Join(f.realDir, newName)
+
+	// apiReq carries the Alist credentials for fs calls and is the upload
+	// request handed to putStaged.
+	apiReq, err := http.NewRequestWithContext(ctx, http.MethodPut, "http://reencrypt.local/api/fs/put", nil)
+	if err != nil {
+		return false, err
+	}
+	for key, values := range job.auth {
+		apiReq.Header[key] = append([]string(nil), values...)
+	}
+
+	body, ciphertextSize, err := h.openReencryptSource(ctx, apiReq, realPath)
+	if err != nil {
+		return false, err
+	}
+	defer body.Close()
+	counted := &reencryptProgressReader{r: body, job: job}
+	plain, meta, err := encryption.AutoDecryptReader(job.oldPassword, encryption.EncType(job.OldEncType), counted, ciphertextSize)
+	if err != nil {
+		counted.rollback()
+		return false, fmt.Errorf("decrypt: %w", err)
+	}
+	sameType := encryption.NormalizeEncType(job.OldEncType) == encryption.NormalizeEncType(target.EncType)
+	if _, current := target.CurrentPassword(); sameType && current > 1 && meta.EffectivePasswordVersion() == current {
+		// Already written with the folder's current password version.
+		counted.rollback()
+		return true, nil
+	}
+
+	apiReq.Body = io.NopCloser(plain)
+	apiReq.ContentLength = meta.PlainSize
+	apiReq.Header.Set("Content-Length", strconv.FormatInt(meta.PlainSize, 10))
+	apiReq.Header.Set("Content-Type", "application/octet-stream")
+	apiReq.Header.Set("File-Path", pathutil.EscapeFilePath(finalPath))
+	targetURL := httputil.BuildTargetURL(h.cfg.GetAlistURL(), "/api/fs/put", nil)
+	rec := newStagedUploadRecorder()
+	if !h.putStaged(rec, apiReq, targetURL, target, meta.PlainSize, finalPath, "") {
+		counted.rollback()
+		return false, fmt.Errorf("upload: status %d: %s", rec.status, strings.TrimSpace(rec.body.String()))
+	}
+
+	if finalPath != realPath {
+		h.discardStagedUpload(context.WithoutCancel(ctx), apiReq, realPath)
+	}
+	displayPath := path.Join(f.displayDir, displayName)
+	h.fileDAO.Revive(displayPath)
+	h.fileDAO.InvalidateDisplayPath(displayPath)
+	if target.EncName {
+		h.fileDAO.SetEncPathMapping(displayPath, finalPath)
+	}
+	h.InvalidateListCache(f.displayDir)
+	return false, nil
+}
+
+// openReencryptSource downloads the stored ciphertext of realPath.
+func (h *AlistHandler) openReencryptSource(ctx context.Context, apiReq *http.Request, realPath string) (io.ReadCloser, int64, error) {
+	var data struct {
+		RawURL string `json:"raw_url"`
+		Sign   string `json:"sign"`
+		Size   int64  `json:"size"`
+	}
+	if err := h.alistAPICall(ctx, apiReq, "/api/fs/get", map[string]interface{}{"path": realPath}, &data); err != nil {
+		return nil, 0, err
+	}
+	source := data.RawURL
+	if source == "" {
+		source = strings.TrimRight(h.cfg.GetAlistURL(), "/") + "/d" + (&url.URL{Path: realPath}).EscapedPath()
+		if data.Sign != "" {
+			source += "?sign=" + url.QueryEscape(data.Sign)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if strings.HasPrefix(source, h.cfg.GetAlistURL()) {
+		copyAuthHeaders(req, apiReq.Header)
+	}
+	// Storage links may redirect, and large files must not hit the API
+	// request timeout.
+	client := &http.Client{Transport: h.httpClient.Transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, 0, fmt.Errorf("download: status %d", resp.StatusCode)
+	}
+	size := data.Size
+	if resp.ContentLength >= 0 {
+		size = resp.ContentLength
+	}
+	return resp.Body, size, nil
+}
+
+// reencryptProgressReader adds downloaded bytes to the job's progress and can
+// take them back when the file fails.
+type reencryptProgressReader struct {
+	r   io.Reader
+	job *ReencryptJob
+	n   int64
+}
+
+func (p *reencryptProgressReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	if n > 0 {
+		p.n += int64(n)
+		p.job.update(func(j *ReencryptJob) { j.DoneBytes += int64(n) })
+	}
+	return n, err
+}
+
+func (p *reencryptProgressReader) rollback() {
+	n := p.n
+	p.n = 0
+	p.job.update(func(j *ReencryptJob) { j.DoneBytes -= n })
+}
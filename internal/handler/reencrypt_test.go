@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/encryption"
+)
+
+// fakeAlistFS is a flat in-memory Alist serving just the fs calls the
+// re-encryption job makes.
+type fakeAlistFS struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func (f *fakeAlistFS) handler() http.Handler {
+	decode := func(r *http.Request) map[string]interface{} {
+		var req map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		return req
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/fs/list", func(w http.ResponseWriter, r *http.Request) {
+		dir, _ := decode(r)["path"].(string)
+		f.mu.Lock()
+		var content []interface{}
+		for p, data := range f.files {
+			if path.Dir(p) == dir {
+				content = append(content, map[string]interface{}{"name": path.Base(p), "is_dir": false, "size": float64(len(data))})
+			}
+		}
+		f.mu.Unlock()
+		writeJSONResponse(w, map[string]interface{}{"code": 200, "data": map[string]interface{}{"content": content, "total": float64(len(content))}})
+	})
+	mux.HandleFunc("/api/fs/get", func(w http.ResponseWriter, r *http.Request) {
+		p, _ := decode(r)["path"].(string)
+		f.mu.Lock()
+		data, ok := f.files[p]
+		f.mu.Unlock()
+		if !ok {
+			writeJSONResponse(w, map[string]interface{}{"code": 500, "message": "object not found"})
+			return
+		}
+		writeJSONResponse(w, map[string]interface{}{"code": 200, "data": map[string]interface{}{"size": float64(len(data)), "raw_url": ""}})
+	})
+	mux.HandleFunc("/d/", func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		data, ok := f.files[strings.TrimPrefix(r.URL.Path, "/d")]
+		f.mu.Unlock()
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write(data)
+	})
+	mux.HandleFunc("/api/fs/put", func(w http.ResponseWriter, r *http.Request) {
+		p, _ := url.QueryUnescape(r.Header.Get("File-Path"))
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			return // the proxy aborted the upload
+		}
+		f.mu.Lock()
+		f.files[p] = data
+		f.mu.Unlock()
+		writeJSONResponse(w, map[string]interface{}{"code": 200})
+	})
+	mux.HandleFunc("/api/fs/rename", func(w http.ResponseWriter, r *http.Request) {
+		req := decode(r)
+		from, _ := req["path"].(string)
+		name, _ := req["name"].(string)
+		f.mu.Lock()
+		f.files[path.Join(path.Dir(from), name)] = f.files[from]
+		delete(f.files, from)
+		f.mu.Unlock()
+		writeJSONResponse(w, map[string]interface{}{"code": 200})
+	})
+	mux.HandleFunc("/api/fs/remove", func(w http.ResponseWriter, r *http.Request) {
+		req := decode(r)
+		dir, _ := req["dir"].(string)
+		names, _ := req["names"].([]interface{})
+		f.mu.Lock()
+		for _, n := range names {
+			delete(f.files, path.Join(dir, n.(string)))
+		}
+		f.mu.Unlock()
+		writeJSONResponse(w, map[string]interface{}{"code": 200})
+	})
+	return mux
+}
+
+func sealForTest(t *testing.T, password, encType string, plain []byte) []byte {
+	t.Helper()
+	enc, err := encryption.NewLatestContentEncryptor(password, encType, int64(len(plain)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := enc.EncryptReader(bytes.NewReader(plain), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := io.ReadAll(reader)
+	return out
+}
+
+func waitReencryptJob(t *testing.T, id string) ReencryptStatus {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		if job := reencryptJobs.get(id); job != nil && job.finished() {
+			return job.snapshot()
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return ReencryptStatus{}
+}
+
+func TestReencryptJobRotatesPasswordAndNames(t *testing.T) {
+	passwd := &config.PasswdInfo{
+		Password: "new-pass",
+		EncType:  "chacha20",
+		Enable:   true,
+		EncName:  true,
+		EncPath:  []string{"/vault/*"},
+	}
+	oldNames := encryption.NewFileNameConverter("old-pass", "aesctr", "")
+	newNames := encryption.NewFileNameConverter(passwd.Password, passwd.EncType, "")
+	plain := bytes.Repeat([]byte("rotate-me-"), 5000)
+	done := []byte("already rotated")
+
+	fs := &fakeAlistFS{files: map[string][]byte{
+		"/vault/" + oldNames.ToRealName("movie.mkv"): sealForTest(t, "old-pass", "aesctr", plain),
+		"/vault/" + newNames.ToRealName("done.txt"):  sealForTest(t, passwd.Password, passwd.EncType, done),
+	}}
+	srv := newSocketTestServer(t, fs.handler())
+	defer srv.Close()
+	handler, _ := newTestAlistHandler(t, srv.URL, passwd)
+
+	body := `{"path":"/vault","oldPassword":"old-pass","oldEncType":"aes-ctr"}`
+	req := httptest.NewRequest(http.MethodPost, "/enc-api/reencrypt/start", strings.NewReader(body))
+	req.Header.Set("X-Alist-Token", "alist-token")
+	rec := httptest.NewRecorder()
+	handler.HandleReencryptStart(rec, req)
+	var started struct {
+		Code int             `json:"code"`
+		Data ReencryptStatus `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &started); err != nil || started.Data.ID == "" {
+		t.Fatalf("start: %s", rec.Body.String())
+	}
+
+	job := waitReencryptJob(t, started.Data.ID)
+	if job.Status != ReencryptDone || job.DoneFiles != 1 || job.Skipped != 1 || job.Failed != 0 {
+		t.Fatalf("job=%+v", job)
+	}
+	if len(fs.files) != 2 {
+		t.Fatalf("files after rotation: %d", len(fs.files))
+	}
+	stored, ok := fs.files["/vault/"+newNames.ToRealName("movie.mkv")]
+	if !ok {
+		t.Fatal("rotated file not stored under its new name")
+	}
+	reader, _, err := encryption.AutoDecryptReader(passwd.Password, encryption.EncType(passwd.EncType), bytes.NewReader(stored), int64(len(stored)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(reader); !bytes.Equal(got, plain) {
+		t.Fatal("rotated content does not decrypt with the new password")
+	}
+}
+
+func TestReencryptJobKeepsOriginalOnWrongOldPassword(t *testing.T) {
+	passwd := &config.PasswdInfo{
+		Password: "new-pass",
+		EncType:  "aesgcm",
+		Enable:   true,
+		EncPath:  []string{"/vault/*"},
+	}
+	original := sealForTest(t, "old-pass", "aesgcm", []byte("sealed content"))
+	fs := &fakeAlistFS{files: map[string][]byte{"/vault/doc.txt": original}}
+	srv := newSocketTestServer(t, fs.handler())
+	defer srv.Close()
+	handler, _ := newTestAlistHandler(t, srv.URL, passwd)
+
+	req := httptest.NewRequest(http.MethodPost, "/enc-api/reencrypt/start", strings.NewReader(`{"path":"/vault","oldPassword":"wrong-pass"}`))
+	rec := httptest.NewRecorder()
+	handler.HandleReencryptStart(rec, req)
+	var started struct {
+		Data ReencryptStatus `json:"data"`
+	}
+	_ = json.Unmarshal(rec.Body.Bytes(), &started)
+
+	job := waitReencryptJob(t, started.Data.ID)
+	if job.Failed != 1 || len(job.Failures) != 1 || job.Failures[0].Path != "/vault/doc.txt" {
+		t.Fatalf("job=%+v", job)
+	}
+	if len(fs.files) != 1 || !bytes.Equal(fs.files["/vault/doc.txt"], original) {
+		t.Fatalf("original was touched: %v", fs.files)
+	}
+}
+
+func TestReencryptStartRejectsUnchangedKey(t *testing.T) {
+	passwd := &config.PasswdInfo{Password: "same", EncType: "aesctr", Enable: true, EncPath: []string{"/vault/*"}}
+	handler, _ := newTestAlistHandler(t, "http://127.0.0.1:1", passwd)
+
+	rec := httptest.NewRecorder()
+	handler.HandleReencryptStart(rec, httptest.NewRequest(http.MethodPost, "/enc-api/reencrypt/start", strings.NewReader(`{"path":"/vault","oldPassword":"same"}`)))
+	if !strings.Contains(rec.Body.String(), `"code":400`) {
+		t.Fatalf("body=%s", rec.Body.String())
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/rs/zerolog/log"
 
 	"github.com/alist-encrypt-go/internal/errors"
+	"github.com/alist-encrypt-go/internal/i18n"
 )
 
 // maxProxyResponseBody is the default maximum size (10 MB) for buffering upstream responses.
@@ -66,9 +67,10 @@ func readLimitedBody(resp *http.Response, maxBytes int64) ([]byte, error) {
 
 // APIResponse represents a standard API response
 type APIResponse struct {
-	Code int         `json:"code"`
-	Msg  string      `json:"msg,omitempty"`
-	Data interface{} `json:"data,omitempty"`
+	Code      int         `json:"code"`
+	ErrorCode string      `json:"error_code,omitempty"`
+	Msg       string      `json:"msg,omitempty"`
+	Data      interface{} `json:"data,omitempty"`
 }
 
 // RespondError writes a JSON error response with logging
@@ -86,22 +88,39 @@ func RespondError(w http.ResponseWriter, err error) {
 		log.Error().Msg(appErr.Message)
 	}
 
+	code := errors.CodeOf(appErr)
+	w.Header().Set(errors.HeaderErrorCode, string(code))
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(appErr.HTTPStatus)
 	json.NewEncoder(w).Encode(APIResponse{
-		Code: int(appErr.Code),
-		Msg:  appErr.Message,
+		Code:      int(appErr.Code),
+		ErrorCode: string(code),
+		Msg:       appErr.Message,
 	})
 }
 
 // RespondAPIError writes an API-style error response (code in body, HTTP 200)
-// This matches the Alist API convention where errors return HTTP 200 with error code in body
+// This matches the Alist API convention where errors return HTTP 200 with error code in body.
+// The message is localized when the locale middleware negotiated a language.
 func RespondAPIError(w http.ResponseWriter, code int, message string) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(APIResponse{
 		Code: code,
-		Msg:  message,
+		Msg:  i18n.Localize(w, message),
+	})
+}
+
+// RespondAPIErrorCode is RespondAPIError with a stable error code in the body
+// and the X-Enc-Error header.
+func RespondAPIErrorCode(w http.ResponseWriter, code int, errCode errors.Code, message string) {
+	w.Header().Set(errors.HeaderErrorCode, string(errCode))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(APIResponse{
+		Code:      code,
+		ErrorCode: string(errCode),
+		Msg:       i18n.Localize(w, message),
 	})
 }
 
@@ -121,18 +140,27 @@ func RespondSuccessMsg(w http.ResponseWriter, message string) {
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(APIResponse{
 		Code: 0,
-		Msg:  message,
+		Msg:  i18n.Localize(w, message),
 	})
 }
 
 // RespondHTTPError writes a plain HTTP error for non-API endpoints
 func RespondHTTPError(w http.ResponseWriter, err error) {
 	status := errors.ToHTTPStatus(err)
+	w.Header().Set(errors.HeaderErrorCode, string(errors.CodeOf(err)))
 	http.Error(w, err.Error(), status)
 }
 
-// RespondHTTPErrorWithStatus writes a plain HTTP error with explicit status code
+// RespondHTTPErrorWithStatus writes a plain HTTP error with explicit status
+// code; X-Enc-Error gets a generic code derived from the status.
 func RespondHTTPErrorWithStatus(w http.ResponseWriter, message string, status int) {
+	RespondCodedError(w, errors.CodeForStatus(status), message, status)
+}
+
+// RespondCodedError writes a plain HTTP error tagged with a stable code in the
+// X-Enc-Error header, for download and WebDAV clients that expect text bodies.
+func RespondCodedError(w http.ResponseWriter, code errors.Code, message string, status int) {
+	w.Header().Set(errors.HeaderErrorCode, string(code))
 	http.Error(w, message, status)
 }
 
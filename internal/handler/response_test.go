@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/errors"
+)
+
+func TestRespondErrorCarriesStableCode(t *testing.T) {
+	rec := httptest.NewRecorder()
+	RespondError(rec, errors.NewDecryptionError("bad key").WithCode(errors.CodeDecryptValidation))
+
+	if got := rec.Header().Get(errors.HeaderErrorCode); got != string(errors.CodeDecryptValidation) {
+		t.Fatalf("X-Enc-Error=%q", got)
+	}
+	var body APIResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode body: %v", err)
+	}
+	if body.ErrorCode != string(errors.CodeDecryptValidation) || body.Code != int(errors.ErrCodeDecryption) {
+		t.Fatalf("body=%+v", body)
+	}
+}
+
+func TestRespondHTTPErrorWithStatusDerivesCode(t *testing.T) {
+	rec := httptest.NewRecorder()
+	RespondHTTPErrorWithStatus(rec, "Proxy error", http.StatusBadGateway)
+	if got := rec.Header().Get(errors.HeaderErrorCode); got != string(errors.CodeUpstreamUnreachable) {
+		t.Fatalf("X-Enc-Error=%q", got)
+	}
+
+	if got := errors.CodeForFailure("range_unsupported"); got != errors.CodeRangeUnsupported {
+		t.Fatalf("CodeForFailure(range_unsupported)=%q", got)
+	}
+}
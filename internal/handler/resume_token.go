@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/dao"
+	"github.com/alist-encrypt-go/internal/trace"
+)
+
+// resumeTokenHeader carries resume tokens on large decrypted downloads and
+// on the requests that continue them. The "resume" query parameter works too
+// for clients that cannot set headers.
+const resumeTokenHeader = "X-Resume-Token"
+
+var (
+	errResumeTokenInvalid = errors.New("invalid resume token")
+	errResumeTokenExpired = errors.New("resume token expired")
+)
+
+// resumeToken pins what a download negotiated (the encrypted path, the plain
+// and ciphertext sizes and the size strategy that worked) so a download cut
+// by a proxy restart continues without asking Alist again. Offset is where
+// the response carrying the token started; clients add what they received.
+type resumeToken struct {
+	Path           string       `json:"p"`
+	RealPath       string       `json:"r"`
+	Size           int64        `json:"s"`
+	CiphertextSize int64        `json:"cs,omitempty"`
+	ContentVersion int          `json:"cv,omitempty"`
+	HeaderLen      int64        `json:"hl,omitempty"`
+	NonceField     []byte       `json:"n,omitempty"`
+	Strategy       StrategyType `json:"st,omitempty"`
+	Offset         int64        `json:"o"`
+	Expires        int64        `json:"e"`
+}
+
+// resumeSigner issues and verifies resume tokens. The key is derived from the
+// JWT secret, so tokens stay valid across restarts as long as the secret does.
+type resumeSigner struct {
+	secret  []byte
+	ttl     time.Duration
+	minSize int64
+	now     func() time.Time
+}
+
+func newResumeSigner(jwtSecret string, ttl time.Duration, minSize int64) *resumeSigner {
+	mac := hmac.New(sha256.New, []byte(jwtSecret))
+	mac.Write([]byte("alist-encrypt-go/resume-token/v1"))
+	return &resumeSigner{
+		secret:  mac.Sum(nil),
+		ttl:     ttl,
+		minSize: minSize,
+		now:     time.Now,
+	}
+}
+
+func (s *resumeSigner) sign(payload string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Issue returns a token for info served from offset, or "" for files below
+// the configured minimum size.
+func (s *resumeSigner) Issue(info *dao.FileInfo, realPath string, strategy StrategyType, offset int64) string {
+	if info == nil || info.Size < s.minSize || info.Size <= 0 {
+		return ""
+	}
+	data, err := json.Marshal(resumeToken{
+		Path:           info.Path,
+		RealPath:       realPath,
+		Size:           info.Size,
+		CiphertextSize: info.CiphertextSize,
+		ContentVersion: info.ContentVersion,
+		HeaderLen:      info.HeaderLen,
+		NonceField:     info.NonceField,
+		Strategy:       strategy,
+		Offset:         offset,
+		Expires:        s.now().Add(s.ttl).Unix(),
+	})
+	if err != nil {
+		return ""
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + s.sign(payload)
+}
+
+// Parse verifies a token and returns its contents.
+func (s *resumeSigner) Parse(token string) (*resumeToken, error) {
+	payload, sig, ok := strings.Cut(strings.TrimSpace(token), ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.sign(payload))) {
+		return nil, errResumeTokenInvalid
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errResumeTokenInvalid
+	}
+	var t resumeToken
+	if err := json.Unmarshal(data, &t); err != nil || t.Path == "" || t.Size <= 0 {
+		return nil, errResumeTokenInvalid
+	}
+	if s.now().Unix() > t.Expires {
+		return nil, errResumeTokenExpired
+	}
+	return &t, nil
+}
+
+// FileInfo returns the file metadata the token was issued for.
+func (t *resumeToken) FileInfo() *dao.FileInfo {
+	return &dao.FileInfo{
+		Path:           t.Path,
+		EncryptedPath:  t.RealPath,
+		Size:           t.Size,
+		CiphertextSize: t.CiphertextSize,
+		ContentVersion: t.ContentVersion,
+		HeaderLen:      t.HeaderLen,
+		NonceField:     t.NonceField,
+	}
+}
+
+// resumeDownload applies the resume token a download request presents: the
+// negotiated metadata is put back into the caches and a request without a
+// Range starts at the token's offset. Tokens that do not verify or name
+// another file are ignored; ok is false when the request was answered
+// because the file changed since the token was issued.
+func (h *ProxyHandler) resumeDownload(w http.ResponseWriter, r *http.Request, displayPath string) (token *resumeToken, ok bool) {
+	raw := requestResumeToken(r)
+	if h.resumer == nil || raw == "" {
+		return nil, true
+	}
+	r.Header.Del(resumeTokenHeader)
+	t, err := h.resumer.Parse(raw)
+	if err != nil {
+		trace.Logf(r.Context(), "download", "Ignoring resume token: %v", err)
+		return nil, true
+	}
+	if t.Path != displayPath {
+		trace.Logf(r.Context(), "download", "Ignoring resume token issued for %s", t.Path)
+		return nil, true
+	}
+	cached, found := h.fileDAO.Get(displayPath)
+	if found && cached != nil && cached.Size > 0 && cached.Size != t.Size {
+		RespondHTTPErrorWithStatus(w, "File changed since the resume token was issued", http.StatusPreconditionFailed)
+		return nil, false
+	}
+	if !found || cached == nil || cached.Size <= 0 {
+		_ = h.fileDAO.Set(t.FileInfo())
+	}
+	if t.Strategy != "" {
+		h.strategyCache.RecordSuccess(path.Dir(displayPath), t.Strategy)
+	}
+	if r.Header.Get("Range") == "" && t.Offset > 0 {
+		r.Header.Set("Range", "bytes="+strconv.FormatInt(t.Offset, 10)+"-")
+	}
+	return t, true
+}
+
+// requestResumeToken returns the resume token a request presents, if any.
+func requestResumeToken(r *http.Request) string {
+	if token := r.Header.Get(resumeTokenHeader); token != "" {
+		return token
+	}
+	return r.URL.Query().Get("resume")
+}
+
+// requestRangeStart returns the first byte a download request asks for.
+func requestRangeStart(r *http.Request) int64 {
+	raw, ok := strings.CutPrefix(r.Header.Get("Range"), "bytes=")
+	if !ok {
+		return 0
+	}
+	raw, _, _ = strings.Cut(raw, ",")
+	start, _, _ := strings.Cut(raw, "-")
+	n, err := strconv.ParseInt(strings.TrimSpace(start), 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/dao"
+	"github.com/alist-encrypt-go/internal/encryption"
+	"github.com/alist-encrypt-go/internal/proxy"
+	"github.com/alist-encrypt-go/internal/storage"
+)
+
+func TestResumeSignerRoundTrip(t *testing.T) {
+	now := time.Unix(1_700_000_000, 0)
+	s := newResumeSigner("test-secret", time.Hour, 100)
+	s.now = func() time.Time { return now }
+
+	if token := s.Issue(&dao.FileInfo{Path: "/a/small.bin", Size: 99}, "/a/small.bin", StrategyHEADRequest, 0); token != "" {
+		t.Fatalf("files below the minimum size should not get a token")
+	}
+	token := s.Issue(&dao.FileInfo{Path: "/a/movie.mkv", Size: 4096, ContentVersion: 2, HeaderLen: 32}, "/a/enc.mkv", StrategyHEADRequest, 1000)
+	if token == "" {
+		t.Fatal("expected a token")
+	}
+
+	// A proxy restarted with the same secret accepts the token.
+	restarted := newResumeSigner("test-secret", time.Hour, 100)
+	restarted.now = s.now
+	got, err := restarted.Parse(token)
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if got.Path != "/a/movie.mkv" || got.RealPath != "/a/enc.mkv" || got.Size != 4096 || got.Offset != 1000 ||
+		got.Strategy != StrategyHEADRequest || got.ContentVersion != 2 || got.HeaderLen != 32 {
+		t.Fatalf("unexpected token contents: %+v", got)
+	}
+
+	if _, err := newResumeSigner("other-secret", time.Hour, 100).Parse(token); err != errResumeTokenInvalid {
+		t.Fatalf("foreign secret err=%v", err)
+	}
+	payload, sig, _ := strings.Cut(token, ".")
+	if _, err := s.Parse(payload + "x." + sig); err != errResumeTokenInvalid {
+		t.Fatalf("tampered payload err=%v", err)
+	}
+	s.now = func() time.Time { return now.Add(2 * time.Hour) }
+	if _, err := s.Parse(token); err != errResumeTokenExpired {
+		t.Fatalf("expired err=%v", err)
+	}
+}
+
+func TestRequestRangeStart(t *testing.T) {
+	cases := map[string]int64{"": 0, "bytes=100-": 100, "bytes=5-9,20-30": 5, "bytes=-500": 0, "items=3-": 0}
+	for header, want := range cases {
+		req := httptest.NewRequest(http.MethodGet, "/d/a", nil)
+		if header != "" {
+			req.Header.Set("Range", header)
+		}
+		if got := requestRangeStart(req); got != want {
+			t.Fatalf("Range %q: got %d, want %d", header, got, want)
+		}
+	}
+}
+
+func TestHandleDownloadResumesWithTokenAfterRestart(t *testing.T) {
+	cfg := config.Get()
+	original := cfg.AlistServer
+	t.Cleanup(func() {
+		cfg.AlistServer = original
+	})
+
+	passwd := config.PasswdInfo{
+		Password: "123456",
+		EncType:  "aesctr",
+		Enable:   true,
+		EncPath:  []string{"/encrypt/*"},
+	}
+	cfg.AlistServer.PasswdList = []config.PasswdInfo{passwd}
+
+	fileSize := int64(4096)
+	plain := make([]byte, fileSize)
+	for i := range plain {
+		plain[i] = byte(i % 251)
+	}
+	ciphertext := append([]byte(nil), plain...)
+	flow, err := encryption.NewFlowEnc(passwd.Password, passwd.EncType, fileSize)
+	if err != nil {
+		t.Fatalf("create flow enc: %v", err)
+	}
+	flow.Encrypt(ciphertext)
+
+	var metadataCalls int
+	var ranges []string
+	backend := newSocketTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/fs/get", "/api/fs/link":
+			metadataCalls++
+			http.NotFound(w, r)
+		case "/d/encrypt/movie.mp4":
+			if r.Method == http.MethodGet {
+				ranges = append(ranges, r.Header.Get("Range"))
+			}
+			http.ServeContent(w, r, "movie.mp4", time.Time{}, bytes.NewReader(ciphertext))
+		default:
+			t.Fatalf("unexpected path: %s", r.URL.Path)
+		}
+	}))
+	defer backend.Close()
+
+	parsed, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatalf("parse backend url: %v", err)
+	}
+	port, err := strconv.Atoi(parsed.Port())
+	if err != nil {
+		t.Fatalf("parse port: %v", err)
+	}
+	cfg.AlistServer.ServerHost = parsed.Hostname()
+	cfg.AlistServer.ServerPort = port
+	cfg.AlistServer.HTTPS = false
+
+	newHandler := func() *ProxyHandler {
+		store, err := storage.NewStore(t.TempDir())
+		if err != nil {
+			t.Fatalf("create store: %v", err)
+		}
+		t.Cleanup(func() { _ = store.Close() })
+		fileDAO := dao.NewFileDAO(store)
+		h := NewProxyHandler(cfg, proxy.NewStreamProxy(cfg), fileDAO, dao.NewPasswdDAO(store), nil, nil)
+		t.Cleanup(h.Stop)
+		h.resumer = newResumeSigner("test-secret", time.Hour, 1)
+		return h
+	}
+
+	first := newHandler()
+	_ = first.fileDAO.Set(&dao.FileInfo{Path: "/encrypt/movie.mp4", Name: "movie.mp4", Size: fileSize})
+	rec := httptest.NewRecorder()
+	first.HandleDownload(rec, httptest.NewRequest(http.MethodGet, "/d/encrypt/movie.mp4", nil))
+	token := rec.Header().Get(resumeTokenHeader)
+	if rec.Code != http.StatusOK || token == "" {
+		t.Fatalf("status=%d token=%q", rec.Code, token)
+	}
+
+	// A restarted proxy has empty caches; the token replaces the negotiation.
+	metadataCalls = 0
+	second := newHandler()
+	req := httptest.NewRequest(http.MethodGet, "/d/encrypt/movie.mp4", nil)
+	req.Header.Set("Range", "bytes=1000-")
+	req.Header.Set(resumeTokenHeader, token)
+	rec = httptest.NewRecorder()
+	second.HandleDownload(rec, req)
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("resume status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	body, _ := io.ReadAll(rec.Body)
+	if !bytes.Equal(body, plain[1000:]) {
+		t.Fatalf("resumed body mismatch: got %d bytes", len(body))
+	}
+	if metadataCalls != 0 {
+		t.Fatalf("metadata prefetch ran %d times on resume", metadataCalls)
+	}
+	if last := ranges[len(ranges)-1]; last != "bytes=1000-" {
+		t.Fatalf("upstream range=%q", last)
+	}
+
+	// A cached size that disagrees with the token means the file changed.
+	_ = second.fileDAO.Set(&dao.FileInfo{Path: "/encrypt/movie.mp4", Size: fileSize + 1})
+	req = httptest.NewRequest(http.MethodGet, "/d/encrypt/movie.mp4?resume="+url.QueryEscape(token), nil)
+	rec = httptest.NewRecorder()
+	second.HandleDownload(rec, req)
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("changed file status=%d", rec.Code)
+	}
+}
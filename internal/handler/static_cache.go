@@ -0,0 +1,341 @@
+package handler
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/httputil"
+)
+
+const (
+	staticCacheDefaultTTL = 5 * time.Minute
+	staticCacheMaxTTL     = 24 * time.Hour
+	staticCacheFetchLimit = 30 * time.Second
+)
+
+// staticCacheExts are the frontend asset types worth keeping in memory.
+var staticCacheExts = map[string]bool{
+	".js": true, ".mjs": true, ".css": true, ".map": true,
+	".woff": true, ".woff2": true, ".ttf": true, ".otf": true, ".eot": true,
+	".svg": true, ".png": true, ".ico": true, ".webp": true,
+}
+
+// staticCachePassthroughPrefixes are never cached even with an asset
+// extension: they carry user files or API answers, not the Alist UI.
+var staticCachePassthroughPrefixes = []string{"/api/", "/d/", "/p/", "/dav/", "/enc-api/"}
+
+// staticCacheHeaders are the upstream headers replayed on a cache hit.
+var staticCacheHeaders = []string{"Content-Type", "Cache-Control", "ETag", "Last-Modified"}
+
+// staticAsset is one cached upstream response. Uncacheable answers are kept
+// as bodiless negative entries so they go straight to the proxy until stale.
+type staticAsset struct {
+	key         string
+	header      http.Header
+	body        []byte
+	modTime     time.Time
+	freshUntil  time.Time
+	uncacheable bool
+}
+
+func (a *staticAsset) size() int64 {
+	return int64(len(a.key) + len(a.body))
+}
+
+// staticAssetCache is a byte-bounded in-memory LRU of Alist frontend assets
+// fetched through the catch-all proxy. Stale entries are revalidated with
+// If-None-Match/If-Modified-Since instead of being fetched again.
+type staticAssetCache struct {
+	mu          sync.Mutex
+	maxBytes    int64
+	bytes       int64
+	order       *list.List // front = most recently used
+	entries     map[string]*list.Element
+	group       singleflight.Group
+	hits        uint64
+	misses      uint64
+	revalidated uint64
+	uncacheable uint64
+}
+
+func staticCacheMb(cfg *config.Config) int {
+	if cfg == nil {
+		return 0
+	}
+	return cfg.AlistServer.StaticCacheMb
+}
+
+func newStaticAssetCache(maxMb int) *staticAssetCache {
+	c := &staticAssetCache{
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+	c.resize(maxMb)
+	return c
+}
+
+// resize changes the capacity in place, evicting the least recently used
+// assets that no longer fit.
+func (c *staticAssetCache) resize(maxMb int) {
+	if maxMb <= 0 {
+		maxMb = 64
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxBytes = int64(maxMb) * 1024 * 1024
+	c.evictLocked()
+}
+
+// maxEntryBytes keeps one large bundle from flushing the rest of the cache.
+func (c *staticAssetCache) maxEntryBytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.maxBytes / 4
+}
+
+func (c *staticAssetCache) get(key string) *staticAsset {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*staticAsset)
+}
+
+func (c *staticAssetCache) put(asset *staticAsset) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[asset.key]; ok {
+		c.bytes -= elem.Value.(*staticAsset).size()
+		elem.Value = asset
+		c.order.MoveToFront(elem)
+	} else {
+		c.entries[asset.key] = c.order.PushFront(asset)
+	}
+	c.bytes += asset.size()
+	c.evictLocked()
+}
+
+func (c *staticAssetCache) evictLocked() {
+	for c.bytes > c.maxBytes && c.order.Len() > 1 {
+		oldest := c.order.Back()
+		evicted := oldest.Value.(*staticAsset)
+		c.order.Remove(oldest)
+		delete(c.entries, evicted.key)
+		c.bytes -= evicted.size()
+	}
+}
+
+// lookup returns a fresh entry for key, fetching or revalidating it at most
+// once across concurrent callers.
+func (c *staticAssetCache) lookup(key string, fetch func(prev *staticAsset) (asset *staticAsset, revalidated bool, err error)) (*staticAsset, error) {
+	if asset := c.get(key); asset != nil && time.Now().Before(asset.freshUntil) {
+		if !asset.uncacheable {
+			atomic.AddUint64(&c.hits, 1)
+		}
+		return asset, nil
+	}
+	v, err, _ := c.group.Do(key, func() (interface{}, error) {
+		prev := c.get(key)
+		if prev != nil && prev.uncacheable {
+			prev = nil
+		}
+		asset, revalidated, err := fetch(prev)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case asset.uncacheable:
+			atomic.AddUint64(&c.uncacheable, 1)
+		case revalidated:
+			atomic.AddUint64(&c.revalidated, 1)
+		default:
+			atomic.AddUint64(&c.misses, 1)
+		}
+		c.put(asset)
+		return asset, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*staticAsset), nil
+}
+
+// Stats reports hit/miss counters and memory use.
+func (c *staticAssetCache) Stats() map[string]interface{} {
+	c.mu.Lock()
+	entries, used := c.order.Len(), c.bytes
+	c.mu.Unlock()
+	return map[string]interface{}{
+		"entries":     entries,
+		"bytes":       used,
+		"max_bytes":   c.maxBytes,
+		"hits":        atomic.LoadUint64(&c.hits),
+		"misses":      atomic.LoadUint64(&c.misses),
+		"revalidated": atomic.LoadUint64(&c.revalidated),
+		"uncacheable": atomic.LoadUint64(&c.uncacheable),
+	}
+}
+
+// staticCacheEligible reports whether r asks for an Alist frontend asset.
+func staticCacheEligible(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	p := r.URL.Path
+	for _, prefix := range staticCachePassthroughPrefixes {
+		if strings.HasPrefix(p, prefix) {
+			return false
+		}
+	}
+	return staticCacheExts[strings.ToLower(path.Ext(p))]
+}
+
+// staticFreshness derives how long a response may be served without asking
+// upstream again. ok is false when it must not be stored at all.
+func staticFreshness(header http.Header) (ttl time.Duration, ok bool) {
+	ttl = staticCacheDefaultTTL
+	for _, directive := range strings.Split(strings.ToLower(header.Get("Cache-Control")), ",") {
+		directive = strings.TrimSpace(directive)
+		switch {
+		case directive == "no-store", directive == "private":
+			return 0, false
+		case directive == "no-cache":
+			return 0, true
+		case strings.HasPrefix(directive, "max-age="):
+			if secs, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age=")); err == nil && secs >= 0 {
+				ttl = time.Duration(secs) * time.Second
+			}
+		}
+	}
+	if ttl > staticCacheMaxTTL {
+		ttl = staticCacheMaxTTL
+	}
+	return ttl, true
+}
+
+// staticVaryOK accepts responses that vary on nothing but Accept-Encoding:
+// the transport asks for gzip itself and stores the decoded body.
+func staticVaryOK(header http.Header) bool {
+	for _, value := range header.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			field = strings.TrimSpace(field)
+			if field != "" && !strings.EqualFold(field, "Accept-Encoding") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// fetchStaticAsset loads key from upstream, revalidating prev when given;
+// revalidated is true when upstream answered 304. It deliberately sends none
+// of the client's headers: UI assets are public, and a cached copy must never
+// depend on who fetched it first.
+func (h *ProxyHandler) fetchStaticAsset(key string, prev *staticAsset) (asset *staticAsset, revalidated bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), staticCacheFetchLimit)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	if prev != nil {
+		if etag := prev.header.Get("ETag"); etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		if lastModified := prev.header.Get("Last-Modified"); lastModified != "" {
+			req.Header.Set("If-Modified-Since", lastModified)
+		}
+	}
+	resp, err := h.shortClient.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	negative := &staticAsset{key: key, freshUntil: time.Now().Add(staticCacheDefaultTTL), uncacheable: true}
+	if resp.StatusCode == http.StatusNotModified && prev != nil {
+		ttl, ok := staticFreshness(prev.header)
+		if resp.Header.Get("Cache-Control") != "" {
+			ttl, ok = staticFreshness(resp.Header)
+		}
+		if !ok {
+			return negative, false, nil
+		}
+		refreshed := *prev
+		refreshed.freshUntil = time.Now().Add(ttl)
+		return &refreshed, true, nil
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Set-Cookie") != "" ||
+		resp.Header.Get("Content-Encoding") != "" || !staticVaryOK(resp.Header) {
+		return negative, false, nil
+	}
+	ttl, ok := staticFreshness(resp.Header)
+	if !ok {
+		return negative, false, nil
+	}
+	limit := h.staticCache.maxEntryBytes()
+	if resp.ContentLength > limit {
+		return negative, false, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, false, err
+	}
+	if int64(len(body)) > limit {
+		return negative, false, nil
+	}
+	if body == nil {
+		body = []byte{}
+	}
+	header := make(http.Header)
+	for _, name := range staticCacheHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			header.Set(name, value)
+		}
+	}
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &staticAsset{key: key, header: header, body: body, modTime: modTime, freshUntil: time.Now().Add(ttl)}, false, nil
+}
+
+// serveStaticAsset answers r from the static asset cache. It returns false
+// when the request is not cacheable so the caller proxies it as usual.
+func (h *ProxyHandler) serveStaticAsset(w http.ResponseWriter, r *http.Request) bool {
+	if h.staticCache == nil || h.cfg == nil || !h.cfg.AlistServer.EnableStaticCache || !staticCacheEligible(r) {
+		return false
+	}
+	key := httputil.BuildTargetURL(h.cfg.GetAlistURL(), r.URL.Path, r)
+	asset, err := h.staticCache.lookup(key, func(prev *staticAsset) (*staticAsset, bool, error) {
+		return h.fetchStaticAsset(key, prev)
+	})
+	if err != nil || asset.uncacheable {
+		return false
+	}
+
+	for name, values := range asset.header {
+		w.Header()[name] = append([]string(nil), values...)
+	}
+	body := asset.body
+	if shouldRewriteTextResponse(asset.header.Get("Content-Type")) {
+		if rewritten := rewriteUpstreamTextBody(r, h.cfg.GetAlistURL(), body); !bytes.Equal(rewritten, body) {
+			body = rewritten
+			w.Header().Del("ETag")
+		}
+	}
+	http.ServeContent(w, r, "", asset.modTime, bytes.NewReader(body))
+	return true
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+func newStaticCacheTestHandler(t *testing.T, upstream http.HandlerFunc) *ProxyHandler {
+	t.Helper()
+	srv := httptest.NewServer(upstream)
+	t.Cleanup(srv.Close)
+	parsed, _ := url.Parse(srv.URL)
+	port, _ := strconv.Atoi(parsed.Port())
+	cfg := config.DefaultConfig()
+	cfg.AlistServer.ServerHost = parsed.Hostname()
+	cfg.AlistServer.ServerPort = port
+	cfg.AlistServer.EnableStaticCache = true
+	return newTestProxyHandler(t, cfg)
+}
+
+func getStatic(h *ProxyHandler, target string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.HandleProxy(rec, req)
+	return rec
+}
+
+func TestStaticCacheServesRepeatedAssetsFromMemory(t *testing.T) {
+	var fetches atomic.Int32
+	h := newStaticCacheTestHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Content-Type", "application/javascript")
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("console.log('alist')"))
+	})
+
+	for i := 0; i < 3; i++ {
+		if rec := getStatic(h, "/assets/index.js", nil); rec.Code != http.StatusOK || rec.Body.String() != "console.log('alist')" {
+			t.Fatalf("request %d: status=%d body=%q", i, rec.Code, rec.Body.String())
+		}
+	}
+	if rec := getStatic(h, "/assets/index.js", map[string]string{"If-None-Match": `"v1"`}); rec.Code != http.StatusNotModified {
+		t.Fatalf("conditional hit status=%d", rec.Code)
+	}
+	if rec := getStatic(h, "/assets/index.js", map[string]string{"Range": "bytes=0-6"}); rec.Code != http.StatusPartialContent || rec.Body.String() != "console" {
+		t.Fatalf("range hit status=%d body=%q", rec.Code, rec.Body.String())
+	}
+	if got := fetches.Load(); got != 1 {
+		t.Fatalf("upstream fetched %d times, want 1", got)
+	}
+}
+
+func TestStaticCacheRevalidatesStaleEntries(t *testing.T) {
+	var fetches, notModified atomic.Int32
+	h := newStaticCacheTestHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "text/css")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("body{color:red}"))
+	})
+
+	for i := 0; i < 2; i++ {
+		if rec := getStatic(h, "/assets/app.css", nil); rec.Body.String() != "body{color:red}" {
+			t.Fatalf("request %d body=%q", i, rec.Body.String())
+		}
+	}
+	if fetches.Load() != 2 || notModified.Load() != 1 {
+		t.Fatalf("fetches=%d notModified=%d", fetches.Load(), notModified.Load())
+	}
+	if stats := h.staticCache.Stats(); stats["revalidated"] != uint64(1) || stats["misses"] != uint64(1) {
+		t.Fatalf("stats=%v", stats)
+	}
+}
+
+func TestStaticCacheSkipsUncacheableResponses(t *testing.T) {
+	var fetches atomic.Int32
+	h := newStaticCacheTestHandler(t, func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Content-Type", "application/javascript")
+		w.Header().Set("Cache-Control", "no-store")
+		_, _ = w.Write([]byte("dynamic"))
+	})
+
+	for i := 0; i < 2; i++ {
+		if rec := getStatic(h, "/assets/config.js", nil); rec.Body.String() != "dynamic" {
+			t.Fatalf("request %d body=%q", i, rec.Body.String())
+		}
+	}
+	// The first request probes and proxies; afterwards the negative entry
+	// sends requests straight through the proxy.
+	if got := fetches.Load(); got != 3 {
+		t.Fatalf("upstream fetched %d times, want 3", got)
+	}
+	if stats := h.staticCache.Stats(); stats["hits"] != uint64(0) || stats["uncacheable"] != uint64(1) {
+		t.Fatalf("stats=%v", stats)
+	}
+
+	if rec := getStatic(h, "/d/movie.js", nil); rec.Body.String() != "dynamic" || fetches.Load() != 4 {
+		t.Fatalf("/d path was cached: fetches=%d", fetches.Load())
+	}
+}
@@ -6,7 +6,9 @@ import (
 
 	"github.com/alist-encrypt-go/internal/config"
 	"github.com/alist-encrypt-go/internal/dao"
+	"github.com/alist-encrypt-go/internal/encryption"
 	"github.com/alist-encrypt-go/internal/proxy"
+	"github.com/alist-encrypt-go/internal/workers"
 )
 
 // StatsHandler provides runtime stats for caches and resolver behavior
@@ -17,6 +19,10 @@ type StatsHandler struct {
 	proxyHandler  *ProxyHandler
 	webdavHandler *WebDAVHandler
 	streamProxy   *proxy.StreamProxy
+	images        *ImageResizer
+	readVerifier  *ReadVerifier
+	userDAO       *dao.UserDAO
+	rateLimit     func() map[string]interface{}
 	startTime     time.Time
 }
 
@@ -33,6 +39,26 @@ func NewStatsHandler(cfg *config.Config, fileDAO *dao.FileDAO, alistHandler *Ali
 	}
 }
 
+// SetImageResizer adds /img variant cache counters to the stats.
+func (h *StatsHandler) SetImageResizer(images *ImageResizer) {
+	h.images = images
+}
+
+// SetReadVerifier adds read verification counters to the stats.
+func (h *StatsHandler) SetReadVerifier(verifier *ReadVerifier) {
+	h.readVerifier = verifier
+}
+
+// SetUserDAO adds the count of accounts still on legacy password hashes.
+func (h *StatsHandler) SetUserDAO(userDAO *dao.UserDAO) {
+	h.userDAO = userDAO
+}
+
+// SetRateLimitStats adds the rate_limit counters reported by stats.
+func (h *StatsHandler) SetRateLimitStats(stats func() map[string]interface{}) {
+	h.rateLimit = stats
+}
+
 // HandleStats returns runtime stats
 func (h *StatsHandler) HandleStats(w http.ResponseWriter, r *http.Request) {
 	proxyStats := h.proxyHandler.Stats()
@@ -55,6 +81,8 @@ func (h *StatsHandler) HandleStats(w http.ResponseWriter, r *http.Request) {
 		"meta": map[string]interface{}{
 			"cleanup_disabled": h.cfg != nil && h.cfg.Database != nil && h.cfg.Database.DisableCleanup,
 		},
+		"workers":         workers.Snapshot(),
+		"storage_budgets": workers.BudgetSnapshot(),
 		"stream": map[string]interface{}{
 			"play_first_fallback":     h.cfg != nil && h.cfg.AlistServer.PlayFirstFallback,
 			"final_passthrough_count": proxyStream["final_passthrough_count"] + webdavStream["final_passthrough_count"],
@@ -67,22 +95,51 @@ func (h *StatsHandler) HandleStats(w http.ResponseWriter, r *http.Request) {
 			"provider_strategy":       selectorStats["provider_strategy"],
 			"recent_strategy_events":  selectorStats["recent_events"],
 			"limit":                   streamLimitStats,
+			"bandwidth":               h.streamProxy.BandwidthStats(),
+			"download_pipeline":       h.streamProxy.DownloadPipelineStats(),
 		},
 		"cache": map[string]interface{}{
 			"path_cache":            h.fileDAO.PathCacheStats(),
 			"file_size_cache":       h.fileDAO.FileSizeCacheStats(),
 			"decrypted_block_cache": h.streamProxy.DecryptedBlockCacheStats(),
+			"media_index_cache":     h.streamProxy.MediaIndexStats(),
+			"image_resize_cache": func() map[string]interface{} {
+				if h.images != nil {
+					return h.images.Stats()
+				}
+				return nil
+			}(),
 		},
 		"alist":              alistStats,
 		"proxy":              proxyStats,
 		"webdav":             webdavStats,
 		"range_compat_cache": h.streamProxy.RangeCompatStats(),
 		"probe_scheduler":    getProbeSchedulerStats(proxyStats, webdavStats),
+		"cipher":             encryption.CPUFeatures(),
+		"read_verify":        h.readVerifier.Stats(),
+		"users":              h.userStats(),
+		"rate_limit": func() map[string]interface{} {
+			if h.rateLimit != nil {
+				return h.rateLimit()
+			}
+			return nil
+		}(),
 	}
 
 	RespondSuccess(w, data)
 }
 
+func (h *StatsHandler) userStats() map[string]interface{} {
+	if h.userDAO == nil {
+		return nil
+	}
+	legacy, err := h.userDAO.CountLegacyHashes()
+	if err != nil {
+		return nil
+	}
+	return map[string]interface{}{"legacy_password_hashes": legacy}
+}
+
 func getSelectorStats(stats ...map[string]interface{}) map[string]interface{} {
 	for _, item := range stats {
 		if selector, ok := item["strategy_selector"].(map[string]interface{}); ok && selector != nil {
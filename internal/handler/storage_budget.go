@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/workers"
+)
+
+// storageBudgetWait is how long a client request may queue for its
+// storage's budget before it is turned away; background jobs wait as long
+// as they need.
+const storageBudgetWait = 15 * time.Second
+
+// waitStorageRequest charges one upstream request against the budget of the
+// storage holding p.
+func waitStorageRequest(ctx context.Context, p string) error {
+	return workers.BudgetFor(p).WaitRequest(ctx)
+}
+
+// acquireStorageStream takes a stream slot on the storage holding p.
+func acquireStorageStream(ctx context.Context, p string) (func(), error) {
+	return workers.BudgetFor(p).AcquireStream(ctx)
+}
+
+// acquireClientStorageStream is acquireStorageStream for a client download:
+// it waits at most storageBudgetWait and answers 429 itself on failure.
+func acquireClientStorageStream(w http.ResponseWriter, r *http.Request, p string) (func(), bool) {
+	budget := workers.BudgetFor(p)
+	if budget == nil {
+		return func() {}, true
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), storageBudgetWait)
+	defer cancel()
+	release, err := budget.AcquireStream(ctx)
+	if err != nil {
+		w.Header().Set("Retry-After", "5")
+		RespondHTTPErrorWithStatus(w, "storage stream budget exhausted", http.StatusTooManyRequests)
+		return nil, false
+	}
+	return release, true
+}
+
+// AdmitStorageRequest charges a client request against the budget of the
+// storage holding p, waiting at most storageBudgetWait. It reports false
+// after answering 429 (as an Alist JSON error for API calls).
+func AdmitStorageRequest(w http.ResponseWriter, r *http.Request, p string, api bool) bool {
+	budget := workers.BudgetFor(p)
+	if budget == nil {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), storageBudgetWait)
+	defer cancel()
+	if budget.WaitRequest(ctx) == nil {
+		return true
+	}
+	w.Header().Set("Retry-After", "5")
+	if api {
+		RespondAPIError(w, http.StatusTooManyRequests, "storage request budget exhausted")
+	} else {
+		RespondHTTPErrorWithStatus(w, "storage request budget exhausted", http.StatusTooManyRequests)
+	}
+	return false
+}
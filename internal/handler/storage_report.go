@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/pathutil"
+)
+
+const (
+	storageReportDefaultTop = 20
+	storageReportMaxTop     = 500
+)
+
+// StorageReportExt is the file count and stored size of one extension.
+type StorageReportExt struct {
+	Ext   string `json:"ext"`
+	Count int    `json:"count"`
+	Bytes int64  `json:"bytes"`
+}
+
+// StorageReportFile is one entry of the largest-files list.
+type StorageReportFile struct {
+	Path          string `json:"path"`
+	EncryptedPath string `json:"encrypted_path"`
+	Size          int64  `json:"size"`
+	Modified      string `json:"modified"`
+}
+
+// StorageReportRoot summarizes everything under one encrypted path. Sizes
+// are as stored on the drive (ciphertext, headers included).
+type StorageReportRoot struct {
+	Path       string              `json:"path"`
+	Files      int                 `json:"files"`
+	Dirs       int                 `json:"dirs"`
+	Bytes      int64               `json:"bytes"`
+	Extensions []StorageReportExt  `json:"extensions"`
+	Largest    []StorageReportFile `json:"largest"`
+	Truncated  bool                `json:"truncated"`
+	Error      string              `json:"error,omitempty"`
+}
+
+// HandleStorageReport serves /enc-api/reports/storage[?path=...&top=N] with
+// per-extension counts and the top N largest files of each encrypted path
+// (or only of path), walked like /enc-api/inventory. It helps decide what
+// to re-encode or archive.
+func (h *AlistHandler) HandleStorageReport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	top := storageReportDefaultTop
+	if v := query.Get("top"); v != "" {
+		if parsed, err := strconv.Atoi(v); err == nil && parsed > 0 {
+			top = clampInt(parsed, 1, storageReportMaxTop)
+		}
+	}
+	var roots []string
+	if root := strings.TrimSpace(query.Get("path")); root != "" {
+		roots = []string{pathutil.CleanInput(root)}
+	} else {
+		roots = h.collectEncryptedSearchRoots()
+	}
+
+	auth := make(http.Header)
+	if token := strings.TrimSpace(r.Header.Get("X-Alist-Token")); token != "" {
+		auth.Set("Authorization", token)
+	} else {
+		auth = h.scanAuthHeaders()
+	}
+
+	reports := make([]StorageReportRoot, 0, len(roots))
+	for _, root := range roots {
+		if r.Context().Err() != nil {
+			break
+		}
+		items, truncated, err := h.collectInventory(r.Context(), root, inventoryMaxDepth, auth)
+		if err != nil {
+			log.Warn().Err(err).Str("path", root).Msg("Storage report walk failed")
+			reports = append(reports, StorageReportRoot{Path: root, Error: err.Error()})
+			continue
+		}
+		reports = append(reports, summarizeStorage(root, items, truncated, top))
+	}
+	RespondSuccess(w, map[string]interface{}{
+		"top":   top,
+		"roots": reports,
+	})
+}
+
+// summarizeStorage builds the report of one root from its inventory.
+// Extensions are ordered by stored size, largest first.
+func summarizeStorage(root string, items []InventoryItem, truncated bool, top int) StorageReportRoot {
+	report := StorageReportRoot{Path: root, Truncated: truncated}
+	byExt := make(map[string]*StorageReportExt)
+	var files []InventoryItem
+	for _, it := range items {
+		if it.IsDir {
+			report.Dirs++
+			continue
+		}
+		report.Files++
+		report.Bytes += it.Size
+		ext := strings.ToLower(path.Ext(it.Name))
+		if ext == "" || strings.HasPrefix(it.Name, ext) {
+			ext = "(none)"
+		}
+		stat := byExt[ext]
+		if stat == nil {
+			stat = &StorageReportExt{Ext: ext}
+			byExt[ext] = stat
+		}
+		stat.Count++
+		stat.Bytes += it.Size
+		files = append(files, it)
+	}
+
+	report.Extensions = make([]StorageReportExt, 0, len(byExt))
+	for _, stat := range byExt {
+		report.Extensions = append(report.Extensions, *stat)
+	}
+	sort.Slice(report.Extensions, func(i, j int) bool {
+		a, b := report.Extensions[i], report.Extensions[j]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return a.Ext < b.Ext
+	})
+
+	sort.SliceStable(files, func(i, j int) bool { return files[i].Size > files[j].Size })
+	if len(files) > top {
+		files = files[:top]
+	}
+	report.Largest = make([]StorageReportFile, 0, len(files))
+	for _, it := range files {
+		report.Largest = append(report.Largest, StorageReportFile{
+			Path:          it.Path,
+			EncryptedPath: it.EncryptedPath,
+			Size:          it.Size,
+			Modified:      it.Modified,
+		})
+	}
+	return report
+}
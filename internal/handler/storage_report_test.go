@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/encryption"
+)
+
+func TestSummarizeStorage(t *testing.T) {
+	items := []InventoryItem{
+		{Path: "/vault/a", Name: "a", IsDir: true},
+		{Path: "/vault/a/x.MKV", Name: "x.MKV", Size: 300},
+		{Path: "/vault/a/y.mkv", Name: "y.mkv", Size: 500},
+		{Path: "/vault/b.jpg", Name: "b.jpg", Size: 100},
+		{Path: "/vault/.hidden", Name: ".hidden", Size: 10},
+		{Path: "/vault/README", Name: "README", Size: 20},
+	}
+	report := summarizeStorage("/vault", items, true, 2)
+	if report.Files != 5 || report.Dirs != 1 || report.Bytes != 930 || !report.Truncated {
+		t.Fatalf("report=%+v", report)
+	}
+	want := []StorageReportExt{
+		{Ext: ".mkv", Count: 2, Bytes: 800},
+		{Ext: ".jpg", Count: 1, Bytes: 100},
+		{Ext: "(none)", Count: 2, Bytes: 30},
+	}
+	if len(report.Extensions) != len(want) {
+		t.Fatalf("extensions=%+v", report.Extensions)
+	}
+	for i := range want {
+		if report.Extensions[i] != want[i] {
+			t.Fatalf("extensions[%d]=%+v want %+v", i, report.Extensions[i], want[i])
+		}
+	}
+	if len(report.Largest) != 2 || report.Largest[0].Path != "/vault/a/y.mkv" || report.Largest[1].Path != "/vault/a/x.MKV" {
+		t.Fatalf("largest=%+v", report.Largest)
+	}
+}
+
+func TestHandleStorageReportWalksEncryptedRoots(t *testing.T) {
+	passwd := &config.PasswdInfo{
+		Password: "testpass",
+		EncType:  "aesctr",
+		Enable:   true,
+		EncName:  true,
+		EncPath:  []string{"/vault/*"},
+	}
+	converter := encryption.NewFileNameConverter(passwd.Password, passwd.EncType, passwd.EncSuffix)
+	bigRaw := converter.ToRealName("movie.mkv")
+	smallRaw := converter.ToRealName("cover.jpg")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/fs/list", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		var req struct {
+			Path string `json:"path"`
+		}
+		_ = json.Unmarshal(body, &req)
+		var content []interface{}
+		if req.Path == "/vault" {
+			content = []interface{}{
+				map[string]interface{}{"name": bigRaw, "is_dir": false, "size": float64(8192), "modified": "2026-01-02T03:04:05Z"},
+				map[string]interface{}{"name": smallRaw, "is_dir": false, "size": float64(64), "modified": "2026-01-02T03:04:06Z"},
+			}
+		} else {
+			t.Errorf("unexpected list path %q", req.Path)
+		}
+		writeJSONResponse(w, map[string]interface{}{
+			"code":    200,
+			"message": "success",
+			"data":    map[string]interface{}{"content": content, "total": float64(len(content))},
+		})
+	})
+	srv := newSocketTestServer(t, mux)
+	defer srv.Close()
+	handler, _ := newTestAlistHandler(t, srv.URL, passwd)
+
+	req := httptest.NewRequest(http.MethodGet, "/enc-api/reports/storage?top=1", nil)
+	req.Header.Set("X-Alist-Token", "alist-token")
+	rec := httptest.NewRecorder()
+	handler.HandleStorageReport(rec, req)
+
+	var resp struct {
+		Code int `json:"code"`
+		Data struct {
+			Top   int                 `json:"top"`
+			Roots []StorageReportRoot `json:"roots"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode: %v body=%s", err, rec.Body.String())
+	}
+	if resp.Data.Top != 1 || len(resp.Data.Roots) != 1 {
+		t.Fatalf("body=%s", rec.Body.String())
+	}
+	root := resp.Data.Roots[0]
+	if root.Path != "/vault" || root.Files != 2 || root.Bytes != 8256 || len(root.Extensions) != 2 {
+		t.Fatalf("root=%+v", root)
+	}
+	if len(root.Largest) != 1 || root.Largest[0].Path != "/vault/movie.mkv" || root.Largest[0].EncryptedPath != "/vault/"+bigRaw {
+		t.Fatalf("largest=%+v", root.Largest)
+	}
+}
@@ -3,6 +3,8 @@ package handler
 import (
 	"sync"
 	"time"
+
+	"github.com/alist-encrypt-go/internal/pathutil"
 )
 
 // StrategyType represents the type of file size retrieval strategy
@@ -140,6 +142,18 @@ func (sc *StrategyCache) Invalidate(dirPath string) {
 	delete(sc.strategies, dirPath)
 }
 
+// InvalidateUnder removes the strategies for dir and every directory below
+// it, after dir was removed or renamed.
+func (sc *StrategyCache) InvalidateUnder(dir string) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for p := range sc.strategies {
+		if pathutil.Within(p, dir) {
+			delete(sc.strategies, p)
+		}
+	}
+}
+
 // evictOldest removes the oldest strategy entry (LRU)
 func (sc *StrategyCache) evictOldest() {
 	var oldestPath string
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/dao"
+	"github.com/alist-encrypt-go/internal/errors"
+	"github.com/alist-encrypt-go/internal/pathutil"
+)
+
+// strictPlaintextRule returns the strict passwd rule that storing targetPath
+// unencrypted would violate. srcPath is where the content comes from for
+// moves and copies ("" for new data); content already encrypted with the same
+// key is allowed in.
+func strictPlaintextRule(passwdDAO *dao.PasswdDAO, srcPath, targetPath string) (*config.PasswdInfo, bool) {
+	if passwdDAO == nil {
+		return nil, false
+	}
+	dest, ok := passwdDAO.PathFindPasswd(targetPath)
+	if !ok || dest == nil || !dest.Strict {
+		return nil, false
+	}
+	if srcPath != "" {
+		if src, ok := passwdDAO.PathFindPasswd(srcPath); ok && src != nil &&
+			src.Password == dest.Password && src.EncType == dest.EncType {
+			return nil, false
+		}
+	}
+	return dest, true
+}
+
+func strictPlaintextMessage(targetPath, via string) string {
+	return fmt.Sprintf("%s is in a strict encrypted folder; refusing to store plaintext via %s. Upload through /api/fs/put or WebDAV so the proxy can encrypt it.", targetPath, via)
+}
+
+// respondAlistError writes an Alist-style JSON error with a matching HTTP
+// status and stable error code.
+func respondAlistError(w http.ResponseWriter, status int, code errors.Code, message string) {
+	w.Header().Set(errors.HeaderErrorCode, string(code))
+	RespondJSON(w, status, map[string]interface{}{
+		"code":       status,
+		"error_code": code,
+		"message":    message,
+		"data":       nil,
+	})
+}
+
+// rejectStrictPlaintextWrite guards Alist write routes the proxy does not
+// encrypt itself (form uploads, offline downloads) and reports whether it
+// already answered the request.
+func (h *ProxyHandler) rejectStrictPlaintextWrite(w http.ResponseWriter, r *http.Request) bool {
+	var targets []string
+	switch r.URL.Path {
+	case "/api/fs/form":
+		target, _ := pathutil.UnescapeFilePath(r.Header.Get("File-Path"))
+		targets = append(targets, target)
+	case "/api/fs/add_offline_download", "/api/fs/add_aria2", "/api/fs/add_qbit", "/api/fs/add_transmission":
+		body, err := readLimitedRequestBody(r)
+		if err != nil {
+			RespondHTTPErrorWithStatus(w, "Failed to read request", http.StatusBadRequest)
+			return true
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		var req struct {
+			Path string   `json:"path"`
+			URLs []string `json:"urls"`
+		}
+		if json.Unmarshal(body, &req) != nil {
+			return false
+		}
+		for _, u := range req.URLs {
+			name := path.Base(strings.SplitN(u, "?", 2)[0])
+			targets = append(targets, path.Join(req.Path, name))
+		}
+		if len(targets) == 0 {
+			targets = append(targets, path.Join(req.Path, "-"))
+		}
+	default:
+		return false
+	}
+	for _, target := range targets {
+		if target == "" {
+			continue
+		}
+		if _, violated := strictPlaintextRule(h.passwdDAO, "", target); violated {
+			log.Warn().Str("path", target).Str("route", r.URL.Path).Msg("Rejected plaintext write into strict encrypted folder")
+			respondAlistError(w, http.StatusForbidden, errors.CodeStrictPlaintextWrite, strictPlaintextMessage(target, r.URL.Path))
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+func TestStrictFolderRejectsPlaintextWrites(t *testing.T) {
+	passwd := &config.PasswdInfo{
+		Password: "testpass",
+		EncType:  "aesctr",
+		Enable:   true,
+		EncName:  true,
+		EncPath:  []string{"/vault/*"},
+		Strict:   true,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("unexpected upstream request %s %s", r.Method, r.URL.Path)
+	})
+	srv := newSocketTestServer(t, mux)
+	defer srv.Close()
+
+	handler, _ := newTestAlistHandler(t, srv.URL, passwd)
+
+	form := httptest.NewRequest(http.MethodPut, "/api/fs/form", strings.NewReader("plain"))
+	form.Header.Set("File-Path", url.QueryEscape("/vault/movie.mkv"))
+	rec := httptest.NewRecorder()
+	handler.proxyHandler.HandleProxy(rec, form)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "strict encrypted folder") {
+		t.Fatalf("form upload status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if got := rec.Header().Get("X-Enc-Error"); got != "STRICT_PLAINTEXT_WRITE" {
+		t.Fatalf("X-Enc-Error=%q", got)
+	}
+	if !strings.Contains(rec.Body.String(), `"error_code":"STRICT_PLAINTEXT_WRITE"`) {
+		t.Fatalf("body missing error_code: %s", rec.Body.String())
+	}
+
+	offline := httptest.NewRequest(http.MethodPost, "/api/fs/add_offline_download", strings.NewReader(`{"path":"/vault","urls":["https://example.invalid/a.iso"]}`))
+	rec = httptest.NewRecorder()
+	handler.proxyHandler.HandleProxy(rec, offline)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("offline download status=%d body=%s", rec.Code, rec.Body.String())
+	}
+
+	move := httptest.NewRequest(http.MethodPost, "/api/fs/move", strings.NewReader(`{"src_dir":"/plain","dst_dir":"/vault","names":["a.mp4"]}`))
+	rec = httptest.NewRecorder()
+	handler.HandleFsMove(rec, move)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("move status=%d body=%s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestStrictPlaintextRuleAllowsSameKeyMoves(t *testing.T) {
+	passwd := &config.PasswdInfo{
+		Password: "testpass",
+		EncType:  "aesctr",
+		Enable:   true,
+		EncPath:  []string{"/vault/*"},
+		Strict:   true,
+	}
+	handler, _ := newTestAlistHandler(t, "http://127.0.0.1:1", passwd)
+
+	if _, violated := strictPlaintextRule(handler.passwdDAO, "/vault/a/x.mp4", "/vault/b/x.mp4"); violated {
+		t.Fatal("move within the same strict folder must be allowed")
+	}
+	if _, violated := strictPlaintextRule(handler.passwdDAO, "/plain/x.mp4", "/vault/x.mp4"); !violated {
+		t.Fatal("move from plain folder into strict folder must be rejected")
+	}
+	if _, violated := strictPlaintextRule(handler.passwdDAO, "", "/other/x.mp4"); violated {
+		t.Fatal("writes outside encrypted folders are not affected")
+	}
+}
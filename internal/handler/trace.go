@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/trace"
+)
+
+// TraceHandler serves the per-request traces kept in memory by trace.Log.
+type TraceHandler struct {
+	audit *AuditLog
+}
+
+// NewTraceHandler creates a trace handler; audit entries recorded for the
+// same request are returned alongside the trace when audit is non-nil.
+func NewTraceHandler(audit *AuditLog) *TraceHandler {
+	return &TraceHandler{audit: audit}
+}
+
+// HandleTrace serves /enc-api/trace/<request id>, the ID every response
+// carries in X-Request-ID: the request's buffered trace entries, oldest
+// first, and the audit entries (uploads, renames, jobs ...) it produced.
+func (h *TraceHandler) HandleTrace(w http.ResponseWriter, r *http.Request) {
+	reqID := strings.Trim(strings.TrimPrefix(r.URL.Path, "/enc-api/trace"), "/")
+	if reqID == "" {
+		RespondAPIError(w, 400, "Invalid request: missing request ID")
+		return
+	}
+	entries := trace.Lookup(reqID)
+	if len(entries) == 0 {
+		RespondAPIError(w, 404, "No trace kept for "+reqID+"; it may have been overwritten")
+		return
+	}
+	audit := []AuditEntry{}
+	if h.audit != nil {
+		// Audit entries are written when the request ends.
+		found, err := h.audit.Query(AuditFilter{
+			RequestID: reqID,
+			Since:     entries[0].Time.Add(-time.Second),
+			Limit:     50,
+		})
+		if err == nil {
+			audit = found
+		}
+	}
+	RespondSuccess(w, map[string]interface{}{
+		"request_id": reqID,
+		"entries":    entries,
+		"audit":      audit,
+	})
+}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/trace"
+)
+
+func TestHandleTraceReturnsEntriesAndAudit(t *testing.T) {
+	audit, _ := newTestAuditLog(t)
+	req := httptest.NewRequest(http.MethodPut, "/dav/movies/a.mkv", nil)
+	req = req.WithContext(trace.WithRequestID(req.Context(), "req-abc123"))
+	trace.Logf(req.Context(), "webdav-put", "encrypting %s", "a.mkv")
+	audit.RecordRequest(req, "webdav.put", "/movies/a.mkv", "")
+	audit.RecordRequest(httptest.NewRequest(http.MethodPut, "/dav/other", nil), "webdav.put", "/other", "")
+
+	rr := httptest.NewRecorder()
+	NewTraceHandler(audit).HandleTrace(rr, httptest.NewRequest(http.MethodGet, "/enc-api/trace/req-abc123", nil))
+	var body struct {
+		Data struct {
+			Entries []trace.Entry `json:"entries"`
+			Audit   []AuditEntry  `json:"audit"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode %q: %v", rr.Body.String(), err)
+	}
+	if len(body.Data.Entries) != 1 || body.Data.Entries[0].Message != "encrypting a.mkv" {
+		t.Fatalf("entries=%+v", body.Data.Entries)
+	}
+	if len(body.Data.Audit) != 1 || body.Data.Audit[0].Path != "/movies/a.mkv" {
+		t.Fatalf("audit=%+v", body.Data.Audit)
+	}
+
+	rr = httptest.NewRecorder()
+	NewTraceHandler(audit).HandleTrace(rr, httptest.NewRequest(http.MethodGet, "/enc-api/trace/req-gone00", nil))
+	var missing struct {
+		Code int `json:"code"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &missing); err != nil || missing.Code != 404 {
+		t.Fatalf("unknown request: %q", rr.Body.String())
+	}
+}
@@ -0,0 +1,289 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/proxy"
+	"github.com/alist-encrypt-go/internal/storage"
+)
+
+const (
+	// uploadJournalFlushInterval bounds how often the bytes written by a
+	// running upload are persisted.
+	uploadJournalFlushInterval = 2 * time.Second
+	uploadRecoveryInterval     = time.Hour
+)
+
+// Outcomes of recovering one journal entry.
+const (
+	uploadRecoveryCompleted = "completed" // staged object was whole, renamed into place
+	uploadRecoveryRemoved   = "removed"   // partial or superseded staged object deleted
+	uploadRecoveryGone      = "gone"      // nothing left on the remote
+	uploadRecoveryFailed    = "failed"    // Alist unreachable or refused, retried next run
+)
+
+// UploadJournalEntry is the write-ahead record of one staged upload, kept
+// from before the first byte is sent until the staged object is renamed or
+// removed. Entries written by another process instance were left behind by
+// a crash or restart.
+type UploadJournalEntry struct {
+	StagingPath string    `json:"staging_path"`
+	FinalPath   string    `json:"final_path"`
+	DisplayPath string    `json:"display_path,omitempty"`
+	PlainSize   int64     `json:"plain_size"`
+	StagedSize  int64     `json:"staged_size"`
+	Written     int64     `json:"written"`
+	Instance    string    `json:"instance"`
+	StartedAt   time.Time `json:"started_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Orphaned reports whether the entry belongs to an earlier process.
+func (e *UploadJournalEntry) Orphaned() bool {
+	return e.Instance != proxy.InstanceID()
+}
+
+// UploadJournal persists in-progress staged uploads in BoltDB
+// ("uploadjournal", staging path → UploadJournalEntry). A nil journal
+// records nothing.
+type UploadJournal struct {
+	store *storage.Store
+}
+
+// NewUploadJournal creates a journal persisting into store.
+func NewUploadJournal(store *storage.Store) *UploadJournal {
+	if store == nil {
+		return nil
+	}
+	return &UploadJournal{store: store}
+}
+
+// Begin records an upload about to be sent to entry.StagingPath.
+func (j *UploadJournal) Begin(entry UploadJournalEntry) {
+	if j == nil {
+		return
+	}
+	now := time.Now()
+	entry.Instance = proxy.InstanceID()
+	entry.StartedAt, entry.UpdatedAt = now, now
+	if err := j.store.SetJSON(storage.BucketUploads, entry.StagingPath, &entry); err != nil {
+		log.Warn().Err(err).Str("path", entry.StagingPath).Msg("Failed to journal upload")
+	}
+}
+
+// Progress records the plaintext bytes sent so far.
+func (j *UploadJournal) Progress(stagingPath string, written int64) {
+	if j == nil {
+		return
+	}
+	err := j.store.UpdateBucket(storage.BucketUploads, func(tx *storage.BucketTx) error {
+		var entry UploadJournalEntry
+		if err := tx.GetJSON(stagingPath, &entry); err != nil || entry.StagingPath == "" {
+			return err
+		}
+		entry.Written = written
+		entry.UpdatedAt = time.Now()
+		return tx.SetJSON(stagingPath, &entry)
+	})
+	if err != nil {
+		log.Debug().Err(err).Str("path", stagingPath).Msg("Failed to journal upload progress")
+	}
+}
+
+// Finish drops the entry once the staged object is committed or removed.
+func (j *UploadJournal) Finish(stagingPath string) {
+	if j == nil {
+		return
+	}
+	if err := j.store.Delete(storage.BucketUploads, stagingPath); err != nil {
+		log.Warn().Err(err).Str("path", stagingPath).Msg("Failed to clear upload journal entry")
+	}
+}
+
+// Entries returns all journaled uploads, oldest first.
+func (j *UploadJournal) Entries() ([]UploadJournalEntry, error) {
+	if j == nil {
+		return nil, nil
+	}
+	all, err := j.store.GetAll(storage.BucketUploads)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]UploadJournalEntry, 0, len(all))
+	for _, value := range all {
+		var entry UploadJournalEntry
+		if json.Unmarshal(value, &entry) == nil && entry.StagingPath != "" {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].StartedAt.Before(entries[b].StartedAt) })
+	return entries, nil
+}
+
+// journalReader persists the bytes read from an upload body at most every
+// uploadJournalFlushInterval.
+type journalReader struct {
+	io.ReadCloser
+	journal     *UploadJournal
+	stagingPath string
+	n           int64
+	flushed     time.Time
+}
+
+func (r *journalReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	if now := time.Now(); now.Sub(r.flushed) >= uploadJournalFlushInterval || err == io.EOF {
+		r.flushed = now
+		r.journal.Progress(r.stagingPath, r.n)
+	}
+	return n, err
+}
+
+// UploadRecoveryResult is what the recovery job did with one orphaned entry.
+type UploadRecoveryResult struct {
+	UploadJournalEntry
+	Outcome string `json:"outcome"`
+	Error   string `json:"error,omitempty"`
+}
+
+// SetUploadJournal journals staged uploads into journal.
+func (h *AlistHandler) SetUploadJournal(journal *UploadJournal) {
+	h.uploadJournal = journal
+}
+
+// StartUploadRecovery runs RecoverUploads shortly after startup and then
+// hourly, so staged objects left by a crash are finished or cleaned up even
+// if Alist was unreachable at first.
+func (h *AlistHandler) StartUploadRecovery(ctx context.Context) {
+	if h == nil || h.uploadJournal == nil {
+		return
+	}
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Error().Interface("panic", r).Msg("Upload recovery panicked")
+			}
+		}()
+		timer := time.NewTimer(time.Minute)
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-timer.C:
+			}
+			if h.maintenance.Wait(ctx, jobUploadRecovery) != nil {
+				return
+			}
+			h.RecoverUploads(ctx)
+			timer.Reset(uploadRecoveryInterval)
+		}
+	}()
+}
+
+// RecoverUploads settles the journal entries of earlier processes. A staged
+// object that reached its full size was only missing the final rename and
+// is renamed into place, unless a newer file took its name meanwhile.
+// Alist offers no way to append to an object, so anything shorter is
+// removed and must be uploaded again. Entries are kept for the next run
+// when Alist cannot be asked.
+func (h *AlistHandler) RecoverUploads(ctx context.Context) []UploadRecoveryResult {
+	h.uploadRecoveryMu.Lock()
+	defer h.uploadRecoveryMu.Unlock()
+	entries, err := h.uploadJournal.Entries()
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to read upload journal")
+	}
+	auth, _ := http.NewRequestWithContext(ctx, http.MethodPost, "http://local/", nil)
+	auth.Header = h.scanAuthHeaders()
+
+	var results []UploadRecoveryResult
+	for _, entry := range entries {
+		if !entry.Orphaned() {
+			continue
+		}
+		result := UploadRecoveryResult{UploadJournalEntry: entry}
+		outcome, err := h.recoverUpload(ctx, auth, &entry)
+		if err != nil {
+			result.Outcome, result.Error = uploadRecoveryFailed, err.Error()
+			log.Warn().Err(err).Str("path", entry.StagingPath).Msg("Upload recovery failed, will retry")
+		} else {
+			result.Outcome = outcome
+			h.uploadJournal.Finish(entry.StagingPath)
+			log.Info().Str("path", entry.StagingPath).Str("outcome", result.Outcome).Int64("written", entry.Written).Msg("Recovered interrupted upload")
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func (h *AlistHandler) recoverUpload(ctx context.Context, auth *http.Request, entry *UploadJournalEntry) (string, error) {
+	size, err := h.stagedObjectSize(ctx, auth, entry.StagingPath)
+	if err != nil {
+		if isAlistNotFound(err) {
+			return uploadRecoveryGone, nil
+		}
+		return "", err
+	}
+	if size != entry.StagedSize || entry.Written < entry.PlainSize {
+		h.discardStagedUpload(ctx, auth, entry.StagingPath)
+		return uploadRecoveryRemoved, nil
+	}
+	var final struct {
+		Modified time.Time `json:"modified"`
+	}
+	err = h.alistAPICall(ctx, auth, "/api/fs/get", map[string]interface{}{"path": entry.FinalPath}, &final)
+	if err == nil && final.Modified.After(entry.UpdatedAt) {
+		h.discardStagedUpload(ctx, auth, entry.StagingPath)
+		return uploadRecoveryRemoved, nil
+	}
+	if err := h.commitStagedUpload(ctx, auth, entry.StagingPath, entry.FinalPath, entry.StagedSize); err != nil {
+		return "", err
+	}
+	if entry.DisplayPath != "" {
+		h.InvalidateListCache(path.Dir(entry.DisplayPath))
+	}
+	return uploadRecoveryCompleted, nil
+}
+
+func isAlistNotFound(err error) bool {
+	return strings.Contains(strings.ToLower(err.Error()), "not found")
+}
+
+// HandleUploadJournal serves GET /enc-api/uploadJournal: journaled uploads,
+// with orphaned ones marked. POST runs the recovery job now and returns
+// what it did.
+func (h *AlistHandler) HandleUploadJournal(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		results := h.RecoverUploads(r.Context())
+		if results == nil {
+			results = []UploadRecoveryResult{}
+		}
+		RespondSuccess(w, map[string]interface{}{"results": results})
+		return
+	}
+	entries, err := h.uploadJournal.Entries()
+	if err != nil {
+		RespondAPIError(w, 500, err.Error())
+		return
+	}
+	type view struct {
+		UploadJournalEntry
+		Orphaned bool `json:"orphaned"`
+	}
+	views := make([]view, 0, len(entries))
+	for _, entry := range entries {
+		views = append(views, view{UploadJournalEntry: entry, Orphaned: entry.Orphaned()})
+	}
+	RespondSuccess(w, map[string]interface{}{"entries": views})
+}
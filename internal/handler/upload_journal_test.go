@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/storage"
+)
+
+func newTestUploadJournal(t *testing.T) (*UploadJournal, *storage.Store) {
+	t.Helper()
+	store, err := storage.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("create store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	return NewUploadJournal(store), store
+}
+
+func TestStagedUploadIsJournaledUntilCommitted(t *testing.T) {
+	passwd := &config.PasswdInfo{Password: "testpass", EncType: "aesctr", Enable: true, EncPath: []string{"/enc/*"}}
+	backend := &stagingBackend{}
+	journal, _ := newTestUploadJournal(t)
+	var during []UploadJournalEntry
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/fs/put", func(w http.ResponseWriter, r *http.Request) {
+		during, _ = journal.Entries()
+		backend.handler().ServeHTTP(w, r)
+	})
+	mux.Handle("/", backend.handler())
+	srv := newSocketTestServer(t, mux)
+	defer srv.Close()
+
+	handler, _ := newTestAlistHandler(t, srv.URL, passwd)
+	handler.cfg.AlistServer.EnableUploadStaging = true
+	handler.cfg.AlistServer.UploadStagingVerifyRetries = 1
+	handler.SetUploadJournal(journal)
+
+	body := bytes.Repeat([]byte("journal-"), 64)
+	rec := httptest.NewRecorder()
+	handler.HandleFsPut(rec, newStagedPutRequest("/enc/movie.mp4", body))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if len(during) != 1 || during[0].StagingPath != "/enc/movie.mp4.part" || during[0].FinalPath != "/enc/movie.mp4" ||
+		during[0].PlainSize != int64(len(body)) || during[0].Orphaned() {
+		t.Fatalf("journal during upload: %+v", during)
+	}
+	if after, _ := journal.Entries(); len(after) != 0 {
+		t.Fatalf("journal after commit: %+v", after)
+	}
+}
+
+func TestRecoverUploadsSettlesOrphanedEntries(t *testing.T) {
+	remote := map[string]int64{
+		"/enc/whole.mkv.part":   1032,
+		"/enc/partial.mkv.part": 400,
+		"/enc/newer.mkv.part":   1032,
+		"/enc/newer.mkv":        10,
+	}
+	var renamed, removed []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/fs/get", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Path string `json:"path"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		size, ok := remote[req.Path]
+		if !ok {
+			writeJSONResponse(w, map[string]interface{}{"code": 500, "message": "object not found"})
+			return
+		}
+		writeJSONResponse(w, map[string]interface{}{"code": 200, "message": "success",
+			"data": map[string]interface{}{"size": size, "modified": time.Now().Format(time.RFC3339)}})
+	})
+	mux.HandleFunc("/api/fs/rename", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Path string `json:"path"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		renamed = append(renamed, req.Path)
+		writeJSONResponse(w, map[string]interface{}{"code": 200, "message": "success"})
+	})
+	mux.HandleFunc("/api/fs/remove", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Dir   string   `json:"dir"`
+			Names []string `json:"names"`
+		}
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &req)
+		removed = append(removed, req.Dir+"/"+req.Names[0])
+		writeJSONResponse(w, map[string]interface{}{"code": 200, "message": "success"})
+	})
+	srv := newSocketTestServer(t, mux)
+	defer srv.Close()
+
+	handler, _ := newTestAlistHandler(t, srv.URL, &config.PasswdInfo{Password: "p", EncType: "aesctr", Enable: true, EncPath: []string{"/enc/*"}})
+	handler.cfg.AlistServer.UploadStagingVerifyRetries = 1
+	journal, store := newTestUploadJournal(t)
+	handler.SetUploadJournal(journal)
+
+	before := time.Now().Add(-time.Hour)
+	for _, name := range []string{"whole", "partial", "newer", "vanished"} {
+		entry := UploadJournalEntry{
+			StagingPath: "/enc/" + name + ".mkv.part", FinalPath: "/enc/" + name + ".mkv",
+			PlainSize: 1000, StagedSize: 1032, Written: 1000, Instance: "previous-process",
+			StartedAt: before, UpdatedAt: before,
+		}
+		if name == "partial" {
+			entry.Written = 380
+		}
+		if err := store.SetJSON(storage.BucketUploads, entry.StagingPath, &entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// An upload still running in this process is left alone.
+	journal.Begin(UploadJournalEntry{StagingPath: "/enc/live.mkv.part", FinalPath: "/enc/live.mkv"})
+
+	outcomes := map[string]string{}
+	for _, result := range handler.RecoverUploads(context.Background()) {
+		outcomes[result.StagingPath] = result.Outcome
+	}
+	want := map[string]string{
+		"/enc/whole.mkv.part":    uploadRecoveryCompleted,
+		"/enc/partial.mkv.part":  uploadRecoveryRemoved,
+		"/enc/newer.mkv.part":    uploadRecoveryRemoved,
+		"/enc/vanished.mkv.part": uploadRecoveryGone,
+	}
+	if len(outcomes) != len(want) {
+		t.Fatalf("outcomes=%v", outcomes)
+	}
+	for path, outcome := range want {
+		if outcomes[path] != outcome {
+			t.Fatalf("%s: outcome %q, want %q", path, outcomes[path], outcome)
+		}
+	}
+	if len(renamed) != 1 || renamed[0] != "/enc/whole.mkv.part" {
+		t.Fatalf("renamed=%v", renamed)
+	}
+	if len(removed) != 2 {
+		t.Fatalf("removed=%v", removed)
+	}
+	if left, _ := journal.Entries(); len(left) != 1 || left[0].StagingPath != "/enc/live.mkv.part" {
+		t.Fatalf("journal after recovery: %+v", left)
+	}
+}
@@ -0,0 +1,367 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/encryption"
+	"github.com/alist-encrypt-go/internal/errors"
+	"github.com/alist-encrypt-go/internal/httputil"
+	"github.com/alist-encrypt-go/internal/pathutil"
+)
+
+const (
+	uploadStagingSuffix       = ".part"
+	uploadStagingRetryBackoff = 500 * time.Millisecond
+)
+
+// uploadStagingEnabled reports whether a full (non-resumed) upload should be
+// written under a staging name first. Resumed uploads and Alist background
+// tasks are never staged because the final object is not complete when the
+// upstream request returns.
+func (h *AlistHandler) uploadStagingEnabled(r *http.Request, hasRange bool) bool {
+	if h.cfg == nil || !h.cfg.AlistServer.EnableUploadStaging || hasRange {
+		return false
+	}
+	return !strings.EqualFold(r.Header.Get("As-Task"), "true")
+}
+
+func (h *AlistHandler) uploadStagingRetries() int {
+	if h.cfg == nil || h.cfg.AlistServer.UploadStagingVerifyRetries <= 0 {
+		return 3
+	}
+	return h.cfg.AlistServer.UploadStagingVerifyRetries
+}
+
+// stagedUploadRecorder buffers the upstream /api/fs/put response so it can be
+// replaced with an error when verification or the final rename fails.
+type stagedUploadRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newStagedUploadRecorder() *stagedUploadRecorder {
+	return &stagedUploadRecorder{header: make(http.Header)}
+}
+
+func (r *stagedUploadRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *stagedUploadRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *stagedUploadRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if int64(r.body.Len()+len(p)) > maxProxyResponseBody {
+		return 0, fmt.Errorf("upload response exceeds %d bytes", maxProxyResponseBody)
+	}
+	return r.body.Write(p)
+}
+
+// succeeded reports whether Alist accepted the staged upload.
+func (r *stagedUploadRecorder) succeeded() bool {
+	if r.status != http.StatusOK {
+		return false
+	}
+	var payload struct {
+		Code int `json:"code"`
+	}
+	if err := json.Unmarshal(r.body.Bytes(), &payload); err != nil {
+		return false
+	}
+	return payload.Code == http.StatusOK
+}
+
+func (r *stagedUploadRecorder) flush(w http.ResponseWriter) {
+	for key, values := range r.header {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	status := r.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write(r.body.Bytes())
+}
+
+// uploadBodyVerifier counts plaintext bytes read from the client and hashes
+// them when the client declared a checksum via X-File-Md5/Sha1/Sha256.
+type uploadBodyVerifier struct {
+	io.ReadCloser
+	n        int64
+	hasher   hash.Hash
+	expected string
+	algo     string
+	// plain hashes the body for the read verification manifest.
+	plain hash.Hash
+}
+
+func newUploadBodyVerifier(r *http.Request) *uploadBodyVerifier {
+	v := &uploadBodyVerifier{ReadCloser: r.Body}
+	for _, candidate := range []struct {
+		header string
+		algo   string
+		newFn  func() hash.Hash
+	}{
+		{"X-File-Sha256", "sha256", sha256.New},
+		{"X-File-Sha1", "sha1", sha1.New},
+		{"X-File-Md5", "md5", md5.New},
+	} {
+		if value := strings.TrimSpace(r.Header.Get(candidate.header)); value != "" {
+			v.expected = strings.ToLower(value)
+			v.algo = candidate.algo
+			v.hasher = candidate.newFn()
+			break
+		}
+	}
+	return v
+}
+
+func (v *uploadBodyVerifier) Read(p []byte) (int, error) {
+	n, err := v.ReadCloser.Read(p)
+	if n > 0 {
+		v.n += int64(n)
+		if v.hasher != nil {
+			v.hasher.Write(p[:n])
+		}
+		if v.plain != nil {
+			v.plain.Write(p[:n])
+		}
+	}
+	return n, err
+}
+
+func (v *uploadBodyVerifier) verify(expectedSize int64) error {
+	if v.n != expectedSize {
+		return fmt.Errorf("received %d bytes, expected %d", v.n, expectedSize)
+	}
+	if v.hasher != nil {
+		if got := hex.EncodeToString(v.hasher.Sum(nil)); got != v.expected {
+			return fmt.Errorf("%s mismatch: got %s, declared %s", v.algo, got, v.expected)
+		}
+	}
+	return nil
+}
+
+// stagedCiphertextSize is the size Alist should report for a freshly uploaded
+// file: the plain size for legacy (v1) uploads, plus the content header for
+// v2, plus a tag per chunk for authenticated types.
+func (h *AlistHandler) stagedCiphertextSize(encType string, plainSize int64) int64 {
+	if encryption.IsAEADEncType(encType) {
+		return encryption.AEADCiphertextSize(plainSize)
+	}
+	if h.cfg != nil && h.cfg.AlistServer.UploadContentVersion == encryption.ContentVersionV1 {
+		return plainSize
+	}
+	return plainSize + encryption.ContentHeaderSize()
+}
+
+// putStaged uploads to finalPath+".part", verifies the result and renames it
+// into place. It writes the response itself and returns false on failure.
+// With a displayPath the plaintext hash is recorded for read verification.
+func (h *AlistHandler) putStaged(w http.ResponseWriter, r *http.Request, targetURL string, passwdInfo *config.PasswdInfo, fileSize int64, finalPath, displayPath string) bool {
+	stagingPath := finalPath + uploadStagingSuffix
+	r.Header.Set("File-Path", pathutil.EscapeFilePath(stagingPath))
+	verifier := newUploadBodyVerifier(r)
+	if displayPath != "" {
+		h.readVerifier.Forget(displayPath)
+		verifier.plain = h.readVerifier.uploadHasher(passwdInfo)
+	}
+	r.Body = verifier
+	stagedSize := h.stagedCiphertextSize(passwdInfo.EncType, fileSize)
+	if h.uploadJournal != nil {
+		// Journaled before the first byte goes out so a crash at any point
+		// leaves a record of the staged object for RecoverUploads.
+		h.uploadJournal.Begin(UploadJournalEntry{
+			StagingPath: stagingPath,
+			FinalPath:   finalPath,
+			DisplayPath: displayPath,
+			PlainSize:   fileSize,
+			StagedSize:  stagedSize,
+		})
+		defer h.uploadJournal.Finish(stagingPath)
+		r.Body = &journalReader{ReadCloser: verifier, journal: h.uploadJournal, stagingPath: stagingPath, flushed: time.Now()}
+	}
+
+	// Cleanup and rename must still run if the client goes away after the
+	// body has been fully sent.
+	ctx := context.WithoutCancel(r.Context())
+	rec := newStagedUploadRecorder()
+	if err := h.streamProxy.ProxyUploadEncrypt(rec, r, targetURL, passwdInfo, fileSize, 0); err != nil {
+		log.Error().Err(err).Str("path", finalPath).Msg("Failed to encrypt staged upload")
+		h.discardStagedUpload(ctx, r, stagingPath)
+		RespondCodedError(w, errors.CodeEncryptFailed, "Encryption error", http.StatusBadGateway)
+		return false
+	}
+	if !rec.succeeded() {
+		h.discardStagedUpload(ctx, r, stagingPath)
+		rec.flush(w)
+		return false
+	}
+
+	err := verifier.verify(fileSize)
+	if err == nil {
+		err = h.commitStagedUpload(ctx, r, stagingPath, finalPath, stagedSize)
+	}
+	if err != nil {
+		log.Error().Err(err).Str("path", finalPath).Msg("Staged upload verification failed")
+		h.discardStagedUpload(ctx, r, stagingPath)
+		RespondCodedError(w, errors.CodeUploadVerifyFailed, "Upload verification failed", http.StatusBadGateway)
+		return false
+	}
+
+	if verifier.plain != nil {
+		h.readVerifier.RecordUpload(displayPath, fileSize, verifier.plain.Sum(nil))
+	}
+	log.Debug().Str("staging", stagingPath).Str("final", finalPath).Msg("Committed staged upload")
+	rec.flush(w)
+	return true
+}
+
+// commitStagedUpload verifies the staged object size and renames it to its
+// final name, retrying both steps since some storages index uploads lazily.
+// An existing file at finalPath is replaced, matching plain PUT semantics.
+func (h *AlistHandler) commitStagedUpload(ctx context.Context, r *http.Request, stagingPath, finalPath string, expectedSize int64) error {
+	retries := h.uploadStagingRetries()
+
+	var verifyErr error
+	for attempt := 1; attempt <= retries; attempt++ {
+		var size int64
+		size, verifyErr = h.stagedObjectSize(ctx, r, stagingPath)
+		if verifyErr == nil && size != expectedSize {
+			verifyErr = fmt.Errorf("staged size %d, expected %d", size, expectedSize)
+		}
+		if verifyErr == nil {
+			break
+		}
+		if attempt < retries && !sleepContext(ctx, uploadStagingRetryBackoff*time.Duration(attempt)) {
+			return ctx.Err()
+		}
+	}
+	if verifyErr != nil {
+		return fmt.Errorf("verify staged upload: %w", verifyErr)
+	}
+
+	if _, err := h.stagedObjectSize(ctx, r, finalPath); err == nil {
+		h.discardStagedUpload(ctx, r, finalPath)
+	}
+
+	var renameErr error
+	for attempt := 1; attempt <= retries; attempt++ {
+		renameErr = h.alistAPICall(ctx, r, "/api/fs/rename", map[string]interface{}{
+			"path": stagingPath,
+			"name": path.Base(finalPath),
+		}, nil)
+		if renameErr == nil {
+			return nil
+		}
+		if attempt < retries && !sleepContext(ctx, uploadStagingRetryBackoff*time.Duration(attempt)) {
+			return ctx.Err()
+		}
+	}
+	return fmt.Errorf("rename staged upload: %w", renameErr)
+}
+
+func (h *AlistHandler) stagedObjectSize(ctx context.Context, r *http.Request, stagingPath string) (int64, error) {
+	var data struct {
+		Size  int64 `json:"size"`
+		IsDir bool  `json:"is_dir"`
+	}
+	if err := h.alistAPICall(ctx, r, "/api/fs/get", map[string]interface{}{"path": stagingPath}, &data); err != nil {
+		return 0, err
+	}
+	if data.IsDir {
+		return 0, fmt.Errorf("staged path is a directory")
+	}
+	return data.Size, nil
+}
+
+// discardStagedUpload removes a staged object (or the file it replaces);
+// errors are only logged since the caller cannot do anything better.
+func (h *AlistHandler) discardStagedUpload(ctx context.Context, r *http.Request, stagingPath string) {
+	err := h.alistAPICall(ctx, r, "/api/fs/remove", map[string]interface{}{
+		"dir":   path.Dir(stagingPath),
+		"names": []string{path.Base(stagingPath)},
+	}, nil)
+	if err != nil {
+		log.Warn().Err(err).Str("path", stagingPath).Msg("Failed to remove staged upload")
+	}
+}
+
+// alistAPICall POSTs a JSON payload to an Alist fs endpoint with the caller's
+// Authorization and decodes data into out when the envelope code is 200.
+func (h *AlistHandler) alistAPICall(ctx context.Context, r *http.Request, endpoint string, payload interface{}, out interface{}) error {
+	body, _ := json.Marshal(payload)
+	targetURL := httputil.BuildTargetURL(h.cfg.GetAlistURL(), endpoint, nil)
+	builder := httputil.NewRequest(http.MethodPost, targetURL).
+		WithContext(ctx).
+		WithBody(body).
+		WithHeader("Content-Type", "application/json")
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		builder = builder.WithHeader("Authorization", auth)
+	}
+	req, err := builder.Build()
+	if err != nil {
+		return err
+	}
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := readLimitedBody(resp, maxProxyResponseBody)
+	if err != nil {
+		return err
+	}
+	var envelope struct {
+		Code    int             `json:"code"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(respBody, &envelope); err != nil {
+		return fmt.Errorf("%s: invalid response: %w", endpoint, err)
+	}
+	if envelope.Code != http.StatusOK {
+		return fmt.Errorf("%s: code=%d message=%s", endpoint, envelope.Code, envelope.Message)
+	}
+	if out != nil && len(envelope.Data) > 0 {
+		return json.Unmarshal(envelope.Data, out)
+	}
+	return nil
+}
+
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+type stagingBackend struct {
+	mu         sync.Mutex
+	putPath    string
+	putSize    int64
+	reportSize int64
+	renames    []map[string]interface{}
+	removes    []map[string]interface{}
+}
+
+func (b *stagingBackend) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/fs/put", func(w http.ResponseWriter, r *http.Request) {
+		n, _ := io.Copy(io.Discard, r.Body)
+		b.mu.Lock()
+		b.putPath, _ = url.QueryUnescape(r.Header.Get("File-Path"))
+		b.putSize = n
+		b.mu.Unlock()
+		writeJSONResponse(w, map[string]interface{}{"code": 200, "message": "success"})
+	})
+	mux.HandleFunc("/api/fs/get", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if req["path"] != b.putPath {
+			writeJSONResponse(w, map[string]interface{}{"code": 500, "message": "object not found"})
+			return
+		}
+		size := b.putSize
+		if b.reportSize > 0 {
+			size = b.reportSize
+		}
+		writeJSONResponse(w, map[string]interface{}{
+			"code":    200,
+			"message": "success",
+			"data":    map[string]interface{}{"size": float64(size), "is_dir": false},
+		})
+	})
+	mux.HandleFunc("/api/fs/rename", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		b.mu.Lock()
+		b.renames = append(b.renames, req)
+		b.mu.Unlock()
+		writeJSONResponse(w, map[string]interface{}{"code": 200, "message": "success"})
+	})
+	mux.HandleFunc("/api/fs/remove", func(w http.ResponseWriter, r *http.Request) {
+		var req map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&req)
+		b.mu.Lock()
+		b.removes = append(b.removes, req)
+		b.mu.Unlock()
+		writeJSONResponse(w, map[string]interface{}{"code": 200, "message": "success"})
+	})
+	return mux
+}
+
+func newStagedPutRequest(displayPath string, body []byte) *http.Request {
+	req := httptest.NewRequest(http.MethodPut, "http://proxy.local/api/fs/put", bytes.NewReader(body))
+	req.Header.Set("File-Path", url.QueryEscape(displayPath))
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	req.ContentLength = int64(len(body))
+	return req
+}
+
+func TestHandleFsPutStagingRenamesAfterVerification(t *testing.T) {
+	passwd := &config.PasswdInfo{
+		Password: "testpass",
+		EncType:  "aesctr",
+		Enable:   true,
+		EncPath:  []string{"/enc/*"},
+	}
+	backend := &stagingBackend{}
+	srv := newSocketTestServer(t, backend.handler())
+	defer srv.Close()
+
+	handler, _ := newTestAlistHandler(t, srv.URL, passwd)
+	handler.cfg.AlistServer.EnableUploadStaging = true
+	handler.cfg.AlistServer.UploadStagingVerifyRetries = 1
+
+	body := bytes.Repeat([]byte("staged-"), 64)
+	rec := httptest.NewRecorder()
+	handler.HandleFsPut(rec, newStagedPutRequest("/enc/movie.mp4", body))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if backend.putPath != "/enc/movie.mp4.part" {
+		t.Fatalf("upload went to %q, want staging name", backend.putPath)
+	}
+	if len(backend.renames) != 1 {
+		t.Fatalf("renames=%v", backend.renames)
+	}
+	if backend.renames[0]["path"] != "/enc/movie.mp4.part" || backend.renames[0]["name"] != "movie.mp4" {
+		t.Fatalf("unexpected rename request: %v", backend.renames[0])
+	}
+	if len(backend.removes) != 0 {
+		t.Fatalf("unexpected removes: %v", backend.removes)
+	}
+}
+
+func TestHandleFsPutStagingExpectsLegacySizeForV1Uploads(t *testing.T) {
+	passwd := &config.PasswdInfo{
+		Password: "testpass",
+		EncType:  "rc4md5",
+		Enable:   true,
+		EncPath:  []string{"/enc/*"},
+	}
+	backend := &stagingBackend{}
+	srv := newSocketTestServer(t, backend.handler())
+	defer srv.Close()
+
+	handler, _ := newTestAlistHandler(t, srv.URL, passwd)
+	handler.cfg.AlistServer.EnableUploadStaging = true
+	handler.cfg.AlistServer.UploadStagingVerifyRetries = 1
+	handler.cfg.AlistServer.UploadContentVersion = 1
+
+	body := bytes.Repeat([]byte("legacy-"), 64)
+	rec := httptest.NewRecorder()
+	handler.HandleFsPut(rec, newStagedPutRequest("/enc/movie.mp4", body))
+
+	if rec.Code != http.StatusOK || len(backend.renames) != 1 {
+		t.Fatalf("status=%d renames=%v body=%s", rec.Code, backend.renames, rec.Body.String())
+	}
+	if backend.putSize != int64(len(body)) {
+		t.Fatalf("v1 upload sent %d bytes, want %d (no header)", backend.putSize, len(body))
+	}
+}
+
+func TestHandleFsPutStagingDiscardsOnSizeMismatch(t *testing.T) {
+	passwd := &config.PasswdInfo{
+		Password: "testpass",
+		EncType:  "aesctr",
+		Enable:   true,
+		EncPath:  []string{"/enc/*"},
+	}
+	backend := &stagingBackend{reportSize: 7}
+	srv := newSocketTestServer(t, backend.handler())
+	defer srv.Close()
+
+	handler, _ := newTestAlistHandler(t, srv.URL, passwd)
+	handler.cfg.AlistServer.EnableUploadStaging = true
+	handler.cfg.AlistServer.UploadStagingVerifyRetries = 1
+
+	rec := httptest.NewRecorder()
+	handler.HandleFsPut(rec, newStagedPutRequest("/enc/movie.mp4", bytes.Repeat([]byte("x"), 128)))
+
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status=%d, want 502", rec.Code)
+	}
+	if len(backend.renames) != 0 {
+		t.Fatalf("staged upload must not be renamed: %v", backend.renames)
+	}
+	if len(backend.removes) != 1 || backend.removes[0]["dir"] != "/enc" {
+		t.Fatalf("expected staged file removal, got %v", backend.removes)
+	}
+}
+
+func TestUploadBodyVerifierChecksDeclaredHash(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPut, "http://proxy.local/api/fs/put", bytes.NewReader([]byte("hello")))
+	req.Header.Set("X-File-Md5", "5d41402abc4b2a76b9719d911017c592")
+	v := newUploadBodyVerifier(req)
+	if _, err := io.ReadAll(v); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if err := v.verify(5); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "http://proxy.local/api/fs/put", bytes.NewReader([]byte("hellO")))
+	req.Header.Set("X-File-Md5", "5d41402abc4b2a76b9719d911017c592")
+	v = newUploadBodyVerifier(req)
+	_, _ = io.ReadAll(v)
+	if err := v.verify(5); err == nil {
+		t.Fatal("expected hash mismatch")
+	}
+}
@@ -0,0 +1,322 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/proxy"
+)
+
+const upstreamProbeTimeout = 10 * time.Second
+
+// UpstreamSample is one probe of an upstream. OK means the upstream answered
+// with a non-5xx status; LatencyMs is the time to the response headers.
+type UpstreamSample struct {
+	Time      time.Time `json:"time"`
+	OK        bool      `json:"ok"`
+	Status    int       `json:"status,omitempty"`
+	LatencyMs int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// UpstreamSummary condenses an upstream's rolling history.
+type UpstreamSummary struct {
+	Name                string           `json:"name"`
+	URL                 string           `json:"url"`
+	Up                  bool             `json:"up"`
+	Availability        float64          `json:"availability"` // percent of samples that were OK
+	Samples             int              `json:"samples"`
+	LastLatencyMs       int64            `json:"last_latency_ms"`
+	AvgLatencyMs        int64            `json:"avg_latency_ms"`
+	P95LatencyMs        int64            `json:"p95_latency_ms"`
+	ConsecutiveFailures int              `json:"consecutive_failures"`
+	LastChecked         time.Time        `json:"last_checked"`
+	LastError           string           `json:"last_error,omitempty"`
+	History             []UpstreamSample `json:"history,omitempty"`
+}
+
+// upstreamTarget is one upstream to probe, rebuilt from the config on every
+// round so API edits take effect without a restart.
+type upstreamTarget struct {
+	name, url string
+}
+
+// upstreamHistory is a fixed-size ring of samples.
+type upstreamHistory struct {
+	url     string
+	samples []UpstreamSample
+	next    int
+	full    bool
+}
+
+func (h *upstreamHistory) add(s UpstreamSample, size int) {
+	if len(h.samples) != size {
+		// History size changed: keep the newest samples that still fit.
+		ordered := h.ordered()
+		if len(ordered) > size {
+			ordered = ordered[len(ordered)-size:]
+		}
+		h.samples = make([]UpstreamSample, size)
+		copy(h.samples, ordered)
+		h.next = len(ordered) % size
+		h.full = len(ordered) == size
+	}
+	h.samples[h.next] = s
+	h.next = (h.next + 1) % size
+	if h.next == 0 {
+		h.full = true
+	}
+}
+
+// ordered returns the samples oldest first.
+func (h *upstreamHistory) ordered() []UpstreamSample {
+	if !h.full {
+		return append([]UpstreamSample(nil), h.samples[:h.next]...)
+	}
+	out := make([]UpstreamSample, 0, len(h.samples))
+	out = append(out, h.samples[h.next:]...)
+	return append(out, h.samples[:h.next]...)
+}
+
+// UpstreamStatus probes the Alist upstream (and, with include_webdav, every
+// webdavServer entry) every status_probe.interval_seconds and keeps a rolling
+// latency/availability history per upstream for /enc-api/status and /ready.
+type UpstreamStatus struct {
+	cfg    *config.Config
+	client *http.Client
+
+	mu      sync.RWMutex
+	history map[string]*upstreamHistory
+	order   []string
+}
+
+// NewUpstreamStatus creates a prober for cfg's upstreams.
+func NewUpstreamStatus(cfg *config.Config) *UpstreamStatus {
+	return &UpstreamStatus{
+		cfg:     cfg,
+		client:  proxy.NewHTTPClient(cfg, upstreamProbeTimeout),
+		history: make(map[string]*upstreamHistory),
+	}
+}
+
+// Start probes immediately and then every interval until ctx is cancelled.
+// It does nothing when status_probe.enable is off.
+func (u *UpstreamStatus) Start(ctx context.Context) {
+	if u == nil || u.cfg.StatusProbe == nil || !u.cfg.StatusProbe.Enable {
+		return
+	}
+	go func() {
+		for {
+			u.ProbeOnce(ctx)
+			interval := time.Duration(u.cfg.StatusProbe.IntervalSeconds) * time.Second
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+}
+
+// ProbeOnce probes every configured upstream concurrently and records the
+// results.
+func (u *UpstreamStatus) ProbeOnce(ctx context.Context) {
+	targets := u.targets()
+	samples := make([]UpstreamSample, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target upstreamTarget) {
+			defer wg.Done()
+			samples[i] = u.probe(ctx, target.url)
+		}(i, target)
+	}
+	wg.Wait()
+
+	size := 120
+	if u.cfg.StatusProbe != nil && u.cfg.StatusProbe.History > 0 {
+		size = u.cfg.StatusProbe.History
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	order := make([]string, 0, len(targets))
+	for i, target := range targets {
+		h := u.history[target.name]
+		if h == nil || h.url != target.url {
+			// A new upstream, or one moved to another address: its old
+			// samples describe a different server.
+			h = &upstreamHistory{url: target.url}
+			u.history[target.name] = h
+		}
+		h.add(samples[i], size)
+		order = append(order, target.name)
+		if !samples[i].OK {
+			log.Debug().Str("upstream", target.name).Str("error", samples[i].Error).Int("status", samples[i].Status).Msg("Upstream status probe failed")
+		}
+	}
+	for name := range u.history {
+		if !slices.Contains(order, name) {
+			delete(u.history, name)
+		}
+	}
+	u.order = order
+}
+
+func (u *UpstreamStatus) targets() []upstreamTarget {
+	targets := []upstreamTarget{{name: "alist", url: u.cfg.GetAlistURL() + "/ping"}}
+	if u.cfg.StatusProbe == nil || !u.cfg.StatusProbe.IncludeWebDAV {
+		return targets
+	}
+	for _, server := range u.cfg.WebDAVServer {
+		if !server.Enable || server.ServerHost == "" {
+			continue
+		}
+		scheme := "http"
+		if server.HTTPS {
+			scheme = "https"
+		}
+		name := server.Name
+		if name == "" {
+			name = server.ID
+		}
+		base := scheme + "://" + server.ServerHost
+		if server.ServerPort > 0 && server.ServerPort != 80 && server.ServerPort != 443 {
+			base = fmt.Sprintf("%s:%d", base, server.ServerPort)
+		}
+		targets = append(targets, upstreamTarget{name: "webdav:" + name, url: base + "/"})
+	}
+	return targets
+}
+
+func (u *UpstreamStatus) probe(ctx context.Context, target string) UpstreamSample {
+	sample := UpstreamSample{Time: time.Now()}
+	ctx, cancel := context.WithTimeout(ctx, upstreamProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		sample.Error = err.Error()
+		return sample
+	}
+	resp, err := u.client.Do(req)
+	sample.LatencyMs = time.Since(sample.Time).Milliseconds()
+	if err != nil {
+		sample.Error = err.Error()
+		return sample
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	sample.Status = resp.StatusCode
+	// Any answer below 500 means the upstream is serving; WebDAV backends
+	// reply 401 or 405 to an anonymous GET.
+	sample.OK = resp.StatusCode < http.StatusInternalServerError
+	if !sample.OK {
+		sample.Error = resp.Status
+	}
+	return sample
+}
+
+// Summaries returns one summary per upstream in probe order, with the sample
+// history when withHistory is set.
+func (u *UpstreamStatus) Summaries(withHistory bool) []UpstreamSummary {
+	if u == nil {
+		return nil
+	}
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	out := make([]UpstreamSummary, 0, len(u.order))
+	for _, name := range u.order {
+		h := u.history[name]
+		if h == nil {
+			continue
+		}
+		out = append(out, summarizeUpstream(name, h, withHistory))
+	}
+	return out
+}
+
+func summarizeUpstream(name string, h *upstreamHistory, withHistory bool) UpstreamSummary {
+	samples := h.ordered()
+	summary := UpstreamSummary{Name: name, URL: h.url, Samples: len(samples)}
+	if len(samples) == 0 {
+		return summary
+	}
+	last := samples[len(samples)-1]
+	summary.Up = last.OK
+	summary.LastLatencyMs = last.LatencyMs
+	summary.LastChecked = last.Time
+	var ok int
+	var latencies []int64
+	var total int64
+	for _, s := range samples {
+		if s.OK {
+			ok++
+			latencies = append(latencies, s.LatencyMs)
+			total += s.LatencyMs
+		} else if s.Error != "" {
+			summary.LastError = s.Error
+		}
+	}
+	for i := len(samples) - 1; i >= 0 && !samples[i].OK; i-- {
+		summary.ConsecutiveFailures++
+	}
+	summary.Availability = float64(int64(float64(ok)*10000/float64(len(samples)))) / 100
+	if len(latencies) > 0 {
+		summary.AvgLatencyMs = total / int64(len(latencies))
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		summary.P95LatencyMs = latencies[(len(latencies)*95+99)/100-1]
+	}
+	if withHistory {
+		summary.History = samples
+	}
+	return summary
+}
+
+// Ready reports the upstream detail for /ready: each upstream's state and
+// whether all of them answered their last probe.
+func (u *UpstreamStatus) Ready() (bool, map[string]interface{}) {
+	detail := map[string]interface{}{}
+	allUp := true
+	for _, s := range u.Summaries(false) {
+		detail[s.Name] = map[string]interface{}{
+			"up":                   s.Up,
+			"latency_ms":           s.LastLatencyMs,
+			"availability":         s.Availability,
+			"last_checked":         s.LastChecked,
+			"consecutive_failures": s.ConsecutiveFailures,
+		}
+		if !s.Up {
+			allUp = false
+		}
+	}
+	return allUp, detail
+}
+
+// HandleStatus serves /enc-api/status for the status page. history=0 omits
+// the per-sample history.
+func (u *UpstreamStatus) HandleStatus(w http.ResponseWriter, r *http.Request) {
+	withHistory := true
+	if v, err := strconv.ParseBool(r.URL.Query().Get("history")); err == nil {
+		withHistory = v
+	}
+	data := map[string]interface{}{
+		"enabled":   u.cfg.StatusProbe != nil && u.cfg.StatusProbe.Enable,
+		"upstreams": u.Summaries(withHistory),
+	}
+	if u.cfg.StatusProbe != nil {
+		data["interval_seconds"] = u.cfg.StatusProbe.IntervalSeconds
+	}
+	if targets := proxy.ActiveFailover().Targets(); len(targets) > 1 {
+		data["failover"] = targets
+	}
+	RespondSuccess(w, data)
+}
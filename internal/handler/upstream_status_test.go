@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+func hostPort(t *testing.T, raw string) (string, int) {
+	t.Helper()
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("parse %s: %v", raw, err)
+	}
+	port, err := strconv.Atoi(parsed.Port())
+	if err != nil {
+		t.Fatalf("port of %s: %v", raw, err)
+	}
+	return parsed.Hostname(), port
+}
+
+func TestUpstreamStatusRollingHistory(t *testing.T) {
+	var failing atomic.Bool
+	alist := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ping" {
+			t.Errorf("alist probed at %s, want /ping", r.URL.Path)
+		}
+		if failing.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte("pong"))
+	}))
+	defer alist.Close()
+	dav := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer dav.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.AlistServer.ServerHost, cfg.AlistServer.ServerPort = hostPort(t, alist.URL)
+	davHost, davPort := hostPort(t, dav.URL)
+	cfg.WebDAVServer = []config.WebDAVServer{
+		{ID: "1", Name: "nas", Enable: true, ServerHost: davHost, ServerPort: davPort},
+		{ID: "2", Name: "off", Enable: false, ServerHost: davHost, ServerPort: davPort},
+	}
+	cfg.StatusProbe = &config.StatusProbeConfig{Enable: true, IntervalSeconds: 30, History: 10, IncludeWebDAV: true}
+
+	status := NewUpstreamStatus(cfg)
+	ctx := context.Background()
+	for i := 0; i < 12; i++ {
+		status.ProbeOnce(ctx)
+	}
+	failing.Store(true)
+	for i := 0; i < 2; i++ {
+		status.ProbeOnce(ctx)
+	}
+
+	summaries := status.Summaries(true)
+	if len(summaries) != 2 || summaries[0].Name != "alist" || summaries[1].Name != "webdav:nas" {
+		t.Fatalf("summaries=%+v, want alist and webdav:nas", summaries)
+	}
+	a := summaries[0]
+	if a.Samples != 10 || len(a.History) != 10 {
+		t.Fatalf("alist samples=%d history=%d, want the last 10", a.Samples, len(a.History))
+	}
+	if a.Up || a.ConsecutiveFailures != 2 || a.Availability != 80 || a.LastError == "" {
+		t.Fatalf("alist summary=%+v, want down after 2 failures with 80%% availability", a)
+	}
+	if a.History[9].Status != http.StatusBadGateway || a.History[0].Status != http.StatusOK {
+		t.Fatalf("history not oldest first: first=%+v last=%+v", a.History[0], a.History[9])
+	}
+	if d := summaries[1]; !d.Up || d.Availability != 100 {
+		t.Fatalf("webdav summary=%+v, want 401 counted as up", d)
+	}
+
+	up, detail := status.Ready()
+	if up || len(detail) != 2 {
+		t.Fatalf("ready up=%v detail=%v", up, detail)
+	}
+
+	cfg.StatusProbe.IncludeWebDAV = false
+	status.ProbeOnce(ctx)
+	if got := status.Summaries(false); len(got) != 1 || got[0].History != nil {
+		t.Fatalf("after disabling webdav probes: %+v", got)
+	}
+
+	rr := httptest.NewRecorder()
+	status.HandleStatus(rr, httptest.NewRequest(http.MethodGet, "/enc-api/status?history=0", nil))
+	var body struct {
+		Data struct {
+			Enabled   bool              `json:"enabled"`
+			Upstreams []UpstreamSummary `json:"upstreams"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("decode %q: %v", rr.Body.String(), err)
+	}
+	if !body.Data.Enabled || len(body.Data.Upstreams) != 1 || body.Data.Upstreams[0].History != nil {
+		t.Fatalf("status body=%s", rr.Body.String())
+	}
+}
@@ -19,7 +19,9 @@ import (
 	"github.com/alist-encrypt-go/internal/config"
 	"github.com/alist-encrypt-go/internal/dao"
 	"github.com/alist-encrypt-go/internal/encryption"
+	"github.com/alist-encrypt-go/internal/errors"
 	"github.com/alist-encrypt-go/internal/httputil"
+	"github.com/alist-encrypt-go/internal/pathutil"
 	"github.com/alist-encrypt-go/internal/proxy"
 	"github.com/alist-encrypt-go/internal/trace"
 )
@@ -36,6 +38,10 @@ type WebDAVHandler struct {
 	strategySel           *StrategySelector
 	metaStore             FileMetaStore
 	probe                 *ProbeScheduler
+	playStats             *PlaybackStats
+	readVerifier          *ReadVerifier
+	audit                 *AuditLog
+	maintenance           *MaintenanceGate
 	negCache              *negativePathCache
 	sharedTransport       http.RoundTripper // shared transport for connection pooling
 	shortClient           *http.Client      // 10s timeout for HEAD/quick ops
@@ -95,7 +101,7 @@ func NewWebDAVHandler(cfg *config.Config, streamProxy *proxy.StreamProxy, fileDA
 		passwdDAO:       passwdDAO,
 		proxyHandler:    NewProxyHandler(cfg, streamProxy, fileDAO, passwdDAO, selector, metaStore),
 		strategyCache:   NewStrategyCache(1000),
-		sizeResolver:    NewFileSizeResolver(cfg, fileDAO, metaStore, 20, getMinMetaSize(cfg), getRedirectMaxHops(cfg)),
+		sizeResolver:    NewFileSizeResolver(cfg, fileDAO, metaStore, 0, getMinMetaSize(cfg), getRedirectMaxHops(cfg)),
 		strategySel:     selector,
 		metaStore:       metaStore,
 		probe:           nil,
@@ -104,6 +110,7 @@ func NewWebDAVHandler(cfg *config.Config, streamProxy *proxy.StreamProxy, fileDA
 		shortClient:     proxy.NewHTTPClientWithTransport(sharedTransport, 10*time.Second),
 		stdClient:       proxy.NewHTTPClientWithTransport(sharedTransport, 30*time.Second),
 	}
+	fileDAO.OnForget(h.strategyCache.InvalidateUnder)
 	return h
 }
 
@@ -114,35 +121,96 @@ func (h *WebDAVHandler) Handle(w http.ResponseWriter, r *http.Request) {
 		davPath = "/"
 	}
 
+	if !h.mapWebDAVUser(w, r) {
+		return
+	}
 	if auth := r.Header.Get("Authorization"); auth != "" {
 		ctx := context.WithValue(r.Context(), webdavAuthContextKey, auth)
 		r = r.WithContext(ctx)
 	}
+	h.rewriteIfHeader(r)
 
 	switch r.Method {
 	case "GET", "HEAD":
+		if h.wantsHTMLIndex(r, davPath) {
+			h.handleHTMLIndex(w, r, davPath)
+			return
+		}
 		h.handleGet(w, r, davPath)
 	case "PUT":
+		h.negCache.Unblock(davPath)
+		h.fileDAO.Revive(davPath)
 		h.handlePut(w, r, davPath)
 	case "PROPFIND":
 		h.handlePropfind(w, r, davPath)
 	case "DELETE":
-		h.handleDelete(w, r, davPath)
+		sw := &statusCaptureWriter{ResponseWriter: w}
+		h.handleDelete(sw, r, davPath)
+		if sw.status >= 200 && sw.status < 300 {
+			h.fileDAO.Forget(davPath)
+		}
 	case "MOVE":
 		h.handleMove(w, r, davPath)
 	case "COPY":
 		h.handleCopy(w, r, davPath)
-	case "MKCOL", "PROPPATCH", "LOCK", "UNLOCK", "OPTIONS":
+	case "LOCK", "UNLOCK", "PROPPATCH":
+		h.handleLockAware(w, r, davPath)
+	case "MKCOL":
+		h.negCache.Unblock(davPath, path.Clean(davPath))
+		h.fileDAO.Revive(davPath)
+		h.handleMkcol(w, r, davPath)
+	case "OPTIONS":
 		h.handlePassthrough(w, r)
 	default:
 		h.handlePassthrough(w, r)
 	}
 }
 
+// statusCaptureWriter remembers the status a handler answered with, so the
+// caller can act on the outcome of a passed-through request.
+type statusCaptureWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusCaptureWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusCaptureWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(p)
+}
+
 func (h *WebDAVHandler) SetProbeScheduler(probe *ProbeScheduler) {
 	h.probe = probe
 }
 
+// SetMaintenanceGate pauses the startup probe crawl during quiet hours.
+func (h *WebDAVHandler) SetMaintenanceGate(gate *MaintenanceGate) {
+	h.maintenance = gate
+}
+
+// SetPlaybackStats enables per-file playback accounting.
+func (h *WebDAVHandler) SetPlaybackStats(stats *PlaybackStats) {
+	h.playStats = stats
+}
+
+// SetAuditLog records reads blocked by access windows in audit.
+func (h *WebDAVHandler) SetAuditLog(audit *AuditLog) {
+	h.audit = audit
+}
+
+// SetReadVerifier enables sampled hash checks of decrypted downloads.
+func (h *WebDAVHandler) SetReadVerifier(verifier *ReadVerifier) {
+	h.readVerifier = verifier
+}
+
 // Stop terminates background maintenance goroutines owned by the WebDAV handler.
 func (h *WebDAVHandler) Stop() {
 	if h == nil || h.proxyHandler == nil {
@@ -195,6 +263,9 @@ func (h *WebDAVHandler) StartupProbe(ctx context.Context, paths []string) {
 		return
 	}
 	for _, dirPath := range paths {
+		if h.maintenance.Wait(ctx, jobStartupProbe) != nil || waitStorageRequest(ctx, dirPath) != nil {
+			return
+		}
 		h.probePath(ctx, dirPath)
 	}
 }
@@ -214,7 +285,7 @@ func (h *WebDAVHandler) deepScan(ctx context.Context, paths []string) {
 	queue := make([]scanNode, 0, len(paths))
 
 	for _, dirPath := range paths {
-		normalized := normalizeProbeDirPath(dirPath)
+		normalized := pathutil.DirSlash(dirPath)
 		if normalized == "" {
 			continue
 		}
@@ -226,8 +297,14 @@ func (h *WebDAVHandler) deepScan(ctx context.Context, paths []string) {
 	}
 
 	for len(queue) > 0 {
+		if h.maintenance.Wait(ctx, jobStartupProbe) != nil {
+			return
+		}
 		node := queue[0]
 		queue = queue[1:]
+		if waitStorageRequest(ctx, node.path) != nil {
+			return
+		}
 
 		entries := h.probePath(ctx, node.path)
 		if len(entries) == 0 {
@@ -241,7 +318,7 @@ func (h *WebDAVHandler) deepScan(ctx context.Context, paths []string) {
 			if !entry.IsDir {
 				continue
 			}
-			nextPath := normalizeProbeDirPath(entry.Path)
+			nextPath := pathutil.DirSlash(entry.Path)
 			if nextPath == "" || nextPath == node.path {
 				continue
 			}
@@ -269,7 +346,7 @@ func (h *WebDAVHandler) resolveRealPathWithMode(davPath string, passwdInfo *conf
 func (h *WebDAVHandler) handleGet(w http.ResponseWriter, r *http.Request, davPath string) {
 	trace.Logf(r.Context(), "webdav-get", "Processing: %s", davPath)
 
-	passwdInfo, found := h.passwdDAO.FindByPath(davPath)
+	passwdInfo, found := h.passwdDAO.FindByPathFor(r.Context(), davPath)
 	if !found {
 		if dirPasswd, ok := h.passwdDAO.FindByDir(davPath); ok {
 			passwdInfo = dirPasswd
@@ -284,6 +361,9 @@ func (h *WebDAVHandler) handleGet(w http.ResponseWriter, r *http.Request, davPat
 			trace.Logf(r.Context(), "webdav-get", "Using encryption config from X-OpenEncrypt-Rule headers")
 		}
 	}
+	if found && !allowAccessNow(w, r, passwdInfo, davPath, h.audit) {
+		return
+	}
 	if !found {
 		trace.Logf(r.Context(), "webdav-get", "No encryption, passthrough")
 		h.handlePassthrough(w, r)
@@ -372,6 +452,10 @@ func (h *WebDAVHandler) handleGet(w http.ResponseWriter, r *http.Request, davPat
 		CompatKey:             buildRangeCompatStorageKey(passwdInfo, davPath),
 		ConsumerScenario:      consumerScenarioWebDAV,
 		FailureLogMsg:         "WebDAV GET decryption failed",
+		LocalPath:             localDirectPath(h.cfg, h.fileDAO, davPath, realPath),
+		LocalAuthURL:          httputil.BuildTargetURLStripped(h.cfg.GetAlistURL(), "/dav"+realPath),
+		PlayStats:             h.playStats,
+		ReadVerifier:          h.readVerifier,
 		FinalPassthroughCount: &h.finalPassthroughCount,
 		SizeConflictCount:     &h.sizeConflictCount,
 		FirstFrameCount:       &h.firstFrameCount,
@@ -459,6 +543,9 @@ func (h *WebDAVHandler) warmRawURLFromAlistAsync(r *http.Request, displayPath, r
 	if h == nil || h.cfg == nil || strings.TrimSpace(displayPath) == "" || strings.TrimSpace(realPath) == "" {
 		return
 	}
+	if !h.cfg.AlistServer.EnablePrefetch {
+		return
+	}
 	stalenessThreshold := h.upstreamStalenessThreshold()
 	if h.fileDAO != nil {
 		if cachedInfo, ok := h.fileDAO.Get(displayPath); ok && cachedRawURLFresh(cachedInfo, stalenessThreshold) && strings.TrimSpace(cachedInfo.RawURL) != "" {
@@ -498,7 +585,7 @@ func (h *WebDAVHandler) warmRawURLFromAlistAsync(r *http.Request, displayPath, r
 
 // handlePut handles PUT requests with encryption and filename encryption
 func (h *WebDAVHandler) handlePut(w http.ResponseWriter, r *http.Request, davPath string) {
-	passwdInfo, found := h.passwdDAO.FindByPath(davPath)
+	passwdInfo, found := h.passwdDAO.FindByPathFor(r.Context(), davPath)
 	if !found {
 		if dirPasswd, ok := h.passwdDAO.FindByDir(davPath); ok {
 			passwdInfo = dirPasswd
@@ -509,6 +596,10 @@ func (h *WebDAVHandler) handlePut(w http.ResponseWriter, r *http.Request, davPat
 		h.handlePassthrough(w, r)
 		return
 	}
+	if r.ContentLength == 0 && r.Header.Get("Content-Range") == "" {
+		h.handlePutEmpty(w, r, davPath, passwdInfo)
+		return
+	}
 
 	fileSize, err := resolveUploadFileSize(r)
 	if err != nil {
@@ -534,28 +625,90 @@ func (h *WebDAVHandler) handlePut(w http.ResponseWriter, r *http.Request, davPat
 	// Convert display path to real encrypted path
 	realPath := davPath
 	if passwdInfo.EncName {
-		converter := encryption.NewFileNameConverter(passwdInfo.Password, passwdInfo.EncType, passwdInfo.EncSuffix)
 		fileName := path.Base(davPath)
-		realPath = path.Dir(davPath) + "/" + converter.ToRealName(fileName)
-
-		// Cache file info for subsequent PROPFIND (like alist-encrypt does)
-		h.fileDAO.Set(&dao.FileInfo{
-			Path:  davPath,
-			Name:  fileName,
-			Size:  fileSize,
-			IsDir: false,
-		})
+		// WebDAV clients expect the file under the name they sent, so an
+		// over-long name is refused rather than shortened.
+		storedName, _, err := storedNameFor(h.cfg, passwdInfo, fileName, false)
+		if err != nil {
+			RespondCodedError(w, errors.CodeNameTooLong, err.Error(), http.StatusBadRequest)
+			return
+		}
+		realPath = path.Dir(davPath) + "/" + storedName
+
+		// Cache file info for subsequent PROPFIND (like alist-encrypt does).
+		// The content version lets PROPFIND report the plain size, which
+		// clients such as rclone compare against what they uploaded.
+		info := &dao.FileInfo{
+			Path:          davPath,
+			EncryptedPath: realPath,
+			Name:          fileName,
+			Size:          fileSize,
+			IsDir:         false,
+		}
+		if !hasRange && !encryption.IsAEADEncType(passwdInfo.EncType) && h.cfg.AlistServer.UploadContentVersion != encryption.ContentVersionV1 {
+			info.ContentVersion = encryption.ContentVersionV2
+		}
+		h.fileDAO.Set(info)
+		h.negCache.Unblock(realPath)
 		log.Debug().Str("original", davPath).Str("encrypted", realPath).Msg("WebDAV PUT filename encrypted")
 	}
 
 	targetURL := httputil.BuildTargetURLStripped(h.cfg.GetAlistURL(), "/dav"+realPath)
 
+	h.readVerifier.Forget(davPath)
 	if err := h.streamProxy.ProxyUploadEncrypt(w, r, targetURL, passwdInfo, fileSize, startOffset); err != nil {
 		log.Error().Err(err).Str("path", davPath).Msg("WebDAV PUT encryption failed")
-		RespondHTTPErrorWithStatus(w, "Encryption error", http.StatusBadGateway)
+		RespondCodedError(w, errors.CodeEncryptFailed, "Encryption error", http.StatusBadGateway)
 	}
 }
 
+// handlePutEmpty creates an empty file. Windows Explorer and macOS Finder
+// create a file empty before locking and writing it; with no content to
+// encrypt the empty body is forwarded as is, under the encrypted name.
+func (h *WebDAVHandler) handlePutEmpty(w http.ResponseWriter, r *http.Request, davPath string, passwdInfo *config.PasswdInfo) {
+	realPath := davPath
+	if passwdInfo.EncName {
+		storedName, _, err := storedNameFor(h.cfg, passwdInfo, path.Base(davPath), false)
+		if err != nil {
+			RespondCodedError(w, errors.CodeNameTooLong, err.Error(), http.StatusBadRequest)
+			return
+		}
+		realPath = path.Dir(davPath) + "/" + storedName
+		h.fileDAO.SetEncPathMapping(davPath, realPath)
+		h.negCache.Unblock(realPath)
+	}
+	h.readVerifier.Forget(davPath)
+
+	targetURL := httputil.BuildTargetURLStripped(h.cfg.GetAlistURL(), "/dav"+realPath)
+	proxyReq, err := httputil.NewRequest("PUT", targetURL).
+		WithContext(r.Context()).
+		WithBody(nil).
+		CopyHeaders(r).
+		Build()
+	if err != nil {
+		RespondHTTPErrorWithStatus(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := h.getStdClient().Do(proxyReq)
+	if err != nil {
+		log.Error().Err(err).Msg("WebDAV PUT failed")
+		RespondHTTPErrorWithStatus(w, "Proxy error", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	respBody, err := readLimitedBody(resp, maxProxyResponseBody)
+	if err != nil {
+		log.Warn().Err(err).Msg("Upstream response body read failed")
+		RespondCodedError(w, errors.CodeUpstreamTooLarge, "Bad gateway: upstream response too large", http.StatusBadGateway)
+		return
+	}
+	httputil.CopyResponseHeaders(w, resp)
+	w.WriteHeader(resp.StatusCode)
+	w.Write(respBody)
+}
+
 // handleDelete handles DELETE requests with filename encryption
 func (h *WebDAVHandler) handleDelete(w http.ResponseWriter, r *http.Request, davPath string) {
 	passwdInfo, found := h.passwdDAO.FindByPath(davPath)
@@ -589,9 +742,54 @@ func (h *WebDAVHandler) handleDelete(w http.ResponseWriter, r *http.Request, dav
 	respBody, err := readLimitedBody(resp, maxProxyResponseBody)
 	if err != nil {
 		log.Warn().Err(err).Msg("Upstream response body read failed")
-		http.Error(w, "Bad gateway: upstream response too large", http.StatusBadGateway)
+		RespondCodedError(w, errors.CodeUpstreamTooLarge, "Bad gateway: upstream response too large", http.StatusBadGateway)
+		return
+	}
+	httputil.CopyResponseHeaders(w, resp)
+	w.WriteHeader(resp.StatusCode)
+	w.Write(respBody)
+}
+
+// handleMkcol creates a folder. Folder names are never encrypted, so under
+// a filename-encrypting rule the new folder is cached as mapping to itself;
+// otherwise a DELETE or PROPFIND before the next listing would derive an
+// encrypted name for it and miss.
+func (h *WebDAVHandler) handleMkcol(w http.ResponseWriter, r *http.Request, davPath string) {
+	passwdInfo, found := h.passwdDAO.FindByPath(davPath)
+	if !found || !passwdInfo.EncName {
+		h.handlePassthrough(w, r)
+		return
+	}
+
+	targetURL := httputil.BuildTargetURLStripped(h.cfg.GetAlistURL(), "/dav"+davPath)
+	proxyReq, err := httputil.NewRequest("MKCOL", targetURL).
+		WithContext(r.Context()).
+		WithBodyReader(r.Body).
+		CopyHeaders(r).
+		Build()
+	if err != nil {
+		RespondHTTPErrorWithStatus(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := h.getStdClient().Do(proxyReq)
+	if err != nil {
+		log.Error().Err(err).Msg("WebDAV MKCOL failed")
+		RespondHTTPErrorWithStatus(w, "Proxy error", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	respBody, err := readLimitedBody(resp, maxProxyResponseBody)
+	if err != nil {
+		log.Warn().Err(err).Msg("Upstream response body read failed")
+		RespondCodedError(w, errors.CodeUpstreamTooLarge, "Bad gateway: upstream response too large", http.StatusBadGateway)
 		return
 	}
+	if resp.StatusCode == http.StatusCreated {
+		dirPath := path.Clean(davPath)
+		h.fileDAO.SetEncPathMappingWithInfo(dirPath, dirPath, path.Base(dirPath), 0, true)
+	}
 	httputil.CopyResponseHeaders(w, resp)
 	w.WriteHeader(resp.StatusCode)
 	w.Write(respBody)
@@ -607,57 +805,54 @@ func (h *WebDAVHandler) handleCopy(w http.ResponseWriter, r *http.Request, davPa
 	h.handleMoveOrCopy(w, r, davPath, "COPY")
 }
 
-// handleMoveOrCopy handles MOVE/COPY requests with filename encryption
+// handleMoveOrCopy handles MOVE/COPY requests with filename encryption.
+// Overwrite and Depth are validated and forwarded explicitly; Overwrite: F is
+// enforced locally because the upstream only sees encrypted names.
 func (h *WebDAVHandler) handleMoveOrCopy(w http.ResponseWriter, r *http.Request, davPath string, method string) {
-	passwdInfo, found := h.passwdDAO.FindByPath(davPath)
-
-	// Convert source path
-	realSrcPath := davPath
-	if found && passwdInfo.EncName {
-		realSrcPath = h.convertToRealPath(davPath, passwdInfo)
-	}
-
-	// Convert destination path from header
-	destination := r.Header.Get("Destination")
-	if destination != "" {
-		destURL, err := url.Parse(destination)
-		if err == nil {
-			destPath := strings.TrimPrefix(destURL.Path, "/dav")
-			destPasswd, destFound := h.passwdDAO.FindByPath(destPath)
-			if destFound && destPasswd.EncName {
-				converter := encryption.NewFileNameConverter(destPasswd.Password, destPasswd.EncType, destPasswd.EncSuffix)
-				fileName := path.Base(destPath)
-				realDestPath := path.Dir(destPath) + "/" + converter.ToRealName(fileName)
-
-				// Rebuild destination URL
-				destURL.Path = "/dav" + realDestPath
-				destination = destURL.String()
-			}
+	req, status, err := h.parseMoveCopyRequest(r, davPath, method)
+	if err != nil {
+		log.Debug().Err(err).Str("path", davPath).Msgf("Rejected WebDAV %s", method)
+		if _, tooLong := err.(*nameTooLongError); tooLong {
+			RespondCodedError(w, errors.CodeNameTooLong, err.Error(), status)
+			return
 		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	if _, violated := strictPlaintextRule(h.passwdDAO, req.srcPath, req.destPath); violated {
+		RespondCodedError(w, errors.CodeStrictPlaintextWrite, strictPlaintextMessage(req.destPath, method), http.StatusForbidden)
+		return
+	}
+	if !req.overwrite && h.destinationExists(r, req) {
+		http.Error(w, "Destination exists and Overwrite is F", http.StatusPreconditionFailed)
+		return
 	}
 
-	targetURL := httputil.BuildTargetURLStripped(h.cfg.GetAlistURL(), "/dav"+realSrcPath)
+	targetURL := httputil.BuildTargetURLStripped(h.cfg.GetAlistURL(), "/dav"+req.realSrcPath)
 
 	body, err := readLimitedRequestBody(r)
 	if err != nil {
 		log.Warn().Err(err).Msg("Request body read failed")
-		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		RespondHTTPErrorWithStatus(w, "Request body too large", http.StatusRequestEntityTooLarge)
 		return
 	}
+	overwrite := "T"
+	if !req.overwrite {
+		overwrite = "F"
+	}
 	proxyReq, err := httputil.NewRequest(method, targetURL).
 		WithContext(r.Context()).
 		WithBody(body).
-		CopyHeadersExcept(r, "Destination").
+		CopyHeadersExcept(r, "Destination", "Overwrite", "Depth").
+		WithHeader("Destination", req.upstreamDestination(h.cfg.GetAlistURL())).
+		WithHeader("Overwrite", overwrite).
+		WithHeader("Depth", req.depth).
 		Build()
 	if err != nil {
 		RespondHTTPErrorWithStatus(w, "Internal error", http.StatusInternalServerError)
 		return
 	}
 
-	if destination != "" {
-		proxyReq.Header.Set("Destination", destination)
-	}
-
 	resp, err := h.getStdClient().Do(proxyReq)
 	if err != nil {
 		log.Error().Err(err).Msgf("WebDAV %s failed", method)
@@ -669,9 +864,22 @@ func (h *WebDAVHandler) handleMoveOrCopy(w http.ResponseWriter, r *http.Request,
 	respBody, err := readLimitedBody(resp, maxProxyResponseBody)
 	if err != nil {
 		log.Warn().Err(err).Msg("Upstream response body read failed")
-		http.Error(w, "Bad gateway: upstream response too large", http.StatusBadGateway)
+		RespondCodedError(w, errors.CodeUpstreamTooLarge, "Bad gateway: upstream response too large", http.StatusBadGateway)
 		return
 	}
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusNoContent:
+		h.applyMoveCopyResult(req)
+	case http.StatusMultiStatus:
+		// Partial failure: some members may have moved, so drop cached state
+		// for both ends and let the next listing rebuild it.
+		if h.fileDAO != nil {
+			h.fileDAO.InvalidateDisplayPath(req.srcPath)
+			h.fileDAO.InvalidateDisplayPath(req.destPath)
+		}
+		respBody = h.decryptMultiStatusHrefs(respBody, req)
+		resp.Header.Del("Content-Length")
+	}
 	httputil.CopyResponseHeaders(w, resp)
 	w.WriteHeader(resp.StatusCode)
 	w.Write(respBody)
@@ -684,6 +892,7 @@ func (h *WebDAVHandler) handleMoveOrCopy(w http.ResponseWriter, r *http.Request,
 func (h *WebDAVHandler) handlePropfind(w http.ResponseWriter, r *http.Request, davPath string) {
 	trace.Logf(r.Context(), "propfind", "Listing: %s", davPath)
 	startAt := time.Now()
+	w = withCacheControl(w, cacheControlFor(h.cfg, config.CacheTargetList, davPath))
 
 	passwdInfo, found := h.passwdDAO.FindByPath(davPath)
 	ruleSource := "FindByPath"
@@ -702,7 +911,7 @@ func (h *WebDAVHandler) handlePropfind(w http.ResponseWriter, r *http.Request, d
 	body, err := readLimitedRequestBody(r)
 	if err != nil {
 		log.Warn().Err(err).Msg("Request body read failed")
-		http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		RespondHTTPErrorWithStatus(w, "Request body too large", http.StatusRequestEntityTooLarge)
 		return
 	}
 
@@ -761,8 +970,13 @@ func (h *WebDAVHandler) handlePropfind(w http.ResponseWriter, r *http.Request, d
 
 		fileName := path.Base(davPath)
 		if fileName != "" && fileName != "/" && fileName != "." {
-			// Convert to encrypted path and retry
+			// Convert to encrypted path and retry. When that is what was
+			// just asked for, try the plain name: folder names are never
+			// encrypted, and a folder not listed yet gets a derived path.
 			realPath := h.convertToRealPath(davPath, passwdInfo)
+			if realPath == requestPath {
+				realPath = davPath
+			}
 			retryURL := httputil.BuildTargetURLStripped(h.cfg.GetAlistURL(), "/dav"+realPath)
 
 			trace.Logf(r.Context(), "propfind", "404 retry: request=%s retry=%s rule=%s", requestPath, realPath, ruleSource)
@@ -788,7 +1002,7 @@ func (h *WebDAVHandler) handlePropfind(w http.ResponseWriter, r *http.Request, d
 	respBody, err := readLimitedBody(resp, maxProxyResponseBody)
 	if err != nil {
 		log.Warn().Err(err).Msg("Upstream response body read failed")
-		http.Error(w, "Bad gateway: upstream response too large", http.StatusBadGateway)
+		RespondCodedError(w, errors.CodeUpstreamTooLarge, "Bad gateway: upstream response too large", http.StatusBadGateway)
 		return
 	}
 	upstreamCost := time.Since(startAt)
@@ -798,6 +1012,16 @@ func (h *WebDAVHandler) handlePropfind(w http.ResponseWriter, r *http.Request, d
 	entries := h.parsePropfindResponse(r.Context(), respBody, davPath)
 	parseCost := time.Since(parseStart)
 
+	// Cut huge listings to one page before decrypting, so only the names
+	// sent are decrypted. The full listing was cached above.
+	var page propfindPage
+	if limit := h.cfg.AlistServer.WebDAVPropfindLimit; limit > 0 && resp.StatusCode == http.StatusMultiStatus && r.Header.Get("Depth") != "0" {
+		respBody, page = pagePropfindBody(respBody, limit, propfindPageNumber(r))
+		if page.total > 0 {
+			trace.Logf(r.Context(), "propfind", "Paged listing: page=%d limit=%d total=%d", page.page, limit, page.total)
+		}
+	}
+
 	// Step 4: Decrypt filenames in the XML response if encryption is enabled
 	decryptStart := time.Now()
 	if found && passwdInfo.EncName && resp.StatusCode == http.StatusMultiStatus {
@@ -816,12 +1040,15 @@ func (h *WebDAVHandler) handlePropfind(w http.ResponseWriter, r *http.Request, d
 	// Copy response headers (recalculate Content-Length since body may have changed)
 	httputil.CopyResponseHeaders(w, resp, "Content-Length")
 	w.Header().Set("Content-Length", strconv.Itoa(len(respBody)))
+	if page.total > 0 {
+		page.setHeaders(w, r)
+	}
 	w.WriteHeader(resp.StatusCode)
 	w.Write(respBody)
 }
 
 func (h *WebDAVHandler) probePath(ctx context.Context, dirPath string) []propfindEntry {
-	requestPath := normalizeProbeDirPath(dirPath)
+	requestPath := pathutil.DirSlash(dirPath)
 	if requestPath == "" {
 		return nil
 	}
@@ -906,6 +1133,18 @@ func (c *negativePathCache) Block(path string) {
 	c.data[path] = time.Now().Add(c.ttl)
 }
 
+// Unblock forgets paths that have just been created.
+func (c *negativePathCache) Unblock(paths ...string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, p := range paths {
+		delete(c.data, p)
+	}
+}
+
 // handlePassthrough passes requests directly to Alist
 func (h *WebDAVHandler) handlePassthrough(w http.ResponseWriter, r *http.Request) {
 	targetURL := httputil.BuildTargetURL(h.cfg.GetAlistURL(), r.URL.Path, r)
@@ -1010,7 +1249,7 @@ func (h *WebDAVHandler) parsePropfindResponse(ctx context.Context, body []byte,
 		if h.passwdDAO != nil {
 			if passwdInfo, found := h.passwdDAO.FindByPath(entry.Path); found && passwdInfo != nil && passwdInfo.EncName {
 				allowLoose := h.cfg != nil && h.cfg.AlistServer.AllowLooseDecode
-				if decryptedName := encryption.ConvertShowNameWithSuffixOptions(passwdInfo.Password, passwdInfo.EncType, entry.Name, passwdInfo.EncSuffix, allowLoose); decryptedName != "" && decryptedName != entry.Name {
+				if decryptedName := passwdInfo.NameConverter().ShowName(entry.Name, allowLoose); decryptedName != "" && decryptedName != entry.Name {
 					displayName = decryptedName
 					displayPath = path.Join(path.Dir(entry.Path), decryptedName)
 				}
@@ -1018,10 +1257,11 @@ func (h *WebDAVHandler) parsePropfindResponse(ctx context.Context, body []byte,
 		}
 
 		info := &dao.FileInfo{
-			Path:  displayPath,
-			Name:  displayName,
-			Size:  entry.Size,
-			IsDir: entry.IsDir,
+			Path:          displayPath,
+			EncryptedPath: encryptedPath,
+			Name:          displayName,
+			Size:          entry.Size,
+			IsDir:         entry.IsDir,
 		}
 
 		if persistToStore {
@@ -1132,24 +1372,6 @@ func extractAuthorizationValue(raw string) string {
 	return value
 }
 
-func normalizeProbeDirPath(dirPath string) string {
-	trimmed := strings.TrimSpace(dirPath)
-	if trimmed == "" {
-		return ""
-	}
-	if !strings.HasPrefix(trimmed, "/") {
-		trimmed = "/" + trimmed
-	}
-	clean := path.Clean(trimmed)
-	if clean == "." {
-		clean = "/"
-	}
-	if clean != "/" {
-		clean = strings.TrimRight(clean, "/") + "/"
-	}
-	return clean
-}
-
 func isStrictWebDAVRawURLFailure(statusCode int) bool {
 	switch statusCode {
 	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
@@ -1230,10 +1452,9 @@ func (h *WebDAVHandler) decryptPropfindResponse(body []byte, passwdInfo *config.
 		switch bestKind {
 		case 0: // displayname
 			if content != "" && content != "/" {
-				decryptedName := encryption.ConvertShowNameWithSuffixOptions(
-					passwdInfo.Password, passwdInfo.EncType, content, passwdInfo.EncSuffix, allowLoose)
+				decryptedName := passwdInfo.NameConverter().ShowName(content, allowLoose)
 				if decryptedName != "" && decryptedName != content {
-					b.WriteString(decryptedName)
+					b.WriteString(xmlText(decryptedName))
 					b.WriteString(bestEndTag)
 					searchPos = bestEnd + len(bestEndTag)
 					continue
@@ -1252,8 +1473,7 @@ func (h *WebDAVHandler) decryptPropfindResponse(body []byte, passwdInfo *config.
 				if decodedPath != "/" && decodedPath != "" {
 					fileName := path.Base(decodedPath)
 					if fileName != "" && fileName != "/" && fileName != "." {
-						decryptedName := encryption.ConvertShowNameWithSuffixOptions(
-							passwdInfo.Password, passwdInfo.EncType, fileName, passwdInfo.EncSuffix, allowLoose)
+						decryptedName := passwdInfo.NameConverter().ShowName(fileName, allowLoose)
 						if decryptedName != "" && !encryption.IsOriginalFile(decryptedName) && decryptedName != fileName {
 							displayPath := path.Dir(decodedPath) + "/" + decryptedName
 							h.fileDAO.SetEncPathMapping(displayPath, decodedPath)
@@ -1262,7 +1482,7 @@ func (h *WebDAVHandler) decryptPropfindResponse(body []byte, passwdInfo *config.
 									displayPath, decodedPath, decryptedName, fileInfo.Size, fileInfo.IsDir)
 							}
 							origName := path.Base(content)
-							decHref := strings.TrimSuffix(content, origName) + decryptedName
+							decHref := strings.TrimSuffix(content, origName) + xmlText(url.PathEscape(decryptedName))
 							b.WriteString(decHref)
 							b.WriteString(bestEndTag)
 							searchPos = bestEnd + len(bestEndTag)
@@ -1410,6 +1630,13 @@ func (h *WebDAVHandler) adjustPropfindContentLengthForV2(xmlStr string) string {
 	return result
 }
 
+// xmlText escapes s for use as XML character data.
+func xmlText(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
 // decryptXMLElements decrypts content between XML tags (for displayname)
 func (h *WebDAVHandler) decryptXMLElements(xmlStr, startTag, endTag string, passwdInfo *config.PasswdInfo) string {
 	result := xmlStr
@@ -1433,7 +1660,7 @@ func (h *WebDAVHandler) decryptXMLElements(xmlStr, startTag, endTag string, pass
 
 		if encryptedName != "" && encryptedName != "/" {
 			allowLoose := h.cfg != nil && h.cfg.AlistServer.AllowLooseDecode
-			decryptedName := encryption.ConvertShowNameWithSuffixOptions(passwdInfo.Password, passwdInfo.EncType, encryptedName, passwdInfo.EncSuffix, allowLoose)
+			decryptedName := passwdInfo.NameConverter().ShowName(encryptedName, allowLoose)
 			if decryptedName != "" && decryptedName != encryptedName {
 				result = result[:contentStart] + decryptedName + result[endIdx:]
 				searchPos = contentStart + len(decryptedName) + len(endTag)
@@ -1480,7 +1707,7 @@ func (h *WebDAVHandler) decryptHrefElements(xmlStr, startTag, endTag string, pas
 				fileName := path.Base(decodedPath)
 				if fileName != "" && fileName != "/" && fileName != "." {
 					allowLoose := h.cfg != nil && h.cfg.AlistServer.AllowLooseDecode
-					decryptedName := encryption.ConvertShowNameWithSuffixOptions(passwdInfo.Password, passwdInfo.EncType, fileName, passwdInfo.EncSuffix, allowLoose)
+					decryptedName := passwdInfo.NameConverter().ShowName(fileName, allowLoose)
 					if decryptedName != "" && !encryption.IsOriginalFile(decryptedName) && decryptedName != fileName {
 						// Save mapping: display path -> encrypted path (use decoded path)
 						displayPath := path.Dir(decodedPath) + "/" + decryptedName
@@ -1498,7 +1725,7 @@ func (h *WebDAVHandler) decryptHrefElements(xmlStr, startTag, endTag string, pas
 						// Replace only the filename part in the href
 						newHref := "/dav" + path.Dir(decodedPath) + "/" + url.PathEscape(decryptedName)
 						// Normalize path (remove double slashes)
-						newHref = httputil.CleanPath(newHref)
+						newHref = xmlText(httputil.CleanPath(newHref))
 						result = result[:contentStart] + newHref + result[endIdx:]
 						searchPos = contentStart + len(newHref) + len(endTag)
 						continue
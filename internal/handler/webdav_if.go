@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/errors"
+	"github.com/alist-encrypt-go/internal/httputil"
+)
+
+// realLockPath maps a display path to the upstream path used for lock-aware
+// requests. Collections keep their name: only file names are encrypted.
+func (h *WebDAVHandler) realLockPath(davPath string) (string, bool) {
+	if davPath == "" || davPath == "/" || strings.HasSuffix(davPath, "/") {
+		return davPath, false
+	}
+	passwdInfo, found := h.passwdDAO.FindByPath(davPath)
+	if !found || !passwdInfo.EncName {
+		return davPath, false
+	}
+	if h.fileDAO != nil {
+		if info, ok := h.fileDAO.Get(davPath); ok && info != nil && info.IsDir {
+			return davPath, false
+		}
+	}
+	realPath := h.convertToRealPath(davPath, passwdInfo)
+	return realPath, realPath != davPath
+}
+
+// rewriteIfHeader translates the resource tags of an If header (RFC 4918
+// §10.4) from display paths to encrypted upstream paths. Lock tokens and
+// ETags inside the condition lists are left alone; without this the upstream
+// evaluates the condition against a resource that does not exist and rejects
+// every write to a locked file.
+func (h *WebDAVHandler) rewriteIfHeader(r *http.Request) {
+	value := r.Header.Get("If")
+	if value == "" {
+		return
+	}
+	if rewritten := h.rewriteIfHeaderValue(value); rewritten != value {
+		log.Debug().Str("original", value).Str("rewritten", rewritten).Msg("WebDAV If header rewritten")
+		r.Header.Set("If", rewritten)
+	}
+}
+
+func (h *WebDAVHandler) rewriteIfHeaderValue(value string) string {
+	var out strings.Builder
+	depth := 0
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case c == '<' && depth == 0:
+			end := strings.IndexByte(value[i+1:], '>')
+			if end < 0 {
+				out.WriteString(value[i:])
+				return out.String()
+			}
+			tag := value[i+1 : i+1+end]
+			out.WriteByte('<')
+			out.WriteString(h.realIfResource(tag))
+			out.WriteByte('>')
+			i += end + 1
+			continue
+		}
+		out.WriteByte(c)
+	}
+	return out.String()
+}
+
+// realIfResource rewrites one resource tag, which may be an absolute URL or
+// an absolute path. Tags outside /dav are returned unchanged.
+func (h *WebDAVHandler) realIfResource(tag string) string {
+	u, err := url.Parse(tag)
+	if err != nil || (u.Path != "/dav" && !strings.HasPrefix(u.Path, "/dav/")) {
+		return tag
+	}
+	realPath, changed := h.realLockPath(strings.TrimPrefix(u.Path, "/dav"))
+	if !changed {
+		return tag
+	}
+	u.Path = "/dav" + realPath
+	u.RawPath = ""
+	return u.String()
+}
+
+// handleLockAware forwards LOCK, UNLOCK and PROPPATCH against the encrypted
+// upstream path and decrypts hrefs (e.g. lockroot) in the response.
+func (h *WebDAVHandler) handleLockAware(w http.ResponseWriter, r *http.Request, davPath string) {
+	realPath, changed := h.realLockPath(davPath)
+	if !changed {
+		h.handlePassthrough(w, r)
+		return
+	}
+	passwdInfo, _ := h.passwdDAO.FindByPath(davPath)
+	targetURL := httputil.BuildTargetURLStripped(h.cfg.GetAlistURL(), "/dav"+realPath)
+
+	body, err := readLimitedRequestBody(r)
+	if err != nil {
+		log.Warn().Err(err).Msg("Request body read failed")
+		RespondHTTPErrorWithStatus(w, "Request body too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	proxyReq, err := httputil.NewRequest(r.Method, targetURL).
+		WithContext(r.Context()).
+		WithBody(body).
+		CopyHeaders(r).
+		Build()
+	if err != nil {
+		RespondHTTPErrorWithStatus(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := h.getStdClient().Do(proxyReq)
+	if err != nil {
+		log.Error().Err(err).Msgf("WebDAV %s failed", r.Method)
+		RespondHTTPErrorWithStatus(w, "Proxy error", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	respBody, err := readLimitedBody(resp, maxProxyResponseBody)
+	if err != nil {
+		log.Warn().Err(err).Msg("Upstream response body read failed")
+		RespondCodedError(w, errors.CodeUpstreamTooLarge, "Bad gateway: upstream response too large", http.StatusBadGateway)
+		return
+	}
+	if len(respBody) > 0 {
+		// The locked resource itself is known; other hrefs are decoded by name.
+		out := string(respBody)
+		for _, pair := range [][2]string{
+			{(&url.URL{Path: "/dav" + realPath}).EscapedPath(), (&url.URL{Path: "/dav" + davPath}).EscapedPath()},
+			{"/dav" + realPath, (&url.URL{Path: "/dav" + davPath}).EscapedPath()},
+		} {
+			out = strings.ReplaceAll(out, ">"+pair[0]+"<", ">"+pair[1]+"<")
+		}
+		for _, tag := range [][2]string{{`<D:href>`, `</D:href>`}, {`<d:href>`, `</d:href>`}, {`<href>`, `</href>`}} {
+			out = h.decryptHrefElements(out, tag[0], tag[1], passwdInfo)
+		}
+		respBody = []byte(out)
+		resp.Header.Del("Content-Length")
+	}
+	httputil.CopyResponseHeaders(w, resp)
+	w.WriteHeader(resp.StatusCode)
+	w.Write(respBody)
+}
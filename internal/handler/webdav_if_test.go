@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/dao"
+)
+
+func TestWebDAVLockAndIfHeaderUseEncryptedPaths(t *testing.T) {
+	passwd := config.PasswdInfo{
+		Password: "testpass",
+		EncType:  "aesctr",
+		Enable:   true,
+		EncName:  true,
+		EncPath:  []string{"/enc/*"},
+	}
+	var seenPath, seenIf string
+	srv := newSocketTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seenPath, seenIf = r.URL.Path, r.Header.Get("If")
+		if r.Method == "LOCK" {
+			w.Header().Set("Lock-Token", "<opaquelocktoken:abc>")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte(`<D:prop xmlns:D="DAV:"><D:lockdiscovery><D:activelock><D:lockroot><D:href>/dav/enc/ENCDOC.docx</D:href></D:lockroot></D:activelock></D:lockdiscovery></D:prop>`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	global := config.Get()
+	original := global.AlistServer.PasswdList
+	global.AlistServer.PasswdList = []config.PasswdInfo{passwd}
+	t.Cleanup(func() { global.AlistServer.PasswdList = original })
+
+	h := newProbeTestHandler(t, srv.URL)
+	h.passwdDAO = dao.NewPasswdDAO(nil)
+	h.fileDAO.SetEncPathMapping("/enc/report.docx", "/enc/ENCDOC.docx")
+
+	req := httptest.NewRequest("LOCK", "http://proxy.local/dav/enc/report.docx", strings.NewReader("<lockinfo/>"))
+	rec := httptest.NewRecorder()
+	h.Handle(rec, req)
+	if rec.Code != http.StatusOK || seenPath != "/dav/enc/ENCDOC.docx" {
+		t.Fatalf("LOCK status=%d upstream path=%q", rec.Code, seenPath)
+	}
+	if rec.Header().Get("Lock-Token") != "<opaquelocktoken:abc>" {
+		t.Fatalf("Lock-Token not forwarded: %q", rec.Header().Get("Lock-Token"))
+	}
+	if strings.Contains(rec.Body.String(), "ENCDOC") {
+		t.Fatalf("lockroot href not decrypted: %s", rec.Body.String())
+	}
+
+	req = httptest.NewRequest("DELETE", "http://proxy.local/dav/enc/report.docx", nil)
+	req.Header.Set("If", `<http://proxy.local/dav/enc/report.docx> (<opaquelocktoken:abc> ["etag<1>"]) </dav/plain/> (Not <urn:x>)`)
+	rec = httptest.NewRecorder()
+	h.Handle(rec, req)
+	want := `<http://proxy.local/dav/enc/ENCDOC.docx> (<opaquelocktoken:abc> ["etag<1>"]) </dav/plain/> (Not <urn:x>)`
+	if seenIf != want {
+		t.Fatalf("If=%q\nwant %q", seenIf, want)
+	}
+}
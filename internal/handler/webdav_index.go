@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// webdavIndexTemplate is deliberately bare: the page exists to check that
+// names and sizes decrypt, not to replace a file manager.
+var webdavIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Index of {{.Path}}</title>
+<style>body{font-family:sans-serif;margin:2em}td{padding:2px 1.5em 2px 0}td.size{text-align:right;font-family:monospace}</style>
+</head><body>
+<h1>Index of {{.Path}}</h1>
+<table>
+{{if .Parent}}<tr><td><a href="{{.Parent}}">../</a></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{.Href}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td><td class="size">{{.Size}}</td></tr>
+{{end}}</table>
+{{if .Next}}<p>Showing page {{.Page}} of a folder with {{.Total}} entries. <a href="{{.Next}}">Next page</a></p>
+{{end}}</body></html>
+`))
+
+type webdavIndexEntry struct {
+	Href  string
+	Name  string
+	Size  string
+	IsDir bool
+}
+
+// wantsHTMLIndex reports whether a GET should be answered with a rendered
+// directory listing instead of being proxied as a file download.
+func (h *WebDAVHandler) wantsHTMLIndex(r *http.Request, davPath string) bool {
+	if h.cfg == nil || !h.cfg.AlistServer.WebDAVHTMLIndex || r.Method != http.MethodGet {
+		return false
+	}
+	if strings.HasSuffix(davPath, "/") {
+		return true
+	}
+	info, ok := h.fileDAO.Get(davPath)
+	return ok && info.IsDir
+}
+
+// handleHTMLIndex runs a Depth: 1 PROPFIND through the regular decrypting
+// PROPFIND path and renders the result as a plain HTML page.
+func (h *WebDAVHandler) handleHTMLIndex(w http.ResponseWriter, r *http.Request, davPath string) {
+	req := r.Clone(r.Context())
+	req.Method = "PROPFIND"
+	req.Header.Set("Depth", "1")
+	req.Header.Del("Range")
+	req.Header.Del("Accept-Encoding")
+	req.Header.Del("Content-Type")
+	req.Body = http.NoBody
+	req.ContentLength = 0
+
+	// Reuse the staging recorder as a plain buffer for the multistatus body.
+	rec := newStagedUploadRecorder()
+	h.handlePropfind(rec, req, davPath)
+	if rec.status != http.StatusMultiStatus {
+		rec.flush(w)
+		return
+	}
+
+	dirPath := "/" + strings.Trim(davPath, "/")
+	var entries []webdavIndexEntry
+	for _, entry := range h.parsePropfindEntries(rec.body.Bytes()) {
+		entryPath := "/" + strings.Trim(entry.Path, "/")
+		if entryPath == dirPath {
+			continue
+		}
+		// Folder names are never encrypted, so their displayname only picks up
+		// the orig_ marker; files keep it to flag names that failed to decode.
+		name := entry.Name
+		if name == "" || entry.IsDir {
+			name = path.Base(entryPath)
+		}
+		href := webdavIndexHref(entryPath, entry.IsDir)
+		size := ""
+		if !entry.IsDir {
+			size = strconv.FormatInt(entry.Size, 10)
+		}
+		entries = append(entries, webdavIndexEntry{Href: href, Name: name, Size: size, IsDir: entry.IsDir})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].IsDir != entries[j].IsDir {
+			return entries[i].IsDir
+		}
+		return strings.ToLower(entries[i].Name) < strings.ToLower(entries[j].Name)
+	})
+
+	parent := ""
+	if dirPath != "/" {
+		parent = webdavIndexHref(path.Dir(dirPath), true)
+	}
+	var page bytes.Buffer
+	next := ""
+	if rec.header.Get("Link") != "" {
+		next = propfindPageURL(r, propfindPageNumber(r)+1)
+	}
+	if err := webdavIndexTemplate.Execute(&page, map[string]interface{}{
+		"Path":    dirPath,
+		"Parent":  parent,
+		"Entries": entries,
+		"Next":    next,
+		"Page":    rec.header.Get("X-Propfind-Page"),
+		"Total":   rec.header.Get("X-Propfind-Total"),
+	}); err != nil {
+		log.Error().Err(err).Str("path", davPath).Msg("WebDAV index render failed")
+		RespondHTTPErrorWithStatus(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Length", strconv.Itoa(page.Len()))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(page.Bytes())
+}
+
+func webdavIndexHref(displayPath string, isDir bool) string {
+	href := (&url.URL{Path: path.Join("/dav", displayPath)}).EscapedPath()
+	if isDir && !strings.HasSuffix(href, "/") {
+		href += "/"
+	}
+	return href
+}
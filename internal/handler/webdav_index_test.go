@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/encryption"
+)
+
+func TestWebDAVDirectoryGetRendersDecryptedIndex(t *testing.T) {
+	cfg := config.Get()
+	original := cfg.AlistServer
+	t.Cleanup(func() {
+		cfg.AlistServer = original
+	})
+	passwd := config.PasswdInfo{Password: "123456", EncType: "aesctr", EncName: true, Enable: true, EncPath: []string{"/encrypt/*"}}
+	cfg.AlistServer.PasswdList = []config.PasswdInfo{passwd}
+	encName := encryption.NewFileNameConverter(passwd.Password, passwd.EncType, "").ToRealName("holiday 2024.mp4")
+
+	var methods []string
+	backend := newSocketTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method+" "+r.Header.Get("Depth"))
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusMultiStatus)
+		_, _ = w.Write([]byte(buildProbeMultistatus([]probeResponse{
+			{href: "/dav/encrypt/", isDir: true},
+			{href: "/dav/encrypt/" + encName, size: 1234},
+			{href: "/dav/encrypt/photos/", isDir: true},
+		})))
+	}))
+	defer backend.Close()
+
+	h := newProbeTestHandler(t, backend.URL)
+	h.cfg.AlistServer.WebDAVHTMLIndex = true
+	rec := httptest.NewRecorder()
+	h.Handle(rec, httptest.NewRequest(http.MethodGet, "/dav/encrypt/", nil))
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+		t.Fatalf("status=%d type=%s body=%s", rec.Code, rec.Header().Get("Content-Type"), body)
+	}
+	if len(methods) != 1 || methods[0] != "PROPFIND 1" {
+		t.Fatalf("upstream saw %v", methods)
+	}
+	for _, want := range []string{
+		`<a href="/dav/encrypt/holiday%202024.mp4">holiday 2024.mp4</a>`,
+		`<a href="/dav/encrypt/photos/">photos/</a>`,
+		`<a href="/dav/">../</a>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("index missing %s:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, encName) || strings.Index(body, "photos/") > strings.Index(body, "holiday") {
+		t.Fatalf("index leaks encrypted names or lists files before folders:\n%s", body)
+	}
+}
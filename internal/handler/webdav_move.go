@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/httputil"
+)
+
+// moveCopyRequest is the translated form of a WebDAV MOVE/COPY request.
+type moveCopyRequest struct {
+	method       string
+	srcPath      string
+	realSrcPath  string
+	destPath     string
+	realDestPath string
+	destURL      *url.URL
+	destPasswd   *config.PasswdInfo
+	destEncName  bool
+	overwrite    bool
+	depth        string
+}
+
+// parseOverwriteHeader implements RFC 4918 §10.6: absent means "T".
+func parseOverwriteHeader(value string) (bool, error) {
+	switch strings.TrimSpace(value) {
+	case "", "T", "t":
+		return true, nil
+	case "F", "f":
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid Overwrite header %q", value)
+	}
+}
+
+// parseMoveCopyDepth normalizes Depth for MOVE/COPY. COPY accepts 0 or
+// infinity; MOVE on a collection must always act as Depth: infinity.
+func parseMoveCopyDepth(method, value string) (string, error) {
+	depth := strings.ToLower(strings.TrimSpace(value))
+	switch depth {
+	case "", "infinity":
+		return "infinity", nil
+	case "0":
+		if method == "COPY" {
+			return "0", nil
+		}
+	}
+	return "", fmt.Errorf("invalid Depth %q for %s", value, method)
+}
+
+// parseMoveCopyRequest validates headers and resolves both ends of the
+// operation to their upstream (encrypted) paths. The returned status is the
+// HTTP status to reply with when err is non-nil.
+func (h *WebDAVHandler) parseMoveCopyRequest(r *http.Request, davPath, method string) (*moveCopyRequest, int, error) {
+	overwrite, err := parseOverwriteHeader(r.Header.Get("Overwrite"))
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+	depth, err := parseMoveCopyDepth(method, r.Header.Get("Depth"))
+	if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	destination := r.Header.Get("Destination")
+	if destination == "" {
+		return nil, http.StatusBadRequest, fmt.Errorf("missing Destination header")
+	}
+	destURL, err := url.Parse(destination)
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid Destination header: %w", err)
+	}
+	if destURL.Path != "/dav" && !strings.HasPrefix(destURL.Path, "/dav/") {
+		// Destination outside this WebDAV namespace is a cross-server request.
+		return nil, http.StatusBadGateway, fmt.Errorf("destination %q is outside /dav", destURL.Path)
+	}
+	destPath := strings.TrimPrefix(destURL.Path, "/dav")
+	if destPath == "" {
+		destPath = "/"
+	}
+	if path.Clean(destPath) == path.Clean(davPath) {
+		return nil, http.StatusForbidden, fmt.Errorf("source and destination are the same")
+	}
+
+	req := &moveCopyRequest{
+		method:       method,
+		srcPath:      davPath,
+		realSrcPath:  davPath,
+		destPath:     destPath,
+		realDestPath: destPath,
+		destURL:      destURL,
+		overwrite:    overwrite,
+		depth:        depth,
+	}
+	if passwdInfo, found := h.passwdDAO.FindByPath(davPath); found && passwdInfo.EncName {
+		req.realSrcPath = h.convertToRealPath(davPath, passwdInfo)
+	}
+	if destPasswd, found := h.passwdDAO.FindByPath(destPath); found && destPasswd.EncName {
+		// Resolving through the mapping cache makes an overwrite land on the
+		// existing encrypted object instead of creating a sibling with a
+		// freshly derived name.
+		req.realDestPath = h.convertToRealPath(destPath, destPasswd)
+		if limit := h.cfg.NameByteLimit(destPasswd); limit > 0 && len(path.Base(req.realDestPath)) > limit {
+			return nil, http.StatusBadRequest, &nameTooLongError{Name: path.Base(destPath), Bytes: len(path.Base(req.realDestPath)), Limit: limit}
+		}
+		req.destPasswd = destPasswd
+		req.destEncName = true
+	}
+	return req, 0, nil
+}
+
+// destinationExists checks the mapping cache first and falls back to a
+// Depth: 0 PROPFIND against the upstream encrypted path.
+func (h *WebDAVHandler) destinationExists(r *http.Request, req *moveCopyRequest) bool {
+	if h.fileDAO != nil {
+		if _, ok := h.fileDAO.GetEncPath(req.destPath); ok {
+			return true
+		}
+		if h.fileDAO.HasEncryptedPath(req.realDestPath) {
+			return true
+		}
+	}
+
+	targetURL := httputil.BuildTargetURLStripped(h.cfg.GetAlistURL(), "/dav"+req.realDestPath)
+	builder := httputil.NewRequest("PROPFIND", targetURL).
+		WithContext(r.Context()).
+		WithHeader("Depth", "0")
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		builder = builder.WithHeader("Authorization", auth)
+	}
+	probeReq, err := builder.Build()
+	if err != nil {
+		return false
+	}
+	resp, err := h.getShortClient().Do(probeReq)
+	if err != nil {
+		log.Debug().Err(err).Str("path", req.realDestPath).Msg("WebDAV destination existence check failed")
+		return false
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode == http.StatusMultiStatus || resp.StatusCode == http.StatusOK
+}
+
+// upstreamDestination rebuilds the Destination header against the encrypted
+// path. An absolute Destination names the proxy's host as the client sees it;
+// it is moved onto alistURL, since WebDAV servers reject a Destination on a
+// host other than their own.
+func (req *moveCopyRequest) upstreamDestination(alistURL string) string {
+	destURL := *req.destURL
+	destURL.Path = "/dav" + req.realDestPath
+	destURL.RawPath = ""
+	if destURL.Host != "" {
+		if base, err := url.Parse(alistURL); err == nil && base.Host != "" {
+			destURL.Scheme = base.Scheme
+			destURL.Host = base.Host
+			destURL.Path = strings.TrimSuffix(base.Path, "/") + destURL.Path
+		}
+	}
+	return destURL.String()
+}
+
+// applyMoveCopyResult keeps the mapping cache in step with a successful
+// MOVE/COPY so later lookups of either path resolve correctly.
+func (h *WebDAVHandler) applyMoveCopyResult(req *moveCopyRequest) {
+	if h.fileDAO == nil {
+		return
+	}
+	if req.method == "MOVE" {
+		h.fileDAO.Forget(req.srcPath)
+	}
+	h.fileDAO.Revive(req.destPath)
+	h.fileDAO.InvalidateDisplayPath(req.destPath)
+	h.negCache.Unblock(req.destPath, req.realDestPath)
+	if req.method == "MOVE" {
+		if h.probe != nil {
+			h.probe.InvalidateWarm(req.srcPath, "webdav_move_source")
+		}
+	}
+	if req.destEncName {
+		h.fileDAO.SetEncPathMapping(req.destPath, req.realDestPath)
+	}
+}
+
+// decryptMultiStatusHrefs rewrites encrypted names in a 207 error body.
+func (h *WebDAVHandler) decryptMultiStatusHrefs(body []byte, req *moveCopyRequest) []byte {
+	if !req.destEncName {
+		return body
+	}
+	out := string(body)
+	for _, tag := range [][2]string{{`<D:href>`, `</D:href>`}, {`<d:href>`, `</d:href>`}, {`<href>`, `</href>`}} {
+		out = h.decryptHrefElements(out, tag[0], tag[1], req.destPasswd)
+	}
+	return []byte(out)
+}
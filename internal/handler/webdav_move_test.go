@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/dao"
+)
+
+func TestParseOverwriteAndDepthHeaders(t *testing.T) {
+	for value, want := range map[string]bool{"": true, "T": true, "F": false} {
+		got, err := parseOverwriteHeader(value)
+		if err != nil || got != want {
+			t.Fatalf("Overwrite %q: got %v err=%v", value, got, err)
+		}
+	}
+	if _, err := parseOverwriteHeader("yes"); err == nil {
+		t.Fatal("expected invalid Overwrite to be rejected")
+	}
+
+	if depth, err := parseMoveCopyDepth("COPY", "0"); err != nil || depth != "0" {
+		t.Fatalf("COPY Depth 0: %q err=%v", depth, err)
+	}
+	if depth, err := parseMoveCopyDepth("MOVE", ""); err != nil || depth != "infinity" {
+		t.Fatalf("MOVE default depth: %q err=%v", depth, err)
+	}
+	if _, err := parseMoveCopyDepth("MOVE", "0"); err == nil {
+		t.Fatal("MOVE must reject Depth: 0")
+	}
+	if _, err := parseMoveCopyDepth("COPY", "1"); err == nil {
+		t.Fatal("COPY must reject Depth: 1")
+	}
+}
+
+func TestWebDAVMoveOverwriteFalseReturns412WhenDestinationExists(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		methods []string
+	)
+	srv := newSocketTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		methods = append(methods, r.Method)
+		mu.Unlock()
+		if r.Method == "PROPFIND" {
+			w.WriteHeader(http.StatusMultiStatus)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	h := newProbeTestHandler(t, srv.URL)
+	req := httptest.NewRequest("MOVE", "http://proxy.local/dav/plain/a.txt", nil)
+	req.Header.Set("Destination", "http://proxy.local/dav/plain/b.txt")
+	req.Header.Set("Overwrite", "F")
+	rec := httptest.NewRecorder()
+	h.Handle(rec, req)
+
+	if rec.Code != http.StatusPreconditionFailed {
+		t.Fatalf("status=%d, want 412", rec.Code)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(methods) != 1 || methods[0] != "PROPFIND" {
+		t.Fatalf("MOVE must not be forwarded, upstream saw %v", methods)
+	}
+}
+
+func TestWebDAVMoveTranslatesEncryptedDestination(t *testing.T) {
+	passwd := config.PasswdInfo{
+		Password: "testpass",
+		EncType:  "aesctr",
+		Enable:   true,
+		EncName:  true,
+		EncPath:  []string{"/enc/*"},
+	}
+	var seen http.Header
+	srv := newSocketTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = r.Header.Clone()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer srv.Close()
+
+	global := config.Get()
+	original := global.AlistServer.PasswdList
+	global.AlistServer.PasswdList = []config.PasswdInfo{passwd}
+	t.Cleanup(func() { global.AlistServer.PasswdList = original })
+
+	h := newProbeTestHandler(t, srv.URL)
+	h.passwdDAO = dao.NewPasswdDAO(nil)
+	h.fileDAO.SetEncPathMapping("/enc/old.mp4", "/enc/ENCOLD.mp4")
+	h.fileDAO.SetEncPathMapping("/enc/new.mp4", "/enc/ENCNEW.mp4")
+
+	req := httptest.NewRequest("MOVE", "http://proxy.local/dav/enc/old.mp4", nil)
+	req.Header.Set("Destination", "http://proxy.local/dav/enc/new.mp4")
+	rec := httptest.NewRecorder()
+	h.Handle(rec, req)
+
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status=%d body=%s", rec.Code, rec.Body.String())
+	}
+	if got := seen.Get("Destination"); got != srv.URL+"/dav/enc/ENCNEW.mp4" {
+		t.Fatalf("Destination=%q, want existing encrypted name on the upstream host", got)
+	}
+	if seen.Get("Overwrite") != "T" || seen.Get("Depth") != "infinity" {
+		t.Fatalf("Overwrite=%q Depth=%q", seen.Get("Overwrite"), seen.Get("Depth"))
+	}
+	if _, ok := h.fileDAO.GetEncPath("/enc/old.mp4"); ok {
+		t.Fatal("source mapping should be dropped after MOVE")
+	}
+	if enc, ok := h.fileDAO.GetEncPath("/enc/new.mp4"); !ok || enc != "/enc/ENCNEW.mp4" {
+		t.Fatalf("destination mapping=%q ok=%v", enc, ok)
+	}
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// PROPFIND paging (webdavPropfindLimit). WebDAV has no paging of its own, so
+// a Depth: 1 listing is cut to one page of children and the rest is offered
+// through headers clients may follow:
+//
+//	X-Propfind-Total: 50000
+//	X-Propfind-Page: 1
+//	Link: </dav/big/?page=2>; rel="next"
+//
+// Clients that ignore them see the first page only, which keeps a 50k entry
+// folder from costing megabytes of XML and a full filename decrypt on every
+// navigation.
+
+// propfindPageParam is the query parameter selecting a page (1-based).
+const propfindPageParam = "page"
+
+var propfindResponseTags = [][2]string{
+	{"<D:response>", "</D:response>"},
+	{"<d:response>", "</d:response>"},
+	{"<response>", "</response>"},
+}
+
+// splitPropfindResponses cuts a multistatus body into the bytes before the
+// first <response>, the <response> blocks and the bytes after the last.
+func splitPropfindResponses(body []byte) (head []byte, responses [][]byte, tail []byte) {
+	var open, closing []byte
+	first := -1
+	for _, tag := range propfindResponseTags {
+		if idx := bytes.Index(body, []byte(tag[0])); idx != -1 && (first == -1 || idx < first) {
+			first = idx
+			open, closing = []byte(tag[0]), []byte(tag[1])
+		}
+	}
+	if first == -1 {
+		return body, nil, nil
+	}
+	pos := first
+	for {
+		idx := bytes.Index(body[pos:], open)
+		if idx == -1 {
+			break
+		}
+		start := pos + idx
+		end := bytes.Index(body[start:], closing)
+		if end == -1 {
+			break
+		}
+		end = start + end + len(closing)
+		responses = append(responses, body[start:end])
+		pos = end
+	}
+	return body[:first], responses, body[pos:]
+}
+
+// propfindPage describes the page a listing was cut to.
+type propfindPage struct {
+	page    int
+	total   int // children, without the folder's own entry
+	hasNext bool
+}
+
+// pagePropfindBody keeps the folder's own entry (Alist lists it first) and
+// the children of the requested page. A body with at most limit children is
+// returned unchanged, with a zero page.
+func pagePropfindBody(body []byte, limit, page int) ([]byte, propfindPage) {
+	head, responses, tail := splitPropfindResponses(body)
+	if limit <= 0 || len(responses)-1 <= limit {
+		return body, propfindPage{}
+	}
+	if page < 1 {
+		page = 1
+	}
+	children := responses[1:]
+	info := propfindPage{page: page, total: len(children)}
+	start := (page - 1) * limit
+	if start > len(children) {
+		start = len(children)
+	}
+	end := start + limit
+	if end > len(children) {
+		end = len(children)
+	}
+	info.hasNext = end < len(children)
+
+	var b bytes.Buffer
+	b.Grow(len(head) + len(tail) + len(responses[0]) + (end-start)*len(responses[0]))
+	b.Write(head)
+	b.Write(responses[0])
+	for _, resp := range children[start:end] {
+		b.Write(resp)
+	}
+	b.Write(tail)
+	return b.Bytes(), info
+}
+
+// propfindPageNumber returns the page a PROPFIND asks for.
+func propfindPageNumber(r *http.Request) int {
+	n, err := strconv.Atoi(r.URL.Query().Get(propfindPageParam))
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
+
+// setHeaders advertises the page and, when there is one, the next page.
+func (p propfindPage) setHeaders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("X-Propfind-Total", strconv.Itoa(p.total))
+	w.Header().Set("X-Propfind-Page", strconv.Itoa(p.page))
+	if p.hasNext {
+		w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", propfindPageURL(r, p.page+1)))
+	}
+}
+
+func propfindPageURL(r *http.Request, page int) string {
+	query := r.URL.Query()
+	query.Set(propfindPageParam, strconv.Itoa(page))
+	return (&url.URL{Path: r.URL.Path, RawQuery: query.Encode()}).String()
+}
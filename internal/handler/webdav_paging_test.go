@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/encryption"
+)
+
+func TestPagePropfindBody(t *testing.T) {
+	var responses []probeResponse
+	responses = append(responses, probeResponse{href: "/dav/big/", isDir: true})
+	for i := 0; i < 5; i++ {
+		responses = append(responses, probeResponse{href: fmt.Sprintf("/dav/big/f%d.mkv", i), size: 1})
+	}
+	body := []byte(buildProbeMultistatus(responses))
+
+	out, page := pagePropfindBody(body, 2, 3)
+	if page.total != 5 || page.page != 3 || page.hasNext {
+		t.Fatalf("page=%+v", page)
+	}
+	_, kept, _ := splitPropfindResponses(out)
+	if len(kept) != 2 || strings.Contains(string(kept[0]), ".mkv") || !strings.Contains(string(kept[1]), "f4.mkv") {
+		t.Fatalf("kept=%q", kept)
+	}
+	if !strings.HasSuffix(strings.TrimSpace(string(out)), "multistatus>") {
+		t.Fatalf("tail lost:\n%s", out)
+	}
+
+	if same, page := pagePropfindBody(body, 5, 1); page.total != 0 || string(same) != string(body) {
+		t.Fatal("listing within the limit should be unchanged")
+	}
+}
+
+func TestWebDAVPropfindPagesLargeFolders(t *testing.T) {
+	cfg := config.Get()
+	original := cfg.AlistServer
+	t.Cleanup(func() {
+		cfg.AlistServer = original
+	})
+	passwd := config.PasswdInfo{Password: "123456", EncType: "aesctr", EncName: true, Enable: true, EncPath: []string{"/encrypt/*"}}
+	cfg.AlistServer.PasswdList = []config.PasswdInfo{passwd}
+	conv := encryption.NewFileNameConverter(passwd.Password, passwd.EncType, "")
+
+	responses := []probeResponse{{href: "/dav/encrypt/", isDir: true}}
+	for i := 0; i < 5; i++ {
+		responses = append(responses, probeResponse{href: "/dav/encrypt/" + conv.ToRealName(fmt.Sprintf("ep%d.mkv", i)), size: 100})
+	}
+	backend := newSocketTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusMultiStatus)
+		_, _ = w.Write([]byte(buildProbeMultistatus(responses)))
+	}))
+	defer backend.Close()
+
+	h := newProbeTestHandler(t, backend.URL)
+	h.cfg.AlistServer.WebDAVPropfindLimit = 2
+
+	req := httptest.NewRequest("PROPFIND", "/dav/encrypt/?page=2", nil)
+	req.Header.Set("Depth", "1")
+	rec := httptest.NewRecorder()
+	h.Handle(rec, req)
+	body := rec.Body.String()
+	if rec.Code != http.StatusMultiStatus {
+		t.Fatalf("status=%d body=%s", rec.Code, body)
+	}
+	if rec.Header().Get("X-Propfind-Total") != "5" || rec.Header().Get("X-Propfind-Page") != "2" {
+		t.Fatalf("headers=%v", rec.Header())
+	}
+	if got := rec.Header().Get("Link"); got != `</dav/encrypt/?page=3>; rel="next"` {
+		t.Fatalf("Link=%q", got)
+	}
+	if !strings.Contains(body, "ep2.mkv") || !strings.Contains(body, "ep3.mkv") || strings.Contains(body, "ep1.mkv") || strings.Contains(body, "ep4.mkv") {
+		t.Fatalf("page 2 should hold ep2 and ep3:\n%s", body)
+	}
+
+	// Depth: 0 is never paged.
+	req = httptest.NewRequest("PROPFIND", "/dav/encrypt/", nil)
+	req.Header.Set("Depth", "0")
+	rec = httptest.NewRecorder()
+	h.Handle(rec, req)
+	if rec.Header().Get("X-Propfind-Total") != "" {
+		t.Fatalf("Depth 0 was paged: %v", rec.Header())
+	}
+}
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+const webdavAuthRealm = `Basic realm="alist-encrypt-go"`
+
+// mapWebDAVUser swaps a proxy-side WebDAV login for the Alist account it is
+// mapped to in webdavUsers, so Alist only ever sees service accounts. It
+// returns false after writing a 401 when the login must be rejected: a mapped
+// user with a wrong password, or any other login while
+// webdavMappedUsersOnly is set.
+func (h *WebDAVHandler) mapWebDAVUser(w http.ResponseWriter, r *http.Request) bool {
+	if h.cfg == nil || len(h.cfg.AlistServer.WebDAVUsers) == 0 {
+		return true
+	}
+	strict := h.cfg.AlistServer.WebDAVMappedUsersOnly
+	if username, password, ok := r.BasicAuth(); ok {
+		if mapping, found := h.findWebDAVUser(username); found {
+			if webdavPasswordMatches(mapping.Password, password) {
+				r.SetBasicAuth(mapping.AlistUsername, mapping.AlistPassword)
+				return true
+			}
+			log.Warn().Str("user", username).Str("remote", r.RemoteAddr).Msg("WebDAV mapped user password mismatch")
+			strict = true
+		}
+	}
+	if !strict {
+		return true
+	}
+	w.Header().Set("WWW-Authenticate", webdavAuthRealm)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	return false
+}
+
+func (h *WebDAVHandler) findWebDAVUser(username string) (config.WebDAVUserMapping, bool) {
+	for _, mapping := range h.cfg.AlistServer.WebDAVUsers {
+		if mapping.Username == username {
+			return mapping, true
+		}
+	}
+	return config.WebDAVUserMapping{}, false
+}
+
+// webdavPasswordMatches compares against a plain or "sha256:<hex>" password
+// in constant time.
+func webdavPasswordMatches(stored, given string) bool {
+	if hexDigest, ok := strings.CutPrefix(stored, "sha256:"); ok {
+		want, err := hex.DecodeString(strings.TrimSpace(hexDigest))
+		if err != nil {
+			return false
+		}
+		got := sha256.Sum256([]byte(given))
+		return subtle.ConstantTimeCompare(want, got[:]) == 1
+	}
+	return subtle.ConstantTimeCompare([]byte(stored), []byte(given)) == 1
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+func TestMapWebDAVUserInjectsServiceAccount(t *testing.T) {
+	sum := sha256.Sum256([]byte("bob-secret"))
+	cfg := config.DefaultConfig()
+	cfg.AlistServer.WebDAVUsers = []config.WebDAVUserMapping{
+		{Username: "alice", Password: "alice-secret", AlistUsername: "svc-media", AlistPassword: "svc-pass"},
+		{Username: "bob", Password: "sha256:" + hex.EncodeToString(sum[:]), AlistUsername: "svc-ro", AlistPassword: "ro-pass"},
+	}
+	h := &WebDAVHandler{cfg: cfg}
+
+	check := func(user, pass string, wantOK bool, wantUser, wantPass string) {
+		t.Helper()
+		req := httptest.NewRequest("PROPFIND", "/dav/", nil)
+		if user != "" {
+			req.SetBasicAuth(user, pass)
+		}
+		rec := httptest.NewRecorder()
+		if got := h.mapWebDAVUser(rec, req); got != wantOK {
+			t.Fatalf("user=%q ok=%v want %v", user, got, wantOK)
+		}
+		if !wantOK {
+			if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+				t.Fatalf("user=%q status=%d", user, rec.Code)
+			}
+			return
+		}
+		gotUser, gotPass, _ := req.BasicAuth()
+		if gotUser != wantUser || gotPass != wantPass {
+			t.Fatalf("user=%q forwarded as %q:%q", user, gotUser, gotPass)
+		}
+	}
+
+	check("alice", "alice-secret", true, "svc-media", "svc-pass")
+	check("bob", "bob-secret", true, "svc-ro", "ro-pass")
+	check("alice", "wrong", false, "", "")
+	check("carol", "direct", true, "carol", "direct")
+
+	cfg.AlistServer.WebDAVMappedUsersOnly = true
+	check("carol", "direct", false, "", "")
+	check("", "", false, "", "")
+	check("alice", "alice-secret", true, "svc-media", "svc-pass")
+}
@@ -70,12 +70,7 @@ func (b *RequestBuilder) WithForwardedHeaders(src *http.Request) *RequestBuilder
 	if src == nil {
 		return b
 	}
-	proto := "http"
-	if src.TLS != nil {
-		proto = "https"
-	} else if forwardedProto := src.Header.Get("X-Forwarded-Proto"); forwardedProto != "" {
-		proto = forwardedProto
-	}
+	proto := ForwardedProto(src)
 	if src.Host != "" {
 		b.headers.Set("X-Forwarded-Host", src.Host)
 	}
@@ -2,6 +2,7 @@ package httputil
 
 import (
 	"net/http"
+	"path"
 	"strings"
 )
 
@@ -65,3 +66,35 @@ func CleanPath(path string) string {
 	}
 	return path
 }
+
+// ForwardedProto returns the scheme the client used: https for TLS
+// requests, otherwise the first X-Forwarded-Proto value when it is http or
+// https, otherwise http. Forwarding headers are dropped from requests that
+// did not come through a trusted proxy, so they can be used as is.
+func ForwardedProto(r *http.Request) string {
+	if r.TLS != nil {
+		return "https"
+	}
+	proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+	if proto = strings.ToLower(strings.TrimSpace(proto)); proto == "https" {
+		return proto
+	}
+	return "http"
+}
+
+// ForwardedPrefix returns the path prefix a reverse proxy serves this
+// service under, from X-Forwarded-Prefix ("/enc"), or "" when there is
+// none.
+func ForwardedPrefix(r *http.Request) string {
+	if r == nil {
+		return ""
+	}
+	prefix, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Prefix"), ",")
+	if prefix = strings.TrimSpace(prefix); prefix == "" {
+		return ""
+	}
+	if prefix = path.Clean("/" + prefix); prefix == "/" {
+		return ""
+	}
+	return prefix
+}
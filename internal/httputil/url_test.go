@@ -79,3 +79,20 @@ func TestBuildTargetURLStrippedSignBug(t *testing.T) {
 		t.Error("old and new URLs should differ")
 	}
 }
+
+func TestForwardedProtoAndPrefix(t *testing.T) {
+	r, _ := http.NewRequest("GET", "/d/a", nil)
+	if ForwardedProto(r) != "http" || ForwardedPrefix(r) != "" {
+		t.Fatalf("proto=%q prefix=%q without headers", ForwardedProto(r), ForwardedPrefix(r))
+	}
+	r.Header.Set("X-Forwarded-Proto", "HTTPS, http")
+	r.Header.Set("X-Forwarded-Prefix", "enc/../alist//")
+	if ForwardedProto(r) != "https" || ForwardedPrefix(r) != "/alist" {
+		t.Fatalf("proto=%q prefix=%q", ForwardedProto(r), ForwardedPrefix(r))
+	}
+	r.Header.Set("X-Forwarded-Proto", "gopher")
+	r.Header.Set("X-Forwarded-Prefix", "/")
+	if ForwardedProto(r) != "http" || ForwardedPrefix(r) != "" {
+		t.Fatalf("proto=%q prefix=%q", ForwardedProto(r), ForwardedPrefix(r))
+	}
+}
@@ -0,0 +1,191 @@
+// Package i18n localizes the fixed messages the /enc-api endpoints return.
+//
+// Messages are keyed by the legacy English text, which is also what clients
+// get when no language was negotiated, so scripts written against the
+// Node.js server keep seeing the exact strings (typos included) they match
+// on. Numeric codes and error_code values are never translated.
+package i18n
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	LangEN = "en"
+	LangZH = "zh-CN"
+
+	// HeaderContentLanguage carries the negotiated language on the response;
+	// the JSON helpers read it back to pick the message.
+	HeaderContentLanguage = "Content-Language"
+)
+
+// entry holds the translations of one message. English entries only exist
+// where the legacy text needs fixing.
+type entry struct {
+	en, zh string
+	// prefix marks messages the server completes with a detail, e.g.
+	// "Invalid request: " + err. The detail is kept as-is.
+	prefix bool
+}
+
+var catalog = map[string]entry{
+	"passwword error":        {en: "password error", zh: "用户名或密码错误"},
+	"password error":         {zh: "密码错误"},
+	"user unlogin":           {en: "not logged in", zh: "未登录或登录已过期"},
+	"Invalid request":        {zh: "请求无效"},
+	"Invalid request: ":      {zh: "请求无效：", prefix: true},
+	"method not allowed":     {zh: "不支持的请求方法"},
+	"update success":         {zh: "更新成功"},
+	"save ok":                {zh: "保存成功"},
+	"stopped":                {zh: "已停止"},
+	"preferences saved":      {zh: "偏好已保存"},
+	"operation successful":   {zh: "操作成功"},
+	"folderName is error":    {en: "invalid folder name", zh: "文件夹名无效"},
+	"mysql not enabled":      {en: "MySQL is not enabled", zh: "未启用 MySQL"},
+	"path is required":       {zh: "缺少 path 参数"},
+	"Task not found":         {zh: "任务不存在"},
+	"Missing task ID":        {zh: "缺少任务 ID"},
+	"No files to process":    {zh: "没有需要处理的文件"},
+	"folderPath is required": {zh: "缺少 folderPath 参数"},
+
+	"password too short, at less 8 digits":                     {en: "password too short, at least 8 characters", zh: "密码过短，至少 8 位"},
+	"username too short, at least 3 characters":                {zh: "用户名过短，至少 3 个字符"},
+	"password expired, ask an administrator to reset it":       {zh: "密码已过期，请联系管理员重置"},
+	"permission denied":                                        {zh: "权限不足"},
+	"cannot change your own role":                              {zh: "不能修改自己的角色"},
+	"invalid role":                                             {zh: "角色无效"},
+	"user already exists":                                      {zh: "用户已存在"},
+	"user not found":                                           {zh: "用户不存在"},
+	"preferences too large":                                    {zh: "偏好设置过大"},
+	"preferences must be a JSON object":                        {zh: "偏好设置必须是 JSON 对象"},
+	"update checker is disabled":                               {zh: "更新检查已关闭"},
+	"playback stats unavailable":                               {zh: "播放统计不可用"},
+	"audit log unavailable":                                    {zh: "审计日志不可用"},
+	"path is not under an encrypted folder":                    {zh: "路径不在加密文件夹下"},
+	"format must be csv or json":                               {zh: "format 只能是 csv 或 json"},
+	"failed to read remote file":                               {zh: "读取远程文件失败"},
+	"failed to read content header":                            {zh: "读取内容文件头失败"},
+	"failed to list ":                                          {zh: "列出目录失败：", prefix: true},
+	"failed to resolve raw url: ":                              {zh: "获取原始链接失败：", prefix: true},
+	"invalid content header: ":                                 {zh: "内容文件头无效：", prefix: true},
+	"operation must be 'enc' or 'dec'":                         {zh: "operation 只能是 enc 或 dec"},
+	"Too many files, exceeding 10000":                          {zh: "文件过多，超过 10000 个"},
+	"Path is not a directory":                                  {zh: "路径不是目录"},
+	"Path does not exist: ":                                    {zh: "路径不存在：", prefix: true},
+	"Cannot create output directory: ":                         {zh: "无法创建输出目录：", prefix: true},
+	"Source path does not exist or is not a directory":         {zh: "源路径不存在或不是目录"},
+	"Missing required fields: password, folderPath, operation": {zh: "缺少必填字段：password、folderPath、operation"},
+	"oldPassword is required":                                  {zh: "缺少 oldPassword 参数"},
+	"unsupported oldEncType":                                   {zh: "不支持的 oldEncType"},
+	"the folder already uses this password and encType":        {zh: "该文件夹已在使用此密码和加密方式"},
+	"a re-encryption job is already running for this folder":   {zh: "该文件夹已有正在进行的重新加密任务"},
+	"invalid offset":                                           {zh: "offset 参数无效"},
+	"Content-Length is required":                               {zh: "缺少 Content-Length"},
+	"offset is beyond the end of the file":                     {zh: "offset 超出文件末尾"},
+	"a patch is already running for this file":                 {zh: "该文件已有正在进行的补丁上传"},
+	"mode must be pause, resume or auto":                       {zh: "mode 只能是 pause、resume 或 auto"},
+	"MySQL 未连接，请先配置 MySQL 后再试":                                 {en: "MySQL is not connected, configure MySQL first"},
+}
+
+// prefixes lists the prefix keys, longest first.
+var prefixes = func() []string {
+	var keys []string
+	for k, e := range catalog {
+		if e.prefix {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return len(keys[i]) > len(keys[j]) })
+	return keys
+}()
+
+// Normalize maps a language tag to a supported language, or "".
+func Normalize(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	tag = strings.ReplaceAll(tag, "_", "-")
+	switch {
+	case tag == "zh" || strings.HasPrefix(tag, "zh-cn") || strings.HasPrefix(tag, "zh-hans") || strings.HasPrefix(tag, "zh-sg"):
+		return LangZH
+	case strings.HasPrefix(tag, "zh-"):
+		// Traditional Chinese readers are better served by Simplified
+		// than by English.
+		return LangZH
+	case tag == "en" || strings.HasPrefix(tag, "en-"):
+		return LangEN
+	}
+	return ""
+}
+
+// Negotiate picks the best supported language from an Accept-Language
+// header, or "" when none is acceptable.
+func Negotiate(acceptLanguage string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		lang := Normalize(tag)
+		if lang == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > bestQ {
+			best, bestQ = lang, q
+		}
+	}
+	return best
+}
+
+// Translate returns msg in lang. Unknown messages and an empty lang return
+// msg unchanged.
+func Translate(lang, msg string) string {
+	if lang == "" || msg == "" {
+		return msg
+	}
+	if e, ok := catalog[msg]; ok {
+		if t := e.in(lang); t != "" {
+			return t
+		}
+		return msg
+	}
+	for _, key := range prefixes {
+		if detail, ok := strings.CutPrefix(msg, key); ok {
+			if t := catalog[key].in(lang); t != "" {
+				return t + detail
+			}
+			return msg
+		}
+	}
+	return msg
+}
+
+func (e entry) in(lang string) string {
+	switch lang {
+	case LangZH:
+		return e.zh
+	case LangEN:
+		return e.en
+	}
+	return ""
+}
+
+// LangOf returns the language negotiated for the response being written.
+func LangOf(w http.ResponseWriter) string {
+	if w == nil {
+		return ""
+	}
+	return w.Header().Get(HeaderContentLanguage)
+}
+
+// Localize translates msg for the response w is writing.
+func Localize(w http.ResponseWriter, msg string) string {
+	return Translate(LangOf(w), msg)
+}
@@ -0,0 +1,62 @@
+package i18n
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	cases := map[string]string{
+		"":                              "",
+		"fr-FR, de;q=0.8":               "",
+		"zh-CN,zh;q=0.9,en;q=0.8":       LangZH,
+		"en-US,en;q=0.9,zh-CN;q=0.5":    LangEN,
+		"fr;q=1, zh-TW;q=0.7, en;q=0.6": LangZH,
+		"en;q=0.2, zh_Hans;q=0.9":       LangZH,
+		"zh;q=0, en;q=0.1":              LangEN,
+	}
+	for header, want := range cases {
+		if got := Negotiate(header); got != want {
+			t.Errorf("Negotiate(%q)=%q want %q", header, got, want)
+		}
+	}
+}
+
+func TestTranslateKeepsLegacyWithoutLanguage(t *testing.T) {
+	for _, msg := range []string{"passwword error", "user unlogin", "Invalid request: bad json"} {
+		if got := Translate("", msg); got != msg {
+			t.Fatalf("Translate(\"\", %q)=%q", msg, got)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	cases := []struct{ lang, msg, want string }{
+		{LangEN, "passwword error", "password error"},
+		{LangEN, "password too short, at less 8 digits", "password too short, at least 8 characters"},
+		{LangEN, "save ok", "save ok"},
+		{LangZH, "save ok", "保存成功"},
+		{LangZH, "user unlogin", "未登录或登录已过期"},
+		{LangZH, "Invalid request", "请求无效"},
+		{LangZH, "Invalid request: unexpected EOF", "请求无效：unexpected EOF"},
+		{LangZH, "Path does not exist: /a", "路径不存在：/a"},
+		{LangEN, "MySQL 未连接，请先配置 MySQL 后再试", "MySQL is not connected, configure MySQL first"},
+		{LangZH, "something upstream said", "something upstream said"},
+	}
+	for _, tc := range cases {
+		if got := Translate(tc.lang, tc.msg); got != tc.want {
+			t.Errorf("Translate(%s, %q)=%q want %q", tc.lang, tc.msg, got, tc.want)
+		}
+	}
+}
+
+func TestLocalizeReadsContentLanguage(t *testing.T) {
+	rr := httptest.NewRecorder()
+	if got := Localize(rr, "stopped"); got != "stopped" {
+		t.Fatalf("no language: %q", got)
+	}
+	rr.Header().Set(HeaderContentLanguage, LangZH)
+	if got := Localize(rr, "stopped"); got != "已停止" {
+		t.Fatalf("zh-CN: %q", got)
+	}
+}
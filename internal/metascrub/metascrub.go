@@ -0,0 +1,329 @@
+// Package metascrub blanks privacy-sensitive metadata in media files while
+// they stream through. Every edit happens in place — metadata is overwritten,
+// never cut out — so the output is exactly as long as the input and callers
+// that already committed to a size (Content-Length, encryption headers,
+// resumable offsets) stay valid without buffering the whole file.
+//
+// Handled formats:
+//   - JPEG: Exif (keeping only the orientation tag), XMP and Photoshop/IPTC
+//     segments are blanked.
+//   - MP4/MOV/3GP: udta and meta boxes inside moov and its tracks, where
+//     cameras and phones store GPS, device and user data, become zeroed free
+//     boxes.
+//
+// Anything else, and any stream that does not parse cleanly, passes through
+// unchanged.
+package metascrub
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// maxMoovSize bounds how much of a video is buffered to rewrite its moov box.
+// Larger movie headers pass through untouched.
+const maxMoovSize = 64 << 20
+
+const (
+	jpegCOM   = 0xFE
+	jpegAPP1  = 0xE1
+	jpegAPP13 = 0xED
+	jpegSOS   = 0xDA
+	jpegEOI   = 0xD9
+
+	exifOrientationTag = 0x0112
+)
+
+var (
+	exifPrefix        = []byte("Exif\x00\x00")
+	xmpPrefix         = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	xmpExtendedPrefix = []byte("http://ns.adobe.com/xmp/extension/\x00")
+	photoshopPrefix   = []byte("Photoshop 3.0\x00")
+)
+
+// Reader is an io.Reader returning its source with metadata blanked.
+type Reader struct {
+	src      *bufio.Reader
+	pending  []byte
+	copyN    int64 // bytes to pass through verbatim; -1 means the rest
+	step     func() error
+	scrubbed int
+}
+
+// NewReader wraps r. The format is sniffed from the first bytes.
+func NewReader(r io.Reader) *Reader {
+	s := &Reader{src: bufio.NewReader(r)}
+	head, _ := s.src.Peek(12)
+	switch {
+	case len(head) >= 3 && head[0] == 0xFF && head[1] == 0xD8 && head[2] == 0xFF:
+		s.pending = []byte{0xFF, 0xD8}
+		_, _ = s.src.Discard(2)
+		s.step = s.jpegSegment
+	case len(head) >= 8 && string(head[4:8]) == "ftyp":
+		s.step = s.bmffBox
+	default:
+		s.passRest()
+	}
+	return s
+}
+
+// Scrubbed reports how many metadata blocks have been blanked so far.
+func (s *Reader) Scrubbed() int {
+	return s.scrubbed
+}
+
+func (s *Reader) Read(p []byte) (int, error) {
+	for {
+		if len(s.pending) > 0 {
+			n := copy(p, s.pending)
+			s.pending = s.pending[n:]
+			return n, nil
+		}
+		if s.copyN != 0 {
+			if s.copyN > 0 && int64(len(p)) > s.copyN {
+				p = p[:s.copyN]
+			}
+			n, err := s.src.Read(p)
+			if s.copyN > 0 {
+				s.copyN -= int64(n)
+			}
+			return n, err
+		}
+		if s.step == nil {
+			return 0, io.EOF
+		}
+		if err := s.step(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+func (s *Reader) passRest() {
+	s.copyN = -1
+	s.step = nil
+}
+
+// take reads exactly n bytes. On a short stream whatever arrived is queued
+// unchanged, ok is false and scrubbing stops.
+func (s *Reader) take(n int) (buf []byte, ok bool, err error) {
+	buf = make([]byte, n)
+	got, err := io.ReadFull(s.src, buf)
+	if err == nil {
+		return buf, true, nil
+	}
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		s.pending = buf[:got]
+		s.step = nil
+		return nil, false, nil
+	}
+	return nil, false, err
+}
+
+// queueShort puts already-consumed bytes back in front of a short tail.
+func (s *Reader) queueShort(prefix ...[]byte) {
+	s.pending = append(bytes.Join(prefix, nil), s.pending...)
+}
+
+func (s *Reader) jpegSegment() error {
+	marker, ok, err := s.take(2)
+	if err != nil || !ok {
+		return err
+	}
+	if marker[0] != 0xFF {
+		s.pending = marker
+		s.passRest()
+		return nil
+	}
+	switch m := marker[1]; {
+	case m == jpegSOS || m == jpegEOI || m == 0xFF:
+		// Entropy-coded data follows; nothing after it is metadata we edit.
+		s.pending = marker
+		s.passRest()
+		return nil
+	case m == 0x01 || (m >= 0xD0 && m <= 0xD7):
+		s.pending = marker
+		return nil
+	}
+	lengthBytes, ok, err := s.take(2)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		s.queueShort(marker)
+		return nil
+	}
+	length := int(binary.BigEndian.Uint16(lengthBytes))
+	if length < 2 {
+		s.pending = append(marker, lengthBytes...)
+		s.passRest()
+		return nil
+	}
+	payload, ok, err := s.take(length - 2)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		s.queueShort(marker, lengthBytes)
+		return nil
+	}
+	if newMarker, changed := scrubJPEGSegment(marker[1], payload); changed {
+		marker[1] = newMarker
+		s.scrubbed++
+	}
+	s.pending = bytes.Join([][]byte{marker, lengthBytes, payload}, nil)
+	return nil
+}
+
+// scrubJPEGSegment blanks payload in place when it carries metadata and
+// returns the marker the segment should be written with.
+func scrubJPEGSegment(marker byte, payload []byte) (byte, bool) {
+	switch {
+	case marker == jpegAPP1 && bytes.HasPrefix(payload, exifPrefix):
+		orientation := exifOrientation(payload[len(exifPrefix):])
+		clear(payload)
+		if orientation != 0 && writeOrientationExif(payload, orientation) {
+			return jpegAPP1, true
+		}
+		return jpegCOM, true
+	case marker == jpegAPP1 && (bytes.HasPrefix(payload, xmpPrefix) || bytes.HasPrefix(payload, xmpExtendedPrefix)),
+		marker == jpegAPP13 && bytes.HasPrefix(payload, photoshopPrefix):
+		clear(payload)
+		return jpegCOM, true
+	}
+	return marker, false
+}
+
+// exifOrientation returns the IFD0 orientation tag of a TIFF block, or 0.
+func exifOrientation(tiff []byte) uint16 {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	off := int(order.Uint32(tiff[4:8]))
+	if off < 8 || off+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[off:]))
+	for i := 0; i < count; i++ {
+		e := off + 2 + 12*i
+		if e+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[e:]) == exifOrientationTag && order.Uint16(tiff[e+2:]) == 3 {
+			if v := order.Uint16(tiff[e+8:]); v >= 1 && v <= 8 {
+				return v
+			}
+		}
+	}
+	return 0
+}
+
+// writeOrientationExif writes a minimal Exif block holding only the
+// orientation tag, so viewers still rotate the photo. The rest of payload
+// stays zero.
+func writeOrientationExif(payload []byte, orientation uint16) bool {
+	const size = 6 + 8 + 2 + 12 + 4
+	if len(payload) < size {
+		return false
+	}
+	b := payload[:0]
+	b = append(b, exifPrefix...)
+	b = append(b, 'M', 'M', 0x00, 0x2A)
+	b = binary.BigEndian.AppendUint32(b, 8) // IFD0 offset
+	b = binary.BigEndian.AppendUint16(b, 1) // entry count
+	b = binary.BigEndian.AppendUint16(b, exifOrientationTag)
+	b = binary.BigEndian.AppendUint16(b, 3) // SHORT
+	b = binary.BigEndian.AppendUint32(b, 1)
+	b = binary.BigEndian.AppendUint16(b, orientation)
+	b = append(b, 0, 0)
+	_ = binary.BigEndian.AppendUint32(b, 0) // no next IFD
+	return true
+}
+
+func (s *Reader) bmffBox() error {
+	header, ok, err := s.take(8)
+	if err != nil || !ok {
+		return err
+	}
+	size := int64(binary.BigEndian.Uint32(header))
+	if size == 1 {
+		ext, ok, err := s.take(8)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			s.queueShort(header)
+			return nil
+		}
+		header = append(header, ext...)
+		size = int64(binary.BigEndian.Uint64(ext))
+	}
+	headerLen := int64(len(header))
+	if size < headerLen {
+		// size 0 (box runs to EOF) or a malformed size.
+		s.pending = header
+		s.passRest()
+		return nil
+	}
+	if string(header[4:8]) == "moov" && size <= maxMoovSize {
+		body, ok, err := s.take(int(size - headerLen))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			s.queueShort(header)
+			return nil
+		}
+		s.scrubbed += blankBoxes(body)
+		s.pending = append(header, body...)
+		return nil
+	}
+	s.pending = header
+	s.copyN = size - headerLen
+	return nil
+}
+
+// blankBoxes turns udta and meta children of data into zeroed free boxes,
+// descending into tracks. It returns the number of boxes blanked.
+func blankBoxes(data []byte) int {
+	n := 0
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		headerLen := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return n
+			}
+			size = binary.BigEndian.Uint64(data[8:])
+			headerLen = 16
+		}
+		if size < headerLen || size > uint64(len(data)) {
+			return n
+		}
+		box := data[:size]
+		switch string(box[4:8]) {
+		case "udta", "meta":
+			copy(box[4:8], "free")
+			clear(box[headerLen:])
+			n++
+		case "trak":
+			n += blankBoxes(box[headerLen:])
+		}
+		data = data[size:]
+	}
+	return n
+}
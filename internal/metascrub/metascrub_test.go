@@ -0,0 +1,135 @@
+package metascrub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"testing"
+)
+
+const gpsSentinel = "GPS+37.7749-122.4194"
+
+func jpegWithExif(t *testing.T, orientation uint16) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 8))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i)
+	}
+	img.Set(0, 0, color.RGBA{R: 255, A: 255})
+	var enc bytes.Buffer
+	if err := jpeg.Encode(&enc, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Little-endian TIFF: IFD0 with Make and Orientation, then the sentinel
+	// standing in for GPS data.
+	tiff := []byte{'I', 'I', 0x2A, 0x00}
+	tiff = binary.LittleEndian.AppendUint32(tiff, 8)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 2)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 0x010F) // Make
+	tiff = binary.LittleEndian.AppendUint16(tiff, 2)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 4)
+	tiff = append(tiff, 'A', 'c', 'm', 0)
+	tiff = binary.LittleEndian.AppendUint16(tiff, exifOrientationTag)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 3)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, orientation)
+	tiff = append(tiff, 0, 0)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 0)
+	tiff = append(tiff, gpsSentinel...)
+
+	payload := append(append([]byte(nil), exifPrefix...), tiff...)
+	xmp := append(append([]byte(nil), xmpPrefix...), "<x:xmpmeta>"+gpsSentinel+"</x:xmpmeta>"...)
+
+	var out bytes.Buffer
+	out.Write(enc.Bytes()[:2])
+	for _, seg := range []struct {
+		marker  byte
+		payload []byte
+	}{{jpegAPP1, payload}, {jpegAPP1, xmp}} {
+		out.Write([]byte{0xFF, seg.marker})
+		_ = binary.Write(&out, binary.BigEndian, uint16(len(seg.payload)+2))
+		out.Write(seg.payload)
+	}
+	out.Write(enc.Bytes()[2:])
+	return out.Bytes()
+}
+
+func scrub(t *testing.T, in []byte) ([]byte, *Reader) {
+	t.Helper()
+	r := NewReader(bytes.NewReader(in))
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if len(out) != len(in) {
+		t.Fatalf("length changed: %d -> %d", len(in), len(out))
+	}
+	return out, r
+}
+
+func TestJPEGExifBlankedKeepingOrientation(t *testing.T) {
+	in := jpegWithExif(t, 6)
+	out, r := scrub(t, in)
+	if bytes.Contains(out, []byte(gpsSentinel)) || bytes.Contains(out, []byte("Acm")) {
+		t.Fatal("metadata survived scrubbing")
+	}
+	if r.Scrubbed() != 2 {
+		t.Fatalf("expected 2 scrubbed segments, got %d", r.Scrubbed())
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(out)); err != nil {
+		t.Fatalf("scrubbed jpeg no longer decodes: %v", err)
+	}
+	idx := bytes.Index(out, exifPrefix)
+	if idx < 0 {
+		t.Fatal("orientation-only Exif segment missing")
+	}
+	if got := exifOrientation(out[idx+len(exifPrefix):]); got != 6 {
+		t.Fatalf("orientation = %d, want 6", got)
+	}
+}
+
+func box(typ string, children ...[]byte) []byte {
+	body := bytes.Join(children, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	b = append(b, typ...)
+	return append(b, body...)
+}
+
+func TestMP4UserDataBlanked(t *testing.T) {
+	mvhd := box("mvhd", make([]byte, 100))
+	udta := box("udta", box("\xa9xyz", []byte(gpsSentinel)))
+	trak := box("trak", box("tkhd", make([]byte, 84)), box("meta", []byte(gpsSentinel)))
+	in := bytes.Join([][]byte{
+		box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2mp41")),
+		box("moov", mvhd, udta, trak),
+		box("mdat", bytes.Repeat([]byte{0xAB}, 4096)),
+	}, nil)
+
+	out, r := scrub(t, in)
+	if bytes.Contains(out, []byte(gpsSentinel)) {
+		t.Fatal("metadata survived scrubbing")
+	}
+	if r.Scrubbed() != 2 {
+		t.Fatalf("expected 2 scrubbed boxes, got %d", r.Scrubbed())
+	}
+	if !bytes.Equal(out[len(out)-4096:], in[len(in)-4096:]) {
+		t.Fatal("media data changed")
+	}
+}
+
+func TestUnknownAndTruncatedInputPassThrough(t *testing.T) {
+	for name, in := range map[string][]byte{
+		"text":           []byte("plain text file, not media"),
+		"truncated jpeg": jpegWithExif(t, 1)[:40],
+		"truncated mp4":  append(box("ftyp", []byte("isom")), 0, 0, 0x10, 0, 'm', 'o', 'o', 'v', 1, 2),
+	} {
+		out, _ := scrub(t, in)
+		if !bytes.Equal(out, in) {
+			t.Fatalf("%s: expected input unchanged", name)
+		}
+	}
+}
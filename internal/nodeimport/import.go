@@ -0,0 +1,243 @@
+// Package nodeimport converts the data of the original Node.js alist-encrypt
+// (conf/config.json plus the LevelDB user database) into this project's
+// config.json and BoltDB store.
+package nodeimport
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/dao"
+	"github.com/alist-encrypt-go/internal/encryption"
+)
+
+// Source is what was read from a Node.js installation.
+type Source struct {
+	ConfDir string
+
+	// AlistServer is the raw alistServer object, decoded over the target's
+	// current settings so fields the Node.js version lacks keep their values.
+	AlistServer  json.RawMessage
+	WebDAVServer []config.WebDAVServer
+	Port         int
+	Users        []User
+
+	// LevelDBDir is empty when no user database was found.
+	LevelDBDir string
+}
+
+// User is an account from the Node.js user database. The Node.js version
+// stores the login password as entered, so it can be rehashed here.
+type User struct {
+	Username string
+	Password string
+}
+
+// Report describes what an import changed (or would change).
+type Report struct {
+	PasswdEntries int
+	WebDAVAdded   []string
+	WebDAVUpdated []string
+	Port          int
+	UsersCreated  []string
+	UsersUpdated  []string
+	Warnings      []string
+}
+
+// Read loads a Node.js installation. dir may be the project folder or its
+// conf folder.
+func Read(dir string) (*Source, error) {
+	confDir := dir
+	if _, err := os.Stat(filepath.Join(dir, "conf", "config.json")); err == nil {
+		confDir = filepath.Join(dir, "conf")
+	}
+	data, err := os.ReadFile(filepath.Join(confDir, "config.json"))
+	if err != nil {
+		return nil, fmt.Errorf("read Node.js config: %w", err)
+	}
+	var raw struct {
+		AlistServer  json.RawMessage          `json:"alistServer"`
+		WebDAVServer []map[string]interface{} `json:"webdavServer"`
+		Port         int                      `json:"port"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse Node.js config: %w", err)
+	}
+	if len(raw.AlistServer) == 0 {
+		return nil, errors.New("Node.js config has no alistServer")
+	}
+	src := &Source{ConfDir: confDir, AlistServer: raw.AlistServer, Port: raw.Port}
+	for _, m := range raw.WebDAVServer {
+		src.WebDAVServer = append(src.WebDAVServer, config.ParseWebDAVServerFromMap(m))
+	}
+
+	src.LevelDBDir = findLevelDB(confDir)
+	if src.LevelDBDir != "" {
+		kv, err := readLevelDB(src.LevelDBDir)
+		if err != nil {
+			return nil, fmt.Errorf("read Node.js user database: %w", err)
+		}
+		src.Users = usersFromLevelDB(kv)
+	}
+	return src, nil
+}
+
+// findLevelDB returns the first folder under confDir holding a LevelDB
+// database; the folder name differs between Node.js releases.
+func findLevelDB(confDir string) string {
+	entries, err := os.ReadDir(confDir)
+	if err != nil {
+		return ""
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		dir := filepath.Join(confDir, e.Name())
+		if _, err := os.Stat(filepath.Join(dir, "CURRENT")); err == nil {
+			return dir
+		}
+	}
+	return ""
+}
+
+// usersFromLevelDB picks the user records out of the database: JSON objects
+// with a username and password, stored directly or wrapped in the
+// {"value": ...} envelope the Node.js cache helper writes.
+func usersFromLevelDB(kv map[string][]byte) []User {
+	type record struct {
+		Username string          `json:"username"`
+		Password string          `json:"password"`
+		Value    json.RawMessage `json:"value"`
+	}
+	seen := make(map[string]bool)
+	var users []User
+	for _, v := range kv {
+		var rec record
+		if json.Unmarshal(v, &rec) != nil {
+			continue
+		}
+		if rec.Username == "" && len(rec.Value) > 0 {
+			var inner record
+			if json.Unmarshal(rec.Value, &inner) == nil {
+				rec = inner
+			}
+		}
+		if rec.Username == "" || rec.Password == "" || seen[rec.Username] {
+			continue
+		}
+		seen[rec.Username] = true
+		users = append(users, User{Username: rec.Username, Password: rec.Password})
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Username < users[j].Username })
+	return users
+}
+
+// Apply writes src into cfg and users. With dryRun nothing is saved and the
+// report lists what would change. Existing accounts with the same name get
+// the Node.js password; WebDAV servers with the same id are replaced.
+func Apply(src *Source, cfg *config.Config, users *dao.UserDAO, dryRun bool) (*Report, error) {
+	report := &Report{}
+
+	server := cfg.AlistServer
+	server.PasswdList = nil
+	if err := json.Unmarshal(src.AlistServer, &server); err != nil {
+		return nil, fmt.Errorf("parse Node.js alistServer: %w", err)
+	}
+	report.PasswdEntries = len(server.PasswdList)
+	report.Warnings = append(report.Warnings, encTypeWarnings("alistServer", server.PasswdList)...)
+	if !dryRun {
+		if err := cfg.UpdateAlistServer(server); err != nil {
+			return nil, fmt.Errorf("save alistServer: %w", err)
+		}
+	}
+
+	existing := make(map[string]bool)
+	for _, s := range cfg.WebDAVServer {
+		existing[s.ID] = true
+	}
+	for _, s := range src.WebDAVServer {
+		label := s.Name
+		if label == "" {
+			label = s.ID
+		}
+		report.Warnings = append(report.Warnings, encTypeWarnings("webdavServer "+label, s.PasswdList)...)
+		if s.ID == "" {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("webdavServer %s has no id, skipped", label))
+			continue
+		}
+		if existing[s.ID] {
+			report.WebDAVUpdated = append(report.WebDAVUpdated, label)
+			if !dryRun {
+				if err := cfg.UpdateWebDAVServer(s); err != nil {
+					return nil, fmt.Errorf("save webdavServer %s: %w", label, err)
+				}
+			}
+			continue
+		}
+		report.WebDAVAdded = append(report.WebDAVAdded, label)
+		if !dryRun {
+			if err := cfg.AddWebDAVServer(s); err != nil {
+				return nil, fmt.Errorf("save webdavServer %s: %w", label, err)
+			}
+		}
+	}
+
+	if src.Port > 0 && cfg.Scheme != nil && cfg.Scheme.HTTPPort != src.Port {
+		report.Port = src.Port
+		if !dryRun {
+			scheme := *cfg.Scheme
+			scheme.HTTPPort = src.Port
+			if _, err := cfg.UpdateScheme(scheme); err != nil {
+				return nil, fmt.Errorf("save port: %w", err)
+			}
+		}
+	}
+
+	if src.LevelDBDir == "" {
+		report.Warnings = append(report.Warnings, "no LevelDB user database found under "+src.ConfDir+", accounts not imported")
+	}
+	for _, u := range src.Users {
+		_, err := users.Get(u.Username)
+		switch {
+		case err == nil:
+			report.UsersUpdated = append(report.UsersUpdated, u.Username)
+			if !dryRun {
+				err = users.UpdatePassword(u.Username, u.Password)
+			}
+		case errors.Is(err, dao.ErrUserNotFound):
+			report.UsersCreated = append(report.UsersCreated, u.Username)
+			err = nil
+			if !dryRun {
+				err = users.Create(u.Username, u.Password)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("save user %s: %w", u.Username, err)
+		}
+	}
+	return report, nil
+}
+
+// encTypeWarnings flags passwdList entries whose encType this project cannot
+// read, such as the Node.js "mix" type.
+func encTypeWarnings(where string, list []config.PasswdInfo) []string {
+	var warnings []string
+	for _, p := range list {
+		if encryption.IsSupportedEncType(p.EncType) {
+			continue
+		}
+		name := p.Describe
+		if name == "" {
+			name = strings.Join(p.EncPath, ",")
+		}
+		warnings = append(warnings, fmt.Sprintf("%s: passwdList entry %q uses unsupported encType %q", where, name, p.EncType))
+	}
+	return warnings
+}
@@ -0,0 +1,108 @@
+package nodeimport
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/dao"
+	"github.com/alist-encrypt-go/internal/storage"
+)
+
+const nodeConfig = `{
+  "alistServer": {
+    "name": "alist",
+    "path": "/*",
+    "serverHost": "192.168.8.240",
+    "serverPort": 5244,
+    "https": false,
+    "passwdList": [
+      {"password": "123456", "describe": "my video", "encType": "aesctr", "enable": true, "encName": false, "encSuffix": "", "encPath": ["encrypt_folder/*"]},
+      {"password": "abc", "describe": "old mix", "encType": "mix", "enable": true, "encPath": ["/mix/*"]}
+    ]
+  },
+  "webdavServer": [
+    {"id": "w1", "name": "webdav-189", "path": "/dav/*", "enable": true, "serverHost": "192.168.8.241", "serverPort": 5244, "passwdList": []}
+  ],
+  "port": 5355
+}`
+
+func TestImportNodeInstallation(t *testing.T) {
+	nodeDir := t.TempDir()
+	conf := filepath.Join(nodeDir, "conf")
+	levelDir := filepath.Join(conf, "levelDB")
+	if err := os.MkdirAll(levelDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(conf, "config.json"), []byte(nodeConfig), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(levelDir, "CURRENT"), []byte("MANIFEST-000001\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	log := logRecord(1, writeBatch(1,
+		batchOp{kindValue, "user_admin", `{"username":"admin","password":"nodepass","roleId":"[13]"}`},
+		batchOp{kindValue, "user_guest", `{"value":{"username":"guest","password":"guestpass"},"expire":0}`},
+		batchOp{kindValue, "fileInfo_x", `{"path":"/a.mkv","size":1}`},
+	))
+	if err := os.WriteFile(filepath.Join(levelDir, "000003.log"), log, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	src, err := Read(nodeDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(src.Users) != 2 {
+		t.Fatalf("users=%+v", src.Users)
+	}
+
+	base := t.TempDir()
+	cfg := config.LoadFromBaseDir(base)
+	store, err := storage.NewStore(cfg.DataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	users := dao.NewUserDAO(store)
+	if err := users.Create("admin", "go-random"); err != nil {
+		t.Fatal(err)
+	}
+
+	dry, err := Apply(src, cfg, users, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dry.PasswdEntries != 2 || cfg.AlistServer.ServerHost == "192.168.8.240" {
+		t.Fatalf("dry run changed config or miscounted: %+v", dry)
+	}
+
+	report, err := Apply(src, cfg, users, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Warnings) != 1 || len(report.WebDAVAdded) != 1 || report.Port != 5355 {
+		t.Fatalf("report=%+v", report)
+	}
+	if len(report.UsersUpdated) != 1 || len(report.UsersCreated) != 1 {
+		t.Fatalf("report=%+v", report)
+	}
+
+	reloaded := config.LoadFromBaseDir(base)
+	if reloaded.AlistServer.ServerHost != "192.168.8.240" || len(reloaded.AlistServer.PasswdList) != 2 {
+		t.Fatalf("alistServer=%+v", reloaded.AlistServer)
+	}
+	if len(reloaded.WebDAVServer) != 1 || reloaded.WebDAVServer[0].ID != "w1" {
+		t.Fatalf("webdavServer=%+v", reloaded.WebDAVServer)
+	}
+	if reloaded.Scheme.HTTPPort != 5355 {
+		t.Fatalf("http_port=%d", reloaded.Scheme.HTTPPort)
+	}
+	if err := users.Validate("admin", "nodepass"); err != nil {
+		t.Fatalf("admin password not imported: %v", err)
+	}
+	if err := users.Validate("guest", "guestpass"); err != nil {
+		t.Fatalf("guest not imported: %v", err)
+	}
+}
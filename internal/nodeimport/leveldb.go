@@ -0,0 +1,304 @@
+package nodeimport
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The Node.js project keeps its users in a LevelDB database (the `level`
+// npm package). This is a minimal read-only reader for it: it replays the
+// write-ahead logs and scans every table file, keeping the newest version
+// of each key by sequence number. Compaction state in the MANIFEST is not
+// needed for that, so it is not parsed.
+
+const (
+	logBlockSize  = 32 * 1024
+	logHeaderSize = 7
+	tableFooter   = 48
+	tableMagic    = 0xdb4775248b80fb57
+
+	kindDeletion = 0
+	kindValue    = 1
+)
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+type levelEntry struct {
+	seq     uint64
+	value   []byte
+	deleted bool
+}
+
+type levelReader struct {
+	entries map[string]levelEntry
+}
+
+func (r *levelReader) put(key []byte, seq uint64, kind byte, value []byte) {
+	if old, ok := r.entries[string(key)]; ok && old.seq > seq {
+		return
+	}
+	r.entries[string(key)] = levelEntry{
+		seq:     seq,
+		value:   append([]byte(nil), value...),
+		deleted: kind == kindDeletion,
+	}
+}
+
+// readLevelDB returns the live key/value pairs of the LevelDB database in dir.
+func readLevelDB(dir string) (map[string][]byte, error) {
+	if _, err := os.Stat(filepath.Join(dir, "CURRENT")); err != nil {
+		return nil, fmt.Errorf("%s is not a LevelDB directory: %w", dir, err)
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	r := &levelReader{entries: make(map[string]levelEntry)}
+	for _, f := range files {
+		name := f.Name()
+		path := filepath.Join(dir, name)
+		switch {
+		case strings.HasSuffix(name, ".log"):
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			for _, rec := range readLogRecords(data) {
+				if err := r.applyBatch(rec); err != nil {
+					return nil, fmt.Errorf("%s: %w", name, err)
+				}
+			}
+		case strings.HasSuffix(name, ".ldb") || strings.HasSuffix(name, ".sst"):
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			if err := r.readTable(data); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+		}
+	}
+	out := make(map[string][]byte, len(r.entries))
+	for k, e := range r.entries {
+		if !e.deleted {
+			out[k] = e.value
+		}
+	}
+	return out, nil
+}
+
+// readLogRecords reassembles the records of a LevelDB log file. Records
+// with a bad checksum and a torn tail (a crash mid-write) are dropped, as
+// LevelDB itself does on recovery.
+func readLogRecords(data []byte) [][]byte {
+	var records [][]byte
+	var partial []byte
+	inFragment := false
+	for off := 0; off < len(data); {
+		left := logBlockSize - off%logBlockSize
+		if left < logHeaderSize {
+			off += left
+			continue
+		}
+		if off+logHeaderSize > len(data) {
+			break
+		}
+		sum := binary.LittleEndian.Uint32(data[off:])
+		length := int(binary.LittleEndian.Uint16(data[off+4:]))
+		typ := data[off+6]
+		if typ == 0 && length == 0 {
+			off += left
+			continue
+		}
+		end := off + logHeaderSize + length
+		if end > len(data) {
+			break
+		}
+		frag := data[off+logHeaderSize : end]
+		valid := unmaskCRC(sum) == crc32.Update(crc32.Checksum([]byte{typ}, crc32c), crc32c, frag)
+		off = end
+		if !valid {
+			partial, inFragment = nil, false
+			continue
+		}
+		switch typ {
+		case 1: // full
+			records = append(records, append([]byte(nil), frag...))
+			partial, inFragment = nil, false
+		case 2: // first
+			partial, inFragment = append([]byte(nil), frag...), true
+		case 3: // middle
+			if inFragment {
+				partial = append(partial, frag...)
+			}
+		case 4: // last
+			if inFragment {
+				records = append(records, append(partial, frag...))
+			}
+			partial, inFragment = nil, false
+		}
+	}
+	return records
+}
+
+func unmaskCRC(masked uint32) uint32 {
+	rot := masked - 0xa282ead8
+	return rot>>17 | rot<<15
+}
+
+// applyBatch replays one WriteBatch from the log.
+func (r *levelReader) applyBatch(batch []byte) error {
+	if len(batch) < 12 {
+		return errors.New("short write batch")
+	}
+	seq := binary.LittleEndian.Uint64(batch)
+	count := binary.LittleEndian.Uint32(batch[8:])
+	rest := batch[12:]
+	for i := uint32(0); i < count; i++ {
+		if len(rest) == 0 {
+			return errors.New("truncated write batch")
+		}
+		kind := rest[0]
+		key, n := readLengthPrefixed(rest[1:])
+		if n <= 0 {
+			return errors.New("truncated write batch")
+		}
+		rest = rest[1+n:]
+		var value []byte
+		if kind == kindValue {
+			value, n = readLengthPrefixed(rest)
+			if n <= 0 {
+				return errors.New("truncated write batch")
+			}
+			rest = rest[n:]
+		}
+		r.put(key, seq+uint64(i), kind, value)
+	}
+	return nil
+}
+
+func readLengthPrefixed(b []byte) ([]byte, int) {
+	l, n := binary.Uvarint(b)
+	if n <= 0 || uint64(len(b)-n) < l {
+		return nil, 0
+	}
+	return b[n : n+int(l)], n + int(l)
+}
+
+type blockHandle struct {
+	offset, size uint64
+}
+
+func decodeHandle(b []byte) (blockHandle, int) {
+	off, n1 := binary.Uvarint(b)
+	if n1 <= 0 {
+		return blockHandle{}, 0
+	}
+	size, n2 := binary.Uvarint(b[n1:])
+	if n2 <= 0 {
+		return blockHandle{}, 0
+	}
+	return blockHandle{offset: off, size: size}, n1 + n2
+}
+
+// readTable scans every data block of a table file through its index.
+func (r *levelReader) readTable(data []byte) error {
+	if len(data) < tableFooter {
+		return errors.New("table too short")
+	}
+	footer := data[len(data)-tableFooter:]
+	if binary.LittleEndian.Uint64(footer[tableFooter-8:]) != tableMagic {
+		return errors.New("bad table magic")
+	}
+	_, n := decodeHandle(footer)
+	if n <= 0 {
+		return errors.New("bad metaindex handle")
+	}
+	index, m := decodeHandle(footer[n:])
+	if m <= 0 {
+		return errors.New("bad index handle")
+	}
+	indexBlock, err := readBlock(data, index)
+	if err != nil {
+		return err
+	}
+	return iterateBlock(indexBlock, func(_, value []byte) error {
+		h, n := decodeHandle(value)
+		if n <= 0 {
+			return errors.New("bad block handle")
+		}
+		block, err := readBlock(data, h)
+		if err != nil {
+			return err
+		}
+		return iterateBlock(block, func(ikey, value []byte) error {
+			if len(ikey) < 8 {
+				return errors.New("short internal key")
+			}
+			tag := binary.LittleEndian.Uint64(ikey[len(ikey)-8:])
+			r.put(ikey[:len(ikey)-8], tag>>8, byte(tag), value)
+			return nil
+		})
+	})
+}
+
+// readBlock returns the contents of a block, decompressed.
+func readBlock(data []byte, h blockHandle) ([]byte, error) {
+	end := h.offset + h.size
+	if end+5 > uint64(len(data)) || end < h.offset {
+		return nil, errors.New("block out of range")
+	}
+	raw := data[h.offset:end]
+	switch data[end] {
+	case 0:
+		return raw, nil
+	case 1:
+		return snappyDecode(raw)
+	default:
+		return nil, fmt.Errorf("unsupported block compression %d", data[end])
+	}
+}
+
+// iterateBlock walks the prefix-compressed entries of a block.
+func iterateBlock(block []byte, fn func(key, value []byte) error) error {
+	if len(block) < 4 {
+		return errors.New("short block")
+	}
+	restarts := int(binary.LittleEndian.Uint32(block[len(block)-4:]))
+	limit := len(block) - 4 - 4*restarts
+	if restarts < 0 || limit < 0 {
+		return errors.New("bad block restarts")
+	}
+	var key []byte
+	for off := 0; off < limit; {
+		shared, n1 := binary.Uvarint(block[off:])
+		if n1 <= 0 {
+			return errors.New("bad block entry")
+		}
+		nonShared, n2 := binary.Uvarint(block[off+n1:])
+		if n2 <= 0 {
+			return errors.New("bad block entry")
+		}
+		valueLen, n3 := binary.Uvarint(block[off+n1+n2:])
+		if n3 <= 0 {
+			return errors.New("bad block entry")
+		}
+		off += n1 + n2 + n3
+		if shared > uint64(len(key)) || uint64(limit-off) < nonShared+valueLen {
+			return errors.New("bad block entry")
+		}
+		key = append(key[:shared], block[off:off+int(nonShared)]...)
+		off += int(nonShared)
+		value := block[off : off+int(valueLen)]
+		off += int(valueLen)
+		if err := fn(key, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
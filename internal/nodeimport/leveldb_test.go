@@ -0,0 +1,133 @@
+package nodeimport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnappyDecode(t *testing.T) {
+	// "abc" as a literal, then a 1-byte-offset copy of 6 bytes at distance 3.
+	src := []byte{9, 0x08, 'a', 'b', 'c', 0x09, 3}
+	got, err := snappyDecode(src)
+	if err != nil || string(got) != "abcabcabc" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	if _, err := snappyDecode([]byte{4, 0x09, 3}); err == nil {
+		t.Fatal("copy before any output should fail")
+	}
+}
+
+func putUvarint(b []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	return append(b, tmp[:binary.PutUvarint(tmp[:], v)]...)
+}
+
+func putLengthPrefixed(b, s []byte) []byte {
+	return append(putUvarint(b, uint64(len(s))), s...)
+}
+
+type batchOp struct {
+	kind       byte
+	key, value string
+}
+
+func writeBatch(seq uint64, ops ...batchOp) []byte {
+	b := binary.LittleEndian.AppendUint64(nil, seq)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(ops)))
+	for _, op := range ops {
+		b = append(b, op.kind)
+		b = putLengthPrefixed(b, []byte(op.key))
+		if op.kind == kindValue {
+			b = putLengthPrefixed(b, []byte(op.value))
+		}
+	}
+	return b
+}
+
+func logRecord(typ byte, payload []byte) []byte {
+	sum := crc32.Update(crc32.Checksum([]byte{typ}, crc32c), crc32c, payload)
+	masked := (sum>>15 | sum<<17) + 0xa282ead8
+	b := binary.LittleEndian.AppendUint32(nil, masked)
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(payload)))
+	b = append(b, typ)
+	return append(b, payload...)
+}
+
+// buildBlock writes entries without prefix sharing and one restart point.
+func buildBlock(entries [][2][]byte) []byte {
+	var b []byte
+	for _, e := range entries {
+		b = putUvarint(b, 0)
+		b = putUvarint(b, uint64(len(e[0])))
+		b = putUvarint(b, uint64(len(e[1])))
+		b = append(b, e[0]...)
+		b = append(b, e[1]...)
+	}
+	b = binary.LittleEndian.AppendUint32(b, 0)
+	return binary.LittleEndian.AppendUint32(b, 1)
+}
+
+func internalKey(key string, seq uint64, kind byte) []byte {
+	return binary.LittleEndian.AppendUint64([]byte(key), seq<<8|uint64(kind))
+}
+
+func buildTable(entries [][2][]byte) []byte {
+	var out []byte
+	appendBlock := func(block []byte) []byte {
+		handle := putUvarint(putUvarint(nil, uint64(len(out))), uint64(len(block)))
+		out = append(out, block...)
+		out = append(out, 0, 0, 0, 0, 0) // no compression, crc unchecked
+		return handle
+	}
+	data := appendBlock(buildBlock(entries))
+	meta := appendBlock(buildBlock(nil))
+	index := appendBlock(buildBlock([][2][]byte{{[]byte("~"), data}}))
+	footer := append(append([]byte{}, meta...), index...)
+	footer = append(footer, make([]byte, 40-len(footer))...)
+	footer = binary.LittleEndian.AppendUint64(footer, tableMagic)
+	return append(out, footer...)
+}
+
+func TestReadLevelDBMergesLogAndTables(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "CURRENT"), []byte("MANIFEST-000002\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	table := buildTable([][2][]byte{
+		{internalKey("a", 1, kindValue), []byte("old")},
+		{internalKey("b", 2, kindValue), []byte("kept")},
+		{internalKey("c", 3, kindValue), []byte("gone")},
+	})
+	if err := os.WriteFile(filepath.Join(dir, "000005.ldb"), table, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var log []byte
+	log = append(log, logRecord(1, writeBatch(10, batchOp{kindValue, "a", "new"}, batchOp{kindDeletion, "c", ""}))...)
+	// A record split over first/last fragments.
+	big := writeBatch(12, batchOp{kindValue, "d", string(bytes.Repeat([]byte("x"), 100))})
+	log = append(log, logRecord(2, big[:40])...)
+	log = append(log, logRecord(4, big[40:])...)
+	// A corrupt record is skipped.
+	bad := logRecord(1, writeBatch(13, batchOp{kindValue, "b", "corrupt"}))
+	bad[len(bad)-1] ^= 0xff
+	log = append(log, bad...)
+	if err := os.WriteFile(filepath.Join(dir, "000006.log"), log, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	kv, err := readLevelDB(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(kv["a"]) != "new" || string(kv["b"]) != "kept" || len(kv["d"]) != 100 {
+		t.Fatalf("kv=%q", kv)
+	}
+	if _, ok := kv["c"]; ok {
+		t.Fatal("deleted key c still present")
+	}
+}
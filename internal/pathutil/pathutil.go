@@ -0,0 +1,150 @@
+// Package pathutil is the one place storage paths are normalized. Handlers
+// see the same file under several spellings — "/d/a//b", "/dav/a/b/", an
+// fs/get body of "a/b", a File-Path header of "%2Fa%2Fb" — and every cache
+// keyed by path (file info, encrypted-name mappings, list pages, redirects)
+// only hits when they all reduce to the same string.
+//
+// Two kinds of path go through the proxy:
+//   - DisplayPath is what clients see: decrypted names under the Alist root.
+//   - RealPath is what Alist stores: the same directories with encrypted
+//     file names where a passwd rule encrypts names.
+//
+// Both are clean, absolute, slash-separated paths without a trailing slash
+// ("/" for the root). Converting between them needs a passwd rule and lives
+// in the handlers; this package only keeps each side in canonical form.
+package pathutil
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// DisplayPath is a clean path as clients see it, with decrypted names.
+type DisplayPath string
+
+// RealPath is a clean path as Alist stores it, with encrypted names.
+type RealPath string
+
+// Route prefixes under which the proxy serves storage paths: downloads,
+// proxied downloads and WebDAV. "/dav" comes first so "/d" does not eat it.
+var routePrefixes = []string{"/dav", "/d", "/p"}
+
+// Clean returns p as a clean absolute path: a leading slash, no empty, "."
+// or ".." elements and no trailing slash. "" becomes "/". Surrounding
+// spaces are kept, since names may legitimately start or end with one.
+func Clean(p string) string {
+	if p == "" {
+		return "/"
+	}
+	return path.Clean("/" + p)
+}
+
+// CleanInput is Clean for paths typed by a user or read from config, where
+// surrounding whitespace is never meant.
+func CleanInput(p string) string {
+	return Clean(strings.TrimSpace(p))
+}
+
+// Display returns p as a DisplayPath.
+func Display(p string) DisplayPath {
+	return DisplayPath(Clean(p))
+}
+
+// Real returns p as a RealPath.
+func Real(p string) RealPath {
+	return RealPath(Clean(p))
+}
+
+// String returns the path.
+func (p DisplayPath) String() string { return string(p) }
+
+// Key returns the string caches are keyed by.
+func (p DisplayPath) Key() string { return Clean(string(p)) }
+
+// Dir returns the parent directory; the root is its own parent.
+func (p DisplayPath) Dir() DisplayPath { return DisplayPath(path.Dir(p.Key())) }
+
+// Base returns the last element, "/" for the root.
+func (p DisplayPath) Base() string { return path.Base(p.Key()) }
+
+// Join appends elem to p and cleans the result.
+func (p DisplayPath) Join(elem ...string) DisplayPath {
+	return DisplayPath(Join(string(p), elem...))
+}
+
+// Within reports whether p is dir or lies below it.
+func (p DisplayPath) Within(dir DisplayPath) bool { return Within(string(p), string(dir)) }
+
+// String returns the path.
+func (p RealPath) String() string { return string(p) }
+
+// Key returns the string caches are keyed by.
+func (p RealPath) Key() string { return Clean(string(p)) }
+
+// Dir returns the parent directory; the root is its own parent.
+func (p RealPath) Dir() RealPath { return RealPath(path.Dir(p.Key())) }
+
+// Base returns the last element, "/" for the root.
+func (p RealPath) Base() string { return path.Base(p.Key()) }
+
+// Join appends elem to p and cleans the result.
+func (p RealPath) Join(elem ...string) RealPath {
+	return RealPath(Join(string(p), elem...))
+}
+
+// Within reports whether p is dir or lies below it.
+func (p RealPath) Within(dir RealPath) bool { return Within(string(p), string(dir)) }
+
+// Join joins base and elem into one clean path.
+func Join(base string, elem ...string) string {
+	return Clean(path.Join(append([]string{"/", base}, elem...)...))
+}
+
+// Within reports whether p is dir or lies below it, comparing whole
+// elements: "/a/bc" is not within "/a/b". Both are expected clean; a
+// trailing slash on dir is tolerated, and "" or "/" contains everything.
+func Within(p, dir string) bool {
+	dir = strings.TrimRight(dir, "/")
+	return dir == "" || p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+// DirSlash returns dir cleaned with a trailing slash ("/" for the root),
+// the form directory listings and probes are keyed by. "" stays "".
+func DirSlash(dir string) string {
+	dir = strings.TrimSpace(dir)
+	if dir == "" {
+		return ""
+	}
+	clean := Clean(dir)
+	if clean == "/" {
+		return clean
+	}
+	return clean + "/"
+}
+
+// StripRoute returns the storage path a /d, /p or /dav URL path addresses
+// and true, or "" and false for any other URL. "/d" alone addresses the
+// root; "/data" is not a download route.
+func StripRoute(urlPath string) (DisplayPath, bool) {
+	for _, prefix := range routePrefixes {
+		if urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/") {
+			return Display(urlPath[len(prefix):]), true
+		}
+	}
+	return "", false
+}
+
+// EscapeFilePath encodes p for Alist's File-Path header the way the Alist
+// web UI's encodeURIComponent does. Both url.QueryUnescape and
+// url.PathUnescape decode the result to p, where url.QueryEscape alone
+// turns spaces into "+" that PathUnescape keeps.
+func EscapeFilePath(p string) string {
+	return strings.ReplaceAll(url.QueryEscape(p), "+", "%20")
+}
+
+// UnescapeFilePath decodes a File-Path header, accepting both
+// EscapeFilePath's and url.QueryEscape's output.
+func UnescapeFilePath(header string) (string, error) {
+	return url.QueryUnescape(header)
+}
@@ -0,0 +1,214 @@
+package pathutil
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestClean(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"", "/"},
+		{"/", "/"},
+		{"//", "/"},
+		{".", "/"},
+		{"..", "/"},
+		{"a", "/a"},
+		{"/a", "/a"},
+		{"/a/", "/a"},
+		{"a/b/", "/a/b"},
+		{"//a//b//", "/a/b"},
+		{"/a/./b", "/a/b"},
+		{"/a/../b", "/b"},
+		{"/../../etc", "/etc"},
+		{"/电影/2024/", "/电影/2024"},
+		{"/a b/c ", "/a b/c "},
+		{" /a", "/ /a"},
+		{"/a%2Fb", "/a%2Fb"},
+	}
+	for _, tc := range cases {
+		if got := Clean(tc.in); got != tc.want {
+			t.Errorf("Clean(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestCleanInput(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"", "/"},
+		{"   ", "/"},
+		{" /a/b/ ", "/a/b"},
+		{"\ta\n", "/a"},
+		{"/a b", "/a b"},
+	}
+	for _, tc := range cases {
+		if got := CleanInput(tc.in); got != tc.want {
+			t.Errorf("CleanInput(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestTypedPaths(t *testing.T) {
+	d := Display("movies//2024/a.mp4")
+	if d != "/movies/2024/a.mp4" || d.Key() != d.String() {
+		t.Fatalf("Display = %q, key %q", d, d.Key())
+	}
+	if d.Dir() != "/movies/2024" || d.Base() != "a.mp4" {
+		t.Fatalf("Dir/Base = %q %q", d.Dir(), d.Base())
+	}
+	if got := Display("/").Dir(); got != "/" {
+		t.Fatalf("root Dir = %q", got)
+	}
+	if got := Display("").Base(); got != "/" {
+		t.Fatalf("root Base = %q", got)
+	}
+	if got := d.Dir().Join("b.mp4"); got != "/movies/2024/b.mp4" {
+		t.Fatalf("Join = %q", got)
+	}
+	if !d.Within("/movies") || d.Within("/movie") {
+		t.Fatalf("Within wrong for %q", d)
+	}
+	// A literal value that skipped Display still keys like its clean form.
+	if got := DisplayPath("/movies//2024/").Key(); got != "/movies/2024" {
+		t.Fatalf("Key of unclean literal = %q", got)
+	}
+
+	r := Real("/enc/")
+	if r != "/enc" {
+		t.Fatalf("Real = %q", r)
+	}
+	if got := r.Join("x", "../y.bin"); got != "/enc/y.bin" {
+		t.Fatalf("Real Join = %q", got)
+	}
+	if got := r.Join("y.bin"); got.Dir() != r || got.Base() != "y.bin" {
+		t.Fatalf("Real Dir/Base = %q %q", got.Dir(), got.Base())
+	}
+	if !RealPath("/enc/y.bin").Within(r) || r.Within("/enc/y.bin") {
+		t.Fatal("Real Within wrong")
+	}
+	if RealPath("/x").Key() != "/x" || RealPath("x/").String() != "x/" {
+		t.Fatal("Real Key/String wrong")
+	}
+}
+
+func TestJoin(t *testing.T) {
+	cases := []struct {
+		base string
+		elem []string
+		want string
+	}{
+		{"", nil, "/"},
+		{"/", []string{"a"}, "/a"},
+		{"/a", []string{"b", "c"}, "/a/b/c"},
+		{"/a/", []string{"/b/"}, "/a/b"},
+		{"a", []string{"", "b"}, "/a/b"},
+		{"/a", []string{".."}, "/"},
+		{"/a", []string{"../../.."}, "/"},
+	}
+	for _, tc := range cases {
+		if got := Join(tc.base, tc.elem...); got != tc.want {
+			t.Errorf("Join(%q, %q) = %q, want %q", tc.base, tc.elem, got, tc.want)
+		}
+	}
+}
+
+func TestWithin(t *testing.T) {
+	cases := []struct {
+		p, dir string
+		want   bool
+	}{
+		{"/a", "/", true},
+		{"/", "/", true},
+		{"/a", "", true},
+		{"/a", "/a", true},
+		{"/a/b", "/a", true},
+		{"/a/b", "/a/", true},
+		{"/a/b/c", "/a/b", true},
+		{"/ab", "/a", false},
+		{"/a", "/a/b", false},
+		{"/", "/a", false},
+		{"/b", "/a", false},
+	}
+	for _, tc := range cases {
+		if got := Within(tc.p, tc.dir); got != tc.want {
+			t.Errorf("Within(%q, %q) = %v, want %v", tc.p, tc.dir, got, tc.want)
+		}
+	}
+}
+
+func TestDirSlash(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"", ""},
+		{"  ", ""},
+		{"/", "/"},
+		{".", "/"},
+		{"a", "/a/"},
+		{"/a/", "/a/"},
+		{" /a//b ", "/a/b/"},
+	}
+	for _, tc := range cases {
+		if got := DirSlash(tc.in); got != tc.want {
+			t.Errorf("DirSlash(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestStripRoute(t *testing.T) {
+	cases := []struct {
+		in   string
+		want DisplayPath
+		ok   bool
+	}{
+		{"/d/a/b.mp4", "/a/b.mp4", true},
+		{"/p/a/b.mp4", "/a/b.mp4", true},
+		{"/dav/a/b.mp4", "/a/b.mp4", true},
+		{"/d", "/", true},
+		{"/p/", "/", true},
+		{"/dav", "/", true},
+		{"/dav/", "/", true},
+		{"/dav/dir/", "/dir", true},
+		{"/d//a//b", "/a/b", true},
+		{"/d/a/../../secret", "/secret", true},
+		{"/data/x", "", false},
+		{"/pub", "", false},
+		{"/davx/a", "", false},
+		{"/api/fs/get", "", false},
+		{"/", "", false},
+		{"", "", false},
+		{"d/a", "", false},
+	}
+	for _, tc := range cases {
+		got, ok := StripRoute(tc.in)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("StripRoute(%q) = %q, %v, want %q, %v", tc.in, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestEscapeFilePath(t *testing.T) {
+	cases := []struct{ in, want string }{
+		{"/a/b.txt", "%2Fa%2Fb.txt"},
+		{"/a b/c", "%2Fa%20b%2Fc"},
+		{"/a+b", "%2Fa%2Bb"},
+		{"/电影", "%2F%E7%94%B5%E5%BD%B1"},
+		{"/100%", "%2F100%25"},
+	}
+	for _, tc := range cases {
+		got := EscapeFilePath(tc.in)
+		if got != tc.want {
+			t.Errorf("EscapeFilePath(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+		// Alist decodes with PathUnescape, the proxy with UnescapeFilePath.
+		if back, err := url.PathUnescape(got); err != nil || back != tc.in {
+			t.Errorf("PathUnescape(%q) = %q, %v", got, back, err)
+		}
+		if back, err := UnescapeFilePath(got); err != nil || back != tc.in {
+			t.Errorf("UnescapeFilePath(%q) = %q, %v", got, back, err)
+		}
+	}
+	if got, err := UnescapeFilePath(url.QueryEscape("/a b")); err != nil || got != "/a b" {
+		t.Fatalf("UnescapeFilePath(QueryEscape) = %q, %v", got, err)
+	}
+	if _, err := UnescapeFilePath("%zz"); err == nil {
+		t.Fatal("bad escape decoded without error")
+	}
+}
@@ -0,0 +1,261 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/trace"
+)
+
+// throttleChunk is the most written between two waits, so a 512 KB copy
+// buffer leaves the proxy as a steady flow instead of bursts.
+const throttleChunk = 32 * 1024
+
+// byteBucket is a token bucket counted in bytes. Writes reserve what they
+// send and wait off any debt, like workers.Budget does for requests.
+type byteBucket struct {
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newByteBucket(kbps int, now time.Time) *byteBucket {
+	rate := float64(kbps) * 1024
+	// A quarter second of slack, and at least one chunk, absorbs scheduling
+	// jitter without letting a stream run far ahead of its rate.
+	burst := max(rate/4, throttleChunk)
+	return &byteBucket{rate: rate, burst: burst, tokens: burst, last: now}
+}
+
+// reserve takes n bytes and returns how long the caller must wait before
+// sending them.
+func (b *byteBucket) reserve(n int, now time.Time) time.Duration {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+	}
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// clientBandwidth is the bucket shared by the streams of one client
+// address; it is dropped with the last of them.
+type clientBandwidth struct {
+	bucket  *byteBucket
+	streams int
+}
+
+// bandwidthLimiter applies the bandwidth config to response bodies.
+type bandwidthLimiter struct {
+	cfg    config.BandwidthConfig
+	exempt []*net.IPNet
+	now    func() time.Time
+
+	mu      sync.Mutex
+	clients map[string]*clientBandwidth
+
+	active    int64
+	waitNanos int64
+}
+
+func newBandwidthLimiter(cfg *config.BandwidthConfig) *bandwidthLimiter {
+	if cfg == nil || (cfg.PerStreamKBps <= 0 && cfg.PerClientKBps <= 0) {
+		return nil
+	}
+	exempt, err := config.ParseIPNets(cfg.Exempt)
+	if err != nil {
+		log.Warn().Err(err).Msg("Ignoring bandwidth exemptions")
+	}
+	return &bandwidthLimiter{
+		cfg:     *cfg,
+		exempt:  exempt,
+		now:     time.Now,
+		clients: make(map[string]*clientBandwidth),
+	}
+}
+
+// same reports whether l was built from cfg.
+func (l *bandwidthLimiter) same(cfg *config.BandwidthConfig) bool {
+	if l == nil {
+		return newBandwidthLimiter(cfg) == nil
+	}
+	return cfg != nil && reflect.DeepEqual(l.cfg, *cfg)
+}
+
+// bandwidthClient returns the address a request's bytes are charged to.
+func bandwidthClient(r *http.Request) string {
+	if ip := trace.GetClientIP(r.Context()); ip != "" {
+		return ip
+	}
+	host, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+	if err != nil {
+		return strings.TrimSpace(r.RemoteAddr)
+	}
+	return host
+}
+
+func (l *bandwidthLimiter) exempted(client string) bool {
+	ip := net.ParseIP(client)
+	if ip == nil {
+		return false
+	}
+	for _, n := range l.exempt {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// wrap returns a writer that paces w to the stream and client caps, and
+// the function that ends the stream. Exempt clients get w back.
+func (l *bandwidthLimiter) wrap(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	if l == nil {
+		return w, func() {}
+	}
+	client := bandwidthClient(r)
+	if l.exempted(client) {
+		return w, func() {}
+	}
+	now := l.now()
+	tw := &throttledWriter{ResponseWriter: w, ctx: r.Context(), limiter: l}
+	if l.cfg.PerStreamKBps > 0 {
+		tw.stream = newByteBucket(l.cfg.PerStreamKBps, now)
+	}
+	if l.cfg.PerClientKBps > 0 {
+		l.mu.Lock()
+		cb := l.clients[client]
+		if cb == nil {
+			cb = &clientBandwidth{bucket: newByteBucket(l.cfg.PerClientKBps, now)}
+			l.clients[client] = cb
+		}
+		cb.streams++
+		l.mu.Unlock()
+		tw.client = cb
+	}
+	atomic.AddInt64(&l.active, 1)
+	var done atomic.Bool
+	return tw, func() {
+		if done.Swap(true) {
+			return
+		}
+		atomic.AddInt64(&l.active, -1)
+		if tw.client == nil {
+			return
+		}
+		l.mu.Lock()
+		if tw.client.streams--; tw.client.streams == 0 {
+			delete(l.clients, client)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// reserve charges n bytes to the stream and client buckets and returns the
+// longer of their waits.
+func (l *bandwidthLimiter) reserve(tw *throttledWriter, n int) time.Duration {
+	now := l.now()
+	var wait time.Duration
+	if tw.stream != nil {
+		wait = tw.stream.reserve(n, now)
+	}
+	if tw.client != nil {
+		l.mu.Lock()
+		wait = max(wait, tw.client.bucket.reserve(n, now))
+		l.mu.Unlock()
+	}
+	return wait
+}
+
+func (l *bandwidthLimiter) stats() map[string]interface{} {
+	if l == nil {
+		return map[string]interface{}{"enabled": false}
+	}
+	l.mu.Lock()
+	clients := len(l.clients)
+	l.mu.Unlock()
+	return map[string]interface{}{
+		"enabled":          true,
+		"per_stream_kbps":  l.cfg.PerStreamKBps,
+		"per_client_kbps":  l.cfg.PerClientKBps,
+		"active_streams":   atomic.LoadInt64(&l.active),
+		"active_clients":   clients,
+		"throttled_millis": time.Duration(atomic.LoadInt64(&l.waitNanos)).Milliseconds(),
+	}
+}
+
+// throttledWriter paces writes to the response. It hides the underlying
+// writer's ReadFrom so io.CopyBuffer goes through Write.
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	limiter *bandwidthLimiter
+	stream  *byteBucket
+	client  *clientBandwidth
+}
+
+func (tw *throttledWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p[:min(len(p), throttleChunk)]
+		if wait := tw.limiter.reserve(tw, len(chunk)); wait > 0 {
+			atomic.AddInt64(&tw.limiter.waitNanos, int64(wait))
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-tw.ctx.Done():
+				timer.Stop()
+				return written, tw.ctx.Err()
+			}
+		}
+		n, err := tw.ResponseWriter.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (tw *throttledWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
+}
+
+// Flush keeps streaming responses flushable through the throttle.
+func (tw *throttledWriter) Flush() {
+	if f, ok := tw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Throttle paces w to the bandwidth caps for r's client. The returned
+// function must be called once the response body is written.
+func (s *StreamProxy) Throttle(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	if s == nil {
+		return w, func() {}
+	}
+	return s.bandwidth.Load().wrap(w, r)
+}
+
+// BandwidthStats returns the bandwidth caps and how many streams they pace.
+func (s *StreamProxy) BandwidthStats() map[string]interface{} {
+	if s == nil {
+		return (*bandwidthLimiter)(nil).stats()
+	}
+	return s.bandwidth.Load().stats()
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/trace"
+)
+
+func TestByteBucketReserve(t *testing.T) {
+	start := time.Unix(1000, 0)
+	b := newByteBucket(1024, start) // 1 MiB/s, burst 256 KiB
+	if wait := b.reserve(256*1024, start); wait != 0 {
+		t.Fatalf("burst wait = %s, want 0", wait)
+	}
+	if wait := b.reserve(512*1024, start); wait != 500*time.Millisecond {
+		t.Fatalf("wait = %s, want 500ms for 512 KiB of debt", wait)
+	}
+	// A second pays off the debt and refills the burst, no more.
+	if wait := b.reserve(0, start.Add(time.Second)); wait != 0 {
+		t.Fatalf("wait after a second = %s, want 0", wait)
+	}
+	if b.tokens != b.burst {
+		t.Fatalf("tokens = %v, want the burst %v", b.tokens, b.burst)
+	}
+}
+
+func bandwidthRequest(ip string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/d/movie.mkv", nil)
+	return r.WithContext(trace.WithClientIP(r.Context(), ip))
+}
+
+func TestBandwidthClientBucketIsShared(t *testing.T) {
+	l := newBandwidthLimiter(&config.BandwidthConfig{Enable: true, PerClientKBps: 100, Exempt: []string{"192.168.0.0/16"}})
+	w1, done1 := l.wrap(httptest.NewRecorder(), bandwidthRequest("203.0.113.5"))
+	w2, done2 := l.wrap(httptest.NewRecorder(), bandwidthRequest("203.0.113.5"))
+	_, done3 := l.wrap(httptest.NewRecorder(), bandwidthRequest("203.0.113.6"))
+	if w1.(*throttledWriter).client != w2.(*throttledWriter).client {
+		t.Fatal("streams of one client got separate buckets")
+	}
+	if got := l.stats()["active_clients"]; got != 2 {
+		t.Fatalf("active_clients = %v, want 2", got)
+	}
+	done1()
+	done1()
+	done2()
+	done3()
+	if got := l.stats()["active_clients"]; got != 0 {
+		t.Fatalf("active_clients = %v after all streams ended", got)
+	}
+
+	rec := httptest.NewRecorder()
+	if w, _ := l.wrap(rec, bandwidthRequest("192.168.1.20")); w != rec {
+		t.Fatal("exempt client throttled")
+	}
+}
+
+func TestThrottledWriterPacesStream(t *testing.T) {
+	l := newBandwidthLimiter(&config.BandwidthConfig{Enable: true, PerStreamKBps: 2048})
+	rec := httptest.NewRecorder()
+	w, done := l.wrap(rec, bandwidthRequest("203.0.113.5"))
+	defer done()
+
+	// 512 KiB burst, then 512 KiB more at 2 MiB/s: about 250ms.
+	start := time.Now()
+	n, err := w.Write(make([]byte, 1024*1024))
+	if err != nil || n != 1024*1024 || rec.Body.Len() != n {
+		t.Fatalf("n=%d err=%v body=%d", n, err, rec.Body.Len())
+	}
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Fatalf("1 MiB at 2 MiB/s took %s", elapsed)
+	}
+}
+
+func TestThrottledWriterStopsWithRequest(t *testing.T) {
+	l := newBandwidthLimiter(&config.BandwidthConfig{Enable: true, PerStreamKBps: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	r := bandwidthRequest("203.0.113.5").WithContext(ctx)
+	w, done := l.wrap(httptest.NewRecorder(), r)
+	defer done()
+	time.AfterFunc(20*time.Millisecond, cancel)
+	n, err := w.Write(make([]byte, 256*1024))
+	if err != context.Canceled || n != throttleChunk {
+		t.Fatalf("n=%d err=%v, want the first chunk and context.Canceled", n, err)
+	}
+}
+
+func TestStreamProxyApplyConfigSwapsBandwidth(t *testing.T) {
+	cfg := config.DefaultConfig()
+	s := NewStreamProxy(cfg)
+	if s.bandwidth.Load() != nil {
+		t.Fatal("bandwidth limiter without a bandwidth section")
+	}
+	cfg.Bandwidth = &config.BandwidthConfig{Enable: true, PerStreamKBps: 500}
+	s.ApplyConfig(cfg)
+	first := s.bandwidth.Load()
+	if first == nil {
+		t.Fatal("bandwidth section not applied")
+	}
+	s.ApplyConfig(cfg)
+	if s.bandwidth.Load() != first {
+		t.Fatal("unchanged bandwidth section rebuilt the limiter")
+	}
+	cfg.Bandwidth.Enable = false
+	s.ApplyConfig(cfg)
+	if s.bandwidth.Load() != nil {
+		t.Fatal("disabled bandwidth section still throttles")
+	}
+}
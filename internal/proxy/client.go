@@ -82,6 +82,57 @@ func baseTransport(cfg *config.Config) *http.Transport {
 	}
 }
 
+// upstreamHTTP2Config maps config.HTTP2 upstream tuning onto net/http's
+// HTTP2Config, which golang.org/x/net/http2 merges into its transport settings.
+// Returns nil when nothing is tuned so library defaults apply.
+func upstreamHTTP2Config(cfg *config.Config) *http.HTTP2Config {
+	if cfg == nil || cfg.HTTP2 == nil {
+		return nil
+	}
+	h := cfg.HTTP2
+	if h.UpstreamMaxReadFrameSize <= 0 && h.UpstreamConnWindowKb <= 0 && h.UpstreamStreamWindowKb <= 0 {
+		return nil
+	}
+	return &http.HTTP2Config{
+		MaxReadFrameSize:              h.UpstreamMaxReadFrameSize,
+		MaxReceiveBufferPerConnection: h.UpstreamConnWindowKb * 1024,
+		MaxReceiveBufferPerStream:     h.UpstreamStreamWindowKb * 1024,
+	}
+}
+
+// configureHTTP2 enables HTTP/2 on transport with the configured flow control.
+func configureHTTP2(transport *http.Transport, cfg *config.Config) {
+	transport.HTTP2 = upstreamHTTP2Config(cfg)
+	t2, err := http2.ConfigureTransports(transport)
+	if err != nil {
+		log.Warn().Err(err).Msg("Failed to configure HTTP/2 transport")
+		return
+	}
+	if cfg != nil && cfg.HTTP2 != nil {
+		t2.StrictMaxConcurrentStreams = cfg.HTTP2.UpstreamStrictMaxConcurrency
+	}
+}
+
+// newH2CTransport builds a cleartext HTTP/2 transport for the Alist backend.
+// It is derived from an http.Transport so HTTP2Config window tuning applies.
+func newH2CTransport(cfg *config.Config) http.RoundTripper {
+	t1 := &http.Transport{HTTP2: upstreamHTTP2Config(cfg)}
+	t2, err := http2.ConfigureTransports(t1)
+	if err != nil {
+		t2 = &http2.Transport{}
+	}
+	t2.AllowHTTP = true // Allow HTTP/2 over cleartext (h2c)
+	t2.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+		// For h2c, we dial without TLS
+		var d net.Dialer
+		return d.DialContext(ctx, network, addr)
+	}
+	if cfg != nil && cfg.HTTP2 != nil {
+		t2.StrictMaxConcurrentStreams = cfg.HTTP2.UpstreamStrictMaxConcurrency
+	}
+	return t2
+}
+
 func parseHostOnly(hostport string) string {
 	host := strings.ToLower(strings.TrimSpace(hostport))
 	if host == "" {
@@ -228,10 +279,10 @@ func NewHTTPClient(cfg *config.Config, timeout time.Duration) *http.Client {
 	transport := baseTransport(cfg)
 	transport.Proxy = proxyFunc(cfg)
 	if cfg.Proxy.EnableHTTP2 {
-		http2.ConfigureTransport(transport)
+		configureHTTP2(transport, cfg)
 	}
 	return &http.Client{
-		Transport: transport,
+		Transport: markLoops(withFailover(transport), cfg),
 		Timeout:   timeout,
 		CheckRedirect: func(req *http.Request, via []*http.Request) error {
 			return http.ErrUseLastResponse
@@ -246,9 +297,9 @@ func NewSharedTransport(cfg *config.Config) http.RoundTripper {
 	transport := baseTransport(cfg)
 	transport.Proxy = proxyFunc(cfg)
 	if cfg != nil && cfg.Proxy != nil && cfg.Proxy.EnableHTTP2 {
-		http2.ConfigureTransport(transport)
+		configureHTTP2(transport, cfg)
 	}
-	return transport
+	return markLoops(withFailover(transport), cfg)
 }
 
 // NewHTTPClientWithTransport creates an http.Client reusing a shared transport.
@@ -271,12 +322,12 @@ func NewClient(cfg *config.Config) *Client {
 
 	// Configure HTTP/2 if enabled
 	if proxyCfg.EnableHTTP2 {
-		http2.ConfigureTransport(transport)
+		configureHTTP2(transport, cfg)
 	}
 
 	client := &Client{
 		Client: &http.Client{
-			Transport: transport,
+			Transport: markLoops(withFailover(transport), cfg),
 			Timeout:   0, // No timeout for streaming
 			CheckRedirect: func(req *http.Request, via []*http.Request) error {
 				return http.ErrUseLastResponse // Don't follow redirects automatically
@@ -287,16 +338,8 @@ func NewClient(cfg *config.Config) *Client {
 
 	// Create h2c client if enabled for backend connections
 	if cfg.AlistServer.EnableH2C {
-		h2cTransport := &http2.Transport{
-			AllowHTTP: true, // Allow HTTP/2 over cleartext (h2c)
-			DialTLSContext: func(ctx context.Context, network, addr string, cfg *tls.Config) (net.Conn, error) {
-				// For h2c, we dial without TLS
-				var d net.Dialer
-				return d.DialContext(ctx, network, addr)
-			},
-		}
 		client.h2cClient = &http.Client{
-			Transport: h2cTransport,
+			Transport: markLoops(withFailover(newH2CTransport(cfg)), cfg),
 			Timeout:   0,
 			CheckRedirect: func(req *http.Request, via []*http.Request) error {
 				return http.ErrUseLastResponse
@@ -91,3 +91,36 @@ func TestProxyFuncFixedMode(t *testing.T) {
 		t.Fatalf("expected direct route for private cidr, got %#v", route)
 	}
 }
+
+func TestUpstreamHTTP2ConfigDefaultsToNil(t *testing.T) {
+	cfg := config.DefaultConfig()
+	if got := upstreamHTTP2Config(cfg); got != nil {
+		t.Fatalf("expected nil HTTP2Config for untuned upstream, got %#v", got)
+	}
+}
+
+func TestUpstreamHTTP2ConfigAppliesWindows(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.HTTP2.UpstreamConnWindowKb = 32 * 1024
+	cfg.HTTP2.UpstreamStreamWindowKb = 16 * 1024
+	cfg.HTTP2.UpstreamMaxReadFrameSize = 1 << 20
+
+	got := upstreamHTTP2Config(cfg)
+	if got == nil {
+		t.Fatal("expected HTTP2Config")
+	}
+	if got.MaxReceiveBufferPerConnection != 32<<20 {
+		t.Fatalf("conn window=%d", got.MaxReceiveBufferPerConnection)
+	}
+	if got.MaxReceiveBufferPerStream != 16<<20 {
+		t.Fatalf("stream window=%d", got.MaxReceiveBufferPerStream)
+	}
+	if got.MaxReadFrameSize != 1<<20 {
+		t.Fatalf("frame size=%d", got.MaxReadFrameSize)
+	}
+
+	transport := NewSharedTransport(cfg).(*loopMarkTransport).base.(*failoverTransport).base.(*http.Transport)
+	if transport.HTTP2 == nil || transport.HTTP2.MaxReceiveBufferPerStream != 16<<20 {
+		t.Fatalf("shared transport missing HTTP2 tuning: %#v", transport.HTTP2)
+	}
+}
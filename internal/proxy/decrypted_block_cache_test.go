@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"io"
 	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
 )
 
 func TestDecryptedBlockCacheRangeHit(t *testing.T) {
@@ -100,3 +102,31 @@ func TestDecryptedBlockCacheStats(t *testing.T) {
 		t.Fatalf("miss_count=%v", stats["miss_count"])
 	}
 }
+
+func TestStreamProxyApplyConfigSwapsCachesOnlyWhenSettingsChange(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.AlistServer.EnableDecryptedBlockCache = true
+	cfg.AlistServer.DecryptedBlockCacheMb = 32
+	s := NewStreamProxy(cfg)
+	first := s.blockCacheRef()
+	if first == nil {
+		t.Fatal("block cache not built")
+	}
+
+	s.ApplyConfig(cfg)
+	if s.blockCacheRef() != first {
+		t.Fatal("unchanged settings must keep the warm cache")
+	}
+
+	cfg.AlistServer.DecryptedBlockCacheMb = 64
+	s.ApplyConfig(cfg)
+	if got := s.DecryptedBlockCacheStats()["max_bytes"]; got != int64(64*1024*1024) {
+		t.Fatalf("max_bytes=%v after resize", got)
+	}
+
+	cfg.AlistServer.EnableDecryptedBlockCache = false
+	s.ApplyConfig(cfg)
+	if s.blockCacheRef() != nil {
+		t.Fatal("disabling the cache should drop it")
+	}
+}
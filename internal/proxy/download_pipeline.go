@@ -0,0 +1,184 @@
+package proxy
+
+import (
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/rs/zerolog/log"
+)
+
+// DownloadTransform is an optional stage of the download pipeline. It runs
+// after decryption and the built-in stages, on the plaintext window the
+// client asked for. Like UploadTransform it must preserve the length byte for
+// byte: Content-Length and Content-Range are sent before the body is read.
+type DownloadTransform interface {
+	Name() string
+	// Wrap receives the plaintext starting at file offset offset.
+	Wrap(r io.Reader, offset int64) io.Reader
+}
+
+var (
+	downloadTransformsMu sync.RWMutex
+	downloadTransforms   = map[string]DownloadTransform{}
+)
+
+// RegisterDownloadTransform makes t available to passwd entries under
+// t.Name(). A later registration with the same name replaces the earlier one.
+func RegisterDownloadTransform(t DownloadTransform) {
+	downloadTransformsMu.Lock()
+	defer downloadTransformsMu.Unlock()
+	downloadTransforms[t.Name()] = t
+}
+
+// downloadTransformsFor resolves the transforms passwdInfo declares. Unknown
+// names are logged and skipped.
+func downloadTransformsFor(passwdInfo *config.PasswdInfo) []DownloadTransform {
+	if passwdInfo == nil || len(passwdInfo.DownloadTransforms) == 0 {
+		return nil
+	}
+	downloadTransformsMu.RLock()
+	defer downloadTransformsMu.RUnlock()
+	stages := make([]DownloadTransform, 0, len(passwdInfo.DownloadTransforms))
+	for _, name := range passwdInfo.DownloadTransforms {
+		t, ok := downloadTransforms[name]
+		if !ok {
+			log.Warn().Str("transform", name).Msg("Unknown download transform, skipping")
+			continue
+		}
+		stages = append(stages, t)
+	}
+	return stages
+}
+
+// downloadPipeline assembles the reader chain that feeds a decrypted
+// response: upstream → decrypt → range → sniff → caches → transforms. Every
+// stage is metered so getStats can show where time goes.
+type downloadPipeline struct {
+	reader io.Reader
+	meters []*stageMeter
+}
+
+func newDownloadPipeline(source string, r io.Reader) *downloadPipeline {
+	p := &downloadPipeline{}
+	p.reader = p.meter(source, r)
+	return p
+}
+
+// add wraps the current reader with stage name.
+func (p *downloadPipeline) add(name string, wrap func(io.Reader) io.Reader) {
+	p.reader = p.meter(name, wrap(p.reader))
+}
+
+// addTransforms appends the passwd entry's registered transforms.
+func (p *downloadPipeline) addTransforms(transforms []DownloadTransform, offset int64) {
+	for _, t := range transforms {
+		p.add(t.Name(), func(r io.Reader) io.Reader { return t.Wrap(r, offset) })
+	}
+}
+
+func (p *downloadPipeline) meter(name string, r io.Reader) io.Reader {
+	m := &stageMeter{name: name, src: r}
+	p.meters = append(p.meters, m)
+	return m
+}
+
+// stageMeter counts bytes and wall time spent in one stage's Read. Reads are
+// pulled through the chain, so a stage's own cost is its elapsed time minus
+// that of the stage below it.
+type stageMeter struct {
+	name    string
+	src     io.Reader
+	bytes   int64
+	elapsed time.Duration
+}
+
+func (m *stageMeter) Read(b []byte) (int, error) {
+	start := time.Now()
+	n, err := m.src.Read(b)
+	m.elapsed += time.Since(start)
+	m.bytes += int64(n)
+	return n, err
+}
+
+// downloadPipelineStats aggregates stage meters across responses.
+type downloadPipelineStats struct {
+	mu     sync.Mutex
+	order  []string
+	stages map[string]*stageTotals
+}
+
+type stageTotals struct {
+	Streams int64
+	Bytes   int64
+	Self    time.Duration
+}
+
+func newDownloadPipelineStats() *downloadPipelineStats {
+	return &downloadPipelineStats{stages: make(map[string]*stageTotals)}
+}
+
+func (st *downloadPipelineStats) record(p *downloadPipeline) {
+	if st == nil || p == nil {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var below time.Duration
+	for _, m := range p.meters {
+		t, ok := st.stages[m.name]
+		if !ok {
+			t = &stageTotals{}
+			st.stages[m.name] = t
+			st.order = append(st.order, m.name)
+		}
+		t.Streams++
+		t.Bytes += m.bytes
+		if self := m.elapsed - below; self > 0 {
+			t.Self += self
+		}
+		below = m.elapsed
+	}
+}
+
+func (st *downloadPipelineStats) snapshot() map[string]interface{} {
+	stages := make([]map[string]interface{}, 0)
+	if st != nil {
+		st.mu.Lock()
+		for _, name := range st.order {
+			t := st.stages[name]
+			stages = append(stages, map[string]interface{}{
+				"stage":   name,
+				"streams": t.Streams,
+				"bytes":   t.Bytes,
+				"self_ms": t.Self.Milliseconds(),
+			})
+		}
+		st.mu.Unlock()
+	}
+	return map[string]interface{}{"stages": stages}
+}
+
+// DownloadPipelineStats returns per-stage totals of the download pipeline.
+func (s *StreamProxy) DownloadPipelineStats() map[string]interface{} {
+	if s == nil {
+		return newDownloadPipelineStats().snapshot()
+	}
+	return s.pipelineStats.snapshot()
+}
+
+// streamPipeline copies p to w, paced to the bandwidth caps, and records
+// its meters.
+func (s *StreamProxy) streamPipeline(w http.ResponseWriter, req *http.Request, p *downloadPipeline) (int64, error) {
+	buf := getBuffer()
+	defer putBuffer(buf)
+	out, done := s.Throttle(w, req)
+	defer done()
+	written, err := io.CopyBuffer(out, p.reader, *buf)
+	if s != nil {
+		s.pipelineStats.record(p)
+	}
+	return written, err
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/encryption"
+)
+
+// offsetStamp replaces every byte with the low byte of its file offset, so
+// tests can check both the order of stages and the offset they were given.
+type offsetStamp struct{}
+
+func (offsetStamp) Name() string { return "test_offset_stamp" }
+
+func (offsetStamp) Wrap(r io.Reader, offset int64) io.Reader {
+	return &offsetStampReader{r: r, pos: offset}
+}
+
+type offsetStampReader struct {
+	r   io.Reader
+	pos int64
+}
+
+func (o *offsetStampReader) Read(p []byte) (int, error) {
+	n, err := o.r.Read(p)
+	for i := 0; i < n; i++ {
+		p[i] = byte(o.pos)
+		o.pos++
+	}
+	return n, err
+}
+
+func TestDownloadPipelineAppliesTransformsAndMeters(t *testing.T) {
+	RegisterDownloadTransform(offsetStamp{})
+	plain := bytes.Repeat([]byte("plaintext!"), 100)
+	ciphertext := append([]byte(nil), plain...)
+	flow, err := encryption.NewFlowEnc("123456", "aesctr", int64(len(plain)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flow.Encrypt(ciphertext)
+
+	sp := NewStreamProxy(config.DefaultConfig())
+	sp.cfg.AlistServer.EnableSniff = false
+	sp.client = newTestClient(func(r *http.Request) (*http.Response, error) {
+		headers := make(http.Header)
+		headers.Set("Content-Length", strconv.Itoa(len(ciphertext)))
+		return &http.Response{StatusCode: http.StatusOK, Header: headers, Body: io.NopCloser(bytes.NewReader(ciphertext)), Request: r}, nil
+	})
+	passwd := &config.PasswdInfo{Password: "123456", EncType: "aesctr", Enable: true, DownloadTransforms: []string{"test_offset_stamp", "missing"}}
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/d/test.bin", nil)
+	req.Header.Set("Range", "bytes=300-309")
+	result := sp.ProxyDownloadDecryptWithStrategyForStorage(rr, req, "http://upstream.local/file", passwd, int64(len(plain)), StreamStrategyFull, "/")
+	if result.Err != nil {
+		t.Fatalf("stream: %v", result.Err)
+	}
+	want := []byte{44, 45, 46, 47, 48, 49, 50, 51, 52, 53} // offsets 300..309
+	if !bytes.Equal(rr.Body.Bytes(), want) {
+		t.Fatalf("body %v, want %v", rr.Body.Bytes(), want)
+	}
+
+	stages, _ := sp.DownloadPipelineStats()["stages"].([]map[string]interface{})
+	names := make([]string, 0, len(stages))
+	for _, st := range stages {
+		names = append(names, st["stage"].(string))
+	}
+	wantNames := []string{"upstream", "decrypt", "range", "block_cache", "test_offset_stamp"}
+	if len(names) != len(wantNames) {
+		t.Fatalf("stages %v, want %v", names, wantNames)
+	}
+	for i := range names {
+		if names[i] != wantNames[i] {
+			t.Fatalf("stages %v, want %v", names, wantNames)
+		}
+	}
+	if got := stages[len(stages)-1]["bytes"].(int64); got != 10 {
+		t.Fatalf("last stage bytes=%d, want 10", got)
+	}
+}
@@ -0,0 +1,391 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+const (
+	failoverProbeTimeout = 5 * time.Second
+	// A target is taken out after this many failed checks in a row, and put
+	// back after as many good ones, so one lost probe does not flap traffic.
+	failoverDownAfter = 2
+	failoverUpAfter   = 2
+)
+
+// activeFailover is the failover state every upstream transport consults;
+// nil until StartFailover runs.
+var activeFailover atomic.Pointer[Failover]
+
+// Failover sends requests for the Alist server to the first healthy address
+// in GetAlistURLs, so the LAN address can be listed first and a WAN or
+// tunnel address take over when it goes down. Targets are health checked
+// every failoverCheckSeconds; traffic returns to an earlier target as soon
+// as it passes its checks again. A connection error also takes the target
+// out at once, and GET/HEAD requests are retried on the next target.
+type Failover struct {
+	cfg   *config.Config
+	probe *http.Client
+
+	mu      sync.RWMutex
+	targets []*failoverTarget
+	active  int
+}
+
+type failoverTarget struct {
+	base      *url.URL
+	up        bool
+	fails     int
+	oks       int
+	checked   time.Time
+	lastError string
+}
+
+// FailoverTarget is the state of one address for /enc-api/status.
+type FailoverTarget struct {
+	URL         string    `json:"url"`
+	Up          bool      `json:"up"`
+	Active      bool      `json:"active"`
+	LastChecked time.Time `json:"last_checked"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// StartFailover installs failover for cfg's Alist server and health checks
+// its addresses until ctx is cancelled. Without failoverHosts it only
+// installs the state, which then routes everything to the primary address;
+// hosts added later through the config API are picked up by the next check.
+func StartFailover(ctx context.Context, cfg *config.Config) *Failover {
+	f := NewFailover(cfg)
+	activeFailover.Store(f)
+	go func() {
+		for {
+			if len(cfg.AlistServer.FailoverHosts) > 0 {
+				f.CheckOnce(ctx)
+			} else {
+				f.sync()
+			}
+			interval := time.Duration(cfg.AlistServer.FailoverCheckSeconds) * time.Second
+			if interval <= 0 {
+				interval = 10 * time.Second
+			}
+			select {
+			case <-ctx.Done():
+				activeFailover.CompareAndSwap(f, nil)
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+	return f
+}
+
+// NewFailover creates failover state for cfg with every address assumed up.
+func NewFailover(cfg *config.Config) *Failover {
+	transport := baseTransport(cfg)
+	transport.Proxy = proxyFunc(cfg)
+	f := &Failover{
+		cfg: cfg,
+		// Probes go straight to each address, not through failover.
+		probe: &http.Client{
+			Transport: transport,
+			Timeout:   failoverProbeTimeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+	f.sync()
+	return f
+}
+
+// sync rebuilds the target list from the config, keeping the state of
+// addresses that are still listed.
+func (f *Failover) sync() {
+	urls := f.cfg.GetAlistURLs()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(urls) == len(f.targets) {
+		same := true
+		for i, raw := range urls {
+			if f.targets[i].base.String() != raw {
+				same = false
+				break
+			}
+		}
+		if same {
+			return
+		}
+	}
+	old := make(map[string]*failoverTarget, len(f.targets))
+	for _, t := range f.targets {
+		old[t.base.String()] = t
+	}
+	targets := make([]*failoverTarget, 0, len(urls))
+	for _, raw := range urls {
+		if t, ok := old[raw]; ok {
+			targets = append(targets, t)
+			continue
+		}
+		base, err := url.Parse(raw)
+		if err != nil {
+			continue
+		}
+		targets = append(targets, &failoverTarget{base: base, up: true})
+	}
+	f.targets = targets
+	f.selectLocked()
+}
+
+// CheckOnce health checks every address concurrently and updates the
+// active one.
+func (f *Failover) CheckOnce(ctx context.Context) {
+	f.sync()
+	f.mu.RLock()
+	targets := append([]*failoverTarget(nil), f.targets...)
+	f.mu.RUnlock()
+
+	errs := make([]error, len(targets))
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		wg.Add(1)
+		go func(i int, base string) {
+			defer wg.Done()
+			errs[i] = f.check(ctx, base)
+		}(i, t.base.String())
+	}
+	wg.Wait()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	for i, t := range targets {
+		t.checked = now
+		if errs[i] == nil {
+			t.fails = 0
+			t.oks++
+			if !t.up && t.oks >= failoverUpAfter {
+				t.up = true
+				t.lastError = ""
+			}
+			continue
+		}
+		t.oks = 0
+		t.fails++
+		t.lastError = errs[i].Error()
+		if t.up && t.fails >= failoverDownAfter {
+			t.up = false
+		}
+	}
+	f.selectLocked()
+}
+
+func (f *Failover) check(ctx context.Context, base string) error {
+	ctx, cancel := context.WithTimeout(ctx, failoverProbeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+"/ping", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := f.probe.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// selectLocked makes the first address that is up active, or the primary
+// when none is.
+func (f *Failover) selectLocked() {
+	next := 0
+	for i, t := range f.targets {
+		if t.up {
+			next = i
+			break
+		}
+	}
+	if next == f.active || len(f.targets) == 0 {
+		f.active = next
+		return
+	}
+	from := ""
+	if f.active < len(f.targets) {
+		from = f.targets[f.active].base.String()
+	}
+	to := f.targets[next].base.String()
+	if next == 0 {
+		log.Info().Str("from", from).Str("to", to).Msg("Alist upstream failed back to primary address")
+	} else {
+		log.Warn().Str("from", from).Str("to", to).Msg("Alist upstream failed over")
+	}
+	f.active = next
+}
+
+// Active returns the base URL requests for the Alist server currently go to.
+func (f *Failover) Active() string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if len(f.targets) == 0 {
+		return ""
+	}
+	return f.targets[f.active].base.String()
+}
+
+// Targets returns the state of every address, primary first.
+func (f *Failover) Targets() []FailoverTarget {
+	if f == nil {
+		return nil
+	}
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	out := make([]FailoverTarget, 0, len(f.targets))
+	for i, t := range f.targets {
+		out = append(out, FailoverTarget{
+			URL:         t.base.String(),
+			Up:          t.up,
+			Active:      i == f.active,
+			LastChecked: t.checked,
+			LastError:   t.lastError,
+		})
+	}
+	return out
+}
+
+// ActiveFailover returns the installed failover state, or nil.
+func ActiveFailover() *Failover {
+	return activeFailover.Load()
+}
+
+// route returns the target a request to u goes to and whether u addresses
+// the Alist server at all.
+func (f *Failover) route(u *url.URL) (*failoverTarget, bool) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if len(f.targets) < 2 || hostKey(u) != hostKey(f.targets[0].base) {
+		return nil, false
+	}
+	return f.targets[f.active], true
+}
+
+// markDown takes t out after a connection error and returns the target to
+// retry on, or nil when there is none.
+func (f *Failover) markDown(t *failoverTarget, err error) *failoverTarget {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	t.oks = 0
+	t.fails = failoverDownAfter
+	t.lastError = err.Error()
+	if !t.up {
+		return nil
+	}
+	t.up = false
+	f.selectLocked()
+	if next := f.targets[f.active]; next != t && next.up {
+		return next
+	}
+	return nil
+}
+
+// hostKey is scheme://host:port with the default port filled in.
+func hostKey(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return u.Scheme + "://" + strings.ToLower(u.Hostname()) + ":" + port
+}
+
+// failoverTransport rewrites requests for the primary Alist address to the
+// active failover target.
+type failoverTransport struct {
+	base http.RoundTripper
+}
+
+func withFailover(base http.RoundTripper) http.RoundTripper {
+	return &failoverTransport{base: base}
+}
+
+func (t *failoverTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	f := activeFailover.Load()
+	if f == nil {
+		return t.base.RoundTrip(req)
+	}
+	target, ok := f.route(req.URL)
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+	resp, err := t.base.RoundTrip(rewriteToTarget(req, f, target))
+	if err == nil || req.Context().Err() != nil || !isConnectError(err) {
+		return resp, err
+	}
+	next := f.markDown(target, err)
+	if next == nil || (req.Method != http.MethodGet && req.Method != http.MethodHead) || (req.Body != nil && req.Body != http.NoBody) {
+		return resp, err
+	}
+	log.Debug().Err(err).Str("retry", next.base.String()).Msg("Retrying Alist request on failover address")
+	return t.base.RoundTrip(rewriteToTarget(req, f, next))
+}
+
+// rewriteToTarget points a clone of req at target unless it is the primary.
+func rewriteToTarget(req *http.Request, f *Failover, target *failoverTarget) *http.Request {
+	f.mu.RLock()
+	primary := f.targets[0]
+	f.mu.RUnlock()
+	if target == primary {
+		return req
+	}
+	out := req.Clone(req.Context())
+	out.URL.Scheme = target.base.Scheme
+	out.URL.Host = target.base.Host
+	// A failover URL may carry a path prefix, e.g. behind a reverse proxy.
+	if prefix := target.base.Path; prefix != "" {
+		out.URL.Path = prefix + out.URL.Path
+		if out.URL.RawPath != "" {
+			out.URL.RawPath = prefix + out.URL.RawPath
+		}
+	}
+	out.Host = ""
+	return out
+}
+
+// isConnectError reports whether err means the address could not be reached
+// at all, as opposed to a request that failed on a working server.
+func isConnectError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout() && strings.Contains(err.Error(), "dial")
+}
+
+// CloseIdleConnections lets http.Client.CloseIdleConnections reach the
+// wrapped transport.
+func (t *failoverTransport) CloseIdleConnections() {
+	if c, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
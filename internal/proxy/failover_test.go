@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+// failoverTestConfig points cfg at primary with secondary as its failover
+// host and installs failover state for it.
+func failoverTestConfig(t *testing.T, primary, secondary string) (*config.Config, *Failover) {
+	t.Helper()
+	u, _ := url.Parse(primary)
+	port, _ := strconv.Atoi(u.Port())
+	cfg := config.DefaultConfig()
+	cfg.AlistServer.ServerHost = u.Hostname()
+	cfg.AlistServer.ServerPort = port
+	cfg.AlistServer.FailoverHosts = []string{secondary}
+	f := NewFailover(cfg)
+	activeFailover.Store(f)
+	t.Cleanup(func() { activeFailover.Store(nil) })
+	return cfg, f
+}
+
+func fetchBody(t *testing.T, client *http.Client, target string) string {
+	t.Helper()
+	resp, err := client.Get(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return string(body)
+}
+
+func TestFailoverFollowsHealthChecksAndFailsBack(t *testing.T) {
+	var primaryDown atomic.Bool
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if primaryDown.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		io.WriteString(w, "lan "+r.URL.Path)
+	}))
+	defer primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "wan "+r.URL.Path)
+	}))
+	defer secondary.Close()
+
+	cfg, f := failoverTestConfig(t, primary.URL, secondary.URL+"/alist")
+	client := NewHTTPClient(cfg, 0)
+	ctx := context.Background()
+
+	if got := fetchBody(t, client, primary.URL+"/d/a.mkv"); got != "lan /d/a.mkv" {
+		t.Fatalf("healthy primary: got %q", got)
+	}
+
+	primaryDown.Store(true)
+	f.CheckOnce(ctx)
+	if f.Active() != primary.URL {
+		t.Fatal("failed over after a single bad check")
+	}
+	f.CheckOnce(ctx)
+	if f.Active() != secondary.URL+"/alist" {
+		t.Fatalf("active=%s after primary went down", f.Active())
+	}
+	if got := fetchBody(t, client, primary.URL+"/d/a.mkv"); got != "wan /alist/d/a.mkv" {
+		t.Fatalf("failed over: got %q", got)
+	}
+
+	primaryDown.Store(false)
+	f.CheckOnce(ctx)
+	f.CheckOnce(ctx)
+	if f.Active() != primary.URL {
+		t.Fatalf("active=%s after primary recovered", f.Active())
+	}
+	if got := fetchBody(t, client, primary.URL+"/d/a.mkv"); got != "lan /d/a.mkv" {
+		t.Fatalf("failed back: got %q", got)
+	}
+	targets := f.Targets()
+	if len(targets) != 2 || !targets[0].Active || !targets[1].Up {
+		t.Fatalf("targets=%+v", targets)
+	}
+}
+
+func TestFailoverRetriesGetOnConnectionError(t *testing.T) {
+	primary := httptest.NewServer(http.NotFoundHandler())
+	primaryURL := primary.URL
+	primary.Close()
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "wan "+r.Host)
+	}))
+	defer secondary.Close()
+
+	cfg, f := failoverTestConfig(t, primaryURL, secondary.URL)
+	client := NewHTTPClient(cfg, 0)
+
+	u, _ := url.Parse(secondary.URL)
+	if got := fetchBody(t, client, primaryURL+"/api/fs/list"); got != "wan "+u.Host {
+		t.Fatalf("got %q", got)
+	}
+	if f.Active() != secondary.URL {
+		t.Fatalf("active=%s after connection error", f.Active())
+	}
+
+	// Requests with a body are not replayed.
+	f.mu.Lock()
+	f.targets[0].up, f.active = true, 0
+	f.mu.Unlock()
+	resp, err := client.Post(primaryURL+"/api/fs/put", "text/plain", strings.NewReader("x"))
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("POST was replayed on the failover address")
+	}
+}
+
+func TestFailoverLeavesOtherHostsAlone(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "cdn")
+	}))
+	defer other.Close()
+	_, f := failoverTestConfig(t, "http://alist.invalid:5244", other.URL)
+	u, _ := url.Parse(other.URL)
+	if _, ok := f.route(u); ok {
+		t.Fatal("failover routed a request for another host")
+	}
+	if _, ok := f.route(&url.URL{Scheme: "http", Host: "ALIST.invalid:5244"}); !ok {
+		t.Fatal("primary host not matched case-insensitively")
+	}
+}
@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+// Headers exchanged between chained alist-encrypt instances (for example a
+// LAN instance in front of one on a VPS). They are only sent to the
+// configured Alist host, never to cloud drive CDNs.
+const (
+	// InstanceHeader lists, comma separated, the instance IDs a request has
+	// passed through. A request that arrives carrying our own ID went out to
+	// "Alist" and came straight back: alistServer points at the proxy itself.
+	InstanceHeader = "X-Alist-Encrypt-Instance"
+	// HopHeader counts the alist-encrypt instances before the receiver.
+	HopHeader = "X-Alist-Encrypt-Hop"
+	// OwnedHeader marks a request whose path an earlier instance encrypts;
+	// later instances pass it through untouched so files are not encrypted
+	// twice.
+	OwnedHeader = "X-Alist-Encrypt-Owned"
+)
+
+var instanceID = newInstanceID()
+
+func newInstanceID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// InstanceID returns the random ID this process stamps on Alist requests.
+func InstanceID() string {
+	return instanceID
+}
+
+// Chain is what an incoming request says about the instances before us.
+type Chain struct {
+	Instances []string
+	Hops      int
+	Owned     bool
+}
+
+// ChainFrom reads the chain headers of an incoming request.
+func ChainFrom(r *http.Request) Chain {
+	var chain Chain
+	for _, id := range strings.Split(r.Header.Get(InstanceHeader), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			chain.Instances = append(chain.Instances, id)
+		}
+	}
+	if n, err := strconv.Atoi(strings.TrimSpace(r.Header.Get(HopHeader))); err == nil && n > 0 {
+		chain.Hops = n
+	}
+	// Older instances only sent their ID; count it as one hop.
+	if chain.Hops < len(chain.Instances) {
+		chain.Hops = len(chain.Instances)
+	}
+	chain.Owned = chain.Hops > 0 && r.Header.Get(OwnedHeader) == "1"
+	return chain
+}
+
+// Looped reports whether this process is already part of the chain.
+func (c Chain) Looped() bool {
+	for _, id := range c.Instances {
+		if id == instanceID {
+			return true
+		}
+	}
+	return false
+}
+
+// IsLoopedRequest reports whether r was sent by this very process.
+func IsLoopedRequest(r *http.Request) bool {
+	return ChainFrom(r).Looped()
+}
+
+// StripChainHeaders removes the chain headers from an incoming request so
+// they are not copied onto requests to other hosts; requests to Alist get
+// fresh ones from the transport.
+func StripChainHeaders(h http.Header) {
+	h.Del(InstanceHeader)
+	h.Del(HopHeader)
+	h.Del(OwnedHeader)
+}
+
+type chainKey struct{}
+
+// WithChain attaches the incoming chain to ctx so Alist requests made with
+// it extend the chain instead of starting a new one.
+func WithChain(ctx context.Context, chain Chain) context.Context {
+	return context.WithValue(ctx, chainKey{}, chain)
+}
+
+func chainFromContext(ctx context.Context) Chain {
+	chain, _ := ctx.Value(chainKey{}).(Chain)
+	return chain
+}
+
+// loopMarkTransport stamps the chain headers on requests to the configured
+// Alist host. Other hosts (cloud drive CDNs) never see them.
+type loopMarkTransport struct {
+	base http.RoundTripper
+	cfg  *config.Config
+}
+
+func markLoops(base http.RoundTripper, cfg *config.Config) http.RoundTripper {
+	if cfg == nil {
+		return base
+	}
+	return &loopMarkTransport{base: base, cfg: cfg}
+}
+
+func (t *loopMarkTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := strings.TrimSpace(t.cfg.AlistServer.ServerHost)
+	if host == "" || !strings.EqualFold(parseHostOnly(req.URL.Host), strings.Trim(strings.ToLower(host), "[]")) {
+		return t.base.RoundTrip(req)
+	}
+	chain := chainFromContext(req.Context())
+	instances := append(append([]string(nil), chain.Instances...), instanceID)
+	// A RoundTripper must not modify the caller's request.
+	marked := req.Clone(req.Context())
+	marked.Header.Set(InstanceHeader, strings.Join(instances, ","))
+	marked.Header.Set(HopHeader, strconv.Itoa(chain.Hops+1))
+	if chain.Owned {
+		marked.Header.Set(OwnedHeader, "1")
+	} else {
+		marked.Header.Del(OwnedHeader)
+	}
+	return t.base.RoundTrip(marked)
+}
+
+// CloseIdleConnections lets http.Client.CloseIdleConnections reach the
+// wrapped transport.
+func (t *loopMarkTransport) CloseIdleConnections() {
+	if c, ok := t.base.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+func TestLoopMarkerOnlyReachesAlist(t *testing.T) {
+	var got []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get(InstanceHeader))
+	}))
+	defer upstream.Close()
+	u, _ := url.Parse(upstream.URL)
+
+	cfg := config.DefaultConfig()
+	cfg.AlistServer.ServerHost = u.Hostname()
+	client := NewHTTPClient(cfg, 0)
+
+	req, _ := http.NewRequest(http.MethodGet, upstream.URL+"/d/a.mkv", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if req.Header.Get(InstanceHeader) != "" {
+		t.Fatal("caller's request was modified")
+	}
+
+	cfg.AlistServer.ServerHost = "alist.example"
+	resp, err = client.Get(upstream.URL + "/cdn/a.mkv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if len(got) != 2 || got[0] != InstanceID() || got[1] != "" {
+		t.Fatalf("markers=%q", got)
+	}
+	looped := httptest.NewRequest(http.MethodGet, "/d/a.mkv", nil)
+	looped.Header.Set(InstanceHeader, InstanceID())
+	if !IsLoopedRequest(looped) {
+		t.Fatal("own marker not recognised")
+	}
+}
+
+func TestLoopMarkerExtendsIncomingChain(t *testing.T) {
+	var header http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Clone()
+	}))
+	defer upstream.Close()
+	u, _ := url.Parse(upstream.URL)
+	cfg := config.DefaultConfig()
+	cfg.AlistServer.ServerHost = u.Hostname()
+	client := NewHTTPClient(cfg, 0)
+
+	incoming := httptest.NewRequest(http.MethodGet, "/d/enc/a.mkv", nil)
+	incoming.Header.Set(InstanceHeader, "lan")
+	incoming.Header.Set(HopHeader, "1")
+	incoming.Header.Set(OwnedHeader, "1")
+	chain := ChainFrom(incoming)
+	if chain.Hops != 1 || !chain.Owned || chain.Looped() {
+		t.Fatalf("chain=%+v", chain)
+	}
+
+	req, _ := http.NewRequestWithContext(WithChain(context.Background(), chain), http.MethodGet, upstream.URL+"/d/enc/a.mkv", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := header.Get(InstanceHeader); got != "lan,"+InstanceID() {
+		t.Fatalf("instances=%q", got)
+	}
+	if header.Get(HopHeader) != "2" || header.Get(OwnedHeader) != "1" {
+		t.Fatalf("hop=%q owned=%q", header.Get(HopHeader), header.Get(OwnedHeader))
+	}
+}
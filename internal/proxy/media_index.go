@@ -0,0 +1,587 @@
+package proxy
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/binary"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+// Media index caching keeps the decrypted seek index of large videos (MP4 moov
+// box, MKV Cues element) in a dedicated LRU so that player startup and seeks do
+// not repeatedly range the remote's head/tail. Regions are discovered by
+// sniffing the first bytes of a playback that starts at offset 0 and fetched in
+// the background through the regular decrypt path.
+
+const (
+	mediaIndexSniffBytes    = 64 * 1024
+	mediaIndexFetchTimeout  = 60 * time.Second
+	mkvTailFallbackFraction = 64 // fall back to fileSize/64 of tail for MKV without SeekHead
+)
+
+type mediaIndexFetchKey struct{}
+
+// withMediaIndexFetch marks a request as an internal index fetch so it is not analyzed again.
+func withMediaIndexFetch(ctx context.Context) context.Context {
+	return context.WithValue(ctx, mediaIndexFetchKey{}, true)
+}
+
+func isMediaIndexFetch(ctx context.Context) bool {
+	if ctx == nil {
+		return false
+	}
+	v, _ := ctx.Value(mediaIndexFetchKey{}).(bool)
+	return v
+}
+
+type mediaIndexRegion struct {
+	start int64
+	data  []byte
+}
+
+func (r mediaIndexRegion) end() int64 {
+	return r.start + int64(len(r.data))
+}
+
+type mediaIndexEntry struct {
+	key     string
+	regions []mediaIndexRegion
+	size    int64
+}
+
+type mediaIndexCache struct {
+	mu            sync.Mutex
+	maxBytes      int64
+	maxRegion     int64
+	minFileSize   int64
+	usedBytes     int64
+	items         map[string]*list.Element
+	lru           *list.List
+	inflight      map[string]struct{}
+	hitCount      uint64
+	missCount     uint64
+	fetchCount    uint64
+	fetchFailures uint64
+	evictions     uint64
+}
+
+func newMediaIndexCacheFromConfig(cfg *config.Config) *mediaIndexCache {
+	if cfg == nil || !cfg.AlistServer.EnableMediaIndex {
+		return nil
+	}
+	cacheMB := cfg.AlistServer.MediaIndexCacheMb
+	if cacheMB <= 0 {
+		cacheMB = 64
+	}
+	regionKB := cfg.AlistServer.MediaIndexMaxRegionKb
+	if regionKB <= 0 {
+		regionKB = 8192
+	}
+	minSize := cfg.AlistServer.MediaIndexMinSizeBytes
+	if minSize <= 0 {
+		minSize = 256 * 1024 * 1024
+	}
+	return newMediaIndexCache(int64(cacheMB)*1024*1024, int64(regionKB)*1024, minSize)
+}
+
+func newMediaIndexCache(maxBytes, maxRegion, minFileSize int64) *mediaIndexCache {
+	if maxBytes <= 0 || maxRegion <= 0 {
+		return nil
+	}
+	if maxRegion > maxBytes {
+		maxRegion = maxBytes
+	}
+	return &mediaIndexCache{
+		maxBytes:    maxBytes,
+		maxRegion:   maxRegion,
+		minFileSize: minFileSize,
+		items:       make(map[string]*list.Element),
+		lru:         list.New(),
+		inflight:    make(map[string]struct{}),
+	}
+}
+
+// getRange returns plaintext bytes when [start, start+length) lies fully inside a cached region.
+func (c *mediaIndexCache) getRange(baseKey string, start, length int64) ([]byte, bool) {
+	if c == nil || baseKey == "" || start < 0 || length <= 0 {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[baseKey]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*mediaIndexEntry)
+	for _, region := range entry.regions {
+		if start >= region.start && start+length <= region.end() {
+			c.lru.MoveToFront(elem)
+			c.hitCount++
+			off := start - region.start
+			return append([]byte(nil), region.data[off:off+length]...), true
+		}
+	}
+	c.missCount++
+	return nil, false
+}
+
+func (c *mediaIndexCache) putRegion(baseKey string, start int64, data []byte) {
+	if c == nil || baseKey == "" || start < 0 || len(data) == 0 || int64(len(data)) > c.maxRegion {
+		return
+	}
+	region := mediaIndexRegion{start: start, data: append([]byte(nil), data...)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[baseKey]
+	if !ok {
+		elem = c.lru.PushFront(&mediaIndexEntry{key: baseKey})
+		c.items[baseKey] = elem
+	}
+	entry := elem.Value.(*mediaIndexEntry)
+	for i, existing := range entry.regions {
+		if existing.start == region.start {
+			c.usedBytes -= int64(len(existing.data))
+			entry.size -= int64(len(existing.data))
+			entry.regions = append(entry.regions[:i], entry.regions[i+1:]...)
+			break
+		}
+	}
+	entry.regions = append(entry.regions, region)
+	entry.size += int64(len(region.data))
+	c.usedBytes += int64(len(region.data))
+	c.lru.MoveToFront(elem)
+	for c.usedBytes > c.maxBytes {
+		back := c.lru.Back()
+		if back == nil || back == elem {
+			break
+		}
+		evicted := back.Value.(*mediaIndexEntry)
+		delete(c.items, evicted.key)
+		c.usedBytes -= evicted.size
+		c.lru.Remove(back)
+		c.evictions++
+	}
+}
+
+func (c *mediaIndexCache) has(baseKey string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.items[baseKey]
+	return ok
+}
+
+// beginFetch reserves baseKey for a background fetch; false when cached or already in flight.
+func (c *mediaIndexCache) beginFetch(baseKey string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[baseKey]; ok {
+		return false
+	}
+	if _, ok := c.inflight[baseKey]; ok {
+		return false
+	}
+	c.inflight[baseKey] = struct{}{}
+	return true
+}
+
+func (c *mediaIndexCache) endFetch(baseKey string, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.inflight, baseKey)
+	c.fetchCount++
+	if !ok {
+		c.fetchFailures++
+	}
+}
+
+func (c *mediaIndexCache) stats() map[string]interface{} {
+	if c == nil {
+		return map[string]interface{}{"enabled": false}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return map[string]interface{}{
+		"enabled":        true,
+		"entries":        len(c.items),
+		"used_bytes":     c.usedBytes,
+		"max_bytes":      c.maxBytes,
+		"max_region":     c.maxRegion,
+		"inflight":       len(c.inflight),
+		"hit_count":      c.hitCount,
+		"miss_count":     c.missCount,
+		"fetch_count":    c.fetchCount,
+		"fetch_failures": c.fetchFailures,
+		"eviction_count": c.evictions,
+	}
+}
+
+// MediaIndexStats returns media index cache runtime stats.
+func (s *StreamProxy) MediaIndexStats() map[string]interface{} {
+	c := s.mediaIndexCache()
+	if c == nil {
+		return map[string]interface{}{"enabled": false}
+	}
+	return c.stats()
+}
+
+// mediaContainer identifies a supported container from the display name or URL path.
+func mediaContainer(displayName, targetURL string) string {
+	name := displayName
+	if name == "" {
+		if u, err := url.Parse(targetURL); err == nil {
+			name = u.Path
+		} else {
+			name = targetURL
+		}
+	}
+	switch strings.ToLower(path.Ext(name)) {
+	case ".mp4", ".m4v", ".mov", ".3gp":
+		return "mp4"
+	case ".mkv", ".webm", ".mka":
+		return "mkv"
+	default:
+		return ""
+	}
+}
+
+// locateMediaIndex returns the [start, end) plaintext region holding the seek
+// index, derived from the first decrypted bytes of the file.
+func locateMediaIndex(container string, head []byte, fileSize, maxRegion int64) (int64, int64, bool) {
+	var start, end int64
+	var ok bool
+	switch container {
+	case "mp4":
+		start, end, ok = locateMP4Moov(head, fileSize)
+	case "mkv":
+		start, end, ok = locateMKVCues(head, fileSize, maxRegion)
+	}
+	if !ok || start < 0 || end > fileSize || end <= start {
+		return 0, 0, false
+	}
+	if end-start > maxRegion {
+		return 0, 0, false
+	}
+	return start, end, true
+}
+
+// locateMP4Moov walks top-level ISO BMFF boxes. When moov is in the head its
+// exact extent is returned; when mdat comes first, moov is assumed to trail it.
+func locateMP4Moov(head []byte, fileSize int64) (int64, int64, bool) {
+	var off int64
+	for off+8 <= int64(len(head)) {
+		size := int64(binary.BigEndian.Uint32(head[off : off+4]))
+		boxType := string(head[off+4 : off+8])
+		hdr := int64(8)
+		if size == 1 {
+			if off+16 > int64(len(head)) {
+				return 0, 0, false
+			}
+			large := binary.BigEndian.Uint64(head[off+8 : off+16])
+			if large > math.MaxInt64 {
+				return 0, 0, false
+			}
+			size = int64(large)
+			hdr = 16
+		} else if size == 0 {
+			size = fileSize - off
+		}
+		// Compared as a remainder: a crafted 64-bit size would overflow off+size.
+		if size < hdr || size > fileSize-off {
+			return 0, 0, false
+		}
+		switch boxType {
+		case "moov":
+			return off, off + size, true
+		case "mdat":
+			tail := off + size
+			if tail >= fileSize {
+				return 0, 0, false
+			}
+			return tail, fileSize, true
+		}
+		off += size
+	}
+	return 0, 0, false
+}
+
+var (
+	ebmlHeaderID  = []byte{0x1A, 0x45, 0xDF, 0xA3}
+	mkvSegmentID  = uint64(0x18538067)
+	mkvSeekHeadID = uint64(0x114D9B74)
+	mkvSeekID     = uint64(0x4DBB)
+	mkvSeekIDID   = uint64(0x53AB)
+	mkvSeekPosID  = uint64(0x53AC)
+	mkvCuesID     = uint64(0x1C53BB6B)
+)
+
+// readEBMLVint reads an EBML variable-length integer. When keepMarker is true
+// the length marker bit is preserved (element IDs), otherwise it is masked (sizes).
+func readEBMLVint(b []byte, keepMarker bool) (uint64, int, bool) {
+	if len(b) == 0 || b[0] == 0 {
+		return 0, 0, false
+	}
+	n := 1
+	for mask := byte(0x80); b[0]&mask == 0; mask >>= 1 {
+		n++
+	}
+	if n > 8 || len(b) < n {
+		return 0, 0, false
+	}
+	v := uint64(b[0])
+	if !keepMarker {
+		v &= uint64(0xFF >> n)
+	}
+	for i := 1; i < n; i++ {
+		v = v<<8 | uint64(b[i])
+	}
+	return v, n, true
+}
+
+// locateMKVCues resolves the Cues position through the Segment's SeekHead.
+// Without a SeekHead the file tail is used, where muxers usually place Cues.
+func locateMKVCues(head []byte, fileSize, maxRegion int64) (int64, int64, bool) {
+	if !bytes.HasPrefix(head, ebmlHeaderID) {
+		return 0, 0, false
+	}
+	tailFallback := func() (int64, int64, bool) {
+		size := fileSize / mkvTailFallbackFraction
+		if size > maxRegion {
+			size = maxRegion
+		}
+		if size <= 0 {
+			return 0, 0, false
+		}
+		return fileSize - size, fileSize, true
+	}
+	off := 4
+	hdrSize, n, ok := readEBMLVint(head[off:], false)
+	if !ok {
+		return 0, 0, false
+	}
+	off += n + int(hdrSize)
+	if off >= len(head) {
+		return tailFallback()
+	}
+	id, n, ok := readEBMLVint(head[off:], true)
+	if !ok || id != mkvSegmentID {
+		return tailFallback()
+	}
+	off += n
+	_, n, ok = readEBMLVint(head[off:], false)
+	if !ok {
+		return tailFallback()
+	}
+	off += n
+	segmentStart := int64(off)
+
+	id, n, ok = readEBMLVint(head[off:], true)
+	if !ok || id != mkvSeekHeadID {
+		return tailFallback()
+	}
+	off += n
+	seekHeadSize, n, ok := readEBMLVint(head[off:], false)
+	if !ok {
+		return tailFallback()
+	}
+	off += n
+	seekHeadEnd := off + int(seekHeadSize)
+	if seekHeadEnd > len(head) {
+		seekHeadEnd = len(head)
+	}
+	for off < seekHeadEnd {
+		id, n, ok = readEBMLVint(head[off:], true)
+		if !ok {
+			break
+		}
+		off += n
+		size, n, ok := readEBMLVint(head[off:], false)
+		if !ok {
+			break
+		}
+		off += n
+		end := off + int(size)
+		if end > seekHeadEnd {
+			break
+		}
+		if id == mkvSeekID {
+			var target, pos uint64
+			inner := off
+			for inner < end {
+				cid, cn, ok := readEBMLVint(head[inner:], true)
+				if !ok {
+					break
+				}
+				inner += cn
+				csize, cn, ok := readEBMLVint(head[inner:], false)
+				if !ok || inner+cn+int(csize) > end {
+					break
+				}
+				inner += cn
+				var v uint64
+				for _, b := range head[inner : inner+int(csize)] {
+					v = v<<8 | uint64(b)
+				}
+				switch cid {
+				case mkvSeekIDID:
+					target = v
+				case mkvSeekPosID:
+					pos = v
+				}
+				inner += int(csize)
+			}
+			if target == mkvCuesID {
+				start := segmentStart + int64(pos)
+				if start >= fileSize {
+					return tailFallback()
+				}
+				end := start + maxRegion
+				if end > fileSize {
+					end = fileSize
+				}
+				return start, end, true
+			}
+		}
+		off = end
+	}
+	return tailFallback()
+}
+
+// mediaIndexSniffer captures the first bytes of a playback stream and, once
+// enough is seen, schedules a background fetch of the seek index region.
+type mediaIndexSniffer struct {
+	src       io.Reader
+	head      []byte
+	done      bool
+	onSniffed func(head []byte)
+}
+
+func (r *mediaIndexSniffer) Read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	if !r.done && n > 0 {
+		need := mediaIndexSniffBytes - len(r.head)
+		if need > n {
+			need = n
+		}
+		r.head = append(r.head, p[:need]...)
+		if len(r.head) >= mediaIndexSniffBytes {
+			r.finish()
+		}
+	}
+	if err != nil && !r.done {
+		r.finish()
+	}
+	return n, err
+}
+
+func (r *mediaIndexSniffer) finish() {
+	r.done = true
+	if len(r.head) > 0 {
+		r.onSniffed(r.head)
+	}
+	r.head = nil
+}
+
+// observeMediaIndex wraps a decrypted playback stream starting at offset 0 and
+// schedules a background index fetch for large videos. Only range-capable
+// upstreams are used since tail regions would otherwise require a full download.
+func (s *StreamProxy) observeMediaIndex(reader io.Reader, req *http.Request, targetURL string, passwdInfo *config.PasswdInfo, fileSize int64, baseKey string, strategy StreamStrategy, compatStorageKey string) io.Reader {
+	c := s.mediaIndexCache()
+	if c == nil || req == nil || baseKey == "" || strategy != StreamStrategyRange || fileSize < c.minFileSize || isMediaIndexFetch(req.Context()) {
+		return reader
+	}
+	container := mediaContainer(displayNameFromContext(req.Context()), targetURL)
+	if container == "" || c.has(baseKey) {
+		return reader
+	}
+	fetchReq := req.Clone(withMediaIndexFetch(context.WithoutCancel(req.Context())))
+	return &mediaIndexSniffer{
+		src: reader,
+		onSniffed: func(head []byte) {
+			start, end, ok := locateMediaIndex(container, head, fileSize, c.maxRegion)
+			if !ok || !c.beginFetch(baseKey) {
+				return
+			}
+			go s.fetchMediaIndex(c, fetchReq, targetURL, passwdInfo, fileSize, baseKey, compatStorageKey, start, end)
+		},
+	}
+}
+
+func (s *StreamProxy) fetchMediaIndex(c *mediaIndexCache, req *http.Request, targetURL string, passwdInfo *config.PasswdInfo, fileSize int64, baseKey, compatStorageKey string, start, end int64) {
+	ok := false
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error().Interface("panic", r).Str("target_url", targetURL).Msg("Media index fetch panicked")
+		}
+		c.endFetch(baseKey, ok)
+	}()
+	ctx, cancel := context.WithTimeout(req.Context(), mediaIndexFetchTimeout)
+	defer cancel()
+	req = req.WithContext(ctx)
+	req.Method = http.MethodGet
+	req.Header.Set("Range", "bytes="+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(end-1, 10))
+
+	w := newMediaIndexWriter(end - start)
+	outcome := s.ProxyDownloadDecryptWithStrategyForStorage(w, req, targetURL, passwdInfo, fileSize, StreamStrategyRange, compatStorageKey)
+	if outcome == nil || outcome.Err != nil || w.status != http.StatusPartialContent || int64(w.buf.Len()) != end-start {
+		log.Debug().
+			Str("target_url", targetURL).
+			Int64("start", start).
+			Int64("end", end).
+			Int("status", w.status).
+			Int("bytes", w.buf.Len()).
+			Msg("Media index fetch incomplete")
+		return
+	}
+	c.putRegion(baseKey, start, w.buf.Bytes())
+	ok = true
+	log.Info().
+		Str("category", "playback").
+		Str("target_url", targetURL).
+		Int64("start", start).
+		Int64("end", end).
+		Msg("Cached media seek index region")
+}
+
+// mediaIndexWriter is an in-memory http.ResponseWriter bounded to the region size.
+type mediaIndexWriter struct {
+	header http.Header
+	status int
+	limit  int64
+	buf    bytes.Buffer
+}
+
+func newMediaIndexWriter(limit int64) *mediaIndexWriter {
+	return &mediaIndexWriter{header: make(http.Header), limit: limit}
+}
+
+func (w *mediaIndexWriter) Header() http.Header { return w.header }
+
+func (w *mediaIndexWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *mediaIndexWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if int64(w.buf.Len())+int64(len(p)) > w.limit {
+		return 0, io.ErrShortWrite
+	}
+	return w.buf.Write(p)
+}
@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+func mp4Box(boxType string, payload int) []byte {
+	b := make([]byte, 8+payload)
+	binary.BigEndian.PutUint32(b[:4], uint32(8+payload))
+	copy(b[4:8], boxType)
+	return b
+}
+
+func TestLocateMP4MoovFastStart(t *testing.T) {
+	head := append(mp4Box("ftyp", 24), mp4Box("moov", 1000)...)
+	start, end, ok := locateMP4Moov(head, 1<<30)
+	if !ok {
+		t.Fatal("expected moov to be located")
+	}
+	if start != 32 || end != 32+1008 {
+		t.Fatalf("region=[%d,%d), want [32,1040)", start, end)
+	}
+}
+
+func TestLocateMP4MoovAfterMdat(t *testing.T) {
+	fileSize := int64(10 << 20)
+	mdatSize := fileSize - 32 - 4096
+	mdat := make([]byte, 8)
+	binary.BigEndian.PutUint32(mdat[:4], uint32(mdatSize))
+	copy(mdat[4:8], "mdat")
+	head := append(mp4Box("ftyp", 24), mdat...)
+
+	start, end, ok := locateMP4Moov(head, fileSize)
+	if !ok {
+		t.Fatal("expected trailing moov region")
+	}
+	if start != 32+mdatSize || end != fileSize {
+		t.Fatalf("region=[%d,%d), want [%d,%d)", start, end, 32+mdatSize, fileSize)
+	}
+}
+
+func TestLocateMP4MoovRejectsHugeLargesize(t *testing.T) {
+	for _, large := range []uint64{math.MaxInt64 - 4, math.MaxUint64} {
+		box := make([]byte, 16)
+		binary.BigEndian.PutUint32(box[:4], 1)
+		copy(box[4:8], "mdat")
+		binary.BigEndian.PutUint64(box[8:16], large)
+		head := append(mp4Box("ftyp", 24), box...)
+
+		if _, _, ok := locateMP4Moov(head, 1<<30); ok {
+			t.Fatalf("largesize %d: expected box to be rejected", large)
+		}
+	}
+}
+
+func TestLocateMKVCuesFromSeekHead(t *testing.T) {
+	var b bytes.Buffer
+	b.Write(ebmlHeaderID)
+	b.WriteByte(0x80) // empty EBML header body
+	b.Write([]byte{0x18, 0x53, 0x80, 0x67, 0x01, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+	segmentStart := int64(b.Len())
+	seek := []byte{
+		0x4D, 0xBB, 0x8B, // Seek, size 11
+		0x53, 0xAB, 0x84, 0x1C, 0x53, 0xBB, 0x6B, // SeekID = Cues
+		0x53, 0xAC, 0x81, 0x40, // SeekPosition = 64
+	}
+	b.Write([]byte{0x11, 0x4D, 0x9B, 0x74, byte(0x80 | len(seek))})
+	b.Write(seek)
+
+	start, end, ok := locateMKVCues(b.Bytes(), 1<<20, 4096)
+	if !ok {
+		t.Fatal("expected cues region")
+	}
+	if start != segmentStart+64 || end != segmentStart+64+4096 {
+		t.Fatalf("region=[%d,%d), want start %d", start, end, segmentStart+64)
+	}
+}
+
+func TestLocateMKVCuesFallsBackToTail(t *testing.T) {
+	head := append(append([]byte(nil), ebmlHeaderID...), 0x80)
+	start, end, ok := locateMKVCues(head, 1<<20, 4096)
+	if !ok || end != 1<<20 || start != 1<<20-4096 {
+		t.Fatalf("region=[%d,%d) ok=%v", start, end, ok)
+	}
+}
+
+func TestMediaIndexCacheServesContainedRanges(t *testing.T) {
+	c := newMediaIndexCache(1<<20, 64<<10, 0)
+	data := bytes.Repeat([]byte{0xAB}, 4096)
+	c.putRegion("k", 1000, data)
+
+	got, ok := c.getRange("k", 1500, 100)
+	if !ok || len(got) != 100 {
+		t.Fatalf("expected hit, ok=%v len=%d", ok, len(got))
+	}
+	if _, ok := c.getRange("k", 4000, 2000); ok {
+		t.Fatal("range crossing region end must miss")
+	}
+	if c.beginFetch("k") {
+		t.Fatal("cached key must not be refetched")
+	}
+}
+
+func TestMediaIndexCacheEvictsLeastRecent(t *testing.T) {
+	c := newMediaIndexCache(8192, 8192, 0)
+	c.putRegion("a", 0, make([]byte, 4096))
+	c.putRegion("b", 0, make([]byte, 4096))
+	c.putRegion("c", 0, make([]byte, 4096))
+	if c.has("a") {
+		t.Fatal("expected oldest entry to be evicted")
+	}
+	if !c.has("b") || !c.has("c") {
+		t.Fatal("expected newer entries to remain")
+	}
+}
+
+func TestMediaContainerPrefersDisplayName(t *testing.T) {
+	if got := mediaContainer("movie.MKV", "http://alist/d/enc/abcdef"); got != "mkv" {
+		t.Fatalf("container=%q", got)
+	}
+	if got := mediaContainer("", "http://alist/d/enc/movie.mp4?sign=1"); got != "mp4" {
+		t.Fatalf("container=%q", got)
+	}
+	if got := mediaContainer("", "http://alist/d/enc/notes.txt"); got != "" {
+		t.Fatalf("container=%q", got)
+	}
+}
@@ -12,6 +12,10 @@ type rangeLearningStats struct {
 	pseudoRangeCount    uint64
 	reasonUnsupported   uint64
 	reasonUnsatisfiable uint64
+	discardCount        uint64
+	discardBytes        uint64
+	discardWarnCount    uint64
+	tailSkippedBytes    uint64
 }
 
 func newRangeLearningStats() *rangeLearningStats {
@@ -32,5 +36,9 @@ func (s *rangeLearningStats) snapshot() map[string]interface{} {
 		"pseudo_range_count":   atomic.LoadUint64(&s.pseudoRangeCount),
 		"reason_unsupported":   atomic.LoadUint64(&s.reasonUnsupported),
 		"reason_unsatisfiable": atomic.LoadUint64(&s.reasonUnsatisfiable),
+		"discard_count":        atomic.LoadUint64(&s.discardCount),
+		"discard_bytes":        atomic.LoadUint64(&s.discardBytes),
+		"discard_warn_count":   atomic.LoadUint64(&s.discardWarnCount),
+		"tail_skipped_bytes":   atomic.LoadUint64(&s.tailSkippedBytes),
 	}
 }
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// serverTiming records where the time of one decrypted download went, for
+// the Server-Timing header (enableServerTiming). It tells a stall on the
+// cloud drive (connect, ttfb) apart from one in the proxy (cipher init,
+// first decrypted byte) and, by elimination, the client's own network.
+// A nil *serverTiming records nothing.
+type serverTiming struct {
+	start time.Time
+
+	mu             sync.Mutex
+	getConn        time.Time
+	gotConn        time.Time
+	connReused     bool
+	firstByte      time.Time
+	cipherStart    time.Time
+	cipherReady    time.Time
+	firstDecrypted time.Time
+}
+
+type serverTimingKey struct{}
+
+func (s *StreamProxy) serverTimingEnabled() bool {
+	return s != nil && s.cfg != nil && s.cfg.AlistServer.EnableServerTiming
+}
+
+// withServerTiming starts a timing for the download made with ctx and traces
+// its upstream connection.
+func withServerTiming(ctx context.Context) context.Context {
+	t := &serverTiming{start: time.Now()}
+	trace := &httptrace.ClientTrace{
+		// A redirect or retry overwrites the marks, so they describe the
+		// request whose body is streamed.
+		GetConn: func(string) { t.mark(&t.getConn) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mu.Lock()
+			t.gotConn = time.Now()
+			t.connReused = info.Reused
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() { t.mark(&t.firstByte) },
+	}
+	return httptrace.WithClientTrace(context.WithValue(ctx, serverTimingKey{}, t), trace)
+}
+
+func serverTimingFrom(ctx context.Context) *serverTiming {
+	t, _ := ctx.Value(serverTimingKey{}).(*serverTiming)
+	return t
+}
+
+func (t *serverTiming) mark(at *time.Time) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	*at = time.Now()
+	t.mu.Unlock()
+}
+
+func (t *serverTiming) markCipherStart() {
+	if t != nil {
+		t.mark(&t.cipherStart)
+	}
+}
+
+func (t *serverTiming) markCipherReady() {
+	if t != nil {
+		t.mark(&t.cipherReady)
+	}
+}
+
+func (t *serverTiming) markFirstDecrypted() {
+	if t != nil {
+		t.mark(&t.firstDecrypted)
+	}
+}
+
+// peekFirstDecrypted reads the first decrypted bytes before the status line
+// is committed, so the header can report when they were ready. The bytes
+// are put back in front of the pipeline.
+func (t *serverTiming) peekFirstDecrypted(p *downloadPipeline) {
+	if t == nil {
+		return
+	}
+	buf := make([]byte, 32*1024)
+	n, _ := p.reader.Read(buf)
+	t.markFirstDecrypted()
+	// A read error shows up again on the next read, as after sniffing.
+	p.add("server_timing", func(r io.Reader) io.Reader {
+		return io.MultiReader(bytes.NewReader(buf[:n]), r)
+	})
+}
+
+// header formats the metrics measured so far.
+func (t *serverTiming) header(extra ...string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var parts []string
+	add := func(name string, from, to time.Time, desc string) {
+		if from.IsZero() || to.IsZero() || to.Before(from) {
+			return
+		}
+		part := fmt.Sprintf("%s;dur=%.1f", name, float64(to.Sub(from))/float64(time.Millisecond))
+		if desc != "" {
+			part += `;desc="` + desc + `"`
+		}
+		parts = append(parts, part)
+	}
+	connDesc := "new connection"
+	if t.connReused {
+		connDesc = "reused connection"
+	}
+	add("upstream-connect", t.getConn, t.gotConn, connDesc)
+	add("upstream-ttfb", t.gotConn, t.firstByte, "cloud drive response")
+	add("cipher-init", t.cipherStart, t.cipherReady, "")
+	add("first-byte-decrypted", t.cipherReady, t.firstDecrypted, "")
+	return strings.Join(append(parts, extra...), ", ")
+}
+
+// setHeader adds the Server-Timing header; call it right before WriteHeader.
+func (t *serverTiming) setHeader(w http.ResponseWriter, extra ...string) {
+	if t == nil {
+		return
+	}
+	if h := t.header(extra...); h != "" {
+		w.Header().Set("Server-Timing", h)
+	}
+}
+
+// finish reports the total as a trailer, which reaches the client over
+// HTTP/2 or chunked responses; with a fixed Content-Length on HTTP/1.1 it
+// is only logged.
+func (t *serverTiming) finish(w http.ResponseWriter, targetURL string, written int64) {
+	if t == nil {
+		return
+	}
+	total := time.Since(t.start)
+	w.Header().Set(http.TrailerPrefix+"Server-Timing", fmt.Sprintf("total;dur=%.1f", float64(total)/float64(time.Millisecond)))
+	log.Debug().
+		Str("category", "playback").
+		Str("target_url", targetURL).
+		Str("server_timing", t.header()).
+		Dur("total", total).
+		Int64("bytes", written).
+		Msg("Decrypted download timing")
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/encryption"
+)
+
+func TestServerTimingHeaderOnDecryptedDownload(t *testing.T) {
+	plain := bytes.Repeat([]byte("timed plaintext "), 512)
+	ciphertext := append([]byte(nil), plain...)
+	flow, err := encryption.NewFlowEnc("123456", "aesctr", int64(len(plain)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flow.Encrypt(ciphertext)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(ciphertext))
+	}))
+	defer upstream.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.AlistServer.EnableSniff = false
+	passwd := &config.PasswdInfo{Password: "123456", EncType: "aesctr", Enable: true}
+	download := func(cfg *config.Config) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/d/file.bin", nil)
+		result := NewStreamProxy(cfg).ProxyDownloadDecryptWithStrategyForStorage(rr, req, upstream.URL+"/file.bin", passwd, int64(len(plain)), StreamStrategyRange, "")
+		if result.Err != nil {
+			t.Fatalf("stream: %v", result.Err)
+		}
+		if !bytes.Equal(rr.Body.Bytes(), plain) {
+			t.Fatal("decrypted body mismatch")
+		}
+		return rr
+	}
+
+	if got := download(cfg).Header().Get("Server-Timing"); got != "" {
+		t.Fatalf("Server-Timing sent while disabled: %q", got)
+	}
+
+	cfg.AlistServer.EnableServerTiming = true
+	rr := download(cfg)
+	got := rr.Header().Get("Server-Timing")
+	for _, metric := range []string{"upstream-connect;dur=", "upstream-ttfb;dur=", "cipher-init;dur=", "first-byte-decrypted;dur="} {
+		if !strings.Contains(got, metric) {
+			t.Fatalf("Server-Timing %q lacks %s", got, metric)
+		}
+	}
+	if total := rr.Header().Get(http.TrailerPrefix + "Server-Timing"); !strings.HasPrefix(total, "total;dur=") {
+		t.Fatalf("total trailer %q", total)
+	}
+}
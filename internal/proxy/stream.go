@@ -1,12 +1,16 @@
 package proxy
 
 import (
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/rs/zerolog/log"
+
 	"github.com/alist-encrypt-go/internal/backoff"
 	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/workers"
 )
 
 // Buffer pool for streaming - default 512KB buffers for high-bitrate video
@@ -77,10 +81,13 @@ type StreamProxy struct {
 	retrier          *backoff.Retrier // retry with jitter for transient network errors
 	uploadMetaMu     sync.Mutex
 	uploadMeta       map[string]uploadMetaEntry
-	blockCache       *decryptedBlockCache
-	streamLimiter    chan struct{}
-	activeStreams    int64
-	rejectedStreams  uint64
+	blockCache       atomic.Pointer[decryptedBlockCache]
+	mediaIndex       atomic.Pointer[mediaIndexCache]
+	cacheMu          sync.Mutex
+	cacheSettings    string // settings blockCache and mediaIndex were built from
+	streamLimiter    *workers.Pool
+	bandwidth        atomic.Pointer[bandwidthLimiter]
+	pipelineStats    *downloadPipelineStats
 }
 
 // StreamOutcome describes the streaming result for strategy selection.
@@ -102,7 +109,6 @@ func NewStreamProxy(cfg *config.Config) *StreamProxy {
 	applyStreamBufferConfig(cfg)
 	cbThreshold := 5
 	cbCooldown := 30 * time.Second
-	maxActiveStreams := 32
 	retrier := backoff.DefaultRetrier()
 	if cfg != nil {
 		if cfg.AlistServer.CircuitBreakerThreshold > 0 {
@@ -114,11 +120,8 @@ func NewStreamProxy(cfg *config.Config) *StreamProxy {
 		if cfg.AlistServer.RetryMaxAttempts >= 0 {
 			retrier.MaxRetries = cfg.AlistServer.RetryMaxAttempts
 		}
-		if cfg.AlistServer.MaxActiveStreams > 0 {
-			maxActiveStreams = cfg.AlistServer.MaxActiveStreams
-		}
 	}
-	return &StreamProxy{
+	s := &StreamProxy{
 		client:        NewClient(cfg),
 		cfg:           cfg,
 		compatStore:   NewMemoryRangeCompatStore(),
@@ -127,9 +130,57 @@ func NewStreamProxy(cfg *config.Config) *StreamProxy {
 		cbGate:        backoff.NewGate(cbThreshold, cbCooldown),
 		retrier:       retrier,
 		uploadMeta:    make(map[string]uploadMetaEntry),
-		blockCache:    newDecryptedBlockCacheFromConfig(cfg),
-		streamLimiter: make(chan struct{}, maxActiveStreams),
+		pipelineStats: newDownloadPipelineStats(),
+		streamLimiter: workers.Register(workers.New(config.PoolDownloadStreams, cfg.WorkerLimit(config.PoolDownloadStreams))),
+	}
+	s.ApplyConfig(cfg)
+	return s
+}
+
+// ApplyConfig rebuilds the decrypted block cache and the media index cache
+// when their settings changed and swaps the new ones in; streams holding
+// the old caches finish against them. Unchanged settings keep the warm
+// caches. The bandwidth caps are swapped the same way.
+func (s *StreamProxy) ApplyConfig(cfg *config.Config) {
+	var bw *config.BandwidthConfig
+	if cfg != nil {
+		bw = cfg.GetBandwidth()
+	}
+	if !s.bandwidth.Load().same(bw) {
+		s.bandwidth.Store(newBandwidthLimiter(bw))
+	}
+
+	settings := ""
+	if cfg != nil {
+		a := cfg.AlistServer
+		settings = fmt.Sprint(a.EnableDecryptedBlockCache, a.DecryptedBlockCacheMb, a.DecryptedBlockSizeKb,
+			a.EnableMediaIndex, a.MediaIndexCacheMb, a.MediaIndexMaxRegionKb, a.MediaIndexMinSizeBytes)
+	}
+	s.cacheMu.Lock()
+	defer s.cacheMu.Unlock()
+	if s.cacheSettings == settings && settings != "" {
+		return
 	}
+	if s.cacheSettings != "" {
+		log.Info().Msg("Decrypted cache settings changed, swapping in new caches")
+	}
+	s.cacheSettings = settings
+	s.blockCache.Store(newDecryptedBlockCacheFromConfig(cfg))
+	s.mediaIndex.Store(newMediaIndexCacheFromConfig(cfg))
+}
+
+func (s *StreamProxy) blockCacheRef() *decryptedBlockCache {
+	if s == nil {
+		return nil
+	}
+	return s.blockCache.Load()
+}
+
+func (s *StreamProxy) mediaIndexCache() *mediaIndexCache {
+	if s == nil {
+		return nil
+	}
+	return s.mediaIndex.Load()
 }
 
 // AcquireStream reserves capacity for a decrypt playback stream. It returns a
@@ -138,39 +189,28 @@ func (s *StreamProxy) AcquireStream() (func(), bool) {
 	if s == nil || s.streamLimiter == nil {
 		return func() {}, true
 	}
-	select {
-	case s.streamLimiter <- struct{}{}:
-		atomic.AddInt64(&s.activeStreams, 1)
-		var released atomic.Bool
-		return func() {
-			if released.Swap(true) {
-				return
-			}
-			<-s.streamLimiter
-			atomic.AddInt64(&s.activeStreams, -1)
-		}, true
-	default:
-		atomic.AddUint64(&s.rejectedStreams, 1)
+	if !s.streamLimiter.TryAcquire() {
 		return nil, false
 	}
+	var released atomic.Bool
+	return func() {
+		if released.Swap(true) {
+			return
+		}
+		s.streamLimiter.Release()
+	}, true
 }
 
 // StreamLimitStats returns current decrypt playback concurrency stats.
 func (s *StreamProxy) StreamLimitStats() map[string]interface{} {
-	limit := 0
-	if s != nil && s.streamLimiter != nil {
-		limit = cap(s.streamLimiter)
-	}
-	var active int64
-	var rejected uint64
-	if s != nil {
-		active = atomic.LoadInt64(&s.activeStreams)
-		rejected = atomic.LoadUint64(&s.rejectedStreams)
+	if s == nil || s.streamLimiter == nil {
+		return map[string]interface{}{"active_streams": int64(0), "max_active": 0, "rejected_streams": uint64(0)}
 	}
+	stats := s.streamLimiter.Stats()
 	return map[string]interface{}{
-		"active_streams":   active,
-		"max_active":       limit,
-		"rejected_streams": rejected,
+		"active_streams":   stats["active"],
+		"max_active":       stats["size"],
+		"rejected_streams": stats["rejected"],
 	}
 }
 
@@ -0,0 +1,224 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	stderrors "errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/encryption"
+	"github.com/alist-encrypt-go/internal/errors"
+	"github.com/alist-encrypt-go/internal/httputil"
+	"github.com/rs/zerolog/log"
+)
+
+// resolveAEADMeta makes sure aesgcm/xchacha20poly1305 folders stream with the file's V3 header.
+// Chunk keys depend on the per-file salt and the whole header is the
+// additional data, so unlike V2 there is no usable guess without it.
+func (s *StreamProxy) resolveAEADMeta(ctx context.Context, targetURL string, headers http.Header, passwdInfo *config.PasswdInfo, meta encryption.ContentMeta) encryption.ContentMeta {
+	if passwdInfo == nil || !encryption.IsAEADEncType(passwdInfo.EncType) {
+		return meta
+	}
+	if meta.IsAEAD() && len(meta.NonceField) == 16 {
+		return meta
+	}
+	return s.inspectEncryptedContent(ctx, targetURL, headers, passwdInfo, meta.CiphertextSize)
+}
+
+// buildAEADUpstreamRange widens a plaintext range to the sealed chunks that
+// cover it. Without a usable single range the whole file is fetched.
+func buildAEADUpstreamRange(rangeHeader string, meta encryption.ContentMeta) string {
+	if strings.TrimSpace(rangeHeader) == "" || meta.PlainSize <= 0 {
+		return rangeHeader
+	}
+	parsed, err := httputil.ParseRange(rangeHeader, meta.PlainSize)
+	if err != nil || parsed == nil || len(parsed.Ranges) != 1 {
+		return ""
+	}
+	_, start, end := meta.AEADChunkSpan(parsed.Ranges[0].Start, parsed.Ranges[0].End)
+	return fmt.Sprintf("bytes=%d-%d", start, end)
+}
+
+// contentRangeStart returns the first byte of a "bytes a-b/n" Content-Range.
+func contentRangeStart(contentRange string) (int64, bool) {
+	spec := strings.TrimSpace(contentRange)
+	if !strings.HasPrefix(strings.ToLower(spec), "bytes ") {
+		return 0, false
+	}
+	spec = strings.TrimSpace(spec[len("bytes "):])
+	dash := strings.Index(spec, "-")
+	if dash <= 0 {
+		return 0, false
+	}
+	start, err := strconv.ParseInt(spec[:dash], 10, 64)
+	if err != nil || start < 0 {
+		return 0, false
+	}
+	return start, true
+}
+
+// streamDecryptAEADResponse serves a successful upstream response for
+// authenticated content. Sizes come from the header, not the listing, and
+// every chunk is verified before any of its bytes reach the client.
+func (s *StreamProxy) streamDecryptAEADResponse(w http.ResponseWriter, req *http.Request, resp *http.Response, passwdInfo *config.PasswdInfo, meta encryption.ContentMeta, rangeHeader string, strategy StreamStrategy, targetURL, compatStorageKey string) *StreamOutcome {
+	result := &StreamOutcome{}
+	fileSize := meta.PlainSize
+
+	var activeRange *httputil.Range
+	if rangeHeader != "" && req.Method == http.MethodGet && fileSize > 0 {
+		parsed, err := httputil.ParseRange(rangeHeader, fileSize)
+		if err == nil && parsed != nil && len(parsed.Ranges) != 1 {
+			err = errors.NewProxyError("multiple ranges not supported")
+		}
+		if err != nil {
+			writeRangeNotSatisfiable(w, fileSize)
+			result.Err = err
+			result.FailureReason = "range_invalid"
+			result.ResponseStarted = true
+			result.StatusCode = http.StatusRequestedRangeNotSatisfiable
+			return result
+		}
+		if parsed != nil {
+			activeRange = &parsed.Ranges[0]
+		}
+	}
+	if activeRange != nil && resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		s.recordRangeFailure(targetURL, compatStorageKey, "range_unsatisfiable")
+		return &StreamOutcome{Err: errors.NewProxyError("range unsatisfiable"), Retryable: true, FailureReason: "range_unsatisfiable"}
+	}
+
+	timing := serverTimingFrom(req.Context())
+	timing.markCipherStart()
+	password, err := passwdInfo.ContentPassword(meta.PasswordVersion)
+	if err != nil {
+		result.Err = errors.NewDecryptionErrorWithCause("failed to create cipher", err)
+		return result
+	}
+	content, err := encryption.NewAEADContent(password, meta)
+	if err != nil {
+		result.Err = errors.NewDecryptionErrorWithCause("failed to create cipher", err)
+		return result
+	}
+	timing.markCipherReady()
+
+	start, end := int64(0), fileSize-1
+	statusCode := http.StatusOK
+	httputil.CopyResponseHeaders(w, resp, "Content-Length", "Content-Range", "Accept-Ranges")
+	w.Header().Set("Accept-Ranges", "bytes")
+	if activeRange != nil {
+		start, end = activeRange.Start, activeRange.End
+		statusCode = http.StatusPartialContent
+		w.Header().Set("Content-Range", activeRange.ContentRangeHeader(fileSize))
+	}
+	result.ExpectedBytes = end - start + 1
+	w.Header().Set("Content-Length", strconv.FormatInt(result.ExpectedBytes, 10))
+	result.StatusCode = statusCode
+	result.ContentType = resp.Header.Get("Content-Type")
+	result.ETag = resp.Header.Get("ETag")
+	s.rewriteDecryptedDisposition(w, req, passwdInfo)
+
+	if req.Method == http.MethodHead || result.ExpectedBytes <= 0 {
+		timing.setHeader(w)
+		w.WriteHeader(statusCode)
+		result.ResponseStarted = true
+		return result
+	}
+
+	firstChunk, cipherStart, _ := meta.AEADChunkSpan(start, end)
+	upstreamStart := int64(0)
+	if resp.StatusCode == http.StatusPartialContent {
+		upstreamStart, _ = contentRangeStart(resp.Header.Get("Content-Range"))
+	}
+	if upstreamStart > cipherStart {
+		s.recordRangeFailure(targetURL, compatStorageKey, "range_unsupported")
+		return &StreamOutcome{Err: errors.NewProxyError("upstream range does not start on a chunk"), Retryable: true, FailureReason: "range_unsupported"}
+	}
+	if err := discardBytes(resp.Body, cipherStart-upstreamStart); err != nil {
+		result.Err = errors.NewProxyErrorWithCause("failed to seek to authenticated chunk", err)
+		return result
+	}
+
+	pipeline := newDownloadPipeline("upstream", resp.Body)
+	pipeline.add("decrypt", func(r io.Reader) io.Reader { return content.DecryptReader(r, firstChunk) })
+	pipeline.add("range", func(r io.Reader) io.Reader {
+		return &aeadWindowReader{src: r, skip: start - firstChunk*encryption.AEADChunkSize, limit: result.ExpectedBytes}
+	})
+	pipeline.addTransforms(downloadTransformsFor(passwdInfo), start)
+
+	// Open the first chunk before committing to a status, so a wrong password
+	// or a damaged file is reported instead of an empty 200.
+	verified := bufio.NewReaderSize(pipeline.reader, encryption.AEADChunkSize)
+	if _, err := verified.Peek(1); err != nil && err != io.EOF {
+		return aeadFailure(targetURL, err)
+	}
+	pipeline.reader = verified
+	timing.markFirstDecrypted()
+
+	timing.setHeader(w)
+	w.WriteHeader(statusCode)
+	result.ResponseStarted = true
+	written, err := s.streamPipeline(w, req, pipeline)
+	result.BytesWritten = written
+	timing.finish(w, targetURL, written)
+	if err != nil {
+		if stderrors.Is(err, encryption.ErrContentAuthFailed) {
+			failure := aeadFailure(targetURL, err)
+			failure.ResponseStarted = true
+			failure.BytesWritten = written
+			failure.StatusCode = statusCode
+			failure.ExpectedBytes = result.ExpectedBytes
+			return failure
+		}
+		log.Error().Err(err).Msg("Error streaming decrypted content")
+		result.Err = err
+		result.FailureReason, result.Retryable = classifyStreamError(err)
+	}
+	if strategy == StreamStrategyRange && activeRange != nil && result.Err == nil {
+		s.recordRangeSuccess(targetURL, compatStorageKey)
+	}
+	return result
+}
+
+func aeadFailure(targetURL string, err error) *StreamOutcome {
+	if !stderrors.Is(err, encryption.ErrContentAuthFailed) {
+		reason, retryable := classifyStreamError(err)
+		return &StreamOutcome{Err: errors.NewProxyErrorWithCause("failed to read authenticated content", err), FailureReason: reason, Retryable: retryable}
+	}
+	log.Error().Err(err).Str("target_url", targetURL).Msg("Authenticated content failed verification")
+	return &StreamOutcome{
+		Err:           errors.NewDecryptionErrorWithCause("content integrity check failed (tampered, truncated or wrong password)", err),
+		Retryable:     false,
+		FailureReason: "integrity_failed",
+		NoLearning:    true,
+	}
+}
+
+// aeadWindowReader trims whole decrypted chunks to the requested window.
+type aeadWindowReader struct {
+	src   io.Reader
+	skip  int64
+	limit int64
+}
+
+func (a *aeadWindowReader) Read(p []byte) (int, error) {
+	if a.skip > 0 {
+		if err := discardBytes(a.src, a.skip); err != nil {
+			return 0, err
+		}
+		a.skip = 0
+	}
+	if a.limit <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > a.limit {
+		p = p[:a.limit]
+	}
+	n, err := a.src.Read(p)
+	a.limit -= int64(n)
+	return n, err
+}
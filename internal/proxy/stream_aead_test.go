@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/encryption"
+)
+
+func sealAESGCMForTest(t *testing.T, plain []byte) []byte {
+	t.Helper()
+	return sealAEADForTest(t, "aesgcm", plain)
+}
+
+func sealAEADForTest(t *testing.T, encType string, plain []byte) []byte {
+	t.Helper()
+	enc, err := encryption.NewLatestContentEncryptor("123456", encType, int64(len(plain)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader, err := enc.EncryptReader(bytes.NewReader(plain), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ciphertext
+}
+
+// rangeServingClient answers like a ranging storage and records the Range
+// headers it was asked for.
+func rangeServingClient(ciphertext []byte, ranges *[]string) *Client {
+	return newTestClient(func(r *http.Request) (*http.Response, error) {
+		*ranges = append(*ranges, r.Header.Get("Range"))
+		rec := httptest.NewRecorder()
+		http.ServeContent(rec, r, "", time.Time{}, bytes.NewReader(ciphertext))
+		resp := rec.Result()
+		resp.Request = r
+		return resp, nil
+	})
+}
+
+func TestAEADDownloadSeeksByChunk(t *testing.T) {
+	plain := make([]byte, 3*encryption.AEADChunkSize+100)
+	for i := range plain {
+		plain[i] = byte(i % 251)
+	}
+	for _, encType := range []string{"aesgcm", "xchacha20poly1305"} {
+		ciphertext := sealAEADForTest(t, encType, plain)
+
+		sp := NewStreamProxy(config.DefaultConfig())
+		var ranges []string
+		sp.client = rangeServingClient(ciphertext, &ranges)
+		passwd := &config.PasswdInfo{Password: "123456", EncType: encType, Enable: true}
+
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/d/movie.bin", nil)
+		req.Header.Set("Range", "bytes=70000-70009")
+		outcome := sp.ProxyDownloadDecryptWithStrategyForStorage(rr, req, "http://upstream.local/d/movie.bin", passwd, int64(len(ciphertext)), StreamStrategyRange, "")
+		if outcome.Err != nil {
+			t.Fatalf("%s download: %v", encType, outcome.Err)
+		}
+		if rr.Code != http.StatusPartialContent || !bytes.Equal(rr.Body.Bytes(), plain[70000:70010]) {
+			t.Fatalf("%s status=%d body=%x", encType, rr.Code, rr.Body.Bytes())
+		}
+		if got := rr.Header().Get("Content-Range"); got != "bytes 70000-70009/"+strconv.Itoa(len(plain)) {
+			t.Fatalf("%s content-range=%q", encType, got)
+		}
+		sealed := int64(encryption.AEADChunkSize + 16)
+		wantRange := "bytes=" + strconv.FormatInt(32+sealed, 10) + "-" + strconv.FormatInt(32+2*sealed-1, 10)
+		if len(ranges) != 2 || ranges[0] != "bytes=0-31" || ranges[1] != wantRange {
+			t.Fatalf("%s upstream ranges=%v, want header probe then %s", encType, ranges, wantRange)
+		}
+	}
+}
+
+func TestAESGCMDownloadFailsOnTamperedChunk(t *testing.T) {
+	plain := bytes.Repeat([]byte("integrity"), encryption.AEADChunkSize/4)
+	ciphertext := sealAESGCMForTest(t, plain)
+	passwd := &config.PasswdInfo{Password: "123456", EncType: "aesgcm", Enable: true}
+
+	for _, tc := range []struct {
+		name    string
+		offset  int
+		started bool
+	}{
+		{"first chunk", 40, false},
+		{"later chunk", len(ciphertext) - 5, true},
+	} {
+		tampered := append([]byte(nil), ciphertext...)
+		tampered[tc.offset] ^= 0x80
+		sp := NewStreamProxy(config.DefaultConfig())
+		var ranges []string
+		sp.client = rangeServingClient(tampered, &ranges)
+
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/d/doc.bin", nil)
+		outcome := sp.ProxyDownloadDecryptWithStrategyForStorage(rr, req, "http://upstream.local/d/doc.bin", passwd, int64(len(tampered)), StreamStrategyRange, "")
+		if outcome.Err == nil || outcome.FailureReason != "integrity_failed" || outcome.Retryable {
+			t.Fatalf("%s: outcome=%+v", tc.name, outcome)
+		}
+		if outcome.ResponseStarted != tc.started {
+			t.Fatalf("%s: response started=%v", tc.name, outcome.ResponseStarted)
+		}
+		if !bytes.HasPrefix(plain, rr.Body.Bytes()) || rr.Body.Len() == len(plain) {
+			t.Fatalf("%s: released %d unverified bytes", tc.name, rr.Body.Len())
+		}
+	}
+}
+
+func TestAESGCMUploadSendsWholeCiphertext(t *testing.T) {
+	plain := bytes.Repeat([]byte("upload"), 20000)
+	sp := NewStreamProxy(config.DefaultConfig())
+	var received []byte
+	var fileSize string
+	sp.client = newTestClient(func(r *http.Request) (*http.Response, error) {
+		fileSize = r.Header.Get("X-File-Size")
+		received, _ = io.ReadAll(r.Body)
+		return &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Body: io.NopCloser(strings.NewReader("{}")), Request: r}, nil
+	})
+	passwd := &config.PasswdInfo{Password: "123456", EncType: "aesgcm", Enable: true}
+
+	req := httptest.NewRequest(http.MethodPut, "/api/fs/put", bytes.NewReader(plain))
+	if err := sp.ProxyUploadEncrypt(httptest.NewRecorder(), req, "http://upstream.local/put", passwd, int64(len(plain)), 0); err != nil {
+		t.Fatalf("upload: %v", err)
+	}
+	if want := encryption.AEADCiphertextSize(int64(len(plain))); fileSize != strconv.FormatInt(want, 10) || int64(len(received)) != want {
+		t.Fatalf("x-file-size=%s received=%d want=%d", fileSize, len(received), want)
+	}
+	reader, _, err := encryption.AutoDecryptReader("123456", encryption.EncTypeAESGCM, bytes.NewReader(received), int64(len(received)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(reader); err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("roundtrip err=%v equal=%v", err, bytes.Equal(got, plain))
+	}
+
+	resume := httptest.NewRequest(http.MethodPut, "/api/fs/put", bytes.NewReader(plain[10:]))
+	if err := sp.ProxyUploadEncrypt(httptest.NewRecorder(), resume, "http://upstream.local/put", passwd, int64(len(plain)), 10); err == nil {
+		t.Fatal("expected resumed aesgcm upload to be rejected")
+	}
+}
@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
@@ -39,8 +40,12 @@ func (s *StreamProxy) ProxyDownloadDecryptWithStrategyForStorage(w http.Response
 		}
 	}
 
+	if s.serverTimingEnabled() {
+		r = r.WithContext(withServerTiming(r.Context()))
+	}
 	rangeHeader := r.Header.Get("Range")
 	meta := contentMetaFromContext(r.Context(), passwdInfo, fileSize)
+	meta = s.resolveAEADMeta(r.Context(), targetURL, r.Header, passwdInfo, meta)
 	if meta.PlainSize > 0 {
 		fileSize = meta.PlainSize
 	}
@@ -127,8 +132,12 @@ func (s *StreamProxy) ProxyDownloadDecryptReqWithStrategy(w http.ResponseWriter,
 
 // ProxyDownloadDecryptReqWithStrategyForStorage downloads and decrypts using storage-scoped range learning.
 func (s *StreamProxy) ProxyDownloadDecryptReqWithStrategyForStorage(w http.ResponseWriter, req *http.Request, targetURL string, passwdInfo *config.PasswdInfo, fileSize int64, strategy StreamStrategy, compatStorageKey string) *StreamOutcome {
+	if s.serverTimingEnabled() {
+		req = req.WithContext(withServerTiming(req.Context()))
+	}
 	rangeHeader := req.Header.Get("Range")
 	meta := contentMetaFromContext(req.Context(), passwdInfo, fileSize)
+	meta = s.resolveAEADMeta(req.Context(), targetURL, req.Header, passwdInfo, meta)
 	if meta.PlainSize > 0 {
 		fileSize = meta.PlainSize
 	}
@@ -200,7 +209,8 @@ func applyStrategyHeaders(req *http.Request, strategy StreamStrategy) {
 }
 
 func (s *StreamProxy) tryServeDecryptedCache(w http.ResponseWriter, req *http.Request, targetURL string, passwdInfo *config.PasswdInfo, fileSize int64, meta encryption.ContentMeta, rangeHeader, compatStorageKey string) (*StreamOutcome, bool) {
-	if s == nil || s.blockCache == nil || req == nil || req.Method != http.MethodGet || rangeHeader == "" || fileSize <= 0 {
+	blockCache, mediaIndex := s.blockCacheRef(), s.mediaIndexCache()
+	if s == nil || (blockCache == nil && mediaIndex == nil) || req == nil || req.Method != http.MethodGet || rangeHeader == "" || fileSize <= 0 {
 		return nil, false
 	}
 	if meta.PlainSize > 0 {
@@ -215,17 +225,23 @@ func (s *StreamProxy) tryServeDecryptedCache(w http.ResponseWriter, req *http.Re
 	if baseKey == "" {
 		return nil, false
 	}
-	data, ok := s.blockCache.getRange(baseKey, activeRange.Start, activeRange.ContentLength())
+	data, ok := mediaIndex.getRange(baseKey, activeRange.Start, activeRange.ContentLength())
+	if !ok {
+		data, ok = blockCache.getRange(baseKey, activeRange.Start, activeRange.ContentLength())
+	}
 	if !ok {
 		return nil, false
 	}
 	w.Header().Set("Accept-Ranges", "bytes")
 	w.Header().Set("Content-Range", activeRange.ContentRangeHeader(fileSize))
 	w.Header().Set("Content-Length", strconv.FormatInt(int64(len(data)), 10))
+	serverTimingFrom(req.Context()).setHeader(w, `decrypted-cache;desc="hit"`)
 	w.WriteHeader(http.StatusPartialContent)
-	n, writeErr := w.Write(data)
+	pipeline := newDownloadPipeline("decrypted_cache", bytes.NewReader(data))
+	pipeline.addTransforms(downloadTransformsFor(passwdInfo), activeRange.Start)
+	n, writeErr := s.streamPipeline(w, req, pipeline)
 	outcome := &StreamOutcome{
-		BytesWritten:    int64(n),
+		BytesWritten:    n,
 		ExpectedBytes:   activeRange.ContentLength(),
 		ResponseStarted: true,
 		StatusCode:      http.StatusPartialContent,
@@ -269,10 +285,11 @@ func (s *StreamProxy) decryptedCacheBaseKey(targetURL string, passwdInfo *config
 
 // DecryptedBlockCacheStats returns decrypted block cache runtime stats.
 func (s *StreamProxy) DecryptedBlockCacheStats() map[string]interface{} {
-	if s == nil || s.blockCache == nil {
+	blockCache := s.blockCacheRef()
+	if blockCache == nil {
 		return map[string]interface{}{"enabled": false}
 	}
-	return s.blockCache.stats()
+	return blockCache.stats()
 }
 
 func (s *StreamProxy) streamDecryptResponse(w http.ResponseWriter, req *http.Request, resp *http.Response, passwdInfo *config.PasswdInfo, fileSize int64, meta encryption.ContentMeta, rangeHeader string, strategy StreamStrategy, targetURL, compatStorageKey string) *StreamOutcome {
@@ -320,6 +337,9 @@ func (s *StreamProxy) streamDecryptResponse(w http.ResponseWriter, req *http.Req
 
 	// Upstream responded successfully (< 500), reset circuit breaker
 	s.cbGate.RecordSuccess()
+	if meta.IsAEAD() {
+		return s.streamDecryptAEADResponse(w, req, resp, passwdInfo, meta, rangeHeader, strategy, targetURL, compatStorageKey)
+	}
 
 	// Get file size from Content-Length if not provided
 	fileSize = resolveFileSize(fileSize, resp)
@@ -343,10 +363,15 @@ func (s *StreamProxy) streamDecryptResponse(w http.ResponseWriter, req *http.Req
 	}
 
 	// Create decryption stream
+	timing := serverTimingFrom(req.Context())
+	timing.markCipherStart()
 	var flowEnc encryption.Cipher
 	var err error
 	if meta.IsV2() {
-		flowEnc, err = encryption.NewCipherV2(encryption.EncType(passwdInfo.EncType), passwdInfo.Password, fileSize, meta.NonceField)
+		var password string
+		if password, err = passwdInfo.ContentPassword(meta.PasswordVersion); err == nil {
+			flowEnc, err = encryption.NewCipherV2(encryption.EncType(passwdInfo.EncType), password, fileSize, meta.NonceField)
+		}
 	} else {
 		flowEnc, err = encryption.NewFlowEnc(passwdInfo.Password, passwdInfo.EncType, fileSize)
 	}
@@ -354,6 +379,7 @@ func (s *StreamProxy) streamDecryptResponse(w http.ResponseWriter, req *http.Req
 		result.Err = errors.NewDecryptionErrorWithCause("failed to create cipher", err)
 		return result
 	}
+	timing.markCipherReady()
 
 	var activeRange *httputil.Range
 	if rangeHeader != "" && req.Method == http.MethodGet {
@@ -382,8 +408,12 @@ func (s *StreamProxy) streamDecryptResponse(w http.ResponseWriter, req *http.Req
 	// Preserve range start for Full strategy fallback: when Range is unsupported,
 	// we download the full file but seek in the cipher + discard upstream bytes.
 	fullRangeStart := int64(0)
+	fullRangeEnd := fileSize - 1
 	if strategy == StreamStrategyFull && activeRange != nil {
 		fullRangeStart = activeRange.Start
+		if activeRange.End >= fullRangeStart && activeRange.End < fullRangeEnd {
+			fullRangeEnd = activeRange.End
+		}
 		activeRange = nil
 	}
 
@@ -421,7 +451,7 @@ func (s *StreamProxy) streamDecryptResponse(w http.ResponseWriter, req *http.Req
 	// For Full strategy with seek: build a synthetic range for correct 206 headers.
 	fullSeekRange := activeRange
 	if strategy == StreamStrategyFull && fullRangeStart > 0 {
-		fullSeekRange = &httputil.Range{Start: fullRangeStart, End: fileSize - 1}
+		fullSeekRange = &httputil.Range{Start: fullRangeStart, End: fullRangeEnd}
 	}
 
 	upstreamShiftedRange := meta.IsV2() && strategy == StreamStrategyRange && buildUpstreamRangeHeader(rangeHeader, meta) != rangeHeader
@@ -467,18 +497,10 @@ func (s *StreamProxy) streamDecryptResponse(w http.ResponseWriter, req *http.Req
 		Bool("upstream_shifted_range", upstreamShiftedRange).
 		Msg("Prepared decrypt response headers")
 
-	if req.Method == http.MethodGet && passwdInfo != nil && passwdInfo.Enable && passwdInfo.EncName {
-		showName := displayNameFromContext(req.Context())
-		if showName == "" {
-			allowLoose := s.cfg != nil && s.cfg.AlistServer.AllowLooseDecode
-			showName = decodeNameFromRequest(passwdInfo, req.URL.Path, allowLoose)
-		}
-		if showName != "" {
-			rewriteContentDisposition(w, showName)
-		}
-	}
+	s.rewriteDecryptedDisposition(w, req, passwdInfo)
 
 	if req.Method == http.MethodHead {
+		timing.setHeader(w)
 		w.WriteHeader(statusCode)
 		result.ResponseStarted = true
 		if strategy == StreamStrategyRange && activeRange != nil && result.Err == nil {
@@ -506,6 +528,7 @@ func (s *StreamProxy) streamDecryptResponse(w http.ResponseWriter, req *http.Req
 			result.Err = errors.NewProxyErrorWithCause("failed to discard bytes for full seek", err)
 			return result
 		}
+		s.recordRangeDiscard(targetURL, compatStorageKey, strategy, fullRangeStart, fileSize-1-fullRangeEnd)
 	}
 
 	sniffOffset := int64(0)
@@ -526,22 +549,29 @@ func (s *StreamProxy) streamDecryptResponse(w http.ResponseWriter, req *http.Req
 				result.Err = errors.NewProxyErrorWithCause("failed to discard range bytes", err)
 				return result
 			}
+			s.recordRangeDiscard(targetURL, compatStorageKey, strategy, activeRange.Start, fileSize-1-activeRange.End)
 		}
 	}
 	if strategy == StreamStrategyFull && fullRangeStart > 0 {
 		sniffOffset = fullRangeStart
 	}
 
-	readerToStream := flowEnc.DecryptReader(bodyReader)
+	pipeline := newDownloadPipeline("upstream", bodyReader)
+	pipeline.add("decrypt", flowEnc.DecryptReader)
 	if activeRange != nil {
-		readerToStream = io.LimitReader(readerToStream, activeRange.ContentLength())
+		pipeline.add("range", func(r io.Reader) io.Reader { return io.LimitReader(r, activeRange.ContentLength()) })
+	} else if fullSeekRange != nil {
+		// Non-ranging storages send the whole body; stop at the client's end
+		// instead of streaming the tail nobody asked for.
+		pipeline.add("range", func(r io.Reader) io.Reader { return io.LimitReader(r, fullSeekRange.ContentLength()) })
 	}
 
 	// Sniff first bytes of decrypted output to detect wrong password/fileSize.
 	// Can be disabled via config (enableSniff: false) for performance.
 	if shouldSniffDecryptedContent(req.Method, resp.Header.Get("Content-Type"), sniffOffset) &&
 		(s.cfg == nil || s.cfg.AlistServer.EnableSniff) {
-		if sniffBytes, ok := sniffDecrypted(readerToStream); !ok {
+		sniffBytes, ok := sniffDecrypted(pipeline.reader)
+		if !ok {
 			resp.Body.Close()
 			return &StreamOutcome{
 				Err:           errors.NewDecryptionError("decryption validation failed: output appears encrypted (wrong password or file size?)"),
@@ -549,21 +579,30 @@ func (s *StreamProxy) streamDecryptResponse(w http.ResponseWriter, req *http.Req
 				FailureReason: "decrypt_validation_failed",
 				NoLearning:    true,
 			}
-		} else {
-			readerToStream = sniffBytes
 		}
+		pipeline.add("sniff", func(io.Reader) io.Reader { return sniffBytes })
+	}
+	if blockCache := s.blockCacheRef(); req.Method == http.MethodGet && rangeHeader != "" && blockCache != nil {
+		baseKey := s.decryptedCacheBaseKey(targetURL, passwdInfo, fileSize, meta, compatStorageKey)
+		pipeline.add("block_cache", func(r io.Reader) io.Reader {
+			return newDecryptedCacheReader(r, blockCache, baseKey, sniffOffset)
+		})
 	}
-	if req.Method == http.MethodGet && rangeHeader != "" && s.blockCache != nil {
+	if req.Method == http.MethodGet && s.mediaIndexCache() != nil && sniffOffset == 0 {
 		baseKey := s.decryptedCacheBaseKey(targetURL, passwdInfo, fileSize, meta, compatStorageKey)
-		readerToStream = newDecryptedCacheReader(readerToStream, s.blockCache, baseKey, sniffOffset)
+		pipeline.add("media_index", func(r io.Reader) io.Reader {
+			return s.observeMediaIndex(r, req, targetURL, passwdInfo, fileSize, baseKey, strategy, compatStorageKey)
+		})
 	}
+	pipeline.addTransforms(downloadTransformsFor(passwdInfo), sniffOffset)
+	timing.peekFirstDecrypted(pipeline)
+	timing.setHeader(w)
 	w.WriteHeader(statusCode)
 	result.ResponseStarted = true
 
-	buf := getBuffer()
-	defer putBuffer(buf)
-	written, err := io.CopyBuffer(w, readerToStream, *buf)
+	written, err := s.streamPipeline(w, req, pipeline)
 	result.BytesWritten = written
+	timing.finish(w, targetURL, written)
 	if err != nil {
 		log.Error().Err(err).Msg("Error streaming decrypted content")
 		result.Err = err
@@ -579,6 +618,22 @@ func (s *StreamProxy) streamDecryptResponse(w http.ResponseWriter, req *http.Req
 
 	return result
 }
+
+// rewriteDecryptedDisposition names the download after the decrypted file name.
+func (s *StreamProxy) rewriteDecryptedDisposition(w http.ResponseWriter, req *http.Request, passwdInfo *config.PasswdInfo) {
+	if req.Method != http.MethodGet || passwdInfo == nil || !passwdInfo.Enable || !passwdInfo.EncName {
+		return
+	}
+	showName := displayNameFromContext(req.Context())
+	if showName == "" {
+		allowLoose := s.cfg != nil && s.cfg.AlistServer.AllowLooseDecode
+		showName = decodeNameFromRequest(passwdInfo, req.URL.Path, allowLoose)
+	}
+	if showName != "" {
+		rewriteContentDisposition(w, showName)
+	}
+}
+
 func parseRangeStart(rangeHeader string) (int64, bool) {
 	if rangeHeader == "" {
 		return 0, false
@@ -8,7 +8,6 @@ import (
 	"strings"
 
 	"github.com/alist-encrypt-go/internal/config"
-	"github.com/alist-encrypt-go/internal/encryption"
 )
 
 // Pre-compiled regex for Content-Disposition rewriting (avoids per-request compilation)
@@ -23,13 +22,7 @@ func decodeNameFromRequest(passwdInfo *config.PasswdInfo, urlPath string, allowL
 	if err == nil {
 		name = decoded
 	}
-	ext := path.Ext(name)
-	base := strings.TrimSuffix(name, ext)
-	decodedName := encryption.DecodeName(passwdInfo.Password, passwdInfo.EncType, base)
-	if decodedName == "" && allowLoose {
-		return encryption.DecodeNameLoose(passwdInfo.Password, passwdInfo.EncType, base)
-	}
-	return decodedName
+	return passwdInfo.NameConverter().DecodeFileName(name, allowLoose)
 }
 
 func rewriteContentDisposition(w http.ResponseWriter, showName string) {
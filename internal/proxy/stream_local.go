@@ -0,0 +1,198 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/encryption"
+	"github.com/alist-encrypt-go/internal/httputil"
+	"github.com/rs/zerolog/log"
+)
+
+// localAuthTimeout bounds the upstream permission check made before a local
+// direct read.
+const localAuthTimeout = 10 * time.Second
+
+// ServeLocalDecrypt serves an encrypted file straight from local disk,
+// decrypting on the fly. Range, HEAD and conditional requests are answered by
+// http.ServeContent. It returns false without writing anything when the file
+// cannot be opened or its content header cannot be read, so callers can fall
+// back to fetching through Alist. Entries with download transforms always
+// fall back, as do authenticated (V3) entries.
+//
+// A disk read skips Alist's own sign/permission check, so the request is
+// first replayed upstream as a HEAD against authURL with the client's
+// credentials; unless Alist accepts it the file is not served and the caller
+// falls through to the upstream fetch, which returns Alist's error.
+func (s *StreamProxy) ServeLocalDecrypt(w http.ResponseWriter, r *http.Request, authURL, localPath string, passwdInfo *config.PasswdInfo) bool {
+	if passwdInfo == nil || localPath == "" {
+		return false
+	}
+	if len(downloadTransformsFor(passwdInfo)) > 0 {
+		// ServeContent seeks freely; leave transforms to the streaming path.
+		return false
+	}
+	if encryption.IsAEADEncType(passwdInfo.EncType) {
+		// Chunks must be verified before release; the streaming path does that.
+		return false
+	}
+	f, err := os.Open(localPath)
+	if err != nil {
+		log.Debug().Err(err).Str("local_path", localPath).Msg("Local direct read unavailable")
+		return false
+	}
+	defer f.Close()
+	st, err := f.Stat()
+	if err != nil || !st.Mode().IsRegular() {
+		return false
+	}
+	if !s.authorizeLocalRead(r, authURL) {
+		return false
+	}
+	reader, err := newLocalDecryptReader(f, st.Size(), passwdInfo)
+	if err != nil {
+		log.Warn().Err(err).Str("local_path", localPath).Msg("Local direct read failed, falling back to upstream")
+		return false
+	}
+
+	name := displayNameFromContext(r.Context())
+	if name == "" {
+		name = path.Base(r.URL.Path)
+	}
+	if r.Method == http.MethodGet && passwdInfo.EncName && name != "" {
+		rewriteContentDisposition(w, name)
+	}
+	log.Info().
+		Str("category", "playback").
+		Str("local_path", localPath).
+		Str("client_range", r.Header.Get("Range")).
+		Int("meta_version", reader.meta.Version).
+		Int64("plain_size", reader.size).
+		Msg("Serving decrypted content from local disk")
+	out, done := s.Throttle(w, r)
+	defer done()
+	http.ServeContent(out, r, name, st.ModTime(), reader)
+	return true
+}
+
+// authorizeLocalRead asks Alist whether r may read authURL by sending it as a
+// HEAD with the client's headers (a sign, if any, is already part of
+// authURL). Any 2xx or 3xx answer counts as allowed.
+func (s *StreamProxy) authorizeLocalRead(r *http.Request, authURL string) bool {
+	if s.client == nil || authURL == "" {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), localAuthTimeout)
+	defer cancel()
+	req, err := httputil.NewRequest(http.MethodHead, authURL).
+		WithContext(ctx).
+		CopyHeadersExcept(r, "Range", "If-Range", "If-None-Match", "If-Modified-Since").
+		Build()
+	if err != nil {
+		return false
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		log.Debug().Err(err).Str("auth_url", authURL).Msg("Local direct read auth check failed")
+		return false
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusBadRequest {
+		log.Info().
+			Str("category", "playback").
+			Str("auth_url", authURL).
+			Int("status", resp.StatusCode).
+			Msg("Upstream refused request, skipping local direct read")
+		return false
+	}
+	return true
+}
+
+// localDecryptReader is an io.ReadSeeker over the plaintext of an encrypted
+// file on disk. The cipher is repositioned only when a read does not continue
+// where the previous one stopped.
+type localDecryptReader struct {
+	file      io.ReaderAt
+	meta      encryption.ContentMeta
+	cipher    encryption.Cipher
+	size      int64 // plaintext size
+	pos       int64
+	cipherPos int64
+}
+
+func newLocalDecryptReader(file io.ReaderAt, ciphertextSize int64, passwdInfo *config.PasswdInfo) (*localDecryptReader, error) {
+	encType := encryption.EncType(passwdInfo.EncType)
+	prefix := make([]byte, encryption.ContentHeaderSize())
+	n, err := file.ReadAt(prefix, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	meta, isV2, err := encryption.ParseContentHeader(encType, prefix[:n], ciphertextSize)
+	if err != nil {
+		return nil, err
+	}
+	var c encryption.Cipher
+	if isV2 {
+		if meta.PlainSize+meta.HeaderLen > ciphertextSize {
+			return nil, fmt.Errorf("truncated file: header declares %d bytes, have %d", meta.PlainSize, ciphertextSize-meta.HeaderLen)
+		}
+		password, perr := passwdInfo.ContentPassword(meta.PasswordVersion)
+		if perr != nil {
+			return nil, perr
+		}
+		c, err = encryption.NewCipherV2(encType, password, meta.PlainSize, meta.NonceField)
+	} else {
+		c, err = encryption.NewFlowEnc(passwdInfo.Password, passwdInfo.EncType, ciphertextSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &localDecryptReader{file: file, meta: meta, cipher: c, size: meta.PlainSize}, nil
+}
+
+func (l *localDecryptReader) Read(p []byte) (int, error) {
+	if l.pos >= l.size {
+		return 0, io.EOF
+	}
+	if remaining := l.size - l.pos; int64(len(p)) > remaining {
+		p = p[:remaining]
+	}
+	if l.cipherPos != l.pos {
+		if err := l.cipher.SetPosition(l.pos); err != nil {
+			return 0, err
+		}
+		l.cipherPos = l.pos
+	}
+	n, err := l.file.ReadAt(p, l.meta.UpstreamOffset(l.pos))
+	l.cipher.Decrypt(p[:n])
+	l.pos += int64(n)
+	l.cipherPos = l.pos
+	if errors.Is(err, io.EOF) && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (l *localDecryptReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += l.pos
+	case io.SeekEnd:
+		offset += l.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position %d", offset)
+	}
+	l.pos = offset
+	return offset, nil
+}
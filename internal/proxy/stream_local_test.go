@@ -0,0 +1,180 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/encryption"
+)
+
+func writeEncryptedLocalFile(t *testing.T, plain []byte, passwdInfo *config.PasswdInfo, v2 bool) string {
+	t.Helper()
+	var ciphertext []byte
+	if v2 {
+		enc, err := encryption.NewLatestContentEncryptor(passwdInfo.Password, passwdInfo.EncType, int64(len(plain)))
+		if err != nil {
+			t.Fatalf("new encryptor: %v", err)
+		}
+		r, err := enc.EncryptReader(bytes.NewReader(plain), 0)
+		if err != nil {
+			t.Fatalf("encrypt reader: %v", err)
+		}
+		if ciphertext, err = io.ReadAll(r); err != nil {
+			t.Fatalf("encrypt: %v", err)
+		}
+	} else {
+		flow, err := encryption.NewFlowEnc(passwdInfo.Password, passwdInfo.EncType, int64(len(plain)))
+		if err != nil {
+			t.Fatalf("new flow: %v", err)
+		}
+		ciphertext = append([]byte(nil), plain...)
+		flow.Encrypt(ciphertext)
+	}
+	p := filepath.Join(t.TempDir(), "movie.mkv")
+	if err := os.WriteFile(p, ciphertext, 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	return p
+}
+
+const localTargetURL = "http://alist/d/media/movie.mkv?sign=ok"
+
+// signCheckingClient answers HEAD requests like Alist's /d route: 200 when
+// the sign query is "ok", 401 otherwise. Any other request fails the test.
+func signCheckingClient(t *testing.T, heads *int) *Client {
+	return newTestClient(func(r *http.Request) (*http.Response, error) {
+		if r.Method != http.MethodHead {
+			t.Errorf("unexpected upstream %s %s", r.Method, r.URL)
+		}
+		*heads++
+		status := http.StatusUnauthorized
+		if r.URL.Query().Get("sign") == "ok" {
+			status = http.StatusOK
+		}
+		return &http.Response{StatusCode: status, Body: io.NopCloser(bytes.NewReader(nil)), Header: make(http.Header), Request: r}, nil
+	})
+}
+
+func TestServeLocalDecryptRanges(t *testing.T) {
+	plain := make([]byte, 300*1024)
+	for i := range plain {
+		plain[i] = byte(i*7 + i/251)
+	}
+	for _, encType := range []string{"aesctr", "rc4md5", "chacha20"} {
+		for _, v2 := range []bool{false, true} {
+			passwdInfo := &config.PasswdInfo{Password: "local-pass", EncType: encType, Enable: true}
+			localPath := writeEncryptedLocalFile(t, plain, passwdInfo, v2)
+			var heads int
+			s := &StreamProxy{client: signCheckingClient(t, &heads)}
+
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, "/d/media/movie.mkv", nil)
+			if !s.ServeLocalDecrypt(rec, req, localTargetURL, localPath, passwdInfo) {
+				t.Fatalf("%s v2=%v: expected local read to be served", encType, v2)
+			}
+			if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), plain) {
+				t.Fatalf("%s v2=%v: full read mismatch, status=%d len=%d", encType, v2, rec.Code, rec.Body.Len())
+			}
+
+			rec = httptest.NewRecorder()
+			req = httptest.NewRequest(http.MethodGet, "/d/media/movie.mkv", nil)
+			req.Header.Set("Range", "bytes=200000-200099")
+			s.ServeLocalDecrypt(rec, req, localTargetURL, localPath, passwdInfo)
+			if rec.Code != http.StatusPartialContent {
+				t.Fatalf("%s v2=%v: expected 206, got %d", encType, v2, rec.Code)
+			}
+			if got := rec.Header().Get("Content-Range"); got != "bytes 200000-200099/307200" {
+				t.Fatalf("%s v2=%v: unexpected Content-Range %q", encType, v2, got)
+			}
+			if !bytes.Equal(rec.Body.Bytes(), plain[200000:200100]) {
+				t.Fatalf("%s v2=%v: range body mismatch", encType, v2)
+			}
+		}
+	}
+}
+
+func TestServeLocalDecryptMissingFileFallsBack(t *testing.T) {
+	var heads int
+	s := &StreamProxy{client: signCheckingClient(t, &heads)}
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/d/media/movie.mkv", nil)
+	passwdInfo := &config.PasswdInfo{Password: "p", EncType: "aesctr", Enable: true}
+	if s.ServeLocalDecrypt(rec, req, localTargetURL, filepath.Join(t.TempDir(), "missing.mkv"), passwdInfo) {
+		t.Fatal("expected fallback for missing file")
+	}
+	if rec.Body.Len() != 0 || len(rec.Header()) != 0 {
+		t.Fatal("fallback must not write a response")
+	}
+}
+
+func TestServeLocalDecryptRejectsUnsignedRequest(t *testing.T) {
+	plain := bytes.Repeat([]byte("secret"), 1024)
+	passwdInfo := &config.PasswdInfo{Password: "local-pass", EncType: "aesctr", Enable: true}
+	localPath := writeEncryptedLocalFile(t, plain, passwdInfo, true)
+	var heads int
+	s := &StreamProxy{client: signCheckingClient(t, &heads)}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/d/media/movie.mkv", nil)
+	if s.ServeLocalDecrypt(rec, req, "http://alist/d/media/movie.mkv", localPath, passwdInfo) {
+		t.Fatal("unsigned request must not be served from disk")
+	}
+	if heads != 1 {
+		t.Fatalf("expected one upstream auth check, got %d", heads)
+	}
+	if rec.Body.Len() != 0 || len(rec.Header()) != 0 {
+		t.Fatal("rejected local read must not write a response")
+	}
+
+	rec = httptest.NewRecorder()
+	if !s.ServeLocalDecrypt(rec, req, localTargetURL, localPath, passwdInfo) {
+		t.Fatal("signed request should be served from disk")
+	}
+	if !bytes.Equal(rec.Body.Bytes(), plain) {
+		t.Fatal("decrypted body mismatch")
+	}
+}
+
+func TestRotatedPasswordUploadsTagAndOldFilesStillDecrypt(t *testing.T) {
+	plain := bytes.Repeat([]byte("rotation-"), 4096)
+	old := &config.PasswdInfo{Password: "first", EncType: "aesctr", Enable: true}
+	oldPath := writeEncryptedLocalFile(t, plain, old, true)
+
+	rotated := &config.PasswdInfo{
+		Password:         "first",
+		EncType:          "aesctr",
+		Enable:           true,
+		PasswordVersions: []config.PasswordVersion{{Version: 2, Password: "second"}},
+	}
+	var heads int
+	s := &StreamProxy{uploadMeta: make(map[string]uploadMetaEntry), client: signCheckingClient(t, &heads)}
+	req := httptest.NewRequest(http.MethodPut, "/dav/media/new.mkv", nil)
+	body, meta, err := s.encryptUploadBody(req, bytes.NewReader(plain), "http://alist/dav/media/new.mkv", rotated, int64(len(plain)), 0)
+	if err != nil {
+		t.Fatalf("encrypt upload: %v", err)
+	}
+	if meta.PasswordVersion != 2 {
+		t.Fatalf("upload tagged with version %d, want 2", meta.PasswordVersion)
+	}
+	ciphertext, _ := io.ReadAll(body)
+	newPath := filepath.Join(t.TempDir(), "new.mkv")
+	if err := os.WriteFile(newPath, ciphertext, 0o600); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	for name, p := range map[string]string{"old": oldPath, "new": newPath} {
+		rec := httptest.NewRecorder()
+		if !s.ServeLocalDecrypt(rec, httptest.NewRequest(http.MethodGet, "/d/media/x.mkv", nil), localTargetURL, p, rotated) {
+			t.Fatalf("%s: expected local read to be served", name)
+		}
+		if !bytes.Equal(rec.Body.Bytes(), plain) {
+			t.Fatalf("%s: decrypted body mismatch", name)
+		}
+	}
+}
@@ -113,6 +113,48 @@ func (s *StreamProxy) InspectEncryptedContent(ctx context.Context, targetURL str
 	return s.inspectEncryptedContent(ctx, targetURL, authHeaders, passwdInfo, ciphertextSize)
 }
 
+// Prewarm requests the first byte of targetURL, following redirects like
+// InspectEncryptedContent, and returns the final status. It checks that the
+// link still works and leaves an idle connection to the storage host in the
+// pool, so the player's first range request skips DNS and TLS setup.
+func (s *StreamProxy) Prewarm(ctx context.Context, targetURL string, authHeaders http.Header) (int, error) {
+	maxHops := 2
+	if s.cfg != nil && s.cfg.AlistServer.RedirectMaxHops > 0 {
+		maxHops = s.cfg.AlistServer.RedirectMaxHops
+	}
+	currentURL := strings.TrimSpace(targetURL)
+	currentAuth := authHeaders
+	for hop := 0; ; hop++ {
+		req, err := httputil.NewRequest(http.MethodGet, currentURL).WithContext(ctx).Build()
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Range", "bytes=0-0")
+		req.Header.Set("Accept-Encoding", "identity")
+		copyProbeAuthHeaders(req, currentAuth)
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return 0, err
+		}
+		location := strings.TrimSpace(resp.Header.Get("Location"))
+		if resp.StatusCode >= 300 && resp.StatusCode < 400 && location != "" && hop < maxHops {
+			resp.Body.Close()
+			if currentURL, err = resolveRedirectTarget(currentURL, location); err != nil {
+				return 0, err
+			}
+			currentAuth = nil
+			continue
+		}
+		// A ranged answer is a single byte; reading it to EOF lets the
+		// connection be reused. Storage that ignores Range is not drained.
+		if resp.StatusCode == http.StatusPartialContent {
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+}
+
 func resolveRedirectTarget(baseURL, location string) (string, error) {
 	ref, err := url.Parse(location)
 	if err != nil {
@@ -129,6 +171,9 @@ func resolveRedirectTarget(baseURL, location string) (string, error) {
 }
 
 func buildUpstreamRangeHeader(rangeHeader string, meta encryption.ContentMeta) string {
+	if meta.IsAEAD() {
+		return buildAEADUpstreamRange(rangeHeader, meta)
+	}
 	if !meta.IsV2() {
 		return rangeHeader
 	}
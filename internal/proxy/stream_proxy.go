@@ -62,6 +62,8 @@ func (s *StreamProxy) ProxyRequest(w http.ResponseWriter, r *http.Request, targe
 	// Stream response body with large buffer
 	buf := getBuffer()
 	defer putBuffer(buf)
-	_, err = io.CopyBuffer(w, resp.Body, *buf)
+	out, done := s.Throttle(w, r)
+	defer done()
+	_, err = io.CopyBuffer(out, resp.Body, *buf)
 	return err
 }
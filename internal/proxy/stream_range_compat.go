@@ -8,6 +8,8 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/rs/zerolog/log"
+
 	"github.com/alist-encrypt-go/internal/httputil"
 )
 
@@ -170,6 +172,32 @@ func (s *StreamProxy) shouldSkipRange(targetURL, storageKey string) bool {
 	return shouldSkip
 }
 
+// recordRangeDiscard accounts for bytes decrypted and thrown away to reach a
+// seek offset on storages that do not honour Range, and warns once the skip
+// exceeds chunkedSeekMaxDiscardBytes. tailSkipped is the part of the body
+// past the client's range end that was not streamed.
+func (s *StreamProxy) recordRangeDiscard(targetURL, storageKey string, strategy StreamStrategy, discarded, tailSkipped int64) {
+	if s == nil || s.rangeStats == nil || discarded <= 0 {
+		return
+	}
+	atomic.AddUint64(&s.rangeStats.discardCount, 1)
+	atomic.AddUint64(&s.rangeStats.discardBytes, uint64(discarded))
+	if tailSkipped > 0 {
+		atomic.AddUint64(&s.rangeStats.tailSkippedBytes, uint64(tailSkipped))
+	}
+	if maxDiscard := s.chunkedSeekMaxDiscardBytes(); maxDiscard > 0 && discarded > maxDiscard {
+		atomic.AddUint64(&s.rangeStats.discardWarnCount, 1)
+		log.Warn().
+			Str("category", "range_compat").
+			Str("target_url", targetURL).
+			Str("storage", normalizeCompatStorageKey(storageKey)).
+			Str("strategy", string(strategy)).
+			Int64("discard_bytes", discarded).
+			Int64("max_discard_bytes", maxDiscard).
+			Msg("Storage ignores Range; discarded large prefix to reach seek offset")
+	}
+}
+
 func (s *StreamProxy) recordRangeFailure(targetURL, storageKey, reason string) {
 	if s.compatStore == nil || s.cfg == nil || !s.cfg.AlistServer.EnableRangeCompatCache {
 		return
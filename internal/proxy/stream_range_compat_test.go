@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"bytes"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -9,6 +10,7 @@ import (
 	"time"
 
 	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/encryption"
 )
 
 func TestRangeCompatDowngradeAfterConsecutivePseudoRangeFailures(t *testing.T) {
@@ -305,3 +307,51 @@ func TestSelectOptimalStrategyIgnoresExpiredPlaybackHint(t *testing.T) {
 		t.Fatalf("strategy=%s, want %s", got, StreamStrategyRange)
 	}
 }
+
+func TestFullStrategySeekCountsDiscardAndStopsAtRangeEnd(t *testing.T) {
+	cfg := config.DefaultConfig()
+	sp := NewStreamProxy(cfg)
+
+	fileSize := int64(16)
+	plain := []byte("0123456789abcdef")
+	ciphertext := append([]byte(nil), plain...)
+	flow, err := encryption.NewFlowEnc("123456", "aesctr", fileSize)
+	if err != nil {
+		t.Fatalf("failed to create flow enc: %v", err)
+	}
+	flow.Encrypt(ciphertext)
+
+	sp.client = newTestClient(func(r *http.Request) (*http.Response, error) {
+		headers := make(http.Header)
+		headers.Set("Content-Length", "16")
+		headers.Set("Content-Type", "video/mp4")
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     headers,
+			Body:       io.NopCloser(bytes.NewReader(ciphertext)),
+			Request:    r,
+		}, nil
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/d/test.bin", nil)
+	req.Header.Set("Range", "bytes=4-9")
+	rr := httptest.NewRecorder()
+	passwd := &config.PasswdInfo{Password: "123456", EncType: "aesctr", Enable: true}
+	result := sp.ProxyDownloadDecryptWithStrategyForStorage(rr, req, "http://upstream.local/file", passwd, fileSize, StreamStrategyFull, "/encrypt/test.bin")
+	if result.Err != nil {
+		t.Fatalf("unexpected stream error: %v", result.Err)
+	}
+	if rr.Code != http.StatusPartialContent {
+		t.Fatalf("status=%d, want 206", rr.Code)
+	}
+	if got := rr.Header().Get("Content-Range"); got != "bytes 4-9/16" {
+		t.Fatalf("Content-Range=%q, want bytes 4-9/16", got)
+	}
+	if got := rr.Body.String(); got != string(plain[4:10]) {
+		t.Fatalf("body=%q, want %q", got, plain[4:10])
+	}
+	stats := sp.RangeCompatStats()
+	if stats["discard_bytes"] != uint64(4) || stats["tail_skipped_bytes"] != uint64(6) {
+		t.Fatalf("discard_bytes=%v tail_skipped_bytes=%v", stats["discard_bytes"], stats["tail_skipped_bytes"])
+	}
+}
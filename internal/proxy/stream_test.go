@@ -761,3 +761,33 @@ func TestMediaContentTypeSkipsSniffAtStart(t *testing.T) {
 		t.Fatalf("decrypted body mismatch: got %d bytes", len(body))
 	}
 }
+
+func TestPrewarmFollowsRedirectAndReadsOneByte(t *testing.T) {
+	sp := NewStreamProxy(config.DefaultConfig())
+	var hits []string
+	sp.client = newTestClient(func(r *http.Request) (*http.Response, error) {
+		hits = append(hits, r.URL.String()+" "+r.Header.Get("Range")+" "+r.Header.Get("Authorization"))
+		if r.URL.Host == "openalist:5244" {
+			return &http.Response{
+				StatusCode: http.StatusFound,
+				Header:     http.Header{"Location": []string{"https://cdn.example/demo.bin"}},
+				Body:       io.NopCloser(strings.NewReader("")),
+				Request:    r,
+			}, nil
+		}
+		return &http.Response{
+			StatusCode: http.StatusPartialContent,
+			Header:     http.Header{"Content-Range": []string{"bytes 0-0/100"}},
+			Body:       io.NopCloser(strings.NewReader("x")),
+			Request:    r,
+		}, nil
+	})
+	status, err := sp.Prewarm(context.Background(), "http://openalist:5244/d/demo.bin", http.Header{"Authorization": []string{"tok"}})
+	if err != nil || status != http.StatusPartialContent {
+		t.Fatalf("status=%d err=%v", status, err)
+	}
+	want := []string{"http://openalist:5244/d/demo.bin bytes=0-0 tok", "https://cdn.example/demo.bin bytes=0-0 "}
+	if strings.Join(hits, "|") != strings.Join(want, "|") {
+		t.Fatalf("requests=%q", hits)
+	}
+}
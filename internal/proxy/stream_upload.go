@@ -13,54 +13,14 @@ import (
 	"github.com/alist-encrypt-go/internal/httputil"
 )
 
-// ProxyUploadEncrypt uploads with encryption.
+// ProxyUploadEncrypt uploads with encryption. The body runs through the
+// passwd entry's upload pipeline (see UploadTransform) and is encrypted last.
 // startOffset should be the absolute file offset for chunked/resume uploads.
 func (s *StreamProxy) ProxyUploadEncrypt(w http.ResponseWriter, r *http.Request, targetURL string, passwdInfo *config.PasswdInfo, fileSize int64, startOffset int64) error {
-	var (
-		encryptedBody io.Reader
-		contentMeta   encryption.ContentMeta
-		err           error
-	)
-	if startOffset > 0 {
-		meta, ok := s.getUploadMeta(targetURL)
-		if !ok {
-			meta = encryption.LegacyContentMeta(encryption.EncType(passwdInfo.EncType), fileSize)
-		}
-		if !meta.IsV2() && (strings.Contains(targetURL, "/dav/") || strings.HasSuffix(targetURL, "/dav")) {
-			meta = s.inspectEncryptedContent(r.Context(), targetURL, r.Header, passwdInfo, fileSize)
-		}
-		if meta.IsV2() {
-			cipherImpl, cipherErr := encryption.NewCipherV2(encryption.EncType(passwdInfo.EncType), passwdInfo.Password, meta.PlainSize, meta.NonceField)
-			if cipherErr != nil {
-				return errors.NewEncryptionErrorWithCause("failed to create v2 cipher", cipherErr)
-			}
-			if err := cipherImpl.SetPosition(startOffset); err != nil {
-				return errors.NewEncryptionErrorWithCause("failed to set upload offset", err)
-			}
-			encryptedBody = cipherImpl.EncryptReader(r.Body)
-			contentMeta = meta
-		} else {
-			flowEnc, cipherErr := encryption.NewFlowEnc(passwdInfo.Password, passwdInfo.EncType, fileSize)
-			if cipherErr != nil {
-				return errors.NewEncryptionErrorWithCause("failed to create cipher", cipherErr)
-			}
-			if err := flowEnc.SetPosition(startOffset); err != nil {
-				return errors.NewEncryptionErrorWithCause("failed to set upload offset", err)
-			}
-			encryptedBody = flowEnc.EncryptReader(r.Body)
-			contentMeta = meta
-		}
-	} else {
-		contentEnc, cipherErr := encryption.NewLatestContentEncryptor(passwdInfo.Password, passwdInfo.EncType, fileSize)
-		if cipherErr != nil {
-			return errors.NewEncryptionErrorWithCause("failed to create cipher", cipherErr)
-		}
-		encryptedBody, err = contentEnc.EncryptReader(r.Body, startOffset)
-		if err != nil {
-			return errors.NewEncryptionErrorWithCause("failed to create encrypt reader", err)
-		}
-		contentMeta = contentEnc.Meta
-		s.putUploadMeta(targetURL, contentMeta)
+	body := applyUploadTransforms(r.Body, passwdInfo, startOffset)
+	encryptedBody, contentMeta, err := s.encryptUploadBody(r, body, targetURL, passwdInfo, fileSize, startOffset)
+	if err != nil {
+		return err
 	}
 
 	req, err := httputil.NewRequest(r.Method, targetURL).
@@ -89,19 +49,100 @@ func (s *StreamProxy) ProxyUploadEncrypt(w http.ResponseWriter, r *http.Request,
 	_, err = io.CopyBuffer(w, resp.Body, *buf)
 	return err
 }
+
+// encryptUploadBody is the final upload stage. A fresh upload gets the
+// configured content format (uploadContentVersion) and its meta is remembered
+// for the continuation chunks; those resume the cipher at startOffset with the
+// meta the first chunk used. Fresh V2/V3 content is encrypted with the
+// folder's current password version (see PasswdInfo.CurrentPassword).
+func (s *StreamProxy) encryptUploadBody(r *http.Request, body io.Reader, targetURL string, passwdInfo *config.PasswdInfo, fileSize int64, startOffset int64) (io.Reader, encryption.ContentMeta, error) {
+	if startOffset > 0 {
+		meta, ok := s.getUploadMeta(targetURL)
+		if !ok {
+			meta = encryption.LegacyContentMeta(encryption.EncType(passwdInfo.EncType), fileSize)
+		}
+		if meta.IsAEAD() || encryption.IsAEADEncType(passwdInfo.EncType) {
+			// Chunk tags cover whole chunks bound to one header; a resumed
+			// body cannot be sealed without re-reading what was sent.
+			return nil, meta, errors.NewEncryptionError("resumed uploads are not supported for authenticated (v3) folders")
+		}
+		if !meta.IsV2() && (strings.Contains(targetURL, "/dav/") || strings.HasSuffix(targetURL, "/dav")) {
+			meta = s.inspectEncryptedContent(r.Context(), targetURL, r.Header, passwdInfo, fileSize)
+		}
+		if meta.IsV2() {
+			password, err := passwdInfo.ContentPassword(meta.PasswordVersion)
+			if err != nil {
+				return nil, meta, errors.NewEncryptionErrorWithCause("failed to create v2 cipher", err)
+			}
+			cipherImpl, err := encryption.NewCipherV2(encryption.EncType(passwdInfo.EncType), password, meta.PlainSize, meta.NonceField)
+			if err != nil {
+				return nil, meta, errors.NewEncryptionErrorWithCause("failed to create v2 cipher", err)
+			}
+			if err := cipherImpl.SetPosition(startOffset); err != nil {
+				return nil, meta, errors.NewEncryptionErrorWithCause("failed to set upload offset", err)
+			}
+			return cipherImpl.EncryptReader(body), meta, nil
+		}
+		flowEnc, err := encryption.NewFlowEnc(passwdInfo.Password, passwdInfo.EncType, fileSize)
+		if err != nil {
+			return nil, meta, errors.NewEncryptionErrorWithCause("failed to create cipher", err)
+		}
+		if err := flowEnc.SetPosition(startOffset); err != nil {
+			return nil, meta, errors.NewEncryptionErrorWithCause("failed to set upload offset", err)
+		}
+		return flowEnc.EncryptReader(body), meta, nil
+	}
+
+	password, passwordVersion := passwdInfo.CurrentPassword()
+	if s.uploadContentVersion() == encryption.ContentVersionV1 && !encryption.IsAEADEncType(passwdInfo.EncType) {
+		// Headerless content has nowhere to record a version.
+		password, passwordVersion = passwdInfo.Password, 1
+	}
+	contentEnc, err := encryption.NewVersionedContentEncryptor(password, passwdInfo.EncType, fileSize, s.uploadContentVersion(), passwordVersion)
+	if err != nil {
+		return nil, encryption.ContentMeta{}, errors.NewEncryptionErrorWithCause("failed to create cipher", err)
+	}
+	encryptedBody, err := contentEnc.EncryptReader(body, startOffset)
+	if err != nil {
+		return nil, encryption.ContentMeta{}, errors.NewEncryptionErrorWithCause("failed to create encrypt reader", err)
+	}
+	s.putUploadMeta(targetURL, contentEnc.Meta)
+	return encryptedBody, contentEnc.Meta, nil
+}
+
+// uploadContentVersion is the content format fresh uploads are written in.
+func (s *StreamProxy) uploadContentVersion() int {
+	if s.cfg != nil && s.cfg.AlistServer.UploadContentVersion == encryption.ContentVersionV1 {
+		return encryption.ContentVersionV1
+	}
+	return encryption.ContentVersionV2
+}
+
 func rewriteUploadHeadersForV2(req *http.Request, meta encryption.ContentMeta, startOffset int64, originalContentRange string) {
-	if req == nil || !meta.IsV2() {
+	if req == nil || !meta.HasContentHeader() {
 		return
 	}
 	ciphertextSize := meta.TotalCiphertextSize()
-	if rewritten, ok := rewritePlainContentRangeToCiphertext(originalContentRange, meta.HeaderLen); ok {
-		req.Header.Set("Content-Range", rewritten)
-	}
-	if req.ContentLength > 0 {
-		if startOffset == 0 {
-			req.ContentLength += meta.HeaderLen
+	if meta.IsAEAD() {
+		// AEAD bodies are always sent whole, so the ciphertext replaces the
+		// plaintext size wherever one was declared.
+		if strings.TrimSpace(originalContentRange) != "" && ciphertextSize > 0 {
+			req.Header.Set("Content-Range", fmt.Sprintf("bytes 0-%d/%d", ciphertextSize-1, ciphertextSize))
+		}
+		if req.ContentLength > 0 {
+			req.ContentLength = ciphertextSize
+			req.Header.Set("Content-Length", strconv.FormatInt(req.ContentLength, 10))
+		}
+	} else {
+		if rewritten, ok := rewritePlainContentRangeToCiphertext(originalContentRange, meta.HeaderLen); ok {
+			req.Header.Set("Content-Range", rewritten)
+		}
+		if req.ContentLength > 0 {
+			if startOffset == 0 {
+				req.ContentLength += meta.HeaderLen
+			}
+			req.Header.Set("Content-Length", strconv.FormatInt(req.ContentLength, 10))
 		}
-		req.Header.Set("Content-Length", strconv.FormatInt(req.ContentLength, 10))
 	}
 	if ciphertextSize > 0 {
 		sizeStr := strconv.FormatInt(ciphertextSize, 10)
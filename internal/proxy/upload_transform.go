@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"io"
+	"sync"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/metascrub"
+	"github.com/rs/zerolog/log"
+)
+
+// UploadTransform is one stage of the upload pipeline. Stages rewrite the
+// plaintext body in the order a passwd entry lists them; encryption is always
+// the final stage. Implementations must preserve the length byte for byte: by
+// the time the body is read, the client's declared size is already committed
+// to Content-Length, the V2 content header and resumable upload offsets.
+type UploadTransform interface {
+	Name() string
+	Wrap(body io.Reader) io.Reader
+}
+
+var (
+	uploadTransformsMu sync.RWMutex
+	uploadTransforms   = map[string]UploadTransform{
+		config.UploadTransformStripMetadata: metadataScrubTransform{},
+	}
+)
+
+// RegisterUploadTransform makes t available to passwd entries under t.Name().
+// A later registration with the same name replaces the earlier one.
+func RegisterUploadTransform(t UploadTransform) {
+	uploadTransformsMu.Lock()
+	defer uploadTransformsMu.Unlock()
+	uploadTransforms[t.Name()] = t
+}
+
+// uploadPipeline resolves the transforms passwdInfo declares. Unknown names
+// are logged and skipped so a typo never blocks uploads.
+func uploadPipeline(passwdInfo *config.PasswdInfo) []UploadTransform {
+	if passwdInfo == nil {
+		return nil
+	}
+	names := passwdInfo.UploadTransformNames()
+	if len(names) == 0 {
+		return nil
+	}
+	uploadTransformsMu.RLock()
+	defer uploadTransformsMu.RUnlock()
+	stages := make([]UploadTransform, 0, len(names))
+	for _, name := range names {
+		t, ok := uploadTransforms[name]
+		if !ok {
+			log.Warn().Str("transform", name).Msg("Unknown upload transform, skipping")
+			continue
+		}
+		stages = append(stages, t)
+	}
+	return stages
+}
+
+// applyUploadTransforms runs body through the passwd entry's pipeline.
+// Transforms only see uploads that start at the first byte of the file;
+// continuation chunks of a resumable upload pass through unchanged.
+func applyUploadTransforms(body io.Reader, passwdInfo *config.PasswdInfo, startOffset int64) io.Reader {
+	if startOffset != 0 {
+		return body
+	}
+	for _, t := range uploadPipeline(passwdInfo) {
+		body = t.Wrap(body)
+	}
+	return body
+}
+
+// metadataScrubTransform blanks EXIF/GPS and video user data.
+type metadataScrubTransform struct{}
+
+func (metadataScrubTransform) Name() string { return config.UploadTransformStripMetadata }
+
+func (metadataScrubTransform) Wrap(body io.Reader) io.Reader {
+	return metascrub.NewReader(body)
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/encryption"
+)
+
+func TestProxyUploadEncryptStripsMetadataWhenEnabled(t *testing.T) {
+	exif := append([]byte("Exif\x00\x00MM\x00\x2a\x00\x00\x00\x08\x00\x00\x00\x00\x00\x00"), "GPS-SECRET"...)
+	var plain bytes.Buffer
+	plain.Write([]byte{0xFF, 0xD8, 0xFF, 0xE1})
+	_ = binary.Write(&plain, binary.BigEndian, uint16(len(exif)+2))
+	plain.Write(exif)
+	plain.Write([]byte{0xFF, 0xDA, 0x00, 0x02, 0x11, 0x22, 0xFF, 0xD9})
+
+	for _, strip := range []bool{false, true} {
+		sp := NewStreamProxy(config.DefaultConfig())
+		var received []byte
+		sp.client = newTestClient(func(r *http.Request) (*http.Response, error) {
+			received, _ = io.ReadAll(r.Body)
+			return &http.Response{StatusCode: http.StatusOK, Header: make(http.Header), Body: io.NopCloser(strings.NewReader("{}")), Request: r}, nil
+		})
+		passwd := &config.PasswdInfo{Password: "123456", EncType: "aesctr", Enable: true, StripMetadata: strip}
+		req := httptest.NewRequest(http.MethodPut, "/api/fs/put", bytes.NewReader(plain.Bytes()))
+		if err := sp.ProxyUploadEncrypt(httptest.NewRecorder(), req, "http://upstream.local/put", passwd, int64(plain.Len()), 0); err != nil {
+			t.Fatalf("upload: %v", err)
+		}
+		reader, _, err := encryption.AutoDecryptReader("123456", encryption.EncTypeAESCTR, bytes.NewReader(received), int64(len(received)))
+		if err != nil {
+			t.Fatalf("decrypt reader: %v", err)
+		}
+		stored, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("decrypt: %v", err)
+		}
+		if len(stored) != plain.Len() {
+			t.Fatalf("strip=%v: stored %d bytes, want %d", strip, len(stored), plain.Len())
+		}
+		if got := bytes.Contains(stored, []byte("GPS-SECRET")); got == strip {
+			t.Fatalf("strip=%v: metadata present=%v", strip, got)
+		}
+	}
+}
+
+type tagTransform struct {
+	name string
+	tag  byte
+}
+
+func (t tagTransform) Name() string { return t.name }
+
+func (t tagTransform) Wrap(body io.Reader) io.Reader {
+	data, _ := io.ReadAll(body)
+	if len(data) > 0 {
+		data[0] = t.tag
+	}
+	return bytes.NewReader(data)
+}
+
+func TestUploadPipelineRunsDeclaredStagesInOrder(t *testing.T) {
+	RegisterUploadTransform(tagTransform{name: "test_tag_a", tag: 'A'})
+	RegisterUploadTransform(tagTransform{name: "test_tag_b", tag: 'B'})
+
+	passwd := &config.PasswdInfo{UploadTransforms: []string{"test_tag_b", "missing_stage", "test_tag_a"}}
+	got, _ := io.ReadAll(applyUploadTransforms(strings.NewReader("xyz"), passwd, 0))
+	if string(got) != "Ayz" {
+		t.Fatalf("pipeline output %q, want stages applied b then a", got)
+	}
+	got, _ = io.ReadAll(applyUploadTransforms(strings.NewReader("xyz"), passwd, 5))
+	if string(got) != "xyz" {
+		t.Fatalf("continuation chunk was transformed: %q", got)
+	}
+
+	passwd = &config.PasswdInfo{StripMetadata: true, UploadTransforms: []string{"test_tag_a"}}
+	stages := uploadPipeline(passwd)
+	if len(stages) != 2 || stages[0].Name() != "test_tag_a" || stages[1].Name() != config.UploadTransformStripMetadata {
+		t.Fatalf("unexpected pipeline %v", stages)
+	}
+}
@@ -0,0 +1,245 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/pathutil"
+)
+
+// pruneInterval is how often per-address buckets that have refilled are
+// dropped. A full bucket is the same as a new one, so dropping it loses
+// nothing and keeps the maps from growing with every client ever seen.
+const pruneInterval = time.Minute
+
+// limit is the refill rate and capacity of a token bucket.
+type limit struct {
+	rate  float64 // tokens per second
+	burst float64
+}
+
+func newLimit(l config.RateLimit) limit {
+	return limit{rate: l.RPS, burst: float64(l.Burst)}
+}
+
+func (l limit) on() bool { return l.rate > 0 }
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// refill brings b up to date; a new bucket starts full.
+func (l limit) refill(b *bucket, now time.Time) {
+	if b.last.IsZero() {
+		b.tokens = l.burst
+	} else if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(l.burst, b.tokens+elapsed.Seconds()*l.rate)
+	}
+	b.last = now
+}
+
+// wait returns how long until b holds a whole token.
+func (l limit) wait(b *bucket) time.Duration {
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// full reports whether b has refilled completely by now.
+func (l limit) full(b *bucket, now time.Time) bool {
+	return b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst
+}
+
+type route struct {
+	prefix  string
+	methods []string
+	limit   limit
+	shared  bool
+	bucket  bucket
+	perIP   map[string]*bucket
+}
+
+func (r *route) matches(method, urlPath string) bool {
+	if !pathutil.Within(urlPath, r.prefix) {
+		return false
+	}
+	return len(r.methods) == 0 || slices.Contains(r.methods, method)
+}
+
+// Limiter applies the global, per-address and per-route token buckets of a
+// rate_limit config. A nil Limiter allows everything.
+type Limiter struct {
+	cfg config.RateLimitConfig
+
+	mu        sync.Mutex
+	global    limit
+	globalB   bucket
+	perIP     limit
+	ipBuckets map[string]*bucket
+	routes    []*route
+	exempt    []*net.IPNet
+	lastPrune time.Time
+	now       func() time.Time
+
+	allowed  uint64
+	rejected uint64
+}
+
+// New builds a Limiter from cfg, or returns nil when cfg is nil. An invalid
+// exempt list is reported and ignored; the limits still apply.
+func New(cfg *config.RateLimitConfig) (*Limiter, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	l := &Limiter{
+		cfg:       *cfg,
+		global:    newLimit(cfg.Global),
+		perIP:     newLimit(cfg.PerIP),
+		ipBuckets: make(map[string]*bucket),
+		now:       time.Now,
+	}
+	for _, rc := range cfg.Routes {
+		if rc.Prefix == "" || rc.RPS <= 0 {
+			continue
+		}
+		l.routes = append(l.routes, &route{
+			prefix:  rc.Prefix,
+			methods: rc.Methods,
+			limit:   newLimit(rc.RateLimit),
+			shared:  rc.Shared,
+			perIP:   make(map[string]*bucket),
+		})
+	}
+	exempt, err := config.ParseIPNets(cfg.Exempt)
+	if err != nil {
+		return l, fmt.Errorf("rate_limit.exempt: %w", err)
+	}
+	l.exempt = exempt
+	return l, nil
+}
+
+// Same reports whether l was built from cfg, so a config apply that did not
+// touch rate_limit keeps the buckets as they are.
+func (l *Limiter) Same(cfg *config.RateLimitConfig) bool {
+	if l == nil || cfg == nil {
+		return l == nil && cfg == nil
+	}
+	return reflect.DeepEqual(l.cfg, *cfg)
+}
+
+// Allow takes a token from every bucket that applies to a request and
+// reports whether it may proceed. A request is only charged when all of
+// them have a token, so rejected requests do not drain the other limits.
+// When it is rejected, retryAfter is how long until it would pass and scope
+// names the limit that was hit.
+func (l *Limiter) Allow(clientIP, method, urlPath string) (ok bool, retryAfter time.Duration, scope string) {
+	if l == nil {
+		return true, 0, ""
+	}
+	if ip := net.ParseIP(clientIP); ip != nil {
+		for _, n := range l.exempt {
+			if n.Contains(ip) {
+				return true, 0, ""
+			}
+		}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.pruneLocked(now)
+
+	type charge struct {
+		limit  limit
+		bucket *bucket
+		scope  string
+	}
+	var charges []charge
+	if l.global.on() {
+		charges = append(charges, charge{l.global, &l.globalB, "global"})
+	}
+	if l.perIP.on() {
+		charges = append(charges, charge{l.perIP, bucketFor(l.ipBuckets, clientIP), "per_ip"})
+	}
+	for _, r := range l.routes {
+		if !r.matches(method, urlPath) {
+			continue
+		}
+		b := &r.bucket
+		if !r.shared {
+			b = bucketFor(r.perIP, clientIP)
+		}
+		charges = append(charges, charge{r.limit, b, "route " + r.prefix})
+	}
+
+	for _, c := range charges {
+		c.limit.refill(c.bucket, now)
+		if wait := c.limit.wait(c.bucket); wait > retryAfter {
+			retryAfter, scope = wait, c.scope
+		}
+	}
+	if retryAfter > 0 {
+		atomic.AddUint64(&l.rejected, 1)
+		return false, retryAfter, scope
+	}
+	for _, c := range charges {
+		c.bucket.tokens--
+	}
+	atomic.AddUint64(&l.allowed, 1)
+	return true, 0, ""
+}
+
+func bucketFor(m map[string]*bucket, key string) *bucket {
+	b := m[key]
+	if b == nil {
+		b = &bucket{}
+		m[key] = b
+	}
+	return b
+}
+
+func (l *Limiter) pruneLocked(now time.Time) {
+	if now.Sub(l.lastPrune) < pruneInterval {
+		return
+	}
+	l.lastPrune = now
+	prune := func(lim limit, m map[string]*bucket) {
+		for key, b := range m {
+			if lim.full(b, now) {
+				delete(m, key)
+			}
+		}
+	}
+	prune(l.perIP, l.ipBuckets)
+	for _, r := range l.routes {
+		prune(r.limit, r.perIP)
+	}
+}
+
+// Stats returns how many requests were allowed and rejected and how many
+// client addresses are being tracked.
+func (l *Limiter) Stats() map[string]interface{} {
+	if l == nil {
+		return map[string]interface{}{"enabled": false}
+	}
+	l.mu.Lock()
+	clients := len(l.ipBuckets)
+	for _, r := range l.routes {
+		clients = max(clients, len(r.perIP))
+	}
+	l.mu.Unlock()
+	return map[string]interface{}{
+		"enabled":         true,
+		"allowed":         atomic.LoadUint64(&l.allowed),
+		"rejected":        atomic.LoadUint64(&l.rejected),
+		"tracked_clients": clients,
+	}
+}
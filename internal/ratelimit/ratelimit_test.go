@@ -0,0 +1,143 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter(t *testing.T, cfg config.RateLimitConfig) (*Limiter, *fakeClock) {
+	t.Helper()
+	l, err := New(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{t: time.Unix(1000, 0)}
+	l.now = clock.now
+	return l, clock
+}
+
+func TestPerIPBurstAndRefill(t *testing.T) {
+	l, clock := newTestLimiter(t, config.RateLimitConfig{Enable: true, PerIP: config.RateLimit{RPS: 2, Burst: 3}})
+	for i := 0; i < 3; i++ {
+		if ok, _, _ := l.Allow("10.0.0.1", "GET", "/d/a"); !ok {
+			t.Fatalf("request %d within burst rejected", i)
+		}
+	}
+	ok, retry, scope := l.Allow("10.0.0.1", "GET", "/d/a")
+	if ok || scope != "per_ip" || retry != 500*time.Millisecond {
+		t.Fatalf("over burst: ok=%v retry=%s scope=%q", ok, retry, scope)
+	}
+	if ok, _, _ := l.Allow("10.0.0.2", "GET", "/d/a"); !ok {
+		t.Fatal("another client shares the first one's bucket")
+	}
+	clock.advance(500 * time.Millisecond)
+	if ok, _, _ := l.Allow("10.0.0.1", "GET", "/d/a"); !ok {
+		t.Fatal("not refilled after Retry-After")
+	}
+}
+
+func TestGlobalLimitCoversAllClients(t *testing.T) {
+	l, _ := newTestLimiter(t, config.RateLimitConfig{Enable: true, Global: config.RateLimit{RPS: 1, Burst: 2}})
+	l.Allow("10.0.0.1", "GET", "/")
+	l.Allow("10.0.0.2", "GET", "/")
+	if ok, _, scope := l.Allow("10.0.0.3", "GET", "/"); ok || scope != "global" {
+		t.Fatalf("third client allowed past the global burst (scope %q)", scope)
+	}
+}
+
+func TestRouteLimit(t *testing.T) {
+	l, _ := newTestLimiter(t, config.RateLimitConfig{Enable: true, Routes: []config.RouteRateLimit{
+		{Prefix: "/d", RateLimit: config.RateLimit{RPS: 1, Burst: 1}},
+		{Prefix: "/api/fs/put", Methods: []string{"PUT"}, RateLimit: config.RateLimit{RPS: 1, Burst: 1}, Shared: true},
+	}})
+	if ok, _, _ := l.Allow("10.0.0.1", "GET", "/d/movie.mkv"); !ok {
+		t.Fatal("first /d request rejected")
+	}
+	if ok, _, scope := l.Allow("10.0.0.1", "GET", "/d/other.mkv"); ok || scope != "route /d" {
+		t.Fatalf("second /d request allowed (scope %q)", scope)
+	}
+	for _, p := range []string{"/dav/movie.mkv", "/api/fs/list", "/download"} {
+		if ok, _, _ := l.Allow("10.0.0.1", "GET", p); !ok {
+			t.Fatalf("%s limited by the /d route", p)
+		}
+	}
+	if ok, _, _ := l.Allow("10.0.0.2", "GET", "/d/movie.mkv"); !ok {
+		t.Fatal("per-client route bucket shared between clients")
+	}
+
+	l.Allow("10.0.0.1", "PUT", "/api/fs/put")
+	if ok, _, _ := l.Allow("10.0.0.1", "POST", "/api/fs/put"); !ok {
+		t.Fatal("method filter ignored")
+	}
+	if ok, _, _ := l.Allow("10.0.0.2", "PUT", "/api/fs/put"); ok {
+		t.Fatal("shared route bucket not shared between clients")
+	}
+}
+
+func TestRejectedRequestsDoNotDrainOtherBuckets(t *testing.T) {
+	l, clock := newTestLimiter(t, config.RateLimitConfig{
+		Enable: true,
+		PerIP:  config.RateLimit{RPS: 10, Burst: 2},
+		Routes: []config.RouteRateLimit{{Prefix: "/p", RateLimit: config.RateLimit{RPS: 1, Burst: 1}}},
+	})
+	l.Allow("10.0.0.1", "GET", "/p/a")
+	for i := 0; i < 5; i++ {
+		if ok, _, _ := l.Allow("10.0.0.1", "GET", "/p/a"); ok {
+			t.Fatal("route limit not applied")
+		}
+	}
+	// Only the one admitted request was charged to the per-client bucket.
+	clock.advance(time.Millisecond)
+	if ok, _, _ := l.Allow("10.0.0.1", "GET", "/d/a"); !ok {
+		t.Fatal("rejected requests drained the per-client bucket")
+	}
+}
+
+func TestExemptClients(t *testing.T) {
+	l, _ := newTestLimiter(t, config.RateLimitConfig{Enable: true, Global: config.RateLimit{RPS: 1, Burst: 1}, Exempt: []string{"192.168.1.0/24", "::1"}})
+	for i := 0; i < 5; i++ {
+		if ok, _, _ := l.Allow("192.168.1.20", "GET", "/"); !ok {
+			t.Fatal("exempt subnet limited")
+		}
+		if ok, _, _ := l.Allow("::1", "GET", "/"); !ok {
+			t.Fatal("exempt address limited")
+		}
+	}
+
+	if _, err := New(&config.RateLimitConfig{Enable: true, Exempt: []string{"lan"}}); err == nil {
+		t.Fatal("invalid exempt entry accepted")
+	}
+}
+
+func TestPruneDropsRefilledClients(t *testing.T) {
+	l, clock := newTestLimiter(t, config.RateLimitConfig{Enable: true, PerIP: config.RateLimit{RPS: 1, Burst: 5}})
+	l.Allow("10.0.0.1", "GET", "/")
+	clock.advance(pruneInterval)
+	l.Allow("10.0.0.2", "GET", "/")
+	if n := l.Stats()["tracked_clients"]; n != 1 {
+		t.Fatalf("tracked_clients = %v after prune, want 1", n)
+	}
+}
+
+func TestSame(t *testing.T) {
+	cfg := &config.RateLimitConfig{Enable: true, PerIP: config.RateLimit{RPS: 1, Burst: 1}}
+	l, _ := New(cfg)
+	if !l.Same(&config.RateLimitConfig{Enable: true, PerIP: config.RateLimit{RPS: 1, Burst: 1}}) {
+		t.Fatal("equal config reported as changed")
+	}
+	if l.Same(&config.RateLimitConfig{Enable: true, PerIP: config.RateLimit{RPS: 2, Burst: 2}}) || l.Same(nil) {
+		t.Fatal("changed config reported as the same")
+	}
+	var none *Limiter
+	if ok, _, _ := none.Allow("10.0.0.1", "GET", "/"); !ok || !none.Same(nil) {
+		t.Fatal("nil limiter must allow everything")
+	}
+}
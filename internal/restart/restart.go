@@ -14,6 +14,14 @@ func SetChan(ch chan struct{}) {
 	restartChan = ch
 }
 
+// Available reports whether a restart loop is listening, which is not the
+// case when the server is embedded without cmd/server.
+func Available() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return restartChan != nil
+}
+
 // Trigger signals the server to restart
 func Trigger() {
 	mu.Lock()
@@ -23,3 +31,21 @@ func Trigger() {
 		restartChan = nil
 	}
 }
+
+var execRequested bool
+
+// TriggerExec signals a restart that re-executes the (replaced) binary
+// instead of reloading the server in-process.
+func TriggerExec() {
+	mu.Lock()
+	execRequested = true
+	mu.Unlock()
+	Trigger()
+}
+
+// ExecRequested reports whether the last restart asked for a re-exec.
+func ExecRequested() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return execRequested
+}
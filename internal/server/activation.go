@@ -0,0 +1,247 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+// Socket activation (sd_listen_fds(3)): systemd binds the sockets of a
+// .socket unit and starts the service with them open as fds 3 onwards,
+// described by LISTEN_PID, LISTEN_FDS and LISTEN_FDNAMES. The port stays
+// bound while the service restarts, so connections queue instead of being
+// refused, and the service can start on the first connection.
+//
+// The fds are kept open for the life of the process and not marked
+// close-on-exec: the in-process restart takes them again, and a re-exec
+// keeps both the fds and the PID that LISTEN_PID names.
+const listenFDsStart = 3
+
+var (
+	activationOnce  sync.Once
+	activationFiles []*os.File
+)
+
+// Sockets the server binds itself are retained the same way, so an
+// in-process restart gives the new server the socket the old one used. The
+// new server accepts on it while the old one drains, and connections that
+// arrive while neither is accepting wait in the backlog instead of being
+// refused.
+var (
+	retainedMu    sync.Mutex
+	retainedFiles = map[string]*os.File{}
+)
+
+// parseListenFDs returns the fds and names passed by socket activation, or
+// nil when LISTEN_PID is not pid (the variables were meant for another
+// process, such as a parent that did not unset them).
+func parseListenFDs(getenv func(string) string, pid int) ([]int, []string) {
+	if p, err := strconv.Atoi(getenv("LISTEN_PID")); err != nil || p != pid {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(getenv("LISTEN_FDNAMES"), ":")
+	fds := make([]int, n)
+	fdNames := make([]string, n)
+	for i := range fds {
+		fds[i] = listenFDsStart + i
+		if i < len(names) && len(names) == n {
+			fdNames[i] = names[i]
+		}
+	}
+	return fds, fdNames
+}
+
+// activatedFiles wraps the inherited fds once; wrapping an fd twice would
+// let the first *os.File's finalizer close it under the second.
+func activatedFiles() []*os.File {
+	activationOnce.Do(func() {
+		fds, names := parseListenFDs(os.Getenv, os.Getpid())
+		for i, fd := range fds {
+			name := names[i]
+			if name == "" {
+				name = "LISTEN_FD_" + strconv.Itoa(fd)
+			}
+			activationFiles = append(activationFiles, os.NewFile(uintptr(fd), name))
+		}
+		if len(fds) > 0 {
+			log.Info().Int("count", len(fds)).Strs("names", names).Msg("Using sockets passed by socket activation")
+		}
+	})
+	return activationFiles
+}
+
+// activationSet hands the inherited sockets out to the configured
+// listeners during one Start. Each set holds its own duplicates of the fds,
+// so shutting its servers down leaves the inherited sockets open.
+type activationSet struct {
+	listeners []net.Listener
+}
+
+func newActivationSet() *activationSet {
+	a := &activationSet{}
+	for _, f := range activatedFiles() {
+		ln, err := net.FileListener(f)
+		if err != nil {
+			log.Warn().Err(err).Str("fd", f.Name()).Msg("Ignoring activated fd that is not a listening socket")
+			continue
+		}
+		a.listeners = append(a.listeners, ln)
+	}
+	return a
+}
+
+// takeTCP returns the inherited TCP socket for l: one on l's port whose
+// address is l's, or any address when l listens on all of them.
+func (a *activationSet) takeTCP(l config.ListenerConfig) net.Listener {
+	return a.take(func(ln net.Listener) bool {
+		addr, ok := ln.Addr().(*net.TCPAddr)
+		if !ok || addr.Port != l.Port {
+			return false
+		}
+		switch l.Address {
+		case "", "0.0.0.0", "::":
+			return true
+		}
+		ip := net.ParseIP(l.Address)
+		return ip != nil && ip.Equal(addr.IP)
+	})
+}
+
+// takeUnix returns the inherited unix socket bound to socketPath.
+func (a *activationSet) takeUnix(socketPath string) net.Listener {
+	return a.take(func(ln net.Listener) bool {
+		addr, ok := ln.Addr().(*net.UnixAddr)
+		return ok && addr.Name == socketPath
+	})
+}
+
+func (a *activationSet) take(match func(net.Listener) bool) net.Listener {
+	for i, ln := range a.listeners {
+		if ln != nil && match(ln) {
+			a.listeners[i] = nil
+			return ln
+		}
+	}
+	return nil
+}
+
+// unclaimedTCP returns the inherited TCP sockets no listener took. The
+// socket unit owns those ports, so they are served as plain HTTP listeners
+// rather than left accepting connections nobody answers.
+func (a *activationSet) unclaimedTCP() []config.ListenerConfig {
+	var out []config.ListenerConfig
+	for _, ln := range a.listeners {
+		if ln == nil {
+			continue
+		}
+		if tcp, ok := ln.Addr().(*net.TCPAddr); ok {
+			out = append(out, config.ListenerConfig{Address: tcp.IP.String(), Port: tcp.Port})
+		}
+	}
+	return out
+}
+
+// close closes the duplicates nobody took.
+func (a *activationSet) close() {
+	for i, ln := range a.listeners {
+		if ln != nil {
+			ln.Close()
+			a.listeners[i] = nil
+		}
+	}
+}
+
+// listenTCP listens on addr, reusing the socket retained from an earlier
+// Start when there is one.
+func listenTCP(addr string) (net.Listener, error) {
+	retainedMu.Lock()
+	defer retainedMu.Unlock()
+	if f, ok := retainedFiles[addr]; ok {
+		if ln, err := net.FileListener(f); err == nil {
+			return ln, nil
+		}
+		f.Close()
+		delete(retainedFiles, addr)
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tcp, ok := ln.(*net.TCPListener); ok {
+		if f, err := tcp.File(); err == nil {
+			retainedFiles[addr] = f
+		}
+	}
+	return ln, nil
+}
+
+// unixRetainKey is the retainedFiles key of the unix socket at socketPath.
+func unixRetainKey(socketPath string) string {
+	return "unix:" + socketPath
+}
+
+// listenUnix listens on the unix socket at socketPath, reusing the socket
+// retained from an earlier Start when there is one. A stale socket file is
+// replaced; the file is only removed again by releaseRetained, not when a
+// server closes its listener.
+func listenUnix(socketPath string) (net.Listener, error) {
+	key := unixRetainKey(socketPath)
+	retainedMu.Lock()
+	defer retainedMu.Unlock()
+	if f, ok := retainedFiles[key]; ok {
+		if ln, err := net.FileListener(f); err == nil {
+			return ln, nil
+		}
+		f.Close()
+		delete(retainedFiles, key)
+	}
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove existing socket: %w", err)
+	}
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create unix socket: %w", err)
+	}
+	if unix, ok := ln.(*net.UnixListener); ok {
+		unix.SetUnlinkOnClose(false)
+		if f, err := unix.File(); err == nil {
+			retainedFiles[key] = f
+		}
+	}
+	return ln, nil
+}
+
+// releaseRetained closes the retained sockets whose key is not in keep,
+// freeing the ports (and removing the socket files) of listeners a restart
+// removed.
+func releaseRetained(keep []string) {
+	retainedMu.Lock()
+	defer retainedMu.Unlock()
+	for key, f := range retainedFiles {
+		if !slices.Contains(keep, key) {
+			f.Close()
+			delete(retainedFiles, key)
+			if socketPath, ok := strings.CutPrefix(key, "unix:"); ok {
+				os.Remove(socketPath)
+			}
+		}
+	}
+}
+
+// ReleaseListeners closes every socket retained for in-process restarts.
+// Call it once the last server has shut down and the process is exiting.
+func ReleaseListeners() {
+	releaseRetained(nil)
+}
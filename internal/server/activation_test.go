@@ -0,0 +1,179 @@
+package server
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+func TestParseListenFDs(t *testing.T) {
+	env := func(vars map[string]string) func(string) string {
+		return func(k string) string { return vars[k] }
+	}
+	fds, names := parseListenFDs(env(map[string]string{
+		"LISTEN_PID": "42", "LISTEN_FDS": "2", "LISTEN_FDNAMES": "http:https",
+	}), 42)
+	if !reflect.DeepEqual(fds, []int{3, 4}) || !reflect.DeepEqual(names, []string{"http", "https"}) {
+		t.Fatalf("fds=%v names=%v", fds, names)
+	}
+
+	// Names that do not line up with the fds are dropped.
+	_, names = parseListenFDs(env(map[string]string{"LISTEN_PID": "42", "LISTEN_FDS": "2", "LISTEN_FDNAMES": "http"}), 42)
+	if !reflect.DeepEqual(names, []string{"", ""}) {
+		t.Fatalf("names=%q", names)
+	}
+
+	for _, vars := range []map[string]string{
+		{},
+		{"LISTEN_PID": "41", "LISTEN_FDS": "1"},
+		{"LISTEN_PID": "42", "LISTEN_FDS": "0"},
+		{"LISTEN_PID": "42", "LISTEN_FDS": "x"},
+		{"LISTEN_FDS": "1"},
+	} {
+		if fds, _ := parseListenFDs(env(vars), 42); fds != nil {
+			t.Errorf("%v: fds=%v, want none", vars, fds)
+		}
+	}
+}
+
+func TestActivationSetMatchesListeners(t *testing.T) {
+	a, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	defer b.Close()
+	set := &activationSet{listeners: []net.Listener{a, b}}
+	portA := a.Addr().(*net.TCPAddr).Port
+	portB := b.Addr().(*net.TCPAddr).Port
+
+	if got := set.takeTCP(config.ListenerConfig{Address: "10.0.0.1", Port: portA}); got != nil {
+		t.Fatal("socket on another address was taken")
+	}
+	if got := set.takeTCP(config.ListenerConfig{Address: "0.0.0.0", Port: portA}); got != a {
+		t.Fatalf("all-address listener got %v, want the socket on its port", got)
+	}
+	if got := set.takeTCP(config.ListenerConfig{Address: "0.0.0.0", Port: portA}); got != nil {
+		t.Fatal("socket handed out twice")
+	}
+	if got := set.takeUnix("/run/enc.sock"); got != nil {
+		t.Fatal("TCP socket taken as unix socket")
+	}
+	rest := set.unclaimedTCP()
+	if len(rest) != 1 || rest[0].Port != portB || rest[0].Address != "127.0.0.1" {
+		t.Fatalf("unclaimed=%+v", rest)
+	}
+	if got := set.takeTCP(rest[0]); got != b {
+		t.Fatal("unclaimed socket does not match its own listener config")
+	}
+}
+
+func TestNewListenServerServesActivatedSocket(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.GET("/ping", func(c *gin.Context) { c.String(http.StatusOK, "pong") })
+	s := &Server{cfg: config.DefaultConfig(), engine: engine}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The configured port is never bound; the inherited socket is used.
+	srv, run, err := s.newListenServer(config.ListenerConfig{Address: "127.0.0.1", Port: 1}, ln)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go run()
+	defer srv.Close()
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/ping")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "pong" {
+		t.Fatalf("status=%d body=%q", resp.StatusCode, body)
+	}
+}
+
+func TestListenTCPKeepsSocketAcrossRestart(t *testing.T) {
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := probe.Addr().String()
+	probe.Close()
+
+	first, err := listenTCP(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseRetained(nil)
+	first.Close()
+
+	// The old server is gone, but the port still accepts; the connection
+	// waits in the backlog for the next server.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("port refused while no server runs: %v", err)
+	}
+	defer conn.Close()
+
+	second, err := listenTCP(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	accepted, err := second.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted.Close()
+
+	second.Close()
+	releaseRetained(nil)
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Fatal("released port still accepts")
+	}
+}
+
+func TestListenUnixKeepsSocketAcrossRestart(t *testing.T) {
+	socketPath := filepath.Join(t.TempDir(), "proxy.sock")
+	first, err := listenUnix(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer releaseRetained(nil)
+
+	second, err := listenUnix(socketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	// The old server stopping must not unlink the socket the new one uses.
+	first.Close()
+	conn, err := net.Dial("unix", socketPath)
+	if err != nil {
+		t.Fatalf("socket gone after the old listener closed: %v", err)
+	}
+	conn.Close()
+
+	second.Close()
+	releaseRetained(nil)
+	if _, err := os.Stat(socketPath); !os.IsNotExist(err) {
+		t.Fatalf("released socket file still exists: %v", err)
+	}
+}
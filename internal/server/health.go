@@ -2,23 +2,40 @@ package server
 
 import (
 	"net/http"
+	"os"
 	"runtime"
+	"sync/atomic"
 	"time"
 
-	"github.com/gin-gonic/gin"
 	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/handler"
+	"github.com/gin-gonic/gin"
 )
 
 var startTime = time.Now()
 
+// storageDegraded holds the reason BoltDB runs on a temporary copy, if any.
+var storageDegraded atomic.Value
+
+func setStorageDegraded(reason string) {
+	storageDegraded.Store(reason)
+}
+
+func storageDegradedReason() string {
+	reason, _ := storageDegraded.Load().(string)
+	return reason
+}
+
 // HealthResponse represents the health check response
 type HealthResponse struct {
-	Status    string `json:"status"`
-	Version   string `json:"version"`
-	Uptime    string `json:"uptime"`
-	GoVersion string `json:"go_version"`
-	NumGoroutine int  `json:"num_goroutine"`
-	MemAlloc  uint64 `json:"mem_alloc_mb"`
+	Status       string `json:"status"`
+	Version      string `json:"version"`
+	Uptime       string `json:"uptime"`
+	GoVersion    string `json:"go_version"`
+	NumGoroutine int    `json:"num_goroutine"`
+	MemAlloc     uint64 `json:"mem_alloc_mb"`
+	NumFD        int    `json:"num_fd,omitempty"`
+	Storage      string `json:"storage_degraded,omitempty"`
 }
 
 // HealthHandler returns server health status
@@ -33,12 +50,38 @@ func HealthHandler(c *gin.Context) {
 		GoVersion:    runtime.Version(),
 		NumGoroutine: runtime.NumGoroutine(),
 		MemAlloc:     m.Alloc / 1024 / 1024, // MB
+		NumFD:        openFDs(),
+	}
+	if reason := storageDegradedReason(); reason != "" {
+		resp.Status = "degraded"
+		resp.Storage = reason
 	}
 
 	c.JSON(http.StatusOK, resp)
 }
 
-// ReadyHandler returns whether the service is ready to accept traffic
-func ReadyHandler(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"status": "ready"})
+// openFDs returns how many file descriptors the process holds, or 0 where
+// /proc is not available. Soak tests watch it for leaked connections.
+func openFDs() int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0
+	}
+	return len(entries)
+}
+
+// ReadyHandler returns whether the service is ready to accept traffic. The
+// proxy itself is ready once it serves; the upstream probe results are added
+// as detail so orchestrators and dashboards can see a slow or unreachable
+// Alist without the proxy being taken out of rotation.
+func ReadyHandler(upstreams *handler.UpstreamStatus) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		resp := gin.H{"status": "ready"}
+		if summaries := upstreams.Summaries(false); len(summaries) > 0 {
+			upstreamsUp, detail := upstreams.Ready()
+			resp["upstreams_up"] = upstreamsUp
+			resp["detail"] = gin.H{"upstreams": detail}
+		}
+		c.JSON(http.StatusOK, resp)
+	}
 }
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+func TestNewHTTP2ServerDefaults(t *testing.T) {
+	h2s := newHTTP2Server(config.DefaultConfig())
+	if h2s.MaxConcurrentStreams != 1000 {
+		t.Fatalf("MaxConcurrentStreams=%d, want 1000", h2s.MaxConcurrentStreams)
+	}
+	if h2s.MaxUploadBufferPerConnection != 0 || h2s.MaxUploadBufferPerStream != 0 {
+		t.Fatalf("expected library default windows, got conn=%d stream=%d", h2s.MaxUploadBufferPerConnection, h2s.MaxUploadBufferPerStream)
+	}
+}
+
+func TestNewHTTP2ServerAppliesTuning(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.HTTP2.MaxConcurrentStreams = 250
+	cfg.HTTP2.MaxReadFrameSize = 256 << 10
+	cfg.HTTP2.ServerConnWindowKb = 64 * 1024
+	cfg.HTTP2.ServerStreamWindowKb = 8 * 1024
+
+	h2s := newHTTP2Server(cfg)
+	if h2s.MaxConcurrentStreams != 250 {
+		t.Fatalf("MaxConcurrentStreams=%d", h2s.MaxConcurrentStreams)
+	}
+	if h2s.MaxReadFrameSize != 256<<10 {
+		t.Fatalf("MaxReadFrameSize=%d", h2s.MaxReadFrameSize)
+	}
+	if h2s.MaxUploadBufferPerConnection != 64<<20 {
+		t.Fatalf("conn window=%d", h2s.MaxUploadBufferPerConnection)
+	}
+	if h2s.MaxUploadBufferPerStream != 8<<20 {
+		t.Fatalf("stream window=%d", h2s.MaxUploadBufferPerStream)
+	}
+}
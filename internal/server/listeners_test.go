@@ -0,0 +1,37 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+func TestNewListenServerLimitsPaths(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	engine := gin.New()
+	engine.Any("/*path", func(c *gin.Context) { c.Status(http.StatusOK) })
+	s := &Server{cfg: config.DefaultConfig(), engine: engine}
+
+	srv, _, err := s.newListenServer(config.ListenerConfig{Address: "0.0.0.0", Port: 5345, Paths: []string{"/dav"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if srv.Addr != "0.0.0.0:5345" {
+		t.Fatalf("addr=%q", srv.Addr)
+	}
+	for path, want := range map[string]int{"/dav/movies/a.mkv": http.StatusOK, "/enc-api/login": http.StatusNotFound, "/": http.StatusNotFound} {
+		rr := httptest.NewRecorder()
+		srv.Handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		if rr.Code != want {
+			t.Errorf("%s: status=%d, want %d", path, rr.Code, want)
+		}
+	}
+
+	if _, _, err := s.newListenServer(config.ListenerConfig{Port: 5346, TLS: true}, nil); err == nil {
+		t.Fatal("TLS listener without a certificate should fail")
+	}
+}
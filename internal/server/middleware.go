@@ -1,16 +1,31 @@
 package server
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"path"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
 
 	"github.com/alist-encrypt-go/internal/auth"
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/errors"
+	"github.com/alist-encrypt-go/internal/geoip"
+	"github.com/alist-encrypt-go/internal/handler"
+	"github.com/alist-encrypt-go/internal/httputil"
+	"github.com/alist-encrypt-go/internal/i18n"
+	"github.com/alist-encrypt-go/internal/pathutil"
+	"github.com/alist-encrypt-go/internal/proxy"
 	"github.com/alist-encrypt-go/internal/trace"
+	"github.com/alist-encrypt-go/internal/workers"
 )
 
 // TraceMiddleware adds request tracing context to each request
@@ -21,6 +36,7 @@ func TraceMiddleware() gin.HandlerFunc {
 
 		ctx := trace.WithRequestID(c.Request.Context(), reqID)
 		ctx = trace.WithPathTag(ctx, pathTag)
+		ctx = trace.WithClientIP(ctx, c.ClientIP())
 		c.Request = c.Request.WithContext(ctx)
 
 		c.Header("X-Request-ID", reqID)
@@ -28,23 +44,466 @@ func TraceMiddleware() gin.HandlerFunc {
 	}
 }
 
-// LoggerMiddleware logs HTTP requests using the new trace format
-func LoggerMiddleware() gin.HandlerFunc {
+// passwdMatcher is the part of the passwd DAO the chain middleware needs.
+type passwdMatcher interface {
+	PathFindPasswd(urlPath string) (*config.PasswdInfo, bool)
+	MatchDir(dirPath string) bool
+}
+
+// ChainMiddleware handles the headers chained alist-encrypt instances
+// exchange (see proxy.InstanceHeader):
+//
+//   - a request carrying our own instance ID is a loop, i.e. alistServer leads
+//     back to this proxy (often through a reverse proxy or DNS name the
+//     startup check cannot see); it is answered 508 instead of fanning out
+//     into itself until sockets run out,
+//   - a request that already passed maxHops instances is answered 508 too,
+//   - a storage request whose path an earlier instance encrypts is passed
+//     through to Alist untouched, so files are not encrypted twice,
+//   - otherwise the chain is kept in the request context, marked as owned
+//     when passwdList matches here, for the transport to extend.
+func ChainMiddleware(cfg *config.Config, passwd passwdMatcher, passThrough http.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		chain := proxy.ChainFrom(c.Request)
+		if chain.Looped() {
+			log.Error().
+				Str("path", c.Request.URL.Path).
+				Str("remote", c.ClientIP()).
+				Msg("Proxy loop detected: alistServer points back at this proxy; set serverHost/serverPort to the Alist server itself")
+			c.AbortWithStatusJSON(http.StatusLoopDetected, gin.H{
+				"code": http.StatusLoopDetected,
+				"msg":  "proxy loop detected: alistServer points back at alist-encrypt",
+			})
+			return
+		}
+		if maxHops := cfg.AlistServer.MaxHops; maxHops > 0 && chain.Hops >= maxHops {
+			log.Error().
+				Str("path", c.Request.URL.Path).
+				Int("hops", chain.Hops).
+				Int("max_hops", maxHops).
+				Msg("Request passed too many alist-encrypt instances")
+			c.AbortWithStatusJSON(http.StatusLoopDetected, gin.H{
+				"code": http.StatusLoopDetected,
+				"msg":  "too many chained alist-encrypt instances",
+			})
+			return
+		}
+		proxy.StripChainHeaders(c.Request.Header)
+
+		p, _ := storageRequestPath(c.Request)
+		if chain.Owned && p != "" && passThrough != nil {
+			c.Request = c.Request.WithContext(proxy.WithChain(c.Request.Context(), chain))
+			passThrough(c.Writer, c.Request)
+			c.Abort()
+			return
+		}
+		chain.Owned = p != "" && passwd != nil && ownsPath(passwd, p)
+		c.Request = c.Request.WithContext(proxy.WithChain(c.Request.Context(), chain))
+		c.Next()
+	}
+}
+
+// ownsPath reports whether passwdList encrypts p, as a file or a folder.
+func ownsPath(passwd passwdMatcher, p string) bool {
+	if _, ok := passwd.PathFindPasswd(p); ok {
+		return true
+	}
+	return passwd.MatchDir(p)
+}
+
+// StorageBudgetMiddleware charges client requests for downloads, WebDAV and
+// the Alist fs API against the budget of the storage they touch. Requests
+// that wait too long are answered with 429 before reaching any handler.
+func StorageBudgetMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !workers.HasBudgets() {
+			c.Next()
+			return
+		}
+		p, api := storageRequestPath(c.Request)
+		if p != "" && !handler.AdmitStorageRequest(c.Writer, c.Request, p, api) {
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// storageRequestPath returns the display path a request works on and
+// whether it is an Alist JSON API call. fs API bodies are small JSON
+// objects; they are read here and put back for the handler.
+func storageRequestPath(r *http.Request) (string, bool) {
+	p := r.URL.Path
+	switch {
+	case strings.HasPrefix(p, "/d/"), strings.HasPrefix(p, "/p/"), strings.HasPrefix(p, "/dav/"):
+		displayPath, _ := pathutil.StripRoute(p)
+		return displayPath.String(), false
+	case p == "/api/fs/put" || p == "/api/fs/form":
+		if fp, err := pathutil.UnescapeFilePath(r.Header.Get("File-Path")); err == nil {
+			return fp, true
+		}
+		return "", true
+	case !strings.HasPrefix(p, "/api/fs/") || r.Body == nil:
+		return "", false
+	}
+	if paths := fsBodyPaths(r); len(paths) > 0 {
+		return paths[0], true
+	}
+	return "", true
+}
+
+// fsBodyPaths returns the storage paths named in an fs API body: path,
+// dir, src_dir, parent and dst_dir, in that order. The body is put back for
+// the handler.
+func fsBodyPaths(r *http.Request) []string {
+	if r.Body == nil {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	if err != nil {
+		return nil
+	}
+	var fields struct {
+		Path   string `json:"path"`
+		Dir    string `json:"dir"`
+		SrcDir string `json:"src_dir"`
+		Parent string `json:"parent"`
+		DstDir string `json:"dst_dir"`
+	}
+	_ = json.Unmarshal(body, &fields)
+	var paths []string
+	for _, v := range []string{fields.Path, fields.Dir, fields.SrcDir, fields.Parent, fields.DstDir} {
+		if v != "" {
+			paths = append(paths, v)
+		}
+	}
+	return paths
+}
+
+// listCacheInvalidator is the part of the Alist handler that mutating
+// passthrough requests need.
+type listCacheInvalidator interface {
+	InvalidateListCache(dirs ...string)
+}
+
+// ListCacheInvalidationMiddleware clears cached fs/list responses after any
+// request that may change a directory but is not handled by one of the
+// dedicated fs handlers (mkdir, batch_rename, WebDAV PUT/DELETE/MOVE, ...).
+func ListCacheInvalidationMiddleware(cache listCacheInvalidator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if cache != nil && mutatesListings(c.Request) {
+			cache.InvalidateListCache()
+		}
+	}
+}
+
+func mutatesListings(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+		return false
+	}
+	p := r.URL.Path
+	if p == "/dav" || strings.HasPrefix(p, "/dav/") {
+		return true
+	}
+	if !strings.HasPrefix(p, "/api/fs/") {
+		return false
+	}
+	switch p {
+	case "/api/fs/list", "/api/fs/get", "/api/fs/link", "/api/fs/search", "/api/fs/dirs", "/api/fs/other":
+		return false
+	}
+	return true
+}
+
+// debugRecorderWriter routes gin's writes through the recorder's capturing
+// writer while keeping gin's status/size bookkeeping.
+type debugRecorderWriter struct {
+	gin.ResponseWriter
+	capture http.ResponseWriter
+}
+
+func (w *debugRecorderWriter) WriteHeader(status int) { w.capture.WriteHeader(status) }
+
+func (w *debugRecorderWriter) Write(p []byte) (int, error) { return w.capture.Write(p) }
+
+func (w *debugRecorderWriter) WriteString(s string) (int, error) { return w.capture.Write([]byte(s)) }
+
+// AuditMiddleware records file mutations (WebDAV DELETE/MOVE/COPY/PUT/MKCOL,
+// Alist fs uploads, removes, renames, moves, copies and mkdirs, patch uploads
+// and re-encryption/ingest jobs) in the audit log with the status the proxy answered. The actor is
+// taken before the handler runs, since WebDAV user mapping rewrites the Basic
+// auth credentials.
+func AuditMiddleware(audit *handler.AuditLog) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		action := auditAction(c.Request)
+		if audit == nil || action == "" {
+			c.Next()
+			return
+		}
+		actor := handler.AuditActor(c.Request)
+		paths, target := auditPaths(c.Request)
+		c.Next()
+		if login := trace.GetLoginUser(c.Request.Context()); login != "" {
+			actor = login
+		}
+		if len(paths) == 0 {
+			paths = []string{""}
+		}
+		for _, p := range paths {
+			audit.Record(handler.AuditEntry{
+				Actor:     actor,
+				Action:    action,
+				Path:      p,
+				Target:    target,
+				Status:    c.Writer.Status(),
+				RemoteIP:  c.ClientIP(),
+				RequestID: trace.GetRequestID(c.Request.Context()),
+			})
+		}
+	}
+}
+
+// auditAction names the file mutation r performs, or "" if it is not one.
+func auditAction(r *http.Request) string {
+	p := r.URL.Path
+	if p == "/dav" || strings.HasPrefix(p, "/dav/") {
+		switch r.Method {
+		case http.MethodDelete, "MOVE", "COPY", http.MethodPut, "MKCOL":
+			return "webdav." + strings.ToLower(r.Method)
+		}
+		return ""
+	}
+	switch {
+	case p == "/enc-api/patch" && r.Method == http.MethodPut:
+		return "file.patch"
+	case p == "/enc-api/reencrypt/start" && r.Method == http.MethodPost:
+		return "job.reencrypt"
+	case p == "/enc-api/ingest" && r.Method == http.MethodPost:
+		return "job.ingest"
+	case p == "/enc-api/jobs/override" && r.Method == http.MethodPost:
+		return "job.override"
+	}
+	switch p {
+	case "/api/fs/put", "/api/fs/form":
+		return "fs.put"
+	case "/api/fs/remove", "/api/fs/rename", "/api/fs/move", "/api/fs/copy", "/api/fs/mkdir":
+		return "fs." + strings.TrimPrefix(p, "/api/fs/")
+	}
+	return ""
+}
+
+// auditPaths returns the display paths an audited request acts on and the
+// destination of a move, copy or rename. fs API bodies are read here and put
+// back for the handler, like storageRequestPath does.
+func auditPaths(r *http.Request) ([]string, string) {
+	p := r.URL.Path
+	switch {
+	case p == "/dav" || strings.HasPrefix(p, "/dav/"):
+		var target string
+		if dest := r.Header.Get("Destination"); dest != "" {
+			if u, err := url.Parse(dest); err == nil {
+				if destPath, ok := pathutil.StripRoute(u.Path); ok {
+					target = destPath.String()
+				}
+			}
+		}
+		displayPath, _ := pathutil.StripRoute(p)
+		return []string{displayPath.String()}, target
+	case p == "/enc-api/patch":
+		return []string{r.URL.Query().Get("path")}, ""
+	case p == "/api/fs/put" || p == "/api/fs/form":
+		fp, _ := pathutil.UnescapeFilePath(r.Header.Get("File-Path"))
+		return []string{fp}, ""
+	case r.Body == nil:
+		return nil, ""
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	if err != nil {
+		return nil, ""
+	}
+	var fields struct {
+		Path   string   `json:"path"`
+		Name   string   `json:"name"`
+		Dir    string   `json:"dir"`
+		SrcDir string   `json:"src_dir"`
+		DstDir string   `json:"dst_dir"`
+		Names  []string `json:"names"`
+	}
+	_ = json.Unmarshal(body, &fields)
+	switch {
+	case fields.Path != "":
+		target := ""
+		if p == "/api/fs/rename" && fields.Name != "" {
+			target = path.Join(path.Dir(fields.Path), fields.Name)
+		}
+		return []string{fields.Path}, target
+	case len(fields.Names) > 0:
+		dir := fields.Dir
+		if dir == "" {
+			dir = fields.SrcDir
+		}
+		paths := make([]string, 0, len(fields.Names))
+		for _, name := range fields.Names {
+			paths = append(paths, path.Join(dir, name))
+		}
+		return paths, fields.DstDir
+	}
+	return nil, ""
+}
+
+// DebugRecorderMiddleware captures requests matching an active debug
+// recording started via /enc-api/debugRecorder/start.
+func DebugRecorderMiddleware(rec *handler.DebugRecorder) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w, r, finish := rec.Wrap(c.Writer, c.Request)
+		if w == http.ResponseWriter(c.Writer) {
+			c.Next()
+			return
+		}
+		c.Writer = &debugRecorderWriter{ResponseWriter: c.Writer, capture: w}
+		c.Request = r
+		c.Next()
+		finish(c.Writer.Status())
+	}
+}
+
+// ForwardedUserMiddleware attributes requests to the user named in
+// forwardedUserHeader (set by an authenticating reverse proxy) and applies
+// forwardedUserPaths to every route that serves or changes file content.
+// RealIPMiddleware drops the header from clients that are not trusted
+// proxies, so only the reverse proxy can name a user.
+func ForwardedUserMiddleware(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := cfg.AlistServer.ForwardedUserHeader
+		if header == "" {
+			c.Next()
+			return
+		}
+		user := strings.TrimSpace(c.GetHeader(header))
+		if user != "" {
+			c.Request = c.Request.WithContext(trace.WithUser(c.Request.Context(), user))
+		}
+		if rules := cfg.AlistServer.ForwardedUserPaths; len(rules) > 0 {
+			targets, listing := forwardedUserTargets(c.Request)
+			if !forwardedUserAllowed(rules, user, listing, targets) {
+				log.Warn().
+					Str("user", user).
+					Str("path", c.Request.URL.Path).
+					Msg("forwarded-user denied")
+				handler.RespondCodedError(c.Writer, errors.CodeForbidden, "path not allowed for this user", http.StatusForbidden)
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// forwardedUserTargets returns the storage paths a request reads or
+// changes, and whether it only lists them: /d, /p, /dav and /img paths, and
+// every path named by an Alist fs API call (fs/get hands out raw_url, so it
+// counts as a read). Other routes touch no storage path.
+func forwardedUserTargets(r *http.Request) ([]string, bool) {
+	p := r.URL.Path
+	if displayPath, ok := pathutil.StripRoute(p); ok {
+		return []string{displayPath.String()}, r.Method == "PROPFIND" || r.Method == http.MethodOptions
+	}
+	switch {
+	case p == "/img" || strings.HasPrefix(p, "/img/"):
+		return []string{pathutil.CleanInput(strings.TrimPrefix(p, "/img"))}, false
+	case p == "/api/fs/put" || p == "/api/fs/form":
+		fp, err := pathutil.UnescapeFilePath(r.Header.Get("File-Path"))
+		if err != nil {
+			fp = ""
+		}
+		// An unreadable File-Path is checked as the root, which a
+		// restricted user may not write to.
+		return []string{pathutil.CleanInput(fp)}, false
+	case strings.HasPrefix(p, "/api/fs/"):
+		paths := fsBodyPaths(r)
+		if len(paths) == 0 {
+			// fs calls without a path act on the root.
+			paths = []string{"/"}
+		}
+		return paths, p == "/api/fs/list" || p == "/api/fs/dirs"
+	}
+	return nil, false
+}
+
+// forwardedUserAllowed reports whether user may access every target. Users
+// without a rule (and no "*" rule) are unrestricted.
+func forwardedUserAllowed(rules []config.ForwardedUserPaths, user string, listing bool, targets []string) bool {
+	if len(rules) == 0 || len(targets) == 0 {
+		return true
+	}
+	var rule *config.ForwardedUserPaths
+	for i := range rules {
+		if user != "" && rules[i].Username == user {
+			rule = &rules[i]
+			break
+		}
+		if rules[i].Username == "*" && rule == nil {
+			rule = &rules[i]
+		}
+	}
+	if rule == nil {
+		return true
+	}
+	for _, target := range targets {
+		if !forwardedUserPathAllowed(rule.AllowPaths, listing, pathutil.CleanInput(target)) {
+			return false
+		}
+	}
+	return true
+}
+
+func forwardedUserPathAllowed(allowPaths []string, listing bool, target string) bool {
+	// Ancestors of an allowed prefix stay listable so WebDAV clients and
+	// the file browser can navigate down to it.
+	for _, allowed := range allowPaths {
+		if pathutil.Within(target, allowed) {
+			return true
+		}
+		if listing && (target == "/" || strings.HasPrefix(allowed, target+"/")) {
+			return true
+		}
+	}
+	return false
+}
+
+// LoggerMiddleware logs HTTP requests using the new trace format. When geo is
+// non-nil, public client addresses are tagged with country / ASN.
+func LoggerMiddleware(geo *geoip.Resolver) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		start := time.Now()
 
 		// Process request
 		c.Next()
 
-		reqID := trace.GetRequestID(c.Request.Context())
-		pathTag := trace.GetPathTag(c.Request.Context())
 		duration := time.Since(start)
+		userSuffix := ""
+		if user := trace.GetUser(c.Request.Context()); user != "" {
+			userSuffix = fmt.Sprintf(" user=%q", user)
+		}
 
-		// Use new format: [timestamp] [req-xxx] [path_tag] [request] details
-		ts := time.Now().Format("2006-01-02T15:04:05")
-		fmt.Printf("%s [%s] [%s] [request] %s %s status=%d bytes=%d duration=%v\n",
-			ts, reqID, pathTag, c.Request.Method, c.Request.URL.Path,
-			c.Writer.Status(), c.Writer.Size(), duration)
+		// Use new format: [timestamp] [req-xxx] [path_tag] [request] details.
+		// trace.Log also keeps the line with the request's trace.
+		ctx := c.Request.Context()
+		if geo != nil {
+			if info := geo.Lookup(c.ClientIP()); !info.Empty() {
+				trace.Logf(ctx, "request", "%s %s status=%d bytes=%d duration=%v client=%s %s%s",
+					c.Request.Method, c.Request.URL.Path,
+					c.Writer.Status(), c.Writer.Size(), duration, c.ClientIP(), info, userSuffix)
+				return
+			}
+		}
+		trace.Logf(ctx, "request", "%s %s status=%d bytes=%d duration=%v%s",
+			c.Request.Method, c.Request.URL.Path,
+			c.Writer.Status(), c.Writer.Size(), duration, userSuffix)
 	}
 }
 
@@ -65,7 +524,7 @@ func CORSMiddleware() gin.HandlerFunc {
 		}
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS, PROPFIND, PROPPATCH, MKCOL, COPY, MOVE, LOCK, UNLOCK")
 		c.Header("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, Authorization, X-CSRF-Token, Depth, Destination, Overwrite, File-Path, Authorizetoken, AUTHORIZETOKEN")
-		c.Header("Access-Control-Expose-Headers", "Content-Length, Content-Range, Content-Disposition")
+		c.Header("Access-Control-Expose-Headers", "Content-Length, Content-Range, Content-Disposition, X-Enc-Error, X-Resume-Token")
 
 		if c.Request.Method == "OPTIONS" && !strings.HasPrefix(c.Request.URL.Path, "/dav") {
 			c.AbortWithStatus(http.StatusOK)
@@ -88,15 +547,23 @@ func normalizeOriginHost(hostport string) string {
 	return strings.Trim(strings.ToLower(strings.TrimSpace(hostport)), "[]")
 }
 
-// ForceHTTPSMiddleware redirects HTTP to HTTPS
-func ForceHTTPSMiddleware(httpsPort int) gin.HandlerFunc {
+// ForceHTTPSMiddleware redirects HTTP to HTTPS while scheme.force_https is
+// set and HTTPS is enabled; the setting is read per request so toggling it
+// needs no restart.
+func ForceHTTPSMiddleware(cfg *config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if c.Request.TLS == nil && c.GetHeader("X-Forwarded-Proto") != "https" {
+		scheme := cfg.Scheme
+		if scheme == nil || !scheme.ForceHTTPS || !cfg.IsHTTPSEnabled() {
+			c.Next()
+			return
+		}
+		httpsPort := scheme.HTTPSPort
+		if httputil.ForwardedProto(c.Request) != "https" {
 			host := c.Request.Host
 			if httpsPort != 443 {
 				host = fmt.Sprintf("%s:%d", c.Request.Host, httpsPort)
 			}
-			target := fmt.Sprintf("https://%s%s", host, c.Request.URL.RequestURI())
+			target := fmt.Sprintf("https://%s%s%s", host, httputil.ForwardedPrefix(c.Request), c.Request.URL.RequestURI())
 			c.Redirect(http.StatusMovedPermanently, target)
 			c.Abort()
 			return
@@ -105,27 +572,36 @@ func ForceHTTPSMiddleware(httpsPort int) gin.HandlerFunc {
 	}
 }
 
-// AuthMiddleware validates JWT tokens
-func AuthMiddleware(jwtSecret string, expireHours int) gin.HandlerFunc {
+// ClientCertMiddleware rejects HTTPS requests that carry no verified client
+// certificate while scheme.client_ca_file is set, except under
+// scheme.client_auth_exempt. The TLS layer already refused certificates
+// that do not chain to the CA. Plain HTTP requests are not checked, nor is
+// anything until the HTTPS listener was started with the CA (enabled).
+func ClientCertMiddleware(cfg *config.Config, enabled *atomic.Bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		state := c.Request.TLS
+		if state == nil || !enabled.Load() || len(state.VerifiedChains) > 0 || !cfg.Scheme.ClientCertRequired(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+		handler.RespondCodedError(c.Writer, errors.CodeClientCertRequired, "client certificate required", http.StatusForbidden)
+		c.Abort()
+	}
+}
+
+// tokenRevocations reports management tokens revoked before they expire.
+type tokenRevocations interface {
+	Revoked(id, username string, issuedAt time.Time) bool
+}
+
+// AuthMiddleware validates JWT tokens: the signature, expiry, and that the
+// token was not revoked by logout or a password change.
+func AuthMiddleware(jwtSecret string, expireHours int, revoked tokenRevocations) gin.HandlerFunc {
 	if expireHours <= 0 {
 		expireHours = 48
 	}
 	jwtAuth := auth.NewJWTAuth(jwtSecret, time.Duration(expireHours)*time.Hour)
 
-	extractToken := func(c *gin.Context) string {
-		if token := strings.TrimSpace(c.GetHeader("Authorizetoken")); token != "" {
-			return token
-		}
-		if authz := strings.TrimSpace(c.GetHeader("Authorization")); authz != "" {
-			if len(authz) >= 7 && strings.EqualFold(authz[:7], "Bearer ") {
-				return strings.TrimSpace(authz[7:])
-			}
-			return authz
-		}
-		// Query parameter tokens removed for security — URLs leak into logs, browser history, and referrer headers
-		return ""
-	}
-
 	return func(c *gin.Context) {
 		// Skip auth for login endpoint
 		if c.Request.URL.Path == "/enc-api/login" {
@@ -133,22 +609,85 @@ func AuthMiddleware(jwtSecret string, expireHours int) gin.HandlerFunc {
 			return
 		}
 
-		token := extractToken(c)
+		token := auth.TokenFromRequest(c.Request)
 
+		unlogin := i18n.Localize(c.Writer, "user unlogin")
 		if token == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": "user unlogin"})
+			c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": unlogin})
 			c.Abort()
 			return
 		}
 
-		if _, err := jwtAuth.ValidateToken(token); err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": "user unlogin"})
+		claims, err := jwtAuth.ValidateToken(token)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": unlogin})
 			c.Abort()
 			return
 		}
+		if revoked != nil {
+			if revoked.Revoked(claims.ID, claims.Username, claims.IssuedAtTime()) {
+				c.JSON(http.StatusUnauthorized, gin.H{"code": 401, "msg": unlogin})
+				c.Abort()
+				return
+			}
+		}
 
 		// Store token in Gin context without mutating request headers that may be proxied upstream.
 		c.Set("user_token", token)
+		c.Request = c.Request.WithContext(trace.WithLoginUser(c.Request.Context(), claims.Username))
+		c.Next()
+	}
+}
+
+// roleLookup resolves a logged-in user's role.
+type roleLookup interface {
+	Role(username string) string
+}
+
+// RequireRole admits logged-in users whose current role is one of roles and
+// answers 403 otherwise. The role is read from the user store on every
+// request rather than from the token, so a role change applies at once.
+// Installed after AuthMiddleware.
+func RequireRole(users roleLookup, roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := users.Role(trace.GetLoginUser(c.Request.Context()))
+		for _, allowed := range roles {
+			if role != "" && role == allowed {
+				c.Next()
+				return
+			}
+		}
+		c.JSON(http.StatusForbidden, gin.H{"code": 403, "msg": i18n.Localize(c.Writer, "permission denied")})
+		c.Abort()
+	}
+}
+
+// localePreferences resolves a logged-in user's stored locale.
+type localePreferences interface {
+	Locale(username string) string
+}
+
+// LocaleMiddleware negotiates the language of /enc-api messages from
+// Accept-Language and records it in Content-Language, where the JSON
+// response helpers pick it up. Installed after AuthMiddleware with prefs, the
+// user's saved locale wins over the header. Without a supported language the
+// legacy messages are sent unchanged.
+func LocaleMiddleware(prefs localePreferences) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		lang := i18n.Negotiate(c.GetHeader("Accept-Language"))
+		if prefs != nil {
+			if user := trace.GetLoginUser(c.Request.Context()); user != "" {
+				if saved := i18n.Normalize(prefs.Locale(user)); saved != "" {
+					lang = saved
+				}
+			}
+		}
+		if prefs == nil {
+			c.Writer.Header().Add("Vary", "Accept-Language")
+		}
+		if lang != "" {
+			c.Writer.Header().Set(i18n.HeaderContentLanguage, lang)
+		}
 		c.Next()
 	}
 }
@@ -1,12 +1,27 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/alist-encrypt-go/internal/auth"
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/dao"
+	"github.com/alist-encrypt-go/internal/handler"
+	"github.com/alist-encrypt-go/internal/proxy"
+	"github.com/alist-encrypt-go/internal/storage"
+	"github.com/alist-encrypt-go/internal/trace"
+	"github.com/alist-encrypt-go/internal/workers"
 	"github.com/gin-gonic/gin"
 )
 
@@ -106,7 +121,7 @@ func TestAuthMiddlewareStoresTokenWithoutMutatingRequestHeaders(t *testing.T) {
 	}
 
 	r := gin.New()
-	r.Use(AuthMiddleware(secret, 48))
+	r.Use(AuthMiddleware(secret, 48, nil))
 	r.GET("/enc-api/getStats", func(c *gin.Context) {
 		if got := c.Request.Header.Get("X-User-Token"); got != "" {
 			t.Fatalf("X-User-Token header=%q, want empty", got)
@@ -126,3 +141,521 @@ func TestAuthMiddlewareStoresTokenWithoutMutatingRequestHeaders(t *testing.T) {
 		t.Fatalf("status=%d, want %d", rr.Code, http.StatusNoContent)
 	}
 }
+
+func TestForwardedUserMiddlewareAttributesAndRestricts(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{AlistServer: config.AlistServer{
+		ForwardedUserHeader: "X-Forwarded-User",
+		ForwardedUserPaths: []config.ForwardedUserPaths{
+			{Username: "kid", AllowPaths: []string{"/media/cartoons"}},
+			{Username: "*", AllowPaths: []string{"/public"}},
+		},
+	}}
+	var seenUser string
+	r := gin.New()
+	r.Use(ForwardedUserMiddleware(cfg))
+	ok := func(c *gin.Context) {
+		seenUser = trace.GetUser(c.Request.Context())
+		c.Status(http.StatusOK)
+	}
+	r.Any("/*path", ok)
+	r.Handle("PROPFIND", "/*path", ok)
+
+	cases := []struct {
+		method, path, user, body string
+		want                     int
+	}{
+		{http.MethodGet, "/d/media/cartoons/a.mkv", "kid", "", http.StatusOK},
+		{http.MethodGet, "/d/media/movies/b.mkv", "kid", "", http.StatusForbidden},
+		{"PROPFIND", "/dav/media", "kid", "", http.StatusOK},
+		{http.MethodGet, "/dav/media", "kid", "", http.StatusForbidden},
+		{http.MethodGet, "/d/public/x", "", "", http.StatusOK},
+		{http.MethodGet, "/d/media/cartoons/a.mkv", "", "", http.StatusForbidden},
+		{http.MethodPost, "/api/fs/list", "kid", "", http.StatusOK},
+		{http.MethodPost, "/api/fs/list", "kid", `{"path":"/media"}`, http.StatusOK},
+		{http.MethodPost, "/api/fs/get", "kid", `{"path":"/media/movies/b.mkv"}`, http.StatusForbidden},
+		{http.MethodPost, "/api/fs/get", "kid", `{"path":"/media/cartoons/a.mkv"}`, http.StatusOK},
+		{http.MethodPost, "/api/fs/move", "kid", `{"src_dir":"/media/cartoons","dst_dir":"/media/movies","names":["a.mkv"]}`, http.StatusForbidden},
+		{http.MethodGet, "/img/media/movies/poster.jpg", "kid", "", http.StatusForbidden},
+		{http.MethodGet, "/img/media/cartoons/poster.jpg", "kid", "", http.StatusOK},
+		{http.MethodGet, "/enc-api/getUserInfo", "kid", "", http.StatusOK},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		if tc.user != "" {
+			req.Header.Set("X-Forwarded-User", tc.user)
+		}
+		rr := httptest.NewRecorder()
+		seenUser = ""
+		r.ServeHTTP(rr, req)
+		if rr.Code != tc.want {
+			t.Fatalf("%s %s as %q: status=%d, want %d", tc.method, tc.path, tc.user, rr.Code, tc.want)
+		}
+		if rr.Code == http.StatusOK && seenUser != tc.user {
+			t.Fatalf("%s %s: user in context=%q, want %q", tc.method, tc.path, seenUser, tc.user)
+		}
+	}
+}
+
+func TestClientCertMiddlewareRequiresCertificate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{Scheme: &config.SchemeConfig{
+		ClientCAFile:     "ca.pem",
+		ClientAuthExempt: []string{"/dav/"},
+	}}
+	var enabled atomic.Bool
+	r := gin.New()
+	r.Use(ClientCertMiddleware(cfg, &enabled))
+	r.Any("/*path", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{}}}}
+	cases := []struct {
+		path    string
+		state   *tls.ConnectionState
+		enabled bool
+		want    int
+	}{
+		{"/d/a.mkv", &tls.ConnectionState{}, false, http.StatusOK},
+		{"/d/a.mkv", &tls.ConnectionState{}, true, http.StatusForbidden},
+		{"/d/a.mkv", verified, true, http.StatusOK},
+		{"/d/a.mkv", nil, true, http.StatusOK},
+		{"/dav/a.mkv", &tls.ConnectionState{}, true, http.StatusOK},
+		{"/dav", &tls.ConnectionState{}, true, http.StatusOK},
+		{"/davx", &tls.ConnectionState{}, true, http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		enabled.Store(tc.enabled)
+		req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		req.TLS = tc.state
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != tc.want {
+			t.Fatalf("%s tls=%v enabled=%v: status=%d, want %d", tc.path, tc.state != nil, tc.enabled, rr.Code, tc.want)
+		}
+		if rr.Code == http.StatusForbidden && rr.Header().Get("X-Enc-Error") != "CLIENT_CERT_REQUIRED" {
+			t.Fatalf("%s: error code %q", tc.path, rr.Header().Get("X-Enc-Error"))
+		}
+	}
+}
+
+type stubLocales map[string]string
+
+func (s stubLocales) Locale(username string) string { return s[username] }
+
+func TestLocaleMiddlewareLocalizesEncAPIMessages(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const secret = "test-secret"
+	jwt := auth.NewJWTAuth(secret, time.Hour)
+	adminToken, _ := jwt.GenerateToken("admin")
+	guestToken, _ := jwt.GenerateToken("guest")
+
+	r := gin.New()
+	api := r.Group("/enc-api")
+	api.Use(LocaleMiddleware(nil))
+	protected := api.Group("")
+	protected.Use(AuthMiddleware(secret, 48, nil), LocaleMiddleware(stubLocales{"admin": "zh-CN"}))
+	protected.GET("/updatePasswd", func(c *gin.Context) {
+		handler.RespondAPIError(c.Writer, 500, "passwword error")
+	})
+
+	call := func(token, acceptLanguage string) (int, string, string) {
+		req := httptest.NewRequest(http.MethodGet, "/enc-api/updatePasswd", nil)
+		if token != "" {
+			req.Header.Set("Authorization", token)
+		}
+		if acceptLanguage != "" {
+			req.Header.Set("Accept-Language", acceptLanguage)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		var body struct {
+			Code int    `json:"code"`
+			Msg  string `json:"msg"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatalf("decode %q: %v", rr.Body.String(), err)
+		}
+		return body.Code, body.Msg, rr.Header().Get("Content-Language")
+	}
+
+	cases := []struct {
+		token, accept        string
+		code                 int
+		msg, contentLanguage string
+	}{
+		{"", "", 401, "user unlogin", ""},
+		{"", "zh-CN,zh;q=0.9", 401, "未登录或登录已过期", "zh-CN"},
+		{"", "en-US", 401, "not logged in", "en"},
+		{guestToken, "", 500, "passwword error", ""},
+		{guestToken, "en", 500, "password error", "en"},
+		{adminToken, "en", 500, "用户名或密码错误", "zh-CN"},
+	}
+	for _, tc := range cases {
+		code, msg, lang := call(tc.token, tc.accept)
+		if code != tc.code || msg != tc.msg || lang != tc.contentLanguage {
+			t.Errorf("token=%t accept=%q: code=%d msg=%q lang=%q", tc.token != "", tc.accept, code, msg, lang)
+		}
+	}
+}
+
+func TestStorageBudgetMiddlewareLimitsByBodyPath(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	workers.SetBudgets(map[string]workers.BudgetLimits{"/cloud": {RequestsPerMin: 1}})
+	t.Cleanup(func() { workers.SetBudgets(nil) })
+
+	r := gin.New()
+	r.Use(StorageBudgetMiddleware())
+	var seen string
+	r.POST("/api/fs/list", func(c *gin.Context) {
+		var body struct {
+			Path string `json:"path"`
+		}
+		_ = c.ShouldBindJSON(&body)
+		seen = body.Path
+		c.Status(http.StatusOK)
+	})
+	list := func(p string) *httptest.ResponseRecorder {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		req := httptest.NewRequest(http.MethodPost, "/api/fs/list", strings.NewReader(`{"path":"`+p+`"}`)).WithContext(ctx)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := list("/cloud/a"); rr.Code != http.StatusOK || seen != "/cloud/a" {
+		t.Fatalf("first request: %d, handler saw %q", rr.Code, seen)
+	}
+	if rr := list("/cloud/b"); !strings.Contains(rr.Body.String(), `"code":429`) || rr.Header().Get("Retry-After") == "" {
+		t.Fatalf("second request should exceed the budget: %s", rr.Body.String())
+	}
+	if rr := list("/other/c"); rr.Code != http.StatusOK {
+		t.Fatalf("other storage: %d", rr.Code)
+	}
+}
+
+type fakePasswdMatcher struct{ prefix string }
+
+func (f fakePasswdMatcher) PathFindPasswd(p string) (*config.PasswdInfo, bool) {
+	if strings.HasPrefix(p, f.prefix+"/") {
+		return &config.PasswdInfo{Password: "x", Enable: true}, true
+	}
+	return nil, false
+}
+
+func (f fakePasswdMatcher) MatchDir(p string) bool { return p == f.prefix }
+
+func TestChainMiddlewareStopsLoopsAndLongChains(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := config.DefaultConfig()
+	cfg.AlistServer.MaxHops = 2
+	r := gin.New()
+	r.Use(ChainMiddleware(cfg, nil, nil))
+	var forwarded string
+	r.GET("/d/*path", func(c *gin.Context) {
+		forwarded = c.GetHeader(proxy.InstanceHeader)
+		c.Status(http.StatusOK)
+	})
+
+	serve := func(instances, hops string) int {
+		req := httptest.NewRequest(http.MethodGet, "/d/a.mkv", nil)
+		req.Header.Set(proxy.InstanceHeader, instances)
+		if hops != "" {
+			req.Header.Set(proxy.HopHeader, hops)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr.Code
+	}
+	if code := serve("lan,"+proxy.InstanceID(), "2"); code != http.StatusLoopDetected {
+		t.Fatalf("own ID in chain: status=%d", code)
+	}
+	if code := serve("another-instance", "1"); code != http.StatusOK || forwarded != "" {
+		t.Fatalf("status=%d forwarded marker=%q", code, forwarded)
+	}
+	if code := serve("a,b", "2"); code != http.StatusLoopDetected {
+		t.Fatalf("third instance with maxHops 2: status=%d", code)
+	}
+}
+
+func TestChainMiddlewarePassesThroughOwnedPaths(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var upstreamOwned []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamOwned = append(upstreamOwned, r.Header.Get(proxy.OwnedHeader))
+	}))
+	defer upstream.Close()
+	u, _ := url.Parse(upstream.URL)
+	cfg := config.DefaultConfig()
+	cfg.AlistServer.ServerHost = u.Hostname()
+	client := proxy.NewHTTPClient(cfg, 0)
+
+	r := gin.New()
+	passedThrough := false
+	r.Use(ChainMiddleware(cfg, fakePasswdMatcher{prefix: "/enc"}, func(w http.ResponseWriter, r *http.Request) {
+		passedThrough = true
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	r.GET("/d/*path", func(c *gin.Context) {
+		req, _ := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, upstream.URL+c.Request.URL.Path, nil)
+		if resp, err := client.Do(req); err == nil {
+			resp.Body.Close()
+		}
+		c.Status(http.StatusOK)
+	})
+
+	// An earlier instance encrypts the path: pass it through.
+	req := httptest.NewRequest(http.MethodGet, "/enc/a.mkv", nil)
+	req.URL.Path = "/d/enc/a.mkv"
+	req.Header.Set(proxy.InstanceHeader, "lan")
+	req.Header.Set(proxy.HopHeader, "1")
+	req.Header.Set(proxy.OwnedHeader, "1")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if !passedThrough || rr.Code != http.StatusNoContent {
+		t.Fatalf("owned request not passed through: status=%d", rr.Code)
+	}
+
+	// A client request is handled here.
+	passedThrough = false
+	req = httptest.NewRequest(http.MethodGet, "/d/enc/a.mkv", nil)
+	req.Header.Set(proxy.OwnedHeader, "1")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if passedThrough || rr.Code != http.StatusOK {
+		t.Fatalf("client request passed through: status=%d", rr.Code)
+	}
+
+	// Paths this instance does not encrypt are not claimed.
+	req = httptest.NewRequest(http.MethodGet, "/d/plain/a.mkv", nil)
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	if len(upstreamOwned) != 2 || upstreamOwned[0] != "1" || upstreamOwned[1] != "" {
+		t.Fatalf("owned markers sent upstream=%q", upstreamOwned)
+	}
+}
+
+type stubRoles map[string]string
+
+func (s stubRoles) Role(username string) string { return s[username] }
+
+func TestRequireRoleChecksCurrentRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const secret = "test-secret"
+	jwt := auth.NewJWTAuth(secret, time.Hour)
+	roles := stubRoles{"admin": "admin", "op": "operator"}
+
+	r := gin.New()
+	protected := r.Group("/enc-api")
+	protected.Use(AuthMiddleware(secret, 48, nil))
+	protected.GET("/getStats", func(c *gin.Context) { c.Status(http.StatusOK) })
+	admin := protected.Group("")
+	admin.Use(RequireRole(roles, "admin"))
+	admin.POST("/saveSchemeConfig", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	call := func(user, method, path string) int {
+		token, _ := jwt.GenerateToken(user)
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorizetoken", token)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	cases := []struct {
+		user, method, path string
+		want               int
+	}{
+		{"admin", http.MethodPost, "/enc-api/saveSchemeConfig", http.StatusOK},
+		{"op", http.MethodPost, "/enc-api/saveSchemeConfig", http.StatusForbidden},
+		{"op", http.MethodGet, "/enc-api/getStats", http.StatusOK},
+		{"deleted", http.MethodPost, "/enc-api/saveSchemeConfig", http.StatusForbidden},
+	}
+	for _, tc := range cases {
+		if got := call(tc.user, tc.method, tc.path); got != tc.want {
+			t.Fatalf("%s %s %s: status=%d, want %d", tc.user, tc.method, tc.path, got, tc.want)
+		}
+	}
+
+	roles["op"] = "admin"
+	if got := call("op", http.MethodPost, "/enc-api/saveSchemeConfig"); got != http.StatusOK {
+		t.Fatalf("promoted operator: status=%d, want 200", got)
+	}
+}
+
+func TestAuditMiddlewareRecordsFileMutations(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	audit := handler.NewAuditLog(store)
+
+	r := gin.New()
+	r.Use(AuditMiddleware(audit))
+	var bodySeen string
+	r.Handle("MOVE", "/dav/*path", func(c *gin.Context) { c.Status(http.StatusCreated) })
+	r.POST("/api/fs/remove", func(c *gin.Context) {
+		raw, _ := io.ReadAll(c.Request.Body)
+		bodySeen = string(raw)
+		c.Status(http.StatusOK)
+	})
+
+	move := httptest.NewRequest("MOVE", "/dav/movies/a.mkv", nil)
+	move.Header.Set("Destination", "http://nas/dav/archive/a%20b.mkv")
+	move.SetBasicAuth("alice", "pw")
+	r.ServeHTTP(httptest.NewRecorder(), move)
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("PROPFIND", "/dav/movies/", nil))
+	removeBody := `{"dir":"/tv","names":["x.mp4","y.mp4"]}`
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/api/fs/remove", strings.NewReader(removeBody)))
+	if bodySeen != removeBody {
+		t.Fatalf("handler body=%q, want it restored", bodySeen)
+	}
+
+	entries, err := audit.Query(handler.AuditFilter{})
+	if err != nil {
+		t.Fatalf("Query: %v", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("entries=%+v, want move + two removes", entries)
+	}
+	byPath := map[string]handler.AuditEntry{}
+	for _, e := range entries {
+		byPath[e.Path] = e
+	}
+	if e := byPath["/movies/a.mkv"]; e.Action != "webdav.move" || e.Actor != "alice" || e.Target != "/archive/a b.mkv" || e.Status != http.StatusCreated {
+		t.Fatalf("move entry=%+v", e)
+	}
+	if e := byPath["/tv/y.mp4"]; e.Action != "fs.remove" || e.Status != http.StatusOK {
+		t.Fatalf("remove entry=%+v", e)
+	}
+}
+
+func TestRequestRulesMiddlewareActions(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := &config.Config{AlistServer: config.AlistServer{
+		ServerHost: "alist.lan",
+		ServerPort: 5244,
+		PasswdList: []config.PasswdInfo{{Describe: "inbox", Password: "secret", EncType: "aesctr"}},
+	}}
+	cfg.RequestRules = []config.RequestRule{
+		{Name: "lan inbox", Path: "/inbox", ClientIPs: []string{"192.0.2.0/24"}, Action: config.RuleActionEncrypt, Passwd: "inbox"},
+		{Name: "raw", Path: "/raw", Action: config.RuleActionPassthrough},
+		{Name: "direct", Path: "/big", Action: config.RuleActionRedirect, RedirectBase: "https://alist.example.com/"},
+		{Name: "everything else from outside", ClientIPs: []string{"0.0.0.0/0"}, Path: "/dav", Action: config.RuleActionBlock, Status: http.StatusNotFound},
+	}
+	var passedThrough bool
+	var seen context.Context
+	r := gin.New()
+	r.Use(RequestRulesMiddleware(cfg, newRequestRules(cfg), func(w http.ResponseWriter, r *http.Request) {
+		passedThrough = true
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	r.Any("/*path", func(c *gin.Context) {
+		seen = c.Request.Context()
+		c.Status(http.StatusOK)
+	})
+
+	cases := []struct {
+		method, path string
+		want         int
+		location     string
+	}{
+		{http.MethodPut, "/dav/inbox/a.jpg", http.StatusOK, ""},
+		{http.MethodGet, "/d/raw/a.bin", http.StatusAccepted, ""},
+		{http.MethodGet, "/d/big/a.iso?sign=x", http.StatusFound, "https://alist.example.com/d/big/a.iso?sign=x"},
+		{http.MethodPut, "/dav/big/a.iso", http.StatusTemporaryRedirect, "https://alist.example.com/dav/big/a.iso"},
+		{http.MethodGet, "/dav/other", http.StatusNotFound, ""},
+		{http.MethodGet, "/enc-api/getUserInfo", http.StatusOK, ""},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		req.RemoteAddr = "192.0.2.10:40000"
+		rr := httptest.NewRecorder()
+		passedThrough = false
+		r.ServeHTTP(rr, req)
+		if rr.Code != tc.want {
+			t.Fatalf("%s %s: status=%d, want %d", tc.method, tc.path, rr.Code, tc.want)
+		}
+		if got := rr.Header().Get("Location"); got != tc.location {
+			t.Fatalf("%s %s: Location=%q, want %q", tc.method, tc.path, got, tc.location)
+		}
+		if passedThrough != (tc.want == http.StatusAccepted) {
+			t.Fatalf("%s %s: passed through=%v", tc.method, tc.path, passedThrough)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/dav/inbox/a.jpg", nil)
+	req.RemoteAddr = "192.0.2.10:40000"
+	r.ServeHTTP(httptest.NewRecorder(), req)
+	override, ok := dao.NewPasswdDAO(nil).FindByPathFor(seen, "/not/in/passwdlist")
+	if !ok || override.Password != "secret" || !override.Enable {
+		t.Fatalf("encrypt rule did not select the inbox passwd: %+v", override)
+	}
+}
+
+func TestAuthMiddlewareRejectsRevokedTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const secret = "test-secret"
+	jwtAuth := auth.NewJWTAuth(secret, time.Hour)
+	loggedOut, err := jwtAuth.GenerateToken("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := jwtAuth.GenerateToken("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := jwtAuth.ValidateToken(loggedOut)
+	if err != nil || claims.ID == "" {
+		t.Fatalf("token has no ID: claims=%+v err=%v", claims, err)
+	}
+	tokens := dao.NewTokenDAO(nil)
+	if err := tokens.Revoke(claims.ID, claims.ExpiresAt.Time); err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.Use(AuthMiddleware(secret, 48, tokens))
+	r.GET("/enc-api/getStats", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	for token, want := range map[string]int{loggedOut: http.StatusUnauthorized, other: http.StatusNoContent} {
+		req := httptest.NewRequest(http.MethodGet, "/enc-api/getStats", nil)
+		req.Header.Set("Authorizetoken", token)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Fatalf("status=%d, want %d", rr.Code, want)
+		}
+	}
+}
+
+func TestAuthMiddlewareRevokeUserCatchesSameSecondTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const secret = "test-secret"
+	jwtAuth := auth.NewJWTAuth(secret, time.Hour)
+	tokens := dao.NewTokenDAO(nil)
+	before, err := jwtAuth.GenerateToken("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tokens.RevokeUser("admin"); err != nil {
+		t.Fatal(err)
+	}
+	relogin, err := jwtAuth.GenerateTokenAt("admin", tokens.IssueTime("admin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.Use(AuthMiddleware(secret, 48, tokens))
+	r.GET("/enc-api/getStats", func(c *gin.Context) { c.Status(http.StatusNoContent) })
+	for token, want := range map[string]int{before: http.StatusUnauthorized, relogin: http.StatusNoContent} {
+		req := httptest.NewRequest(http.MethodGet, "/enc-api/getStats", nil)
+		req.Header.Set("Authorizetoken", token)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Fatalf("status=%d, want %d", rr.Code, want)
+		}
+	}
+}
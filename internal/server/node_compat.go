@@ -0,0 +1,112 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// NodeCompatMiddleware rewrites /enc-api JSON replies into the envelope the
+// Node.js alist-encrypt server produced, so frontends and scripts written
+// against it run unmodified:
+//
+//   - success carries code 200 instead of 0,
+//   - failures, including "user unlogin", are always HTTP 200 with the code
+//     in the body,
+//   - the body has only code, msg and data (no error_code).
+//
+// Non-JSON replies (exports, recorder downloads, progress streams) pass
+// through untouched.
+func NodeCompatMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w := &nodeCompatWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+		c.Writer = w.ResponseWriter
+		w.finish()
+	}
+}
+
+// nodeCompatWriter holds back JSON bodies until the handler chain is done so
+// their envelope and status can still be changed.
+type nodeCompatWriter struct {
+	gin.ResponseWriter
+	decided   bool
+	buffering bool
+	body      bytes.Buffer
+}
+
+func (w *nodeCompatWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	w.buffering = strings.HasPrefix(w.Header().Get("Content-Type"), "application/json")
+}
+
+func (w *nodeCompatWriter) Write(p []byte) (int, error) {
+	w.decide()
+	if w.buffering {
+		return w.body.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *nodeCompatWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *nodeCompatWriter) WriteHeaderNow() {
+	w.decide()
+	if !w.buffering {
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *nodeCompatWriter) Flush() {
+	w.decide()
+	if !w.buffering {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *nodeCompatWriter) finish() {
+	if !w.buffering {
+		return
+	}
+	status, body := nodeCompatEnvelope(w.ResponseWriter.Status(), w.body.Bytes())
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(status)
+	_, _ = w.ResponseWriter.Write(body)
+}
+
+// nodeCompatEnvelope maps one JSON reply onto the Node.js shape. Bodies that
+// are not a {"code": ...} envelope are returned unchanged.
+func nodeCompatEnvelope(status int, body []byte) (int, []byte) {
+	var envelope map[string]json.RawMessage
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return status, body
+	}
+	var code int
+	if raw, ok := envelope["code"]; !ok || json.Unmarshal(raw, &code) != nil {
+		return status, body
+	}
+	if code == 0 {
+		envelope["code"] = json.RawMessage("200")
+	}
+	delete(envelope, "error_code")
+	if status == http.StatusUnauthorized {
+		status = http.StatusOK
+	}
+	out, err := json.Marshal(envelope)
+	if err != nil {
+		return status, body
+	}
+	if bytes.HasSuffix(body, []byte("\n")) {
+		out = append(out, '\n')
+	}
+	return status, out
+}
@@ -0,0 +1,137 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/dao"
+	"github.com/alist-encrypt-go/internal/handler"
+	"github.com/alist-encrypt-go/internal/storage"
+)
+
+// newEncAPIRouter registers the real route table with only the /enc-api
+// dependencies wired up.
+func newEncAPIRouter(t *testing.T, nodeCompat bool) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	store, err := storage.NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	t.Cleanup(func() { _ = store.Close() })
+	users := dao.NewUserDAO(store)
+	if err := users.Create("admin", "secret-pass"); err != nil {
+		t.Fatalf("create user: %v", err)
+	}
+
+	cfg := config.DefaultConfig()
+	cfg.JWTSecret = "contract-secret"
+	cfg.NodeCompat = nodeCompat
+	s := &Server{cfg: cfg, prefsDAO: dao.NewPreferencesDAO(store)}
+	api := handler.NewAPIHandler(cfg, users, nil, nil)
+	api.SetPreferencesDAO(s.prefsDAO)
+
+	r := gin.New()
+	s.registerRoutes(r, api, nil, nil, nil, nil)
+	return r
+}
+
+func callEncAPI(r *gin.Engine, method, path, token, body string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorizetoken", token)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	return rr
+}
+
+func decodeEnvelope(t *testing.T, rr *httptest.ResponseRecorder) map[string]json.RawMessage {
+	t.Helper()
+	var env map[string]json.RawMessage
+	if err := json.Unmarshal(rr.Body.Bytes(), &env); err != nil {
+		t.Fatalf("decode %q: %v", rr.Body.String(), err)
+	}
+	return env
+}
+
+func TestNodeCompatContract(t *testing.T) {
+	r := newEncAPIRouter(t, true)
+	zh := map[string]string{"Accept-Language": "zh-CN"}
+
+	// Wrong password: Node's typo, HTTP 200, code 500, nothing else.
+	rr := callEncAPI(r, http.MethodPost, "/enc-api/login", "", `{"username":"admin","password":"nope"}`, zh)
+	if rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != `{"code":500,"msg":"passwword error"}` {
+		t.Fatalf("bad login: status=%d body=%s", rr.Code, rr.Body.String())
+	}
+
+	// Login answers on any method, success is code 200 with userInfo/jwtToken.
+	rr = callEncAPI(r, http.MethodPut, "/enc-api/login", "", `{"username":"admin","password":"secret-pass"}`, nil)
+	env := decodeEnvelope(t, rr)
+	if rr.Code != http.StatusOK || string(env["code"]) != "200" {
+		t.Fatalf("login: status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	var data struct {
+		UserInfo map[string]any `json:"userInfo"`
+		JWTToken string         `json:"jwtToken"`
+	}
+	if err := json.Unmarshal(env["data"], &data); err != nil || data.JWTToken == "" || data.UserInfo["username"] != "admin" {
+		t.Fatalf("login data=%s err=%v", env["data"], err)
+	}
+
+	// Missing token: HTTP 200 with code 401 so the UI's re-login prompt fires.
+	rr = callEncAPI(r, http.MethodPost, "/enc-api/getUserInfo", "", "", zh)
+	if rr.Code != http.StatusOK || strings.TrimSpace(rr.Body.String()) != `{"code":401,"msg":"user unlogin"}` {
+		t.Fatalf("unlogin: status=%d body=%s", rr.Code, rr.Body.String())
+	}
+	if got := rr.Header().Get("Content-Language"); got != "" {
+		t.Fatalf("compat mode negotiated a language: %q", got)
+	}
+
+	// The typo'd route and plain messages keep their Node shape.
+	rr = callEncAPI(r, http.MethodPost, "/enc-api/getWebdavonfig", data.JWTToken, "", nil)
+	if env := decodeEnvelope(t, rr); string(env["code"]) != "200" || env["data"] == nil {
+		t.Fatalf("getWebdavonfig: %s", rr.Body.String())
+	}
+	rr = callEncAPI(r, http.MethodPost, "/enc-api/updatePasswd", data.JWTToken, `{"username":"admin","password":"secret-pass","newpassword":"short"}`, zh)
+	if strings.TrimSpace(rr.Body.String()) != `{"code":500,"msg":"password too short, at less 8 digits"}` {
+		t.Fatalf("updatePasswd: %s", rr.Body.String())
+	}
+}
+
+func TestNativeEncAPIUnchangedWithoutNodeCompat(t *testing.T) {
+	r := newEncAPIRouter(t, false)
+
+	rr := callEncAPI(r, http.MethodPost, "/enc-api/getUserInfo", "", "", nil)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("unlogin status=%d, want 401", rr.Code)
+	}
+	rr = callEncAPI(r, http.MethodPost, "/enc-api/login", "", `{"username":"admin","password":"secret-pass"}`, nil)
+	if env := decodeEnvelope(t, rr); string(env["code"]) != "0" {
+		t.Fatalf("login: %s", rr.Body.String())
+	}
+}
+
+func TestNodeCompatEnvelopeLeavesOtherBodiesAlone(t *testing.T) {
+	for _, body := range []string{`[1,2]`, `{"rows":[]}`, `not json`} {
+		if status, out := nodeCompatEnvelope(http.StatusOK, []byte(body)); status != http.StatusOK || string(out) != body {
+			t.Fatalf("%s -> %d %s", body, status, out)
+		}
+	}
+	status, out := nodeCompatEnvelope(http.StatusOK, []byte(`{"code":403,"error_code":"forbidden","msg":"x"}`+"\n"))
+	if status != http.StatusOK || string(out) != `{"code":403,"msg":"x"}`+"\n" {
+		t.Fatalf("error envelope -> %d %s", status, out)
+	}
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"net/http/pprof"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// registerPprof mounts the runtime profiler under <group>/debug/pprof/. It is
+// only registered on the authenticated group: profiles expose memory contents
+// and a CPU profile keeps a core busy for its whole duration. The handlers are
+// wired explicitly because net/http/pprof's Index looks for the
+// /debug/pprof/ prefix, which the /enc-api mount does not have.
+//
+//	curl -H "Authorizetoken: $TOKEN" -o cpu.pprof \
+//	  'http://nas:5344/enc-api/debug/pprof/profile?seconds=30'
+//	go tool pprof -http=: cpu.pprof
+func registerPprof(g *gin.RouterGroup) {
+	g.Any("/debug/pprof/*name", func(c *gin.Context) {
+		name := strings.Trim(c.Param("name"), "/")
+		switch name {
+		case "":
+			pprof.Index(c.Writer, c.Request)
+		case "cmdline":
+			pprof.Cmdline(c.Writer, c.Request)
+		case "profile":
+			pprof.Profile(c.Writer, c.Request)
+		case "symbol":
+			pprof.Symbol(c.Writer, c.Request)
+		case "trace":
+			pprof.Trace(c.Writer, c.Request)
+		default:
+			// heap, goroutine, allocs, block, mutex, threadcreate; unknown
+			// names get pprof's own 404.
+			pprof.Handler(name).ServeHTTP(c.Writer, c.Request)
+		}
+	})
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alist-encrypt-go/internal/auth"
+	"github.com/gin-gonic/gin"
+)
+
+func TestPprofRequiresAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const secret = "test-secret"
+	token, err := auth.NewJWTAuth(secret, time.Hour).GenerateToken("admin")
+	if err != nil {
+		t.Fatalf("generate token: %v", err)
+	}
+	r := gin.New()
+	protected := r.Group("/enc-api")
+	protected.Use(AuthMiddleware(secret, 48, nil))
+	registerPprof(protected)
+
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/enc-api/debug/pprof/", nil))
+	if rr.Code == http.StatusOK {
+		t.Fatal("pprof index served without a token")
+	}
+
+	for path, want := range map[string]string{
+		"/enc-api/debug/pprof/":                  "goroutine",
+		"/enc-api/debug/pprof/goroutine?debug=1": "goroutine profile:",
+		"/enc-api/debug/pprof/cmdline":           "",
+		"/enc-api/debug/pprof/heap?debug=1":      "heap profile:",
+		"/enc-api/debug/pprof/no-such-profile":   "Unknown profile",
+	} {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorizetoken", token)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Fatalf("%s: status=%d body=%.200q", path, rr.Code, rr.Body.String())
+		}
+	}
+}
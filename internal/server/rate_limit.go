@@ -0,0 +1,74 @@
+package server
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/handler"
+	"github.com/alist-encrypt-go/internal/ratelimit"
+	"github.com/alist-encrypt-go/internal/trace"
+)
+
+// rateLimiter holds the limiter built from rate_limit, rebuilt when the
+// config is applied with different limits.
+type rateLimiter struct {
+	limiter atomic.Pointer[ratelimit.Limiter]
+}
+
+func newRateLimiter(cfg *config.Config) *rateLimiter {
+	rl := &rateLimiter{}
+	rl.apply(cfg)
+	cfg.OnApply(rl.apply)
+	return rl
+}
+
+func (rl *rateLimiter) apply(cfg *config.Config) {
+	next := cfg.GetRateLimit()
+	if rl.limiter.Load().Same(next) {
+		return
+	}
+	limiter, err := ratelimit.New(next)
+	if err != nil {
+		log.Warn().Err(err).Msg("Ignoring rate limit exemptions")
+	}
+	rl.limiter.Store(limiter)
+}
+
+// Stats reports the limiter's counters for /enc-api/getStats.
+func (rl *rateLimiter) Stats() map[string]interface{} {
+	return rl.limiter.Load().Stats()
+}
+
+// RateLimitMiddleware answers 429 with Retry-After to requests over a
+// rate_limit bucket, before any handler spends Alist calls or CPU on them.
+// Health checks are never limited.
+func RateLimitMiddleware(rl *rateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limiter := rl.limiter.Load()
+		urlPath := c.Request.URL.Path
+		if limiter == nil || urlPath == "/health" || urlPath == "/ready" {
+			c.Next()
+			return
+		}
+		ok, retryAfter, scope := limiter.Allow(c.ClientIP(), c.Request.Method, urlPath)
+		if ok {
+			c.Next()
+			return
+		}
+		trace.Logf(c.Request.Context(), "ratelimit", "Rate limited by %s, retry after %s", scope, retryAfter)
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		if strings.HasPrefix(urlPath, "/api/") || strings.HasPrefix(urlPath, "/enc-api/") {
+			handler.RespondAPIError(c.Writer, http.StatusTooManyRequests, "too many requests")
+		} else {
+			handler.RespondHTTPErrorWithStatus(c.Writer, "too many requests", http.StatusTooManyRequests)
+		}
+		c.Abort()
+	}
+}
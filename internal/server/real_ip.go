@@ -0,0 +1,102 @@
+package server
+
+import (
+	"net"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/alist-encrypt-go/internal/config"
+)
+
+// forwardingHeaders are only believed from trusted proxies.
+var forwardingHeaders = []string{
+	"X-Forwarded-For", "X-Real-IP", "X-Forwarded-Proto", "X-Forwarded-Host", "X-Forwarded-Prefix",
+}
+
+// trustedProxies holds the parsed trusted_proxies and the configured
+// forwarded user header, rebuilt whenever the config is applied.
+type trustedProxies struct {
+	nets       atomic.Pointer[[]*net.IPNet]
+	userHeader atomic.Value // string
+}
+
+func newTrustedProxies(cfg *config.Config) *trustedProxies {
+	tp := &trustedProxies{}
+	tp.apply(cfg)
+	cfg.OnApply(tp.apply)
+	return tp
+}
+
+func (tp *trustedProxies) apply(cfg *config.Config) {
+	nets := config.TrustedProxyNets(cfg.GetTrustedProxies())
+	tp.nets.Store(&nets)
+	tp.userHeader.Store(strings.TrimSpace(cfg.AlistServer.ForwardedUserHeader))
+}
+
+func (tp *trustedProxies) trusted(ip net.IP) bool {
+	for _, n := range *tp.nets.Load() {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the client address behind a trusted peer: the
+// X-Forwarded-For hops are walked from the nearest one back, skipping
+// trusted proxies, and X-Real-IP is the fallback. It returns "" when the
+// headers name no valid address.
+func (tp *trustedProxies) clientIP(forwardedFor []string, realIP string) string {
+	var hops []string
+	for _, value := range forwardedFor {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+	client := ""
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(hops[i]))
+		if ip == nil {
+			// Whatever lies beyond a malformed hop cannot be trusted.
+			break
+		}
+		client = ip.String()
+		if !tp.trusted(ip) {
+			break
+		}
+	}
+	if client == "" {
+		if ip := net.ParseIP(strings.TrimSpace(realIP)); ip != nil {
+			client = ip.String()
+		}
+	}
+	return client
+}
+
+// RealIPMiddleware makes RemoteAddr the client address when the request
+// came through a trusted proxy, and drops the X-Forwarded-* headers and the
+// forwardedUserHeader when it did not, so logs, request rules, rate limits,
+// ForceHTTPS, generated URLs and forwarded users only ever see forwarding
+// information a trusted proxy set. Requests
+// on the unix socket come from the local host and count as trusted.
+func RealIPMiddleware(tp *trustedProxies) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		r := c.Request
+		host, _, err := net.SplitHostPort(strings.TrimSpace(r.RemoteAddr))
+		peer := net.ParseIP(host)
+		if err == nil && peer != nil && !tp.trusted(peer) {
+			for _, h := range forwardingHeaders {
+				r.Header.Del(h)
+			}
+			if h, _ := tp.userHeader.Load().(string); h != "" {
+				r.Header.Del(h)
+			}
+			c.Next()
+			return
+		}
+		if client := tp.clientIP(r.Header.Values("X-Forwarded-For"), r.Header.Get("X-Real-IP")); client != "" {
+			r.RemoteAddr = net.JoinHostPort(client, "0")
+		}
+		c.Next()
+	}
+}
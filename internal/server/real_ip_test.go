@@ -0,0 +1,134 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/handler"
+	"github.com/alist-encrypt-go/internal/trace"
+)
+
+func realIPEngine(t *testing.T, trusted ...string) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	cfg := config.DefaultConfig()
+	cfg.TrustedProxies = trusted
+	r := gin.New()
+	r.ForwardedByClientIP = false
+	r.Use(RealIPMiddleware(newTrustedProxies(cfg)))
+	r.GET("/ip", func(c *gin.Context) {
+		c.String(http.StatusOK, c.ClientIP()+" "+c.GetHeader("X-Forwarded-Proto"))
+	})
+	return r
+}
+
+func TestRealIPMiddlewareHonorsTrustedProxiesOnly(t *testing.T) {
+	r := realIPEngine(t, "10.0.0.0/8")
+	for _, tc := range []struct {
+		name, remote, forwardedFor, want string
+	}{
+		{"untrusted peer", "203.0.113.9:4000", "198.51.100.1", "203.0.113.9 "},
+		{"trusted peer", "10.0.0.2:4000", "198.51.100.1", "198.51.100.1 https"},
+		// nginx appends the address it saw; the client's own value is not
+		// believed past the first untrusted hop.
+		{"spoofed chain", "10.0.0.2:4000", "1.2.3.4, 198.51.100.1", "198.51.100.1 https"},
+		{"proxy chain", "10.0.0.2:4000", "198.51.100.1, 10.0.0.7", "198.51.100.1 https"},
+		{"malformed hop", "10.0.0.2:4000", "198.51.100.1, junk", "10.0.0.2 https"},
+		{"no header", "10.0.0.2:4000", "", "10.0.0.2 https"},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+		req.RemoteAddr = tc.remote
+		req.Header.Set("X-Forwarded-Proto", "https")
+		if tc.forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if got := rr.Body.String(); got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRealIPMiddlewareDefaultsToLoopback(t *testing.T) {
+	r := realIPEngine(t)
+	for remote, want := range map[string]string{
+		"127.0.0.1:4000": "198.51.100.1",
+		"[::1]:4000":     "198.51.100.1",
+		"@":              "198.51.100.1", // unix socket
+		"10.0.0.2:4000":  "10.0.0.2",
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+		req.RemoteAddr = remote
+		req.Header.Set("X-Real-IP", "198.51.100.1")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if got := rr.Body.String(); got != want+" " {
+			t.Errorf("peer %s: got %q, want %q", remote, got, want)
+		}
+	}
+}
+
+func TestForceHTTPSKeepsForwardedPrefix(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := config.DefaultConfig()
+	cfg.Scheme = &config.SchemeConfig{HTTPSPort: 443, ForceHTTPS: true, CertFile: "cert.pem", KeyFile: "key.pem"}
+	r := gin.New()
+	r.Use(RealIPMiddleware(newTrustedProxies(cfg)), ForceHTTPSMiddleware(cfg))
+	r.GET("/d/a", func(c *gin.Context) { handler.RespondSuccess(c.Writer, nil) })
+
+	req := httptest.NewRequest(http.MethodGet, "http://nas/d/a?x=1", nil)
+	req.RemoteAddr = "127.0.0.1:4000"
+	req.Header.Set("X-Forwarded-Prefix", "/enc/")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusMovedPermanently || rr.Header().Get("Location") != "https://nas/enc/d/a?x=1" {
+		t.Fatalf("status=%d location=%q", rr.Code, rr.Header().Get("Location"))
+	}
+
+	// A client talking to the proxy directly cannot claim HTTPS.
+	req = httptest.NewRequest(http.MethodGet, "http://nas/d/a", nil)
+	req.RemoteAddr = "203.0.113.9:4000"
+	req.Header.Set("X-Forwarded-Proto", "https")
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusMovedPermanently {
+		t.Fatalf("forged X-Forwarded-Proto skipped the redirect: status=%d", rr.Code)
+	}
+}
+
+func TestRealIPMiddlewareDropsForwardedUserFromUntrustedPeers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := config.DefaultConfig()
+	cfg.AlistServer.ForwardedUserHeader = "X-Forwarded-User"
+	cfg.AlistServer.ForwardedUserPaths = []config.ForwardedUserPaths{
+		{Username: "admin", AllowPaths: []string{"/"}},
+		{Username: "*", AllowPaths: []string{"/public"}},
+	}
+	r := gin.New()
+	r.Use(RealIPMiddleware(newTrustedProxies(cfg)), ForwardedUserMiddleware(cfg))
+	r.GET("/d/*path", func(c *gin.Context) {
+		c.String(http.StatusOK, trace.GetUser(c.Request.Context()))
+	})
+
+	for _, tc := range []struct {
+		remote, user string
+		want         int
+	}{
+		{"203.0.113.9:4000", "", http.StatusForbidden},
+		{"127.0.0.1:4000", "admin", http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/d/private/a.mkv", nil)
+		req.RemoteAddr = tc.remote
+		req.Header.Set("X-Forwarded-User", "admin")
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != tc.want || (tc.want == http.StatusOK && rr.Body.String() != tc.user) {
+			t.Fatalf("peer %s: status=%d user=%q, want %d %q", tc.remote, rr.Code, rr.Body.String(), tc.want, tc.user)
+		}
+	}
+}
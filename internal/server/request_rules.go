@@ -0,0 +1,109 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/dao"
+	"github.com/alist-encrypt-go/internal/errors"
+	"github.com/alist-encrypt-go/internal/handler"
+	"github.com/alist-encrypt-go/internal/trace"
+)
+
+// requestRules holds the compiled request_rules, rebuilt whenever the
+// config is applied.
+type requestRules struct {
+	set atomic.Pointer[config.RequestRuleSet]
+}
+
+func newRequestRules(cfg *config.Config) *requestRules {
+	rules := &requestRules{}
+	rules.apply(cfg)
+	cfg.OnApply(rules.apply)
+	return rules
+}
+
+func (r *requestRules) apply(cfg *config.Config) {
+	set, errs := config.CompileRequestRules(cfg.GetRequestRules())
+	for _, err := range errs {
+		log.Warn().Err(err).Msg("Ignoring invalid request rule")
+	}
+	r.set.Store(set)
+}
+
+// ruleExempt reports whether urlPath is outside the reach of request rules:
+// the management API and health checks stay reachable so a bad rule can be
+// fixed.
+func ruleExempt(urlPath string) bool {
+	return urlPath == "/health" || urlPath == "/ready" ||
+		urlPath == "/enc-api" || strings.HasPrefix(urlPath, "/enc-api/")
+}
+
+// RequestRulesMiddleware applies the first request rule matching a request:
+// encrypt continues with the named passwdList entry, passthrough hands the
+// request to passThrough, block answers the rule's status and
+// redirect_direct sends the client to Alist itself.
+func RequestRulesMiddleware(cfg *config.Config, rules *requestRules, passThrough http.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		set := rules.set.Load()
+		if set.Len() == 0 || ruleExempt(c.Request.URL.Path) {
+			c.Next()
+			return
+		}
+		displayPath, _ := storageRequestPath(c.Request)
+		rule, index, ok := set.Match(config.RequestRuleInput{
+			Method:      c.Request.Method,
+			URLPath:     c.Request.URL.Path,
+			DisplayPath: displayPath,
+			Header:      c.Request.Header,
+			ClientIP:    c.ClientIP(),
+		})
+		if !ok {
+			c.Next()
+			return
+		}
+		trace.Logf(c.Request.Context(), "rules", "Request rule %d (%s) matched: %s", index, rule.Name, rule.Action)
+
+		switch rule.Action {
+		case config.RuleActionEncrypt:
+			info, found := cfg.RequestRulePasswd(rule.Passwd)
+			if !found {
+				log.Warn().Str("rule", rule.Name).Str("passwd", rule.Passwd).Msg("Request rule names an unknown passwdList entry")
+				c.Next()
+				return
+			}
+			info.Enable = true
+			c.Request = c.Request.WithContext(dao.WithPasswdOverride(c.Request.Context(), info))
+			c.Next()
+		case config.RuleActionPassthrough:
+			passThrough(c.Writer, c.Request)
+			c.Abort()
+		case config.RuleActionBlock:
+			status := rule.Status
+			if status == 0 {
+				status = http.StatusForbidden
+			}
+			handler.RespondCodedError(c.Writer, errors.CodeForbidden, "blocked by request rule", status)
+			c.Abort()
+		case config.RuleActionRedirect:
+			base := rule.RedirectBase
+			if base == "" {
+				base = cfg.GetAlistURL()
+			}
+			status := http.StatusFound
+			if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+				// Keep the method and body of uploads and WebDAV requests.
+				status = http.StatusTemporaryRedirect
+			}
+			c.Redirect(status, strings.TrimRight(base, "/")+c.Request.URL.RequestURI())
+			c.Abort()
+		default:
+			c.Next()
+		}
+	}
+}
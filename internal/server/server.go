@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-contrib/gzip"
@@ -18,10 +19,16 @@ import (
 
 	"github.com/alist-encrypt-go/internal/config"
 	"github.com/alist-encrypt-go/internal/dao"
+	"github.com/alist-encrypt-go/internal/encryption"
+	"github.com/alist-encrypt-go/internal/geoip"
 	"github.com/alist-encrypt-go/internal/handler"
 	"github.com/alist-encrypt-go/internal/proxy"
+	"github.com/alist-encrypt-go/internal/sftpd"
 	"github.com/alist-encrypt-go/internal/storage"
 	"github.com/alist-encrypt-go/internal/storage/mysqlstore"
+	"github.com/alist-encrypt-go/internal/trace"
+	"github.com/alist-encrypt-go/internal/update"
+	"github.com/alist-encrypt-go/internal/workers"
 )
 
 // Server represents the HTTP/2 server
@@ -30,9 +37,9 @@ type Server struct {
 	store         *storage.Store
 	mysqlStore    *mysqlstore.Store
 	engine        *gin.Engine
-	httpServer    *http.Server
-	httpsServer   *http.Server
+	listenServers []*http.Server
 	unixServer    *http.Server
+	sftpServer    *sftpd.Server
 	streamProxy   *proxy.StreamProxy
 	userDAO       *dao.UserDAO
 	fileDAO       *dao.FileDAO
@@ -40,10 +47,31 @@ type Server struct {
 	proxyHandler  *handler.ProxyHandler
 	webdavHandler *handler.WebDAVHandler
 	probeCancel   context.CancelFunc
+	geo           *geoip.Resolver
+	updateCancel  context.CancelFunc
+	healthCancel  context.CancelFunc
+	recorder      *handler.DebugRecorder
+	playStats     *handler.PlaybackStats
+	audit         *handler.AuditLog
+	upstreams     *handler.UpstreamStatus
+	imageResizer  *handler.ImageResizer
+	prefsDAO      *dao.PreferencesDAO
+	tokenDAO      *dao.TokenDAO
+	maintenance   *handler.MaintenanceGate
+	guests        *handler.GuestHandler
+	rateLimiter   *rateLimiter
+	// clientAuth is set once the HTTPS listener asks for client
+	// certificates, so a client_ca_file added later without a restart does
+	// not lock every client out.
+	clientAuth atomic.Bool
 }
 
 // New creates a new server instance
 func New(cfg *config.Config) (*Server, error) {
+	if err := cfg.CheckSelfUpstream(); err != nil {
+		return nil, err
+	}
+
 	// Try MySQL first.
 	mysqlStore, mysqlErr := mysqlstore.NewStore(cfg)
 	if mysqlErr != nil {
@@ -51,14 +79,17 @@ func New(cfg *config.Config) (*Server, error) {
 	}
 
 	// BoltDB is always created for users/passwd/config (minimal, always needed).
-	store, err := storage.NewStore(cfg.DataDir)
+	store, err := acquireStore(cfg.DataDir)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create store: %w", err)
 	}
+	setStorageDegraded(store.DegradedReason())
 
 	// Set Gin mode
 	gin.SetMode(gin.ReleaseMode)
 
+	warnSoftwareAES(cfg)
+
 	s := &Server{
 		cfg:         cfg,
 		store:       store,
@@ -75,33 +106,98 @@ func New(cfg *config.Config) (*Server, error) {
 		s.fileDAO.SetFileMetaWriter(handler.NewMySQLFileMetaWriter(mysqlStore))
 	}
 
+	traceBuffer := trace.DefaultBufferSize
+	if cfg.Log != nil {
+		geo, err := geoip.Open(cfg.Log.GeoIPDB, cfg.Log.ASNDB)
+		if err != nil {
+			log.Warn().Err(err).Msg("GeoIP tagging disabled")
+		}
+		s.geo = geo
+		if cfg.Log.TraceBuffer != 0 {
+			traceBuffer = cfg.Log.TraceBuffer
+		}
+	}
+	trace.SetBufferSize(traceBuffer)
+
 	// Ensure default admin user exists
 	if err := s.userDAO.EnsureDefaultUser(); err != nil {
 		log.Warn().Err(err).Msg("Failed to ensure default user")
 	}
+	if legacy, err := s.userDAO.CountLegacyHashes(); err == nil && legacy > 0 {
+		log.Warn().Int("accounts", legacy).Msg("Accounts still use legacy SHA256 password hashes; they are upgraded on next login or can be expired via /enc-api/expireLegacyPasswords")
+	}
 
 	s.setupRoutes()
 	return s, nil
 }
 
+// Handler returns the router with all routes registered, for embedding the
+// proxy in tests and benchmarks without binding a listener.
+func (s *Server) Handler() http.Handler {
+	return s.engine
+}
+
+// warnSoftwareAES flags aesctr folders on CPUs without AES instructions,
+// where streaming CPU is dominated by software AES.
+func warnSoftwareAES(cfg *config.Config) {
+	if encryption.HardwareAES() {
+		return
+	}
+	lists := [][]config.PasswdInfo{cfg.AlistServer.PasswdList}
+	for _, server := range cfg.WebDAVServer {
+		lists = append(lists, server.PasswdList)
+	}
+	for _, list := range lists {
+		for _, passwd := range list {
+			if passwd.Enable && passwd.EncType == string(encryption.EncTypeAESCTR) {
+				log.Warn().
+					Interface("cpu_features", encryption.CPUFeatures()).
+					Msg("CPU has no AES instructions; aesctr streaming will be CPU bound, consider chacha20 for new folders")
+				return
+			}
+		}
+	}
+}
+
 func (s *Server) setupRoutes() {
 	r := s.engine
 
 	// Middleware
 	r.Use(gin.Recovery())
+	// Client addresses come from RemoteAddr, which RealIPMiddleware sets
+	// from the forwarding headers of trusted proxies only.
+	r.ForwardedByClientIP = false
+	r.Use(RealIPMiddleware(newTrustedProxies(s.cfg)))
 	r.Use(TraceMiddleware())
-	r.Use(LoggerMiddleware())
+	r.Use(LoggerMiddleware(s.geo))
+	s.rateLimiter = newRateLimiter(s.cfg)
+	r.Use(RateLimitMiddleware(s.rateLimiter))
+	r.Use(ClientCertMiddleware(s.cfg, &s.clientAuth))
+	r.Use(ForwardedUserMiddleware(s.cfg))
+	s.audit = handler.NewAuditLog(s.store)
+	r.Use(AuditMiddleware(s.audit))
 	r.Use(CORSMiddleware())
 	r.Use(gzip.Gzip(gzip.DefaultCompression, gzip.WithExcludedPaths([]string{"/dav"})))
+	s.recorder = handler.NewDebugRecorder(filepath.Join(s.cfg.DataDir, "recordings"))
+	r.Use(DebugRecorderMiddleware(s.recorder))
+	r.Use(StorageBudgetMiddleware())
+	// proxyHandler is created below, before the first request arrives.
+	r.Use(ChainMiddleware(s.cfg, s.passwdDAO, func(w http.ResponseWriter, r *http.Request) {
+		s.proxyHandler.HandlePassThrough(w, r)
+	}))
 
 	// Force HTTPS redirect if enabled
-	if s.cfg.Scheme != nil && s.cfg.Scheme.ForceHTTPS && s.cfg.IsHTTPSEnabled() {
-		r.Use(ForceHTTPSMiddleware(s.cfg.Scheme.HTTPSPort))
-	}
+	r.Use(ForceHTTPSMiddleware(s.cfg))
+
+	// request_rules run last, right before handler dispatch.
+	r.Use(RequestRulesMiddleware(s.cfg, newRequestRules(s.cfg), func(w http.ResponseWriter, r *http.Request) {
+		s.proxyHandler.HandlePassThrough(w, r)
+	}))
 
 	// Health check endpoints (no auth required)
 	r.GET("/health", HealthHandler)
-	r.GET("/ready", ReadyHandler)
+	s.upstreams = handler.NewUpstreamStatus(s.cfg)
+	r.GET("/ready", ReadyHandler(s.upstreams))
 
 	s.setupWebUIRoutes(r)
 
@@ -118,6 +214,20 @@ func (s *Server) setupRoutes() {
 // createHandlers initializes all request handlers.
 func (s *Server) createHandlers() (*handler.APIHandler, *handler.ProxyHandler, *handler.AlistHandler, *handler.WebDAVHandler, *handler.StatsHandler) {
 	apiHandler := handler.NewAPIHandler(s.cfg, s.userDAO, s.passwdDAO, s.mysqlStore)
+	s.prefsDAO = dao.NewPreferencesDAO(s.store)
+	apiHandler.SetPreferencesDAO(s.prefsDAO)
+	s.tokenDAO = dao.NewTokenDAO(s.store)
+	if err := s.tokenDAO.BindSecret(s.cfg.JWTSecret); err != nil {
+		log.Warn().Err(err).Msg("Failed to check stored login sessions against the JWT secret")
+	}
+	apiHandler.SetTokenDAO(s.tokenDAO)
+	if u := s.cfg.Update; u != nil && u.Enable {
+		checker := update.NewChecker(u.Repo, config.Version, time.Duration(u.CheckIntervalHours)*time.Hour, u.AllowApply)
+		ctx, cancel := context.WithCancel(context.Background())
+		s.updateCancel = cancel
+		checker.Start(ctx)
+		apiHandler.SetUpdateChecker(checker)
+	}
 	strategyStore := handler.StrategyStore(handler.NewMemoryStrategyStore())
 	var metaStore handler.FileMetaStore
 
@@ -139,7 +249,10 @@ func (s *Server) createHandlers() (*handler.APIHandler, *handler.ProxyHandler, *
 		log.Warn().Err(err).Msg("Failed to initialize strategy selector")
 		strategySelector, _ = handler.NewStrategySelector(s.cfg, handler.NewMemoryStrategyStore())
 	}
+	workers.SetBudgets(storageBudgetLimits(s.cfg))
 	probeScheduler := handler.NewProbeScheduler(s.cfg, s.fileDAO, metaStore, s.streamProxy)
+	s.maintenance = handler.NewMaintenanceGate(s.cfg)
+	probeScheduler.SetMaintenanceGate(s.maintenance)
 	proxyHandler := handler.NewProxyHandler(s.cfg, s.streamProxy, s.fileDAO, s.passwdDAO, strategySelector, metaStore)
 	proxyHandler.SetProbeScheduler(probeScheduler)
 	alistHandler := handler.NewAlistHandler(s.cfg, s.streamProxy, s.fileDAO, s.passwdDAO, proxyHandler, metaStore, probeScheduler)
@@ -150,12 +263,49 @@ func (s *Server) createHandlers() (*handler.APIHandler, *handler.ProxyHandler, *
 		dirSyncStore = handler.NewBoltDirSyncStore(s.store)
 	}
 	alistHandler.SetDirSyncStore(dirSyncStore)
+	alistHandler.SetMaintenanceGate(s.maintenance)
 	alistHandler.StartDirSyncLoop()
+	healthCtx, healthCancel := context.WithCancel(context.Background())
+	s.healthCancel = healthCancel
+	alistHandler.StartDecodeHealthLoop(healthCtx)
 	webdavHandler := handler.NewWebDAVHandler(s.cfg, s.streamProxy, s.fileDAO, s.passwdDAO, strategySelector, metaStore)
 	webdavHandler.SetProbeScheduler(probeScheduler)
+	webdavHandler.SetMaintenanceGate(s.maintenance)
+	s.playStats = handler.NewPlaybackStats(s.store)
+	s.playStats.Start(healthCtx)
+	s.audit.Start(healthCtx)
+	s.upstreams.Start(healthCtx)
+	proxy.StartFailover(healthCtx, s.cfg)
+	apiHandler.SetAuditLog(s.audit)
+	proxyHandler.SetAuditLog(s.audit)
+	webdavHandler.SetAuditLog(s.audit)
+	proxyHandler.SetPlaybackStats(s.playStats)
+	webdavHandler.SetPlaybackStats(s.playStats)
+	readVerifier := handler.NewReadVerifier(s.cfg, s.store)
+	proxyHandler.SetReadVerifier(readVerifier)
+	webdavHandler.SetReadVerifier(readVerifier)
+	alistHandler.SetReadVerifier(readVerifier)
+	alistHandler.SetUploadJournal(handler.NewUploadJournal(s.store))
+	alistHandler.StartUploadRecovery(healthCtx)
+	alistHandler.SetNameKDFJobs(handler.NewNameKDFJobs(s.store))
+	alistHandler.ResumeNameKDFJobs()
 	statsHandler := handler.NewStatsHandler(s.cfg, s.fileDAO, alistHandler, proxyHandler, webdavHandler, s.streamProxy, startTime)
+	statsHandler.SetReadVerifier(readVerifier)
+	statsHandler.SetUserDAO(s.userDAO)
+	statsHandler.SetRateLimitStats(s.rateLimiter.Stats)
+	s.imageResizer = handler.NewImageResizer(s.cfg, s.fileDAO, proxyHandler.HandleDownload, s.cfg.DataDir)
+	statsHandler.SetImageResizer(s.imageResizer)
 	s.proxyHandler = proxyHandler
 	s.webdavHandler = webdavHandler
+	s.guests = handler.NewGuestHandler(s.cfg, dao.NewGuestCodeDAO(s.store), alistHandler, proxyHandler)
+	s.guests.SetAuditLog(s.audit)
+
+	// Settings changed through the API are live for everything that reads
+	// the config per request; these rebuild the state derived from it.
+	s.cfg.OnApply(s.streamProxy.ApplyConfig)
+	s.cfg.OnApply(proxyHandler.ApplyConfig)
+	s.cfg.OnApply(s.imageResizer.ApplyConfig)
+	s.cfg.OnApply(func(cfg *config.Config) { workers.SetBudgets(storageBudgetLimits(cfg)) })
 
 	return apiHandler, proxyHandler, alistHandler, webdavHandler, statsHandler
 }
@@ -169,45 +319,108 @@ func (s *Server) registerRoutes(r *gin.Engine, apiHandler *handler.APIHandler, p
 
 	// /enc-api/* routes - Authentication and config management
 	encAPI := r.Group("/enc-api")
+	authChain := []gin.HandlerFunc{AuthMiddleware(s.cfg.JWTSecret, s.cfg.JWTExpire, s.tokenDAO)}
 	{
 		// Public routes (no auth required)
-		encAPI.POST("/login", ginWrap(apiHandler.Login))
+		if s.cfg.NodeCompat {
+			// The Node.js server took /login on any method and never
+			// localized its messages.
+			encAPI.Use(NodeCompatMiddleware())
+			encAPI.Any("/login", ginWrap(apiHandler.Login))
+		} else {
+			encAPI.Use(LocaleMiddleware(nil))
+			encAPI.POST("/login", ginWrap(apiHandler.Login))
+			authChain = append(authChain, LocaleMiddleware(s.prefsDAO))
+		}
 		encAPI.Any("/getBuildInfo", ginWrap(apiHandler.GetBuildInfo))
+		encAPI.GET("/oidc/login", ginWrap(apiHandler.OIDCLogin))
+		encAPI.GET("/oidc/callback", ginWrap(apiHandler.OIDCCallback))
 
-		// Protected routes (auth required)
+		// Protected routes (auth required). Operators manage passwd lists,
+		// caches and library jobs; saveAlistConfig and updateWebdavConfig only
+		// apply their passwd list and cache sections for them.
 		protected := encAPI.Group("")
-		protected.Use(AuthMiddleware(s.cfg.JWTSecret, s.cfg.JWTExpire))
+		protected.Use(authChain...)
 		{
 			protected.Any("/getUserInfo", ginWrap(apiHandler.GetUserInfo))
+			protected.POST("/logout", ginWrap(apiHandler.Logout))
+			protected.GET("/sessions", ginWrap(apiHandler.HandleSessions))
+			protected.POST("/sessions/revoke", ginWrap(apiHandler.HandleSessions))
+			protected.Any("/preferences", ginWrap(apiHandler.HandlePreferences))
 			protected.Any("/updatePasswd", ginWrap(apiHandler.UpdatePasswd))
 			protected.Any("/updateUsername", ginWrap(apiHandler.UpdateUsername))
 			protected.Any("/getAlistConfig", ginWrap(apiHandler.GetAlistConfig))
 			protected.Any("/saveAlistConfig", ginWrap(apiHandler.SaveAlistConfig))
-			protected.Any("/validateScanConfig", ginWrap(apiHandler.ValidateScanConfig))
 			protected.Any("/getWebdavonfig", ginWrap(apiHandler.GetWebdavConfig)) // Typo matches original
 			protected.Any("/getWebdavConfig", ginWrap(apiHandler.GetWebdavConfig))
-			protected.Any("/saveWebdavConfig", ginWrap(apiHandler.SaveWebdavConfig))
 			protected.Any("/updateWebdavConfig", ginWrap(apiHandler.UpdateWebdavConfig))
-			protected.Any("/delWebdavConfig", ginWrap(apiHandler.DelWebdavConfig))
 			protected.Any("/encodeFoldName", ginWrap(apiHandler.EncodeFoldName))
 			protected.Any("/decodeFoldName", ginWrap(apiHandler.DecodeFoldName))
+			protected.POST("/identify", ginWrap(apiHandler.Identify))
 			protected.Any("/getSchemeConfig", ginWrap(apiHandler.GetSchemeConfig))
-			protected.Any("/saveSchemeConfig", ginWrap(apiHandler.SaveSchemeConfig))
 			protected.Any("/exportFileMeta", ginWrap(apiHandler.ExportFileMeta))
 			protected.Any("/exportStrategy", ginWrap(apiHandler.ExportStrategy))
 			protected.Any("/exportRangeCompat", ginWrap(apiHandler.ExportRangeCompat))
-			protected.Any("/cleanupLegacyBoltDB", ginWrap(apiHandler.CleanupLegacyBoltDB))
+			protected.Any("/chunkMap", ginWrap(alistHandler.HandleChunkMap))
+			protected.GET("/inventory", ginWrap(alistHandler.HandleInventory))
+			protected.POST("/reencrypt/start", ginWrap(alistHandler.HandleReencryptStart))
+			protected.GET("/reencrypt/status", ginWrap(alistHandler.HandleReencryptStatus))
+			protected.POST("/reencrypt/cancel", ginWrap(alistHandler.HandleReencryptCancel))
+			protected.POST("/nameKdf/start", ginWrap(alistHandler.HandleNameKDFStart))
+			protected.GET("/nameKdf/status", ginWrap(alistHandler.HandleNameKDFStatus))
+			protected.POST("/nameKdf/cancel", ginWrap(alistHandler.HandleNameKDFCancel))
+			protected.POST("/ingest", ginWrap(alistHandler.HandleIngestStart))
+			protected.GET("/ingest/status", ginWrap(alistHandler.HandleIngestStatus))
+			protected.POST("/ingest/cancel", ginWrap(alistHandler.HandleIngestCancel))
+			protected.PUT("/patch", ginWrap(alistHandler.HandlePatchUpload))
+			protected.GET("/jobs", ginWrap(s.maintenance.HandleJobs))
 			protected.Any("/getStats", ginWrap(statsHandler.HandleStats))
+			protected.GET("/status", ginWrap(s.upstreams.HandleStatus))
+			protected.GET("/reports/top", ginWrap(s.playStats.HandleTopReport))
+			protected.GET("/reports/storage", ginWrap(alistHandler.HandleStorageReport))
 			protected.Any("/getProxyDomainDictionary", ginWrap(apiHandler.GetProxyDomainDictionary))
 			protected.Any("/refreshProxyDomainDictionary", ginWrap(apiHandler.RefreshProxyDomainDictionary))
 			protected.Any("/getProxyRoutingConfig", ginWrap(apiHandler.GetProxyRoutingConfig))
-			protected.Any("/saveProxyRoutingConfig", ginWrap(apiHandler.SaveProxyRoutingConfig))
+			protected.GET("/getRequestRules", ginWrap(apiHandler.GetRequestRules))
+			protected.POST("/testRequestRules", ginWrap(apiHandler.TestRequestRules))
 			// Local file encrypt/decrypt with progress tracking
 			protected.Any("/checkFilePath", ginWrap(handler.HandleCheckFilePath))
 			protected.Any("/encryptFile", ginWrap(handler.HandleEncryptFile))
 			protected.Any("/encryptStatus/*taskId", ginWrap(handler.HandleEncryptTaskStatus))
 			protected.Any("/encryptTasks", ginWrap(handler.HandleEncryptTaskList))
 		}
+
+		// Admin-only routes: listeners/TLS, upstream connections, accounts,
+		// binary updates and diagnostics.
+		admin := protected.Group("")
+		admin.Use(RequireRole(s.userDAO, dao.RoleAdmin))
+		{
+			admin.POST("/applyUpdate", ginWrap(apiHandler.ApplyUpdate))
+			admin.POST("/restart", ginWrap(apiHandler.Restart))
+			admin.POST("/expireLegacyPasswords", ginWrap(apiHandler.ExpireLegacyPasswords))
+			admin.POST("/createUser", ginWrap(apiHandler.CreateUser))
+			admin.POST("/setUserRole", ginWrap(apiHandler.SetUserRole))
+			admin.Any("/validateScanConfig", ginWrap(apiHandler.ValidateScanConfig))
+			admin.Any("/saveWebdavConfig", ginWrap(apiHandler.SaveWebdavConfig))
+			admin.Any("/delWebdavConfig", ginWrap(apiHandler.DelWebdavConfig))
+			admin.Any("/saveSchemeConfig", ginWrap(apiHandler.SaveSchemeConfig))
+			admin.Any("/cleanupLegacyBoltDB", ginWrap(apiHandler.CleanupLegacyBoltDB))
+			admin.POST("/jobs/override", ginWrap(s.maintenance.HandleJobsOverride))
+			admin.POST("/debugRecorder/start", ginWrap(s.recorder.HandleDebugRecorderStart))
+			admin.Any("/debugRecorder/stop", ginWrap(s.recorder.HandleDebugRecorderStop))
+			admin.GET("/debugRecorder/status", ginWrap(s.recorder.HandleDebugRecorderStatus))
+			admin.GET("/debugRecorder/download", ginWrap(s.recorder.HandleDebugRecorderDownload))
+			registerPprof(admin)
+			admin.Any("/saveProxyRoutingConfig", ginWrap(apiHandler.SaveProxyRoutingConfig))
+			admin.POST("/saveRequestRules", ginWrap(apiHandler.SaveRequestRules))
+			admin.GET("/audit", ginWrap(s.audit.HandleAudit))
+			admin.GET("/trace/:id", ginWrap(handler.NewTraceHandler(s.audit).HandleTrace))
+			admin.GET("/uploadJournal", ginWrap(alistHandler.HandleUploadJournal))
+			admin.POST("/uploadJournal", ginWrap(alistHandler.HandleUploadJournal))
+			admin.GET("/guestCodes", ginWrap(s.guests.HandleCodes))
+			admin.POST("/guestCodes", ginWrap(s.guests.HandleCodes))
+			admin.POST("/guestCodes/revoke", ginWrap(s.guests.HandleRevoke))
+		}
 	}
 
 	// /redirect/:key - 302 redirect decryption
@@ -217,8 +430,12 @@ func (s *Server) registerRoutes(r *gin.Engine, apiHandler *handler.APIHandler, p
 	// so requiring auth here would block all playback in web UI.
 	r.Any("/redirect/:key", ginWrap(proxyHandler.HandleRedirect))
 
+	// /guest/{code}/* - read-only browsing of one directory for holders of
+	// an admin-issued guest code; the code is the credential.
+	r.Any("/guest/*path", ginWrap(s.guests.HandleGuest))
+
 	// /dav/* - WebDAV proxy (supports all WebDAV methods: PROPFIND, MKCOL, etc.)
-	davGroup := r.Group("/dav")
+	davGroup := r.Group("/dav", ListCacheInvalidationMiddleware(alistHandler))
 	{
 		davGroup.Any("", ginWrap(webdavHandler.Handle))
 		davGroup.Any("/*path", ginWrap(webdavHandler.Handle))
@@ -239,9 +456,14 @@ func (s *Server) registerRoutes(r *gin.Engine, apiHandler *handler.APIHandler, p
 	r.GET("/p/*path", ginWrap(proxyHandler.HandleDownload))
 	r.HEAD("/p/*path", ginWrap(proxyHandler.HandleDownload))
 
+	// /img/* - Resized variants of (encrypted) images, cached on disk
+	r.GET("/img/*path", ginWrap(s.imageResizer.HandleImage))
+	r.HEAD("/img/*path", ginWrap(s.imageResizer.HandleImage))
+
 	// /api/fs/* - Alist API interception
 	r.POST("/api/fs/get", ginWrap(alistHandler.HandleFsGet))
 	r.POST("/api/fs/link", ginWrap(alistHandler.HandleFsLink))
+	r.POST("/api/fs/other", ginWrap(alistHandler.HandleFsOther))
 	r.POST("/api/fs/list", ginWrap(alistHandler.HandleFsList))
 	r.POST("/api/fs/search", ginWrap(alistHandler.HandleFsSearch))
 	r.PUT("/api/fs/put", ginWrap(alistHandler.HandleFsPut))
@@ -253,8 +475,24 @@ func (s *Server) registerRoutes(r *gin.Engine, apiHandler *handler.APIHandler, p
 	r.POST("/api/encrypt/dir-sync/run", ginWrap(alistHandler.HandleDirSyncRun))
 	r.GET("/api/encrypt/dir-sync/page", ginWrap(alistHandler.HandleDirSyncPage))
 
+	// Alist admin API - same passthrough, gated by adminRouteAccess
+	r.Any("/api/admin/*path", ginWrap(proxyHandler.HandleAdminAPI))
+
 	// Catch-all - Proxy to Alist with version injection
-	r.NoRoute(ginWrap(proxyHandler.HandleProxy))
+	r.NoRoute(ListCacheInvalidationMiddleware(alistHandler), ginWrap(proxyHandler.HandleProxy))
+}
+
+// storageBudgetLimits converts concurrency.storage_budgets for the shared
+// limiter registry.
+func storageBudgetLimits(cfg *config.Config) map[string]workers.BudgetLimits {
+	limits := make(map[string]workers.BudgetLimits)
+	if cfg == nil || cfg.Concurrency == nil {
+		return limits
+	}
+	for _, b := range cfg.Concurrency.StorageBudgets {
+		limits[b.Path] = workers.BudgetLimits{MaxStreams: b.MaxStreams, RequestsPerMin: b.RequestsPerMin}
+	}
+	return limits
 }
 
 // startStartupProbe launches a background goroutine for startup probing if enabled.
@@ -345,34 +583,73 @@ func ginWrap(h http.HandlerFunc) gin.HandlerFunc {
 
 // Start starts the server(s)
 func (s *Server) Start() error {
-	errChan := make(chan error, 3)
-
-	// Start HTTP server
-	go func() {
-		err := s.startHTTP()
-		if err != nil && err != http.ErrServerClosed {
-			errChan <- fmt.Errorf("HTTP server error: %w", err)
-		} else {
-			errChan <- nil // Signal normal shutdown
+	listeners := s.cfg.Listeners()
+	activated := newActivationSet()
+
+	// Build every HTTP(S) server before serving so Shutdown sees them all.
+	serve := make([]func() error, 0, len(listeners))
+	var bound []string
+	build := func(l config.ListenerConfig) error {
+		ln := activated.takeTCP(l)
+		if ln == nil {
+			bound = append(bound, l.Addr())
+		}
+		srv, run, err := s.newListenServer(l, ln)
+		if err != nil {
+			return err
+		}
+		s.listenServers = append(s.listenServers, srv)
+		serve = append(serve, run)
+		return nil
+	}
+	for _, l := range listeners {
+		if err := build(l); err != nil {
+			activated.close()
+			return err
+		}
+	}
+	for _, l := range activated.unclaimedTCP() {
+		log.Warn().Str("addr", l.Addr()).Msg("Activated socket matches no listener; serving it as plain HTTP")
+		listeners = append(listeners, l)
+		if err := build(l); err != nil {
+			activated.close()
+			return err
 		}
-	}()
+	}
+	var unixListener net.Listener
+	if s.cfg.IsUnixSocketEnabled() {
+		unixListener = activated.takeUnix(s.cfg.Scheme.UnixFile)
+		if unixListener == nil {
+			bound = append(bound, unixRetainKey(s.cfg.Scheme.UnixFile))
+		}
+	}
+	if s.cfg.IsSFTPEnabled() {
+		bound = append(bound, s.cfg.GetSFTPAddr())
+	}
+	activated.close()
+	releaseRetained(bound)
 
-	// Start HTTPS server if enabled
-	if s.cfg.IsHTTPSEnabled() {
-		go func() {
-			err := s.startHTTPS()
+	errChan := make(chan error, len(listeners)+2)
+	log.Info().Str("alist_url", s.cfg.GetAlistURL()).Msg("Proxying to Alist")
+	for i, run := range serve {
+		kind := "HTTP"
+		if listeners[i].TLS {
+			kind = "HTTPS"
+		}
+		go func(run func() error) {
+			err := run()
 			if err != nil && err != http.ErrServerClosed {
-				errChan <- fmt.Errorf("HTTPS server error: %w", err)
+				errChan <- fmt.Errorf("%s server error: %w", kind, err)
 			} else {
-				errChan <- nil
+				errChan <- nil // Signal normal shutdown
 			}
-		}()
+		}(run)
 	}
 
 	// Start Unix socket if enabled
 	if s.cfg.IsUnixSocketEnabled() {
 		go func() {
-			err := s.startUnix()
+			err := s.startUnix(unixListener)
 			if err != nil && err != http.ErrServerClosed {
 				errChan <- fmt.Errorf("Unix socket error: %w", err)
 			} else {
@@ -381,80 +658,150 @@ func (s *Server) Start() error {
 		}()
 	}
 
+	// Start SFTP server if enabled
+	if s.cfg.IsSFTPEnabled() {
+		go func() {
+			if err := s.startSFTP(); err != nil {
+				errChan <- fmt.Errorf("SFTP server error: %w", err)
+			} else {
+				errChan <- nil
+			}
+		}()
+	}
+
 	// Wait for any server to stop
 	return <-errChan
 }
 
-func (s *Server) startHTTP() error {
-	addr := s.cfg.GetHTTPAddr()
-
-	var httpHandler http.Handler = s.engine
-
-	// Enable h2c (HTTP/2 cleartext) if configured
-	if s.cfg.IsH2CEnabled() {
-		h2s := &http2.Server{
-			MaxConcurrentStreams: 1000,
-			IdleTimeout:          120 * time.Second,
+// newListenServer builds the server for one listener and returns the
+// function that serves it. Plain listeners speak h2c when enable_h2c is
+// set; TLS listeners negotiate HTTP/2 and check client certificates when
+// client_ca_file is set. Listeners limited to some paths answer 404 for
+// the rest. ln is the socket passed by socket activation, if any; without
+// one the server listens on the listener's address itself.
+func (s *Server) newListenServer(l config.ListenerConfig, ln net.Listener) (*http.Server, func() error, error) {
+	addr := l.Addr()
+	listen := func() (net.Listener, error) {
+		if ln != nil {
+			return ln, nil
 		}
-		httpHandler = h2c.NewHandler(s.engine, h2s)
-		log.Info().Msg("HTTP/2 cleartext (h2c) enabled")
+		return listenTCP(addr)
 	}
-
-	s.httpServer = &http.Server{
+	var h http.Handler = s.engine
+	if len(l.Paths) > 0 {
+		h = listenerPathFilter(l, h)
+	}
+	srv := &http.Server{
 		Addr:              addr,
-		Handler:           httpHandler,
 		ReadHeaderTimeout: 10 * time.Second,
 		ReadTimeout:       0, // No timeout for streaming
 		WriteTimeout:      0,
 		IdleTimeout:       120 * time.Second,
 	}
 
-	log.Info().Str("addr", addr).Msg("Starting HTTP server")
-	log.Info().Str("alist_url", s.cfg.GetAlistURL()).Msg("Proxying to Alist")
-
-	return s.httpServer.ListenAndServe()
-}
-
-func (s *Server) startHTTPS() error {
-	addr := s.cfg.GetHTTPSAddr()
+	if !l.TLS {
+		// Enable h2c (HTTP/2 cleartext) if configured
+		if s.cfg.IsH2CEnabled() {
+			h = h2c.NewHandler(h, newHTTP2Server(s.cfg))
+			log.Info().Str("addr", addr).Msg("HTTP/2 cleartext (h2c) enabled")
+		}
+		srv.Handler = h
+		log.Info().Str("addr", addr).Strs("paths", l.Paths).Bool("activated", ln != nil).Msg("Starting HTTP server")
+		return srv, func() error {
+			listener, err := listen()
+			if err != nil {
+				return err
+			}
+			return srv.Serve(listener)
+		}, nil
+	}
 
+	scheme := s.cfg.Scheme
+	if scheme == nil || scheme.CertFile == "" || scheme.KeyFile == "" {
+		return nil, nil, fmt.Errorf("TLS listener %s needs scheme.cert_file and scheme.key_file", addr)
+	}
 	tlsConfig := &tls.Config{
 		MinVersion: tls.VersionTLS12,
 		NextProtos: []string{"h2", "http/1.1"},
 	}
-
-	s.httpsServer = &http.Server{
-		Addr:              addr,
-		Handler:           s.engine,
-		TLSConfig:         tlsConfig,
-		ReadHeaderTimeout: 10 * time.Second,
-		ReadTimeout:       0,
-		WriteTimeout:      0,
-		IdleTimeout:       120 * time.Second,
+	if caFile := scheme.ClientCAFile; caFile != "" {
+		pool, err := config.LoadClientCAs(caFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Certificates are verified when presented; ClientCertMiddleware
+		// rejects requests without one outside the exempt paths.
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		s.clientAuth.Store(true)
+		log.Info().Str("addr", addr).Str("ca", caFile).Strs("exempt", scheme.ClientAuthExempt).Msg("Client certificate authentication enabled")
 	}
+	srv.Handler = h
+	srv.TLSConfig = tlsConfig
 
 	// Enable HTTP/2
-	http2.ConfigureServer(s.httpsServer, &http2.Server{
-		MaxConcurrentStreams: 1000,
-		IdleTimeout:          120 * time.Second,
-	})
+	http2.ConfigureServer(srv, newHTTP2Server(s.cfg))
+
+	log.Info().Str("addr", addr).Strs("paths", l.Paths).Bool("activated", ln != nil).Msg("Starting HTTPS server with HTTP/2")
+	certFile, keyFile := scheme.CertFile, scheme.KeyFile
+	return srv, func() error {
+		listener, err := listen()
+		if err != nil {
+			return err
+		}
+		return srv.ServeTLS(listener, certFile, keyFile)
+	}, nil
+}
 
-	log.Info().Str("addr", addr).Msg("Starting HTTPS server with HTTP/2")
+// listenerPathFilter answers 404 for paths outside the listener's paths.
+func listenerPathFilter(l config.ListenerConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !l.Serves(r.URL.Path) {
+			http.NotFound(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-	return s.httpsServer.ListenAndServeTLS(s.cfg.Scheme.CertFile, s.cfg.Scheme.KeyFile)
+// newHTTP2Server builds the serving-side HTTP/2 settings from config.HTTP2.
+// Larger receive windows let clients push uploads without stalling on
+// WINDOW_UPDATE round-trips over high-latency links.
+func newHTTP2Server(cfg *config.Config) *http2.Server {
+	h2s := &http2.Server{
+		MaxConcurrentStreams: 1000,
+		IdleTimeout:          120 * time.Second,
+	}
+	if cfg == nil || cfg.HTTP2 == nil {
+		return h2s
+	}
+	h := cfg.HTTP2
+	if h.MaxConcurrentStreams > 0 {
+		h2s.MaxConcurrentStreams = uint32(h.MaxConcurrentStreams)
+	}
+	if h.MaxReadFrameSize > 0 {
+		h2s.MaxReadFrameSize = uint32(h.MaxReadFrameSize)
+	}
+	if h.ServerConnWindowKb > 0 {
+		h2s.MaxUploadBufferPerConnection = int32(h.ServerConnWindowKb) * 1024
+	}
+	if h.ServerStreamWindowKb > 0 {
+		h2s.MaxUploadBufferPerStream = int32(h.ServerStreamWindowKb) * 1024
+	}
+	return h2s
 }
 
-func (s *Server) startUnix() error {
+func (s *Server) startUnix(listener net.Listener) error {
 	socketPath := s.cfg.Scheme.UnixFile
 
-	// Remove existing socket file
-	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
-		return fmt.Errorf("failed to remove existing socket: %w", err)
-	}
-
-	listener, err := net.Listen("unix", socketPath)
-	if err != nil {
-		return fmt.Errorf("failed to create unix socket: %w", err)
+	// An activated socket belongs to the socket unit; it is neither
+	// removed nor recreated here.
+	if listener == nil {
+		var err error
+		listener, err = listenUnix(socketPath)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Set socket permissions if specified
@@ -481,6 +828,27 @@ func (s *Server) startUnix() error {
 	return nil
 }
 
+// startSFTP serves the same engine over SFTP; uploads are spooled under the
+// data dir until the client closes the file.
+func (s *Server) startSFTP() error {
+	dataDir := s.cfg.DataDir
+	if dataDir == "" {
+		dataDir = "data"
+	}
+	srv, err := sftpd.New(s.engine, s.cfg.GetSFTPHostKeyPath(), filepath.Join(dataDir, "sftp-tmp"))
+	if err != nil {
+		return err
+	}
+	s.sftpServer = srv
+	addr := s.cfg.GetSFTPAddr()
+	ln, err := listenTCP(addr)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("addr", addr).Msg("Starting SFTP server")
+	return srv.Serve(ln)
+}
+
 // Shutdown gracefully shuts down the server
 func (s *Server) Shutdown(ctx context.Context) error {
 	log.Info().Msg("Shutting down server...")
@@ -489,6 +857,12 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	if s.probeCancel != nil {
 		s.probeCancel()
 	}
+	if s.updateCancel != nil {
+		s.updateCancel()
+	}
+	if s.healthCancel != nil {
+		s.healthCancel()
+	}
 	if s.proxyHandler != nil {
 		s.proxyHandler.Stop()
 	}
@@ -497,34 +871,50 @@ func (s *Server) Shutdown(ctx context.Context) error {
 	}
 
 	var lastErr error
-
-	if s.httpServer != nil {
-		if err := s.httpServer.Shutdown(ctx); err != nil {
+	drained := true
+	shutdown := func(srv *http.Server) {
+		if err := srv.Shutdown(ctx); err != nil {
 			lastErr = err
+			if ctx.Err() != nil {
+				drained = false
+			}
 		}
 	}
 
-	if s.httpsServer != nil {
-		if err := s.httpsServer.Shutdown(ctx); err != nil {
-			lastErr = err
-		}
+	for _, srv := range s.listenServers {
+		shutdown(srv)
 	}
 
 	if s.unixServer != nil {
-		if err := s.unixServer.Shutdown(ctx); err != nil {
-			lastErr = err
-		}
+		shutdown(s.unixServer)
 	}
 
-	if err := s.store.Close(); err != nil {
-		lastErr = err
+	if s.sftpServer != nil {
+		if err := s.sftpServer.Close(); err != nil {
+			lastErr = err
+		}
 	}
 
-	// Flush and close MySQL store if active (prevents data loss from write-behind buffers).
-	if s.mysqlStore != nil {
-		if err := s.mysqlStore.Close(); err != nil {
+	s.playStats.Flush()
+	s.audit.Flush()
+	if drained {
+		if err := releaseStore(s.store); err != nil {
 			lastErr = err
 		}
+		// Flush and close MySQL store if active (prevents data loss from write-behind buffers).
+		if s.mysqlStore != nil {
+			if err := s.mysqlStore.Close(); err != nil {
+				lastErr = err
+			}
+		}
+	} else {
+		// Requests that outlived the drain still use the stores; closing
+		// them would fail those requests mid-write. Buffered MySQL writes
+		// are flushed so an exit right after this loses nothing.
+		log.Warn().Msg("Drain timed out; leaving the stores open for requests still running")
+		if s.mysqlStore != nil {
+			s.mysqlStore.Flush()
+		}
 	}
 
 	// Stop PasswdDAO background cleanup
@@ -532,11 +922,6 @@ func (s *Server) Shutdown(ctx context.Context) error {
 		s.passwdDAO.Stop()
 	}
 
-	// Clean up Unix socket
-	if s.cfg.IsUnixSocketEnabled() {
-		os.Remove(s.cfg.Scheme.UnixFile)
-	}
-
 	return lastErr
 }
 
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"path/filepath"
+	"sync"
+
+	"github.com/alist-encrypt-go/internal/storage"
+)
+
+// BoltDB locks its file for as long as it is open, so the server started by
+// a reload could not open the store while the previous one is still
+// draining. Servers in one process share the open store of a data dir
+// instead; it is closed when the last of them shuts down.
+var (
+	sharedStoresMu sync.Mutex
+	sharedStores   = map[string]*sharedStore{}
+)
+
+type sharedStore struct {
+	store *storage.Store
+	refs  int
+}
+
+// acquireStore returns the store for dataDir, opening it if no server
+// holds it yet.
+func acquireStore(dataDir string) (*storage.Store, error) {
+	key := filepath.Clean(dataDir)
+	sharedStoresMu.Lock()
+	defer sharedStoresMu.Unlock()
+	if shared, ok := sharedStores[key]; ok {
+		shared.refs++
+		return shared.store, nil
+	}
+	store, err := storage.NewStore(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	sharedStores[key] = &sharedStore{store: store, refs: 1}
+	return store, nil
+}
+
+// releaseStore drops one reference to store and closes it with the last.
+func releaseStore(store *storage.Store) error {
+	sharedStoresMu.Lock()
+	defer sharedStoresMu.Unlock()
+	for key, shared := range sharedStores {
+		if shared.store != store {
+			continue
+		}
+		if shared.refs--; shared.refs > 0 {
+			return nil
+		}
+		delete(sharedStores, key)
+		return store.Close()
+	}
+	return store.Close()
+}
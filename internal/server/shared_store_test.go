@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/alist-encrypt-go/internal/config"
+	"github.com/alist-encrypt-go/internal/storage"
+)
+
+func newStoreTestServer(t *testing.T, dataDir string) *Server {
+	t.Helper()
+	cfg := config.DefaultConfig()
+	cfg.DataDir = dataDir
+	cfg.JWTSecret = "test-secret"
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return s
+}
+
+func TestReloadedServerSharesStoreWithDrainingOne(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	dataDir := t.TempDir()
+
+	old := newStoreTestServer(t, dataDir)
+	// Without sharing, the BoltDB lock would keep this one waiting.
+	next := newStoreTestServer(t, dataDir)
+	if next.store != old.store {
+		t.Fatal("servers on the same data dir should share the store")
+	}
+	if err := old.Shutdown(context.Background()); err != nil {
+		t.Fatalf("old shutdown: %v", err)
+	}
+	if err := next.store.Set(storage.BucketConfig, "k", []byte("v")); err != nil {
+		t.Fatalf("store closed under the new server: %v", err)
+	}
+	if err := next.Shutdown(context.Background()); err != nil {
+		t.Fatalf("next shutdown: %v", err)
+	}
+	if err := next.store.Set(storage.BucketConfig, "k", []byte("v")); err == nil {
+		t.Fatal("store still open after the last server shut down")
+	}
+}
+
+func TestShutdownKeepsStoreOpenWhenDrainTimesOut(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := newStoreTestServer(t, t.TempDir())
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+		if err := s.store.Set(storage.BucketConfig, "late", []byte("write")); err != nil {
+			t.Errorf("late write failed: %v", err)
+		}
+	})}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(ln)
+	s.listenServers = append(s.listenServers, srv)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if resp, err := http.Get("http://" + ln.Addr().String() + "/slow"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-entered
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("shutdown err=%v, want deadline exceeded", err)
+	}
+	close(release)
+	<-done
+	if _, err := s.store.Get(storage.BucketConfig, "late"); err != nil {
+		t.Fatalf("store closed while a request was still running: %v", err)
+	}
+	srv.Close()
+	releaseStore(s.store)
+}
@@ -9,6 +9,15 @@ import (
 )
 
 func (s *Server) setupWebUIRoutes(r *gin.Engine) {
+	// A local override directory can still provide the UI without embedding.
+	if override := s.webUIOverrideFS(); override != nil {
+		r.StaticFS("/public", override)
+		r.StaticFS("/static", override)
+		r.GET("/index", func(c *gin.Context) {
+			c.Redirect(http.StatusFound, "/public/index.html")
+		})
+		return
+	}
 	r.GET("/index", func(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{
 			"code": 404,
@@ -11,8 +11,12 @@ import (
 )
 
 func (s *Server) setupWebUIRoutes(r *gin.Engine) {
-	r.StaticFS("/public", web.GetFileSystem())
-	r.StaticFS("/static", web.GetFileSystem())
+	fsys := web.GetFileSystem()
+	if override := s.webUIOverrideFS(); override != nil {
+		fsys = overlayFileSystem{override: override, base: fsys}
+	}
+	r.StaticFS("/public", fsys)
+	r.StaticFS("/static", fsys)
 	r.GET("/index", func(c *gin.Context) {
 		c.Redirect(http.StatusFound, "/public/index.html")
 	})
@@ -6,6 +6,9 @@ import (
 	"context"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/alist-encrypt-go/internal/config"
@@ -53,3 +56,31 @@ func TestGetBuildInfoRouteIsPublic(t *testing.T) {
 		t.Fatalf("status=%d, want %d; body=%s", rr.Code, http.StatusOK, rr.Body.String())
 	}
 }
+
+func TestSetupWebUIRoutesPrefersOverrideDirectory(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("custom-ui"), 0644); err != nil {
+		t.Fatalf("write override: %v", err)
+	}
+	cfg := config.DefaultConfig()
+	cfg.WebUIDir = dir
+	s := &Server{cfg: cfg}
+	r := gin.New()
+	s.setupWebUIRoutes(r)
+
+	req := httptest.NewRequest(http.MethodGet, "/public/", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "custom-ui") {
+		t.Fatalf("status=%d body=%q, want override content", rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/public/favicon.ico", nil)
+	rr = httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("embedded fallback status=%d, want %d", rr.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+
+	"github.com/rs/zerolog/log"
+)
+
+// webUIOverrideFS returns the configured web UI override directory, or nil
+// when none is configured or it is not a readable directory.
+func (s *Server) webUIOverrideFS() http.FileSystem {
+	if s.cfg == nil || s.cfg.WebUIDir == "" {
+		return nil
+	}
+	info, err := os.Stat(s.cfg.WebUIDir)
+	if err != nil || !info.IsDir() {
+		log.Warn().Err(err).Str("dir", s.cfg.WebUIDir).Msg("Web UI override directory unavailable, using embedded assets")
+		return nil
+	}
+	log.Info().Str("dir", s.cfg.WebUIDir).Msg("Serving web UI assets with local override directory")
+	return http.Dir(s.cfg.WebUIDir)
+}
+
+// overlayFileSystem serves files from override first and falls back to base
+// for anything the override does not contain.
+type overlayFileSystem struct {
+	override http.FileSystem
+	base     http.FileSystem
+}
+
+func (o overlayFileSystem) Open(name string) (http.File, error) {
+	f, err := o.override.Open(name)
+	if err == nil {
+		return f, nil
+	}
+	if o.base == nil || !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	return o.base.Open(name)
+}
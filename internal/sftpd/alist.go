@@ -0,0 +1,280 @@
+package sftpd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/alist-encrypt-go/internal/pathutil"
+)
+
+// internalOrigin is the origin of the in-process requests; nothing resolves
+// it, it only has to be a valid URL.
+const internalOrigin = "http://sftp.internal"
+
+// apiError is a non-200 answer from an Alist API call.
+type apiError struct {
+	Code    int
+	Message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("alist: %d %s", e.Code, e.Message)
+}
+
+// statusCode maps an error to the closest SFTP status.
+func statusCode(err error) uint32 {
+	var apiErr *apiError
+	switch {
+	case err == nil:
+		return fxOK
+	case !errors.As(err, &apiErr):
+		return fxFailure
+	case apiErr.Code == http.StatusUnauthorized || apiErr.Code == http.StatusForbidden:
+		return fxPermissionDenied
+	case apiErr.Code == http.StatusNotFound || strings.Contains(strings.ToLower(apiErr.Message), "not found"):
+		return fxNoSuchFile
+	default:
+		return fxFailure
+	}
+}
+
+// alistClient calls the proxy's own routes in-process with one user's Alist
+// token, so every request takes the same decrypting/encrypting path as a
+// browser or WebDAV client would.
+type alistClient struct {
+	handler    http.Handler
+	token      string
+	remoteAddr string
+}
+
+func (c *alistClient) newRequest(ctx context.Context, method, target string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, internalOrigin+target, body)
+	if err != nil {
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", c.token)
+	}
+	req.RemoteAddr = c.remoteAddr
+	return req, nil
+}
+
+// call POSTs payload to an Alist JSON API and decodes data into out.
+func (c *alistClient) call(ctx context.Context, endpoint string, payload, out interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := c.newRequest(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	rec := newBufferedResponse()
+	c.handler.ServeHTTP(rec, req)
+	return decodeEnvelope(rec, out)
+}
+
+func decodeEnvelope(rec *bufferedResponse, out interface{}) error {
+	var env struct {
+		Code    int             `json:"code"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(rec.body.Bytes(), &env); err != nil {
+		return &apiError{Code: rec.status, Message: strings.TrimSpace(rec.body.String())}
+	}
+	if env.Code != http.StatusOK {
+		return &apiError{Code: env.Code, Message: env.Message}
+	}
+	if out == nil || len(env.Data) == 0 || string(env.Data) == "null" {
+		return nil
+	}
+	return json.Unmarshal(env.Data, out)
+}
+
+// login exchanges an Alist username and password for a token.
+func (c *alistClient) login(ctx context.Context, username, password string) error {
+	var data struct {
+		Token string `json:"token"`
+	}
+	if err := c.call(ctx, "/api/auth/login", map[string]string{"username": username, "password": password}, &data); err != nil {
+		return err
+	}
+	if data.Token == "" {
+		return &apiError{Code: http.StatusUnauthorized, Message: "empty token"}
+	}
+	c.token = data.Token
+	return nil
+}
+
+func (c *alistClient) list(ctx context.Context, dir string) ([]fileInfo, error) {
+	var data struct {
+		Content []fileInfo `json:"content"`
+	}
+	err := c.call(ctx, "/api/fs/list", map[string]interface{}{
+		"path":     dir,
+		"password": "",
+		"page":     1,
+		"per_page": 0,
+		"refresh":  false,
+	}, &data)
+	return data.Content, err
+}
+
+func (c *alistClient) stat(ctx context.Context, p string) (fileInfo, error) {
+	var fi fileInfo
+	err := c.call(ctx, "/api/fs/get", map[string]interface{}{"path": p, "password": ""}, &fi)
+	if p == "/" {
+		fi.Name, fi.IsDir = "/", true
+	}
+	return fi, err
+}
+
+func (c *alistClient) mkdir(ctx context.Context, p string) error {
+	return c.call(ctx, "/api/fs/mkdir", map[string]string{"path": p}, nil)
+}
+
+func (c *alistClient) remove(ctx context.Context, p string) error {
+	return c.call(ctx, "/api/fs/remove", map[string]interface{}{"dir": path.Dir(p), "names": []string{path.Base(p)}}, nil)
+}
+
+func (c *alistClient) rename(ctx context.Context, from, to string) error {
+	if path.Dir(from) != path.Dir(to) {
+		if err := c.call(ctx, "/api/fs/move", map[string]interface{}{
+			"src_dir": path.Dir(from),
+			"dst_dir": path.Dir(to),
+			"names":   []string{path.Base(from)},
+		}, nil); err != nil {
+			return err
+		}
+		from = path.Join(path.Dir(to), path.Base(from))
+	}
+	if path.Base(from) == path.Base(to) {
+		return nil
+	}
+	return c.call(ctx, "/api/fs/rename", map[string]string{"path": from, "name": path.Base(to)}, nil)
+}
+
+// put uploads size bytes of body to p through the encrypting /api/fs/put.
+func (c *alistClient) put(ctx context.Context, p string, body io.Reader, size int64) error {
+	req, err := c.newRequest(ctx, http.MethodPut, "/api/fs/put", body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Length", strconv.FormatInt(size, 10))
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("File-Path", encodeURIComponent(p))
+	rec := newBufferedResponse()
+	c.handler.ServeHTTP(rec, req)
+	return decodeEnvelope(rec, nil)
+}
+
+// open streams the decrypted content of p from offset through /d.
+func (c *alistClient) open(ctx context.Context, p, sign string, offset int64) (io.ReadCloser, error) {
+	target := "/d" + (&url.URL{Path: p}).EscapedPath()
+	if sign != "" {
+		target += "?sign=" + url.QueryEscape(sign)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	req, err := c.newRequest(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+	pr, pw := io.Pipe()
+	resp := &streamedResponse{header: make(http.Header), ready: make(chan struct{}), pw: pw}
+	go func() {
+		c.handler.ServeHTTP(resp, req)
+		resp.WriteHeader(http.StatusOK)
+		_ = pw.Close()
+	}()
+	<-resp.ready
+
+	body := &cancelReadCloser{Reader: pr, close: func() {
+		cancel()
+		_ = pr.Close()
+	}}
+	switch {
+	case resp.status == http.StatusOK && offset == 0, resp.status == http.StatusPartialContent:
+		return body, nil
+	case resp.status == http.StatusRequestedRangeNotSatisfiable:
+		body.Close()
+		return nil, io.EOF
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(pr, 512))
+		body.Close()
+		return nil, &apiError{Code: resp.status, Message: strings.TrimSpace(string(msg))}
+	}
+}
+
+// encodeURIComponent matches what the Alist web UI sends in File-Path, which
+// both url.QueryUnescape and url.PathUnescape decode correctly.
+func encodeURIComponent(s string) string {
+	return pathutil.EscapeFilePath(s)
+}
+
+// bufferedResponse collects a small JSON API answer.
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedResponse() *bufferedResponse {
+	return &bufferedResponse{header: make(http.Header), status: http.StatusOK}
+}
+
+func (r *bufferedResponse) Header() http.Header         { return r.header }
+func (r *bufferedResponse) WriteHeader(status int)      { r.status = status }
+func (r *bufferedResponse) Write(p []byte) (int, error) { return r.body.Write(p) }
+
+// streamedResponse pipes a download to the reader as the handler writes it.
+type streamedResponse struct {
+	header http.Header
+	status int
+	once   sync.Once
+	ready  chan struct{}
+	pw     *io.PipeWriter
+}
+
+func (r *streamedResponse) Header() http.Header { return r.header }
+
+func (r *streamedResponse) WriteHeader(status int) {
+	r.once.Do(func() {
+		r.status = status
+		close(r.ready)
+	})
+}
+
+func (r *streamedResponse) Write(p []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.pw.Write(p)
+}
+
+func (r *streamedResponse) Flush() {}
+
+type cancelReadCloser struct {
+	io.Reader
+	close func()
+	once  sync.Once
+}
+
+func (c *cancelReadCloser) Close() error {
+	c.once.Do(c.close)
+	return nil
+}
@@ -0,0 +1,226 @@
+package sftpd
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// SFTP version 3 (draft-ietf-secsh-filexfer-02), the version every common
+// client negotiates down to.
+const sftpVersion = 3
+
+// Packet types.
+const (
+	fxpInit     = 1
+	fxpVersion  = 2
+	fxpOpen     = 3
+	fxpClose    = 4
+	fxpRead     = 5
+	fxpWrite    = 6
+	fxpLstat    = 7
+	fxpFstat    = 8
+	fxpSetstat  = 9
+	fxpFsetstat = 10
+	fxpOpendir  = 11
+	fxpReaddir  = 12
+	fxpRemove   = 13
+	fxpMkdir    = 14
+	fxpRmdir    = 15
+	fxpRealpath = 16
+	fxpStat     = 17
+	fxpRename   = 18
+	fxpStatus   = 101
+	fxpHandle   = 102
+	fxpData     = 103
+	fxpName     = 104
+	fxpAttrs    = 105
+)
+
+// Status codes.
+const (
+	fxOK               = 0
+	fxEOF              = 1
+	fxNoSuchFile       = 2
+	fxPermissionDenied = 3
+	fxFailure          = 4
+	fxBadMessage       = 5
+	fxOpUnsupported    = 8
+)
+
+// Open flags.
+const (
+	fxfRead   = 0x01
+	fxfWrite  = 0x02
+	fxfAppend = 0x04
+	fxfCreat  = 0x08
+	fxfTrunc  = 0x10
+	fxfExcl   = 0x20
+)
+
+// Attribute flags.
+const (
+	attrSize        = 0x00000001
+	attrUIDGID      = 0x00000002
+	attrPermissions = 0x00000004
+	attrACModTime   = 0x00000008
+	attrExtended    = 0x80000000
+)
+
+// maxPacket bounds a single request; clients write in 32 KiB pieces.
+const maxPacket = 256 * 1024
+
+var errShortPacket = errors.New("sftp: short packet")
+
+func readPacket(r io.Reader) (byte, []byte, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return 0, nil, err
+	}
+	length := binary.BigEndian.Uint32(head[:])
+	if length == 0 || length > maxPacket {
+		return 0, nil, errShortPacket
+	}
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return 0, nil, err
+	}
+	return buf[0], buf[1:], nil
+}
+
+// packet builds an outgoing message.
+type packet struct {
+	b []byte
+}
+
+func newPacket(kind byte) *packet {
+	return &packet{b: []byte{0, 0, 0, 0, kind}}
+}
+
+func (p *packet) u32(v uint32) *packet {
+	p.b = binary.BigEndian.AppendUint32(p.b, v)
+	return p
+}
+
+func (p *packet) u64(v uint64) *packet {
+	p.b = binary.BigEndian.AppendUint64(p.b, v)
+	return p
+}
+
+func (p *packet) str(s string) *packet {
+	return p.bytes([]byte(s))
+}
+
+func (p *packet) bytes(b []byte) *packet {
+	p.u32(uint32(len(b)))
+	p.b = append(p.b, b...)
+	return p
+}
+
+func (p *packet) attrs(fi fileInfo) *packet {
+	p.u32(attrSize | attrPermissions | attrACModTime)
+	p.u64(uint64(fi.Size))
+	p.u32(fi.mode())
+	mtime := uint32(fi.Modified.Unix())
+	return p.u32(mtime).u32(mtime)
+}
+
+func (p *packet) encode() []byte {
+	binary.BigEndian.PutUint32(p.b, uint32(len(p.b)-4))
+	return p.b
+}
+
+// reader decodes an incoming payload; the first error sticks.
+type reader struct {
+	b   []byte
+	err error
+}
+
+func (r *reader) u32() uint32 {
+	if r.err != nil || len(r.b) < 4 {
+		r.err = errShortPacket
+		return 0
+	}
+	v := binary.BigEndian.Uint32(r.b)
+	r.b = r.b[4:]
+	return v
+}
+
+func (r *reader) u64() uint64 {
+	if r.err != nil || len(r.b) < 8 {
+		r.err = errShortPacket
+		return 0
+	}
+	v := binary.BigEndian.Uint64(r.b)
+	r.b = r.b[8:]
+	return v
+}
+
+func (r *reader) bytes() []byte {
+	n := r.u32()
+	if r.err != nil || uint32(len(r.b)) < n {
+		r.err = errShortPacket
+		return nil
+	}
+	v := r.b[:n]
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *reader) str() string {
+	return string(r.bytes())
+}
+
+// skipAttrs consumes an ATTRS block; sizes, owners and times sent by the
+// client are not applied to Alist.
+func (r *reader) skipAttrs() {
+	flags := r.u32()
+	if flags&attrSize != 0 {
+		r.u64()
+	}
+	if flags&attrUIDGID != 0 {
+		r.u32()
+		r.u32()
+	}
+	if flags&attrPermissions != 0 {
+		r.u32()
+	}
+	if flags&attrACModTime != 0 {
+		r.u32()
+		r.u32()
+	}
+	if flags&attrExtended != 0 {
+		for n := r.u32(); n > 0 && r.err == nil; n-- {
+			r.str()
+			r.str()
+		}
+	}
+}
+
+// fileInfo is one entry as Alist reports it, names already decrypted.
+type fileInfo struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	IsDir    bool      `json:"is_dir"`
+	Modified time.Time `json:"modified"`
+	Sign     string    `json:"sign"`
+}
+
+func (fi fileInfo) mode() uint32 {
+	if fi.IsDir {
+		return uint32(0o040755)
+	}
+	return uint32(0o100644)
+}
+
+// longName is the ls -l style line clients such as the OpenSSH sftp CLI print.
+func (fi fileInfo) longName() string {
+	perm := os.FileMode(0o644).String()
+	if fi.IsDir {
+		perm = (os.ModeDir | 0o755).String()
+	}
+	return fmt.Sprintf("%s 1 alist alist %12d %s %s", perm, fi.Size, fi.Modified.Format("Jan _2 15:04"), fi.Name)
+}
@@ -0,0 +1,230 @@
+// Package sftpd serves the proxy's decrypted view of Alist over SFTP. Each
+// operation is replayed against the proxy's own HTTP handlers, so listings,
+// downloads and uploads get the same name and content encryption as the web
+// UI and WebDAV.
+package sftpd
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/crypto/ssh"
+)
+
+// tokenExtension carries the Alist token from the password check to the
+// session in ssh.Permissions.
+const tokenExtension = "alist-token"
+
+// Server accepts SSH connections and runs the sftp subsystem on them.
+type Server struct {
+	handler http.Handler
+	sshCfg  *ssh.ServerConfig
+	tempDir string
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+}
+
+// New creates a server that forwards to handler. The host key is read from
+// hostKeyPath, or generated there (ed25519) on first start. Uploads are
+// spooled in tempDir until the client closes the file.
+func New(handler http.Handler, hostKeyPath, tempDir string) (*Server, error) {
+	signer, err := loadOrCreateHostKey(hostKeyPath)
+	if err != nil {
+		return nil, err
+	}
+	if tempDir == "" {
+		tempDir = os.TempDir()
+	}
+	if err := os.MkdirAll(tempDir, 0o700); err != nil {
+		return nil, fmt.Errorf("create sftp temp dir: %w", err)
+	}
+	s := &Server{handler: handler, tempDir: tempDir, conns: make(map[net.Conn]struct{})}
+	s.sshCfg = &ssh.ServerConfig{PasswordCallback: s.checkPassword}
+	s.sshCfg.AddHostKey(signer)
+	return s, nil
+}
+
+// checkPassword logs the user into Alist through the proxy; the token it
+// returns authorizes every request of the connection.
+func (s *Server) checkPassword(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+	client := &alistClient{handler: s.handler, remoteAddr: meta.RemoteAddr().String()}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := client.login(ctx, meta.User(), string(password)); err != nil {
+		log.Warn().Err(err).Str("user", meta.User()).Str("remote", meta.RemoteAddr().String()).Msg("SFTP login rejected")
+		return nil, errors.New("invalid credentials")
+	}
+	return &ssh.Permissions{Extensions: map[string]string{tokenExtension: client.token}}, nil
+}
+
+// ListenAndServe listens on addr and serves until Close.
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	log.Info().Str("addr", addr).Msg("Starting SFTP server")
+	return s.Serve(l)
+}
+
+// Serve accepts connections on l until Close. It returns nil after Close.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return nil
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return err
+		}
+		go s.serveConn(conn)
+	}
+}
+
+// Close stops the listener and drops open connections; unfinished uploads
+// are discarded.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for conn := range s.conns {
+		conn.Close()
+	}
+	if s.listener != nil {
+		return s.listener.Close()
+	}
+	return nil
+}
+
+func (s *Server) track(conn net.Conn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		if s.closed {
+			return false
+		}
+		s.conns[conn] = struct{}{}
+	} else {
+		delete(s.conns, conn)
+	}
+	return true
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	if !s.track(conn, true) {
+		conn.Close()
+		return
+	}
+	defer s.track(conn, false)
+	defer conn.Close()
+
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, s.sshCfg)
+	if err != nil {
+		log.Debug().Err(err).Str("remote", conn.RemoteAddr().String()).Msg("SFTP handshake failed")
+		return
+	}
+	defer sshConn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &alistClient{
+		handler:    s.handler,
+		token:      sshConn.Permissions.Extensions[tokenExtension],
+		remoteAddr: conn.RemoteAddr().String(),
+	}
+	log.Info().Str("user", sshConn.User()).Str("remote", conn.RemoteAddr().String()).Msg("SFTP client connected")
+
+	for newCh := range chans {
+		if newCh.ChannelType() != "session" {
+			newCh.Reject(ssh.UnknownChannelType, "only session channels are supported")
+			continue
+		}
+		ch, requests, err := newCh.Accept()
+		if err != nil {
+			continue
+		}
+		go s.serveChannel(ctx, client, ch, requests)
+	}
+}
+
+// serveChannel waits for the "sftp" subsystem request and runs the session;
+// shells, exec and port forwarding are refused.
+func (s *Server) serveChannel(ctx context.Context, client *alistClient, ch ssh.Channel, requests <-chan *ssh.Request) {
+	defer ch.Close()
+	for req := range requests {
+		ok := req.Type == "subsystem" && isSFTPSubsystem(req.Payload)
+		req.Reply(ok, nil)
+		if !ok {
+			continue
+		}
+		go ssh.DiscardRequests(requests)
+		sess := &session{ctx: ctx, client: client, rw: ch, tempDir: s.tempDir, handles: make(map[string]interface{})}
+		if err := sess.serve(); err != nil {
+			log.Debug().Err(err).Msg("SFTP session ended")
+		}
+		return
+	}
+}
+
+func isSFTPSubsystem(payload []byte) bool {
+	r := &reader{b: payload}
+	return r.str() == "sftp" && r.err == nil
+}
+
+func loadOrCreateHostKey(keyPath string) (ssh.Signer, error) {
+	data, err := os.ReadFile(keyPath)
+	if err == nil {
+		signer, err := ssh.ParsePrivateKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("parse sftp host key %s: %w", keyPath, err)
+		}
+		return signer, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("read sftp host key: %w", err)
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("generate sftp host key: %w", err)
+	}
+	block, err := ssh.MarshalPrivateKey(key, "alist-encrypt-go sftp")
+	if err != nil {
+		return nil, fmt.Errorf("encode sftp host key: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0o755); err != nil {
+		return nil, fmt.Errorf("create sftp host key dir: %w", err)
+	}
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0o600); err != nil {
+		return nil, fmt.Errorf("write sftp host key: %w", err)
+	}
+	log.Info().Str("path", keyPath).Msg("Generated SFTP host key")
+	return ssh.NewSignerFromKey(key)
+}
@@ -0,0 +1,321 @@
+package sftpd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/alist-encrypt-go/internal/pathutil"
+)
+
+// readdirBatch is how many names one READDIR answer carries.
+const readdirBatch = 128
+
+// maxReadChunk caps one READ answer regardless of what the client asks for.
+const maxReadChunk = 64 * 1024
+
+type dirHandle struct {
+	entries []fileInfo
+}
+
+// readHandle keeps one decrypted download open while the client reads it
+// front to back, reopening at the new offset only when it seeks.
+type readHandle struct {
+	path string
+	info fileInfo
+	body io.ReadCloser
+	pos  int64
+}
+
+// writeHandle spools a file to disk: the encrypting upload needs the final
+// size up front, and clients may write blocks out of order.
+type writeHandle struct {
+	path string
+	file *os.File
+}
+
+// session serves one SFTP subsystem channel. Requests are handled in order,
+// which keeps a sequential download on a single upstream stream.
+type session struct {
+	ctx     context.Context
+	client  *alistClient
+	rw      io.ReadWriter
+	tempDir string
+	handles map[string]interface{}
+	nextID  uint64
+}
+
+func (s *session) serve() error {
+	defer s.closeAll()
+	for {
+		kind, payload, err := readPacket(s.rw)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if kind == fxpInit {
+			if err := s.send(newPacket(fxpVersion).u32(sftpVersion)); err != nil {
+				return err
+			}
+			continue
+		}
+		r := &reader{b: payload}
+		id := r.u32()
+		if r.err != nil {
+			return r.err
+		}
+		if err := s.handle(kind, id, r); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *session) send(p *packet) error {
+	_, err := s.rw.Write(p.encode())
+	return err
+}
+
+func (s *session) status(id uint32, err error) error {
+	code := statusCode(err)
+	msg := "OK"
+	if err != nil {
+		msg = err.Error()
+		if err == io.EOF {
+			code, msg = fxEOF, "EOF"
+		}
+	}
+	return s.sendStatus(id, code, msg)
+}
+
+func (s *session) sendStatus(id, code uint32, msg string) error {
+	return s.send(newPacket(fxpStatus).u32(id).u32(code).str(msg).str(""))
+}
+
+func (s *session) handle(kind byte, id uint32, r *reader) error {
+	switch kind {
+	case fxpRealpath:
+		p := cleanPath(r.str())
+		// Attributes are optional here; flags 0 sends none.
+		return s.send(newPacket(fxpName).u32(id).u32(1).str(p).str(p).u32(0))
+	case fxpStat, fxpLstat:
+		p := cleanPath(r.str())
+		fi, err := s.client.stat(s.ctx, p)
+		if err != nil {
+			return s.status(id, err)
+		}
+		return s.send(newPacket(fxpAttrs).u32(id).attrs(fi))
+	case fxpFstat:
+		switch h := s.handles[r.str()].(type) {
+		case *readHandle:
+			return s.send(newPacket(fxpAttrs).u32(id).attrs(h.info))
+		case *writeHandle:
+			st, err := h.file.Stat()
+			if err != nil {
+				return s.status(id, err)
+			}
+			return s.send(newPacket(fxpAttrs).u32(id).attrs(fileInfo{Name: path.Base(h.path), Size: st.Size(), Modified: st.ModTime()}))
+		}
+		return s.sendStatus(id, fxFailure, "invalid handle")
+	case fxpSetstat, fxpFsetstat:
+		// Alist has no notion of modes or times; accept so copies succeed.
+		return s.status(id, nil)
+	case fxpOpendir:
+		p := cleanPath(r.str())
+		entries, err := s.client.list(s.ctx, p)
+		if err != nil {
+			return s.status(id, err)
+		}
+		return s.sendHandle(id, &dirHandle{entries: entries})
+	case fxpReaddir:
+		h, ok := s.handles[r.str()].(*dirHandle)
+		if !ok {
+			return s.sendStatus(id, fxFailure, "invalid handle")
+		}
+		if len(h.entries) == 0 {
+			return s.status(id, io.EOF)
+		}
+		batch := h.entries
+		if len(batch) > readdirBatch {
+			batch = batch[:readdirBatch]
+		}
+		h.entries = h.entries[len(batch):]
+		p := newPacket(fxpName).u32(id).u32(uint32(len(batch)))
+		for _, fi := range batch {
+			p.str(fi.Name).str(fi.longName()).attrs(fi)
+		}
+		return s.send(p)
+	case fxpOpen:
+		p := cleanPath(r.str())
+		flags := r.u32()
+		r.skipAttrs()
+		if r.err != nil {
+			return s.sendStatus(id, fxBadMessage, r.err.Error())
+		}
+		return s.open(id, p, flags)
+	case fxpRead:
+		handle := r.str()
+		offset := int64(r.u64())
+		length := r.u32()
+		h, ok := s.handles[handle].(*readHandle)
+		if !ok {
+			return s.sendStatus(id, fxFailure, "invalid handle")
+		}
+		data, err := s.read(h, offset, length)
+		if err != nil {
+			return s.status(id, err)
+		}
+		return s.send(newPacket(fxpData).u32(id).bytes(data))
+	case fxpWrite:
+		handle := r.str()
+		offset := int64(r.u64())
+		data := r.bytes()
+		h, ok := s.handles[handle].(*writeHandle)
+		if !ok || r.err != nil {
+			return s.sendStatus(id, fxFailure, "invalid handle")
+		}
+		_, err := h.file.WriteAt(data, offset)
+		return s.status(id, err)
+	case fxpClose:
+		handle := r.str()
+		h, ok := s.handles[handle]
+		if !ok {
+			return s.sendStatus(id, fxFailure, "invalid handle")
+		}
+		delete(s.handles, handle)
+		return s.status(id, s.closeHandle(h))
+	case fxpMkdir:
+		p := cleanPath(r.str())
+		return s.status(id, s.client.mkdir(s.ctx, p))
+	case fxpRemove, fxpRmdir:
+		p := cleanPath(r.str())
+		return s.status(id, s.client.remove(s.ctx, p))
+	case fxpRename:
+		from, to := cleanPath(r.str()), cleanPath(r.str())
+		return s.status(id, s.client.rename(s.ctx, from, to))
+	default:
+		return s.sendStatus(id, fxOpUnsupported, "unsupported request")
+	}
+}
+
+func (s *session) sendHandle(id uint32, h interface{}) error {
+	s.nextID++
+	key := strconv.FormatUint(s.nextID, 10)
+	s.handles[key] = h
+	return s.send(newPacket(fxpHandle).u32(id).str(key))
+}
+
+func (s *session) open(id uint32, p string, flags uint32) error {
+	if flags&(fxfWrite|fxfAppend|fxfCreat|fxfTrunc) == 0 {
+		fi, err := s.client.stat(s.ctx, p)
+		if err != nil {
+			return s.status(id, err)
+		}
+		if fi.IsDir {
+			return s.sendStatus(id, fxFailure, "is a directory")
+		}
+		return s.sendHandle(id, &readHandle{path: p, info: fi})
+	}
+	// Writes always produce a whole new file: an encrypted object cannot be
+	// modified in place, so O_APPEND and partial rewrites are not offered.
+	if flags&fxfAppend != 0 {
+		return s.sendStatus(id, fxOpUnsupported, "append is not supported")
+	}
+	if flags&fxfExcl != 0 {
+		if _, err := s.client.stat(s.ctx, p); err == nil {
+			return s.sendStatus(id, fxFailure, "file exists")
+		}
+	}
+	f, err := os.CreateTemp(s.tempDir, "sftp-upload-*")
+	if err != nil {
+		return s.status(id, err)
+	}
+	return s.sendHandle(id, &writeHandle{path: p, file: f})
+}
+
+func (s *session) read(h *readHandle, offset int64, length uint32) ([]byte, error) {
+	if offset >= h.info.Size {
+		return nil, io.EOF
+	}
+	if h.body == nil || h.pos != offset {
+		if h.body != nil {
+			h.body.Close()
+		}
+		body, err := s.client.open(s.ctx, h.path, h.info.Sign, offset)
+		if err != nil {
+			h.body = nil
+			return nil, err
+		}
+		h.body, h.pos = body, offset
+	}
+	if length > maxReadChunk {
+		length = maxReadChunk
+	}
+	buf := make([]byte, length)
+	n, err := io.ReadFull(h.body, buf)
+	h.pos += int64(n)
+	if n > 0 {
+		return buf[:n], nil
+	}
+	if (err == io.EOF || err == io.ErrUnexpectedEOF) && h.pos < h.info.Size {
+		// The pipe only says EOF when the download handler gave up midway.
+		return nil, fmt.Errorf("download ended at %d of %d bytes", h.pos, h.info.Size)
+	}
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	return nil, err
+}
+
+func (s *session) closeHandle(h interface{}) error {
+	switch h := h.(type) {
+	case *readHandle:
+		if h.body != nil {
+			h.body.Close()
+		}
+	case *writeHandle:
+		defer os.Remove(h.file.Name())
+		defer h.file.Close()
+		st, err := h.file.Stat()
+		if err != nil {
+			return err
+		}
+		if _, err := h.file.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		if err := s.client.put(s.ctx, h.path, h.file, st.Size()); err != nil {
+			log.Warn().Err(err).Str("path", h.path).Msg("SFTP upload failed")
+			return err
+		}
+		log.Info().Str("path", h.path).Int64("size", st.Size()).Msg("SFTP upload finished")
+	}
+	return nil
+}
+
+// closeAll drops what a disconnecting client left open. Half-written
+// uploads are discarded rather than committed.
+func (s *session) closeAll() {
+	for key, h := range s.handles {
+		switch h := h.(type) {
+		case *readHandle:
+			if h.body != nil {
+				h.body.Close()
+			}
+		case *writeHandle:
+			h.file.Close()
+			os.Remove(h.file.Name())
+		}
+		delete(s.handles, key)
+	}
+}
+
+func cleanPath(p string) string {
+	return pathutil.Clean(p)
+}
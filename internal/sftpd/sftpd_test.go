@@ -0,0 +1,221 @@
+package sftpd
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// fakeProxy stands in for the gin engine: one user, one directory with one
+// file, and an upload sink.
+type fakeProxy struct {
+	mu      sync.Mutex
+	content []byte
+	uploads map[string][]byte
+}
+
+func (f *fakeProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	writeEnvelope := func(code int, data interface{}) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"code": code, "message": "", "data": data})
+	}
+	if r.URL.Path != "/api/auth/login" && r.Header.Get("Authorization") != "tok" {
+		writeEnvelope(401, nil)
+		return
+	}
+	var body map[string]interface{}
+	if r.Method == http.MethodPost {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+	switch {
+	case r.URL.Path == "/api/auth/login":
+		if body["username"] != "alice" || body["password"] != "secret" {
+			writeEnvelope(400, nil)
+			return
+		}
+		writeEnvelope(200, map[string]string{"token": "tok"})
+	case r.URL.Path == "/api/fs/list":
+		writeEnvelope(200, map[string]interface{}{"content": []map[string]interface{}{
+			{"name": "notes.txt", "size": len(f.content), "is_dir": false, "sign": "s1"},
+			{"name": "photos", "is_dir": true},
+		}})
+	case r.URL.Path == "/api/fs/get":
+		if body["path"] != "/docs/notes.txt" {
+			writeEnvelope(500, nil)
+			return
+		}
+		writeEnvelope(200, map[string]interface{}{"name": "notes.txt", "size": len(f.content), "sign": "s1"})
+	case r.URL.Path == "/d/docs/notes.txt":
+		if r.URL.Query().Get("sign") != "s1" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		data := f.content
+		if rng := r.Header.Get("Range"); rng != "" {
+			off, _ := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(rng, "bytes="), "-"))
+			data = data[off:]
+			w.WriteHeader(http.StatusPartialContent)
+		}
+		w.Write(data)
+	case r.URL.Path == "/api/fs/put":
+		data, _ := io.ReadAll(r.Body)
+		f.mu.Lock()
+		f.uploads[r.Header.Get("File-Path")] = data
+		f.mu.Unlock()
+		writeEnvelope(200, nil)
+	default:
+		writeEnvelope(404, nil)
+	}
+}
+
+// testClient speaks just enough SFTP to drive the server.
+type testClient struct {
+	t      *testing.T
+	rw     io.ReadWriter
+	nextID uint32
+}
+
+func (c *testClient) call(p *packet) (byte, *reader) {
+	c.t.Helper()
+	if _, err := c.rw.Write(p.encode()); err != nil {
+		c.t.Fatal(err)
+	}
+	kind, payload, err := readPacket(c.rw)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	r := &reader{b: payload}
+	r.u32() // request id
+	return kind, r
+}
+
+func (c *testClient) request(kind byte) *packet {
+	c.nextID++
+	return newPacket(kind).u32(c.nextID)
+}
+
+func (c *testClient) handle(kind byte, r *reader) string {
+	c.t.Helper()
+	if kind != fxpHandle {
+		c.t.Fatalf("expected handle, got %d (status %d)", kind, r.u32())
+	}
+	return r.str()
+}
+
+func startTestServer(t *testing.T, handler http.Handler) string {
+	t.Helper()
+	srv, err := New(handler, filepath.Join(t.TempDir(), "host_key"), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+	t.Cleanup(func() { srv.Close() })
+	return l.Addr().String()
+}
+
+func dial(t *testing.T, addr, password string) (*testClient, error) {
+	t.Helper()
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            "alice",
+		Auth:            []ssh.AuthMethod{ssh.Password(password)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	t.Cleanup(func() { conn.Close() })
+	sess, err := conn.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin, _ := sess.StdinPipe()
+	stdout, _ := sess.StdoutPipe()
+	if err := sess.RequestSubsystem("sftp"); err != nil {
+		t.Fatal(err)
+	}
+	c := &testClient{t: t, rw: struct {
+		io.Reader
+		io.Writer
+	}{stdout, stdin}}
+	if _, err := c.rw.Write(newPacket(fxpInit).u32(sftpVersion).encode()); err != nil {
+		t.Fatal(err)
+	}
+	if kind, _, err := readPacket(c.rw); err != nil || kind != fxpVersion {
+		t.Fatalf("init answered %d: %v", kind, err)
+	}
+	return c, nil
+}
+
+func TestSFTPRejectsBadPassword(t *testing.T) {
+	addr := startTestServer(t, &fakeProxy{uploads: map[string][]byte{}})
+	if _, err := dial(t, addr, "wrong"); err == nil {
+		t.Fatal("login with a wrong password succeeded")
+	}
+}
+
+func TestSFTPListReadWrite(t *testing.T) {
+	fake := &fakeProxy{content: []byte("hello, decrypted world"), uploads: map[string][]byte{}}
+	c, err := dial(t, startTestServer(t, fake), "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := c.handle(c.call(c.request(fxpOpendir).str("/docs")))
+	kind, r := c.call(c.request(fxpReaddir).str(dir))
+	if kind != fxpName || r.u32() != 2 {
+		t.Fatalf("readdir answered %d", kind)
+	}
+	if name := r.str(); name != "notes.txt" {
+		t.Fatalf("first entry %q", name)
+	}
+	if kind, r := c.call(c.request(fxpReaddir).str(dir)); kind != fxpStatus || r.u32() != fxEOF {
+		t.Fatal("second readdir should report EOF")
+	}
+
+	file := c.handle(c.call(c.request(fxpOpen).str("/docs/notes.txt").u32(fxfRead).u32(0)))
+	read := func(off uint64, n uint32) string {
+		kind, r := c.call(c.request(fxpRead).str(file).u64(off).u32(n))
+		if kind != fxpData {
+			t.Fatalf("read at %d answered %d (status %d)", off, kind, r.u32())
+		}
+		return string(r.bytes())
+	}
+	if got := read(0, 5); got != "hello" {
+		t.Fatalf("read %q", got)
+	}
+	if got := read(5, 7); got != ", decry" {
+		t.Fatalf("sequential read %q", got)
+	}
+	if got := read(16, 100); got != " world" {
+		t.Fatalf("seek read %q", got)
+	}
+	if kind, r := c.call(c.request(fxpRead).str(file).u64(22).u32(10)); kind != fxpStatus || r.u32() != fxEOF {
+		t.Fatal("read past the end should report EOF")
+	}
+	c.call(c.request(fxpClose).str(file))
+
+	upload := c.handle(c.call(c.request(fxpOpen).str("/docs/new file.txt").u32(fxfWrite | fxfCreat | fxfTrunc).u32(0)))
+	c.call(c.request(fxpWrite).str(upload).u64(6).bytes([]byte("world")))
+	c.call(c.request(fxpWrite).str(upload).u64(0).bytes([]byte("hello ")))
+	if kind, r := c.call(c.request(fxpClose).str(upload)); kind != fxpStatus || r.u32() != fxOK {
+		t.Fatal("close of the upload failed")
+	}
+	fake.mu.Lock()
+	got := fake.uploads["%2Fdocs%2Fnew%20file.txt"]
+	fake.mu.Unlock()
+	if !bytes.Equal(got, []byte("hello world")) {
+		t.Fatalf("uploaded %q (%v)", got, fake.uploads)
+	}
+}
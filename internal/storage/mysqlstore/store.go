@@ -129,10 +129,18 @@ func (s *Store) Close() error {
 	}
 	// Flush all pending write-behind buffers before closing the connection,
 	// otherwise data buffered within the flush interval window will be lost.
+	s.Flush()
+	return s.db.Close()
+}
+
+// Flush writes the write-behind buffers out now, leaving the store open.
+func (s *Store) Flush() {
+	if s == nil || s.db == nil {
+		return
+	}
 	flushCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	s.flushBuffers(flushCtx)
-	return s.db.Close()
 }
 
 func (s *Store) startLoops(ctx context.Context) {
@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	dbFileName     = "alist-encrypt.db"
+	backupDirName  = "backups"
+	backupPrefix   = "alist-encrypt-"
+	backupKeep     = 3
+	backupInterval = 24 * time.Hour
+)
+
+// storeOpenTimeout bounds how long NewStore waits for another process to
+// release the database lock. A variable so tests can shorten it.
+var storeOpenTimeout = 30 * time.Second
+
+// openWithRetry opens dbPath, retrying while the file lock is held elsewhere.
+func openWithRetry(dbPath string, timeout time.Duration) (*bolt.DB, error) {
+	deadline := time.Now().Add(timeout)
+	wait := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: wait})
+		if err == nil || !errors.Is(err, bolt.ErrTimeout) || time.Now().After(deadline) {
+			return db, err
+		}
+		log.Warn().Str("path", dbPath).Int("attempt", attempt).Msg("Database is locked by another process, retrying")
+		if wait < 4*time.Second {
+			wait *= 2
+		}
+	}
+}
+
+// restoreFromBackup moves a database that failed to open aside and tries the
+// backup snapshots newest first. When none opens, the original file is put
+// back so the next start still sees it instead of silently starting empty.
+func restoreFromBackup(dataDir, dbPath string, openErr error) (*bolt.DB, error) {
+	backups := listBackups(dataDir)
+	if len(backups) == 0 {
+		return nil, fmt.Errorf("failed to open database: %w (no backup snapshot to restore)", openErr)
+	}
+	aside := fmt.Sprintf("%s.corrupt-%s", dbPath, time.Now().Format("20060102-150405"))
+	if err := os.Rename(dbPath, aside); err != nil {
+		return nil, fmt.Errorf("failed to open database: %w (cannot move it aside: %v)", openErr, err)
+	}
+	for _, backup := range backups {
+		if err := copyFile(backup, dbPath); err != nil {
+			continue
+		}
+		db, err := bolt.Open(dbPath, 0600, &bolt.Options{Timeout: time.Second})
+		if err == nil {
+			log.Error().
+				Err(openErr).
+				Str("corrupt_copy", aside).
+				Str("restored_from", backup).
+				Msg("Database was unreadable and has been restored from the latest backup snapshot; changes made after that snapshot are lost")
+			return db, nil
+		}
+	}
+	os.Remove(dbPath)
+	if err := os.Rename(aside, dbPath); err != nil {
+		log.Error().Err(err).Str("path", aside).Msg("Failed to move unreadable database back into place")
+	}
+	return nil, fmt.Errorf("failed to open database: %w (no usable backup snapshot)", openErr)
+}
+
+// openDegraded runs the store on a temporary copy of the database (or of
+// its newest backup) so reads keep working while the real file is locked or
+// unreadable.
+func openDegraded(dataDir, dbPath string, cause error) (*Store, error) {
+	tmp, err := os.CreateTemp("", "alist-encrypt-degraded-*.db")
+	if err != nil {
+		return nil, fmt.Errorf("%w; degraded mode unavailable: %v", cause, err)
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+
+	var db *bolt.DB
+	for _, seed := range append([]string{dbPath}, listBackups(dataDir)...) {
+		if copyFile(seed, tmpPath) != nil {
+			continue
+		}
+		if db, err = bolt.Open(tmpPath, 0600, &bolt.Options{Timeout: time.Second}); err == nil {
+			break
+		}
+	}
+	if db == nil {
+		os.Remove(tmpPath)
+		if db, err = bolt.Open(tmpPath, 0600, &bolt.Options{Timeout: time.Second}); err != nil {
+			os.Remove(tmpPath)
+			return nil, fmt.Errorf("%w; degraded mode unavailable: %v", cause, err)
+		}
+	}
+
+	store := &Store{
+		db:            db,
+		path:          tmpPath,
+		degraded:      cause.Error(),
+		ephemeralPath: tmpPath,
+	}
+	if err := store.initBuckets(); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("%w; degraded mode unavailable: %v", cause, err)
+	}
+
+	log.Error().Msg("==================================================================")
+	log.Error().Err(cause).Str("path", dbPath).Msg("DATABASE UNAVAILABLE: running in DEGRADED mode on a temporary copy")
+	log.Error().Msg("Reads keep working, but settings, users and caches changed now are LOST on restart")
+	log.Error().Msg("==================================================================")
+	return store, nil
+}
+
+// snapshotBackup copies the open database into dataDir/backups at most once
+// per backupInterval and prunes old snapshots beyond backupKeep.
+func (s *Store) snapshotBackup(dataDir string) {
+	backups := listBackups(dataDir)
+	if len(backups) > 0 {
+		if info, err := os.Stat(backups[0]); err == nil && time.Since(info.ModTime()) < backupInterval {
+			return
+		}
+	}
+	dir := filepath.Join(dataDir, backupDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Warn().Err(err).Msg("Failed to create database backup directory")
+		return
+	}
+	target := filepath.Join(dir, backupPrefix+time.Now().Format("20060102-150405")+".db")
+	tmp := target + ".tmp"
+	if err := s.db.View(func(tx *bolt.Tx) error { return tx.CopyFile(tmp, 0600) }); err != nil {
+		os.Remove(tmp)
+		log.Warn().Err(err).Msg("Failed to write database backup snapshot")
+		return
+	}
+	if err := os.Rename(tmp, target); err != nil {
+		os.Remove(tmp)
+		log.Warn().Err(err).Msg("Failed to write database backup snapshot")
+		return
+	}
+	if all := listBackups(dataDir); len(all) > backupKeep {
+		for _, old := range all[backupKeep:] {
+			os.Remove(old)
+		}
+	}
+}
+
+// listBackups returns backup snapshots newest first.
+func listBackups(dataDir string) []string {
+	entries, err := os.ReadDir(filepath.Join(dataDir, backupDirName))
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, backupPrefix) || !strings.HasSuffix(name, ".db") {
+			continue
+		}
+		out = append(out, filepath.Join(dataDir, backupDirName, name))
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(out)))
+	return out
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -2,9 +2,11 @@ package storage
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	bolt "go.etcd.io/bbolt"
 )
@@ -17,12 +19,25 @@ var (
 	BucketFileInfo = []byte("fileinfo")
 	BucketFileSize = []byte("filesize")
 	BucketDirSync  = []byte("dirsync")
+	BucketPlayback = []byte("playback")
+	BucketPrefs    = []byte("preferences")
+	BucketHashes   = []byte("contenthash")
+	BucketAudit    = []byte("audit")
+	BucketTokens   = []byte("tokens")
+	BucketGuests   = []byte("guestcodes")
+	BucketUploads  = []byte("uploadjournal")
+	BucketNameKDF  = []byte("namekdfjobs")
 )
 
 // Store represents the BoltDB storage
 type Store struct {
 	db   *bolt.DB
 	path string
+
+	// degraded is non-empty when the real database could not be opened and
+	// the store runs on a throwaway copy; ephemeralPath is that copy.
+	degraded      string
+	ephemeralPath string
 }
 
 // BucketTx exposes scoped operations within a single BoltDB write transaction.
@@ -30,16 +45,24 @@ type BucketTx struct {
 	b *bolt.Bucket
 }
 
-// NewStore creates a new BoltDB store
+// NewStore creates a new BoltDB store.
+//
+// A database locked by another process is retried until storeOpenTimeout; a
+// corrupt one is set aside and replaced by the newest backup snapshot. If
+// neither works the store falls back to a temporary copy so the proxy can keep
+// serving reads; see DegradedReason.
 func NewStore(dataDir string) (*Store, error) {
 	if err := os.MkdirAll(dataDir, 0755); err != nil {
 		return nil, fmt.Errorf("failed to create data directory: %w", err)
 	}
 
-	dbPath := filepath.Join(dataDir, "alist-encrypt.db")
-	db, err := bolt.Open(dbPath, 0600, nil)
+	dbPath := filepath.Join(dataDir, dbFileName)
+	db, err := openWithRetry(dbPath, storeOpenTimeout)
+	if err != nil && !errors.Is(err, bolt.ErrTimeout) {
+		db, err = restoreFromBackup(dataDir, dbPath, err)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to open database: %w", err)
+		return openDegraded(dataDir, dbPath, err)
 	}
 
 	store := &Store{
@@ -52,13 +75,14 @@ func NewStore(dataDir string) (*Store, error) {
 		db.Close()
 		return nil, err
 	}
+	store.snapshotBackup(dataDir)
 
 	return store, nil
 }
 
 func (s *Store) initBuckets() error {
 	return s.db.Update(func(tx *bolt.Tx) error {
-		buckets := [][]byte{BucketUsers, BucketPasswd, BucketConfig, BucketFileInfo, BucketFileSize, BucketDirSync}
+		buckets := [][]byte{BucketUsers, BucketPasswd, BucketConfig, BucketFileInfo, BucketFileSize, BucketDirSync, BucketPlayback, BucketPrefs, BucketHashes, BucketAudit, BucketTokens, BucketGuests, BucketUploads, BucketNameKDF}
 		for _, bucket := range buckets {
 			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
 				return fmt.Errorf("failed to create bucket %s: %w", bucket, err)
@@ -70,7 +94,18 @@ func (s *Store) initBuckets() error {
 
 // Close closes the database
 func (s *Store) Close() error {
-	return s.db.Close()
+	err := s.db.Close()
+	if s.ephemeralPath != "" {
+		os.Remove(s.ephemeralPath)
+	}
+	return err
+}
+
+// DegradedReason explains why the store is running on a temporary copy, or
+// returns "" when the real database is in use. Writes made while degraded are
+// lost on restart.
+func (s *Store) DegradedReason() string {
+	return s.degraded
 }
 
 // Get retrieves a value from a bucket
@@ -198,3 +233,68 @@ func (s *Store) ListKeys(bucket []byte) ([]string, error) {
 	})
 	return keys, err
 }
+
+// ForEachReverse calls fn for the keys of a bucket from last to first, until
+// fn returns false. value is only valid during the call.
+func (s *Store) ForEachReverse(bucket []byte, fn func(key string, value []byte) bool) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if b == nil {
+			return fmt.Errorf("bucket not found: %s", bucket)
+		}
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if !fn(string(k), v) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// DeletePrefix removes every key of a bucket that starts with prefix and
+// returns how many were removed.
+func (s *Store) DeletePrefix(bucket []byte, prefix string) (int, error) {
+	removed := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if b == nil {
+			return fmt.Errorf("bucket not found: %s", bucket)
+		}
+		// Deleting while walking a cursor can skip keys; collect first.
+		var keys [][]byte
+		c := b.Cursor()
+		for k, _ := c.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		removed = len(keys)
+		return nil
+	})
+	return removed, err
+}
+
+// DeleteBefore removes every key of a bucket that sorts before key and
+// returns how many were removed.
+func (s *Store) DeleteBefore(bucket []byte, key string) (int, error) {
+	removed := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(bucket)
+		if b == nil {
+			return fmt.Errorf("bucket not found: %s", bucket)
+		}
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && string(k) < key; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
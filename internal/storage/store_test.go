@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewStoreRestoresCorruptDatabaseFromBackup(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	if err := store.Set(BucketConfig, "k", []byte("v1")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	store.Close()
+
+	// Startup snapshots are taken right after opening, so make a fresh one
+	// that contains the value written above.
+	os.RemoveAll(filepath.Join(dir, backupDirName))
+	store, err = NewStore(dir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	store.Close()
+	if len(listBackups(dir)) != 1 {
+		t.Fatalf("backups=%v, want one snapshot", listBackups(dir))
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, dbFileName), []byte("definitely not a bolt database"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err = NewStore(dir)
+	if err != nil {
+		t.Fatalf("NewStore after corruption: %v", err)
+	}
+	defer store.Close()
+	if reason := store.DegradedReason(); reason != "" {
+		t.Fatalf("restored store reported degraded: %s", reason)
+	}
+	got, err := store.Get(BucketConfig, "k")
+	if err != nil || string(got) != "v1" {
+		t.Fatalf("Get=%q err=%v, want v1 from backup", got, err)
+	}
+	matches, _ := filepath.Glob(filepath.Join(dir, dbFileName+".corrupt-*"))
+	if len(matches) != 1 {
+		t.Fatalf("corrupt copy not kept aside: %v", matches)
+	}
+}
+
+func TestNewStoreDegradesWhenDatabaseLocked(t *testing.T) {
+	original := storeOpenTimeout
+	storeOpenTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { storeOpenTimeout = original })
+
+	dir := t.TempDir()
+	holder, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer holder.Close()
+	if err := holder.Set(BucketUsers, "admin", []byte("{}")); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	store, err := NewStore(dir)
+	if err != nil {
+		t.Fatalf("NewStore while locked: %v", err)
+	}
+	if store.DegradedReason() == "" {
+		t.Fatal("expected degraded store while database is locked")
+	}
+	if got, _ := store.Get(BucketUsers, "admin"); string(got) != "{}" {
+		t.Fatalf("degraded store did not serve existing data: %q", got)
+	}
+	tmp := store.ephemeralPath
+	store.Close()
+	if _, err := os.Stat(tmp); !os.IsNotExist(err) {
+		t.Fatalf("temporary copy %s not removed", tmp)
+	}
+}
+
+func TestDeletePrefix(t *testing.T) {
+	store, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+	defer store.Close()
+	for _, k := range []string{"/a", "/a/1", "/a/2", "/a/b/3", "/ab", "/b"} {
+		if err := store.Set(BucketFileInfo, k, []byte("x")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := store.DeletePrefix(BucketFileInfo, "/a/")
+	if err != nil || n != 3 {
+		t.Fatalf("removed=%d err=%v, want 3", n, err)
+	}
+	keys, _ := store.ListKeys(BucketFileInfo)
+	if strings.Join(keys, " ") != "/a /ab /b" {
+		t.Fatalf("keys left: %v", keys)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"strings"
 	"time"
+
+	"github.com/alist-encrypt-go/internal/pathutil"
 )
 
 type contextKey string
@@ -14,6 +16,9 @@ type contextKey string
 const (
 	requestIDKey contextKey = "request_id"
 	pathTagKey   contextKey = "path_tag"
+	userKey      contextKey = "user"
+	loginUserKey contextKey = "login_user"
+	clientIPKey  contextKey = "client_ip"
 )
 
 // GenerateRequestID generates a unique request ID in format "req-XXXXXX"
@@ -31,11 +36,10 @@ func GenerateRequestID() string {
 func ExtractPathTag(urlPath string) string {
 	// Remove common prefixes
 	path := urlPath
-	for _, prefix := range []string{"/dav", "/d", "/p", "/api/fs"} {
-		if strings.HasPrefix(path, prefix) {
-			path = strings.TrimPrefix(path, prefix)
-			break
-		}
+	if displayPath, ok := pathutil.StripRoute(urlPath); ok {
+		path = displayPath.String()
+	} else if path == "/api/fs" || strings.HasPrefix(path, "/api/fs/") {
+		path = strings.TrimPrefix(path, "/api/fs")
 	}
 
 	// Split into parts and extract storage + first directory
@@ -77,6 +81,47 @@ func GetPathTag(ctx context.Context) string {
 	return ""
 }
 
+// WithUser records the downstream identity (e.g. from X-Forwarded-User)
+func WithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey, user)
+}
+
+// GetUser retrieves the downstream identity from context
+func GetUser(ctx context.Context) string {
+	if v := ctx.Value(userKey); v != nil {
+		return v.(string)
+	}
+	return ""
+}
+
+// WithLoginUser records the management UI account authenticated by the
+// /enc-api JWT.
+func WithLoginUser(ctx context.Context, username string) context.Context {
+	return context.WithValue(ctx, loginUserKey, username)
+}
+
+// GetLoginUser retrieves the management UI account from context
+func GetLoginUser(ctx context.Context) string {
+	if v := ctx.Value(loginUserKey); v != nil {
+		return v.(string)
+	}
+	return ""
+}
+
+// WithClientIP records the client address the request is attributed to,
+// after trusted forwarding headers.
+func WithClientIP(ctx context.Context, ip string) context.Context {
+	return context.WithValue(ctx, clientIPKey, ip)
+}
+
+// GetClientIP retrieves the client address from context
+func GetClientIP(ctx context.Context) string {
+	if v := ctx.Value(clientIPKey); v != nil {
+		return v.(string)
+	}
+	return ""
+}
+
 // LogPrefix returns a formatted log prefix: "[req-xxx] [path] [op]"
 func LogPrefix(ctx context.Context, operation string) string {
 	reqID := GetRequestID(ctx)
@@ -91,6 +136,7 @@ func LogPrefix(ctx context.Context, operation string) string {
 }
 
 // Log outputs formatted log: [timestamp] [req-xxx] [path_tag] [operation] message
+// and keeps it in the trace buffer under the request ID.
 func Log(ctx context.Context, operation, message string) {
 	reqID := GetRequestID(ctx)
 	pathTag := GetPathTag(ctx)
@@ -100,8 +146,9 @@ func Log(ctx context.Context, operation, message string) {
 	if pathTag == "" {
 		pathTag = "/"
 	}
-	ts := time.Now().Format("2006-01-02T15:04:05")
-	fmt.Printf("%s [%s] [%s] [%s] %s\n", ts, reqID, pathTag, operation, message)
+	now := time.Now()
+	currentRing().add(Entry{Time: now, RequestID: GetRequestID(ctx), PathTag: pathTag, Operation: operation, Message: message})
+	fmt.Printf("%s [%s] [%s] [%s] %s\n", now.Format("2006-01-02T15:04:05"), reqID, pathTag, operation, message)
 }
 
 // Logf outputs formatted log with printf-style formatting
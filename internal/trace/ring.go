@@ -0,0 +1,104 @@
+package trace
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultBufferSize is how many trace entries are kept in memory unless
+// log.trace_buffer says otherwise.
+const DefaultBufferSize = 5000
+
+// Entry is one Log call kept in the trace buffer.
+type Entry struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	PathTag   string    `json:"path_tag"`
+	Operation string    `json:"operation"`
+	Message   string    `json:"message"`
+}
+
+type ringSlot struct {
+	seq   uint64
+	entry Entry
+}
+
+// ring keeps the latest Log entries and indexes them by request ID, so the
+// whole trace of a recent request can be looked up after the fact.
+type ring struct {
+	mu    sync.Mutex
+	slots []ringSlot
+	next  uint64              // sequence number of the next entry, from 1
+	byID  map[string][]uint64 // request ID -> sequence numbers, oldest first
+}
+
+var (
+	bufferMu sync.RWMutex
+	buffer   = newRing(DefaultBufferSize)
+)
+
+func newRing(size int) *ring {
+	if size <= 0 {
+		return nil
+	}
+	return &ring{slots: make([]ringSlot, size), next: 1, byID: make(map[string][]uint64)}
+}
+
+// SetBufferSize replaces the trace buffer with one holding size entries,
+// dropping what was kept. size <= 0 turns the buffer off.
+func SetBufferSize(size int) {
+	r := newRing(size)
+	bufferMu.Lock()
+	buffer = r
+	bufferMu.Unlock()
+}
+
+func currentRing() *ring {
+	bufferMu.RLock()
+	defer bufferMu.RUnlock()
+	return buffer
+}
+
+func (r *ring) add(e Entry) {
+	if r == nil || e.RequestID == "" {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	slot := &r.slots[r.next%uint64(len(r.slots))]
+	if slot.seq != 0 {
+		// The overwritten entry is the oldest one of its request.
+		old := slot.entry.RequestID
+		if seqs := r.byID[old]; len(seqs) <= 1 {
+			delete(r.byID, old)
+		} else {
+			r.byID[old] = seqs[1:]
+		}
+	}
+	slot.seq = r.next
+	slot.entry = e
+	r.byID[e.RequestID] = append(r.byID[e.RequestID], r.next)
+	r.next++
+}
+
+func (r *ring) lookup(reqID string) []Entry {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seqs := r.byID[reqID]
+	entries := make([]Entry, 0, len(seqs))
+	for _, seq := range seqs {
+		if slot := r.slots[seq%uint64(len(r.slots))]; slot.seq == seq {
+			entries = append(entries, slot.entry)
+		}
+	}
+	return entries
+}
+
+// Lookup returns the buffered entries of request reqID, oldest first. Older
+// entries of a long request may already have been overwritten.
+func Lookup(reqID string) []Entry {
+	return currentRing().lookup(reqID)
+}
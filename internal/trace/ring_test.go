@@ -0,0 +1,49 @@
+package trace
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestRingKeepsLatestEntriesPerRequest(t *testing.T) {
+	r := newRing(4)
+	for i := 0; i < 3; i++ {
+		r.add(Entry{RequestID: "req-a", Message: fmt.Sprint("a", i)})
+	}
+	r.add(Entry{RequestID: "req-b", Message: "b0"})
+	r.add(Entry{RequestID: "req-b", Message: "b1"}) // overwrites a0
+	r.add(Entry{Message: "no request"})             // not kept
+
+	a := r.lookup("req-a")
+	if len(a) != 2 || a[0].Message != "a1" || a[1].Message != "a2" {
+		t.Fatalf("req-a=%+v", a)
+	}
+	if b := r.lookup("req-b"); len(b) != 2 || b[1].Message != "b1" {
+		t.Fatalf("req-b=%+v", b)
+	}
+	r.add(Entry{RequestID: "req-c"})
+	r.add(Entry{RequestID: "req-c"})
+	if a := r.lookup("req-a"); len(a) != 0 {
+		t.Fatalf("req-a should be gone, got %+v", a)
+	}
+	if _, ok := r.byID["req-a"]; ok {
+		t.Fatal("index kept an overwritten request")
+	}
+}
+
+func TestLogRecordsIntoBuffer(t *testing.T) {
+	SetBufferSize(10)
+	defer SetBufferSize(DefaultBufferSize)
+	ctx := WithPathTag(WithRequestID(context.Background(), "req-123456"), "local:/movies")
+	Logf(ctx, "get", "resolved %s", "a.mkv")
+	entries := Lookup("req-123456")
+	if len(entries) != 1 || entries[0].Operation != "get" || entries[0].Message != "resolved a.mkv" || entries[0].PathTag != "local:/movies" {
+		t.Fatalf("entries=%+v", entries)
+	}
+	SetBufferSize(-1)
+	Logf(ctx, "get", "dropped")
+	if entries := Lookup("req-123456"); len(entries) != 0 {
+		t.Fatalf("disabled buffer returned %+v", entries)
+	}
+}
@@ -0,0 +1,216 @@
+package update
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// maxBinarySize bounds the download so a bad asset cannot fill the disk.
+const maxBinarySize = 256 << 20
+
+// maxChecksumsSize bounds the SHA256SUMS download.
+const maxChecksumsSize = 64 << 10
+
+var (
+	// ErrApplyDisabled is returned when update.allow_apply is off.
+	ErrApplyDisabled = errors.New("binary update is disabled")
+	// ErrNoUpdate is returned when the running version is already current.
+	ErrNoUpdate = errors.New("no newer release available")
+	// ErrChecksumMismatch is returned when the downloaded binary does not
+	// match the release's SHA256SUMS.
+	ErrChecksumMismatch = errors.New("release asset checksum mismatch")
+)
+
+// Apply downloads the release binary for this platform and swaps it in place
+// of the running executable, keeping the previous binary as <exe>.old. The
+// binary must match its entry in the release's SHA256SUMS; releases without
+// one are not applied. The caller is responsible for restarting into the new
+// binary.
+func (c *Checker) Apply(ctx context.Context) (Status, error) {
+	if !c.allowApply {
+		return c.Status(), ErrApplyDisabled
+	}
+	c.applyMu.Lock()
+	defer c.applyMu.Unlock()
+
+	status, err := c.Check(ctx)
+	if err != nil {
+		return status, err
+	}
+	if !status.Available {
+		return status, ErrNoUpdate
+	}
+	c.mu.RLock()
+	release := c.release
+	c.mu.RUnlock()
+	asset, ok := release.assetFor(runtime.GOOS, runtime.GOARCH)
+	if !ok {
+		return status, fmt.Errorf("release %s has no asset for %s/%s", release.TagName, runtime.GOOS, runtime.GOARCH)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return status, fmt.Errorf("locate executable: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = resolved
+	}
+	if err := c.downloadVerified(ctx, release, asset, exe+".new"); err != nil {
+		os.Remove(exe + ".new")
+		return status, err
+	}
+	if err := swapBinary(exe, exe+".new"); err != nil {
+		os.Remove(exe + ".new")
+		return status, err
+	}
+	return status, nil
+}
+
+// downloadVerified downloads asset to dest and checks it against the
+// release's SHA256SUMS.
+func (c *Checker) downloadVerified(ctx context.Context, release *Release, asset Asset, dest string) error {
+	sumsAsset, ok := release.checksumsAsset()
+	if !ok {
+		return fmt.Errorf("release %s publishes no %s; refusing to update", release.TagName, checksumsName)
+	}
+	want, err := c.fetchChecksum(ctx, sumsAsset, asset.Name)
+	if err != nil {
+		return err
+	}
+	got, err := c.download(ctx, asset, dest)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("%w: %s has sha256 %s, %s lists %s", ErrChecksumMismatch, asset.Name, got, checksumsName, want)
+	}
+	return nil
+}
+
+// fetchChecksum returns the hex SHA-256 that the checksums file lists for
+// name.
+func (c *Checker) fetchChecksum(ctx context.Context, sums Asset, name string) (string, error) {
+	resp, err := c.get(ctx, sums)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	scanner := bufio.NewScanner(io.LimitReader(resp.Body, maxChecksumsSize))
+	for scanner.Scan() {
+		// sha256sum format: "<hex>  <name>", or "<hex> *<name>" in binary mode.
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || strings.TrimPrefix(fields[1], "*") != name {
+			continue
+		}
+		sum := strings.ToLower(fields[0])
+		if decoded, err := hex.DecodeString(sum); err != nil || len(decoded) != sha256.Size {
+			return "", fmt.Errorf("%s: malformed checksum for %s", checksumsName, name)
+		}
+		return sum, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return "", fmt.Errorf("read %s: %w", checksumsName, err)
+	}
+	return "", fmt.Errorf("%s has no entry for %s", checksumsName, name)
+}
+
+func (c *Checker) get(ctx context.Context, asset Asset) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, asset.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "alist-encrypt-go/"+c.current)
+	// The default checker client has a short timeout meant for the API.
+	client := &http.Client{Transport: c.client.Transport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("download %s: %w", asset.Name, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("download %s: status %d", asset.Name, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// download writes asset to dest and returns its hex SHA-256.
+func (c *Checker) download(ctx context.Context, asset Asset, dest string) (string, error) {
+	resp, err := c.get(ctx, asset)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0755)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, hash), io.LimitReader(resp.Body, maxBinarySize+1))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("write %s: %w", dest, err)
+	}
+	if n > maxBinarySize {
+		return "", fmt.Errorf("asset %s exceeds %d bytes", asset.Name, maxBinarySize)
+	}
+	if asset.Size > 0 && n != asset.Size {
+		return "", fmt.Errorf("asset %s size mismatch: got %d want %d", asset.Name, n, asset.Size)
+	}
+	if err := verifyExecutable(dest); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// verifyExecutable rejects downloads that are not a native executable, e.g.
+// an HTML error page served with status 200.
+func verifyExecutable(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return fmt.Errorf("read %s: %w", path, err)
+	}
+	switch {
+	case bytes.Equal(magic, []byte{0x7f, 'E', 'L', 'F'}):
+	case bytes.HasPrefix(magic, []byte("MZ")):
+	case bytes.Equal(magic, []byte{0xcf, 0xfa, 0xed, 0xfe}), bytes.Equal(magic, []byte{0xce, 0xfa, 0xed, 0xfe}):
+	default:
+		return fmt.Errorf("%s is not an executable", path)
+	}
+	return nil
+}
+
+// swapBinary moves exe aside to exe.old and puts next in its place. Renaming
+// the running binary works on Windows too, where overwriting it does not.
+func swapBinary(exe, next string) error {
+	old := exe + ".old"
+	os.Remove(old)
+	if err := os.Rename(exe, old); err != nil {
+		return fmt.Errorf("backup current binary: %w", err)
+	}
+	if err := os.Rename(next, exe); err != nil {
+		if restoreErr := os.Rename(old, exe); restoreErr != nil {
+			return fmt.Errorf("install new binary: %v (restore failed: %v)", err, restoreErr)
+		}
+		return fmt.Errorf("install new binary: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+// Package update checks GitHub releases for newer builds and can replace the
+// running binary with the release asset for the current platform.
+package update
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultRepo is the GitHub repository that publishes release binaries.
+	DefaultRepo = "qingwo1991-debug/alist-encrypt-go"
+
+	defaultAPIBase = "https://api.github.com"
+	binaryPrefix   = "alist-encrypt-go-"
+	// checksumsName is the sha256sum listing published with each release.
+	checksumsName = "SHA256SUMS"
+)
+
+// Release is the subset of the GitHub release payload the checker needs.
+type Release struct {
+	TagName string  `json:"tag_name"`
+	HTMLURL string  `json:"html_url"`
+	Assets  []Asset `json:"assets"`
+}
+
+// Asset is a downloadable release file.
+type Asset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+	Size int64  `json:"size"`
+}
+
+// Status is the latest check result surfaced to the management UI.
+type Status struct {
+	Current   string    `json:"current"`
+	Latest    string    `json:"latest,omitempty"`
+	Available bool      `json:"available"`
+	URL       string    `json:"url,omitempty"`
+	Asset     string    `json:"asset,omitempty"`
+	CanApply  bool      `json:"can_apply"`
+	CheckedAt time.Time `json:"checked_at,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// Checker periodically compares the running version with the latest release.
+type Checker struct {
+	repo       string
+	current    string
+	interval   time.Duration
+	allowApply bool
+	apiBase    string
+	client     *http.Client
+
+	mu      sync.RWMutex
+	status  Status
+	release *Release
+	applyMu sync.Mutex
+}
+
+// NewChecker creates a checker for repo (owner/name). An empty repo uses
+// DefaultRepo and a non-positive interval defaults to 24h.
+func NewChecker(repo, current string, interval time.Duration, allowApply bool) *Checker {
+	repo = strings.Trim(strings.TrimSpace(repo), "/")
+	if repo == "" {
+		repo = DefaultRepo
+	}
+	if interval <= 0 {
+		interval = 24 * time.Hour
+	}
+	return &Checker{
+		repo:       repo,
+		current:    current,
+		interval:   interval,
+		allowApply: allowApply,
+		apiBase:    defaultAPIBase,
+		client:     &http.Client{Timeout: 30 * time.Second},
+		status:     Status{Current: current},
+	}
+}
+
+// Start runs a check immediately and then every interval until ctx ends.
+func (c *Checker) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			if _, err := c.Check(ctx); err != nil && ctx.Err() == nil {
+				log.Warn().Err(err).Str("repo", c.repo).Msg("Update check failed")
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Status returns the most recent check result.
+func (c *Checker) Status() Status {
+	if c == nil {
+		return Status{}
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.status
+}
+
+// Check queries the latest release and updates the cached status.
+func (c *Checker) Check(ctx context.Context) (Status, error) {
+	release, err := c.fetchLatest(ctx)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.status.CheckedAt = time.Now()
+	if err != nil {
+		c.status.Error = err.Error()
+		return c.status, err
+	}
+	asset, _ := release.assetFor(runtime.GOOS, runtime.GOARCH)
+	c.release = release
+	c.status = Status{
+		Current:   c.current,
+		Latest:    strings.TrimPrefix(release.TagName, "v"),
+		Available: CompareVersions(release.TagName, c.current) > 0,
+		URL:       release.HTMLURL,
+		Asset:     asset.Name,
+		CheckedAt: c.status.CheckedAt,
+	}
+	_, hasSums := release.checksumsAsset()
+	c.status.CanApply = c.allowApply && c.status.Available && asset.URL != "" && hasSums
+	if c.status.Available {
+		log.Info().Str("current", c.current).Str("latest", c.status.Latest).Msg("New release available")
+	}
+	return c.status, nil
+}
+
+func (c *Checker) fetchLatest(ctx context.Context) (*Release, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/repos/%s/releases/latest", c.apiBase, c.repo), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("User-Agent", "alist-encrypt-go/"+c.current)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("github api status %d", resp.StatusCode)
+	}
+	var release Release
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return nil, fmt.Errorf("decode release: %w", err)
+	}
+	if release.TagName == "" {
+		return nil, errors.New("release has no tag")
+	}
+	return &release, nil
+}
+
+// assetFor picks the server binary published for goos/goarch.
+func (r *Release) assetFor(goos, goarch string) (Asset, bool) {
+	arch := goarch
+	if goarch == "arm" {
+		arch = "armv7"
+	}
+	want := binaryPrefix + goos + "-" + arch
+	if goos == "windows" {
+		want += ".exe"
+	}
+	for _, asset := range r.Assets {
+		if asset.Name == want {
+			return asset, true
+		}
+	}
+	return Asset{}, false
+}
+
+// checksumsAsset returns the release's SHA256SUMS file.
+func (r *Release) checksumsAsset() (Asset, bool) {
+	for _, asset := range r.Assets {
+		if asset.Name == checksumsName {
+			return asset, asset.URL != ""
+		}
+	}
+	return Asset{}, false
+}
+
+// CompareVersions compares dotted numeric versions, ignoring a leading "v"
+// and any pre-release/build suffix. It returns -1, 0 or 1.
+func CompareVersions(a, b string) int {
+	pa, pb := versionParts(a), versionParts(b)
+	for i := 0; i < len(pa) || i < len(pb); i++ {
+		var x, y int
+		if i < len(pa) {
+			x = pa[i]
+		}
+		if i < len(pb) {
+			y = pb[i]
+		}
+		switch {
+		case x > y:
+			return 1
+		case x < y:
+			return -1
+		}
+	}
+	return 0
+}
+
+func versionParts(v string) []int {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	var parts []int
+	for _, field := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			break
+		}
+		parts = append(parts, n)
+	}
+	return parts
+}
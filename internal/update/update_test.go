@@ -0,0 +1,140 @@
+package update
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"v1.0.1", "1.0.0", 1},
+		{"2026.10.16.12", "2026.10.16.9", 1},
+		{"1.0", "1.0.0", 0},
+		{"v1.0.0-rc1", "1.0.0", 0},
+		{"0.9.9", "1.0.0", -1},
+	}
+	for _, tc := range cases {
+		if got := CompareVersions(tc.a, tc.b); got != tc.want {
+			t.Errorf("CompareVersions(%q,%q)=%d want %d", tc.a, tc.b, got, tc.want)
+		}
+	}
+}
+
+func TestCheckReportsAvailableRelease(t *testing.T) {
+	assetName := binaryPrefix + runtime.GOOS + "-" + runtime.GOARCH
+	if runtime.GOARCH == "arm" {
+		assetName = binaryPrefix + runtime.GOOS + "-armv7"
+	}
+	if runtime.GOOS == "windows" {
+		assetName += ".exe"
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/owner/repo/releases/latest" {
+			http.NotFound(w, r)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(Release{
+			TagName: "v1.2.0",
+			HTMLURL: "https://example.invalid/release",
+			Assets: []Asset{
+				{Name: assetName, URL: "https://example.invalid/bin"},
+				{Name: checksumsName, URL: "https://example.invalid/SHA256SUMS"},
+			},
+		})
+	}))
+	defer srv.Close()
+
+	c := NewChecker("owner/repo", "1.1.9", 0, true)
+	c.apiBase = srv.URL
+	status, err := c.Check(context.Background())
+	if err != nil {
+		t.Fatalf("check: %v", err)
+	}
+	if !status.Available || status.Latest != "1.2.0" || status.Asset != assetName || !status.CanApply {
+		t.Fatalf("status=%+v", status)
+	}
+
+	c.current = "1.2.0"
+	if status, _ := c.Check(context.Background()); status.Available || status.CanApply {
+		t.Fatalf("expected up to date, got %+v", status)
+	}
+}
+
+func TestSwapBinaryKeepsBackup(t *testing.T) {
+	dir := t.TempDir()
+	exe := filepath.Join(dir, "server")
+	if err := os.WriteFile(exe, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(exe+".new", []byte("new"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := swapBinary(exe, exe+".new"); err != nil {
+		t.Fatalf("swap: %v", err)
+	}
+	if data, _ := os.ReadFile(exe); string(data) != "new" {
+		t.Fatalf("exe=%q", data)
+	}
+	if data, _ := os.ReadFile(exe + ".old"); string(data) != "old" {
+		t.Fatalf("backup=%q", data)
+	}
+}
+
+func TestVerifyExecutableRejectsHTML(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bin")
+	if err := os.WriteFile(path, []byte("<html>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := verifyExecutable(path); err == nil {
+		t.Fatal("expected html payload to be rejected")
+	}
+}
+
+func TestDownloadVerifiedRejectsTamperedAsset(t *testing.T) {
+	genuine := append([]byte{0x7f, 'E', 'L', 'F'}, []byte("genuine build")...)
+	sum := sha256.Sum256(genuine)
+	served := genuine
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/bin":
+			_, _ = w.Write(served)
+		case "/SHA256SUMS":
+			_, _ = w.Write([]byte(strings.Repeat("0", 64) + "  other-file\n" + hex.EncodeToString(sum[:]) + " *alist-encrypt-go-linux-amd64\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewChecker("owner/repo", "1.0.0", 0, true)
+	asset := Asset{Name: "alist-encrypt-go-linux-amd64", URL: srv.URL + "/bin"}
+	release := &Release{TagName: "v1.1.0", Assets: []Asset{asset, {Name: checksumsName, URL: srv.URL + "/SHA256SUMS"}}}
+	dest := filepath.Join(t.TempDir(), "server.new")
+
+	if err := c.downloadVerified(context.Background(), release, asset, dest); err != nil {
+		t.Fatalf("genuine asset: %v", err)
+	}
+
+	served = append([]byte{0x7f, 'E', 'L', 'F'}, []byte("tampered build")...)
+	if err := c.downloadVerified(context.Background(), release, asset, dest); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("tampered asset err=%v", err)
+	}
+
+	unsigned := &Release{TagName: "v1.1.0", Assets: []Asset{asset}}
+	if err := c.downloadVerified(context.Background(), unsigned, asset, dest); err == nil {
+		t.Fatal("release without SHA256SUMS was accepted")
+	}
+}
@@ -0,0 +1,193 @@
+package workers
+
+import (
+	"context"
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BudgetLimits caps the load sent to one Alist storage. Zero disables a
+// limit.
+type BudgetLimits struct {
+	MaxStreams     int
+	RequestsPerMin int
+}
+
+// Budget enforces the limits of one storage: a stream pool and a token
+// bucket for requests. A nil Budget never limits.
+type Budget struct {
+	key     string
+	limits  BudgetLimits
+	streams *Pool
+
+	mu       sync.Mutex
+	tokens   float64
+	burst    float64
+	perToken time.Duration
+	last     time.Time
+	now      func() time.Time
+
+	requests uint64
+	delayed  uint64
+	denied   uint64
+}
+
+var (
+	budgetMu sync.RWMutex
+	budgets  = map[string]*Budget{}
+)
+
+// StorageKey returns the top-level path segment ("/aliyun") that budgets
+// are keyed by.
+func StorageKey(p string) string {
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if i := strings.IndexByte(p, '/'); i >= 0 {
+		p = p[:i]
+	}
+	return "/" + p
+}
+
+// SetBudgets replaces the per-storage budgets. Budgets whose limits did not
+// change are kept, so streams in flight keep counting against them.
+func SetBudgets(limits map[string]BudgetLimits) {
+	budgetMu.Lock()
+	defer budgetMu.Unlock()
+	next := make(map[string]*Budget, len(limits))
+	for key, l := range limits {
+		key = StorageKey(key)
+		if l.MaxStreams <= 0 && l.RequestsPerMin <= 0 {
+			continue
+		}
+		if b, ok := budgets[key]; ok && b.limits == l {
+			next[key] = b
+			continue
+		}
+		next[key] = newBudget(key, l)
+	}
+	budgets = next
+}
+
+func newBudget(key string, l BudgetLimits) *Budget {
+	b := &Budget{key: key, limits: l, now: time.Now}
+	if l.MaxStreams > 0 {
+		b.streams = New("storage:"+key, l.MaxStreams)
+	}
+	if l.RequestsPerMin > 0 {
+		// Allow five seconds' worth of requests at once so a directory
+		// listing with a few lookups is not serialized, but never a whole
+		// minute's worth in one burst.
+		b.perToken = time.Minute / time.Duration(l.RequestsPerMin)
+		b.burst = math.Max(1, math.Ceil(float64(l.RequestsPerMin)/12))
+		b.tokens = b.burst
+		b.last = b.now()
+	}
+	return b
+}
+
+// HasBudgets reports whether any storage budget is configured.
+func HasBudgets() bool {
+	budgetMu.RLock()
+	defer budgetMu.RUnlock()
+	return len(budgets) > 0
+}
+
+// BudgetFor returns the budget covering p, or nil when its storage has none.
+func BudgetFor(p string) *Budget {
+	budgetMu.RLock()
+	defer budgetMu.RUnlock()
+	if len(budgets) == 0 {
+		return nil
+	}
+	return budgets[StorageKey(p)]
+}
+
+// WaitRequest takes one request token, blocking until one is available or
+// ctx is done.
+func (b *Budget) WaitRequest(ctx context.Context) error {
+	if b == nil || b.perToken <= 0 {
+		return ctx.Err()
+	}
+	atomic.AddUint64(&b.requests, 1)
+	b.mu.Lock()
+	now := b.now()
+	b.tokens = math.Min(b.burst, b.tokens+float64(now.Sub(b.last))/float64(b.perToken))
+	b.last = now
+	b.tokens--
+	wait := time.Duration(0)
+	if b.tokens < 0 {
+		wait = time.Duration(-b.tokens * float64(b.perToken))
+	}
+	b.mu.Unlock()
+	if wait == 0 {
+		return nil
+	}
+
+	atomic.AddUint64(&b.delayed, 1)
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		// Hand the reserved token back to the next caller.
+		b.mu.Lock()
+		b.tokens++
+		b.mu.Unlock()
+		atomic.AddUint64(&b.denied, 1)
+		return ctx.Err()
+	}
+}
+
+// AcquireStream takes a stream slot and returns its release function.
+func (b *Budget) AcquireStream(ctx context.Context) (func(), error) {
+	if b == nil || b.streams == nil {
+		return func() {}, ctx.Err()
+	}
+	if err := b.streams.Acquire(ctx); err != nil {
+		atomic.AddUint64(&b.denied, 1)
+		return nil, err
+	}
+	var released atomic.Bool
+	return func() {
+		if !released.Swap(true) {
+			b.streams.Release()
+		}
+	}, nil
+}
+
+// Stats returns the budget's limits and usage.
+func (b *Budget) Stats() map[string]interface{} {
+	stats := map[string]interface{}{
+		"max_streams":      b.limits.MaxStreams,
+		"requests_per_min": b.limits.RequestsPerMin,
+		"requests":         atomic.LoadUint64(&b.requests),
+		"delayed":          atomic.LoadUint64(&b.delayed),
+		"denied":           atomic.LoadUint64(&b.denied),
+	}
+	if b.streams != nil {
+		stats["active_streams"] = b.streams.Active()
+		stats["waiting_streams"] = b.streams.Stats()["waiting"]
+	}
+	return stats
+}
+
+// BudgetSnapshot returns Stats for every storage budget, keyed by path.
+func BudgetSnapshot() map[string]interface{} {
+	budgetMu.RLock()
+	list := make([]*Budget, 0, len(budgets))
+	for _, b := range budgets {
+		list = append(list, b)
+	}
+	budgetMu.RUnlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].key < list[j].key })
+	out := make(map[string]interface{}, len(list))
+	for _, b := range list {
+		out[b.key] = b.Stats()
+	}
+	return out
+}
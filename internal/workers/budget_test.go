@@ -0,0 +1,65 @@
+package workers
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestStorageBudgets(t *testing.T) {
+	SetBudgets(map[string]BudgetLimits{
+		"/aliyun/movies": {RequestsPerMin: 60},
+		"/115":           {MaxStreams: 1},
+		"/local":         {},
+	})
+	t.Cleanup(func() { SetBudgets(nil) })
+
+	if StorageKey("aliyun/movies/a.mkv") != "/aliyun" || BudgetFor("/local/x") != nil || BudgetFor("/other") != nil {
+		t.Fatal("budgets must be keyed by the top-level path")
+	}
+
+	// 60/min allows a burst of 5, then one request per second.
+	rate := BudgetFor("/aliyun/tv/b.mkv")
+	for i := 0; i < 5; i++ {
+		if err := rate.WaitRequest(context.Background()); err != nil {
+			t.Fatalf("burst request %d: %v", i, err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if rate.WaitRequest(ctx) == nil {
+		t.Fatal("sixth request should have to wait")
+	}
+	if stats := rate.Stats(); stats["denied"].(uint64) != 1 || stats["delayed"].(uint64) != 1 {
+		t.Fatalf("unexpected stats: %v", stats)
+	}
+
+	streams := BudgetFor("/115/c.mkv")
+	release, err := streams.AcquireStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel2()
+	if _, err := streams.AcquireStream(ctx2); err == nil {
+		t.Fatal("second stream should be refused while the first is open")
+	}
+	release()
+	release()
+	if r, err := streams.AcquireStream(context.Background()); err != nil {
+		t.Fatal(err)
+	} else {
+		r()
+	}
+
+	// Unchanged limits keep the same budget across reconfiguration.
+	SetBudgets(map[string]BudgetLimits{"/115": {MaxStreams: 1}})
+	if BudgetFor("/115") != streams || BudgetFor("/aliyun") != nil {
+		t.Fatal("reconfiguration should keep unchanged budgets and drop removed ones")
+	}
+
+	var none *Budget
+	if none.WaitRequest(context.Background()) != nil {
+		t.Fatal("nil budget must not limit")
+	}
+}
@@ -0,0 +1,150 @@
+package workers
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Pool bounds how many goroutines of one kind run at once and counts how it
+// is used. Shared pools are registered by name and reported by Snapshot.
+type Pool struct {
+	name     string
+	sem      chan struct{}
+	active   int64
+	waiting  int64
+	acquired uint64
+	rejected uint64
+}
+
+var (
+	registryMu sync.Mutex
+	registry   = map[string]*Pool{}
+)
+
+// New creates an unregistered pool of size slots (at least one).
+func New(name string, size int) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	return &Pool{name: name, sem: make(chan struct{}, size)}
+}
+
+// Shared returns the process-wide pool for name, creating it with size on
+// first use. Later calls return the same pool regardless of size, so every
+// caller of a given kind competes for the same slots.
+func Shared(name string, size int) *Pool {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if p, ok := registry[name]; ok {
+		return p
+	}
+	p := New(name, size)
+	registry[name] = p
+	return p
+}
+
+// Register publishes p in Snapshot under its name, replacing any pool
+// registered earlier with that name. It is meant for pools owned by a
+// singleton such as the stream proxy.
+func Register(p *Pool) *Pool {
+	registryMu.Lock()
+	registry[p.name] = p
+	registryMu.Unlock()
+	return p
+}
+
+// Name returns the pool name.
+func (p *Pool) Name() string { return p.name }
+
+// Size returns the number of slots.
+func (p *Pool) Size() int { return cap(p.sem) }
+
+// Active returns the number of slots currently held.
+func (p *Pool) Active() int { return int(atomic.LoadInt64(&p.active)) }
+
+// Acquire blocks until a slot is free or ctx is done.
+func (p *Pool) Acquire(ctx context.Context) error {
+	select {
+	case p.sem <- struct{}{}:
+		p.onAcquire()
+		return nil
+	default:
+	}
+	atomic.AddInt64(&p.waiting, 1)
+	defer atomic.AddInt64(&p.waiting, -1)
+	select {
+	case p.sem <- struct{}{}:
+		p.onAcquire()
+		return nil
+	case <-ctx.Done():
+		atomic.AddUint64(&p.rejected, 1)
+		return ctx.Err()
+	}
+}
+
+// AcquireTimeout waits up to d for a slot. It returns false on ctx
+// cancellation or timeout.
+func (p *Pool) AcquireTimeout(ctx context.Context, d time.Duration) bool {
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	return p.Acquire(ctx) == nil
+}
+
+// TryAcquire takes a slot only if one is free right now.
+func (p *Pool) TryAcquire() bool {
+	select {
+	case p.sem <- struct{}{}:
+		p.onAcquire()
+		return true
+	default:
+		atomic.AddUint64(&p.rejected, 1)
+		return false
+	}
+}
+
+// Release frees a slot taken by Acquire, AcquireTimeout or TryAcquire.
+func (p *Pool) Release() {
+	<-p.sem
+	atomic.AddInt64(&p.active, -1)
+}
+
+func (p *Pool) onAcquire() {
+	atomic.AddInt64(&p.active, 1)
+	atomic.AddUint64(&p.acquired, 1)
+}
+
+// Stats returns the pool's current usage.
+func (p *Pool) Stats() map[string]interface{} {
+	active := atomic.LoadInt64(&p.active)
+	utilization := 0.0
+	if size := cap(p.sem); size > 0 {
+		utilization = float64(active) / float64(size)
+	}
+	return map[string]interface{}{
+		"size":        cap(p.sem),
+		"active":      active,
+		"waiting":     atomic.LoadInt64(&p.waiting),
+		"acquired":    atomic.LoadUint64(&p.acquired),
+		"rejected":    atomic.LoadUint64(&p.rejected),
+		"utilization": utilization,
+	}
+}
+
+// Snapshot returns Stats for every shared pool, keyed by name.
+func Snapshot() map[string]interface{} {
+	registryMu.Lock()
+	pools := make([]*Pool, 0, len(registry))
+	for _, p := range registry {
+		pools = append(pools, p)
+	}
+	registryMu.Unlock()
+	sort.Slice(pools, func(i, j int) bool { return pools[i].name < pools[j].name })
+	out := make(map[string]interface{}, len(pools))
+	for _, p := range pools {
+		out[p.name] = p.Stats()
+	}
+	return out
+}
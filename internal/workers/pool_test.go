@@ -0,0 +1,42 @@
+package workers
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPoolLimitsConcurrency(t *testing.T) {
+	p := New("test", 2)
+	if !p.TryAcquire() || !p.TryAcquire() {
+		t.Fatal("expected two free slots")
+	}
+	if p.TryAcquire() {
+		t.Fatal("third acquire should be rejected")
+	}
+	if p.AcquireTimeout(context.Background(), 10*time.Millisecond) {
+		t.Fatal("acquire should time out while pool is full")
+	}
+	p.Release()
+	if err := p.Acquire(context.Background()); err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+	stats := p.Stats()
+	if stats["active"].(int64) != 2 || stats["rejected"].(uint64) != 2 || stats["acquired"].(uint64) != 3 {
+		t.Fatalf("unexpected stats: %v", stats)
+	}
+	if stats["utilization"].(float64) != 1 {
+		t.Fatalf("utilization = %v, want 1", stats["utilization"])
+	}
+}
+
+func TestSharedPoolIsRegisteredOnce(t *testing.T) {
+	a := Shared("test_shared", 3)
+	b := Shared("test_shared", 8)
+	if a != b || b.Size() != 3 {
+		t.Fatalf("Shared should return the first pool, got size %d", b.Size())
+	}
+	if _, ok := Snapshot()["test_shared"]; !ok {
+		t.Fatal("shared pool missing from snapshot")
+	}
+}